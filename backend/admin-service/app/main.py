@@ -4,7 +4,7 @@ from fastapi import FastAPI
 
 from app.core.database import engine
 from app.models import Base, Tenant, User  # noqa: F401 — enregistre les modèles sur Base.metadata
-from app.routes import health, mail_accounts, security, stats, tenants, users
+from app.routes import groups, health, mail_accounts, quotas, security, stats, tenants, users
 
 Base.metadata.create_all(bind=engine)
 
@@ -19,6 +19,8 @@ app.include_router(health.router)
 app.include_router(tenants.router)
 app.include_router(users.router)
 app.include_router(mail_accounts.router)
+app.include_router(quotas.router)
+app.include_router(groups.router)
 app.include_router(stats.router)
 app.include_router(security.router)
 
@@ -0,0 +1,111 @@
+"""Groupes d'utilisateurs par tenant (tables user_groups / user_group_members).
+
+Utilisés par drive-service comme destinataires de partages internes : un rôle donné
+à un groupe s'applique à tous ses membres (cf. migration 49-drive-acl-shares.sql).
+"""
+
+from typing import List
+
+from fastapi import APIRouter, Depends, HTTPException
+from sqlalchemy import text
+from sqlalchemy.exc import IntegrityError
+from sqlalchemy.orm import Session
+
+from app.core.database import get_db
+from app.models import Tenant, User
+from app.schemas import UserGroupCreate, UserGroupMemberAdd, UserGroupResponse
+
+router = APIRouter(prefix="/admin", tags=["groups"])
+
+
+def _group_response(db: Session, row) -> UserGroupResponse:
+    members = db.execute(
+        text("SELECT user_id FROM user_group_members WHERE group_id = :group_id ORDER BY user_id"),
+        {"group_id": row["id"]},
+    ).scalars().all()
+    return UserGroupResponse(
+        id=row["id"],
+        tenant_id=row["tenant_id"],
+        name=row["name"],
+        member_ids=list(members),
+        created_at=row["created_at"],
+    )
+
+
+def _get_group(db: Session, group_id: int):
+    row = db.execute(
+        text("SELECT id, tenant_id, name, created_at FROM user_groups WHERE id = :group_id"),
+        {"group_id": group_id},
+    ).mappings().first()
+    if not row:
+        raise HTTPException(status_code=404, detail="Group not found")
+    return row
+
+
+@router.get("/tenants/{tenant_id}/groups", response_model=List[UserGroupResponse])
+async def list_groups(tenant_id: int, db: Session = Depends(get_db)):
+    if not db.query(Tenant).filter(Tenant.id == tenant_id).first():
+        raise HTTPException(status_code=404, detail="Tenant not found")
+    rows = db.execute(
+        text("SELECT id, tenant_id, name, created_at FROM user_groups WHERE tenant_id = :tenant_id ORDER BY name"),
+        {"tenant_id": tenant_id},
+    ).mappings().all()
+    return [_group_response(db, row) for row in rows]
+
+
+@router.post("/tenants/{tenant_id}/groups", response_model=UserGroupResponse, status_code=201)
+async def create_group(tenant_id: int, payload: UserGroupCreate, db: Session = Depends(get_db)):
+    if not db.query(Tenant).filter(Tenant.id == tenant_id).first():
+        raise HTTPException(status_code=404, detail="Tenant not found")
+    try:
+        row = db.execute(
+            text(
+                """
+                INSERT INTO user_groups (tenant_id, name) VALUES (:tenant_id, :name)
+                RETURNING id, tenant_id, name, created_at
+                """
+            ),
+            {"tenant_id": tenant_id, "name": payload.name.strip()},
+        ).mappings().one()
+        db.commit()
+    except IntegrityError:
+        db.rollback()
+        raise HTTPException(status_code=409, detail="Group name already exists")
+    return _group_response(db, row)
+
+
+@router.delete("/groups/{group_id}", status_code=204)
+async def delete_group(group_id: int, db: Session = Depends(get_db)):
+    _get_group(db, group_id)
+    db.execute(text("DELETE FROM user_groups WHERE id = :group_id"), {"group_id": group_id})
+    db.commit()
+
+
+@router.post("/groups/{group_id}/members", response_model=UserGroupResponse)
+async def add_group_member(group_id: int, payload: UserGroupMemberAdd, db: Session = Depends(get_db)):
+    group = _get_group(db, group_id)
+    user = db.query(User).filter(User.id == payload.user_id).first()
+    if not user or user.tenant_id != group["tenant_id"]:
+        raise HTTPException(status_code=404, detail="User not found in tenant")
+    db.execute(
+        text(
+            """
+            INSERT INTO user_group_members (group_id, user_id) VALUES (:group_id, :user_id)
+            ON CONFLICT DO NOTHING
+            """
+        ),
+        {"group_id": group_id, "user_id": payload.user_id},
+    )
+    db.commit()
+    return _group_response(db, group)
+
+
+@router.delete("/groups/{group_id}/members/{user_id}", response_model=UserGroupResponse)
+async def remove_group_member(group_id: int, user_id: int, db: Session = Depends(get_db)):
+    group = _get_group(db, group_id)
+    db.execute(
+        text("DELETE FROM user_group_members WHERE group_id = :group_id AND user_id = :user_id"),
+        {"group_id": group_id, "user_id": user_id},
+    )
+    db.commit()
+    return _group_response(db, group)
@@ -0,0 +1,164 @@
+"""Quotas de stockage Drive/Photos (table storage_quotas) par tenant et par utilisateur.
+
+L'application du quota est faite par drive-service à chaque écriture ; ici on ne fait
+que lire / poser les limites et exposer l'usage courant (corbeille incluse). Sans quota
+explicite, la limite affichée est celle que drive-service applique réellement : ses défauts
+d'environnement DRIVE_DEFAULT_USER_QUOTA_BYTES / DRIVE_DEFAULT_TENANT_QUOTA_BYTES.
+"""
+
+import os
+from typing import Optional
+
+from fastapi import APIRouter, Depends, HTTPException
+from sqlalchemy import text
+from sqlalchemy.orm import Session
+
+from app.core.database import get_db
+from app.models import Tenant, User
+from app.schemas import StorageQuotaResponse, StorageQuotaUpdate
+
+router = APIRouter(prefix="/admin", tags=["quotas"])
+
+# Alignés sur drive-service (storage_quota.go).
+QUOTA_WARN_PERCENT = 80
+QUOTA_CRITICAL_PERCENT = 95
+
+DEFAULT_QUOTA_ENV = {
+    "user": "DRIVE_DEFAULT_USER_QUOTA_BYTES",
+    "tenant": "DRIVE_DEFAULT_TENANT_QUOTA_BYTES",
+}
+
+
+def default_quota_bytes(scope: str) -> Optional[int]:
+    """Défaut d'environnement du scope, lu comme quotaBytesFromEnv (vide / invalide / 0 = illimité)."""
+    raw = os.getenv(DEFAULT_QUOTA_ENV[scope], "").strip()
+    try:
+        value = int(raw)
+    except ValueError:
+        return None
+    return value if value > 0 else None
+
+
+def quota_level(used: int, max_bytes: Optional[int]) -> str:
+    if not max_bytes:
+        return "unlimited"
+    if used > max_bytes:
+        return "exceeded"
+    if used * 100 >= max_bytes * QUOTA_CRITICAL_PERCENT:
+        return "critical"
+    if used * 100 >= max_bytes * QUOTA_WARN_PERCENT:
+        return "warning"
+    return "ok"
+
+
+def build_quota_response(
+    scope: str, tenant_id: int, user_id: Optional[int], explicit_max: Optional[int], used: int
+) -> StorageQuotaResponse:
+    # Même repli que loadQuotaLimits (drive-service) : explicite, sinon défaut d'environnement.
+    is_default = explicit_max is None
+    max_bytes = default_quota_bytes(scope) if is_default else explicit_max
+    percent = None
+    if max_bytes:
+        percent = used * 100 / max_bytes
+    return StorageQuotaResponse(
+        scope=scope,
+        tenant_id=tenant_id,
+        user_id=user_id,
+        max_bytes=max_bytes,
+        is_default=is_default,
+        used_bytes=used,
+        percent=percent,
+        level=quota_level(used, max_bytes),
+        warn_thresholds=[QUOTA_WARN_PERCENT, QUOTA_CRITICAL_PERCENT],
+    )
+
+
+def _tenant_quota(db: Session, tenant_id: int) -> StorageQuotaResponse:
+    row = db.execute(
+        text(
+            """
+            SELECT
+                (SELECT max_bytes FROM storage_quotas WHERE tenant_id = :tenant_id AND user_id IS NULL) AS max_bytes,
+                COALESCE((SELECT SUM(size) FROM drive_nodes WHERE tenant_id = :tenant_id AND is_folder = false), 0)::bigint AS used
+            """
+        ),
+        {"tenant_id": tenant_id},
+    ).mappings().one()
+    return build_quota_response("tenant", tenant_id, None, row["max_bytes"], int(row["used"]))
+
+
+def _user_quota(db: Session, user: User) -> StorageQuotaResponse:
+    row = db.execute(
+        text(
+            """
+            SELECT
+                (SELECT max_bytes FROM storage_quotas WHERE user_id = :user_id) AS max_bytes,
+                COALESCE((SELECT SUM(size) FROM drive_nodes WHERE user_id = :user_id AND is_folder = false), 0)::bigint AS used
+            """
+        ),
+        {"user_id": user.id},
+    ).mappings().one()
+    return build_quota_response("user", user.tenant_id, user.id, row["max_bytes"], int(row["used"]))
+
+
+@router.get("/tenants/{tenant_id}/quota", response_model=StorageQuotaResponse)
+async def get_tenant_quota(tenant_id: int, db: Session = Depends(get_db)):
+    if not db.query(Tenant).filter(Tenant.id == tenant_id).first():
+        raise HTTPException(status_code=404, detail="Tenant not found")
+    return _tenant_quota(db, tenant_id)
+
+
+@router.put("/tenants/{tenant_id}/quota", response_model=StorageQuotaResponse)
+async def set_tenant_quota(tenant_id: int, payload: StorageQuotaUpdate, db: Session = Depends(get_db)):
+    if not db.query(Tenant).filter(Tenant.id == tenant_id).first():
+        raise HTTPException(status_code=404, detail="Tenant not found")
+    if payload.max_bytes is None:
+        db.execute(
+            text("DELETE FROM storage_quotas WHERE tenant_id = :tenant_id AND user_id IS NULL"),
+            {"tenant_id": tenant_id},
+        )
+    else:
+        db.execute(
+            text(
+                """
+                INSERT INTO storage_quotas (tenant_id, user_id, max_bytes)
+                VALUES (:tenant_id, NULL, :max_bytes)
+                ON CONFLICT (tenant_id) WHERE user_id IS NULL
+                DO UPDATE SET max_bytes = EXCLUDED.max_bytes
+                """
+            ),
+            {"tenant_id": tenant_id, "max_bytes": payload.max_bytes},
+        )
+    db.commit()
+    return _tenant_quota(db, tenant_id)
+
+
+@router.get("/users/{user_id}/quota", response_model=StorageQuotaResponse)
+async def get_user_quota(user_id: int, db: Session = Depends(get_db)):
+    user = db.query(User).filter(User.id == user_id).first()
+    if not user:
+        raise HTTPException(status_code=404, detail="User not found")
+    return _user_quota(db, user)
+
+
+@router.put("/users/{user_id}/quota", response_model=StorageQuotaResponse)
+async def set_user_quota(user_id: int, payload: StorageQuotaUpdate, db: Session = Depends(get_db)):
+    user = db.query(User).filter(User.id == user_id).first()
+    if not user:
+        raise HTTPException(status_code=404, detail="User not found")
+    if payload.max_bytes is None:
+        db.execute(text("DELETE FROM storage_quotas WHERE user_id = :user_id"), {"user_id": user_id})
+    else:
+        db.execute(
+            text(
+                """
+                INSERT INTO storage_quotas (tenant_id, user_id, max_bytes)
+                VALUES (:tenant_id, :user_id, :max_bytes)
+                ON CONFLICT (user_id) WHERE user_id IS NOT NULL
+                DO UPDATE SET max_bytes = EXCLUDED.max_bytes, tenant_id = EXCLUDED.tenant_id
+                """
+            ),
+            {"tenant_id": user.tenant_id, "user_id": user_id, "max_bytes": payload.max_bytes},
+        )
+    db.commit()
+    return _user_quota(db, user)
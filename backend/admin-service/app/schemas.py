@@ -64,6 +64,44 @@ class TenantMailAccountSummary(BaseModel):
     model_config = ConfigDict(from_attributes=True)
 
 
+class StorageQuotaUpdate(BaseModel):
+    """Quota en octets ; `None` supprime le quota explicite (retour au défaut du service)."""
+
+    max_bytes: Optional[int] = Field(None, ge=0)
+
+
+class StorageQuotaResponse(BaseModel):
+    """`max_bytes` : limite appliquée par drive-service ; `is_default` si elle vient de l'environnement."""
+
+    scope: str
+    tenant_id: int
+    user_id: Optional[int] = None
+    max_bytes: Optional[int] = None
+    is_default: bool = False
+    used_bytes: int = 0
+    percent: Optional[float] = None
+    level: str = "unlimited"
+    warn_thresholds: List[int] = Field(default_factory=lambda: [80, 95])
+
+
+class UserGroupCreate(BaseModel):
+    """Groupe d'utilisateurs d'un tenant (destinataire de partages Drive internes)."""
+
+    name: str = Field(..., min_length=1, max_length=255)
+
+
+class UserGroupMemberAdd(BaseModel):
+    user_id: int = Field(..., gt=0)
+
+
+class UserGroupResponse(BaseModel):
+    id: int
+    tenant_id: int
+    name: str
+    member_ids: List[int] = Field(default_factory=list)
+    created_at: datetime
+
+
 class AdminTwoFAResetRequest(BaseModel):
     """Step-up admin : code TOTP de l'administrateur qui effectue le reset."""
 
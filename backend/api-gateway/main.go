@@ -498,7 +498,9 @@ func authMiddleware(next http.Handler) http.Handler {
 			strings.HasPrefix(r.URL.Path, "/auth/webauthn/login") ||
 			strings.HasPrefix(r.URL.Path, "/auth/health") ||
 			r.URL.Path == "/health" ||
-			r.URL.Path == "/csp-report" {
+			r.URL.Path == "/csp-report" ||
+			isPublicDriveShareRoute(r.URL.Path) ||
+			isDriveWebDAVRoute(r.URL.Path) {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -640,7 +642,22 @@ func authMiddleware(next http.Handler) http.Handler {
 func isAdminOnlyMailRoute(path string) bool {
 	return strings.HasPrefix(path, "/mail/domains") ||
 		strings.HasPrefix(path, "/mail/mailboxes") ||
-		strings.HasPrefix(path, "/mail/aliases")
+		strings.HasPrefix(path, "/mail/aliases") ||
+		strings.HasPrefix(path, "/mail/admin/")
+}
+
+// isPublicDriveShareRoute : liens de partage Drive (accès anonyme par token).
+// Le JWT éventuel est ignoré : le drive-service n'y lit que le token du lien,
+// jamais X-User-ID (retiré par stripInternalTrustHeaders).
+func isPublicDriveShareRoute(path string) bool {
+	return strings.HasPrefix(path, "/drive/public/")
+}
+
+// isDriveWebDAVRoute : montage WebDAV du Drive. Les clients (Finder, Nautilus,
+// rclone…) envoient un mot de passe d'application en Basic, vérifié par le
+// drive-service ; le Bearer JWT n'est pas exigé ici.
+func isDriveWebDAVRoute(path string) bool {
+	return path == "/drive/dav" || strings.HasPrefix(path, "/drive/dav/")
 }
 
 // isAdminOnlyPassRoute regroupe les routes Pass réservées aux admins (stats
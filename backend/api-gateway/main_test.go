@@ -316,6 +316,40 @@ func TestDriveSearchPrefixRouted(t *testing.T) {
 	}
 }
 
+func TestDrivePublicShareSkipsAuth(t *testing.T) {
+	if !isPublicDriveShareRoute("/drive/public/shares/abc") {
+		t.Fatal("isPublicDriveShareRoute should accept /drive/public/shares/*")
+	}
+	if isPublicDriveShareRoute("/drive/nodes/1/shares") {
+		t.Fatal("isPublicDriveShareRoute must not match owner share management routes")
+	}
+	handler := NewHandler()
+	req := httptest.NewRequest(http.MethodGet, "/drive/public/shares/abc", nil)
+	req.Header.Set("Authorization", "Bearer fake-token")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code == http.StatusUnauthorized || w.Code == http.StatusNotFound {
+		t.Errorf("GET /drive/public/shares/abc: got %d, public share route must be forwarded without JWT check", w.Code)
+	}
+}
+
+func TestDriveWebDAVSkipsJWT(t *testing.T) {
+	if !isDriveWebDAVRoute("/drive/dav") || !isDriveWebDAVRoute("/drive/dav/Documents/a.txt") {
+		t.Fatal("isDriveWebDAVRoute should accept /drive/dav and /drive/dav/*")
+	}
+	if isDriveWebDAVRoute("/drive/davinci") || isDriveWebDAVRoute("/drive/nodes") {
+		t.Fatal("isDriveWebDAVRoute must only match the WebDAV mount")
+	}
+	handler := NewHandler()
+	req := httptest.NewRequest("PROPFIND", "/drive/dav/", nil)
+	req.SetBasicAuth("user@example.com", "abcd-efgh-jkmn-pqrs-tuvw-xyz2")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code == http.StatusUnauthorized || w.Code == http.StatusNotFound || w.Code == http.StatusMethodNotAllowed {
+		t.Errorf("PROPFIND /drive/dav/: got %d, WebDAV must be forwarded without JWT check", w.Code)
+	}
+}
+
 func TestMailMeAccountsRouted(t *testing.T) {
 	handler := NewHandler()
 	req := httptest.NewRequest(http.MethodGet, "/mail/me/accounts", nil)
@@ -336,6 +370,7 @@ func TestIsAdminOnlyMailRoute(t *testing.T) {
 		{path: "/mail/domains", want: true},
 		{path: "/mail/mailboxes/1", want: true},
 		{path: "/mail/aliases", want: true},
+		{path: "/mail/admin/spam/model", want: true},
 		{path: "/mail/me/accounts", want: false},
 	}
 	for _, tc := range cases {
@@ -0,0 +1,227 @@
+// app_passwords.go — Mots de passe d'application (table `app_passwords`).
+//
+// Pour les clients incapables de faire le login JWT + 2FA (montage WebDAV du
+// Drive : Nautilus, Finder, Explorateur Windows, rclone). L'utilisateur en crée
+// un par appareil depuis ses réglages, le colle dans le client, et peut le
+// révoquer individuellement.
+//
+// Format : 24 caractères `xxxx-xxxx-xxxx-xxxx-xxxx-xxxx` (≈ 119 bits). Stocké
+// en SHA-256 de la forme normalisée : l'entropie rend un hash lent inutile et
+// drive-service le vérifie à chaque requête WebDAV (webdav_auth.go).
+//
+// Référence : infrastructure/postgresql/migrations/50-app-passwords.sql.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// appPasswordAlphabet — minuscules + chiffres sans caractères ambigus (0/o, 1/i/l).
+const appPasswordAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+const (
+	appPasswordLength = 24
+	// appPasswordMaxPerUser — garde-fou contre l'accumulation de secrets oubliés.
+	appPasswordMaxPerUser  = 25
+	appPasswordScopeWebDAV = "webdav"
+)
+
+// generateAppPassword produit `xxxx-xxxx-xxxx-xxxx-xxxx-xxxx`.
+func generateAppPassword() (string, error) {
+	buf := make([]byte, appPasswordLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for i := 0; i < appPasswordLength; i++ {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(appPasswordAlphabet[int(buf[i])%len(appPasswordAlphabet)])
+	}
+	return b.String(), nil
+}
+
+// appPasswordHash — SHA-256 hex de la forme normalisée (minuscules, sans tirets
+// ni espaces). Doit rester identique à drive-service (webdav_auth.go).
+func appPasswordHash(raw string) string {
+	cleaned := strings.ToLower(strings.TrimSpace(raw))
+	cleaned = strings.ReplaceAll(cleaned, "-", "")
+	cleaned = strings.ReplaceAll(cleaned, " ", "")
+	sum := sha256.Sum256([]byte(cleaned))
+	return hex.EncodeToString(sum[:])
+}
+
+// requireBearerClaims — Bearer JWT obligatoire, sinon 401 (mêmes messages que recovery codes).
+func (a *AuthService) requireBearerClaims(c *gin.Context) (*Claims, bool) {
+	auth := strings.TrimSpace(c.GetHeader("Authorization"))
+	if !strings.HasPrefix(auth, "Bearer ") {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing bearer token"})
+		return nil, false
+	}
+	claims, err := a.parseAccessToken(strings.TrimPrefix(auth, "Bearer "))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return nil, false
+	}
+	return claims, true
+}
+
+func (a *AuthService) appPasswordDB(c *gin.Context) (*sql.DB, bool) {
+	store, ok := a.userStore.(*postgresUserStore)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "app passwords require postgres user store"})
+		return nil, false
+	}
+	return store.db, true
+}
+
+// CreateAppPassword — POST /auth/app-passwords {"name": "Laptop Nautilus"}
+// Renvoie le mot de passe en clair UNE SEULE FOIS.
+func (a *AuthService) CreateAppPassword(c *gin.Context) {
+	claims, ok := a.requireBearerClaims(c)
+	if !ok {
+		return
+	}
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name required (max 100 chars)"})
+		return
+	}
+	db, ok := a.appPasswordDB(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	var active int
+	if err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM app_passwords WHERE user_id = $1::int AND revoked_at IS NULL
+	`, claims.UserID).Scan(&active); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if active >= appPasswordMaxPerUser {
+		c.JSON(http.StatusConflict, gin.H{"error": "too many app passwords, revoke unused ones first"})
+		return
+	}
+	password, err := generateAppPassword()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "rng failure"})
+		return
+	}
+	var id int
+	var createdAt time.Time
+	if err := db.QueryRowContext(ctx, `
+		INSERT INTO app_passwords (user_id, tenant_id, name, password_hash, scopes)
+		SELECT id, tenant_id, $2, $3, ARRAY[$4]::TEXT[] FROM users WHERE id = $1::int
+		RETURNING id, created_at
+	`, claims.UserID, name, appPasswordHash(password), appPasswordScopeWebDAV).Scan(&id, &createdAt); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"id":         id,
+		"name":       name,
+		"password":   password,
+		"scopes":     []string{appPasswordScopeWebDAV},
+		"created_at": createdAt,
+		"warning":    "Copie-le maintenant — il ne sera plus affiché.",
+	})
+}
+
+// ListAppPasswords — GET /auth/app-passwords (jamais le secret).
+func (a *AuthService) ListAppPasswords(c *gin.Context) {
+	claims, ok := a.requireBearerClaims(c)
+	if !ok {
+		return
+	}
+	db, ok := a.appPasswordDB(c)
+	if !ok {
+		return
+	}
+	rows, err := db.QueryContext(c.Request.Context(), `
+		SELECT id, name, array_to_string(scopes, ','), last_used_at, created_at
+		  FROM app_passwords
+		 WHERE user_id = $1::int AND revoked_at IS NULL
+		 ORDER BY created_at DESC
+	`, claims.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	type appPasswordView struct {
+		ID         int        `json:"id"`
+		Name       string     `json:"name"`
+		Scopes     []string   `json:"scopes"`
+		LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+		CreatedAt  time.Time  `json:"created_at"`
+	}
+	out := make([]appPasswordView, 0)
+	for rows.Next() {
+		var v appPasswordView
+		var scopes string
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&v.ID, &v.Name, &scopes, &lastUsed, &v.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		v.Scopes = strings.Split(scopes, ",")
+		if lastUsed.Valid {
+			v.LastUsedAt = &lastUsed.Time
+		}
+		out = append(out, v)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// RevokeAppPassword — DELETE /auth/app-passwords/:id
+func (a *AuthService) RevokeAppPassword(c *gin.Context) {
+	claims, ok := a.requireBearerClaims(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	db, ok := a.appPasswordDB(c)
+	if !ok {
+		return
+	}
+	res, err := db.ExecContext(c.Request.Context(), `
+		UPDATE app_passwords SET revoked_at = now()
+		 WHERE id = $1 AND user_id = $2::int AND revoked_at IS NULL
+	`, id, claims.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGenerateAppPasswordFormat(t *testing.T) {
+	seen := make(map[string]struct{})
+	for i := 0; i < 100; i++ {
+		pw, err := generateAppPassword()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pw) != 29 || strings.Count(pw, "-") != 5 {
+			t.Fatalf("format inattendu: %q", pw)
+		}
+		for _, c := range strings.ReplaceAll(pw, "-", "") {
+			if !strings.ContainsRune(appPasswordAlphabet, c) {
+				t.Fatalf("caractère hors alphabet: %q dans %q", c, pw)
+			}
+		}
+		if _, dup := seen[pw]; dup {
+			t.Fatalf("collision: %q", pw)
+		}
+		seen[pw] = struct{}{}
+	}
+}
+
+// TestAppPasswordHashNormalized — saisie avec/sans tirets, majuscules : même hash.
+func TestAppPasswordHashNormalized(t *testing.T) {
+	want := appPasswordHash("abcd-efgh-jkmn-pqrs-tuvw-xyz2")
+	for _, in := range []string{"abcdefghjkmnpqrstuvwxyz2", "ABCD-EFGH-JKMN-PQRS-TUVW-XYZ2", " abcd efgh jkmn pqrs tuvw xyz2 "} {
+		if got := appPasswordHash(in); got != want {
+			t.Errorf("appPasswordHash(%q) = %s, want %s", in, got, want)
+		}
+	}
+	if len(want) != 64 {
+		t.Fatalf("sha256 hex attendu, got %q", want)
+	}
+}
+
+func TestAppPasswordsRequireBearer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := &AuthService{}
+	r := gin.New()
+	r.POST("/auth/app-passwords", svc.CreateAppPassword)
+	r.GET("/auth/app-passwords", svc.ListAppPasswords)
+	r.DELETE("/auth/app-passwords/:id", svc.RevokeAppPassword)
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodPost, "/auth/app-passwords"},
+		{http.MethodGet, "/auth/app-passwords"},
+		{http.MethodDelete, "/auth/app-passwords/1"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s sans Bearer: got %d", tc.method, tc.path, w.Code)
+		}
+	}
+}
@@ -17,6 +17,9 @@ func registerAuthHTTPRoutes(r *gin.Engine, auth *AuthService, db *sql.DB, rdb *r
 	r.POST("/auth/2fa/verify", auth.Verify2FA)
 	r.POST("/auth/2fa/recovery-codes/regenerate", auth.RegenerateRecoveryCodes)
 	r.GET("/auth/2fa/recovery-codes/count", auth.CountRecoveryCodes)
+	r.POST("/auth/app-passwords", auth.CreateAppPassword)
+	r.GET("/auth/app-passwords", auth.ListAppPasswords)
+	r.DELETE("/auth/app-passwords/:id", auth.RevokeAppPassword)
 	r.GET("/auth/security-paths", auth.SecurePaths)
 	r.POST("/auth/security-paths/validate", auth.ValidateSecurePath)
 	r.GET("/auth/validate", auth.ValidateToken)
@@ -0,0 +1,670 @@
+package main
+
+// archive.go — archives Drive : liste des entrées, aperçu d'une entrée, extraction.
+//
+// Formats : ZIP, tar, tar.gz (.tgz) et tar.zst, reconnus à leur signature (le nom ne sert
+// qu'aux tar anciens sans en-tête ustar). 7z / rar ne sont pas pris en charge : pas de
+// décodeur Go pur maintenu. POST /drive/nodes/:id/archive/extract crée un dossier à côté de
+// l'archive et y recrée l'arborescence par lots (une transaction et une réservation de quota
+// par lot) ; en cas d'échec le dossier créé est supprimé.
+//
+// Garde-fous : chemins normalisés (ni absolus, ni "..", liens et fichiers spéciaux ignorés),
+// nombre d'entrées, taille décompressée totale et par entrée, ratio de compression (zip bomb).
+// Les tailles annoncées ne sont pas crues sur parole : archive/zip refuse une entrée plus
+// longue que son en-tête et les flux tar compressés sont lus au travers d'un compteur borné.
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	archiveZip    = "zip"
+	archiveTar    = "tar"
+	archiveTarGz  = "tar.gz"
+	archiveTarZst = "tar.zst"
+
+	archiveMaxDepth = 64
+
+	// Lots d'extraction : le verrou de quota du tenant (reserveQuota) n'est tenu que le temps
+	// d'insérer un lot, pas pendant toute la décompression.
+	archiveBatchEntries = 200
+	archiveBatchBytes   = 32 << 20
+)
+
+var (
+	errArchiveTooLarge = errors.New("archive exceeds extraction limits")
+	errArchiveStop     = errors.New("stop archive walk")
+)
+
+type archiveEntry struct {
+	Path  string `json:"path"`
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	IsDir bool   `json:"is_dir"`
+}
+
+// archiveLimits — DRIVE_ARCHIVE_MAX_ENTRIES / _MAX_BYTES / _MAX_ENTRY_BYTES / _MAX_RATIO.
+type archiveLimits struct {
+	MaxEntries    int
+	MaxTotalBytes int64
+	MaxEntryBytes int64
+	MaxRatio      int64
+}
+
+func archiveLimitsFromEnv() archiveLimits {
+	l := archiveLimits{MaxEntries: 10000, MaxTotalBytes: 1 << 30, MaxEntryBytes: 256 << 20, MaxRatio: 100}
+	if v, err := strconv.Atoi(os.Getenv("DRIVE_ARCHIVE_MAX_ENTRIES")); err == nil && v > 0 {
+		l.MaxEntries = v
+	}
+	if v := quotaBytesFromEnv("DRIVE_ARCHIVE_MAX_BYTES"); v > 0 {
+		l.MaxTotalBytes = v
+	}
+	if v := quotaBytesFromEnv("DRIVE_ARCHIVE_MAX_ENTRY_BYTES"); v > 0 {
+		l.MaxEntryBytes = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("DRIVE_ARCHIVE_MAX_RATIO"), 10, 64); err == nil && v > 0 {
+		l.MaxRatio = v
+	}
+	return l
+}
+
+// expandedBudget — octets décompressés admis pour une archive de archiveSize octets.
+func (l archiveLimits) expandedBudget(archiveSize int64) int64 {
+	budget := l.MaxTotalBytes
+	if byRatio := archiveSize * l.MaxRatio; byRatio >= 0 && byRatio < budget {
+		budget = byRatio
+	}
+	return budget
+}
+
+// detectArchiveFormat reconnaît le conteneur à sa signature ("" si non pris en charge).
+func detectArchiveFormat(name string, content []byte) string {
+	switch {
+	case bytes.HasPrefix(content, []byte("PK\x03\x04")), bytes.HasPrefix(content, []byte("PK\x05\x06")):
+		return archiveZip
+	case bytes.HasPrefix(content, []byte{0x1f, 0x8b}):
+		return archiveTarGz
+	case bytes.HasPrefix(content, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return archiveTarZst
+	case len(content) >= 262 && string(content[257:262]) == "ustar":
+		return archiveTar
+	case strings.HasSuffix(strings.ToLower(name), ".tar") && len(content) >= 512:
+		return archiveTar
+	}
+	return ""
+}
+
+// cleanArchivePath normalise un chemin d'entrée ; refuse chemins absolus, lecteurs Windows,
+// segments "..", octets nuls et arborescences trop profondes.
+func cleanArchivePath(p string) (string, bool) {
+	p = strings.ReplaceAll(p, `\`, "/")
+	if p == "" || strings.HasPrefix(p, "/") || strings.ContainsRune(p, 0) || (len(p) >= 2 && p[1] == ':') {
+		return "", false
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return "", false
+		}
+	}
+	p = path.Clean(p)
+	if p == "." || strings.Count(p, "/") >= archiveMaxDepth {
+		return "", false
+	}
+	return p, true
+}
+
+// archiveFolderName — nom du dossier d'extraction : nom de l'archive sans ses extensions.
+func archiveFolderName(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tar.zst", ".tgz", ".tzst", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	if ext := path.Ext(name); ext != "" && len(name) > len(ext) {
+		return name[:len(name)-len(ext)]
+	}
+	return name + " (extrait)"
+}
+
+// uniqueSiblingName renvoie base, sinon « base (2) », « base (3) »… absent de taken.
+func uniqueSiblingName(base string, taken map[string]bool) string {
+	if !taken[base] {
+		return base
+	}
+	for i := 2; ; i++ {
+		if n := fmt.Sprintf("%s (%d)", base, i); !taken[n] {
+			return n
+		}
+	}
+}
+
+// boundedReader échoue avec errArchiveTooLarge au-delà de left octets (flux décompressé).
+type boundedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (b *boundedReader) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		return 0, errArchiveTooLarge
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.r.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
+
+// walkArchive appelle fn pour chaque fichier ou dossier au chemin sûr (entrées ignorées
+// comptées dans skipped). open n'est valide que pendant l'appel (flux tar séquentiel) ;
+// fn peut renvoyer errArchiveStop pour arrêter le parcours sans erreur.
+func walkArchive(content []byte, format string, limits archiveLimits, fn func(e archiveEntry, open func() (io.Reader, error)) error) (skipped int, err error) {
+	if format == archiveZip {
+		return walkZip(content, fn)
+	}
+	var stream io.Reader = bytes.NewReader(content)
+	switch format {
+	case archiveTarGz:
+		zr, err := gzip.NewReader(stream)
+		if err != nil {
+			return 0, err
+		}
+		defer zr.Close()
+		stream = zr
+	case archiveTarZst:
+		d, err := zstd.NewReader(stream, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(128<<20))
+		if err != nil {
+			return 0, err
+		}
+		defer d.Close()
+		stream = d
+	case archiveTar:
+	default:
+		return 0, errors.New("unsupported archive format")
+	}
+	// En-têtes tar (512 o par entrée, extensions PAX) en plus du budget de contenu.
+	overhead := int64(limits.MaxEntries+16) * 2048
+	tr := tar.NewReader(&boundedReader{r: stream, left: limits.expandedBudget(int64(len(content))) + overhead})
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return skipped, nil
+		}
+		if err != nil {
+			return skipped, err
+		}
+		clean, ok := cleanArchivePath(hdr.Name)
+		isDir := hdr.Typeflag == tar.TypeDir
+		if !ok || (!isDir && hdr.Typeflag != tar.TypeReg) {
+			// Liens symboliques / physiques, périphériques, FIFO : jamais recréés.
+			skipped++
+			continue
+		}
+		e := archiveEntry{Path: clean, Name: path.Base(clean), IsDir: isDir}
+		if !isDir {
+			e.Size = hdr.Size
+		}
+		if err := fn(e, func() (io.Reader, error) { return tr, nil }); err != nil {
+			if err == errArchiveStop {
+				return skipped, nil
+			}
+			return skipped, err
+		}
+	}
+}
+
+func walkZip(content []byte, fn func(e archiveEntry, open func() (io.Reader, error)) error) (skipped int, err error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range zr.File {
+		clean, ok := cleanArchivePath(f.Name)
+		mode := f.Mode()
+		isDir := strings.HasSuffix(f.Name, "/") || mode.IsDir()
+		if !ok || (!isDir && !mode.IsRegular()) || f.UncompressedSize64 > 1<<62 {
+			skipped++
+			continue
+		}
+		e := archiveEntry{Path: clean, Name: path.Base(clean), IsDir: isDir}
+		if !isDir {
+			e.Size = int64(f.UncompressedSize64)
+		}
+		var rc io.ReadCloser
+		err := fn(e, func() (io.Reader, error) {
+			r, err := f.Open()
+			rc = r
+			return r, err
+		})
+		if rc != nil {
+			rc.Close()
+		}
+		if err == errArchiveStop {
+			return skipped, nil
+		}
+		if err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// readArchiveEntry lit une entrée en mémoire, bornée à max octets.
+func readArchiveEntry(open func() (io.Reader, error), max int64) ([]byte, error) {
+	r, err := open()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, errArchiveTooLarge
+	}
+	return data, nil
+}
+
+// archivePlan — inventaire avant extraction (aucune écriture).
+type archivePlan struct {
+	Files, Folders int
+	Bytes          int64
+	Skipped        int
+}
+
+func planArchive(content []byte, format string, limits archiveLimits) (archivePlan, error) {
+	var p archivePlan
+	budget := limits.expandedBudget(int64(len(content)))
+	skipped, err := walkArchive(content, format, limits, func(e archiveEntry, _ func() (io.Reader, error)) error {
+		if e.IsDir {
+			p.Folders++
+		} else {
+			p.Files++
+			p.Bytes += e.Size
+		}
+		if p.Files+p.Folders > limits.MaxEntries || e.Size > limits.MaxEntryBytes || p.Bytes > budget {
+			return errArchiveTooLarge
+		}
+		return nil
+	})
+	p.Skipped = skipped
+	return p, err
+}
+
+// writeArchiveError — 413 pour les limites d'extraction, 400 pour une archive illisible.
+func writeArchiveError(c *gin.Context, err error, limits archiveLimits) {
+	if errors.Is(err, errArchiveTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":           "archive_too_large",
+			"code":            "ARCHIVE_LIMITS",
+			"max_entries":     limits.MaxEntries,
+			"max_bytes":       limits.MaxTotalBytes,
+			"max_entry_bytes": limits.MaxEntryBytes,
+			"max_ratio":       limits.MaxRatio,
+		})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "invalid archive", "detail": err.Error()})
+}
+
+// loadArchiveNode charge une archive visible (nodeAccessSQL) ; écrit la réponse d'erreur.
+func (h *Handler) loadArchiveNode(c *gin.Context) (id int, name string, content []byte, parentID sql.NullInt64, format string, ok bool) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var vaultEncrypted bool
+	err = h.dbex(c.Request.Context()).QueryRow(`
+		SELECT name, COALESCE(content, ''::bytea), parent_id, vault_encrypted FROM drive_nodes
+		WHERE id = $1 AND is_folder = false AND deleted_at IS NULL AND photo_locked_at IS NULL
+		  AND `+nodeAccessSQL("id", accessViewer)+`
+	`, id).Scan(&name, &content, &parentID, &vaultEncrypted)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if vaultEncrypted {
+		c.JSON(http.StatusConflict, gin.H{"error": "vault_encrypted", "code": "VAULT_ENCRYPTED"})
+		return
+	}
+	return id, name, content, parentID, detectArchiveFormat(name, content), true
+}
+
+// getArchiveEntries — GET /drive/nodes/:id/archive/entries : liste sans extraire (ZIP, tar, tar.gz, tar.zst).
+func (h *Handler) getArchiveEntries(c *gin.Context) {
+	_, _, content, _, format, ok := h.loadArchiveNode(c)
+	if !ok {
+		return
+	}
+	if len(content) == 0 {
+		c.JSON(http.StatusOK, gin.H{"entries": []archiveEntry{}})
+		return
+	}
+	if format == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_archive", "code": "UNSUPPORTED_ARCHIVE"})
+		return
+	}
+	limits := archiveLimitsFromEnv()
+	entries := make([]archiveEntry, 0)
+	truncated := false
+	skipped, err := walkArchive(content, format, limits, func(e archiveEntry, _ func() (io.Reader, error)) error {
+		if len(entries) >= limits.MaxEntries {
+			truncated = true
+			return errArchiveStop
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if errors.Is(err, errArchiveTooLarge) {
+		// Flux compressé démesuré : on montre ce qui a été lu.
+		truncated, err = true, nil
+	}
+	if err != nil {
+		writeArchiveError(c, err, limits)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"format": format, "entries": entries, "truncated": truncated, "skipped": skipped})
+}
+
+// getArchiveEntry — GET /drive/nodes/:id/archive/entry?path= : contenu d'une seule entrée (aperçu).
+func (h *Handler) getArchiveEntry(c *gin.Context) {
+	_, _, content, _, format, ok := h.loadArchiveNode(c)
+	if !ok {
+		return
+	}
+	want, valid := cleanArchivePath(c.Query("path"))
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid path"})
+		return
+	}
+	if format == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_archive", "code": "UNSUPPORTED_ARCHIVE"})
+		return
+	}
+	limits := archiveLimitsFromEnv()
+	var data []byte
+	found := false
+	_, err := walkArchive(content, format, limits, func(e archiveEntry, open func() (io.Reader, error)) error {
+		if e.IsDir || e.Path != want {
+			return nil
+		}
+		b, err := readArchiveEntry(open, limits.MaxEntryBytes)
+		if err != nil {
+			return err
+		}
+		data, found = b, true
+		return errArchiveStop
+	})
+	if err != nil {
+		writeArchiveError(c, err, limits)
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "entry not found"})
+		return
+	}
+	c.Header("Cache-Control", "private, no-store")
+	serveNodeContent(c, path.Base(want), data, sql.NullString{}, false)
+}
+
+// createArchiveRoot vérifie le quota pour la taille annoncée de l'archive et crée le dossier
+// d'extraction (nom suffixé si pris), dans une transaction courte.
+func (h *Handler) createArchiveRoot(ctx context.Context, uid, tid, parentID int, name string, size int64) (int, string, string, error) {
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer tx.Rollback()
+	level, err := reserveQuota(tx, uid, tid, size)
+	if err != nil {
+		return 0, "", "", err
+	}
+	parentParam := sql.NullInt64{Int64: int64(parentID), Valid: parentID > 0}
+	rows, err := tx.Query(`
+		SELECT name FROM drive_nodes
+		WHERE user_id = $1 AND parent_id IS NOT DISTINCT FROM $2 AND deleted_at IS NULL
+	`, uid, parentParam)
+	if err != nil {
+		return 0, "", "", err
+	}
+	siblings := make(map[string]bool)
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err != nil {
+			rows.Close()
+			return 0, "", "", err
+		}
+		siblings[n] = true
+	}
+	rows.Close()
+	folderName := uniqueSiblingName(name, siblings)
+	var rootID int
+	if err := tx.QueryRow(`
+		INSERT INTO drive_nodes (tenant_id, user_id, parent_id, name, is_folder, size) VALUES ($1, $2, $3, $4, true, 0)
+		RETURNING id
+	`, tid, uid, parentParam, folderName).Scan(&rootID); err != nil {
+		return 0, "", "", err
+	}
+	return rootID, folderName, level, tx.Commit()
+}
+
+// extractArchive — POST /drive/nodes/:id/archive/extract {"parent_id": N} : extrait l'archive dans
+// un nouveau dossier (nom de l'archive, suffixé si pris) sous parent_id, par défaut à côté de l'archive.
+func (h *Handler) extractArchive(c *gin.Context) {
+	_, name, content, archiveParent, format, ok := h.loadArchiveNode(c)
+	if !ok {
+		return
+	}
+	var body struct {
+		ParentID *int `json:"parent_id"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+			return
+		}
+	}
+	if format == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_archive", "code": "UNSUPPORTED_ARCHIVE"})
+		return
+	}
+	parentID := int(archiveParent.Int64)
+	if body.ParentID != nil {
+		parentID = *body.ParentID
+	}
+	uid, tid := requestUserTenant(c)
+	if parentID > 0 {
+		// Dossier cible partagé : rôle éditeur requis, les nœuds appartiennent à son propriétaire.
+		parent, ok := h.requireNodeAccess(c, parentID, accessEditor)
+		if !ok {
+			return
+		}
+		if !parent.IsFolder {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "parent must be a folder"})
+			return
+		}
+		uid, tid = parent.UserID, parent.TenantID
+	}
+	limits := archiveLimitsFromEnv()
+	plan, err := planArchive(content, format, limits)
+	if err != nil {
+		writeArchiveError(c, err, limits)
+		return
+	}
+	ctx := c.Request.Context()
+	rootID, folderName, level, err := h.createArchiveRoot(ctx, uid, tid, parentID, archiveFolderName(name), plan.Bytes)
+	if err != nil {
+		if !writeQuotaError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Entrées lues en attente d'insertion (dossiers avant leur contenu, dans l'ordre de l'archive).
+	type pendingEntry struct {
+		path, name string
+		isDir      bool
+		data       []byte
+	}
+	var batch []pendingEntry
+	var batchBytes int64
+	// dirs : chemin → id des dossiers insérés ; known : dossiers insérés ou en attente ;
+	// files : chemins des fichiers (doublons ignorés).
+	dirs := map[string]int{".": rootID}
+	known := map[string]bool{".": true}
+	files := make(map[string]bool)
+	var nFiles, nFolders int
+	var written int64
+	skipped := plan.Skipped
+	// dbErr distingue une erreur SQL ou de quota d'une archive illisible ou hors limites.
+	var dbErr error
+	var queueDir func(p string) bool
+	queueDir = func(p string) bool {
+		if known[p] {
+			return true
+		}
+		if files[p] || !queueDir(path.Dir(p)) {
+			return false
+		}
+		known[p] = true
+		batch = append(batch, pendingEntry{path: p, name: path.Base(p), isDir: true})
+		nFolders++
+		return true
+	}
+	insertBatch := func(tx *sql.Tx) error {
+		if level, dbErr = reserveQuota(tx, uid, tid, batchBytes); dbErr != nil {
+			return dbErr
+		}
+		for _, it := range batch {
+			parent := dirs[path.Dir(it.path)]
+			if it.isDir {
+				var id int
+				if dbErr = tx.QueryRow(`
+					INSERT INTO drive_nodes (tenant_id, user_id, parent_id, name, is_folder, size) VALUES ($1, $2, $3, $4, true, 0)
+					RETURNING id
+				`, tid, uid, parent, it.name).Scan(&id); dbErr != nil {
+					return dbErr
+				}
+				dirs[it.path] = id
+				continue
+			}
+			mimeType := mimeFromFileName(it.name)
+			if mimeType == "" {
+				mimeType = "application/octet-stream"
+			}
+			var takenAt sql.NullTime
+			if t, ok := photoTakenAtFromExif(it.name, mimeType, it.data); ok {
+				takenAt = sql.NullTime{Time: t, Valid: true}
+			} else if t, ok := photoTakenAtFromFileName(it.name); ok {
+				takenAt = sql.NullTime{Time: t, Valid: true}
+			}
+			if _, dbErr = tx.Exec(`
+				INSERT INTO drive_nodes (tenant_id, user_id, parent_id, name, is_folder, size, mime_type, content, taken_at, content_hash)
+				VALUES ($1, $2, $3, $4, false, $5, $6, $7, $8, $9)
+			`, tid, uid, parent, it.name, int64(len(it.data)), mimeType, it.data, takenAt, contentHashParam(sha256HexContent(it.data))); dbErr != nil {
+				return dbErr
+			}
+		}
+		dbErr = tx.Commit()
+		return dbErr
+	}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		tx, err := h.dbex(ctx).Begin()
+		if err != nil {
+			dbErr = err
+			return err
+		}
+		defer tx.Rollback()
+		if err := insertBatch(tx); err != nil {
+			return err
+		}
+		batch, batchBytes = nil, 0
+		return nil
+	}
+	_, err = walkArchive(content, format, limits, func(e archiveEntry, open func() (io.Reader, error)) error {
+		if e.IsDir {
+			if !queueDir(e.Path) {
+				skipped++
+			}
+			return nil
+		}
+		if files[e.Path] || known[e.Path] || !queueDir(path.Dir(e.Path)) {
+			skipped++
+			return nil
+		}
+		data, err := readArchiveEntry(open, limits.MaxEntryBytes)
+		if err != nil {
+			return err
+		}
+		// Le quota a été vérifié sur les tailles annoncées : elles ne peuvent pas être dépassées.
+		if written += int64(len(data)); written > plan.Bytes {
+			return errArchiveTooLarge
+		}
+		files[e.Path] = true
+		nFiles++
+		batch = append(batch, pendingEntry{path: e.Path, name: e.Name, data: data})
+		if batchBytes += int64(len(data)); len(batch) >= archiveBatchEntries || batchBytes >= archiveBatchBytes {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		// Extraction partielle : on retire le dossier (et, en cascade, les lots déjà insérés).
+		if _, derr := h.dbex(ctx).Exec(`DELETE FROM drive_nodes WHERE id = $1`, rootID); derr != nil {
+			log.Printf("[drive] archive extract cleanup folder=%d: %v", rootID, derr)
+		}
+		if dbErr != nil {
+			if !writeQuotaError(c, dbErr) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": dbErr.Error()})
+			}
+			return
+		}
+		writeArchiveError(c, err, limits)
+		return
+	}
+	setQuotaLevelHeader(c, level)
+	kickContentWorkers()
+	c.JSON(http.StatusCreated, gin.H{
+		"folder_id": rootID,
+		"name":      folderName,
+		"format":    format,
+		"files":     nFiles,
+		"folders":   nFolders,
+		"bytes":     written,
+		"skipped":   skipped,
+	})
+}
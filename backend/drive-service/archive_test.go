@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type testArchiveFile struct {
+	name string
+	body string
+	dir  bool
+	link bool
+}
+
+func buildTestTar(t *testing.T, files []testArchiveFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.body)), Typeflag: tar.TypeReg}
+		switch {
+		case f.dir:
+			hdr.Typeflag, hdr.Size = tar.TypeDir, 0
+		case f.link:
+			hdr.Typeflag, hdr.Size, hdr.Linkname = tar.TypeSymlink, 0, "/etc/passwd"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			if _, err := tw.Write([]byte(f.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildTestZip(t *testing.T, files []testArchiveFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		name := f.name
+		if f.dir {
+			name += "/"
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll(b, nil)
+}
+
+var testArchiveFiles = []testArchiveFile{
+	{name: "docs", dir: true},
+	{name: "docs/readme.txt", body: "bonjour"},
+	{name: "../evil.txt", body: "x"},
+	{name: "/etc/shadow", body: "x"},
+	{name: "photos/2024/a.jpg", body: "jpeg"},
+}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	tarball := buildTestTar(t, []testArchiveFile{{name: "a.txt", body: "a"}})
+	cases := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"a.zip", buildTestZip(t, []testArchiveFile{{name: "a.txt", body: "a"}}), archiveZip},
+		{"a.tar", tarball, archiveTar},
+		{"a.bin", tarball, archiveTar},
+		{"a.tgz", gzipBytes(t, tarball), archiveTarGz},
+		{"a.tar.zst", zstdBytes(t, tarball), archiveTarZst},
+		{"a.7z", []byte("7z\xbc\xaf\x27\x1c\x00\x04"), ""},
+		{"a.txt", []byte("hello"), ""},
+	}
+	for _, tc := range cases {
+		if got := detectArchiveFormat(tc.name, tc.content); got != tc.want {
+			t.Errorf("detectArchiveFormat(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCleanArchivePath(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"docs/readme.txt", "docs/readme.txt", true},
+		{"docs/", "docs", true},
+		{"./a//b.txt", "a/b.txt", true},
+		{`win\dir\f.txt`, "win/dir/f.txt", true},
+		{"../evil", "", false},
+		{"a/../../evil", "", false},
+		{"/etc/passwd", "", false},
+		{"C:/Windows/x", "", false},
+		{"a\x00b", "", false},
+		{"", "", false},
+		{".", "", false},
+		{strings.Repeat("d/", archiveMaxDepth) + "f", "", false},
+	}
+	for _, tc := range cases {
+		got, ok := cleanArchivePath(tc.in)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("cleanArchivePath(%q) = %q, %v; want %q, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestArchiveFolderName(t *testing.T) {
+	cases := map[string]string{
+		"photos.zip":        "photos",
+		"backup.tar.gz":     "backup",
+		"Backup.TGZ":        "Backup",
+		"site.tar.zst":      "site",
+		"notes.tar":         "notes",
+		"archive":           "archive (extrait)",
+		".zip":              ".zip (extrait)",
+		"export-2024.7z.gz": "export-2024.7z",
+	}
+	for in, want := range cases {
+		if got := archiveFolderName(in); got != want {
+			t.Errorf("archiveFolderName(%q) = %q, want %q", in, got, want)
+		}
+	}
+	taken := map[string]bool{"photos": true, "photos (2)": true}
+	if got := uniqueSiblingName("photos", taken); got != "photos (3)" {
+		t.Errorf("uniqueSiblingName = %q", got)
+	}
+}
+
+func TestWalkArchiveFormats(t *testing.T) {
+	limits := archiveLimits{MaxEntries: 100, MaxTotalBytes: 1 << 20, MaxEntryBytes: 1 << 20, MaxRatio: 100}
+	tarball := buildTestTar(t, append(testArchiveFiles, testArchiveFile{name: "link", link: true}))
+	cases := []struct {
+		format      string
+		content     []byte
+		wantSkipped int
+	}{
+		{archiveTar, tarball, 3},
+		{archiveTarGz, gzipBytes(t, tarball), 3},
+		{archiveTarZst, zstdBytes(t, tarball), 3},
+		{archiveZip, buildTestZip(t, testArchiveFiles), 2},
+	}
+	for _, tc := range cases {
+		got := map[string]string{}
+		skipped, err := walkArchive(tc.content, tc.format, limits, func(e archiveEntry, open func() (io.Reader, error)) error {
+			if e.IsDir {
+				got[e.Path] = "/"
+				return nil
+			}
+			b, err := readArchiveEntry(open, limits.MaxEntryBytes)
+			got[e.Path] = string(b)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.format, err)
+		}
+		if skipped != tc.wantSkipped {
+			t.Errorf("%s: skipped = %d, want %d", tc.format, skipped, tc.wantSkipped)
+		}
+		if got["docs"] != "/" || got["docs/readme.txt"] != "bonjour" || got["photos/2024/a.jpg"] != "jpeg" || len(got) != 3 {
+			t.Errorf("%s: entries = %v", tc.format, got)
+		}
+	}
+}
+
+func TestPlanArchiveLimits(t *testing.T) {
+	big := strings.Repeat("a", 64<<10)
+	tarball := gzipBytes(t, buildTestTar(t, []testArchiveFile{{name: "a.txt", body: big}, {name: "b.txt", body: big}}))
+	generous := archiveLimits{MaxEntries: 10, MaxTotalBytes: 1 << 20, MaxEntryBytes: 1 << 20, MaxRatio: 1000}
+	plan, err := planArchive(tarball, archiveTarGz, generous)
+	if err != nil || plan.Files != 2 || plan.Bytes != int64(2*len(big)) {
+		t.Fatalf("planArchive = %+v, %v", plan, err)
+	}
+	for name, l := range map[string]archiveLimits{
+		"entries": {MaxEntries: 1, MaxTotalBytes: 1 << 20, MaxEntryBytes: 1 << 20, MaxRatio: 1000},
+		"entry":   {MaxEntries: 10, MaxTotalBytes: 1 << 20, MaxEntryBytes: 1 << 10, MaxRatio: 1000},
+		"total":   {MaxEntries: 10, MaxTotalBytes: 100 << 10, MaxEntryBytes: 1 << 20, MaxRatio: 1000},
+		"ratio":   {MaxEntries: 10, MaxTotalBytes: 1 << 20, MaxEntryBytes: 1 << 20, MaxRatio: 2},
+	} {
+		if _, err := planArchive(tarball, archiveTarGz, l); !errors.Is(err, errArchiveTooLarge) {
+			t.Errorf("%s limit: err = %v, want errArchiveTooLarge", name, err)
+		}
+	}
+}
+
+func TestArchiveLimitsFromEnv(t *testing.T) {
+	t.Setenv("DRIVE_ARCHIVE_MAX_ENTRIES", "50")
+	t.Setenv("DRIVE_ARCHIVE_MAX_BYTES", "")
+	t.Setenv("DRIVE_ARCHIVE_MAX_ENTRY_BYTES", "-1")
+	t.Setenv("DRIVE_ARCHIVE_MAX_RATIO", "20")
+	l := archiveLimitsFromEnv()
+	if l.MaxEntries != 50 || l.MaxTotalBytes != 1<<30 || l.MaxEntryBytes != 256<<20 || l.MaxRatio != 20 {
+		t.Errorf("archiveLimitsFromEnv = %+v", l)
+	}
+	if got := l.expandedBudget(1 << 10); got != 20<<10 {
+		t.Errorf("expandedBudget = %d", got)
+	}
+}
+
+func TestArchiveRoutesRequireAuth(t *testing.T) {
+	r := setupRouter(nil)
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/drive/nodes/1/archive/entries"},
+		{http.MethodGet, "/drive/nodes/1/archive/entry?path=a.txt"},
+		{http.MethodPost, "/drive/nodes/1/archive/extract"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without X-User-ID: got %d", tc.method, tc.path, w.Code)
+		}
+	}
+}
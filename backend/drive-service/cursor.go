@@ -0,0 +1,45 @@
+package main
+
+// cursor.go — pagination par curseur (keyset) : timeline Photos et listings de dossier.
+//
+// Le curseur est opaque pour le client (base64url d'un JSON désignant la dernière ligne servie) ;
+// la page suivante reprend strictement après cette ligne, sans OFFSET : pas de glissement quand
+// des fichiers sont ajoutés pendant le défilement, coût constant en profondeur (migration 57).
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// photoTimelineKeySQL — clé de tri de la timeline (même expression que photos-service).
+const photoTimelineKeySQL = `COALESCE(taken_at, created_at, TIMESTAMPTZ 'epoch')`
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func encodeCursor(v any) string {
+	raw, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(raw, v) != nil {
+		return errInvalidCursor
+	}
+	return nil
+}
+
+// photoTimelineCursor — dernière ligne servie : (clé de date, id) décroissants.
+type photoTimelineCursor struct {
+	At time.Time `json:"t"`
+	ID int       `json:"i"`
+}
+
+// nodeListCursor — dernière ligne d'un listing de dossier : dossiers d'abord, puis nom, id.
+type nodeListCursor struct {
+	Folder bool   `json:"f"`
+	Name   string `json:"n"`
+	ID     int    `json:"i"`
+}
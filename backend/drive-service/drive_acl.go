@@ -0,0 +1,400 @@
+package main
+
+// drive_acl.go — partage interne Drive entre utilisateurs / groupes d'un même tenant.
+//
+// Un partage (drive_node_shares) donne un rôle sur un nœud et tous ses descendants :
+// viewer < commenter < editor, le propriétaire ayant tous les droits. Le niveau
+// effectif est calculé en base par drive_node_access(node, user) (migration 49),
+// utilisé à la fois par les policies RLS et par les handlers (nodeAccessSQL).
+//
+// Les nœuds créés par un éditeur dans un dossier partagé appartiennent au
+// propriétaire du dossier : quota, unicité des noms et corbeille restent les siens.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	accessNone      = 0
+	accessViewer    = 1
+	accessCommenter = 2
+	accessEditor    = 3
+	accessOwner     = 4
+
+	shareRoleViewer    = "viewer"
+	shareRoleCommenter = "commenter"
+	shareRoleEditor    = "editor"
+)
+
+// shareRoleLevel convertit un rôle de partage en niveau d'accès (0 si inconnu).
+func shareRoleLevel(role string) int {
+	switch role {
+	case shareRoleViewer:
+		return accessViewer
+	case shareRoleCommenter:
+		return accessCommenter
+	case shareRoleEditor:
+		return accessEditor
+	}
+	return accessNone
+}
+
+// accessLevelRole est l'inverse de shareRoleLevel ("owner" pour le propriétaire).
+func accessLevelRole(level int) string {
+	switch level {
+	case accessViewer:
+		return shareRoleViewer
+	case accessCommenter:
+		return shareRoleCommenter
+	case accessEditor:
+		return shareRoleEditor
+	case accessOwner:
+		return "owner"
+	}
+	return ""
+}
+
+// nodeAccessSQL — prédicat SQL « l'utilisateur courant a au moins level sur col ».
+// Le sous-SELECT permet à Postgres de n'évaluer la fonction qu'une fois quand col est un paramètre.
+func nodeAccessSQL(col string, level int) string {
+	return fmt.Sprintf("(SELECT drive_node_access(%s, current_setting('app.current_user_id', true)::INTEGER)) >= %d", col, level)
+}
+
+// nodeAccess — niveau d'accès de l'utilisateur courant et propriétaire d'un nœud non supprimé.
+type nodeAccess struct {
+	Level    int
+	UserID   int
+	TenantID int
+	IsFolder bool
+}
+
+func (h *Handler) loadNodeAccess(ctx context.Context, id int) (nodeAccess, error) {
+	var a nodeAccess
+	err := h.dbex(ctx).QueryRow(`
+		SELECT drive_node_access(id, current_setting('app.current_user_id', true)::INTEGER), user_id, tenant_id, is_folder
+		FROM drive_nodes WHERE id = $1 AND deleted_at IS NULL
+	`, id).Scan(&a.Level, &a.UserID, &a.TenantID, &a.IsFolder)
+	return a, err
+}
+
+// requireNodeAccess écrit 404 si le nœud est absent ou invisible, 403 si le rôle est insuffisant.
+func (h *Handler) requireNodeAccess(c *gin.Context, id, level int) (nodeAccess, bool) {
+	a, err := h.loadNodeAccess(c.Request.Context(), id)
+	if err == sql.ErrNoRows || (err == nil && a.Level == accessNone) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return a, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return a, false
+	}
+	if a.Level < level {
+		c.JSON(http.StatusForbidden, gin.H{"error": "insufficient_permission", "role": accessLevelRole(a.Level), "required": accessLevelRole(level)})
+		return a, false
+	}
+	return a, true
+}
+
+type nodeMember struct {
+	ID        int     `json:"id"`
+	NodeID    int     `json:"node_id"`
+	UserID    *int    `json:"user_id,omitempty"`
+	UserEmail string  `json:"user_email,omitempty"`
+	GroupID   *int    `json:"group_id,omitempty"`
+	GroupName string  `json:"group_name,omitempty"`
+	Role      string  `json:"role"`
+	CreatedAt string  `json:"created_at"`
+	UpdatedAt *string `json:"updated_at,omitempty"`
+}
+
+// listNodeMembers — GET /drive/nodes/:id/permissions (tout utilisateur ayant accès au nœud).
+func (h *Handler) listNodeMembers(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusOK, []nodeMember{})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	a, ok := h.requireNodeAccess(c, id, accessViewer)
+	if !ok {
+		return
+	}
+	rows, err := h.dbex(c.Request.Context()).Query(`
+		SELECT s.id, s.node_id, s.grantee_user_id, COALESCE(u.email, ''), s.grantee_group_id, COALESCE(g.name, ''),
+			s.role, s.created_at::text, s.updated_at::text
+		FROM drive_node_shares s
+		LEFT JOIN users u ON u.id = s.grantee_user_id
+		LEFT JOIN user_groups g ON g.id = s.grantee_group_id
+		WHERE s.node_id = $1
+		ORDER BY s.created_at
+	`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	list := make([]nodeMember, 0)
+	for rows.Next() {
+		var m nodeMember
+		var uid, gid sql.NullInt64
+		var uat sql.NullString
+		if err := rows.Scan(&m.ID, &m.NodeID, &uid, &m.UserEmail, &gid, &m.GroupName, &m.Role, &m.CreatedAt, &uat); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if uid.Valid {
+			v := int(uid.Int64)
+			m.UserID = &v
+		}
+		if gid.Valid {
+			v := int(gid.Int64)
+			m.GroupID = &v
+		}
+		if uat.Valid {
+			m.UpdatedAt = &uat.String
+		}
+		list = append(list, m)
+	}
+	c.JSON(http.StatusOK, gin.H{"role": accessLevelRole(a.Level), "owner_user_id": a.UserID, "members": list})
+}
+
+// addNodeMember — POST /drive/nodes/:id/permissions (propriétaire uniquement).
+// Body : {"user_id": 12 | "email": "...", "group_id": 3, "role": "viewer"|"commenter"|"editor"}
+// Un partage existant pour le même destinataire est mis à jour (upsert).
+func (h *Handler) addNodeMember(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var body struct {
+		UserID  int    `json:"user_id"`
+		Email   string `json:"email"`
+		GroupID int    `json:"group_id"`
+		Role    string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	role := strings.ToLower(strings.TrimSpace(body.Role))
+	if role == "" {
+		role = shareRoleViewer
+	}
+	if shareRoleLevel(role) == accessNone {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be viewer, commenter or editor"})
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(body.Email))
+	byUser := body.UserID > 0 || email != ""
+	if byUser == (body.GroupID > 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of user_id/email or group_id required"})
+		return
+	}
+	if h.rejectPhotosRootMutation(c, id) {
+		return
+	}
+	a, ok := h.requireNodeAccess(c, id, accessOwner)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	var shareID int
+	if byUser {
+		granteeID := body.UserID
+		err = h.dbex(ctx).QueryRow(`
+			SELECT id FROM users
+			WHERE tenant_id = $1 AND (id = $2 OR ($3 <> '' AND LOWER(email) = $3)) AND is_active = true
+			LIMIT 1
+		`, a.TenantID, body.UserID, email).Scan(&granteeID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found in tenant"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if granteeID == a.UserID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot share with the owner"})
+			return
+		}
+		err = h.dbex(ctx).QueryRow(`
+			INSERT INTO drive_node_shares (node_id, tenant_id, owner_user_id, grantee_user_id, role)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (node_id, grantee_user_id) WHERE grantee_user_id IS NOT NULL
+			DO UPDATE SET role = EXCLUDED.role
+			RETURNING id
+		`, id, a.TenantID, a.UserID, granteeID, role).Scan(&shareID)
+	} else {
+		var exists bool
+		if err := h.dbex(ctx).QueryRow(`SELECT EXISTS (SELECT 1 FROM user_groups WHERE id = $1 AND tenant_id = $2)`, body.GroupID, a.TenantID).Scan(&exists); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "group not found in tenant"})
+			return
+		}
+		err = h.dbex(ctx).QueryRow(`
+			INSERT INTO drive_node_shares (node_id, tenant_id, owner_user_id, grantee_group_id, role)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (node_id, grantee_group_id) WHERE grantee_group_id IS NOT NULL
+			DO UPDATE SET role = EXCLUDED.role
+			RETURNING id
+		`, id, a.TenantID, a.UserID, body.GroupID, role).Scan(&shareID)
+	}
+	if err != nil {
+		var perr *pq.Error
+		if errors.As(err, &perr) && perr.Code == "23503" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid grantee"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"id": shareID, "node_id": id, "role": role})
+}
+
+// updateNodeMember — PUT /drive/nodes/:id/permissions/:permId {"role": "..."} (propriétaire uniquement).
+func (h *Handler) updateNodeMember(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	permID, perr := strconv.Atoi(c.Param("permId"))
+	if err != nil || perr != nil || id <= 0 || permID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	role := strings.ToLower(strings.TrimSpace(body.Role))
+	if shareRoleLevel(role) == accessNone {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be viewer, commenter or editor"})
+		return
+	}
+	if _, ok := h.requireNodeAccess(c, id, accessOwner); !ok {
+		return
+	}
+	res, err := h.dbex(c.Request.Context()).Exec(`UPDATE drive_node_shares SET role = $1 WHERE id = $2 AND node_id = $3`, role, permID, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if aff, _ := res.RowsAffected(); aff == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": permID, "node_id": id, "role": role})
+}
+
+// removeNodeMember — DELETE /drive/nodes/:id/permissions/:permId.
+// Le propriétaire retire n'importe quel partage ; un destinataire direct peut quitter le sien.
+func (h *Handler) removeNodeMember(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	permID, perr := strconv.Atoi(c.Param("permId"))
+	if err != nil || perr != nil || id <= 0 || permID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := h.requireNodeAccess(c, id, accessViewer); !ok {
+		return
+	}
+	res, err := h.dbex(c.Request.Context()).Exec(`
+		DELETE FROM drive_node_shares
+		WHERE id = $1 AND node_id = $2
+		  AND (owner_user_id = current_setting('app.current_user_id', true)::INTEGER
+		       OR grantee_user_id = current_setting('app.current_user_id', true)::INTEGER)
+	`, permID, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if aff, _ := res.RowsAffected(); aff == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// listSharedWithMe — GET /drive/shared-with-me : nœuds partagés directement avec l'utilisateur
+// ou l'un de ses groupes (meilleur rôle si plusieurs partages). La navigation dans un dossier
+// partagé passe ensuite par GET /drive/nodes?parent_id=.
+func (h *Handler) listSharedWithMe(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusOK, []Node{})
+		return
+	}
+	rows, err := h.dbex(c.Request.Context()).Query(`
+		SELECT n.id, n.tenant_id, n.user_id, n.parent_id, n.name, n.is_folder, n.size, n.mime_type, n.created_at::text, COALESCE(n.updated_at::text, ''),
+			n.vault_encrypted, n.is_vault_folder, COALESCE(u.email, ''),
+			MAX(CASE s.role WHEN 'editor' THEN 3 WHEN 'commenter' THEN 2 ELSE 1 END)
+		FROM drive_node_shares s
+		INNER JOIN drive_nodes n ON n.id = s.node_id
+		LEFT JOIN users u ON u.id = n.user_id
+		WHERE n.deleted_at IS NULL
+		  AND n.user_id <> current_setting('app.current_user_id', true)::INTEGER
+		  AND (
+		    s.grantee_user_id = current_setting('app.current_user_id', true)::INTEGER
+		    OR s.grantee_group_id IN (
+		      SELECT group_id FROM user_group_members WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
+		    )
+		  )
+		GROUP BY n.id, u.email
+		ORDER BY n.is_folder DESC, n.name
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	list := make([]Node, 0)
+	for rows.Next() {
+		var n Node
+		var pid sql.NullInt64
+		var mime sql.NullString
+		var level int
+		if err := rows.Scan(&n.ID, &n.TenantID, &n.UserID, &pid, &n.Name, &n.IsFolder, &n.Size, &mime, &n.CreatedAt, &n.UpdatedAt,
+			&n.VaultEncrypted, &n.IsVaultFolder, &n.OwnerEmail, &level); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if pid.Valid {
+			p := int(pid.Int64)
+			n.ParentID = &p
+		}
+		if mime.Valid {
+			n.MimeType = &mime.String
+		}
+		n.SharedRole = accessLevelRole(level)
+		list = append(list, n)
+	}
+	c.JSON(http.StatusOK, list)
+}
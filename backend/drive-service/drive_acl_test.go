@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestShareRoleLevel(t *testing.T) {
+	for _, role := range []string{shareRoleViewer, shareRoleCommenter, shareRoleEditor} {
+		level := shareRoleLevel(role)
+		if level == accessNone {
+			t.Fatalf("role %q should be valid", role)
+		}
+		if got := accessLevelRole(level); got != role {
+			t.Fatalf("accessLevelRole(%d) = %q, want %q", level, got, role)
+		}
+	}
+	if shareRoleLevel("owner") != accessNone || shareRoleLevel("") != accessNone {
+		t.Fatal("owner/empty must not be grantable roles")
+	}
+	if !(accessViewer < accessCommenter && accessCommenter < accessEditor && accessEditor < accessOwner) {
+		t.Fatal("access levels must be ordered viewer < commenter < editor < owner")
+	}
+}
+
+func TestNodeAccessSQL(t *testing.T) {
+	got := nodeAccessSQL("$1", accessEditor)
+	if !strings.Contains(got, "drive_node_access($1, current_setting('app.current_user_id', true)::INTEGER)") || !strings.HasSuffix(got, ">= 3") {
+		t.Fatalf("nodeAccessSQL = %q", got)
+	}
+}
+
+func TestInternalSharingRequiresAuth(t *testing.T) {
+	r := setupRouter(nil)
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/drive/shared-with-me"},
+		{http.MethodGet, "/drive/nodes/1/permissions"},
+		{http.MethodPost, "/drive/nodes/1/permissions"},
+		{http.MethodDelete, "/drive/nodes/1/permissions/2"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without X-User-ID: got %d", tc.method, tc.path, w.Code)
+		}
+	}
+}
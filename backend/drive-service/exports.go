@@ -0,0 +1,674 @@
+package main
+
+// exports.go — exports ZIP asynchrones (table drive_export_jobs, migration 59).
+//
+// GET /drive/nodes/:id/zip et POST /drive/nodes/archive construisent l'archive en mémoire
+// pendant la requête, ce qui ne tient pas pour un gros dossier (délai de la gateway).
+// POST /drive/exports crée un job ; le worker l'exécute sur une connexion épinglée à
+// l'utilisateur (mêmes droits que la requête : nodeAccessSQL, RLS), écrit le ZIP dans
+// DRIVE_EXPORT_DIR et met à jour la progression. GET /drive/exports/:id pour suivre,
+// GET /drive/exports/:id/download pour récupérer (Range accepté). Fichier et job expirent
+// après DRIVE_EXPORT_TTL_HOURS. DRIVE_EXPORT_NOTIFY_URL (optionnel) reçoit un POST JSON
+// quand un job demandé avec "notify": true est prêt, signé par DRIVE_EXPORT_NOTIFY_SECRET.
+//
+// DRIVE_EXPORT_DIR doit être partagé entre les instances si le service est répliqué.
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	exportStatusReady = "ready"
+
+	exportMaxNodes       = 100
+	exportMaxActive      = 3
+	exportStaleAfter     = 10 * time.Minute
+	exportProgressPeriod = time.Second
+)
+
+var errExportCanceled = errors.New("export job canceled")
+
+// exportKick réveille le worker d'export à la création d'un job (envoi non bloquant).
+var exportKick = make(chan struct{}, 1)
+
+func kickExportWorker() {
+	select {
+	case exportKick <- struct{}{}:
+	default:
+	}
+}
+
+// exportDir — répertoire des archives (DRIVE_EXPORT_DIR, sinon <tmp>/cloudity-drive-exports).
+func exportDir() string {
+	if v := strings.TrimSpace(os.Getenv("DRIVE_EXPORT_DIR")); v != "" {
+		return v
+	}
+	return filepath.Join(os.TempDir(), "cloudity-drive-exports")
+}
+
+// exportTTL — durée de vie d'un export (DRIVE_EXPORT_TTL_HOURS, 24 h par défaut).
+func exportTTL() time.Duration {
+	if n, err := strconv.Atoi(os.Getenv("DRIVE_EXPORT_TTL_HOURS")); err == nil && n > 0 {
+		return time.Duration(n) * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// exportMaxBytes — taille cumulée maximale des fichiers d'un export (DRIVE_EXPORT_MAX_BYTES, 20 Gio).
+func exportMaxBytes() int64 {
+	if v := quotaBytesFromEnv("DRIVE_EXPORT_MAX_BYTES"); v > 0 {
+		return v
+	}
+	return 20 << 30
+}
+
+// exportZipName — nom du fichier téléchargé : dossier unique → « dossier.zip », sinon « archive.zip ».
+func exportZipName(rootNames []string, singleFolder bool) string {
+	if len(rootNames) == 1 && singleFolder {
+		return strings.TrimSuffix(rootNames[0], ".zip") + ".zip"
+	}
+	return "archive.zip"
+}
+
+// safeZipName — segment de chemin dans l'archive (même règle que addFolderToZip).
+func safeZipName(name string) string {
+	s := path.Base(name)
+	if s == "" || s == "." || s == "/" || s == ".." {
+		return "file"
+	}
+	return s
+}
+
+type exportJob struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	Notify     bool       `json:"notify"`
+	TotalFiles int        `json:"total_files"`
+	DoneFiles  int        `json:"done_files"`
+	TotalBytes int64      `json:"total_bytes"`
+	DoneBytes  int64      `json:"done_bytes"`
+	SizeBytes  int64      `json:"size_bytes"`
+	Progress   int        `json:"progress"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+}
+
+const exportJobColumns = `id, name, status, notify, total_files, done_files, total_bytes, done_bytes, size_bytes,
+	COALESCE(error, ''), created_at, finished_at, expires_at`
+
+// scanExportJob lit exportJobColumns puis les colonnes supplémentaires dans extra.
+func scanExportJob(row interface{ Scan(...any) error }, extra ...any) (exportJob, error) {
+	var j exportJob
+	var finished sql.NullTime
+	err := row.Scan(append([]any{&j.ID, &j.Name, &j.Status, &j.Notify, &j.TotalFiles, &j.DoneFiles, &j.TotalBytes, &j.DoneBytes,
+		&j.SizeBytes, &j.Error, &j.CreatedAt, &finished, &j.ExpiresAt}, extra...)...)
+	if finished.Valid {
+		j.FinishedAt = &finished.Time
+	}
+	j.Progress = exportProgress(j)
+	return j, err
+}
+
+// exportProgress — avancement en pourcentage, pondéré par les octets (par fichiers si tous vides).
+func exportProgress(j exportJob) int {
+	switch {
+	case j.Status == exportStatusReady:
+		return 100
+	case j.TotalBytes > 0:
+		return int(min(j.DoneBytes*100/j.TotalBytes, 99))
+	case j.TotalFiles > 0:
+		return min(j.DoneFiles*100/j.TotalFiles, 99)
+	}
+	return 0
+}
+
+// createExportJob — POST /drive/exports {"node_ids": [...], "notify": bool} → 202 + job.
+func (h *Handler) createExportJob(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	var body struct {
+		NodeIDs []int `json:"node_ids"`
+		Notify  bool  `json:"notify"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || len(body.NodeIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "node_ids array required"})
+		return
+	}
+	if len(body.NodeIDs) > exportMaxNodes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max 100 nodes"})
+		return
+	}
+	ctx := c.Request.Context()
+	rows, err := h.dbex(ctx).Query(`
+		SELECT id, name, is_folder FROM drive_nodes
+		WHERE id = ANY($1) AND deleted_at IS NULL AND photo_locked_at IS NULL AND `+nodeAccessSQL("id", accessViewer)+`
+	`, pq.Array(body.NodeIDs))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var ids []int
+	var names []string
+	folders := 0
+	for rows.Next() {
+		var id int
+		var name string
+		var isFolder bool
+		if err := rows.Scan(&id, &name, &isFolder); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		ids, names = append(ids, id), append(names, name)
+		if isFolder {
+			folders++
+		}
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	var active int
+	if err := h.dbex(ctx).QueryRow(`
+		SELECT COUNT(*) FROM drive_export_jobs
+		WHERE user_id = current_setting('app.current_user_id', true)::INTEGER AND status IN ('pending', 'running')
+	`).Scan(&active); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if active >= exportMaxActive {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too_many_exports", "max_active": exportMaxActive})
+		return
+	}
+	id, err := newShareToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	_, tid := requestUserTenant(c)
+	job, err := scanExportJob(h.dbex(ctx).QueryRow(`
+		INSERT INTO drive_export_jobs (id, user_id, tenant_id, node_ids, name, notify, expires_at)
+		VALUES ($1, current_setting('app.current_user_id', true)::INTEGER, $2, $3, $4, $5, now() + make_interval(secs => $6))
+		RETURNING `+exportJobColumns, id, tid, pq.Array(ids), exportZipName(names, folders == 1), body.Notify, exportTTL().Seconds()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	kickExportWorker()
+	c.Header("Location", "/drive/exports/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// listExportJobs — GET /drive/exports : exports non expirés de l'utilisateur, du plus récent au plus ancien.
+func (h *Handler) listExportJobs(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusOK, gin.H{"exports": []exportJob{}})
+		return
+	}
+	rows, err := h.dbex(c.Request.Context()).Query(`
+		SELECT ` + exportJobColumns + ` FROM drive_export_jobs
+		WHERE user_id = current_setting('app.current_user_id', true)::INTEGER AND expires_at > now()
+		ORDER BY created_at DESC
+		LIMIT 50
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	jobs := make([]exportJob, 0)
+	for rows.Next() {
+		j, err := scanExportJob(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		jobs = append(jobs, j)
+	}
+	c.JSON(http.StatusOK, gin.H{"exports": jobs})
+}
+
+// loadExportJob lit un job de l'utilisateur (file_path en plus) ; écrit la réponse d'erreur.
+func (h *Handler) loadExportJob(c *gin.Context) (exportJob, string, bool) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return exportJob{}, "", false
+	}
+	var filePath sql.NullString
+	j, err := scanExportJob(h.dbex(c.Request.Context()).QueryRow(`
+		SELECT `+exportJobColumns+`, file_path FROM drive_export_jobs
+		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND expires_at > now()
+	`, c.Param("id")), &filePath)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return exportJob{}, "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return exportJob{}, "", false
+	}
+	return j, filePath.String, true
+}
+
+// getExportJob — GET /drive/exports/:id : statut et progression.
+func (h *Handler) getExportJob(c *gin.Context) {
+	j, _, ok := h.loadExportJob(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, j)
+}
+
+// downloadExportJob — GET /drive/exports/:id/download : archive prête (409 sinon).
+func (h *Handler) downloadExportJob(c *gin.Context) {
+	j, filePath, ok := h.loadExportJob(c)
+	if !ok {
+		return
+	}
+	if j.Status != exportStatusReady || filePath == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "export not ready", "status": j.Status})
+		return
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "export file missing"})
+		return
+	}
+	defer f.Close()
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="`+dispositionFilename(j.Name)+`"`)
+	c.Header("Cache-Control", "private, no-store")
+	modTime := j.CreatedAt
+	if j.FinishedAt != nil {
+		modTime = *j.FinishedAt
+	}
+	http.ServeContent(c.Writer, c.Request, j.Name, modTime, f)
+}
+
+// deleteExportJob — DELETE /drive/exports/:id : annule (le worker s'arrête au prochain point
+// de progression) ou supprime un export et son fichier.
+func (h *Handler) deleteExportJob(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	var filePath sql.NullString
+	err := h.dbex(c.Request.Context()).QueryRow(`
+		DELETE FROM drive_export_jobs
+		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER
+		RETURNING file_path
+	`, c.Param("id")).Scan(&filePath)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	removeExportFile(filePath.String)
+	c.Status(http.StatusNoContent)
+}
+
+func removeExportFile(p string) {
+	if p == "" {
+		return
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		log.Printf("[drive] export cleanup %s: %v", p, err)
+	}
+}
+
+// startExportWorker traite la file d'exports et purge les exports expirés.
+func (h *Handler) startExportWorker() {
+	if err := os.MkdirAll(exportDir(), 0o700); err != nil {
+		log.Printf("[drive] export worker disabled: %v", err)
+		return
+	}
+	tk := time.NewTicker(time.Minute)
+	defer tk.Stop()
+	// Worker async sans request HTTP : la conn est épinglée à l'utilisateur de chaque job.
+	ctx := context.Background()
+	for {
+		h.purgeExpiredExports(ctx)
+		for {
+			ran, err := h.runNextExport(ctx)
+			if err != nil {
+				log.Printf("[drive] export worker: %v", err)
+				break
+			}
+			if !ran {
+				break
+			}
+		}
+		select {
+		case <-tk.C:
+		case <-exportKick:
+		}
+	}
+}
+
+func (h *Handler) purgeExpiredExports(ctx context.Context) {
+	rows, err := h.dbex(ctx).Query(`DELETE FROM drive_export_jobs WHERE expires_at <= now() RETURNING COALESCE(file_path, '')`)
+	if err != nil {
+		log.Printf("[drive] export purge: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p string
+		if rows.Scan(&p) == nil {
+			removeExportFile(p)
+		}
+	}
+}
+
+// runNextExport réserve le plus ancien job en attente (ou abandonné) et l'exécute.
+func (h *Handler) runNextExport(ctx context.Context) (bool, error) {
+	var id, name string
+	var uid int
+	var notify bool
+	var nodeIDs pq.Int64Array
+	err := h.dbex(ctx).QueryRow(`
+		UPDATE drive_export_jobs SET status = 'running', started_at = now(), updated_at = now(),
+		       done_files = 0, done_bytes = 0
+		WHERE id = (
+			SELECT id FROM drive_export_jobs
+			WHERE expires_at > now()
+			  AND (status = 'pending' OR (status = 'running' AND updated_at < now() - make_interval(secs => $1)))
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, name, notify, node_ids
+	`, exportStaleAfter.Seconds()).Scan(&id, &uid, &name, &notify, &nodeIDs)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	filePath, size, err := h.buildExport(ctx, id, uid, nodeIDs)
+	if errors.Is(err, errExportCanceled) {
+		return true, nil
+	}
+	if err != nil {
+		log.Printf("[drive] export %s: %v", id, err)
+		if _, uerr := h.dbex(ctx).Exec(`
+			UPDATE drive_export_jobs SET status = 'failed', error = $2, finished_at = now(), updated_at = now()
+			WHERE id = $1
+		`, id, err.Error()); uerr != nil {
+			return true, uerr
+		}
+		return true, nil
+	}
+	var expiresAt time.Time
+	err = h.dbex(ctx).QueryRow(`
+		UPDATE drive_export_jobs
+		SET status = 'ready', file_path = $2, size_bytes = $3, finished_at = now(), updated_at = now(),
+		    expires_at = now() + make_interval(secs => $4)
+		WHERE id = $1
+		RETURNING expires_at
+	`, id, filePath, size, exportTTL().Seconds()).Scan(&expiresAt)
+	if err != nil {
+		// Job supprimé pendant la finalisation : le fichier n'a plus de propriétaire.
+		removeExportFile(filePath)
+		if err == sql.ErrNoRows {
+			return true, nil
+		}
+		return true, err
+	}
+	if notify {
+		notifyExportReady(ctx, id, uid, name, size, expiresAt)
+	}
+	return true, nil
+}
+
+// exportFile — fichier à placer dans l'archive.
+type exportFile struct {
+	id   int
+	path string
+	size int64
+}
+
+// buildExport écrit le ZIP du job sur disque ; renvoie son chemin et sa taille.
+func (h *Handler) buildExport(ctx context.Context, jobID string, uid int, nodeIDs []int64) (string, int64, error) {
+	conn, err := h.db.Conn(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT set_config('app.current_user_id', $1, false)", uid); err != nil {
+		return "", 0, err
+	}
+	// Mêmes droits qu'à la création : un partage révoqué entre-temps n'est plus exporté.
+	uctx := withPinnedConn(ctx, &pinnedConn{conn: conn, ctx: ctx})
+	files, err := h.listExportFiles(uctx, nodeIDs)
+	if err != nil {
+		return "", 0, err
+	}
+	var totalBytes int64
+	for _, f := range files {
+		totalBytes += f.size
+	}
+	if max := exportMaxBytes(); totalBytes > max {
+		return "", 0, fmt.Errorf("export too large: %d bytes (max %d)", totalBytes, max)
+	}
+	if err := h.exportProgressUpdate(ctx, jobID, `total_files = $2, total_bytes = $3`, len(files), totalBytes); err != nil {
+		return "", 0, err
+	}
+
+	out, err := os.CreateTemp(exportDir(), "export-*.zip")
+	if err != nil {
+		return "", 0, err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			out.Close()
+			removeExportFile(out.Name())
+		}
+	}()
+	w := zip.NewWriter(out)
+	var doneFiles int
+	var doneBytes int64
+	last := time.Now()
+	for _, f := range files {
+		var content []byte
+		err := h.dbex(uctx).QueryRow(`
+			SELECT COALESCE(content, ''::bytea) FROM drive_nodes WHERE id = $1 AND deleted_at IS NULL
+		`, f.id).Scan(&content)
+		if err == sql.ErrNoRows {
+			// Supprimé pendant l'export : ignoré.
+			continue
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: f.path, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return "", 0, err
+		}
+		if _, err := fw.Write(content); err != nil {
+			return "", 0, err
+		}
+		doneFiles++
+		doneBytes += f.size
+		if time.Since(last) >= exportProgressPeriod {
+			last = time.Now()
+			if err := h.exportProgressUpdate(ctx, jobID, `done_files = $2, done_bytes = $3`, doneFiles, doneBytes); err != nil {
+				return "", 0, err
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", 0, err
+	}
+	st, err := out.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	if err := out.Close(); err != nil {
+		return "", 0, err
+	}
+	ok = true
+	return out.Name(), st.Size(), nil
+}
+
+// exportProgressUpdate met à jour la progression (battement de cœur) ; errExportCanceled si le job a disparu.
+func (h *Handler) exportProgressUpdate(ctx context.Context, jobID, set string, args ...any) error {
+	res, err := h.dbex(ctx).Exec(`
+		UPDATE drive_export_jobs SET `+set+`, updated_at = now() WHERE id = $1 AND status = 'running'
+	`, append([]any{jobID}, args...)...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errExportCanceled
+	}
+	return nil
+}
+
+// listExportFiles résout les racines accessibles puis parcourt leurs descendants (sans contenu).
+func (h *Handler) listExportFiles(ctx context.Context, nodeIDs []int64) ([]exportFile, error) {
+	var files []exportFile
+	seen := make(map[int64]bool)
+	for _, nodeID := range nodeIDs {
+		if seen[nodeID] {
+			continue
+		}
+		seen[nodeID] = true
+		var name string
+		var isFolder bool
+		var size int64
+		err := h.dbex(ctx).QueryRow(`
+			SELECT name, is_folder, size FROM drive_nodes
+			WHERE id = $1 AND deleted_at IS NULL AND photo_locked_at IS NULL AND `+nodeAccessSQL("id", accessViewer)+`
+		`, nodeID).Scan(&name, &isFolder, &size)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !isFolder {
+			files = append(files, exportFile{id: int(nodeID), path: safeZipName(name), size: size})
+			continue
+		}
+		if files, err = h.appendExportFolder(ctx, files, int(nodeID), safeZipName(name)+"/"); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func (h *Handler) appendExportFolder(ctx context.Context, files []exportFile, folderID int, prefix string) ([]exportFile, error) {
+	rows, err := h.dbex(ctx).Query(`
+		SELECT id, name, is_folder, size FROM drive_nodes
+		WHERE parent_id = $1 AND deleted_at IS NULL AND photo_locked_at IS NULL
+		ORDER BY is_folder, name, id
+	`, folderID)
+	if err != nil {
+		return nil, err
+	}
+	type child struct {
+		id       int
+		name     string
+		isFolder bool
+		size     int64
+	}
+	var children []child
+	for rows.Next() {
+		var ch child
+		if err := rows.Scan(&ch.id, &ch.name, &ch.isFolder, &ch.size); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		children = append(children, ch)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, ch := range children {
+		p := prefix + safeZipName(ch.name)
+		if ch.isFolder {
+			if files, err = h.appendExportFolder(ctx, files, ch.id, p+"/"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		files = append(files, exportFile{id: ch.id, path: p, size: ch.size})
+	}
+	return files, nil
+}
+
+// exportNotifySignature — HMAC-SHA256 hex du corps (en-tête X-Cloudity-Signature).
+func exportNotifySignature(secret string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// notifyExportReady envoie l'événement drive.export.ready à DRIVE_EXPORT_NOTIFY_URL (si configurée).
+func notifyExportReady(ctx context.Context, id string, uid int, name string, size int64, expiresAt time.Time) {
+	url := strings.TrimSpace(os.Getenv("DRIVE_EXPORT_NOTIFY_URL"))
+	if url == "" {
+		return
+	}
+	body, _ := json.Marshal(gin.H{
+		"event":        "drive.export.ready",
+		"export_id":    id,
+		"user_id":      uid,
+		"name":         name,
+		"size_bytes":   size,
+		"expires_at":   expiresAt,
+		"download_url": "/drive/exports/" + id + "/download",
+	})
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[drive] export notify: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret := os.Getenv("DRIVE_EXPORT_NOTIFY_SECRET"); secret != "" {
+		req.Header.Set("X-Cloudity-Signature", "sha256="+exportNotifySignature(secret, body))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("[drive] export notify: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[drive] export notify: HTTP %d", resp.StatusCode)
+	}
+}
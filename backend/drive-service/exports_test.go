@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExportZipName(t *testing.T) {
+	cases := []struct {
+		names  []string
+		folder bool
+		want   string
+	}{
+		{[]string{"Photos"}, true, "Photos.zip"},
+		{[]string{"Backup.zip"}, true, "Backup.zip"},
+		{[]string{"a.txt"}, false, "archive.zip"},
+		{[]string{"A", "B"}, false, "archive.zip"},
+	}
+	for _, tc := range cases {
+		if got := exportZipName(tc.names, tc.folder); got != tc.want {
+			t.Errorf("exportZipName(%v, %v) = %q, want %q", tc.names, tc.folder, got, tc.want)
+		}
+	}
+	for in, want := range map[string]string{"a.txt": "a.txt", "../x": "x", "": "file", "..": "file", "/": "file"} {
+		if got := safeZipName(in); got != want {
+			t.Errorf("safeZipName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestExportProgress(t *testing.T) {
+	cases := []struct {
+		job  exportJob
+		want int
+	}{
+		{exportJob{Status: "pending"}, 0},
+		{exportJob{Status: "running", TotalBytes: 200, DoneBytes: 50}, 25},
+		{exportJob{Status: "running", TotalBytes: 200, DoneBytes: 200}, 99},
+		{exportJob{Status: "running", TotalFiles: 4, DoneFiles: 1}, 25},
+		{exportJob{Status: exportStatusReady, TotalBytes: 200}, 100},
+	}
+	for _, tc := range cases {
+		if got := exportProgress(tc.job); got != tc.want {
+			t.Errorf("exportProgress(%+v) = %d, want %d", tc.job, got, tc.want)
+		}
+	}
+}
+
+func TestExportTTL(t *testing.T) {
+	t.Setenv("DRIVE_EXPORT_TTL_HOURS", "")
+	if got := exportTTL(); got != 24*time.Hour {
+		t.Errorf("default TTL = %v", got)
+	}
+	t.Setenv("DRIVE_EXPORT_TTL_HOURS", "2")
+	if got := exportTTL(); got != 2*time.Hour {
+		t.Errorf("TTL = %v", got)
+	}
+	t.Setenv("DRIVE_EXPORT_TTL_HOURS", "0")
+	if got := exportTTL(); got != 24*time.Hour {
+		t.Errorf("TTL(0) = %v, want default", got)
+	}
+}
+
+func TestNotifyExportReady(t *testing.T) {
+	var gotBody []byte
+	var gotSig string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotSig = r.Header.Get("X-Cloudity-Signature")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	t.Setenv("DRIVE_EXPORT_NOTIFY_URL", srv.URL)
+	t.Setenv("DRIVE_EXPORT_NOTIFY_SECRET", "s3cret")
+	notifyExportReady(context.Background(), "job1", 7, "Photos.zip", 1234, time.Unix(0, 0).UTC())
+	var payload map[string]any
+	if err := json.Unmarshal(gotBody, &payload); err != nil {
+		t.Fatalf("payload: %v (%q)", err, gotBody)
+	}
+	if payload["event"] != "drive.export.ready" || payload["export_id"] != "job1" || payload["download_url"] != "/drive/exports/job1/download" {
+		t.Errorf("payload = %v", payload)
+	}
+	if want := "sha256=" + exportNotifySignature("s3cret", gotBody); gotSig != want {
+		t.Errorf("signature = %q, want %q", gotSig, want)
+	}
+}
+
+func TestExportRoutesRequireAuth(t *testing.T) {
+	r := setupRouter(nil)
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodPost, "/drive/exports"},
+		{http.MethodGet, "/drive/exports"},
+		{http.MethodGet, "/drive/exports/abc"},
+		{http.MethodGet, "/drive/exports/abc/download"},
+		{http.MethodDelete, "/drive/exports/abc"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without X-User-ID: got %d", tc.method, tc.path, w.Code)
+		}
+	}
+}
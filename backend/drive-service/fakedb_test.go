@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+)
+
+// fakeDB — pilote database/sql scripté pour les tests sans PostgreSQL : chaque requête
+// est passée à handle, qui décide des lignes renvoyées ; les requêtes sont journalisées.
+type fakeDB struct {
+	mu      sync.Mutex
+	queries []string
+	handle  func(query string, args []driver.Value) fakeResult
+}
+
+// fakeResult — colonnes + lignes pour un SELECT, affected pour un Exec, ou err.
+type fakeResult struct {
+	cols     []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+}
+
+func fakeRow(vals ...driver.Value) fakeResult {
+	cols := make([]string, len(vals))
+	for i := range cols {
+		cols[i] = "c"
+	}
+	return fakeResult{cols: cols, rows: [][]driver.Value{vals}}
+}
+
+func newFakeDB(handle func(query string, args []driver.Value) fakeResult) (*sql.DB, *fakeDB) {
+	f := &fakeDB{handle: handle}
+	return sql.OpenDB(f), f
+}
+
+// ran — vrai si une requête journalisée contient toutes les sous-chaînes données.
+func (f *fakeDB) ran(parts ...string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, q := range f.queries {
+		all := true
+		for _, p := range parts {
+			if !strings.Contains(q, p) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeDB) run(query string, args []driver.NamedValue) fakeResult {
+	f.mu.Lock()
+	f.queries = append(f.queries, query)
+	f.mu.Unlock()
+	vals := make([]driver.Value, len(args))
+	for i, a := range args {
+		vals[i] = a.Value
+	}
+	return f.handle(query, vals)
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{f} }
+
+type fakeDriver struct{ f *fakeDB }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{d.f}, nil }
+
+type fakeConn struct{ f *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.f, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	r := c.f.run(query, args)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &fakeRows{cols: r.cols, rows: r.rows}, nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	r := c.f.run(query, args)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return driver.RowsAffected(r.affected), nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	f     *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return fakeConn{s.f}.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return fakeConn{s.f}.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	out := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		out[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return out
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+	pos  int
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.pos])
+	r.pos++
+	return nil
+}
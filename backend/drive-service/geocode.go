@@ -0,0 +1,174 @@
+package main
+
+// geocode.go — géocodage inverse hors ligne (aucune API externe) pour les lieux Photos.
+//
+// Jeu embarqué geodata/places.tsv (villes et sites principaux) ; DRIVE_GEONAMES_FILE permet
+// de le remplacer par un export GeoNames (cities500/1000/5000/15000.txt, format TSV officiel)
+// pour une précision communale. Recherche du lieu le plus proche par grille de 1°.
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed geodata/places.tsv
+var embeddedPlaces string
+
+type geoPlace struct {
+	Name    string
+	Country string
+	Admin   string
+	Lat     float64
+	Lon     float64
+}
+
+type geoIndex struct {
+	cells map[[2]int][]geoPlace
+	size  int
+}
+
+// geoCell — maille de 1° (longitude ramenée dans [-180, 180[).
+func geoCell(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat)), int(math.Floor(lon))}
+}
+
+func newGeoIndex(places []geoPlace) *geoIndex {
+	idx := &geoIndex{cells: make(map[[2]int][]geoPlace), size: len(places)}
+	for _, p := range places {
+		k := geoCell(p.Lat, p.Lon)
+		idx.cells[k] = append(idx.cells[k], p)
+	}
+	return idx
+}
+
+// haversineKm — distance orthodromique en kilomètres.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const r = 6371.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * r * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// nearest renvoie le lieu le plus proche à moins de maxKm. Les mailles sont parcourues en
+// anneaux croissants ; on s'arrête dès que l'anneau suivant ne peut plus contenir mieux.
+func (idx *geoIndex) nearest(lat, lon, maxKm float64) (geoPlace, float64, bool) {
+	var best geoPlace
+	bestKm := math.Inf(1)
+	c := geoCell(lat, lon)
+	// 1° de latitude ≈ 111 km ; la longitude se resserre vers les pôles (borne prudente).
+	maxRing := int(math.Ceil(maxKm/111/math.Max(math.Cos(math.Abs(lat)*math.Pi/180), 0.05))) + 1
+	if maxRing > 180 {
+		maxRing = 180
+	}
+	for ring := 0; ring <= maxRing; ring++ {
+		if ring > 0 && float64(ring-1)*111*math.Max(math.Cos(math.Abs(lat)*math.Pi/180), 0.05) > bestKm {
+			break
+		}
+		for dy := -ring; dy <= ring; dy++ {
+			for dx := -ring; dx <= ring; dx++ {
+				if ring > 0 && dy != -ring && dy != ring && dx != -ring && dx != ring {
+					continue
+				}
+				lonCell := (c[1]+dx+180)%360 - 180
+				if lonCell < -180 {
+					lonCell += 360
+				}
+				for _, p := range idx.cells[[2]int{c[0] + dy, lonCell}] {
+					if d := haversineKm(lat, lon, p.Lat, p.Lon); d < bestKm {
+						best, bestKm = p, d
+					}
+				}
+			}
+		}
+	}
+	if bestKm > maxKm {
+		return geoPlace{}, 0, false
+	}
+	return best, bestKm, true
+}
+
+// parsePlacesTSV lit le format embarqué (nom, pays, région, lat, lon) ou, si la ligne a
+// au moins 15 colonnes, le format GeoNames (name=1, lat=4, lon=5, pays=8, admin1=10).
+func parsePlacesTSV(r io.Reader) ([]geoPlace, error) {
+	var out []geoPlace
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Split(line, "\t")
+		var p geoPlace
+		var latS, lonS string
+		switch {
+		case len(f) >= 15:
+			p.Name, latS, lonS, p.Country, p.Admin = f[1], f[4], f[5], f[8], f[10]
+		case len(f) == 5:
+			p.Name, p.Country, p.Admin, latS, lonS = f[0], f[1], f[2], f[3], f[4]
+		default:
+			continue
+		}
+		lat, err1 := strconv.ParseFloat(latS, 64)
+		lon, err2 := strconv.ParseFloat(lonS, 64)
+		if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			continue
+		}
+		p.Lat, p.Lon = lat, lon
+		out = append(out, p)
+	}
+	return out, sc.Err()
+}
+
+var (
+	geoIndexOnce sync.Once
+	geoIdx       *geoIndex
+)
+
+// placesIndex charge le jeu de lieux une seule fois (DRIVE_GEONAMES_FILE, sinon embarqué).
+func placesIndex() *geoIndex {
+	geoIndexOnce.Do(func() {
+		if path := strings.TrimSpace(os.Getenv("DRIVE_GEONAMES_FILE")); path != "" {
+			if f, err := os.Open(path); err == nil {
+				places, err := parsePlacesTSV(f)
+				f.Close()
+				if err == nil && len(places) > 0 {
+					geoIdx = newGeoIndex(places)
+					log.Printf("[drive] geocoding: %d places loaded from %s", len(places), path)
+					return
+				}
+				log.Printf("[drive] geocoding: %s unusable (%v), using embedded dataset", path, err)
+			} else {
+				log.Printf("[drive] geocoding: %v, using embedded dataset", err)
+			}
+		}
+		places, _ := parsePlacesTSV(strings.NewReader(embeddedPlaces))
+		geoIdx = newGeoIndex(places)
+	})
+	return geoIdx
+}
+
+// geocodeMaxKm — distance maximale au lieu retenu (DRIVE_GEOCODE_MAX_KM, 150 km par défaut).
+func geocodeMaxKm() float64 {
+	if v := os.Getenv("DRIVE_GEOCODE_MAX_KM"); v != "" {
+		if n, err := strconv.ParseFloat(v, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 150
+}
+
+// reverseGeocode renvoie le lieu connu le plus proche des coordonnées.
+func reverseGeocode(lat, lon float64) (geoPlace, bool) {
+	p, _, ok := placesIndex().nearest(lat, lon, geocodeMaxKm())
+	return p, ok
+}
@@ -3,11 +3,17 @@ module github.com/pavel/cloudity/drive-service
 go 1.25.0
 
 require (
+	github.com/alexedwards/argon2id v1.0.0
+	github.com/chai2010/webp v1.4.0
 	github.com/gin-gonic/gin v1.9.1
 	github.com/jdeng/goheif v0.0.0-20260407171156-9bf5264f67af
 	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.18.0
+	github.com/ledongthuc/pdf v0.0.0-20260907135840-6c8c28e0e8a0
 	github.com/lib/pq v1.10.9
 	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
+	golang.org/x/image v0.38.0
+	golang.org/x/net v0.55.0
 )
 
 require (
@@ -32,9 +38,8 @@ require (
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	golang.org/x/arch v0.6.0 // indirect
 	golang.org/x/crypto v0.52.0 // indirect
-	golang.org/x/net v0.55.0 // indirect
-	golang.org/x/sys v0.46.0 // indirect
-	golang.org/x/text v0.37.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/text v0.41.0 // indirect
 	google.golang.org/protobuf v1.36.11 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -2,8 +2,12 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"image"
 	"io"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,28 +27,130 @@ func isHeicLike(name, contentType string) bool {
 	return base == "image/heic" || base == "image/heif"
 }
 
-func decodeThumbnailImage(name, contentType string, content []byte) (image.Image, error) {
-	if isHeicLike(name, contentType) {
-		if img, err := goheif.Decode(bytes.NewReader(content)); err == nil {
-			return img, nil
+// thumbMaxPixels — au-delà (DRIVE_THUMBNAIL_MAX_PIXELS, 100 Mpx par défaut), l'image n'est pas
+// décodée : quelques Ko de PNG ou GIF peuvent déclarer des dimensions qui épuisent la mémoire.
+func thumbMaxPixels() int64 {
+	if v := os.Getenv("DRIVE_THUMBNAIL_MAX_PIXELS"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
 		}
 	}
+	return 100_000_000
+}
+
+// checkImagePixels refuse une image sans dimensions ou dépassant thumbMaxPixels.
+func checkImagePixels(cfg image.Config) error {
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return errors.New("invalid image dimensions")
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > thumbMaxPixels() {
+		return fmt.Errorf("image too large to decode (%dx%d)", cfg.Width, cfg.Height)
+	}
+	return nil
+}
+
+// decodeImageBounded lit l'en-tête (image.DecodeConfig) avant de décoder l'image entière.
+func decodeImageBounded(content []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkImagePixels(cfg); err != nil {
+		return nil, err
+	}
 	img, _, err := image.Decode(bytes.NewReader(content))
 	return img, err
 }
 
-// photoTakenAtFromExif tente d'extraire DateTimeOriginal depuis EXIF (HEIC/JPEG).
-func photoTakenAtFromExif(name, contentType string, content []byte) (time.Time, bool) {
-	if len(content) == 0 {
-		return time.Time{}, false
+func decodeThumbnailImage(name, contentType string, content []byte) (image.Image, error) {
+	if isHeicLike(name, contentType) {
+		if cfg, err := goheif.DecodeConfig(bytes.NewReader(content)); err == nil {
+			if err := checkImagePixels(cfg); err != nil {
+				return nil, err
+			}
+			if img, err := goheif.Decode(bytes.NewReader(content)); err == nil {
+				return img, nil
+			}
+		}
 	}
+	return decodeImageBounded(content)
+}
+
+// exifSources : bloc EXIF extrait du conteneur HEIC (si applicable), puis le fichier brut (JPEG).
+func exifSources(name, contentType string, content []byte) []io.Reader {
 	readers := []io.Reader{bytes.NewReader(content)}
 	if isHeicLike(name, contentType) {
 		if raw, err := goheif.ExtractExif(bytes.NewReader(content)); err == nil && len(raw) > 0 {
 			readers = append([]io.Reader{bytes.NewReader(raw)}, readers...)
 		}
 	}
-	for _, r := range readers {
+	return readers
+}
+
+// exifOrientation renvoie le tag Orientation (1..8), 1 si absent ou illisible.
+func exifOrientation(name, contentType string, content []byte) int {
+	if len(content) == 0 {
+		return 1
+	}
+	for _, r := range exifSources(name, contentType, content) {
+		x, err := exif.Decode(r)
+		if err != nil {
+			continue
+		}
+		tag, err := x.Get(exif.Orientation)
+		if err != nil {
+			continue
+		}
+		if o, err := tag.Int(0); err == nil && o >= 1 && o <= 8 {
+			return o
+		}
+	}
+	return 1
+}
+
+// applyExifOrientation redresse l'image selon le tag EXIF Orientation (miroirs et rotations).
+func applyExifOrientation(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // miroir horizontal
+				dx, dy = w-1-x, y
+			case 3: // 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // miroir vertical
+				dx, dy = x, h-1-y
+			case 5: // transposition
+				dx, dy = y, x
+			case 6: // 90° horaire
+				dx, dy = h-1-y, x
+			case 7: // transversale
+				dx, dy = h-1-y, w-1-x
+			case 8: // 90° anti-horaire
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// photoTakenAtFromExif tente d'extraire DateTimeOriginal depuis EXIF (HEIC/JPEG).
+func photoTakenAtFromExif(name, contentType string, content []byte) (time.Time, bool) {
+	if len(content) == 0 {
+		return time.Time{}, false
+	}
+	for _, r := range exifSources(name, contentType, content) {
 		x, err := exif.Decode(r)
 		if err != nil {
 			continue
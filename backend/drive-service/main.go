@@ -6,8 +6,6 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"image"
-	"image/jpeg"
 	_ "image/png"
 	"io"
 	"log"
@@ -83,6 +81,10 @@ func mimeFromFileName(name string) string {
 		return "audio/mp4"
 	case ".mov":
 		return "video/quicktime"
+	case ".m4v":
+		return "video/x-m4v"
+	case ".3gp":
+		return "video/3gpp"
 	case ".opus":
 		return "audio/opus"
 	case ".flac":
@@ -151,25 +153,76 @@ func setupRouter(db *sql.DB) *gin.Engine {
 		drive.POST("/photos/match", h.matchPhotos)
 		drive.GET("/photos/archive", h.listPhotosArchive)
 		drive.GET("/photos/locked", h.listPhotosLocked)
+		drive.GET("/photos/locked/status", h.getLockedStatus)
+		drive.PUT("/photos/locked/pin", h.setLockedPin)
+		drive.POST("/photos/locked/unlock", h.unlockLockedPhotos)
 		drive.POST("/photos/archive", h.archivePhotos)
 		drive.POST("/photos/unarchive", h.unarchivePhotos)
 		drive.POST("/photos/lock", h.lockPhotos)
 		drive.POST("/photos/unlock", h.unlockPhotos)
+		drive.GET("/photos/albums", h.listPhotoAlbums)
+		drive.POST("/photos/albums", h.createPhotoAlbum)
+		drive.GET("/photos/albums/:id", h.getPhotoAlbum)
+		drive.PATCH("/photos/albums/:id", h.updatePhotoAlbum)
+		drive.DELETE("/photos/albums/:id", h.deletePhotoAlbum)
+		drive.POST("/photos/albums/:id/items", h.addPhotoAlbumItems)
+		drive.DELETE("/photos/albums/:id/items/:nodeId", h.removePhotoAlbumItem)
+		drive.PUT("/photos/albums/:id/order", h.reorderPhotoAlbum)
+		drive.GET("/photos/albums/:id/members", h.listPhotoAlbumMembers)
+		drive.POST("/photos/albums/:id/members", h.addPhotoAlbumMember)
+		drive.DELETE("/photos/albums/:id/members/:userId", h.removePhotoAlbumMember)
+		drive.GET("/photos/albums/:id/shares", h.listAlbumShareLinks)
+		drive.POST("/photos/albums/:id/shares", h.createAlbumShareLink)
 		drive.GET("/nodes/recent", h.listRecentNodes)
 		drive.GET("/storage/summary", h.getStorageSummary)
+		drive.GET("/storage/quota", h.getStorageQuota)
 		drive.GET("/nodes/trash", h.listTrash)
 		drive.POST("/nodes", h.createNode)
 		drive.PUT("/nodes/:id", h.updateNode)
 		drive.POST("/nodes/:id/restore", h.restoreNode)
+		drive.DELETE("/nodes/trash", h.emptyTrash)
 		drive.DELETE("/nodes/trash/:id", h.purgeNode)
 		drive.DELETE("/nodes/:id", h.deleteNode)
 		drive.GET("/nodes/:id/thumbnail", h.getNodeThumbnail)
+		drive.GET("/nodes/:id/stream", h.getNodeStreamURL)
+		drive.GET("/nodes/:id/metadata", h.getNodeMetadata)
 		drive.GET("/nodes/:id/content", h.getNodeContent)
-		drive.GET("/nodes/:id/archive/entries", h.getZipEntries)
+		drive.GET("/nodes/:id/archive/entries", h.getArchiveEntries)
+		drive.GET("/nodes/:id/archive/entry", h.getArchiveEntry)
+		drive.POST("/nodes/:id/archive/extract", h.extractArchive)
 		drive.GET("/nodes/:id/zip", h.downloadFolderZip)
 		drive.PUT("/nodes/:id/content", h.putNodeContent)
 		drive.POST("/nodes/upload", h.uploadFile)
 		drive.POST("/nodes/archive", h.downloadArchiveZip)
+		drive.POST("/exports", h.createExportJob)
+		drive.GET("/exports", h.listExportJobs)
+		drive.GET("/exports/:id", h.getExportJob)
+		drive.GET("/exports/:id/download", h.downloadExportJob)
+		drive.DELETE("/exports/:id", h.deleteExportJob)
+		drive.GET("/nodes/:id/shares", h.listShareLinks)
+		drive.POST("/nodes/:id/shares", h.createShareLink)
+		drive.GET("/nodes/:id/permissions", h.listNodeMembers)
+		drive.POST("/nodes/:id/permissions", h.addNodeMember)
+		drive.PUT("/nodes/:id/permissions/:permId", h.updateNodeMember)
+		drive.DELETE("/nodes/:id/permissions/:permId", h.removeNodeMember)
+		drive.GET("/shared-with-me", h.listSharedWithMe)
+		drive.GET("/shares", h.listShareLinks)
+		drive.DELETE("/shares/:shareId", h.revokeShareLink)
+		// Accès anonyme par token (exempté dans requireUserID et dans l'authMiddleware de la gateway).
+		drive.GET("/public/shares/:token", h.getPublicShare)
+		drive.POST("/public/shares/:token/unlock", h.unlockShareLink)
+		drive.GET("/public/shares/:token/content", h.getPublicShareContent)
+		drive.GET("/public/shares/:token/zip", h.getPublicShareZip)
+		drive.POST("/public/shares/:token/upload", h.uploadToPublicShare)
+		drive.GET("/public/albums/:token", h.getPublicAlbum)
+		drive.GET("/public/albums/:token/items/:nodeId/content", h.getPublicAlbumItemContent)
+		drive.GET("/public/albums/:token/items/:nodeId/thumbnail", h.getPublicAlbumItemThumbnail)
+		drive.GET("/public/stream/:token", h.getPublicStream)
+		drive.HEAD("/public/stream/:token", h.getPublicStream)
+		for _, m := range davMethods {
+			drive.Handle(m, "/dav", h.serveWebDAV)
+			drive.Handle(m, "/dav/*path", h.serveWebDAV)
+		}
 	}
 	r.GET("/drive/files", func(c *gin.Context) {
 		if h.db == nil {
@@ -205,6 +258,12 @@ func main() {
 	}
 
 	r := setupRouter(db)
+	if db != nil {
+		go (&Handler{db: db}).startTextIndexWorker()
+		go (&Handler{db: db}).startThumbnailWorker()
+		go (&Handler{db: db}).startTrashPurgeWorker()
+		go (&Handler{db: db}).startExportWorker()
+	}
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = defaultPort
@@ -222,6 +281,12 @@ func (h *Handler) requireUserID(c *gin.Context) {
 		c.Next()
 		return
 	}
+	// Liens de partage publics : pas d'utilisateur, le handler épingle le propriétaire du lien.
+	// WebDAV : authentifié par mot de passe d'application dans serveWebDAV.
+	if strings.HasPrefix(c.FullPath(), "/drive/public/") || strings.HasPrefix(c.FullPath(), davPrefix) {
+		c.Next()
+		return
+	}
 	userID := c.GetHeader("X-User-ID")
 	if userID == "" {
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "X-User-ID required"})
@@ -233,23 +298,34 @@ func (h *Handler) requireUserID(c *gin.Context) {
 		return
 	}
 	if h.db != nil {
-		ctx := c.Request.Context()
-		conn, err := h.db.Conn(ctx)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to acquire DB connection"})
-			return
-		}
-		defer conn.Close()
-		if _, err := conn.ExecContext(ctx, "SELECT set_config('app.current_user_id', $1, false)", uid); err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to set user context"})
+		release, ok := h.pinUserConn(c, uid)
+		if !ok {
 			return
 		}
-		pin := &pinnedConn{conn: conn, ctx: ctx}
-		c.Request = c.Request.WithContext(withPinnedConn(ctx, pin))
+		defer release()
 	}
 	c.Next()
 }
 
+// pinUserConn acquiert une connexion dédiée, y pose app.current_user_id et l'épingle
+// dans le contexte de la requête (cf. dbpin.go). Répond et retourne false en cas d'erreur.
+func (h *Handler) pinUserConn(c *gin.Context, uid int) (func(), bool) {
+	ctx := c.Request.Context()
+	conn, err := h.db.Conn(ctx)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to acquire DB connection"})
+		return nil, false
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT set_config('app.current_user_id', $1, false)", uid); err != nil {
+		conn.Close()
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to set user context"})
+		return nil, false
+	}
+	pin := &pinnedConn{conn: conn, ctx: ctx}
+	c.Request = c.Request.WithContext(withPinnedConn(ctx, pin))
+	return func() { conn.Close() }, true
+}
+
 type Node struct {
 	ID           int     `json:"id"`
 	TenantID     int     `json:"tenant_id"`
@@ -266,49 +342,105 @@ type Node struct {
 	ChildFolders int     `json:"child_folders,omitempty"`
 	ChildFiles   int     `json:"child_files,omitempty"`
 	DeletedAt        string `json:"deleted_at,omitempty"` // pour la corbeille
+	PurgeAt          string `json:"purge_at,omitempty"`   // purge automatique de la corbeille (trash.go)
 	PhotoArchivedAt  string `json:"photo_archived_at,omitempty"`
 	PhotoLockedAt    string `json:"photo_locked_at,omitempty"`
 	VaultEncrypted   bool   `json:"vault_encrypted,omitempty"`
 	IsVaultFolder    bool   `json:"is_vault_folder,omitempty"`
+	// Photos : "image" | "video" ; métadonnées vidéo lues dans les atomes MP4 / QuickTime.
+	MediaKind  string `json:"media_kind,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	VideoCodec string `json:"video_codec,omitempty"`
+	Streamable bool   `json:"streamable,omitempty"`
+	// Photos : position EXIF / ISO 6709, lieu (géocodage inverse hors ligne) et appareil.
+	Latitude     *float64 `json:"latitude,omitempty"`
+	Longitude    *float64 `json:"longitude,omitempty"`
+	PlaceName    string   `json:"place_name,omitempty"`
+	PlaceCountry string   `json:"place_country,omitempty"`
+	CameraMake   string   `json:"camera_make,omitempty"`
+	CameraModel  string   `json:"camera_model,omitempty"`
 	// Renseigné par GET /drive/nodes/search (dossier parent pour navigation).
 	ParentFolderName string `json:"parent_folder_name,omitempty"`
+	// Renseignés par GET /drive/nodes/search quand le contenu indexé correspond (extrait HTML échappé, <mark>).
+	ContentMatch bool   `json:"content_match,omitempty"`
+	Snippet      string `json:"snippet,omitempty"`
+	// Renseignés par GET /drive/shared-with-me (partage interne).
+	SharedRole string `json:"shared_role,omitempty"`
+	OwnerEmail string `json:"owner_email,omitempty"`
 }
 
 const appVaultMime = "application/vnd.cloudity.vault+json;v=1"
 
+// nodeListPage — GET /drive/nodes avec ?limit= : page d'un listing et curseur de la suivante.
+type nodeListPage struct {
+	Items      []Node `json:"items"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// listNodes — GET /drive/nodes[?parent_id=]. Sans limit : tableau complet (dossiers puis nom).
+// Avec ?limit= (≤ 1000) et ?cursor= : page keyset {items, limit, next_cursor, has_more}.
 func (h *Handler) listNodes(c *gin.Context) {
+	paged := c.Query("limit") != "" || c.Query("cursor") != ""
+	limit := 200
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		limit = n
+	}
+	var cursor *nodeListCursor
+	if raw := c.Query("cursor"); raw != "" {
+		var cur nodeListCursor
+		if err := decodeCursor(raw, &cur); err != nil || cur.ID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+			return
+		}
+		cursor = &cur
+	}
 	if h.db == nil {
+		if paged {
+			c.JSON(http.StatusOK, nodeListPage{Items: []Node{}, Limit: limit})
+			return
+		}
 		c.JSON(http.StatusOK, []Node{})
 		return
 	}
 	ctx := c.Request.Context()
 	parentIDStr := c.Query("parent_id")
-	var rows *sql.Rows
-	var err error
+	args := sqlArgs{}
+	var where string
 	if parentIDStr == "" || parentIDStr == "null" {
-		rows, err = h.dbex(ctx).Query(`
-			SELECT n.id, n.tenant_id, n.user_id, n.parent_id, n.name, n.is_folder, n.size, n.mime_type, n.created_at::text, COALESCE(n.updated_at::text, ''),
-				n.vault_encrypted, n.is_vault_folder,
-				(SELECT COUNT(*) FROM drive_nodes c WHERE c.parent_id = n.id AND c.deleted_at IS NULL),
-				(SELECT COUNT(*) FROM drive_nodes c WHERE c.parent_id = n.id AND c.is_folder = true AND c.deleted_at IS NULL),
-				(SELECT COUNT(*) FROM drive_nodes c WHERE c.parent_id = n.id AND c.is_folder = false AND c.deleted_at IS NULL)
-			FROM drive_nodes n WHERE n.user_id = current_setting('app.current_user_id', true)::INTEGER AND n.parent_id IS NULL AND n.deleted_at IS NULL ` + photosRootExcludeSQL + ` ORDER BY n.is_folder DESC, n.name
-		`)
+		where = `n.user_id = current_setting('app.current_user_id', true)::INTEGER AND n.parent_id IS NULL AND n.deleted_at IS NULL ` + photosRootExcludeSQL
 	} else {
 		parentID, perr := strconv.Atoi(parentIDStr)
 		if perr != nil || parentID <= 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parent_id"})
 			return
 		}
-		rows, err = h.dbex(ctx).Query(`
-			SELECT n.id, n.tenant_id, n.user_id, n.parent_id, n.name, n.is_folder, n.size, n.mime_type, n.created_at::text, COALESCE(n.updated_at::text, ''),
-				n.vault_encrypted, n.is_vault_folder,
-				(SELECT COUNT(*) FROM drive_nodes c WHERE c.parent_id = n.id AND c.deleted_at IS NULL),
-				(SELECT COUNT(*) FROM drive_nodes c WHERE c.parent_id = n.id AND c.is_folder = true AND c.deleted_at IS NULL),
-				(SELECT COUNT(*) FROM drive_nodes c WHERE c.parent_id = n.id AND c.is_folder = false AND c.deleted_at IS NULL)
-			FROM drive_nodes n WHERE n.user_id = current_setting('app.current_user_id', true)::INTEGER AND n.parent_id = $1 AND n.deleted_at IS NULL ORDER BY n.is_folder DESC, n.name
-		`, parentID)
+		p := args.add(parentID)
+		where = `n.parent_id = ` + p + ` AND n.deleted_at IS NULL AND ` + nodeAccessSQL(p, accessViewer)
 	}
+	page := ""
+	if cursor != nil {
+		f, name, id := args.add(cursor.Folder), args.add(cursor.Name), args.add(cursor.ID)
+		where += ` AND (n.is_folder < ` + f + ` OR (n.is_folder = ` + f + ` AND (n.name, n.id) > (` + name + `, ` + id + `)))`
+	}
+	if paged {
+		page = ` LIMIT ` + args.add(limit+1)
+	}
+	rows, err := h.dbex(ctx).Query(`
+		SELECT n.id, n.tenant_id, n.user_id, n.parent_id, n.name, n.is_folder, n.size, n.mime_type, n.created_at::text, COALESCE(n.updated_at::text, ''),
+			n.vault_encrypted, n.is_vault_folder,
+			(SELECT COUNT(*) FROM drive_nodes c WHERE c.parent_id = n.id AND c.deleted_at IS NULL),
+			(SELECT COUNT(*) FROM drive_nodes c WHERE c.parent_id = n.id AND c.is_folder = true AND c.deleted_at IS NULL),
+			(SELECT COUNT(*) FROM drive_nodes c WHERE c.parent_id = n.id AND c.is_folder = false AND c.deleted_at IS NULL)
+		FROM drive_nodes n WHERE `+where+` ORDER BY n.is_folder DESC, n.name, n.id`+page, args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -334,11 +466,24 @@ func (h *Handler) listNodes(c *gin.Context) {
 		n.UpdatedAt = uat
 		list = append(list, n)
 	}
-	c.JSON(http.StatusOK, list)
+	if !paged {
+		c.JSON(http.StatusOK, list)
+		return
+	}
+	out := nodeListPage{Items: list, Limit: limit}
+	if len(list) > limit {
+		out.Items, out.HasMore = list[:limit], true
+		last := list[limit-1]
+		out.NextCursor = encodeCursor(nodeListCursor{Folder: last.IsFolder, Name: last.Name, ID: last.ID})
+	}
+	c.JSON(http.StatusOK, out)
 }
 
-// searchNodes — recherche par nom sur tout le Drive de l’utilisateur (ou sous-arbre si parent_id).
-// GET /drive/nodes/search?q=...&limit=50&parent_id= optionnel
+// searchNodes — recherche par nom et dans le contenu indexé (drive_node_text) sur tout le Drive
+// de l’utilisateur (ou sous-arbre si parent_id).
+// GET /drive/nodes/search?q=...&limit=50&parent_id=&content=1&type=pdf&modified_after=2024-01-01&modified_before=&min_size=&max_size=
+// Résultats : correspondances de nom d’abord, puis par pertinence du contenu ; `snippet` contient
+// un extrait HTML échappé avec les termes surlignés en <mark>.
 func (h *Handler) searchNodes(c *gin.Context) {
 	q := strings.TrimSpace(c.Query("q"))
 	if q == "" {
@@ -356,39 +501,56 @@ func (h *Handler) searchNodes(c *gin.Context) {
 			limit = n
 		}
 	}
-	const selectSearch = `
+	withContent := c.Query("content") != "0" && c.Query("content") != "false"
+	args := sqlArgs{q}
+	limitArg := args.add(limit)
+	contentArg := args.add(withContent)
+	headlineArg := args.add(snippetHeadlineOptions)
+	filters, ferr := searchFilters(c.Query, &args)
+	if ferr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ferr.Error()})
+		return
+	}
+	selectSearch := `
 			SELECT n.id, n.tenant_id, n.user_id, n.parent_id, n.name, n.is_folder, n.size, n.mime_type, n.created_at::text, COALESCE(n.updated_at::text, ''),
 				(SELECT COUNT(*) FROM drive_nodes c WHERE c.parent_id = n.id AND c.deleted_at IS NULL),
 				(SELECT COUNT(*) FROM drive_nodes c WHERE c.parent_id = n.id AND c.is_folder = true AND c.deleted_at IS NULL),
 				(SELECT COUNT(*) FROM drive_nodes c WHERE c.parent_id = n.id AND c.is_folder = false AND c.deleted_at IS NULL),
-				COALESCE(p.name, '')
+				COALESCE(p.name, ''),
+				POSITION(LOWER($1) IN LOWER(n.name)) > 0 AS name_match,
+				COALESCE(` + contentArg + ` AND t.tsv @@ query, false) AS content_match,
+				CASE WHEN ` + contentArg + ` AND t.tsv @@ query THEN ts_rank(t.tsv, query) ELSE 0 END AS rank,
+				CASE WHEN ` + contentArg + ` AND t.tsv @@ query THEN ts_headline('` + textSearchConfig + `', t.body, query, ` + headlineArg + `) ELSE '' END
 			FROM drive_nodes n
+			CROSS JOIN websearch_to_tsquery('` + textSearchConfig + `', $1) AS query
 			LEFT JOIN drive_nodes p ON p.id = n.parent_id AND p.user_id = n.user_id AND p.deleted_at IS NULL
+			LEFT JOIN drive_node_text t ON t.node_id = n.id AND t.status = 'indexed' AND n.vault_encrypted = false
 			WHERE n.user_id = current_setting('app.current_user_id', true)::INTEGER
 			AND n.deleted_at IS NULL
-			AND POSITION(LOWER($1) IN LOWER(n.name)) > 0` + photosTreeExcludeSQL
+			AND (POSITION(LOWER($1) IN LOWER(n.name)) > 0 OR (` + contentArg + ` AND t.tsv @@ query))` + photosTreeExcludeSQL + filters
+	const orderSearch = ` ORDER BY name_match DESC, rank DESC, n.is_folder DESC, n.name ASC LIMIT `
 	parentStr := strings.TrimSpace(c.Query("parent_id"))
 	var rows *sql.Rows
 	var err error
 	if parentStr == "" || parentStr == "null" {
-		rows, err = h.dbex(ctx).Query(selectSearch+` ORDER BY n.is_folder DESC, n.name ASC LIMIT $2`, q, limit)
+		rows, err = h.dbex(ctx).Query(selectSearch+orderSearch+limitArg, args...)
 	} else {
 		parentID, perr := strconv.Atoi(parentStr)
 		if perr != nil || parentID <= 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parent_id"})
 			return
 		}
+		parentArg := args.add(parentID)
 		qTree := `
 			WITH RECURSIVE descendants AS (
 				SELECT id FROM drive_nodes
-				WHERE id = $3 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+				WHERE id = ` + parentArg + ` AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
 				UNION ALL
 				SELECT c.id FROM drive_nodes c
 				INNER JOIN descendants d ON c.parent_id = d.id
 				WHERE c.deleted_at IS NULL AND c.user_id = current_setting('app.current_user_id', true)::INTEGER
-			)` + selectSearch + ` AND n.id IN (SELECT id FROM descendants)
-			ORDER BY n.is_folder DESC, n.name ASC LIMIT $2`
-		rows, err = h.dbex(ctx).Query(qTree, q, limit, parentID)
+			)` + selectSearch + ` AND n.id IN (SELECT id FROM descendants)` + orderSearch + limitArg
+		rows, err = h.dbex(ctx).Query(qTree, args...)
 	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -402,7 +564,11 @@ func (h *Handler) searchNodes(c *gin.Context) {
 		var mime sql.NullString
 		var uat string
 		var parentFolder string
-		if err := rows.Scan(&n.ID, &n.TenantID, &n.UserID, &pid, &n.Name, &n.IsFolder, &n.Size, &mime, &n.CreatedAt, &uat, &n.ChildCount, &n.ChildFolders, &n.ChildFiles, &parentFolder); err != nil {
+		var nameMatch bool
+		var rank float64
+		var snippet string
+		if err := rows.Scan(&n.ID, &n.TenantID, &n.UserID, &pid, &n.Name, &n.IsFolder, &n.Size, &mime, &n.CreatedAt, &uat, &n.ChildCount, &n.ChildFolders, &n.ChildFiles, &parentFolder,
+			&nameMatch, &n.ContentMatch, &rank, &snippet); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -415,6 +581,7 @@ func (h *Handler) searchNodes(c *gin.Context) {
 		}
 		n.UpdatedAt = uat
 		n.ParentFolderName = parentFolder
+		n.Snippet = snippetHTML(snippet)
 		list = append(list, n)
 	}
 	if list == nil {
@@ -471,22 +638,23 @@ func (h *Handler) listRecentNodes(c *gin.Context) {
 	c.JSON(http.StatusOK, list)
 }
 
-// photosTimelinePage — images du Drive (tous dossiers), tri chronologique inverse, pagination offset.
+// photosTimelinePage — images et vidéos du Drive (tous dossiers), tri chronologique inverse, pagination par curseur.
 type photosTimelinePage struct {
-	Items   []Node `json:"items"`
-	Limit   int    `json:"limit"`
-	Offset  int    `json:"offset"`
-	HasMore bool   `json:"has_more"`
+	Items      []Node `json:"items"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
 }
 
-// photoImageFilterSQL — fichiers image (hors PDF), dossiers exclus.
-const photoImageFilterSQL = `
+// photoMediaFilterSQL — fichiers image (hors PDF) et vidéo, dossiers exclus.
+const photoMediaFilterSQL = `
   AND is_folder = false
   AND LOWER(name) !~ '\.pdf$'
   AND LOWER(COALESCE(mime_type, '')) NOT LIKE 'application/pdf%'
   AND (
     (LOWER(COALESCE(mime_type, '')) LIKE 'image/%' AND LOWER(COALESCE(mime_type, '')) NOT LIKE 'image/pdf%')
     OR LOWER(name) ~ '\.(jpg|jpeg|png|gif|webp|bmp|heic|heif|avif|tiff|tif)$'
+    OR ` + photoVideoCondSQL + `
   )`
 
 const photoNodeSelectSQL = `
@@ -496,16 +664,29 @@ const photoNodeSelectSQL = `
   COALESCE(updated_at::text, ''),
   COALESCE(photo_archived_at::text, ''),
   COALESCE(photo_locked_at::text, ''),
-  vault_encrypted, is_vault_folder`
+  vault_encrypted, is_vault_folder,
+  CASE WHEN ` + photoVideoCondSQL + ` THEN 'video' ELSE 'image' END,
+  COALESCE(duration_ms, 0), COALESCE(media_width, 0), COALESCE(media_height, 0),
+  COALESCE(video_codec, ''), COALESCE(video_faststart, false),
+  gps_lat, gps_lon, COALESCE(place_name, ''), COALESCE(place_country, ''),
+  COALESCE(camera_make, ''), COALESCE(camera_model, '')`
 
-func scanPhotoNodeRow(rows *sql.Rows) (Node, error) {
+// scanPhotoNodeRow lit une ligne photoNodeSelectSQL, suivie des colonnes extra éventuelles.
+func scanPhotoNodeRow(rows *sql.Rows, extra ...any) (Node, error) {
 	var n Node
 	var pid sql.NullInt64
 	var mime sql.NullString
 	var takenAt, uat, archivedAt, lockedAt string
-	if err := rows.Scan(&n.ID, &n.TenantID, &n.UserID, &pid, &n.Name, &n.IsFolder, &n.Size, &mime, &takenAt, &n.CreatedAt, &uat, &archivedAt, &lockedAt, &n.VaultEncrypted, &n.IsVaultFolder); err != nil {
+	var lat, lon sql.NullFloat64
+	dest := append([]any{&n.ID, &n.TenantID, &n.UserID, &pid, &n.Name, &n.IsFolder, &n.Size, &mime, &takenAt, &n.CreatedAt, &uat, &archivedAt, &lockedAt, &n.VaultEncrypted, &n.IsVaultFolder,
+		&n.MediaKind, &n.DurationMs, &n.Width, &n.Height, &n.VideoCodec, &n.Streamable,
+		&lat, &lon, &n.PlaceName, &n.PlaceCountry, &n.CameraMake, &n.CameraModel}, extra...)
+	if err := rows.Scan(dest...); err != nil {
 		return n, err
 	}
+	if lat.Valid && lon.Valid {
+		n.Latitude, n.Longitude = &lat.Float64, &lon.Float64
+	}
 	if pid.Valid {
 		p := int(pid.Int64)
 		n.ParentID = &p
@@ -524,57 +705,67 @@ type photoIDsBody struct {
 	IDs []int `json:"ids"`
 }
 
+// listPhotosTimeline — GET /drive/photos/timeline?limit=48[&cursor=] (curseur : next_cursor de la page précédente).
 func (h *Handler) listPhotosTimeline(c *gin.Context) {
-	limitStr := c.DefaultQuery("limit", "48")
-	offsetStr := c.DefaultQuery("offset", "0")
-	limit, err := strconv.Atoi(limitStr)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "48"))
 	if err != nil || limit < 1 {
 		limit = 48
 	}
 	if limit > 200 {
 		limit = 200
 	}
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil || offset < 0 {
-		offset = 0
+	args := sqlArgs{}
+	keyset := ""
+	if raw := c.Query("cursor"); raw != "" {
+		var cur photoTimelineCursor
+		if err := decodeCursor(raw, &cur); err != nil || cur.At.IsZero() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+			return
+		}
+		keyset = ` AND (` + photoTimelineKeySQL + `, id) < (` + args.add(cur.At) + `, ` + args.add(cur.ID) + `)`
 	}
 	if h.db == nil {
-		c.JSON(http.StatusOK, photosTimelinePage{Items: []Node{}, Limit: limit, Offset: offset, HasMore: false})
+		c.JSON(http.StatusOK, photosTimelinePage{Items: []Node{}, Limit: limit})
 		return
 	}
 	ctx := c.Request.Context()
 	// Demander une ligne de plus pour savoir s'il reste des pages (évite COUNT(*)).
-	fetch := limit + 1
+	fetch := args.add(limit + 1)
 	rows, err := h.dbex(ctx).Query(`
-		SELECT `+photoNodeSelectSQL+`
+		SELECT `+photoNodeSelectSQL+`, `+photoTimelineKeySQL+`
 		FROM drive_nodes
 		WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
 		  AND deleted_at IS NULL
 		  AND photo_archived_at IS NULL
 		  AND photo_locked_at IS NULL
-		`+photoImageFilterSQL+`
-		ORDER BY COALESCE(taken_at, created_at) DESC NULLS LAST, id DESC
-		LIMIT $1 OFFSET $2
-	`, fetch, offset)
+		`+photoMediaFilterSQL+keyset+`
+		ORDER BY `+photoTimelineKeySQL+` DESC, id DESC
+		LIMIT `+fetch, args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	defer rows.Close()
-	list := make([]Node, 0)
+	page := photosTimelinePage{Items: make([]Node, 0), Limit: limit}
+	var last photoTimelineCursor
 	for rows.Next() {
-		n, err := scanPhotoNodeRow(rows)
+		var key time.Time
+		n, err := scanPhotoNodeRow(rows, &key)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		list = append(list, n)
+		if len(page.Items) == limit {
+			page.HasMore = true
+			break
+		}
+		page.Items = append(page.Items, n)
+		last = photoTimelineCursor{At: key, ID: n.ID}
 	}
-	hasMore := len(list) > limit
-	if hasMore {
-		list = list[:limit]
+	if page.HasMore {
+		page.NextCursor = encodeCursor(last)
 	}
-	c.JSON(http.StatusOK, photosTimelinePage{Items: list, Limit: limit, Offset: offset, HasMore: hasMore})
+	c.JSON(http.StatusOK, page)
 }
 
 func (h *Handler) listPhotosArchive(c *gin.Context) {
@@ -590,7 +781,7 @@ func (h *Handler) listPhotosArchive(c *gin.Context) {
 		  AND deleted_at IS NULL
 		  AND photo_archived_at IS NOT NULL
 		  AND photo_locked_at IS NULL
-		`+photoImageFilterSQL+`
+		`+photoMediaFilterSQL+`
 		ORDER BY photo_archived_at DESC NULLS LAST, id DESC
 	`)
 	if err != nil {
@@ -615,6 +806,10 @@ func (h *Handler) listPhotosLocked(c *gin.Context) {
 		c.JSON(http.StatusOK, []Node{})
 		return
 	}
+	uid, _ := strconv.Atoi(c.GetHeader("X-User-ID"))
+	if !h.requireLockedAccess(c, true, uid) {
+		return
+	}
 	ctx := c.Request.Context()
 	rows, err := h.dbex(ctx).Query(`
 		SELECT `+photoNodeSelectSQL+`
@@ -622,7 +817,7 @@ func (h *Handler) listPhotosLocked(c *gin.Context) {
 		WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
 		  AND deleted_at IS NULL
 		  AND photo_locked_at IS NOT NULL
-		`+photoImageFilterSQL+`
+		`+photoMediaFilterSQL+`
 		ORDER BY photo_locked_at DESC NULLS LAST, id DESC
 	`)
 	if err != nil {
@@ -661,7 +856,7 @@ func (h *Handler) archivePhotos(c *gin.Context) {
 		  AND photo_locked_at IS NULL
 		  AND photo_archived_at IS NULL
 		  AND id = ANY($1::int[])
-		`+photoImageFilterSQL, pq.Array(body.IDs))
+		`+photoMediaFilterSQL, pq.Array(body.IDs))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -689,7 +884,7 @@ func (h *Handler) unarchivePhotos(c *gin.Context) {
 		  AND photo_locked_at IS NULL
 		  AND photo_archived_at IS NOT NULL
 		  AND id = ANY($1::int[])
-		`+photoImageFilterSQL, pq.Array(body.IDs))
+		`+photoMediaFilterSQL, pq.Array(body.IDs))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -709,6 +904,14 @@ func (h *Handler) lockPhotos(c *gin.Context) {
 		return
 	}
 	ctx := c.Request.Context()
+	// Verrouiller sans code laisserait le contenu lisible par la seule session (photos_locked.go).
+	if _, err := h.lockedPinHash(ctx); err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "locked_pin_required", "code": "LOCKED_PIN_REQUIRED"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	res, err := h.dbex(ctx).Exec(`
 		UPDATE drive_nodes
 		SET photo_locked_at = NOW(), photo_archived_at = NULL, updated_at = NOW()
@@ -716,7 +919,7 @@ func (h *Handler) lockPhotos(c *gin.Context) {
 		  AND deleted_at IS NULL
 		  AND photo_locked_at IS NULL
 		  AND id = ANY($1::int[])
-		`+photoImageFilterSQL, pq.Array(body.IDs))
+		`+photoMediaFilterSQL, pq.Array(body.IDs))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -735,6 +938,10 @@ func (h *Handler) unlockPhotos(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "ids required"})
 		return
 	}
+	uid, _ := strconv.Atoi(c.GetHeader("X-User-ID"))
+	if !h.requireLockedAccess(c, true, uid) {
+		return
+	}
 	ctx := c.Request.Context()
 	res, err := h.dbex(ctx).Exec(`
 		UPDATE drive_nodes
@@ -743,7 +950,7 @@ func (h *Handler) unlockPhotos(c *gin.Context) {
 		  AND deleted_at IS NULL
 		  AND photo_locked_at IS NOT NULL
 		  AND id = ANY($1::int[])
-		`+photoImageFilterSQL, pq.Array(body.IDs))
+		`+photoMediaFilterSQL, pq.Array(body.IDs))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -780,14 +987,36 @@ func (h *Handler) createNode(c *gin.Context) {
 			tid = t
 		}
 	}
+	// Dossier parent partagé : rôle éditeur requis, le nœud appartient au propriétaire du dossier.
+	if body.ParentID != nil && *body.ParentID != 0 {
+		parent, ok := h.requireNodeAccess(c, *body.ParentID, accessEditor)
+		if !ok {
+			return
+		}
+		uid, tid = parent.UserID, parent.TenantID
+	}
 	ctx := c.Request.Context()
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	level, err := reserveQuota(tx, uid, tid, 0)
+	if err != nil {
+		if !writeQuotaError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
 	var id int
 	if body.ParentID == nil || *body.ParentID == 0 {
-		err := h.dbex(ctx).QueryRow(`
+		err := tx.QueryRow(`
 			INSERT INTO drive_nodes (tenant_id, user_id, parent_id, name, is_folder, size, is_vault_folder) VALUES ($1, $2, NULL, $3, $4, 0, $5)
 			RETURNING id
 		`, tid, uid, body.Name, body.IsFolder, body.IsVaultFolder).Scan(&id)
 		if err != nil {
+			tx.Rollback()
 			var perr *pq.Error
 			if errors.As(err, &perr) && perr.Code == "23505" {
 				var existingID int
@@ -810,11 +1039,12 @@ func (h *Handler) createNode(c *gin.Context) {
 			return
 		}
 	} else {
-		err := h.dbex(ctx).QueryRow(`
+		err := tx.QueryRow(`
 			INSERT INTO drive_nodes (tenant_id, user_id, parent_id, name, is_folder, size, is_vault_folder) VALUES ($1, $2, $3, $4, $5, 0, $6)
 			RETURNING id
 		`, tid, uid, *body.ParentID, body.Name, body.IsFolder, body.IsVaultFolder).Scan(&id)
 		if err != nil {
+			tx.Rollback()
 			var perr *pq.Error
 			if errors.As(err, &perr) && perr.Code == "23505" {
 				var existingID int
@@ -837,6 +1067,11 @@ func (h *Handler) createNode(c *gin.Context) {
 			return
 		}
 	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	setQuotaLevelHeader(c, level)
 	c.JSON(http.StatusCreated, gin.H{"id": id, "name": body.Name, "is_folder": body.IsFolder})
 }
 
@@ -866,12 +1101,15 @@ func (h *Handler) updateNode(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "name or parent_id required"})
 		return
 	}
+	if !h.requireLockedWrite(c, id) {
+		return
+	}
 	ctx := c.Request.Context()
 	// Mise à jour name et/ou parent_id
 	if body.Name != "" && body.ParentID == nil {
 		res, err := h.dbex(ctx).Exec(`
 			UPDATE drive_nodes SET name = $1, updated_at = CURRENT_TIMESTAMP
-			WHERE id = $2 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+			WHERE id = $2 AND deleted_at IS NULL AND `+nodeAccessSQL("id", accessEditor)+`
 		`, body.Name, id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -887,13 +1125,8 @@ func (h *Handler) updateNode(c *gin.Context) {
 	}
 	if body.ParentID != nil {
 		// Déplacer le nœud (éviter de déplacer dans un de ses descendants)
-		var currentParent *int
-		var isFolder bool
-		if err := h.dbex(ctx).QueryRow(`SELECT parent_id, is_folder FROM drive_nodes WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL`, id).Scan(&currentParent, &isFolder); err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
-			return
-		} else if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		node, ok := h.requireNodeAccess(c, id, accessEditor)
+		if !ok {
 			return
 		}
 		newParentVal := *body.ParentID
@@ -906,12 +1139,28 @@ func (h *Handler) updateNode(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot move into itself"})
 			return
 		}
+		// Partage interne : éditeur sur la cible, et le nœud reste dans l'arborescence de son propriétaire.
+		if newParentNullable == nil {
+			if node.Level < accessOwner {
+				c.JSON(http.StatusForbidden, gin.H{"error": "insufficient_permission", "message": "Seul le propriétaire peut déplacer à la racine."})
+				return
+			}
+		} else {
+			target, ok := h.requireNodeAccess(c, *newParentNullable, accessEditor)
+			if !ok {
+				return
+			}
+			if target.UserID != node.UserID {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "cannot move across owners"})
+				return
+			}
+		}
 		// Vérifier que la cible n'est pas un descendant de id (éviter cycle)
 		if newParentNullable != nil {
 			check := *newParentNullable
 			for check > 0 {
 				var pid *int
-				if err := h.dbex(ctx).QueryRow(`SELECT parent_id FROM drive_nodes WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER`, check).Scan(&pid); err != nil || pid == nil {
+				if err := h.dbex(ctx).QueryRow(`SELECT parent_id FROM drive_nodes WHERE id = $1`, check).Scan(&pid); err != nil || pid == nil {
 					break
 				}
 				if *pid == id {
@@ -923,7 +1172,7 @@ func (h *Handler) updateNode(c *gin.Context) {
 		}
 		res, err := h.dbex(ctx).Exec(`
 			UPDATE drive_nodes SET parent_id = $1, updated_at = CURRENT_TIMESTAMP
-			WHERE id = $2 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+			WHERE id = $2 AND deleted_at IS NULL AND `+nodeAccessSQL("id", accessEditor)+`
 		`, newParentNullable, id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -964,9 +1213,12 @@ func (h *Handler) deleteNode(c *gin.Context) {
 	if h.rejectPhotosRootMutation(c, id) {
 		return
 	}
+	if !h.requireLockedWrite(c, id) {
+		return
+	}
 	ctx := c.Request.Context()
 	res, err := h.dbex(ctx).Exec(`
-		UPDATE drive_nodes SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+		UPDATE drive_nodes SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL AND `+nodeAccessSQL("id", accessEditor)+`
 	`, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -987,11 +1239,12 @@ func (h *Handler) listTrash(c *gin.Context) {
 	}
 	ctx := c.Request.Context()
 	rows, err := h.dbex(ctx).Query(`
-		SELECT id, tenant_id, user_id, parent_id, name, is_folder, size, mime_type, created_at::text, COALESCE(updated_at::text, ''), deleted_at::text
+		SELECT id, tenant_id, user_id, parent_id, name, is_folder, size, mime_type, created_at::text, COALESCE(updated_at::text, ''), deleted_at::text,
+			`+trashPurgeAtSQL+`
 		FROM drive_nodes
 		WHERE user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NOT NULL
 		ORDER BY deleted_at DESC NULLS LAST, id DESC
-	`)
+	`, trashRetention().Seconds())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1003,7 +1256,7 @@ func (h *Handler) listTrash(c *gin.Context) {
 		var pid sql.NullInt64
 		var mime sql.NullString
 		var uat, deletedAt string
-		if err := rows.Scan(&n.ID, &n.TenantID, &n.UserID, &pid, &n.Name, &n.IsFolder, &n.Size, &mime, &n.CreatedAt, &uat, &deletedAt); err != nil {
+		if err := rows.Scan(&n.ID, &n.TenantID, &n.UserID, &pid, &n.Name, &n.IsFolder, &n.Size, &mime, &n.CreatedAt, &uat, &deletedAt, &n.PurgeAt); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -1060,15 +1313,12 @@ func (h *Handler) purgeNode(c *gin.Context) {
 		return
 	}
 	ctx := c.Request.Context()
-	res, err := h.dbex(ctx).Exec(`
-		DELETE FROM drive_nodes WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NOT NULL
-	`, id)
+	nodes, _, err := h.purgeTrashed(ctx, `id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER`, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	aff, _ := res.RowsAffected()
-	if aff == 0 {
+	if nodes == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 		return
 	}
@@ -1090,11 +1340,12 @@ func (h *Handler) getNodeContent(c *gin.Context) {
 	var name string
 	var content []byte
 	var mime sql.NullString
-	var vaultEncrypted bool
+	var vaultEncrypted, locked bool
+	var ownerID int
 	err = h.dbex(ctx).QueryRow(`
-		SELECT name, COALESCE(content, ''::bytea), mime_type, vault_encrypted FROM drive_nodes
-		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND is_folder = false AND deleted_at IS NULL
-	`, id).Scan(&name, &content, &mime, &vaultEncrypted)
+		SELECT name, COALESCE(content, ''::bytea), mime_type, vault_encrypted, photo_locked_at IS NOT NULL, user_id FROM drive_nodes
+		WHERE id = $1 AND is_folder = false AND deleted_at IS NULL AND `+nodeAccessSQL("id", accessViewer)+`
+	`, id).Scan(&name, &content, &mime, &vaultEncrypted, &locked, &ownerID)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 		return
@@ -1103,15 +1354,14 @@ func (h *Handler) getNodeContent(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if vaultEncrypted {
-		c.Header("X-Cloudity-Vault-Encrypted", "1")
-	}
-	// Fichier sans contenu (ex. nouveau document) : retourner 200 avec corps vide
-	if len(content) == 0 {
-		c.Header("Content-Type", "text/plain")
-		c.Data(http.StatusOK, "text/plain", []byte{})
+	if !h.requireLockedAccess(c, locked, ownerID) {
 		return
 	}
+	serveNodeContent(c, name, content, mime, vaultEncrypted)
+}
+
+// nodeContentType déduit le Content-Type (mime enregistré, extension, signature PDF).
+func nodeContentType(name string, mime sql.NullString, content []byte) string {
 	ct := "application/octet-stream"
 	if mime.Valid && strings.TrimSpace(mime.String) != "" {
 		ct = strings.TrimSpace(mime.String)
@@ -1124,6 +1374,22 @@ func (h *Handler) getNodeContent(c *gin.Context) {
 	if len(content) >= 4 && string(content[0:4]) == "%PDF" {
 		ct = "application/pdf"
 	}
+	return ct
+}
+
+// serveNodeContent envoie le contenu d'un fichier avec Content-Type / Content-Disposition déduits.
+// Les requêtes Range (lecture vidéo / audio, reprise de téléchargement) sont honorées.
+func serveNodeContent(c *gin.Context, name string, content []byte, mime sql.NullString, vaultEncrypted bool) {
+	if vaultEncrypted {
+		c.Header("X-Cloudity-Vault-Encrypted", "1")
+	}
+	// Fichier sans contenu (ex. nouveau document) : retourner 200 avec corps vide
+	if len(content) == 0 {
+		c.Header("Content-Type", "text/plain")
+		c.Data(http.StatusOK, "text/plain", []byte{})
+		return
+	}
+	ct := nodeContentType(name, mime, content)
 	inlineParam := strings.ToLower(strings.TrimSpace(c.Query("inline")))
 	wantInline := inlineParam == "1" || inlineParam == "true" || inlineParam == "yes"
 	baseCT := ct
@@ -1143,9 +1409,12 @@ func (h *Handler) getNodeContent(c *gin.Context) {
 		disp = "inline"
 	}
 	c.Header("Content-Disposition", disp+`; filename="`+dispositionFilename(name)+`"`)
-	c.Data(http.StatusOK, ct, content)
+	c.Header("Content-Type", ct)
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, bytes.NewReader(content))
 }
 
+// getNodeThumbnail sert une vignette depuis le cache drive_thumbnails (thumbnails.go).
+// GET /drive/nodes/:id/thumbnail?variant=sm|md|lg (ou size=N) &format=jpeg|webp (défaut : Accept)
 func (h *Handler) getNodeThumbnail(c *gin.Context) {
 	if h.db == nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
@@ -1157,19 +1426,24 @@ func (h *Handler) getNodeThumbnail(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
-	size := 360
-	if s, err := strconv.Atoi(c.DefaultQuery("size", "360")); err == nil && s >= 96 && s <= 1024 {
-		size = s
-	}
+	h.writeNodeThumbnail(c, id)
+}
+
+// writeNodeThumbnail sert la vignette d'un nœud visible (nodeAccessSQL) par l'utilisateur épinglé.
+func (h *Handler) writeNodeThumbnail(c *gin.Context, id int) {
+	variant := thumbVariantFor(c.Query("variant"), c.Query("size"))
+	format := thumbFormatFor(c.Query("format"), c.GetHeader("Accept"))
 	ctx := c.Request.Context()
 	var name string
-	var content []byte
+	var size int64
 	var mime sql.NullString
-	var vaultEncrypted bool
-	err = h.dbex(ctx).QueryRow(`
-		SELECT name, COALESCE(content, ''::bytea), mime_type, vault_encrypted FROM drive_nodes
-		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND is_folder = false AND deleted_at IS NULL
-	`, id).Scan(&name, &content, &mime, &vaultEncrypted)
+	var vaultEncrypted, locked bool
+	var ownerID int
+	var hash string
+	err := h.dbex(ctx).QueryRow(`
+		SELECT name, size, mime_type, vault_encrypted, COALESCE(content_hash, ''), photo_locked_at IS NOT NULL, user_id FROM drive_nodes
+		WHERE id = $1 AND is_folder = false AND deleted_at IS NULL AND `+nodeAccessSQL("id", accessViewer)+`
+	`, id).Scan(&name, &size, &mime, &vaultEncrypted, &hash, &locked, &ownerID)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 		return
@@ -1178,136 +1452,94 @@ func (h *Handler) getNodeThumbnail(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if !h.requireLockedAccess(c, locked, ownerID) {
+		return
+	}
 	if vaultEncrypted {
 		c.Header("X-Cloudity-Vault-Encrypted", "1")
 		c.JSON(http.StatusNotFound, gin.H{"error": "vault_encrypted", "code": "VAULT_ENCRYPTED"})
 		return
 	}
-	ct := "application/octet-stream"
-	if mime.Valid && strings.TrimSpace(mime.String) != "" {
-		ct = strings.TrimSpace(mime.String)
-	}
-	if ct == "application/octet-stream" || ct == "" {
-		if inf := mimeFromFileName(name); inf != "" {
-			ct = inf
-		}
+	ct := nodeContentType(name, mime, nil)
+	// Dossier verrouillé : aucune copie en cache navigateur / proxy au-delà du jeton d'accès.
+	if locked {
+		c.Header("Cache-Control", "private, no-store")
+	} else {
+		c.Header("Cache-Control", "private, max-age=3600")
 	}
-	c.Header("Cache-Control", "private, max-age=3600")
 	if isNonPhotoThumbnail(name, ct) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not_an_image"})
 		return
 	}
-	if len(content) == 0 {
+	if size == 0 {
 		c.Data(http.StatusOK, "image/jpeg", []byte{})
 		return
 	}
-	img, err := decodeThumbnailImage(name, ct, content)
-	if err != nil {
-		if isNonPhotoThumbnail(name, ct) || (len(content) >= 4 && string(content[0:4]) == "%PDF") {
-			c.JSON(http.StatusNotFound, gin.H{"error": "not_an_image"})
+	serve := func(hash string, data []byte) {
+		etag := `"` + hash + "-" + variant + "." + format + `"`
+		c.Header("ETag", etag)
+		c.Header("Vary", "Accept")
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
 			return
 		}
-		c.Header("Content-Disposition", `inline; filename="`+dispositionFilename(name)+`"`)
-		c.Data(http.StatusOK, ct, content)
-		return
+		c.Header("Content-Disposition", `inline; filename="thumbnail.`+format+`"`)
+		c.Data(http.StatusOK, thumbContentType(format), data)
 	}
-	thumb := resizeNearest(img, size)
-	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 78}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "thumbnail encode failed"})
-		return
-	}
-	c.Header("Content-Disposition", `inline; filename="thumbnail.jpg"`)
-	c.Data(http.StatusOK, "image/jpeg", buf.Bytes())
-}
-
-func resizeNearest(src image.Image, maxSide int) image.Image {
-	b := src.Bounds()
-	w, h := b.Dx(), b.Dy()
-	if w <= 0 || h <= 0 || (w <= maxSide && h <= maxSide) {
-		return src
-	}
-	newW, newH := maxSide, maxSide
-	if w >= h {
-		newH = max(1, h*maxSide/w)
-	} else {
-		newW = max(1, w*maxSide/h)
-	}
-	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
-	for y := 0; y < newH; y++ {
-		sy := b.Min.Y + y*h/newH
-		for x := 0; x < newW; x++ {
-			sx := b.Min.X + x*w/newW
-			dst.Set(x, y, src.At(sx, sy))
+	failed := false
+	if hash != "" {
+		data, f, err := h.loadThumbnail(ctx, hash, variant, format)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
+		if data != nil {
+			serve(hash, data)
+			return
+		}
+		failed = f
 	}
-	return dst
-}
-
-// getZipEntries retourne la liste des entrées d'un fichier ZIP (nœud fichier) sans extraire. GET /drive/nodes/:id/archive/entries
-func (h *Handler) getZipEntries(c *gin.Context) {
-	if h.db == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
-		return
+	if !failed && hash == "" {
+		if hash, err = h.ensureContentHash(ctx, id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 	}
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil || id <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+	// Vidéo : couverture sans charger le fichier (atomes lus par plages).
+	if isVideoLike(name, ct) {
+		if list, err := h.generateVideoThumbnails(ctx, id, hash, name, size); err == nil {
+			for _, t := range list {
+				if t.Variant == variant && t.Format == format {
+					serve(hash, t.Data)
+					return
+				}
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_an_image"})
 		return
 	}
-	ctx := c.Request.Context()
 	var content []byte
-	err = h.dbex(ctx).QueryRow(`
-		SELECT COALESCE(content, ''::bytea) FROM drive_nodes
-		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND is_folder = false AND deleted_at IS NULL
-	`, id).Scan(&content)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
-		return
-	}
-	if err != nil {
+	if err := h.dbex(ctx).QueryRow(`SELECT COALESCE(content, ''::bytea) FROM drive_nodes WHERE id = $1`, id).Scan(&content); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if len(content) == 0 {
-		c.JSON(http.StatusOK, gin.H{"entries": []interface{}{}})
-		return
+	if !failed && int64(len(content)) <= thumbMaxBytes() {
+		if list, err := h.generateThumbnails(ctx, hash, name, ct, content); err == nil {
+			for _, t := range list {
+				if t.Variant == variant && t.Format == format {
+					serve(hash, t.Data)
+					return
+				}
+			}
+		}
 	}
-	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid zip", "detail": err.Error()})
+	// Non décodable (format exotique) : le navigateur tentera l’original.
+	if len(content) >= 4 && string(content[0:4]) == "%PDF" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_an_image"})
 		return
 	}
-	type entry struct {
-		Path  string `json:"path"`
-		Name  string `json:"name"`
-		Size  int64  `json:"size"`
-		IsDir bool   `json:"is_dir"`
-	}
-	var entries []entry
-	for _, f := range zr.File {
-		path := f.Name
-		isDir := strings.HasSuffix(path, "/")
-		name := path
-		if isDir && len(name) > 0 {
-			name = strings.TrimSuffix(name, "/")
-		}
-		if idx := strings.LastIndex(name, "/"); idx >= 0 {
-			name = name[idx+1:]
-		}
-		if f.UncompressedSize64 > uint64(^uint64(0)>>1) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "zip entry too large"})
-			return
-		}
-		entries = append(entries, entry{
-			Path:  path,
-			Name:  name,
-			Size:  int64(f.UncompressedSize64),
-			IsDir: isDir,
-		})
-	}
-	c.JSON(http.StatusOK, gin.H{"entries": entries})
+	c.Header("Content-Disposition", `inline; filename="`+dispositionFilename(name)+`"`)
+	c.Data(http.StatusOK, ct, content)
 }
 
 // downloadFolderZip retourne un ZIP du dossier (récursif). Uniquement pour les dossiers.
@@ -1327,7 +1559,7 @@ func (h *Handler) downloadFolderZip(c *gin.Context) {
 	var isFolder bool
 	err = h.dbex(ctx).QueryRow(`
 		SELECT name, is_folder FROM drive_nodes
-		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+		WHERE id = $1 AND deleted_at IS NULL AND `+nodeAccessSQL("id", accessViewer)+`
 	`, id).Scan(&name, &isFolder)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
@@ -1341,6 +1573,12 @@ func (h *Handler) downloadFolderZip(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "not a folder"})
 		return
 	}
+	h.writeFolderZip(c, id, name)
+}
+
+// writeFolderZip construit le ZIP du dossier id (utilisateur épinglé dans le contexte) et l'envoie.
+func (h *Handler) writeFolderZip(c *gin.Context, id int, name string) {
+	ctx := c.Request.Context()
 	var buf bytes.Buffer
 	w := zip.NewWriter(&buf)
 	if err := h.addFolderToZip(ctx, w, id, name+"/"); err != nil {
@@ -1353,20 +1591,18 @@ func (h *Handler) downloadFolderZip(c *gin.Context) {
 		return
 	}
 	zipName := name + ".zip"
-	if !strings.HasSuffix(strings.ToLower(zipName), ".zip") {
-		zipName = name + ".zip"
-	}
 	c.Header("Content-Type", "application/zip")
-	c.Header("Content-Disposition", "attachment; filename=\""+zipName+"\"")
+	c.Header("Content-Disposition", "attachment; filename=\""+dispositionFilename(zipName)+"\"")
 	c.Data(http.StatusOK, "application/zip", buf.Bytes())
 }
 
 // addFolderToZip ajoute récursivement le contenu du dossier dans le zip (prefix = chemin dans l'archive).
+// L'accès au dossier racine est vérifié par l'appelant ; les descendants héritent de ses droits.
 func (h *Handler) addFolderToZip(ctx context.Context, w *zip.Writer, folderID int, prefix string) error {
 	rows, err := h.dbex(ctx).Query(`
 		SELECT id, name, is_folder, content, COALESCE(mime_type, '')
 		FROM drive_nodes
-		WHERE parent_id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+		WHERE parent_id = $1 AND deleted_at IS NULL AND photo_locked_at IS NULL
 	`, folderID)
 	if err != nil {
 		return err
@@ -1434,7 +1670,7 @@ func (h *Handler) downloadArchiveZip(c *gin.Context) {
 		var isFolder bool
 		err := h.dbex(ctx).QueryRow(`
 			SELECT name, is_folder FROM drive_nodes
-			WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+			WHERE id = $1 AND deleted_at IS NULL AND photo_locked_at IS NULL AND `+nodeAccessSQL("id", accessViewer)+`
 		`, nodeID).Scan(&name, &isFolder)
 		if err == sql.ErrNoRows {
 			continue
@@ -1457,7 +1693,7 @@ func (h *Handler) downloadArchiveZip(c *gin.Context) {
 		} else {
 			var content []byte
 			if err := h.dbex(ctx).QueryRow(`
-				SELECT COALESCE(content, ''::bytea) FROM drive_nodes WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+				SELECT COALESCE(content, ''::bytea) FROM drive_nodes WHERE id = $1 AND deleted_at IS NULL
 			`, nodeID).Scan(&content); err != nil {
 				w.Close()
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -1493,6 +1729,9 @@ func (h *Handler) putNodeContent(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	if !h.requireLockedWrite(c, id) {
+		return
+	}
 	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
@@ -1511,9 +1750,39 @@ func (h *Handler) putNodeContent(c *gin.Context) {
 	size := int64(len(body))
 	contentHash := sha256HexContent(body)
 	ctx := c.Request.Context()
-	res, err := h.dbex(ctx).Exec(`
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	// Éditeur d'un partage interne : le quota débité est celui du propriétaire.
+	var oldSize int64
+	var uid, tid int
+	var oldHash string
+	err = tx.QueryRow(`
+		SELECT size, user_id, tenant_id, COALESCE(content_hash, '') FROM drive_nodes
+		WHERE id = $1 AND is_folder = false AND deleted_at IS NULL AND `+nodeAccessSQL("id", accessEditor)+`
+		FOR UPDATE
+	`, id).Scan(&oldSize, &uid, &tid, &oldHash)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	level, err := reserveQuota(tx, uid, tid, size-oldSize)
+	if err != nil {
+		if !writeQuotaError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	res, err := tx.Exec(`
 		UPDATE drive_nodes SET content = $1, size = $2, mime_type = $3, vault_encrypted = $4, content_hash = $5, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $6 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND is_folder = false AND deleted_at IS NULL
+		WHERE id = $6 AND is_folder = false AND deleted_at IS NULL
 	`, body, size, mimeType, vaultEncrypted, contentHashParam(contentHash), id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -1524,6 +1793,15 @@ func (h *Handler) putNodeContent(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if oldHash != contentHash {
+		h.dropThumbnails(ctx, oldHash)
+	}
+	setQuotaLevelHeader(c, level)
+	kickContentWorkers()
 	c.JSON(http.StatusOK, gin.H{"id": id, "size": size})
 }
 
@@ -1594,33 +1872,72 @@ func (h *Handler) uploadFile(c *gin.Context) {
 	contentHash := sha256HexContent(content)
 	ctx := c.Request.Context()
 
+	var parentID int
+	if parentIDStr != "" && parentIDStr != "null" {
+		var perr error
+		parentID, perr = strconv.Atoi(parentIDStr)
+		if perr != nil || parentID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parent_id"})
+			return
+		}
+		// Dossier parent partagé : rôle éditeur requis, le fichier appartient au propriétaire du dossier.
+		parent, ok := h.requireNodeAccess(c, parentID, accessEditor)
+		if !ok {
+			return
+		}
+		uid, tid = parent.UserID, parent.TenantID
+	}
+
 	if overwrite {
 		var existingID int
-		if parentIDStr == "" || parentIDStr == "null" {
+		if parentID == 0 {
 			err = h.dbex(ctx).QueryRow(`
 				SELECT id FROM drive_nodes
 				WHERE user_id = current_setting('app.current_user_id', true)::INTEGER AND parent_id IS NULL AND name = $1 AND is_folder = false
 			`, name).Scan(&existingID)
 		} else {
-			parentID, perr := strconv.Atoi(parentIDStr)
-			if perr != nil || parentID <= 0 {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parent_id"})
-				return
-			}
 			err = h.dbex(ctx).QueryRow(`
 				SELECT id FROM drive_nodes
-				WHERE user_id = current_setting('app.current_user_id', true)::INTEGER AND parent_id = $1 AND name = $2 AND is_folder = false
-			`, parentID, name).Scan(&existingID)
+				WHERE user_id = $3 AND parent_id = $1 AND name = $2 AND is_folder = false
+			`, parentID, name, uid).Scan(&existingID)
 		}
 		if err == nil {
-			_, err = h.dbex(ctx).Exec(`
+			tx, err := h.dbex(ctx).Begin()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			defer tx.Rollback()
+			var oldSize int64
+			var oldHash string
+			if err := tx.QueryRow(`SELECT size, COALESCE(content_hash, '') FROM drive_nodes WHERE id = $1 FOR UPDATE`, existingID).Scan(&oldSize, &oldHash); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			level, err := reserveQuota(tx, uid, tid, size-oldSize)
+			if err != nil {
+				if !writeQuotaError(c, err) {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				}
+				return
+			}
+			_, err = tx.Exec(`
 				UPDATE drive_nodes SET content = $1, size = $2, mime_type = $3, taken_at = COALESCE($4, taken_at), content_hash = $5, updated_at = CURRENT_TIMESTAMP
-				WHERE id = $6 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND is_folder = false
+				WHERE id = $6 AND is_folder = false
 			`, content, size, mimeType, takenAt, contentHashParam(contentHash), existingID)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
+			if err := tx.Commit(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if oldHash != contentHash {
+				h.dropThumbnails(ctx, oldHash)
+			}
+			setQuotaLevelHeader(c, level)
+			kickContentWorkers()
 			c.JSON(http.StatusOK, gin.H{"id": existingID, "name": name, "size": size})
 			return
 		}
@@ -1630,19 +1947,27 @@ func (h *Handler) uploadFile(c *gin.Context) {
 		}
 	}
 
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	level, err := reserveQuota(tx, uid, tid, size)
+	if err != nil {
+		if !writeQuotaError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
 	var id int
-	if parentIDStr == "" || parentIDStr == "null" {
-		err = h.dbex(ctx).QueryRow(`
+	if parentID == 0 {
+		err = tx.QueryRow(`
 			INSERT INTO drive_nodes (tenant_id, user_id, parent_id, name, is_folder, size, mime_type, content, taken_at, content_hash)
 			VALUES ($1, $2, NULL, $3, false, $4, $5, $6, $7, $8) RETURNING id
 		`, tid, uid, name, size, mimeType, content, takenAt, contentHashParam(contentHash)).Scan(&id)
 	} else {
-		parentID, perr := strconv.Atoi(parentIDStr)
-		if perr != nil || parentID <= 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parent_id"})
-			return
-		}
-		err = h.dbex(ctx).QueryRow(`
+		err = tx.QueryRow(`
 			INSERT INTO drive_nodes (tenant_id, user_id, parent_id, name, is_folder, size, mime_type, content, taken_at, content_hash)
 			VALUES ($1, $2, $3, $4, false, $5, $6, $7, $8, $9) RETURNING id
 		`, tid, uid, parentID, name, size, mimeType, content, takenAt, contentHashParam(contentHash)).Scan(&id)
@@ -1656,5 +1981,11 @@ func (h *Handler) uploadFile(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	setQuotaLevelHeader(c, level)
+	kickContentWorkers()
 	c.JSON(http.StatusCreated, gin.H{"id": id, "name": name, "size": size})
 }
@@ -0,0 +1,840 @@
+package main
+
+// photo_albums.go — albums Photos (migration 54).
+//
+// Un album référence des nœuds Drive sans les copier : l'élément reste la propriété de
+// celui qui l'a ajouté (quota, corbeille) et disparaît de l'album avec le nœud.
+// Rôles : propriétaire (tout), contributor (ajoute / retire ses propres photos), viewer.
+// drive_node_access ouvre la lecture des éléments aux membres : les routes habituelles
+// /drive/nodes/:id/content|thumbnail|stream fonctionnent donc telles quelles.
+// Les liens publics d'album réutilisent drive_share_links (album_id, lecture seule) et
+// épinglent la connexion sur le propriétaire de l'album.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	albumRoleViewer      = "viewer"
+	albumRoleContributor = "contributor"
+	albumRoleOwner       = "owner"
+
+	albumSortTakenDesc = "taken_desc"
+	albumSortTakenAsc  = "taken_asc"
+	albumSortManual    = "manual"
+
+	albumMaxItemsPerRequest = 500
+)
+
+// albumRoleLevel : viewer < contributor < owner (0 si inconnu).
+func albumRoleLevel(role string) int {
+	switch role {
+	case albumRoleViewer:
+		return 1
+	case albumRoleContributor:
+		return 2
+	case albumRoleOwner:
+		return 3
+	}
+	return 0
+}
+
+// albumOrderSQL — tri des éléments (colonnes de photoNodeSelectSQL + album_position / album_item_id).
+func albumOrderSQL(sortMode string) string {
+	switch sortMode {
+	case albumSortTakenAsc:
+		return `COALESCE(taken_at, created_at) ASC NULLS LAST, id ASC`
+	case albumSortManual:
+		return `album_position ASC, album_item_id ASC`
+	}
+	return `COALESCE(taken_at, created_at) DESC NULLS LAST, id DESC`
+}
+
+// albumItemsFromSQL — éléments visibles d'un album ($1) : nœud non supprimé, ni verrouillé ni chiffré.
+// La sous-requête expose les colonnes de drive_nodes sans ambiguïté pour photoNodeSelectSQL.
+const albumItemsFromSQL = `
+	FROM (
+		SELECT n.*, i.position AS album_position, i.id AS album_item_id
+		FROM photo_album_items i
+		INNER JOIN drive_nodes n ON n.id = i.node_id
+		WHERE i.album_id = $1
+	) AS album_nodes
+	WHERE deleted_at IS NULL AND photo_locked_at IS NULL AND vault_encrypted = false`
+
+type photoAlbum struct {
+	ID          int    `json:"id"`
+	OwnerID     int    `json:"owner_id"`
+	OwnerEmail  string `json:"owner_email,omitempty"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	CoverNodeID *int   `json:"cover_node_id"`
+	SortMode    string `json:"sort_mode"`
+	Role        string `json:"role"`
+	ItemCount   int    `json:"item_count"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+	Items       []Node `json:"items,omitempty"`
+}
+
+type albumMember struct {
+	UserID  int    `json:"user_id"`
+	Email   string `json:"email"`
+	Role    string `json:"role"`
+	AddedAt string `json:"added_at"`
+}
+
+// publicAlbumItem — vue anonyme d'un élément (sans identifiants utilisateur / tenant).
+type publicAlbumItem struct {
+	ID         int     `json:"id"`
+	Name       string  `json:"name"`
+	Size       int64   `json:"size"`
+	MimeType   *string `json:"mime_type,omitempty"`
+	TakenAt    string  `json:"taken_at,omitempty"`
+	MediaKind  string  `json:"media_kind"`
+	DurationMs int64   `json:"duration_ms,omitempty"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+}
+
+// albumSelectSQL — colonnes de photoAlbum pour l'utilisateur courant (alias a, m = son adhésion).
+// Couverture par défaut : premier élément visible dans l'ordre de l'album.
+const albumSelectSQL = `
+	a.id, a.user_id, COALESCE(u.email, ''), a.title, a.description, a.sort_mode,
+	CASE WHEN a.user_id = current_setting('app.current_user_id', true)::INTEGER THEN 'owner' ELSE m.role END,
+	COALESCE(a.cover_node_id, (
+		SELECT i.node_id FROM photo_album_items i
+		INNER JOIN drive_nodes n ON n.id = i.node_id
+		WHERE i.album_id = a.id AND n.deleted_at IS NULL AND n.photo_locked_at IS NULL AND n.vault_encrypted = false
+		ORDER BY CASE WHEN a.sort_mode = 'manual' THEN i.position END, COALESCE(n.taken_at, n.created_at) DESC, i.id
+		LIMIT 1
+	)),
+	(SELECT COUNT(*) FROM photo_album_items i
+	 INNER JOIN drive_nodes n ON n.id = i.node_id
+	 WHERE i.album_id = a.id AND n.deleted_at IS NULL AND n.photo_locked_at IS NULL AND n.vault_encrypted = false),
+	a.created_at::text, a.updated_at::text`
+
+const albumFromSQL = `
+	FROM photo_albums a
+	LEFT JOIN photo_album_members m
+	  ON m.album_id = a.id AND m.user_id = current_setting('app.current_user_id', true)::INTEGER
+	LEFT JOIN users u ON u.id = a.user_id
+	WHERE (a.user_id = current_setting('app.current_user_id', true)::INTEGER OR m.user_id IS NOT NULL)`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPhotoAlbum(row rowScanner) (photoAlbum, error) {
+	var a photoAlbum
+	var cover sql.NullInt64
+	if err := row.Scan(&a.ID, &a.OwnerID, &a.OwnerEmail, &a.Title, &a.Description, &a.SortMode, &a.Role, &cover, &a.ItemCount, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		return a, err
+	}
+	if cover.Valid {
+		id := int(cover.Int64)
+		a.CoverNodeID = &id
+	}
+	return a, nil
+}
+
+func (h *Handler) loadPhotoAlbum(ctx context.Context, id int) (photoAlbum, error) {
+	return scanPhotoAlbum(h.dbex(ctx).QueryRow(`SELECT `+albumSelectSQL+albumFromSQL+` AND a.id = $1`, id))
+}
+
+// requireAlbum charge l'album pour l'utilisateur courant : 404 s'il n'y a pas accès,
+// 403 si son rôle est inférieur à minRole.
+func (h *Handler) requireAlbum(c *gin.Context, minRole string) (photoAlbum, bool) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return photoAlbum{}, false
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return photoAlbum{}, false
+	}
+	a, err := h.loadPhotoAlbum(c.Request.Context(), id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return a, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return a, false
+	}
+	if albumRoleLevel(a.Role) < albumRoleLevel(minRole) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return a, false
+	}
+	return a, true
+}
+
+func (h *Handler) loadAlbumItems(ctx context.Context, albumID int, sortMode string) ([]Node, error) {
+	rows, err := h.dbex(ctx).Query(`
+		SELECT `+photoNodeSelectSQL+albumItemsFromSQL+`
+		ORDER BY `+albumOrderSQL(sortMode), albumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]Node, 0)
+	for rows.Next() {
+		n, err := scanPhotoNodeRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, rows.Err()
+}
+
+func (h *Handler) touchAlbum(ctx context.Context, id int) {
+	h.dbex(ctx).Exec(`UPDATE photo_albums SET updated_at = NOW() WHERE id = $1`, id)
+}
+
+// listPhotoAlbums — GET /drive/photos/albums : albums possédés et partagés avec l'utilisateur.
+func (h *Handler) listPhotoAlbums(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusOK, []photoAlbum{})
+		return
+	}
+	rows, err := h.dbex(c.Request.Context()).Query(`SELECT ` + albumSelectSQL + albumFromSQL + ` ORDER BY a.updated_at DESC, a.id DESC`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	list := make([]photoAlbum, 0)
+	for rows.Next() {
+		a, err := scanPhotoAlbum(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list = append(list, a)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// createPhotoAlbum — POST /drive/photos/albums {"title": "...", "description": "...", "node_ids": [..]}
+func (h *Handler) createPhotoAlbum(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	var body struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		NodeIDs     []int  `json:"node_ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	title := strings.TrimSpace(body.Title)
+	if title == "" || len(title) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "title required (max 255 chars)"})
+		return
+	}
+	if len(body.NodeIDs) > albumMaxItemsPerRequest {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many node_ids"})
+		return
+	}
+	ctx := c.Request.Context()
+	var id int
+	err := h.dbex(ctx).QueryRow(`
+		INSERT INTO photo_albums (tenant_id, user_id, title, description)
+		SELECT tenant_id, id, $1, $2 FROM users WHERE id = current_setting('app.current_user_id', true)::INTEGER
+		RETURNING id
+	`, title, strings.TrimSpace(body.Description)).Scan(&id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unknown user"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(body.NodeIDs) > 0 {
+		if _, err := h.insertAlbumItems(ctx, id, body.NodeIDs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	a, err := h.loadPhotoAlbum(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, a)
+}
+
+// getPhotoAlbum — GET /drive/photos/albums/:id : album et éléments triés.
+func (h *Handler) getPhotoAlbum(c *gin.Context) {
+	a, ok := h.requireAlbum(c, albumRoleViewer)
+	if !ok {
+		return
+	}
+	items, err := h.loadAlbumItems(c.Request.Context(), a.ID, a.SortMode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	a.Items = items
+	c.JSON(http.StatusOK, a)
+}
+
+// updatePhotoAlbum — PATCH /drive/photos/albums/:id (propriétaire)
+// Body : {"title", "description", "cover_node_id" (0 = automatique), "sort_mode"} — champs optionnels.
+func (h *Handler) updatePhotoAlbum(c *gin.Context) {
+	a, ok := h.requireAlbum(c, albumRoleOwner)
+	if !ok {
+		return
+	}
+	var body struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		CoverNodeID *int    `json:"cover_node_id"`
+		SortMode    *string `json:"sort_mode"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	ctx := c.Request.Context()
+	var sets []string
+	args := sqlArgs{a.ID}
+	if body.Title != nil {
+		title := strings.TrimSpace(*body.Title)
+		if title == "" || len(title) > 255 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "title required (max 255 chars)"})
+			return
+		}
+		sets = append(sets, "title = "+args.add(title))
+	}
+	if body.Description != nil {
+		sets = append(sets, "description = "+args.add(strings.TrimSpace(*body.Description)))
+	}
+	if body.SortMode != nil {
+		mode := strings.ToLower(strings.TrimSpace(*body.SortMode))
+		if mode != albumSortTakenDesc && mode != albumSortTakenAsc && mode != albumSortManual {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sort_mode must be taken_desc, taken_asc or manual"})
+			return
+		}
+		sets = append(sets, "sort_mode = "+args.add(mode))
+	}
+	if body.CoverNodeID != nil {
+		if *body.CoverNodeID <= 0 {
+			sets = append(sets, "cover_node_id = NULL")
+		} else {
+			var isItem bool
+			if err := h.dbex(ctx).QueryRow(`SELECT EXISTS (SELECT 1 `+albumItemsFromSQL+` AND id = $2)`, a.ID, *body.CoverNodeID).Scan(&isItem); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !isItem {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "cover must be an item of the album"})
+				return
+			}
+			sets = append(sets, "cover_node_id = "+args.add(*body.CoverNodeID))
+		}
+	}
+	if len(sets) > 0 {
+		if _, err := h.dbex(ctx).Exec(`UPDATE photo_albums SET `+strings.Join(sets, ", ")+` WHERE id = $1`, args...); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	a, err := h.loadPhotoAlbum(ctx, a.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// deletePhotoAlbum — DELETE /drive/photos/albums/:id (propriétaire ; les photos restent dans Drive).
+func (h *Handler) deletePhotoAlbum(c *gin.Context) {
+	a, ok := h.requireAlbum(c, albumRoleOwner)
+	if !ok {
+		return
+	}
+	if _, err := h.dbex(c.Request.Context()).Exec(`DELETE FROM photo_albums WHERE id = $1`, a.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// insertAlbumItems ajoute à la fin de l'album les nœuds de l'utilisateur courant qui sont
+// des photos / vidéos (ni supprimées, ni verrouillées, ni chiffrées). Les doublons sont ignorés.
+func (h *Handler) insertAlbumItems(ctx context.Context, albumID int, nodeIDs []int) (int64, error) {
+	res, err := h.dbex(ctx).Exec(`
+		INSERT INTO photo_album_items (album_id, node_id, added_by, position)
+		SELECT $1, id, user_id,
+		       COALESCE((SELECT MAX(position) FROM photo_album_items WHERE album_id = $1), 0)
+		       + ROW_NUMBER() OVER (ORDER BY array_position($2::int[], id))
+		FROM drive_nodes
+		WHERE id = ANY($2::int[])
+		  AND user_id = current_setting('app.current_user_id', true)::INTEGER
+		  AND deleted_at IS NULL
+		  AND photo_locked_at IS NULL
+		  AND vault_encrypted = false
+		`+photoMediaFilterSQL+`
+		ON CONFLICT (album_id, node_id) DO NOTHING
+	`, albumID, pq.Array(nodeIDs))
+	if err != nil {
+		return 0, err
+	}
+	h.touchAlbum(ctx, albumID)
+	return res.RowsAffected()
+}
+
+// addPhotoAlbumItems — POST /drive/photos/albums/:id/items {"node_ids": [..]} (propriétaire ou contributor).
+// Seules les photos de l'appelant peuvent être ajoutées ; les autres identifiants sont ignorés.
+func (h *Handler) addPhotoAlbumItems(c *gin.Context) {
+	a, ok := h.requireAlbum(c, albumRoleContributor)
+	if !ok {
+		return
+	}
+	var body struct {
+		NodeIDs []int `json:"node_ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || len(body.NodeIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "node_ids required"})
+		return
+	}
+	if len(body.NodeIDs) > albumMaxItemsPerRequest {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many node_ids"})
+		return
+	}
+	added, err := h.insertAlbumItems(c.Request.Context(), a.ID, body.NodeIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"added": added, "ignored": int64(len(body.NodeIDs)) - added})
+}
+
+// removePhotoAlbumItem — DELETE /drive/photos/albums/:id/items/:nodeId
+// Le propriétaire retire n'importe quel élément, un contributor uniquement les siens.
+func (h *Handler) removePhotoAlbumItem(c *gin.Context) {
+	a, ok := h.requireAlbum(c, albumRoleContributor)
+	if !ok {
+		return
+	}
+	nodeID, err := strconv.Atoi(c.Param("nodeId"))
+	if err != nil || nodeID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+		return
+	}
+	ctx := c.Request.Context()
+	res, err := h.dbex(ctx).Exec(`
+		DELETE FROM photo_album_items
+		WHERE album_id = $1 AND node_id = $2
+		  AND ($3 OR added_by = current_setting('app.current_user_id', true)::INTEGER)
+	`, a.ID, nodeID, a.Role == albumRoleOwner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if aff, _ := res.RowsAffected(); aff == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	h.dbex(ctx).Exec(`UPDATE photo_albums SET cover_node_id = NULL WHERE id = $1 AND cover_node_id = $2`, a.ID, nodeID)
+	h.touchAlbum(ctx, a.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// reorderPhotoAlbum — PUT /drive/photos/albums/:id/order {"node_ids": [..]} (propriétaire).
+// Les éléments listés prennent cet ordre, les autres suivent dans leur ordre manuel actuel ;
+// l'album passe en tri manuel.
+func (h *Handler) reorderPhotoAlbum(c *gin.Context) {
+	a, ok := h.requireAlbum(c, albumRoleOwner)
+	if !ok {
+		return
+	}
+	var body struct {
+		NodeIDs []int `json:"node_ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || len(body.NodeIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "node_ids required"})
+		return
+	}
+	ctx := c.Request.Context()
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	// Décalage des non-listés derrière les listés (positions 1..n), ordre relatif conservé.
+	if _, err := tx.Exec(`
+		UPDATE photo_album_items i SET position = ranked.pos
+		FROM (
+			SELECT id, COALESCE(array_position($2::int[], node_id),
+			                    cardinality($2::int[]) + ROW_NUMBER() OVER (ORDER BY position, id)) AS pos
+			FROM photo_album_items WHERE album_id = $1
+		) ranked
+		WHERE i.id = ranked.id
+	`, a.ID, pq.Array(body.NodeIDs)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec(`UPDATE photo_albums SET sort_mode = 'manual', updated_at = NOW() WHERE id = $1`, a.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// listPhotoAlbumMembers — GET /drive/photos/albums/:id/members (tout membre).
+func (h *Handler) listPhotoAlbumMembers(c *gin.Context) {
+	a, ok := h.requireAlbum(c, albumRoleViewer)
+	if !ok {
+		return
+	}
+	rows, err := h.dbex(c.Request.Context()).Query(`
+		SELECT m.user_id, COALESCE(u.email, ''), m.role, m.added_at::text
+		FROM photo_album_members m
+		LEFT JOIN users u ON u.id = m.user_id
+		WHERE m.album_id = $1
+		ORDER BY m.added_at, m.user_id
+	`, a.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	list := make([]albumMember, 0)
+	for rows.Next() {
+		var m albumMember
+		if err := rows.Scan(&m.UserID, &m.Email, &m.Role, &m.AddedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list = append(list, m)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// addPhotoAlbumMember — POST /drive/photos/albums/:id/members (propriétaire).
+// Body : {"user_id": 12 | "email": "...", "role": "viewer"|"contributor"} ; upsert du rôle.
+func (h *Handler) addPhotoAlbumMember(c *gin.Context) {
+	a, ok := h.requireAlbum(c, albumRoleOwner)
+	if !ok {
+		return
+	}
+	var body struct {
+		UserID int    `json:"user_id"`
+		Email  string `json:"email"`
+		Role   string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	role := strings.ToLower(strings.TrimSpace(body.Role))
+	if role == "" {
+		role = albumRoleViewer
+	}
+	if role != albumRoleViewer && role != albumRoleContributor {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be viewer or contributor"})
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(body.Email))
+	if body.UserID <= 0 && email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id or email required"})
+		return
+	}
+	ctx := c.Request.Context()
+	var granteeID int
+	err := h.dbex(ctx).QueryRow(`
+		SELECT u.id FROM users u
+		INNER JOIN photo_albums a ON a.tenant_id = u.tenant_id
+		WHERE a.id = $1 AND (u.id = $2 OR ($3 <> '' AND LOWER(u.email) = $3)) AND u.is_active = true
+		LIMIT 1
+	`, a.ID, body.UserID, email).Scan(&granteeID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found in tenant"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if granteeID == a.OwnerID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot share with the owner"})
+		return
+	}
+	if _, err := h.dbex(ctx).Exec(`
+		INSERT INTO photo_album_members (album_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (album_id, user_id) DO UPDATE SET role = EXCLUDED.role
+	`, a.ID, granteeID, role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"album_id": a.ID, "user_id": granteeID, "role": role})
+}
+
+// removePhotoAlbumMember — DELETE /drive/photos/albums/:id/members/:userId
+// Le propriétaire retire un membre, un membre peut quitter l'album. Les photos ajoutées
+// par ce membre sont retirées de l'album (elles restent dans son Drive).
+func (h *Handler) removePhotoAlbumMember(c *gin.Context) {
+	a, ok := h.requireAlbum(c, albumRoleViewer)
+	if !ok {
+		return
+	}
+	userID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil || userID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+	ctx := c.Request.Context()
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+		DELETE FROM photo_album_members
+		WHERE album_id = $1 AND user_id = $2
+		  AND ($3 OR user_id = current_setting('app.current_user_id', true)::INTEGER)
+	`, a.ID, userID, a.Role == albumRoleOwner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if aff, _ := res.RowsAffected(); aff == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM photo_album_items WHERE album_id = $1 AND added_by = $2`, a.ID, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// createAlbumShareLink — POST /drive/photos/albums/:id/shares (propriétaire, lecture seule).
+// Body : {"password": "...", "expires_at": RFC3339, "max_downloads": 10}
+func (h *Handler) createAlbumShareLink(c *gin.Context) {
+	a, ok := h.requireAlbum(c, albumRoleOwner)
+	if !ok {
+		return
+	}
+	opts, ok := bindShareLinkOptions(c)
+	if !ok {
+		return
+	}
+	if opts.Mode != shareModeRead {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "album links are read-only"})
+		return
+	}
+	token, err := newShareToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token generation failed"})
+		return
+	}
+	var shareID int
+	var createdAt string
+	err = h.dbex(c.Request.Context()).QueryRow(`
+		INSERT INTO drive_share_links (token_hash, album_id, tenant_id, user_id, password_hash, mode, expires_at, max_downloads)
+		SELECT $1, id, tenant_id, user_id, $3, 'read', $4, $5 FROM photo_albums WHERE id = $2
+		RETURNING id, created_at::text
+	`, shareTokenHash(token), a.ID, opts.PasswordHash, opts.ExpiresAt, opts.MaxDownloads).Scan(&shareID, &createdAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	out := gin.H{
+		"id":           shareID,
+		"album_id":     a.ID,
+		"title":        a.Title,
+		"mode":         shareModeRead,
+		"has_password": opts.PasswordHash.Valid,
+		"token":        token,
+		"path":         "/drive/public/albums/" + token,
+		"created_at":   createdAt,
+	}
+	opts.describe(out)
+	c.JSON(http.StatusCreated, out)
+}
+
+// listAlbumShareLinks — GET /drive/photos/albums/:id/shares (propriétaire). Révocation : DELETE /drive/shares/:shareId.
+func (h *Handler) listAlbumShareLinks(c *gin.Context) {
+	a, ok := h.requireAlbum(c, albumRoleOwner)
+	if !ok {
+		return
+	}
+	rows, err := h.dbex(c.Request.Context()).Query(`
+		SELECT id, mode, password_hash IS NOT NULL,
+		       COALESCE(expires_at::text, ''), max_downloads, download_count,
+		       COALESCE(revoked_at::text, ''), COALESCE(last_used_at::text, ''), created_at::text
+		FROM drive_share_links
+		WHERE album_id = $1
+		ORDER BY created_at DESC, id DESC
+	`, a.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	list := make([]shareLinkInfo, 0)
+	for rows.Next() {
+		s := shareLinkInfo{NodeName: a.Title}
+		var maxDownloads sql.NullInt64
+		if err := rows.Scan(&s.ID, &s.Mode, &s.HasPassword, &s.ExpiresAt, &maxDownloads, &s.DownloadCount, &s.RevokedAt, &s.LastUsedAt, &s.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if maxDownloads.Valid {
+			s.MaxDownloads = &maxDownloads.Int64
+		}
+		list = append(list, s)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// openAlbumShareLink — openShareLink restreint aux liens d'album.
+func (h *Handler) openAlbumShareLink(c *gin.Context) (shareLink, func(), bool) {
+	l, release, ok := h.openShareLink(c)
+	if !ok {
+		return l, nil, false
+	}
+	if l.AlbumID == 0 {
+		release()
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return shareLink{}, nil, false
+	}
+	return l, release, true
+}
+
+// publicAlbumItemID lit :nodeId et vérifie qu'il s'agit d'un élément visible de l'album du lien.
+func (h *Handler) publicAlbumItemID(c *gin.Context, l shareLink) (int, bool) {
+	nodeID, err := strconv.Atoi(c.Param("nodeId"))
+	if err != nil || nodeID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+		return 0, false
+	}
+	var isItem bool
+	if err := h.dbex(c.Request.Context()).QueryRow(`SELECT EXISTS (SELECT 1 `+albumItemsFromSQL+` AND id = $2)`, l.AlbumID, nodeID).Scan(&isItem); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	if !isItem {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return 0, false
+	}
+	return nodeID, true
+}
+
+// getPublicAlbum — GET /drive/public/albums/:token : titre, couverture et éléments de l'album.
+func (h *Handler) getPublicAlbum(c *gin.Context) {
+	l, release, ok := h.openAlbumShareLink(c)
+	if !ok {
+		return
+	}
+	defer release()
+	ctx := c.Request.Context()
+	a, err := h.loadPhotoAlbum(ctx, l.AlbumID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	nodes, err := h.loadAlbumItems(ctx, a.ID, a.SortMode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	items := make([]publicAlbumItem, 0, len(nodes))
+	for _, n := range nodes {
+		items = append(items, publicAlbumItem{
+			ID: n.ID, Name: n.Name, Size: n.Size, MimeType: n.MimeType, TakenAt: n.TakenAt,
+			MediaKind: n.MediaKind, DurationMs: n.DurationMs, Width: n.Width, Height: n.Height,
+		})
+	}
+	out := gin.H{
+		"album_id":          a.ID,
+		"title":             a.Title,
+		"description":       a.Description,
+		"cover_node_id":     a.CoverNodeID,
+		"items":             items,
+		"requires_password": l.PasswordHash.Valid,
+	}
+	if l.ExpiresAt.Valid {
+		out["expires_at"] = l.ExpiresAt.Time.UTC().Format(time.RFC3339)
+	}
+	if l.MaxDownloads.Valid {
+		out["downloads_remaining"] = l.MaxDownloads.Int64 - l.DownloadCount
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// getPublicAlbumItemContent — GET /drive/public/albums/:token/items/:nodeId/content (compte un téléchargement).
+func (h *Handler) getPublicAlbumItemContent(c *gin.Context) {
+	l, release, ok := h.openAlbumShareLink(c)
+	if !ok {
+		return
+	}
+	defer release()
+	nodeID, ok := h.publicAlbumItemID(c, l)
+	if !ok {
+		return
+	}
+	var name string
+	var content []byte
+	var mime sql.NullString
+	err := h.dbex(c.Request.Context()).QueryRow(`
+		SELECT name, COALESCE(content, ''::bytea), mime_type FROM drive_nodes WHERE id = $1
+	`, nodeID).Scan(&name, &content, &mime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if rng := c.GetHeader("Range"); rng == "" || strings.HasPrefix(rng, "bytes=0-") {
+		if !h.consumeShareDownload(c, l) {
+			return
+		}
+	}
+	c.Header("Cache-Control", "private, no-store")
+	serveNodeContent(c, name, content, mime, false)
+}
+
+// getPublicAlbumItemThumbnail — GET /drive/public/albums/:token/items/:nodeId/thumbnail (ne compte pas).
+// La connexion est épinglée sur le propriétaire de l'album, qui voit tous ses éléments (drive_node_access).
+func (h *Handler) getPublicAlbumItemThumbnail(c *gin.Context) {
+	l, release, ok := h.openAlbumShareLink(c)
+	if !ok {
+		return
+	}
+	defer release()
+	nodeID, ok := h.publicAlbumItemID(c, l)
+	if !ok {
+		return
+	}
+	h.writeNodeThumbnail(c, nodeID)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAlbumRoleLevel(t *testing.T) {
+	if !(albumRoleLevel(albumRoleViewer) < albumRoleLevel(albumRoleContributor) &&
+		albumRoleLevel(albumRoleContributor) < albumRoleLevel(albumRoleOwner)) {
+		t.Fatal("album roles must be ordered viewer < contributor < owner")
+	}
+	if albumRoleLevel("") != 0 || albumRoleLevel("editor") != 0 {
+		t.Fatal("unknown roles must have level 0")
+	}
+}
+
+func TestAlbumOrderSQL(t *testing.T) {
+	if got := albumOrderSQL(albumSortManual); !strings.HasPrefix(got, "album_position") {
+		t.Fatalf("manual order = %q", got)
+	}
+	if got := albumOrderSQL(albumSortTakenAsc); !strings.Contains(got, "ASC") {
+		t.Fatalf("taken_asc order = %q", got)
+	}
+	if albumOrderSQL("bogus") != albumOrderSQL(albumSortTakenDesc) {
+		t.Fatal("unknown sort mode must fall back to taken_desc")
+	}
+}
+
+func TestPhotoAlbumsRequireAuth(t *testing.T) {
+	r := setupRouter(nil)
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/drive/photos/albums"},
+		{http.MethodPost, "/drive/photos/albums"},
+		{http.MethodGet, "/drive/photos/albums/1"},
+		{http.MethodPatch, "/drive/photos/albums/1"},
+		{http.MethodPost, "/drive/photos/albums/1/items"},
+		{http.MethodDelete, "/drive/photos/albums/1/items/2"},
+		{http.MethodPut, "/drive/photos/albums/1/order"},
+		{http.MethodPost, "/drive/photos/albums/1/members"},
+		{http.MethodPost, "/drive/photos/albums/1/shares"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without X-User-ID: got %d", tc.method, tc.path, w.Code)
+		}
+	}
+}
+
+func TestPublicAlbumDoesNotRequireUserID(t *testing.T) {
+	r := setupRouter(nil)
+	for _, path := range []string{
+		"/drive/public/albums/sometoken",
+		"/drive/public/albums/sometoken/items/3/content",
+		"/drive/public/albums/sometoken/items/3/thumbnail",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code == http.StatusUnauthorized {
+			t.Errorf("GET %s must not require X-User-ID, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestListPhotoAlbumsWithoutDB(t *testing.T) {
+	r := setupRouter(nil)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/drive/photos/albums", nil)
+	req.Header.Set("X-User-ID", "1")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("GET /drive/photos/albums without DB: %d %s", w.Code, w.Body.String())
+	}
+}
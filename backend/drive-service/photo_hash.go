@@ -0,0 +1,187 @@
+package main
+
+// photo_hash.go — empreintes perceptuelles des photos (table drive_photo_hashes, migration 56).
+//
+// dHash (gradients horizontaux sur une grille 9×8) et pHash (signe des basses fréquences
+// d'une DCT 32×32 par rapport à leur médiane), calculés sur la vignette sm JPEG déjà
+// redressée : une copie réencodée, redimensionnée ou recadrée légèrement garde une empreinte
+// proche (distance de Hamming faible), là où content_hash diffère. Le worker de vignettes
+// enchaîne ce calcul juste après l'upload ; le regroupement est fait par photos-service.
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"image"
+	"image/jpeg"
+	"log"
+	"math"
+	"sort"
+)
+
+// grayGrid réduit img à une grille w×h de luminances (moyenne de chaque zone, 0..255).
+func grayGrid(img image.Image, w, h int) []float64 {
+	b := img.Bounds()
+	sum := make([]float64, w*h)
+	cnt := make([]float64, w*h)
+	bw, bh := b.Dx(), b.Dy()
+	if bw <= 0 || bh <= 0 {
+		return sum
+	}
+	for y := 0; y < bh; y++ {
+		gy := y * h / bh
+		for x := 0; x < bw; x++ {
+			gx := x * w / bw
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			// Luma BT.601 sur des composantes 16 bits.
+			sum[gy*w+gx] += (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)) / 257
+			cnt[gy*w+gx]++
+		}
+	}
+	// Image plus petite que la grille : cases vides reprises de la case source la plus proche.
+	for gy := 0; gy < h; gy++ {
+		for gx := 0; gx < w; gx++ {
+			i := gy*w + gx
+			if cnt[i] > 0 {
+				sum[i] /= cnt[i]
+				continue
+			}
+			sx, sy := gx*bw/w, gy*bh/h
+			r, g, bl, _ := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+			sum[i] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)) / 257
+		}
+	}
+	return sum
+}
+
+// dHash : bit = pixel plus clair que son voisin de droite (grille 9×8 → 64 bits).
+func dHash(img image.Image) uint64 {
+	g := grayGrid(img, 9, 8)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if g[y*9+x] > g[y*9+x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// pHash : DCT-II 2D sur 32×32, coefficients 8×8 de plus basse fréquence comparés à leur
+// médiane (hors composante continue, qui ne dépend que de la luminosité moyenne).
+func pHash(img image.Image) uint64 {
+	const n, k = 32, 8
+	g := grayGrid(img, n, n)
+	var cos [k][n]float64
+	for u := 0; u < k; u++ {
+		for x := 0; x < n; x++ {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * n))
+		}
+	}
+	// Passe lignes puis colonnes, limitée aux k premières fréquences.
+	var rows [n][k]float64
+	for y := 0; y < n; y++ {
+		for u := 0; u < k; u++ {
+			var s float64
+			for x := 0; x < n; x++ {
+				s += g[y*n+x] * cos[u][x]
+			}
+			rows[y][u] = s
+		}
+	}
+	coef := make([]float64, 0, k*k)
+	for v := 0; v < k; v++ {
+		for u := 0; u < k; u++ {
+			var s float64
+			for y := 0; y < n; y++ {
+				s += rows[y][u] * cos[v][y]
+			}
+			coef = append(coef, s)
+		}
+	}
+	sorted := append([]float64(nil), coef[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	var h uint64
+	for _, c := range coef {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+	return h
+}
+
+// storePhotoHash enregistre les empreintes d'un contenu (ou l'échec, pour ne pas le retraiter).
+func (h *Handler) storePhotoHash(ctx context.Context, hash string, d, p uint64, cause error) error {
+	if cause != nil {
+		_, err := h.dbex(ctx).Exec(`
+			INSERT INTO drive_photo_hashes (content_hash, error) VALUES ($1, $2)
+			ON CONFLICT (content_hash) DO NOTHING
+		`, hash, cause.Error())
+		return err
+	}
+	_, err := h.dbex(ctx).Exec(`
+		INSERT INTO drive_photo_hashes (content_hash, dhash, phash) VALUES ($1, $2, $3)
+		ON CONFLICT (content_hash) DO UPDATE SET dhash = EXCLUDED.dhash, phash = EXCLUDED.phash, error = NULL, created_at = CURRENT_TIMESTAMP
+	`, hash, int64(d), int64(p))
+	return err
+}
+
+// computePendingPhotoHashes traite au plus limit contenus image dont la vignette sm existe
+// mais pas encore l'empreinte ; renvoie le nombre traité.
+func (h *Handler) computePendingPhotoHashes(ctx context.Context, limit int) (int, error) {
+	rows, err := h.dbex(ctx).Query(`
+		SELECT t.content_hash, t.data, t.error
+		FROM drive_thumbnails t
+		WHERE t.variant = 'sm' AND t.format = 'jpeg'
+		  AND NOT EXISTS (SELECT 1 FROM drive_photo_hashes p WHERE p.content_hash = t.content_hash)
+		  AND EXISTS (
+		    SELECT 1 FROM drive_nodes n
+		    WHERE n.content_hash = t.content_hash AND n.deleted_at IS NULL AND n.vault_encrypted = false
+		      AND (`+searchTypeClauses["image"]+`) AND NOT (`+searchTypeClauses["pdf"]+`)
+		  )
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return 0, err
+	}
+	type candidate struct {
+		hash   string
+		data   []byte
+		errMsg sql.NullString
+	}
+	list := make([]candidate, 0, limit)
+	for rows.Next() {
+		var it candidate
+		if err := rows.Scan(&it.hash, &it.data, &it.errMsg); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		list = append(list, it)
+	}
+	rows.Close()
+	for _, it := range list {
+		var d, p uint64
+		var cause error
+		if it.errMsg.Valid {
+			cause = errors.New("no thumbnail: " + it.errMsg.String)
+		} else if perr := runWorkerItem(func() {
+			img, err := jpeg.Decode(bytes.NewReader(it.data))
+			if err != nil {
+				cause = err
+				return
+			}
+			d, p = dHash(img), pHash(img)
+		}); perr != nil {
+			cause = perr
+		}
+		if err := h.storePhotoHash(ctx, it.hash, d, p, cause); err != nil {
+			log.Printf("[drive] photo hash %s: %v", it.hash, err)
+		}
+	}
+	return len(list), nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/bits"
+	"testing"
+)
+
+// hashScene dessine une scène synthétique (dégradé + disque) ; shift déplace le disque.
+func hashScene(w, h int, shift float64) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	cx, cy, r := (0.35+shift)*float64(w), 0.45*float64(h), 0.2*float64(h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(40 + 160*x/w)
+			dx, dy := float64(x)-cx, float64(y)-cy
+			if dx*dx+dy*dy < r*r {
+				v = 240
+			}
+			img.Set(x, y, color.RGBA{v, uint8(200 - 150*y/h), v / 2, 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHashesSurviveReencoding(t *testing.T) {
+	orig := hashScene(256, 192, 0)
+	// Copie réduite et recompressée fortement.
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleToFit(orig, 120), &jpeg.Options{Quality: 35}); err != nil {
+		t.Fatal(err)
+	}
+	copyImg, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := bits.OnesCount64(pHash(orig) ^ pHash(copyImg)); d > 6 {
+		t.Errorf("pHash distance for re-encoded copy = %d", d)
+	}
+	if d := bits.OnesCount64(dHash(orig) ^ dHash(copyImg)); d > 8 {
+		t.Errorf("dHash distance for re-encoded copy = %d", d)
+	}
+	other := hashScene(256, 192, 0.35)
+	if d := bits.OnesCount64(pHash(orig) ^ pHash(other)); d < 10 {
+		t.Errorf("pHash distance for a different picture = %d, want clearly apart", d)
+	}
+}
+
+func TestGrayGridSmallImage(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = 100
+	}
+	for i, v := range grayGrid(img, 9, 8) {
+		if v < 99.5 || v > 100.5 {
+			t.Fatalf("cell %d = %v, want 100", i, v)
+		}
+	}
+}
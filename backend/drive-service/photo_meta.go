@@ -0,0 +1,422 @@
+package main
+
+// photo_meta.go — métadonnées EXIF complètes des photos (migration 55) : GPS, appareil,
+// objectif, dimensions, orientation, exposition, et lieu par géocodage inverse hors ligne.
+//
+// Extraites par le worker de vignettes pour chaque contenu (content_hash) ; photo_meta_hash
+// mémorise le contenu analysé, un remplacement de fichier relance donc l'extraction. Les
+// vidéos y passent aussi (atomes MP4, position ISO 6709). Lecture : GET /drive/nodes/:id/metadata.
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+type photoExif struct {
+	TakenAt       time.Time
+	HasGPS        bool
+	Lat, Lon      float64
+	Altitude      *float64
+	Make          string
+	Model         string
+	Lens          string
+	Width, Height int // dimensions d'affichage (orientation appliquée)
+	Orientation   int
+	ISO           int
+	ExposureTime  string // "1/125", "2.5"
+	FNumber       float64
+	FocalLength   float64 // mm
+}
+
+// exifString lit un tag texte (espaces / NUL de fin retirés).
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+func exifInt(x *exif.Exif, name exif.FieldName) int {
+	tag, err := x.Get(name)
+	if err != nil {
+		return 0
+	}
+	if tag.Format() == tiff.RatVal {
+		if num, den, err := tag.Rat2(0); err == nil && den != 0 {
+			return int(num / den)
+		}
+		return 0
+	}
+	v, err := tag.Int(0)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func exifRat(x *exif.Exif, name exif.FieldName) (num, den int64, ok bool) {
+	tag, err := x.Get(name)
+	if err != nil {
+		return 0, 0, false
+	}
+	num, den, err = tag.Rat2(0)
+	if err != nil || den == 0 {
+		return 0, 0, false
+	}
+	return num, den, true
+}
+
+// formatExposure : "1/125" pour les temps courts, secondes décimales au-delà.
+func formatExposure(num, den int64) string {
+	if num <= 0 || den <= 0 {
+		return ""
+	}
+	if num < den {
+		return fmt.Sprintf("1/%d", int64(math.Round(float64(den)/float64(num))))
+	}
+	return strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64)
+}
+
+// normalizeCameraMake harmonise quelques marques écrites différemment selon les firmwares.
+func normalizeCameraMake(make string) string {
+	make = strings.Join(strings.Fields(make), " ")
+	switch strings.ToUpper(make) {
+	case "NIKON CORPORATION", "NIKON":
+		return "Nikon"
+	case "CANON":
+		return "Canon"
+	case "SONY":
+		return "Sony"
+	case "OLYMPUS IMAGING CORP.", "OLYMPUS CORPORATION", "OM DIGITAL SOLUTIONS":
+		return "Olympus"
+	case "FUJIFILM":
+		return "Fujifilm"
+	case "SAMSUNG":
+		return "Samsung"
+	case "HUAWEI":
+		return "Huawei"
+	case "XIAOMI":
+		return "Xiaomi"
+	case "GOOGLE":
+		return "Google"
+	}
+	return make
+}
+
+// extractPhotoExif lit l'ensemble des métadonnées EXIF utiles (HEIC/JPEG/TIFF). ok=false si aucun bloc EXIF.
+func extractPhotoExif(name, contentType string, content []byte) (photoExif, bool) {
+	var m photoExif
+	if len(content) == 0 {
+		return m, false
+	}
+	var x *exif.Exif
+	for _, r := range exifSources(name, contentType, content) {
+		if d, err := exif.Decode(r); err == nil {
+			x = d
+			break
+		}
+	}
+	if x == nil {
+		return m, false
+	}
+	if t, ok := photoTakenAtFromExif(name, contentType, content); ok {
+		m.TakenAt = t
+	}
+	if lat, lon, err := x.LatLong(); err == nil && !math.IsNaN(lat) && !math.IsNaN(lon) &&
+		math.Abs(lat) <= 90 && math.Abs(lon) <= 180 && (lat != 0 || lon != 0) {
+		m.HasGPS, m.Lat, m.Lon = true, lat, lon
+		if num, den, ok := exifRat(x, exif.GPSAltitude); ok {
+			alt := float64(num) / float64(den)
+			if exifInt(x, exif.GPSAltitudeRef) == 1 {
+				alt = -alt
+			}
+			m.Altitude = &alt
+		}
+	}
+	m.Make = normalizeCameraMake(exifString(x, exif.Make))
+	m.Model = strings.Join(strings.Fields(exifString(x, exif.Model)), " ")
+	m.Lens = exifString(x, exif.LensModel)
+	m.Orientation = exifInt(x, exif.Orientation)
+	if m.Orientation < 1 || m.Orientation > 8 {
+		m.Orientation = 1
+	}
+	m.ISO = exifInt(x, exif.ISOSpeedRatings)
+	if num, den, ok := exifRat(x, exif.ExposureTime); ok {
+		m.ExposureTime = formatExposure(num, den)
+	}
+	if num, den, ok := exifRat(x, exif.FNumber); ok {
+		m.FNumber = math.Round(float64(num)/float64(den)*10) / 10
+	}
+	if num, den, ok := exifRat(x, exif.FocalLength); ok {
+		m.FocalLength = math.Round(float64(num)/float64(den)*10) / 10
+	}
+	m.Width, m.Height = exifInt(x, exif.PixelXDimension), exifInt(x, exif.PixelYDimension)
+	if m.Width <= 0 || m.Height <= 0 {
+		m.Width, m.Height = exifInt(x, exif.ImageWidth), exifInt(x, exif.ImageLength)
+	}
+	if m.Width <= 0 || m.Height <= 0 {
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(content)); err == nil {
+			m.Width, m.Height = cfg.Width, cfg.Height
+		}
+	}
+	if m.Orientation >= 5 {
+		m.Width, m.Height = m.Height, m.Width
+	}
+	return m, true
+}
+
+var iso6709Re = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)?`)
+
+// parseISO6709 lit une position vidéo "+48.8577+002.2950+035.000/" (degrés décimaux).
+func parseISO6709(s string) (lat, lon float64, alt *float64, ok bool) {
+	m := iso6709Re.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, 0, nil, false
+	}
+	lat, err1 := strconv.ParseFloat(m[1], 64)
+	lon, err2 := strconv.ParseFloat(m[2], 64)
+	if err1 != nil || err2 != nil || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return 0, 0, nil, false
+	}
+	if m[3] != "" {
+		if a, err := strconv.ParseFloat(m[3], 64); err == nil {
+			alt = &a
+		}
+	}
+	return lat, lon, alt, true
+}
+
+// nullIfZero — paramètre SQL NULL pour les valeurs numériques absentes.
+func nullIfZero[T int | float64](v T) any {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
+
+// storePhotoMeta enregistre les métadonnées sur tous les nœuds de ce contenu et marque
+// le contenu comme analysé (photo_meta_hash), même sans EXIF.
+func (h *Handler) storePhotoMeta(ctx context.Context, hash string, m photoExif, found bool) error {
+	if !found {
+		_, err := h.dbex(ctx).Exec(`UPDATE drive_nodes SET photo_meta_hash = $1 WHERE content_hash = $1`, hash)
+		return err
+	}
+	var lat, lon, alt, takenAt any
+	var place geoPlace
+	if m.HasGPS {
+		lat, lon = m.Lat, m.Lon
+		if m.Altitude != nil {
+			alt = *m.Altitude
+		}
+		place, _ = reverseGeocode(m.Lat, m.Lon)
+	}
+	if !m.TakenAt.IsZero() {
+		takenAt = m.TakenAt
+	}
+	_, err := h.dbex(ctx).Exec(`
+		UPDATE drive_nodes SET
+			gps_lat = $2, gps_lon = $3, gps_alt = $4,
+			camera_make = NULLIF($5, ''), camera_model = NULLIF($6, ''), lens_model = NULLIF($7, ''),
+			media_width = COALESCE($8, media_width), media_height = COALESCE($9, media_height),
+			exif_orientation = $10, iso = $11, exposure_time = NULLIF($12, ''), f_number = $13, focal_length = $14,
+			place_name = NULLIF($15, ''), place_admin = NULLIF($16, ''), place_country = NULLIF($17, ''),
+			taken_at = COALESCE(taken_at, $18),
+			photo_meta_hash = $1
+		WHERE content_hash = $1
+	`, hash, lat, lon, alt,
+		truncateRunes(m.Make, 64), truncateRunes(m.Model, 128), truncateRunes(m.Lens, 128),
+		nullIfZero(m.Width), nullIfZero(m.Height),
+		m.Orientation, nullIfZero(m.ISO), truncateRunes(m.ExposureTime, 16), nullIfZero(m.FNumber), nullIfZero(m.FocalLength),
+		truncateRunes(place.Name, 200), truncateRunes(place.Admin, 200), truncateRunes(place.Country, 2),
+		takenAt)
+	return err
+}
+
+// storeVideoLocation enregistre la position (ISO 6709) d'une vidéo et son lieu.
+func (h *Handler) storeVideoLocation(ctx context.Context, hash, location string) error {
+	lat, lon, alt, ok := parseISO6709(location)
+	if !ok {
+		_, err := h.dbex(ctx).Exec(`UPDATE drive_nodes SET photo_meta_hash = $1 WHERE content_hash = $1`, hash)
+		return err
+	}
+	var altParam any
+	if alt != nil {
+		altParam = *alt
+	}
+	place, _ := reverseGeocode(lat, lon)
+	_, err := h.dbex(ctx).Exec(`
+		UPDATE drive_nodes SET gps_lat = $2, gps_lon = $3, gps_alt = $4,
+			place_name = NULLIF($5, ''), place_admin = NULLIF($6, ''), place_country = NULLIF($7, ''),
+			photo_meta_hash = $1
+		WHERE content_hash = $1
+	`, hash, lat, lon, altParam, truncateRunes(place.Name, 200), truncateRunes(place.Admin, 200), truncateRunes(place.Country, 2))
+	return err
+}
+
+func truncateRunes(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// extractPendingPhotoMeta analyse au plus limit contenus photo / vidéo non encore traités.
+func (h *Handler) extractPendingPhotoMeta(ctx context.Context, limit int) (int, error) {
+	rows, err := h.dbex(ctx).Query(`
+		SELECT DISTINCT ON (COALESCE(n.content_hash, n.id::text)) n.id, n.name, COALESCE(n.mime_type, ''), COALESCE(n.content_hash, ''), n.size
+		FROM drive_nodes n
+		WHERE n.deleted_at IS NULL AND n.vault_encrypted = false AND n.size > 0
+		  AND ((`+searchTypeClauses["image"]+` AND n.size <= $2) OR (`+searchTypeClauses["video"]+`))
+		  AND NOT (`+searchTypeClauses["pdf"]+`)
+		  AND (n.content_hash IS NULL OR n.photo_meta_hash IS DISTINCT FROM n.content_hash)
+		ORDER BY COALESCE(n.content_hash, n.id::text), n.id
+		LIMIT $1
+	`, limit, thumbMaxBytes())
+	if err != nil {
+		return 0, err
+	}
+	type candidate struct {
+		id               int
+		name, mime, hash string
+		size             int64
+	}
+	list := make([]candidate, 0, limit)
+	for rows.Next() {
+		var it candidate
+		if err := rows.Scan(&it.id, &it.name, &it.mime, &it.hash, &it.size); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		list = append(list, it)
+	}
+	rows.Close()
+	for _, it := range list {
+		hash := it.hash
+		if hash == "" {
+			if hash, err = h.ensureContentHash(ctx, it.id); err != nil {
+				log.Printf("[drive] photo meta node=%d: %v", it.id, err)
+				continue
+			}
+		}
+		perr := runWorkerItem(func() {
+			if isVideoLike(it.name, it.mime) {
+				meta, err := parseVideoMeta(&nodeContentReader{ctx: ctx, h: h, id: it.id, size: it.size}, it.size)
+				if err != nil {
+					meta = videoMeta{}
+				}
+				if err := h.storeVideoLocation(ctx, hash, meta.Location); err != nil {
+					log.Printf("[drive] video location node=%d: %v", it.id, err)
+				}
+				return
+			}
+			var content []byte
+			if err := h.dbex(ctx).QueryRow(`SELECT COALESCE(content, ''::bytea) FROM drive_nodes WHERE id = $1`, it.id).Scan(&content); err != nil {
+				log.Printf("[drive] photo meta node=%d: %v", it.id, err)
+				return
+			}
+			m, found := extractPhotoExif(it.name, it.mime, content)
+			if err := h.storePhotoMeta(ctx, hash, m, found); err != nil {
+				log.Printf("[drive] photo meta node=%d: %v", it.id, err)
+			}
+		})
+		if perr != nil {
+			// Contenu marqué traité sans métadonnées, pour ne pas le reprendre à chaque passage.
+			log.Printf("[drive] photo meta node=%d: %v", it.id, perr)
+			if err := h.storePhotoMeta(ctx, hash, photoExif{}, false); err != nil {
+				log.Printf("[drive] photo meta node=%d: %v", it.id, err)
+			}
+		}
+	}
+	return len(list), nil
+}
+
+// getNodeMetadata — GET /drive/nodes/:id/metadata : EXIF, position et lieu d'une photo / vidéo.
+func (h *Handler) getNodeMetadata(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var (
+		name, takenAt                         string
+		lat, lon, alt, fNumber, focal         sql.NullFloat64
+		camMake, camModel, lens, exposure     sql.NullString
+		placeName, placeAdmin, placeCountry   sql.NullString
+		width, height, orientation, iso, dura sql.NullInt64
+		analyzed, locked                      bool
+		ownerID                               int
+	)
+	err = h.dbex(c.Request.Context()).QueryRow(`
+		SELECT name, COALESCE(taken_at::text, ''), gps_lat, gps_lon, gps_alt,
+		       camera_make, camera_model, lens_model, media_width, media_height, exif_orientation,
+		       iso, exposure_time, f_number, focal_length, place_name, place_admin, place_country,
+		       duration_ms, photo_meta_hash IS NOT NULL AND photo_meta_hash = content_hash,
+		       photo_locked_at IS NOT NULL, user_id
+		FROM drive_nodes
+		WHERE id = $1 AND is_folder = false AND deleted_at IS NULL AND `+nodeAccessSQL("id", accessViewer)+`
+	`, id).Scan(&name, &takenAt, &lat, &lon, &alt, &camMake, &camModel, &lens, &width, &height, &orientation,
+		&iso, &exposure, &fNumber, &focal, &placeName, &placeAdmin, &placeCountry, &dura, &analyzed, &locked, &ownerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.requireLockedAccess(c, locked, ownerID) {
+		return
+	}
+	out := gin.H{"id": id, "name": name, "analyzed": analyzed}
+	put := func(key string, v any, valid bool) {
+		if valid {
+			out[key] = v
+		}
+	}
+	put("taken_at", takenAt, takenAt != "")
+	put("camera_make", camMake.String, camMake.Valid)
+	put("camera_model", camModel.String, camModel.Valid)
+	put("lens_model", lens.String, lens.Valid)
+	put("width", width.Int64, width.Valid)
+	put("height", height.Int64, height.Valid)
+	put("orientation", orientation.Int64, orientation.Valid)
+	put("iso", iso.Int64, iso.Valid)
+	put("exposure_time", exposure.String, exposure.Valid)
+	put("f_number", fNumber.Float64, fNumber.Valid)
+	put("focal_length", focal.Float64, focal.Valid)
+	put("duration_ms", dura.Int64, dura.Valid)
+	if lat.Valid && lon.Valid {
+		loc := gin.H{"latitude": lat.Float64, "longitude": lon.Float64}
+		if alt.Valid {
+			loc["altitude"] = alt.Float64
+		}
+		out["location"] = loc
+	}
+	if placeName.Valid {
+		out["place"] = gin.H{"name": placeName.String, "admin": placeAdmin.String, "country": placeCountry.String}
+	}
+	c.JSON(http.StatusOK, out)
+}
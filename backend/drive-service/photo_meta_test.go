@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// tiffEntry — entrée d'IFD pour le générateur EXIF de test (valeurs hors ligne gérées).
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+	data     []byte
+}
+
+func tiffASCII(tag uint16, s string) tiffEntry {
+	b := append([]byte(s), 0)
+	return tiffEntry{tag, 2, uint32(len(b)), b}
+}
+
+func tiffShort(tag uint16, v uint16) tiffEntry {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return tiffEntry{tag, 3, 1, b}
+}
+
+func tiffLong(tag uint16, v uint32) tiffEntry {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return tiffEntry{tag, 4, 1, b}
+}
+
+func tiffRationals(tag uint16, v ...[2]uint32) tiffEntry {
+	b := make([]byte, 8*len(v))
+	for i, r := range v {
+		binary.LittleEndian.PutUint32(b[8*i:], r[0])
+		binary.LittleEndian.PutUint32(b[8*i+4:], r[1])
+	}
+	return tiffEntry{tag, 5, uint32(len(v)), b}
+}
+
+// writeIFD écrit un IFD à l'offset off (relatif au début du TIFF) ; renvoie les octets.
+func writeIFD(off uint32, entries []tiffEntry) []byte {
+	var head, extra bytes.Buffer
+	binary.Write(&head, binary.LittleEndian, uint16(len(entries)))
+	extraAt := off + 2 + uint32(12*len(entries)) + 4
+	for _, e := range entries {
+		binary.Write(&head, binary.LittleEndian, e.tag)
+		binary.Write(&head, binary.LittleEndian, e.typ)
+		binary.Write(&head, binary.LittleEndian, e.count)
+		if len(e.data) <= 4 {
+			v := make([]byte, 4)
+			copy(v, e.data)
+			head.Write(v)
+		} else {
+			binary.Write(&head, binary.LittleEndian, extraAt+uint32(extra.Len()))
+			extra.Write(e.data)
+			if extra.Len()%2 == 1 {
+				extra.WriteByte(0)
+			}
+		}
+	}
+	binary.Write(&head, binary.LittleEndian, uint32(0))
+	return append(head.Bytes(), extra.Bytes()...)
+}
+
+// exifJPEG construit un JPEG minimal (SOI + APP1 Exif + EOI) : appareil, exposition, GPS.
+func exifJPEG(t *testing.T) []byte {
+	t.Helper()
+	const ifd0At = 8
+	// Taille d'IFD0 connue après écriture : on calcule les offsets en deux passes.
+	ifd0Entries := func(exifAt, gpsAt uint32) []tiffEntry {
+		return []tiffEntry{
+			tiffASCII(0x010F, "NIKON CORPORATION"),
+			tiffASCII(0x0110, "NIKON  Z 6"),
+			tiffShort(0x0112, 6),
+			tiffLong(0x8769, exifAt),
+			tiffLong(0x8825, gpsAt),
+		}
+	}
+	ifd0 := writeIFD(ifd0At, ifd0Entries(0, 0))
+	exifAt := uint32(ifd0At + len(ifd0))
+	exifIFD := writeIFD(exifAt, []tiffEntry{
+		tiffRationals(0x829A, [2]uint32{1, 250}),
+		tiffRationals(0x829D, [2]uint32{28, 10}),
+		tiffShort(0x8827, 400),
+		tiffASCII(0x9003, "2024:07:14 10:30:00"),
+		tiffRationals(0x920A, [2]uint32{50, 1}),
+		tiffLong(0xA002, 6000),
+		tiffLong(0xA003, 4000),
+	})
+	gpsAt := exifAt + uint32(len(exifIFD))
+	gpsIFD := writeIFD(gpsAt, []tiffEntry{
+		tiffASCII(0x0001, "N"),
+		tiffRationals(0x0002, [2]uint32{48, 1}, [2]uint32{51, 1}, [2]uint32{3096, 100}),
+		tiffASCII(0x0003, "E"),
+		tiffRationals(0x0004, [2]uint32{2, 1}, [2]uint32{17, 1}, [2]uint32{4200, 100}),
+		tiffShort(0x0005, 0),
+		tiffRationals(0x0006, [2]uint32{35, 1}),
+	})
+	ifd0 = writeIFD(ifd0At, ifd0Entries(exifAt, gpsAt))
+	var tiff bytes.Buffer
+	tiff.WriteString("II")
+	binary.Write(&tiff, binary.LittleEndian, uint16(42))
+	binary.Write(&tiff, binary.LittleEndian, uint32(ifd0At))
+	tiff.Write(ifd0)
+	tiff.Write(exifIFD)
+	tiff.Write(gpsIFD)
+
+	var out bytes.Buffer
+	out.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	binary.Write(&out, binary.BigEndian, uint16(len(payload)+2))
+	out.Write(payload)
+	out.Write([]byte{0xFF, 0xD9})
+	return out.Bytes()
+}
+
+func TestExtractPhotoExif(t *testing.T) {
+	m, ok := extractPhotoExif("DSC_0001.jpg", "image/jpeg", exifJPEG(t))
+	if !ok {
+		t.Fatal("expected EXIF block")
+	}
+	if m.Make != "Nikon" || m.Model != "NIKON Z 6" {
+		t.Errorf("camera = %q / %q", m.Make, m.Model)
+	}
+	if m.ISO != 400 || m.ExposureTime != "1/250" || m.FNumber != 2.8 || m.FocalLength != 50 {
+		t.Errorf("exposure = iso %d, %s, f/%v, %vmm", m.ISO, m.ExposureTime, m.FNumber, m.FocalLength)
+	}
+	// Orientation 6 (rotation 90°) : dimensions d'affichage inversées.
+	if m.Orientation != 6 || m.Width != 4000 || m.Height != 6000 {
+		t.Errorf("orientation %d, %dx%d", m.Orientation, m.Width, m.Height)
+	}
+	if !m.HasGPS || math.Abs(m.Lat-48.8586) > 0.001 || math.Abs(m.Lon-2.2950) > 0.001 {
+		t.Errorf("gps = %v %v,%v", m.HasGPS, m.Lat, m.Lon)
+	}
+	if m.Altitude == nil || *m.Altitude != 35 {
+		t.Errorf("altitude = %v", m.Altitude)
+	}
+	if m.TakenAt.Format("2006-01-02 15:04") != "2024-07-14 10:30" {
+		t.Errorf("taken_at = %v", m.TakenAt)
+	}
+	if _, ok := extractPhotoExif("plain.png", "image/png", []byte("not an image")); ok {
+		t.Error("no EXIF expected in arbitrary bytes")
+	}
+}
+
+func TestFormatExposure(t *testing.T) {
+	for _, tc := range []struct {
+		num, den int64
+		want     string
+	}{{1, 125, "1/125"}, {10, 1250, "1/125"}, {5, 2, "2.5"}, {2, 1, "2"}, {0, 1, ""}} {
+		if got := formatExposure(tc.num, tc.den); got != tc.want {
+			t.Errorf("formatExposure(%d/%d) = %q, want %q", tc.num, tc.den, got, tc.want)
+		}
+	}
+}
+
+func TestParseISO6709(t *testing.T) {
+	lat, lon, alt, ok := parseISO6709("+48.8577+002.2950+035.000/")
+	if !ok || lat != 48.8577 || lon != 2.295 || alt == nil || *alt != 35 {
+		t.Fatalf("got %v %v %v %v", lat, lon, alt, ok)
+	}
+	if _, _, alt, ok := parseISO6709("-33.8688+151.2093/"); !ok || alt != nil {
+		t.Fatal("position without altitude must parse")
+	}
+	if _, _, _, ok := parseISO6709("nowhere"); ok {
+		t.Fatal("invalid position must be rejected")
+	}
+}
+
+func TestReverseGeocodeEmbedded(t *testing.T) {
+	if p, ok := reverseGeocode(48.8584, 2.2945); !ok || p.Name != "Paris" || p.Country != "FR" {
+		t.Fatalf("Tour Eiffel → %+v %v", p, ok)
+	}
+	if p, ok := reverseGeocode(35.0262, 135.7983); !ok || p.Name != "Kyoto" {
+		t.Fatalf("Kyoto → %+v %v", p, ok)
+	}
+	// Antiméridien : la recherche en anneaux doit passer de 179°W à 179°E.
+	idx := newGeoIndex([]geoPlace{{Name: "Taveuni", Country: "FJ", Lat: -16.85, Lon: 179.95}})
+	if p, _, ok := idx.nearest(-16.8, -179.95, 50); !ok || p.Name != "Taveuni" {
+		t.Fatalf("antimeridian → %+v %v", p, ok)
+	}
+	if _, ok := reverseGeocode(-40, -30); ok {
+		t.Fatal("middle of the South Atlantic must not resolve")
+	}
+}
+
+func TestParsePlacesTSVGeoNames(t *testing.T) {
+	line := strings.Join([]string{"2988507", "Paris", "Paris", "", "48.85341", "2.3488", "P", "PPLC", "FR", "", "11", "75", "", "", "2138551", "", "42", "Europe/Paris", "2024-01-01"}, "\t")
+	places, err := parsePlacesTSV(strings.NewReader("# comment\n" + line + "\n"))
+	if err != nil || len(places) != 1 {
+		t.Fatalf("got %v %v", places, err)
+	}
+	if p := places[0]; p.Name != "Paris" || p.Country != "FR" || p.Admin != "11" || p.Lat != 48.85341 {
+		t.Fatalf("place = %+v", p)
+	}
+}
+
+func TestNodeMetadataRequiresAuth(t *testing.T) {
+	r := setupRouter(nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/drive/nodes/1/metadata", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /drive/nodes/1/metadata without X-User-ID: got %d", w.Code)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+// photos_locked.go — dossier verrouillé Photos protégé par un code (table drive_locked_pins, migration 58).
+//
+// photo_locked_at masque la photo de la timeline ; pour en lire le contenu (original,
+// vignettes, flux vidéo, métadonnées) ou la sortir du dossier, le propriétaire échange son
+// code contre un jeton d'accès HMAC court (POST /drive/photos/locked/unlock), transmis en
+// `X-Locked-Access` ou `?locked_access=` (balises <img> / <video>). Même schéma que les liens
+// de partage protégés (share_links.go) : Argon2id, jeton lié au hash courant du code.
+// Les nœuds verrouillés ne sortent jamais par les liens publics, les ZIP ni WebDAV.
+//
+// Rechiffrement optionnel : le client peut remplacer le contenu par un blob coffre
+// (PUT /drive/nodes/:id/content avec X-Cloudity-Vault-Encrypted: 1) ; le serveur ne détient
+// alors plus que le chiffré et ne génère plus de vignette.
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	lockedAccessTTL    = 10 * time.Minute
+	lockedPinMinLen    = 4
+	lockedPinMaxLen    = 128
+	lockedMaxAttempts  = 5
+	lockedBlockSeconds = 5 * 60
+)
+
+var errInvalidLockedAccess = errors.New("invalid locked access token")
+
+// lockedAccessMAC lie le jeton à l'utilisateur ET au hash du code courant.
+func lockedAccessMAC(secret []byte, userID int, pinHash string, expiry int64) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte("locked|" + strconv.Itoa(userID) + "|" + pinHash + "|" + strconv.FormatInt(expiry, 10)))
+	return m.Sum(nil)
+}
+
+func issueLockedAccess(secret []byte, userID int, pinHash string, now time.Time) string {
+	expiry := now.Add(lockedAccessTTL).Unix()
+	mac := lockedAccessMAC(secret, userID, pinHash, expiry)
+	return strconv.FormatInt(expiry, 10) + "." + base64.RawURLEncoding.EncodeToString(mac)
+}
+
+func verifyLockedAccess(secret []byte, access string, userID int, pinHash string, now time.Time) error {
+	parts := strings.SplitN(access, ".", 2)
+	if len(parts) != 2 {
+		return errInvalidLockedAccess
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || now.Unix() > expiry {
+		return errInvalidLockedAccess
+	}
+	got, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return errInvalidLockedAccess
+	}
+	if !hmac.Equal(got, lockedAccessMAC(secret, userID, pinHash, expiry)) {
+		return errInvalidLockedAccess
+	}
+	return nil
+}
+
+// validLockedPin — 4 à 128 caractères (code numérique ou phrase de passe).
+func validLockedPin(pin string) bool {
+	n := len([]rune(pin))
+	return n >= lockedPinMinLen && len(pin) <= lockedPinMaxLen
+}
+
+func lockedAccessFromRequest(c *gin.Context) string {
+	if v := strings.TrimSpace(c.GetHeader("X-Locked-Access")); v != "" {
+		return v
+	}
+	return strings.TrimSpace(c.Query("locked_access"))
+}
+
+// lockedPinHash renvoie le hash du code de l'utilisateur épinglé (sql.ErrNoRows si aucun).
+func (h *Handler) lockedPinHash(ctx context.Context) (string, error) {
+	var hash string
+	err := h.dbex(ctx).QueryRow(`
+		SELECT pin_hash FROM drive_locked_pins WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
+	`).Scan(&hash)
+	return hash, err
+}
+
+// requireLockedAccess laisse passer un nœud non verrouillé ; sinon exige que l'appelant en
+// soit le propriétaire et présente un jeton d'accès valide. Écrit la réponse d'erreur.
+func (h *Handler) requireLockedAccess(c *gin.Context, locked bool, ownerID int) bool {
+	if !locked {
+		return true
+	}
+	uid, _ := strconv.Atoi(c.GetHeader("X-User-ID"))
+	if uid != ownerID {
+		// Un nœud partagé puis verrouillé par son propriétaire n'est plus lisible par les invités.
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return false
+	}
+	hash, err := h.lockedPinHash(c.Request.Context())
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusForbidden, gin.H{"error": "locked_pin_required", "code": "LOCKED_PIN_REQUIRED"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if verifyLockedAccess(shareAccessSecret(), lockedAccessFromRequest(c), uid, hash, time.Now()) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "locked_access_required", "code": "LOCKED_ACCESS_REQUIRED"})
+		return false
+	}
+	return true
+}
+
+// requireLockedWrite applique requireLockedAccess avant une mutation (renommage, déplacement,
+// corbeille) : un nœud du dossier verrouillé n'est modifiable qu'avec le jeton d'accès.
+func (h *Handler) requireLockedWrite(c *gin.Context, id int) bool {
+	var locked bool
+	var ownerID int
+	err := h.dbex(c.Request.Context()).QueryRow(`
+		SELECT photo_locked_at IS NOT NULL, user_id FROM drive_nodes
+		WHERE id = $1 AND deleted_at IS NULL AND `+nodeAccessSQL("id", accessEditor)+`
+	`, id).Scan(&locked, &ownerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return h.requireLockedAccess(c, locked, ownerID)
+}
+
+// checkLockedPin vérifie pin contre le code enregistré avec limitation des essais ;
+// renvoie le hash courant. Écrit la réponse d'erreur.
+func (h *Handler) checkLockedPin(c *gin.Context, pin string) (string, bool) {
+	ctx := c.Request.Context()
+	var hash string
+	var blockedUntil sql.NullTime
+	err := h.dbex(ctx).QueryRow(`
+		SELECT pin_hash, blocked_until FROM drive_locked_pins WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
+	`).Scan(&hash, &blockedUntil)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "locked_pin_required", "code": "LOCKED_PIN_REQUIRED"})
+		return "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", false
+	}
+	if now := time.Now(); blockedUntil.Valid && now.Before(blockedUntil.Time) {
+		retry := int(blockedUntil.Time.Sub(now).Seconds()) + 1
+		c.Header("Retry-After", strconv.Itoa(retry))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too_many_attempts", "retry_after": retry})
+		return "", false
+	}
+	if ok, err := argon2id.ComparePasswordAndHash(pin, hash); err != nil || !ok {
+		if _, err := h.dbex(ctx).Exec(`
+			UPDATE drive_locked_pins
+			SET failed_attempts = CASE WHEN failed_attempts + 1 >= $1 THEN 0 ELSE failed_attempts + 1 END,
+			    blocked_until = CASE WHEN failed_attempts + 1 >= $1 THEN now() + make_interval(secs => $2) ELSE blocked_until END
+			WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
+		`, lockedMaxAttempts, lockedBlockSeconds); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return "", false
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_pin"})
+		return "", false
+	}
+	if _, err := h.dbex(ctx).Exec(`
+		UPDATE drive_locked_pins SET failed_attempts = 0, blocked_until = NULL
+		WHERE user_id = current_setting('app.current_user_id', true)::INTEGER AND (failed_attempts > 0 OR blocked_until IS NOT NULL)
+	`); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return hash, true
+}
+
+// getLockedStatus — GET /drive/photos/locked/status : code défini, jeton présenté encore valide.
+func (h *Handler) getLockedStatus(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	hash, err := h.lockedPinHash(c.Request.Context())
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, gin.H{"pin_set": false, "unlocked": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	uid, _ := strconv.Atoi(c.GetHeader("X-User-ID"))
+	unlocked := verifyLockedAccess(shareAccessSecret(), lockedAccessFromRequest(c), uid, hash, time.Now()) == nil
+	c.JSON(http.StatusOK, gin.H{"pin_set": true, "unlocked": unlocked})
+}
+
+// setLockedPin — PUT /drive/photos/locked/pin {"pin": "...", "current_pin": "..."} :
+// définit le code (current_pin exigé pour le changer). Les jetons déjà délivrés sont révoqués.
+func (h *Handler) setLockedPin(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	var body struct {
+		Pin        string `json:"pin"`
+		CurrentPin string `json:"current_pin"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || !validLockedPin(body.Pin) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pin must be 4 to 128 characters"})
+		return
+	}
+	ctx := c.Request.Context()
+	if _, err := h.lockedPinHash(ctx); err == nil {
+		if body.CurrentPin == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "current_pin required"})
+			return
+		}
+		if _, ok := h.checkLockedPin(c, body.CurrentPin); !ok {
+			return
+		}
+	} else if err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	hash, err := argon2id.CreateHash(body.Pin, shareArgon2idParams())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "pin hashing failed"})
+		return
+	}
+	if _, err := h.dbex(ctx).Exec(`
+		INSERT INTO drive_locked_pins (user_id, pin_hash)
+		VALUES (current_setting('app.current_user_id', true)::INTEGER, $1)
+		ON CONFLICT (user_id) DO UPDATE SET pin_hash = EXCLUDED.pin_hash, failed_attempts = 0, blocked_until = NULL, updated_at = now()
+	`, hash); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// unlockLockedPhotos — POST /drive/photos/locked/unlock {"pin": "..."} → jeton d'accès court.
+func (h *Handler) unlockLockedPhotos(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	var body struct {
+		Pin string `json:"pin"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Pin == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pin required"})
+		return
+	}
+	hash, ok := h.checkLockedPin(c, body.Pin)
+	if !ok {
+		return
+	}
+	uid, _ := strconv.Atoi(c.GetHeader("X-User-ID"))
+	c.JSON(http.StatusOK, gin.H{
+		"access":     issueLockedAccess(shareAccessSecret(), uid, hash, time.Now()),
+		"expires_in": int(lockedAccessTTL.Seconds()),
+	})
+}
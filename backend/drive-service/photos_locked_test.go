@@ -0,0 +1,108 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLockedAccessRoundTrip(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Now()
+	access := issueLockedAccess(secret, 7, "$argon2id$hash", now)
+	if err := verifyLockedAccess(secret, access, 7, "$argon2id$hash", now); err != nil {
+		t.Fatalf("valid access rejected: %v", err)
+	}
+	if err := verifyLockedAccess(secret, access, 8, "$argon2id$hash", now); err == nil {
+		t.Fatal("access must be bound to the user")
+	}
+	if err := verifyLockedAccess(secret, access, 7, "$argon2id$other", now); err == nil {
+		t.Fatal("changing the PIN must invalidate issued access")
+	}
+	if err := verifyLockedAccess(secret, access, 7, "$argon2id$hash", now.Add(lockedAccessTTL+time.Minute)); err == nil {
+		t.Fatal("expired access must be rejected")
+	}
+	// Un jeton de lien de partage (même secret) ne doit pas ouvrir le dossier verrouillé.
+	if err := verifyLockedAccess(secret, issueShareAccess(secret, 7, "$argon2id$hash", now), 7, "$argon2id$hash", now); err == nil {
+		t.Fatal("share access must not unlock locked photos")
+	}
+	if err := verifyLockedAccess(secret, "", 7, "$argon2id$hash", now); err == nil {
+		t.Fatal("empty access must be rejected")
+	}
+}
+
+func TestValidLockedPin(t *testing.T) {
+	cases := map[string]bool{
+		"":                false,
+		"123":             false,
+		"1234":            true,
+		"été!":            true,
+		"phrase de passe": true,
+	}
+	long := make([]byte, lockedPinMaxLen+1)
+	for i := range long {
+		long[i] = '7'
+	}
+	cases[string(long)] = false
+	for pin, want := range cases {
+		if got := validLockedPin(pin); got != want {
+			t.Errorf("validLockedPin(%q) = %v, want %v", pin, got, want)
+		}
+	}
+}
+
+func TestLockedPhotosRequireAuth(t *testing.T) {
+	r := setupRouter(nil)
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/drive/photos/locked/status"},
+		{http.MethodPut, "/drive/photos/locked/pin"},
+		{http.MethodPost, "/drive/photos/locked/unlock"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without X-User-ID: got %d", tc.method, tc.path, w.Code)
+		}
+	}
+}
+
+// Renommer, déplacer, mettre en corbeille ou réécrire une photo verrouillée exige le jeton d'accès.
+func TestLockedPhotoWritesRequireAccess(t *testing.T) {
+	db, fake := newFakeDB(func(q string, args []driver.Value) fakeResult {
+		switch {
+		case strings.Contains(q, "SELECT photo_locked_at IS NOT NULL, user_id"):
+			return fakeRow(true, int64(1))
+		case strings.Contains(q, "FROM drive_locked_pins"):
+			return fakeRow("$argon2id$hash")
+		}
+		return fakeResult{cols: []string{"c"}}
+	})
+	h := &Handler{db: db}
+	r := gin.New()
+	r.PUT("/drive/nodes/:id", h.updateNode)
+	r.DELETE("/drive/nodes/:id", h.deleteNode)
+	r.PUT("/drive/nodes/:id/content", h.putNodeContent)
+	cases := []struct{ method, path, body string }{
+		{http.MethodPut, "/drive/nodes/9", `{"name":"renamed.jpg"}`},
+		{http.MethodPut, "/drive/nodes/9", `{"parent_id":0}`},
+		{http.MethodDelete, "/drive/nodes/9", ""},
+		{http.MethodPut, "/drive/nodes/9/content", "new bytes"},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("X-User-ID", "1")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "LOCKED_ACCESS_REQUIRED") {
+			t.Errorf("%s %s %s: got %d %s", tc.method, tc.path, tc.body, w.Code, w.Body.String())
+		}
+	}
+	if fake.ran("UPDATE drive_nodes") {
+		t.Fatal("locked photo must not be modified without an access token")
+	}
+}
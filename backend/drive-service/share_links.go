@@ -0,0 +1,895 @@
+package main
+
+// share_links.go — liens de partage publics Drive (fichier ou dossier).
+//
+// Modèle "stable share URL" (cf. docs/securite/URL-CAPABILITIES.md § 3) :
+// token aléatoire 192 bits remis une seule fois au créateur, seul son SHA-256
+// est stocké. Mot de passe optionnel (Argon2id, même norme que auth-service) :
+// POST .../unlock échange le mot de passe contre un jeton d'accès HMAC court,
+// transmis ensuite en `X-Share-Access` ou `?access=` (liens <a> / <img>).
+//
+// Les routes /drive/public/* ne passent pas par requireUserID : le handler
+// épingle la connexion sur le propriétaire du lien (pinUserConn) puis réutilise
+// les requêtes Drive habituelles (addFolderToZip, serveNodeContent…).
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	shareModeRead   = "read"
+	shareModeUpload = "upload"
+
+	shareTokenBytes = 24
+	shareAccessTTL  = 15 * time.Minute
+
+	// Cookie posé après un téléchargement compté : les requêtes Range suivantes
+	// (lecture vidéo, reprise) sur le même fichier ne recomptent pas pendant shareAccessTTL.
+	shareDownloadCookie = "cloudity_share_dl"
+
+	// Essais de mot de passe par lien avant blocage (route anonyme, Argon2id coûteux).
+	shareUnlockMaxAttempts  = 10
+	shareUnlockBlockSeconds = 15 * 60
+)
+
+var errInvalidShareAccess = errors.New("invalid share access token")
+
+// shareUploadFormOverhead — marge pour les en-têtes multipart autour du fichier déposé.
+const shareUploadFormOverhead = 64 << 10
+
+// shareUploadMaxBytes — taille max d'un dépôt anonyme (DRIVE_SHARE_UPLOAD_MAX_BYTES, 100 Mo par défaut).
+func shareUploadMaxBytes() int64 {
+	if v := os.Getenv("DRIVE_SHARE_UPLOAD_MAX_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 100 << 20
+}
+
+// shareUploadLimit borne un dépôt par le quota restant du propriétaire (0 = illimité)
+// et par shareUploadMaxBytes : le corps est refusé avant d'être lu en mémoire.
+func shareUploadLimit(userUsed, userMax, tenantUsed, tenantMax int64) int64 {
+	limit := shareUploadMaxBytes()
+	for _, q := range [][2]int64{{userUsed, userMax}, {tenantUsed, tenantMax}} {
+		if q[1] > 0 && q[1]-q[0] < limit {
+			limit = q[1] - q[0]
+		}
+	}
+	return max(limit, 0)
+}
+
+// shareArgon2idParams — alignés sur auth-service (hardenedArgon2idParams), mêmes overrides d'environnement.
+func shareArgon2idParams() *argon2id.Params {
+	p := &argon2id.Params{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 4,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	if v := os.Getenv("ARGON2_MEMORY_KB"); v != "" {
+		if n, err := strconv.ParseUint(v, 10, 32); err == nil && n >= 8*1024 {
+			p.Memory = uint32(n)
+		}
+	}
+	if v := os.Getenv("ARGON2_TIME"); v != "" {
+		if n, err := strconv.ParseUint(v, 10, 32); err == nil && n >= 1 {
+			p.Iterations = uint32(n)
+		}
+	}
+	if v := os.Getenv("ARGON2_PARALLELISM"); v != "" {
+		if n, err := strconv.ParseUint(v, 10, 8); err == nil && n >= 1 {
+			p.Parallelism = uint8(n)
+		}
+	}
+	return p
+}
+
+func newShareToken() (string, error) {
+	b := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func shareTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	shareSecretOnce sync.Once
+	shareSecretKey  []byte
+)
+
+// shareAccessSecret — DRIVE_SHARE_SECRET (>= 32 octets) ; à défaut une clé aléatoire
+// par processus (les jetons d'accès expirent de toute façon après shareAccessTTL).
+func shareAccessSecret() []byte {
+	shareSecretOnce.Do(func() {
+		if raw := strings.TrimSpace(os.Getenv("DRIVE_SHARE_SECRET")); len(raw) >= 32 {
+			shareSecretKey = []byte(raw)
+			return
+		}
+		shareSecretKey = make([]byte, 32)
+		if _, err := rand.Read(shareSecretKey); err != nil {
+			panic("drive: crypto/rand unavailable: " + err.Error())
+		}
+	})
+	return shareSecretKey
+}
+
+// shareAccessMAC lie le jeton au lien ET au hash du mot de passe courant :
+// changer le mot de passe invalide les accès déjà délivrés.
+func shareAccessMAC(secret []byte, linkID int, passwordHash string, expiry int64) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(strconv.Itoa(linkID) + "|" + passwordHash + "|" + strconv.FormatInt(expiry, 10)))
+	return m.Sum(nil)
+}
+
+func issueShareAccess(secret []byte, linkID int, passwordHash string, now time.Time) string {
+	expiry := now.Add(shareAccessTTL).Unix()
+	mac := shareAccessMAC(secret, linkID, passwordHash, expiry)
+	return strconv.FormatInt(expiry, 10) + "." + base64.RawURLEncoding.EncodeToString(mac)
+}
+
+func verifyShareAccess(secret []byte, access string, linkID int, passwordHash string, now time.Time) error {
+	parts := strings.SplitN(access, ".", 2)
+	if len(parts) != 2 {
+		return errInvalidShareAccess
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || now.Unix() > expiry {
+		return errInvalidShareAccess
+	}
+	got, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return errInvalidShareAccess
+	}
+	if !hmac.Equal(got, shareAccessMAC(secret, linkID, passwordHash, expiry)) {
+		return errInvalidShareAccess
+	}
+	return nil
+}
+
+// shareDownloadScope sépare les jetons de téléchargement des jetons d'accès (mot de passe).
+func shareDownloadScope(nodeID int) string {
+	return "download|" + strconv.Itoa(nodeID)
+}
+
+// shareDownloadCounted — vrai si la requête présente un jeton de téléchargement valide
+// pour ce lien et ce fichier. L'en-tête Range n'entre pas en compte : sans jeton, toute
+// lecture (y compris "bytes=1-" ou un découpage en morceaux) consomme max_downloads.
+func shareDownloadCounted(c *gin.Context, l shareLink, nodeID int, now time.Time) bool {
+	grant, err := c.Cookie(shareDownloadCookie)
+	if err != nil || grant == "" {
+		return false
+	}
+	return verifyShareAccess(shareAccessSecret(), grant, l.ID, shareDownloadScope(nodeID), now) == nil
+}
+
+func setShareDownloadGrant(c *gin.Context, l shareLink, nodeID int, now time.Time) {
+	grant := issueShareAccess(shareAccessSecret(), l.ID, shareDownloadScope(nodeID), now)
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(shareDownloadCookie, grant, int(shareAccessTTL.Seconds()), "/drive/public/shares/", "", c.Request.TLS != nil, true)
+}
+
+type shareLink struct {
+	ID            int
+	NodeID        int // 0 pour un lien d'album
+	AlbumID       int // 0 pour un lien de nœud
+	UserID        int
+	TenantID      int
+	PasswordHash  sql.NullString
+	Mode          string
+	ExpiresAt     sql.NullTime
+	MaxDownloads  sql.NullInt64
+	DownloadCount int64
+	RevokedAt     sql.NullTime
+	// UnlockBlockedUntil : essais de mot de passe refusés jusqu'à cette date (trop d'échecs).
+	UnlockBlockedUntil sql.NullTime
+}
+
+// shareLinkUnavailable renvoie la raison (410) pour laquelle un lien n'est plus utilisable, "" sinon.
+func shareLinkUnavailable(l shareLink, now time.Time) string {
+	switch {
+	case l.RevokedAt.Valid:
+		return "share_revoked"
+	case l.ExpiresAt.Valid && !now.Before(l.ExpiresAt.Time):
+		return "share_expired"
+	case l.MaxDownloads.Valid && l.DownloadCount >= l.MaxDownloads.Int64:
+		return "share_download_limit_reached"
+	}
+	return ""
+}
+
+// shareLinkInfo — vue propriétaire d'un lien (le token brut n'est jamais relu).
+type shareLinkInfo struct {
+	ID            int    `json:"id"`
+	NodeID        int    `json:"node_id"`
+	NodeName      string `json:"node_name"`
+	IsFolder      bool   `json:"is_folder"`
+	Mode          string `json:"mode"`
+	HasPassword   bool   `json:"has_password"`
+	ExpiresAt     string `json:"expires_at,omitempty"`
+	MaxDownloads  *int64 `json:"max_downloads,omitempty"`
+	DownloadCount int64  `json:"download_count"`
+	RevokedAt     string `json:"revoked_at,omitempty"`
+	LastUsedAt    string `json:"last_used_at,omitempty"`
+	CreatedAt     string `json:"created_at"`
+}
+
+type publicShareEntry struct {
+	ID       int     `json:"id"`
+	Name     string  `json:"name"`
+	IsFolder bool    `json:"is_folder"`
+	Size     int64   `json:"size"`
+	MimeType *string `json:"mime_type,omitempty"`
+}
+
+// shareLinkOptions — options communes aux liens de nœud et d'album.
+type shareLinkOptions struct {
+	Mode         string
+	PasswordHash sql.NullString
+	ExpiresAt    sql.NullTime
+	MaxDownloads sql.NullInt64
+}
+
+// bindShareLinkOptions lit {"password", "expires_at", "max_downloads", "mode"} (corps optionnel).
+// Répond 400 / 500 et retourne false si une option est invalide.
+func bindShareLinkOptions(c *gin.Context) (shareLinkOptions, bool) {
+	var opts shareLinkOptions
+	var body struct {
+		Password     string `json:"password"`
+		ExpiresAt    string `json:"expires_at"`
+		MaxDownloads *int   `json:"max_downloads"`
+		Mode         string `json:"mode"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return opts, false
+	}
+	opts.Mode = strings.ToLower(strings.TrimSpace(body.Mode))
+	if opts.Mode == "" {
+		opts.Mode = shareModeRead
+	}
+	if opts.Mode != shareModeRead && opts.Mode != shareModeUpload {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be read or upload"})
+		return opts, false
+	}
+	if raw := strings.TrimSpace(body.ExpiresAt); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil || !t.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be a future RFC3339 date"})
+			return opts, false
+		}
+		opts.ExpiresAt = sql.NullTime{Time: t, Valid: true}
+	}
+	if body.MaxDownloads != nil {
+		if *body.MaxDownloads <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_downloads must be positive"})
+			return opts, false
+		}
+		opts.MaxDownloads = sql.NullInt64{Int64: int64(*body.MaxDownloads), Valid: true}
+	}
+	if body.Password != "" {
+		hash, err := argon2id.CreateHash(body.Password, shareArgon2idParams())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "password hashing failed"})
+			return opts, false
+		}
+		opts.PasswordHash = sql.NullString{String: hash, Valid: true}
+	}
+	return opts, true
+}
+
+// describe ajoute expires_at / max_downloads à la réponse de création.
+func (o shareLinkOptions) describe(out gin.H) {
+	if o.ExpiresAt.Valid {
+		out["expires_at"] = o.ExpiresAt.Time.UTC().Format(time.RFC3339)
+	}
+	if o.MaxDownloads.Valid {
+		out["max_downloads"] = o.MaxDownloads.Int64
+	}
+}
+
+// createShareLink — POST /drive/nodes/:id/shares
+// Body : {"password": "...", "expires_at": RFC3339, "max_downloads": 10, "mode": "read"|"upload"}
+func (h *Handler) createShareLink(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	opts, ok := bindShareLinkOptions(c)
+	if !ok {
+		return
+	}
+	mode := opts.Mode
+	ctx := c.Request.Context()
+	var name string
+	var isFolder bool
+	var tenantID, userID int
+	err = h.dbex(ctx).QueryRow(`
+		SELECT name, is_folder, tenant_id, user_id FROM drive_nodes
+		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL AND photo_locked_at IS NULL
+	`, id).Scan(&name, &isFolder, &tenantID, &userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if mode == shareModeUpload && !isFolder {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "upload mode requires a folder"})
+		return
+	}
+	token, err := newShareToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token generation failed"})
+		return
+	}
+	var shareID int
+	var createdAt string
+	err = h.dbex(ctx).QueryRow(`
+		INSERT INTO drive_share_links (token_hash, node_id, tenant_id, user_id, password_hash, mode, expires_at, max_downloads)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at::text
+	`, shareTokenHash(token), id, tenantID, userID, opts.PasswordHash, mode, opts.ExpiresAt, opts.MaxDownloads).Scan(&shareID, &createdAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	out := gin.H{
+		"id":           shareID,
+		"node_id":      id,
+		"node_name":    name,
+		"is_folder":    isFolder,
+		"mode":         mode,
+		"has_password": opts.PasswordHash.Valid,
+		"token":        token,
+		"path":         "/drive/public/shares/" + token,
+		"created_at":   createdAt,
+	}
+	opts.describe(out)
+	c.JSON(http.StatusCreated, out)
+}
+
+// listShareLinks — GET /drive/shares (tous les liens) ou GET /drive/nodes/:id/shares.
+func (h *Handler) listShareLinks(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusOK, []shareLinkInfo{})
+		return
+	}
+	nodeID := 0
+	if raw := c.Param("id"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+		nodeID = n
+	}
+	ctx := c.Request.Context()
+	rows, err := h.dbex(ctx).Query(`
+		SELECT s.id, s.node_id, n.name, n.is_folder, s.mode, s.password_hash IS NOT NULL,
+		       COALESCE(s.expires_at::text, ''), s.max_downloads, s.download_count,
+		       COALESCE(s.revoked_at::text, ''), COALESCE(s.last_used_at::text, ''), s.created_at::text
+		FROM drive_share_links s
+		INNER JOIN drive_nodes n ON n.id = s.node_id
+		WHERE s.user_id = current_setting('app.current_user_id', true)::INTEGER
+		  AND ($1 = 0 OR s.node_id = $1)
+		ORDER BY s.created_at DESC, s.id DESC
+	`, nodeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	list := make([]shareLinkInfo, 0)
+	for rows.Next() {
+		var s shareLinkInfo
+		var maxDownloads sql.NullInt64
+		if err := rows.Scan(&s.ID, &s.NodeID, &s.NodeName, &s.IsFolder, &s.Mode, &s.HasPassword, &s.ExpiresAt, &maxDownloads, &s.DownloadCount, &s.RevokedAt, &s.LastUsedAt, &s.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if maxDownloads.Valid {
+			s.MaxDownloads = &maxDownloads.Int64
+		}
+		list = append(list, s)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// revokeShareLink — DELETE /drive/shares/:shareId
+func (h *Handler) revokeShareLink(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	shareID, err := strconv.Atoi(c.Param("shareId"))
+	if err != nil || shareID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	ctx := c.Request.Context()
+	res, err := h.dbex(ctx).Exec(`
+		UPDATE drive_share_links SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND revoked_at IS NULL
+	`, shareID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	aff, _ := res.RowsAffected()
+	if aff == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) loadShareLink(ctx context.Context, token string) (shareLink, error) {
+	var l shareLink
+	var nodeID, albumID sql.NullInt64
+	err := h.db.QueryRowContext(ctx, `
+		SELECT id, node_id, album_id, user_id, tenant_id, password_hash, mode, expires_at, max_downloads, download_count, revoked_at, unlock_blocked_until
+		FROM drive_share_links WHERE token_hash = $1
+	`, shareTokenHash(token)).Scan(&l.ID, &nodeID, &albumID, &l.UserID, &l.TenantID, &l.PasswordHash, &l.Mode, &l.ExpiresAt, &l.MaxDownloads, &l.DownloadCount, &l.RevokedAt, &l.UnlockBlockedUntil)
+	l.NodeID, l.AlbumID = int(nodeID.Int64), int(albumID.Int64)
+	return l, err
+}
+
+// openShareLink valide le lien (existence, révocation, expiration, mot de passe) puis
+// épingle la connexion sur son propriétaire. L'appelant doit invoquer release().
+func (h *Handler) openShareLink(c *gin.Context) (shareLink, func(), bool) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return shareLink{}, nil, false
+	}
+	token := strings.TrimSpace(c.Param("token"))
+	if token == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return shareLink{}, nil, false
+	}
+	l, err := h.loadShareLink(c.Request.Context(), token)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return shareLink{}, nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return shareLink{}, nil, false
+	}
+	if reason := shareLinkUnavailable(l, time.Now()); reason != "" {
+		c.JSON(http.StatusGone, gin.H{"error": reason})
+		return shareLink{}, nil, false
+	}
+	if l.PasswordHash.Valid {
+		access := c.GetHeader("X-Share-Access")
+		if access == "" {
+			access = c.Query("access")
+		}
+		if verifyShareAccess(shareAccessSecret(), access, l.ID, l.PasswordHash.String, time.Now()) != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "password_required", "requires_password": true})
+			return shareLink{}, nil, false
+		}
+	}
+	release, ok := h.pinUserConn(c, l.UserID)
+	if !ok {
+		return shareLink{}, nil, false
+	}
+	return l, release, true
+}
+
+// shareTargetNode résout ?node_id= (défaut : racine du partage) en vérifiant qu'il appartient au sous-arbre partagé.
+func (h *Handler) shareTargetNode(c *gin.Context, l shareLink) (int, bool) {
+	if l.NodeID == 0 {
+		// Lien d'album : servi par /drive/public/albums/:token (photo_albums.go).
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return 0, false
+	}
+	raw := strings.TrimSpace(c.Query("node_id"))
+	if raw == "" {
+		return l.NodeID, true
+	}
+	nodeID, err := strconv.Atoi(raw)
+	if err != nil || nodeID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node_id"})
+		return 0, false
+	}
+	if nodeID == l.NodeID {
+		return nodeID, true
+	}
+	var inside bool
+	err = h.dbex(c.Request.Context()).QueryRow(`
+		WITH RECURSIVE up AS (
+			SELECT id, parent_id FROM drive_nodes
+			WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+			UNION ALL
+			SELECT p.id, p.parent_id FROM drive_nodes p
+			INNER JOIN up ON p.id = up.parent_id
+			WHERE p.deleted_at IS NULL AND p.user_id = current_setting('app.current_user_id', true)::INTEGER
+		)
+		SELECT EXISTS (SELECT 1 FROM up WHERE id = $2)
+	`, nodeID, l.NodeID).Scan(&inside)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	if !inside {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return 0, false
+	}
+	return nodeID, true
+}
+
+// consumeShareDownload incrémente le compteur sous condition (atomique vis-à-vis de max_downloads).
+func (h *Handler) consumeShareDownload(c *gin.Context, l shareLink) bool {
+	res, err := h.dbex(c.Request.Context()).Exec(`
+		UPDATE drive_share_links SET download_count = download_count + 1, last_used_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		  AND (max_downloads IS NULL OR download_count < max_downloads)
+	`, l.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if aff, _ := res.RowsAffected(); aff == 0 {
+		c.JSON(http.StatusGone, gin.H{"error": "share_download_limit_reached"})
+		return false
+	}
+	return true
+}
+
+// unlockShareLink — POST /drive/public/shares/:token/unlock {"password": "..."} → jeton d'accès court.
+func (h *Handler) unlockShareLink(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password required"})
+		return
+	}
+	l, err := h.loadShareLink(c.Request.Context(), strings.TrimSpace(c.Param("token")))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if reason := shareLinkUnavailable(l, time.Now()); reason != "" {
+		c.JSON(http.StatusGone, gin.H{"error": reason})
+		return
+	}
+	if !l.PasswordHash.Valid {
+		c.JSON(http.StatusOK, gin.H{"access": "", "requires_password": false})
+		return
+	}
+	if now := time.Now(); l.UnlockBlockedUntil.Valid && now.Before(l.UnlockBlockedUntil.Time) {
+		retry := int(l.UnlockBlockedUntil.Time.Sub(now).Seconds()) + 1
+		c.Header("Retry-After", strconv.Itoa(retry))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too_many_attempts", "retry_after": retry})
+		return
+	}
+	ok, err := argon2id.ComparePasswordAndHash(body.Password, l.PasswordHash.String)
+	if err != nil || !ok {
+		if _, err := h.db.ExecContext(c.Request.Context(), `
+			UPDATE drive_share_links
+			SET unlock_failed_attempts = CASE WHEN unlock_failed_attempts + 1 >= $2 THEN 0 ELSE unlock_failed_attempts + 1 END,
+			    unlock_blocked_until = CASE WHEN unlock_failed_attempts + 1 >= $2 THEN now() + make_interval(secs => $3) ELSE unlock_blocked_until END
+			WHERE id = $1
+		`, l.ID, shareUnlockMaxAttempts, shareUnlockBlockSeconds); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_password"})
+		return
+	}
+	if _, err := h.db.ExecContext(c.Request.Context(), `
+		UPDATE drive_share_links SET unlock_failed_attempts = 0, unlock_blocked_until = NULL
+		WHERE id = $1 AND (unlock_failed_attempts > 0 OR unlock_blocked_until IS NOT NULL)
+	`, l.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"access":     issueShareAccess(shareAccessSecret(), l.ID, l.PasswordHash.String, time.Now()),
+		"expires_in": int(shareAccessTTL.Seconds()),
+	})
+}
+
+// getPublicShare — GET /drive/public/shares/:token[?node_id=] : métadonnées + contenu du dossier.
+func (h *Handler) getPublicShare(c *gin.Context) {
+	l, release, ok := h.openShareLink(c)
+	if !ok {
+		return
+	}
+	defer release()
+	nodeID, ok := h.shareTargetNode(c, l)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	var name string
+	var isFolder bool
+	var size int64
+	var mime sql.NullString
+	err := h.dbex(ctx).QueryRow(`
+		SELECT name, is_folder, size, mime_type FROM drive_nodes
+		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL AND photo_locked_at IS NULL
+	`, nodeID).Scan(&name, &isFolder, &size, &mime)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	out := gin.H{
+		"node_id":           nodeID,
+		"root_id":           l.NodeID,
+		"name":              name,
+		"is_folder":         isFolder,
+		"size":              size,
+		"mode":              l.Mode,
+		"requires_password": l.PasswordHash.Valid,
+	}
+	if mime.Valid {
+		out["mime_type"] = mime.String
+	}
+	if l.ExpiresAt.Valid {
+		out["expires_at"] = l.ExpiresAt.Time.UTC().Format(time.RFC3339)
+	}
+	if l.MaxDownloads.Valid {
+		out["downloads_remaining"] = l.MaxDownloads.Int64 - l.DownloadCount
+	}
+	if isFolder {
+		rows, err := h.dbex(ctx).Query(`
+			SELECT id, name, is_folder, size, mime_type FROM drive_nodes
+			WHERE parent_id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL AND photo_locked_at IS NULL
+			ORDER BY is_folder DESC, name
+		`, nodeID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer rows.Close()
+		items := make([]publicShareEntry, 0)
+		for rows.Next() {
+			var e publicShareEntry
+			var m sql.NullString
+			if err := rows.Scan(&e.ID, &e.Name, &e.IsFolder, &e.Size, &m); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if m.Valid {
+				e.MimeType = &m.String
+			}
+			items = append(items, e)
+		}
+		out["items"] = items
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// getPublicShareContent — GET /drive/public/shares/:token/content[?node_id=] (compte un téléchargement).
+func (h *Handler) getPublicShareContent(c *gin.Context) {
+	l, release, ok := h.openShareLink(c)
+	if !ok {
+		return
+	}
+	defer release()
+	nodeID, ok := h.shareTargetNode(c, l)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	var name string
+	var content []byte
+	var mime sql.NullString
+	var vaultEncrypted bool
+	err := h.dbex(ctx).QueryRow(`
+		SELECT name, COALESCE(content, ''::bytea), mime_type, vault_encrypted FROM drive_nodes
+		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND is_folder = false AND deleted_at IS NULL
+		  AND photo_locked_at IS NULL
+	`, nodeID).Scan(&name, &content, &mime, &vaultEncrypted)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Lecture vidéo / reprise : un téléchargement par jeton, quelle que soit la plage demandée.
+	if now := time.Now(); !shareDownloadCounted(c, l, nodeID, now) {
+		if !h.consumeShareDownload(c, l) {
+			return
+		}
+		setShareDownloadGrant(c, l, nodeID, now)
+	}
+	c.Header("Cache-Control", "private, no-store")
+	serveNodeContent(c, name, content, mime, vaultEncrypted)
+}
+
+// getPublicShareZip — GET /drive/public/shares/:token/zip[?node_id=] : dossier partagé en ZIP (compte un téléchargement).
+func (h *Handler) getPublicShareZip(c *gin.Context) {
+	l, release, ok := h.openShareLink(c)
+	if !ok {
+		return
+	}
+	defer release()
+	nodeID, ok := h.shareTargetNode(c, l)
+	if !ok {
+		return
+	}
+	var name string
+	var isFolder bool
+	err := h.dbex(c.Request.Context()).QueryRow(`
+		SELECT name, is_folder FROM drive_nodes
+		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+	`, nodeID).Scan(&name, &isFolder)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !isFolder {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not a folder"})
+		return
+	}
+	if !h.consumeShareDownload(c, l) {
+		return
+	}
+	h.writeFolderZip(c, nodeID, name)
+}
+
+// uploadToPublicShare — POST /drive/public/shares/:token/upload (multipart "file", dossier en mode upload).
+// Le fichier appartient au propriétaire du lien et consomme son quota.
+func (h *Handler) uploadToPublicShare(c *gin.Context) {
+	l, release, ok := h.openShareLink(c)
+	if !ok {
+		return
+	}
+	defer release()
+	if l.Mode != shareModeUpload {
+		c.JSON(http.StatusForbidden, gin.H{"error": "share_read_only"})
+		return
+	}
+	folderID, ok := h.shareTargetNode(c, l)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	var isFolder bool
+	if err := h.dbex(ctx).QueryRow(`
+		SELECT is_folder FROM drive_nodes
+		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+	`, folderID).Scan(&isFolder); err != nil || !isFolder {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not a folder"})
+		return
+	}
+	userMax, tenantMax, err := loadQuotaLimits(h.dbex(ctx), l.UserID, l.TenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	userUsed, tenantUsed, err := loadQuotaUsage(h.dbex(ctx), l.UserID, l.TenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	limit := shareUploadLimit(userUsed, userMax, tenantUsed, tenantMax)
+	tooLarge := func() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large", "code": "SHARE_UPLOAD_TOO_LARGE", "max_bytes": limit})
+	}
+	if c.Request.ContentLength > limit+shareUploadFormOverhead {
+		tooLarge()
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+shareUploadFormOverhead)
+	file, fh, err := c.Request.FormFile("file")
+	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			tooLarge()
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file required"})
+		return
+	}
+	defer file.Close()
+	if fh.Size > limit {
+		tooLarge()
+		return
+	}
+	content, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "read file failed"})
+		return
+	}
+	if int64(len(content)) > limit {
+		tooLarge()
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		name = fh.Filename
+	}
+	name = dispositionFilename(strings.ReplaceAll(name, "/", "_"))
+	mimeType := mimeFromFileName(name)
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	size := int64(len(content))
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	if _, err := reserveQuota(tx, l.UserID, l.TenantID, size); err != nil {
+		if !writeQuotaError(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO drive_nodes (tenant_id, user_id, parent_id, name, is_folder, size, mime_type, content, content_hash)
+		VALUES ($1, $2, $3, $4, false, $5, $6, $7, $8) RETURNING id
+	`, l.TenantID, l.UserID, folderID, name, size, mimeType, content, contentHashParam(sha256HexContent(content))).Scan(&id)
+	if err != nil {
+		var perr *pq.Error
+		if errors.As(err, &perr) && perr.Code == "23505" {
+			c.JSON(http.StatusConflict, gin.H{"error": "file_exists", "code": "FILE_EXISTS", "message": "Un fichier avec ce nom existe déjà"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	kickContentWorkers()
+	c.JSON(http.StatusCreated, gin.H{"id": id, "name": name, "size": size})
+}
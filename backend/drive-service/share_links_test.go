@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNewShareTokenIsRandomAndHashed(t *testing.T) {
+	a, err := newShareToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newShareToken()
+	if a == b || len(a) < 32 {
+		t.Fatalf("tokens should be long and unique: %q %q", a, b)
+	}
+	if h := shareTokenHash(a); len(h) != 64 || h == a {
+		t.Fatalf("shareTokenHash = %q", h)
+	}
+}
+
+func TestShareAccessRoundTrip(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Now()
+	access := issueShareAccess(secret, 7, "$argon2id$hash", now)
+	if err := verifyShareAccess(secret, access, 7, "$argon2id$hash", now); err != nil {
+		t.Fatalf("valid access rejected: %v", err)
+	}
+	if err := verifyShareAccess(secret, access, 8, "$argon2id$hash", now); err == nil {
+		t.Fatal("access must be bound to the share link")
+	}
+	if err := verifyShareAccess(secret, access, 7, "$argon2id$other", now); err == nil {
+		t.Fatal("changing the password must invalidate issued access")
+	}
+	if err := verifyShareAccess(secret, access, 7, "$argon2id$hash", now.Add(shareAccessTTL+time.Minute)); err == nil {
+		t.Fatal("expired access must be rejected")
+	}
+}
+
+func TestShareLinkUnavailable(t *testing.T) {
+	now := time.Now()
+	if got := shareLinkUnavailable(shareLink{}, now); got != "" {
+		t.Fatalf("fresh link unavailable: %q", got)
+	}
+	revoked := shareLink{RevokedAt: sql.NullTime{Time: now, Valid: true}}
+	if got := shareLinkUnavailable(revoked, now); got != "share_revoked" {
+		t.Fatalf("revoked = %q", got)
+	}
+	expired := shareLink{ExpiresAt: sql.NullTime{Time: now.Add(-time.Second), Valid: true}}
+	if got := shareLinkUnavailable(expired, now); got != "share_expired" {
+		t.Fatalf("expired = %q", got)
+	}
+	exhausted := shareLink{MaxDownloads: sql.NullInt64{Int64: 3, Valid: true}, DownloadCount: 3}
+	if got := shareLinkUnavailable(exhausted, now); got != "share_download_limit_reached" {
+		t.Fatalf("exhausted = %q", got)
+	}
+}
+
+func TestPublicShareDoesNotRequireUserID(t *testing.T) {
+	r := setupRouter(nil)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/drive/public/shares/sometoken", nil)
+	r.ServeHTTP(w, req)
+	if w.Code == http.StatusUnauthorized {
+		t.Errorf("GET /drive/public/shares/:token must not require X-User-ID, got %d", w.Code)
+	}
+}
+
+func TestShareManagementRequiresAuth(t *testing.T) {
+	r := setupRouter(nil)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/drive/nodes/1/shares", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("POST /drive/nodes/1/shares without X-User-ID: got %d", w.Code)
+	}
+}
+
+func TestShareDownloadCountedIgnoresRange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := shareLink{ID: 7}
+	now := time.Now()
+	ctx := func(cookie string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/drive/public/shares/tok/content", nil)
+		c.Request.Header.Set("Range", "bytes=1-")
+		if cookie != "" {
+			c.Request.AddCookie(&http.Cookie{Name: shareDownloadCookie, Value: cookie})
+		}
+		return c
+	}
+	// Une plage qui ne commence pas à 0 consomme quand même le quota de téléchargements.
+	if shareDownloadCounted(ctx(""), l, 42, now) {
+		t.Fatal("Range bytes=1- without grant must count as a download")
+	}
+	grant := issueShareAccess(shareAccessSecret(), l.ID, shareDownloadScope(42), now)
+	if !shareDownloadCounted(ctx(grant), l, 42, now) {
+		t.Fatal("valid grant should not count twice")
+	}
+	if shareDownloadCounted(ctx(grant), l, 43, now) {
+		t.Fatal("grant must be bound to the node")
+	}
+	if shareDownloadCounted(ctx(grant), shareLink{ID: 8}, 42, now) {
+		t.Fatal("grant must be bound to the link")
+	}
+	if shareDownloadCounted(ctx(grant), l, 42, now.Add(shareAccessTTL+time.Minute)) {
+		t.Fatal("expired grant must count again")
+	}
+	if shareDownloadCounted(ctx(issueShareAccess(shareAccessSecret(), l.ID, "$argon2id$hash", now)), l, 42, now) {
+		t.Fatal("password access token must not be accepted as a download grant")
+	}
+}
+
+func TestShareUploadLimit(t *testing.T) {
+	t.Setenv("DRIVE_SHARE_UPLOAD_MAX_BYTES", "1000")
+	if got := shareUploadLimit(0, 0, 0, 0); got != 1000 {
+		t.Fatalf("unlimited quota: got %d, want configured max", got)
+	}
+	if got := shareUploadLimit(900, 1000, 0, 0); got != 100 {
+		t.Fatalf("user quota remaining: got %d", got)
+	}
+	if got := shareUploadLimit(0, 0, 4950, 5000); got != 50 {
+		t.Fatalf("tenant quota remaining: got %d", got)
+	}
+	if got := shareUploadLimit(1200, 1000, 0, 0); got != 0 {
+		t.Fatalf("exceeded quota: got %d, want 0", got)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Seuils d'alerte renvoyés aux clients (pourcentage du quota consommé).
+const (
+	quotaWarnPercent     = 80
+	quotaCriticalPercent = 95
+)
+
+// quotaLockClass — première clé de pg_advisory_xact_lock(int, int) ; la seconde
+// est le tenant. Toutes les écritures qui consomment du stockage d'un même tenant
+// sont ainsi sérialisées jusqu'au COMMIT (lecture d'usage + écriture atomiques).
+const quotaLockClass = 0x51554f54
+
+const (
+	quotaLevelUnlimited = "unlimited"
+	quotaLevelOK        = "ok"
+	quotaLevelWarning   = "warning"
+	quotaLevelCritical  = "critical"
+	quotaLevelExceeded  = "exceeded"
+)
+
+type quotaUsage struct {
+	Scope      string  `json:"scope"`
+	UsedBytes  int64   `json:"used_bytes"`
+	QuotaBytes int64   `json:"quota_bytes"` // 0 = illimité
+	Percent    float64 `json:"percent"`
+	Level      string  `json:"level"`
+}
+
+type storageQuotaResponse struct {
+	User           quotaUsage `json:"user"`
+	Tenant         quotaUsage `json:"tenant"`
+	WarnThresholds []int      `json:"warn_thresholds"`
+}
+
+// quotaExceededError — écriture refusée ; Scope = "user" ou "tenant".
+type quotaExceededError struct {
+	Scope     string
+	Used      int64
+	Max       int64
+	Requested int64
+}
+
+func (e *quotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota exceeded: used=%d requested=%d max=%d", e.Scope, e.Used, e.Requested, e.Max)
+}
+
+// quotaQuerier couvre *sql.Tx et dbExec pour les lectures de quota.
+type quotaQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func quotaLevel(used, max int64) string {
+	if max <= 0 {
+		return quotaLevelUnlimited
+	}
+	switch {
+	case used > max:
+		return quotaLevelExceeded
+	case used*100 >= max*quotaCriticalPercent:
+		return quotaLevelCritical
+	case used*100 >= max*quotaWarnPercent:
+		return quotaLevelWarning
+	default:
+		return quotaLevelOK
+	}
+}
+
+func newQuotaUsage(scope string, used, max int64) quotaUsage {
+	u := quotaUsage{Scope: scope, UsedBytes: used, QuotaBytes: max, Level: quotaLevel(used, max)}
+	if max > 0 {
+		u.Percent = float64(used) * 100 / float64(max)
+	}
+	return u
+}
+
+// checkQuota refuse une écriture qui ferait dépasser max (0 = illimité).
+// Un delta négatif (contenu remplacé par plus petit) est toujours accepté ;
+// un delta nul (création de dossier / fichier vide) n'est refusé que si le quota
+// est déjà dépassé (ex. quota abaissé par un admin).
+func checkQuota(scope string, used, delta, max int64) error {
+	if max <= 0 || delta < 0 || used+delta <= max {
+		return nil
+	}
+	return &quotaExceededError{Scope: scope, Used: used, Max: max, Requested: delta}
+}
+
+func quotaBytesFromEnv(key string) int64 {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// loadQuotaLimits lit les quotas explicites (storage_quotas) avec repli sur les valeurs par défaut d'environnement.
+func loadQuotaLimits(q quotaQuerier, uid, tid int) (userMax, tenantMax int64, err error) {
+	err = q.QueryRow(`
+		SELECT
+		  COALESCE((SELECT max_bytes FROM storage_quotas WHERE user_id = $1), -1),
+		  COALESCE((SELECT max_bytes FROM storage_quotas WHERE tenant_id = $2 AND user_id IS NULL), -1)
+	`, uid, tid).Scan(&userMax, &tenantMax)
+	if err != nil {
+		return 0, 0, err
+	}
+	if userMax < 0 {
+		userMax = quotaBytesFromEnv("DRIVE_DEFAULT_USER_QUOTA_BYTES")
+	}
+	if tenantMax < 0 {
+		tenantMax = quotaBytesFromEnv("DRIVE_DEFAULT_TENANT_QUOTA_BYTES")
+	}
+	return userMax, tenantMax, nil
+}
+
+// loadQuotaUsage — octets des fichiers Drive/Photos (corbeille incluse, elle occupe encore le disque).
+// Le total tenant passe par drive_tenant_usage_bytes (SECURITY DEFINER) : sous RLS, drive_nodes
+// ne montre que les fichiers de l'appelant.
+func loadQuotaUsage(q quotaQuerier, uid, tid int) (userUsed, tenantUsed int64, err error) {
+	err = q.QueryRow(`
+		SELECT
+		  COALESCE((SELECT SUM(size) FROM drive_nodes WHERE is_folder = false AND user_id = $1), 0),
+		  drive_tenant_usage_bytes($2)
+	`, uid, tid).Scan(&userUsed, &tenantUsed)
+	return userUsed, tenantUsed, err
+}
+
+func (h *Handler) loadStorageQuota(q quotaQuerier, uid, tid int) (storageQuotaResponse, error) {
+	userMax, tenantMax, err := loadQuotaLimits(q, uid, tid)
+	if err != nil {
+		return storageQuotaResponse{}, err
+	}
+	userUsed, tenantUsed, err := loadQuotaUsage(q, uid, tid)
+	if err != nil {
+		return storageQuotaResponse{}, err
+	}
+	return storageQuotaResponse{
+		User:           newQuotaUsage("user", userUsed, userMax),
+		Tenant:         newQuotaUsage("tenant", tenantUsed, tenantMax),
+		WarnThresholds: []int{quotaWarnPercent, quotaCriticalPercent},
+	}, nil
+}
+
+// reserveQuota verrouille le tenant pour la durée de tx puis vérifie que delta octets
+// tiennent dans les quotas utilisateur et tenant. L'écriture doit suivre dans la même tx.
+// Retourne le niveau d'alerte le plus sévère après écriture.
+func reserveQuota(tx *sql.Tx, uid, tid int, delta int64) (string, error) {
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, quotaLockClass, tid); err != nil {
+		return "", err
+	}
+	userMax, tenantMax, err := loadQuotaLimits(tx, uid, tid)
+	if err != nil {
+		return "", err
+	}
+	if userMax <= 0 && tenantMax <= 0 {
+		return quotaLevelUnlimited, nil
+	}
+	userUsed, tenantUsed, err := loadQuotaUsage(tx, uid, tid)
+	if err != nil {
+		return "", err
+	}
+	if err := checkQuota("user", userUsed, delta, userMax); err != nil {
+		return "", err
+	}
+	if err := checkQuota("tenant", tenantUsed, delta, tenantMax); err != nil {
+		return "", err
+	}
+	return worstQuotaLevel(quotaLevel(userUsed+delta, userMax), quotaLevel(tenantUsed+delta, tenantMax)), nil
+}
+
+func worstQuotaLevel(levels ...string) string {
+	rank := map[string]int{quotaLevelUnlimited: 0, quotaLevelOK: 1, quotaLevelWarning: 2, quotaLevelCritical: 3, quotaLevelExceeded: 4}
+	worst := quotaLevelUnlimited
+	for _, l := range levels {
+		if rank[l] > rank[worst] {
+			worst = l
+		}
+	}
+	return worst
+}
+
+// setQuotaLevelHeader signale au client qu'un seuil d'alerte est franchi.
+func setQuotaLevelHeader(c *gin.Context, level string) {
+	if level == quotaLevelWarning || level == quotaLevelCritical || level == quotaLevelExceeded {
+		c.Header("X-Cloudity-Quota-Level", level)
+	}
+}
+
+// writeQuotaError répond 413 si le fichier dépasse à lui seul le quota, 507 sinon.
+// Retourne false si err n'est pas un dépassement de quota.
+func writeQuotaError(c *gin.Context, err error) bool {
+	var qe *quotaExceededError
+	if !errors.As(err, &qe) {
+		return false
+	}
+	status := http.StatusInsufficientStorage
+	if qe.Requested > qe.Max {
+		status = http.StatusRequestEntityTooLarge
+	}
+	msg := "Espace de stockage insuffisant : quota utilisateur atteint."
+	if qe.Scope == "tenant" {
+		msg = "Espace de stockage insuffisant : quota de l’organisation atteint."
+	}
+	c.JSON(status, gin.H{
+		"error":           "quota_exceeded",
+		"code":            "QUOTA_EXCEEDED",
+		"scope":           qe.Scope,
+		"used_bytes":      qe.Used,
+		"quota_bytes":     qe.Max,
+		"requested_bytes": qe.Requested,
+		"message":         msg,
+	})
+	return true
+}
+
+// requestUserTenant lit X-User-ID / X-Tenant-ID (tenant 1 par défaut, comme createNode).
+func requestUserTenant(c *gin.Context) (int, int) {
+	uid, _ := strconv.Atoi(c.GetHeader("X-User-ID"))
+	tid := 1
+	if t, err := strconv.Atoi(c.GetHeader("X-Tenant-ID")); err == nil && t > 0 {
+		tid = t
+	}
+	return uid, tid
+}
+
+// getStorageQuota — GET /drive/storage/quota : usage, quotas et seuils d'alerte.
+func (h *Handler) getStorageQuota(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusOK, storageQuotaResponse{
+			User:           newQuotaUsage("user", 0, 0),
+			Tenant:         newQuotaUsage("tenant", 0, 0),
+			WarnThresholds: []int{quotaWarnPercent, quotaCriticalPercent},
+		})
+		return
+	}
+	uid, tid := requestUserTenant(c)
+	resp, err := h.loadStorageQuota(h.dbex(c.Request.Context()), uid, tid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQuotaLevel(t *testing.T) {
+	tests := []struct {
+		used, max int64
+		want      string
+	}{
+		{100, 0, quotaLevelUnlimited},
+		{0, 1000, quotaLevelOK},
+		{799, 1000, quotaLevelOK},
+		{800, 1000, quotaLevelWarning},
+		{950, 1000, quotaLevelCritical},
+		{1000, 1000, quotaLevelCritical},
+		{1001, 1000, quotaLevelExceeded},
+	}
+	for _, tc := range tests {
+		if got := quotaLevel(tc.used, tc.max); got != tc.want {
+			t.Errorf("quotaLevel(%d, %d) = %q, want %q", tc.used, tc.max, got, tc.want)
+		}
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	if err := checkQuota("user", 900, 100, 1000); err != nil {
+		t.Fatalf("exact fit should be accepted: %v", err)
+	}
+	if err := checkQuota("user", 5000, 100, 0); err != nil {
+		t.Fatalf("unlimited quota should accept: %v", err)
+	}
+	if err := checkQuota("user", 1500, -200, 1000); err != nil {
+		t.Fatalf("shrinking content should be accepted even over quota: %v", err)
+	}
+	err := checkQuota("tenant", 900, 200, 1000)
+	var qe *quotaExceededError
+	if !errors.As(err, &qe) || qe.Scope != "tenant" || qe.Requested != 200 {
+		t.Fatalf("expected tenant quota error, got %v", err)
+	}
+	if err := checkQuota("user", 1001, 0, 1000); err == nil {
+		t.Fatal("empty node creation should be refused when quota is already exceeded")
+	}
+}
+
+func TestWorstQuotaLevel(t *testing.T) {
+	if got := worstQuotaLevel(quotaLevelOK, quotaLevelCritical, quotaLevelUnlimited); got != quotaLevelCritical {
+		t.Fatalf("worstQuotaLevel = %q", got)
+	}
+}
+
+func TestStorageQuotaRequiresAuth(t *testing.T) {
+	r := setupRouter(nil)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/drive/storage/quota", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /drive/storage/quota without X-User-ID: got %d", w.Code)
+	}
+}
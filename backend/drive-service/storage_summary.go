@@ -17,6 +17,7 @@ type storageSummaryResponse struct {
 	Photos storageServiceUsage `json:"photos"`
 	Drive  storageServiceUsage `json:"drive"`
 	Mail   *storageServiceUsage `json:"mail,omitempty"`
+	Quota  *storageQuotaResponse `json:"quota,omitempty"`
 	Note   string              `json:"note,omitempty"`
 }
 
@@ -108,6 +109,10 @@ SELECT
 			FileCount: driveCount,
 		},
 	}
+	uid, tid := requestUserTenant(c)
+	if quota, quotaErr := h.loadStorageQuota(h.dbex(ctx), uid, tid); quotaErr == nil {
+		resp.Quota = &quota
+	}
 	if mailUsage, mailErr := h.queryMailStorageUsage(ctx); mailErr == nil {
 		resp.Mail = &mailUsage
 	} else {
@@ -0,0 +1,231 @@
+package main
+
+// text_extract.go — extraction du texte des documents pour l'index plein texte
+// (text_index.go). Tout en Go pur : texte brut / Markdown / HTML, Office Open XML
+// (docx, xlsx, pptx), OpenDocument (odt, ods, odp) et PDF (ledongthuc/pdf).
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// textIndexMaxChars — plafond du texte conservé par fichier (un tsvector est limité à 1 Mo).
+const textIndexMaxChars = 256 * 1024
+
+var errTextUnsupported = errors.New("unsupported document type")
+
+// textExtractKinds — extension → famille d'extraction.
+var textExtractKinds = map[string]string{
+	".txt": "text", ".md": "text", ".markdown": "text", ".csv": "text", ".tsv": "text",
+	".json": "text", ".xml": "text", ".log": "text", ".yaml": "text", ".yml": "text",
+	".ini": "text", ".rst": "text", ".tex": "text",
+	".html": "html", ".htm": "html",
+	".docx": "ooxml", ".xlsx": "ooxml", ".pptx": "ooxml",
+	".odt": "odf", ".ods": "odf", ".odp": "odf",
+	".pdf": "pdf",
+}
+
+// textExtractKind renvoie la famille d'extraction du fichier ("" si non indexable).
+func textExtractKind(name, mimeType string) string {
+	if k, ok := textExtractKinds[strings.ToLower(path.Ext(name))]; ok {
+		return k
+	}
+	mt := strings.ToLower(mimeType)
+	switch {
+	case strings.HasPrefix(mt, "text/html"):
+		return "html"
+	case strings.HasPrefix(mt, "text/"):
+		return "text"
+	case mt == "application/pdf":
+		return "pdf"
+	}
+	return ""
+}
+
+// textIndexExtensionsSQL — regex POSIX des extensions indexables (filtre du worker).
+func textIndexExtensionsSQL() string {
+	exts := make([]string, 0, len(textExtractKinds))
+	for ext := range textExtractKinds {
+		exts = append(exts, strings.TrimPrefix(ext, "."))
+	}
+	sort.Strings(exts)
+	return `\.(` + strings.Join(exts, "|") + `)$`
+}
+
+// extractText renvoie le texte brut normalisé (espaces compactés, tronqué à textIndexMaxChars).
+func extractText(name, mimeType string, content []byte) (string, error) {
+	var raw string
+	var err error
+	switch textExtractKind(name, mimeType) {
+	case "text":
+		raw = string(content)
+	case "html":
+		raw = htmlToText(string(content))
+	case "ooxml":
+		raw, err = extractOOXML(name, content)
+	case "odf":
+		raw, err = extractODF(content)
+	case "pdf":
+		raw, err = extractPDF(content)
+	default:
+		return "", errTextUnsupported
+	}
+	if err != nil {
+		return "", err
+	}
+	return normalizeIndexText(raw), nil
+}
+
+var htmlTagRe = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>|<[^>]+>`)
+
+func htmlToText(s string) string {
+	s = htmlTagRe.ReplaceAllString(s, " ")
+	r := strings.NewReplacer("&nbsp;", " ", "&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'")
+	return r.Replace(s)
+}
+
+// normalizeIndexText : UTF-8 valide, sans NUL (refusé par Postgres), espaces compactés, tronqué.
+func normalizeIndexText(s string) string {
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, " ")
+	}
+	s = strings.ReplaceAll(s, "\x00", " ")
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > textIndexMaxChars {
+		s = s[:textIndexMaxChars]
+		for !utf8.ValidString(s) {
+			s = s[:len(s)-1]
+		}
+	}
+	return s
+}
+
+// xmlText concatène les données texte d'un document XML ; un espace est inséré à la
+// fermeture de chaque élément de breakOn (paragraphes, cellules…) pour séparer les mots.
+func xmlText(r io.Reader, breakOn map[string]bool) (string, error) {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	var b strings.Builder
+	for b.Len() < textIndexMaxChars*2 {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return b.String(), err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			b.Write(t)
+		case xml.EndElement:
+			if breakOn[t.Name.Local] {
+				b.WriteByte(' ')
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+func readZipEntry(f *zip.File) ([]byte, error) {
+	// Garde-fou zip bomb : on ne décompresse jamais plus de 32 Mo par entrée.
+	const maxEntry = 32 << 20
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxEntry))
+}
+
+var ooxmlBreaks = map[string]bool{"p": true, "tab": true, "br": true, "si": true, "c": true, "tc": true}
+
+// extractOOXML lit les parties texte d'un docx (word/*.xml), xlsx (sharedStrings + feuilles)
+// ou pptx (slides), dans l'ordre des noms de parties.
+func extractOOXML(name string, content []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", fmt.Errorf("ooxml: %w", err)
+	}
+	var prefixes []string
+	switch strings.ToLower(path.Ext(name)) {
+	case ".docx":
+		prefixes = []string{"word/document.xml", "word/header", "word/footer", "word/footnotes.xml"}
+	case ".xlsx":
+		prefixes = []string{"xl/sharedStrings.xml", "xl/worksheets/sheet"}
+	case ".pptx":
+		prefixes = []string{"ppt/slides/slide", "ppt/notesSlides/notesSlide"}
+	default:
+		return "", errTextUnsupported
+	}
+	files := append([]*zip.File(nil), zr.File...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	var out strings.Builder
+	for _, p := range prefixes {
+		for _, f := range files {
+			if !strings.HasPrefix(f.Name, p) || !strings.HasSuffix(f.Name, ".xml") {
+				continue
+			}
+			data, err := readZipEntry(f)
+			if err != nil {
+				return out.String(), err
+			}
+			txt, _ := xmlText(bytes.NewReader(data), ooxmlBreaks)
+			out.WriteString(txt)
+			out.WriteByte(' ')
+			if out.Len() > textIndexMaxChars*2 {
+				return out.String(), nil
+			}
+		}
+	}
+	return out.String(), nil
+}
+
+var odfBreaks = map[string]bool{"p": true, "h": true, "tab": true, "line-break": true, "table-cell": true, "s": true}
+
+func extractODF(content []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", fmt.Errorf("odf: %w", err)
+	}
+	for _, f := range zr.File {
+		if f.Name != "content.xml" {
+			continue
+		}
+		data, err := readZipEntry(f)
+		if err != nil {
+			return "", err
+		}
+		return xmlText(bytes.NewReader(data), odfBreaks)
+	}
+	return "", errors.New("odf: content.xml missing")
+}
+
+// extractPDF — texte des pages ; la lib peut paniquer sur un PDF malformé.
+func extractPDF(content []byte) (text string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("pdf: %v", r)
+		}
+	}()
+	r, err := pdf.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", fmt.Errorf("pdf: %w", err)
+	}
+	pr, err := r.GetPlainText()
+	if err != nil {
+		return "", fmt.Errorf("pdf: %w", err)
+	}
+	b, err := io.ReadAll(io.LimitReader(pr, textIndexMaxChars*2))
+	return string(b), err
+}
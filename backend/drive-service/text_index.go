@@ -0,0 +1,163 @@
+package main
+
+// text_index.go — worker d'indexation plein texte (table drive_node_text, migration 51).
+//
+// Toutes les DRIVE_TEXT_INDEX_INTERVAL (30 s par défaut), ou dès qu'un upload le
+// réveille (kickTextIndexer), le worker prend les fichiers dont le content_hash n'est
+// pas encore indexé, extrait leur texte (text_extract.go) et le stocke avec son
+// tsvector. Les fichiers vault_encrypted sont exclus et leur index éventuel purgé.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	textIndexBatch = 20
+	// textSearchConfig — configuration Postgres 'simple' : pas de stemming, adaptée
+	// à un Drive multilingue (FR/EN) et aux noms propres / références.
+	textSearchConfig = "simple"
+)
+
+// textIndexKick réveille le worker après un upload (envoi non bloquant).
+var textIndexKick = make(chan struct{}, 1)
+
+func kickTextIndexer() {
+	select {
+	case textIndexKick <- struct{}{}:
+	default:
+	}
+}
+
+func textIndexInterval() time.Duration {
+	if v := os.Getenv("DRIVE_TEXT_INDEX_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= time.Second {
+			return d
+		}
+	}
+	return 30 * time.Second
+}
+
+// textIndexMaxBytes — taille maximale d'un fichier à extraire (DRIVE_TEXT_INDEX_MAX_BYTES, 25 Mo par défaut).
+func textIndexMaxBytes() int64 {
+	if v := os.Getenv("DRIVE_TEXT_INDEX_MAX_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 25 << 20
+}
+
+func (h *Handler) startTextIndexWorker() {
+	if os.Getenv("DRIVE_TEXT_INDEX_DISABLED") == "1" {
+		log.Println("[drive] text indexer disabled (DRIVE_TEXT_INDEX_DISABLED=1)")
+		return
+	}
+	tk := time.NewTicker(textIndexInterval())
+	defer tk.Stop()
+	// Worker async sans request HTTP : pas de conn pin, les requêtes filtrent explicitement.
+	ctx := context.Background()
+	for {
+		for {
+			n, err := h.indexPendingText(ctx, textIndexBatch)
+			if err != nil {
+				log.Printf("[drive] text indexer: %v", err)
+				break
+			}
+			if n < textIndexBatch {
+				break
+			}
+		}
+		select {
+		case <-tk.C:
+		case <-textIndexKick:
+		}
+	}
+}
+
+type textIndexCandidate struct {
+	id, userID  int
+	name, mime  string
+	size        int64
+	contentHash sql.NullString
+}
+
+// indexPendingText traite au plus limit fichiers ; renvoie le nombre traité.
+func (h *Handler) indexPendingText(ctx context.Context, limit int) (int, error) {
+	if _, err := h.dbex(ctx).Exec(`
+		DELETE FROM drive_node_text t USING drive_nodes n
+		WHERE t.node_id = n.id AND n.vault_encrypted = true
+	`); err != nil {
+		return 0, err
+	}
+	rows, err := h.dbex(ctx).Query(`
+		SELECT n.id, n.user_id, n.name, COALESCE(n.mime_type, ''), n.size, n.content_hash
+		FROM drive_nodes n
+		LEFT JOIN drive_node_text t ON t.node_id = n.id
+		WHERE n.is_folder = false AND n.deleted_at IS NULL AND n.vault_encrypted = false
+		  AND (LOWER(n.name) ~ $1 OR n.mime_type LIKE 'text/%' OR n.mime_type = 'application/pdf')
+		  AND (
+		    t.node_id IS NULL
+		    OR t.content_hash IS DISTINCT FROM n.content_hash
+		    OR (n.content_hash IS NULL AND t.indexed_at < COALESCE(n.updated_at, n.created_at))
+		  )
+		ORDER BY n.id
+		LIMIT $2
+	`, textIndexExtensionsSQL(), limit)
+	if err != nil {
+		return 0, err
+	}
+	list := make([]textIndexCandidate, 0, limit)
+	for rows.Next() {
+		var it textIndexCandidate
+		if err := rows.Scan(&it.id, &it.userID, &it.name, &it.mime, &it.size, &it.contentHash); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		list = append(list, it)
+	}
+	rows.Close()
+	for _, it := range list {
+		if err := h.indexNodeText(ctx, it); err != nil {
+			log.Printf("[drive] text index node=%d: %v", it.id, err)
+		}
+	}
+	return len(list), nil
+}
+
+func (h *Handler) indexNodeText(ctx context.Context, it textIndexCandidate) error {
+	status, body, errMsg := "indexed", "", sql.NullString{}
+	if it.size > textIndexMaxBytes() {
+		status = "too_large"
+	} else {
+		var content []byte
+		if err := h.dbex(ctx).QueryRow(`SELECT COALESCE(content, ''::bytea) FROM drive_nodes WHERE id = $1`, it.id).Scan(&content); err != nil {
+			return err
+		}
+		text, err := extractText(it.name, it.mime, content)
+		switch {
+		case errors.Is(err, errTextUnsupported):
+			status = "unsupported"
+		case err != nil:
+			status = "error"
+			errMsg = sql.NullString{String: err.Error(), Valid: true}
+		case text == "":
+			status = "empty"
+		default:
+			body = text
+		}
+	}
+	_, err := h.dbex(ctx).Exec(`
+		INSERT INTO drive_node_text (node_id, user_id, content_hash, status, body, tsv, error, indexed_at)
+		VALUES ($1, $2, $3, $4, $5, to_tsvector('`+textSearchConfig+`', $5), $6, CURRENT_TIMESTAMP)
+		ON CONFLICT (node_id) DO UPDATE SET
+			user_id = EXCLUDED.user_id, content_hash = EXCLUDED.content_hash, status = EXCLUDED.status,
+			body = EXCLUDED.body, tsv = EXCLUDED.tsv, error = EXCLUDED.error, indexed_at = EXCLUDED.indexed_at
+	`, it.id, it.userID, it.contentHash, status, body, errMsg)
+	return err
+}
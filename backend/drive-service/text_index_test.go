@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func buildZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTextExtractKind(t *testing.T) {
+	for _, tc := range []struct{ name, mime, want string }{
+		{"notes.md", "", "text"},
+		{"Rapport.DOCX", "", "ooxml"},
+		{"budget.ods", "", "odf"},
+		{"scan.pdf", "", "pdf"},
+		{"page", "text/html; charset=utf-8", "html"},
+		{"README", "text/plain", "text"},
+		{"photo.jpg", "image/jpeg", ""},
+	} {
+		if got := textExtractKind(tc.name, tc.mime); got != tc.want {
+			t.Errorf("textExtractKind(%q, %q) = %q, want %q", tc.name, tc.mime, got, tc.want)
+		}
+	}
+	if !strings.Contains(textIndexExtensionsSQL(), "|docx|") {
+		t.Errorf("textIndexExtensionsSQL = %q", textIndexExtensionsSQL())
+	}
+}
+
+func TestExtractTextPlainAndHTML(t *testing.T) {
+	got, err := extractText("a.txt", "", []byte("Bonjour\x00  le\n\nmonde"))
+	if err != nil || got != "Bonjour le monde" {
+		t.Fatalf("txt: %q, %v", got, err)
+	}
+	got, err = extractText("a.html", "", []byte(`<html><style>p{}</style><p>Facture&nbsp;<b>2024</b></p><script>x()</script></html>`))
+	if err != nil || got != "Facture 2024" {
+		t.Fatalf("html: %q, %v", got, err)
+	}
+	if _, err := extractText("a.bin", "application/octet-stream", []byte("x")); !errors.Is(err, errTextUnsupported) {
+		t.Fatalf("bin: want errTextUnsupported, got %v", err)
+	}
+}
+
+func TestExtractTextOfficeDocuments(t *testing.T) {
+	docx := buildZip(t, map[string]string{
+		"word/document.xml": `<w:document xmlns:w="x"><w:body><w:p><w:r><w:t>Contrat</w:t></w:r></w:p><w:p><w:r><w:t>signé</w:t></w:r></w:p></w:body></w:document>`,
+		"docProps/app.xml":  `<Properties><Application>Ignored</Application></Properties>`,
+	})
+	got, err := extractText("contrat.docx", "", docx)
+	if err != nil || got != "Contrat signé" {
+		t.Fatalf("docx: %q, %v", got, err)
+	}
+	odt := buildZip(t, map[string]string{
+		"content.xml": `<office:document-content xmlns:office="o" xmlns:text="t"><text:h>Titre</text:h><text:p>Corps</text:p></office:document-content>`,
+	})
+	got, err = extractText("note.odt", "", odt)
+	if err != nil || got != "Titre Corps" {
+		t.Fatalf("odt: %q, %v", got, err)
+	}
+	if _, err := extractText("broken.docx", "", []byte("not a zip")); err == nil {
+		t.Fatal("broken docx should fail")
+	}
+}
+
+func TestNormalizeIndexTextTruncates(t *testing.T) {
+	s := normalizeIndexText(strings.Repeat("é", textIndexMaxChars))
+	if len(s) > textIndexMaxChars || !strings.HasSuffix(s, "é") {
+		t.Fatalf("truncated text invalid (len=%d)", len(s))
+	}
+}
+
+func TestSnippetHTML(t *testing.T) {
+	got := snippetHTML("<b>" + snippetStartSel + "devis" + snippetStopSel + " & co")
+	want := "&lt;b&gt;<mark>devis</mark> &amp; co"
+	if got != want {
+		t.Fatalf("snippetHTML = %q, want %q", got, want)
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	q := url.Values{"type": {"pdf"}, "modified_after": {"2024-01-01"}, "max_size": {"1000"}}
+	args := sqlArgs{"q"}
+	clauses, err := searchFilters(q.Get, &args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(clauses, "application/pdf") || !strings.Contains(clauses, ">= $2") || !strings.Contains(clauses, "n.size <= $3") {
+		t.Fatalf("clauses = %q", clauses)
+	}
+	if len(args) != 3 {
+		t.Fatalf("args = %v", args)
+	}
+	for _, bad := range []url.Values{{"type": {"exe"}}, {"modified_before": {"hier"}}, {"min_size": {"-1"}}} {
+		if _, err := searchFilters(bad.Get, &sqlArgs{}); err == nil {
+			t.Errorf("searchFilters(%v) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+// text_search.go — filtres et extraits de GET /drive/nodes/search (nom + contenu indexé).
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Délimiteurs de surlignage passés à ts_headline, convertis en <mark> après échappement HTML.
+const (
+	snippetStartSel = "⟦"
+	snippetStopSel  = "⟧"
+)
+
+var snippetHeadlineOptions = "StartSel=" + snippetStartSel + ",StopSel=" + snippetStopSel +
+	",MaxFragments=2,MaxWords=18,MinWords=6,FragmentDelimiter= … "
+
+// snippetHTML échappe l'extrait puis remplace les délimiteurs par <mark>…</mark>.
+func snippetHTML(s string) string {
+	if s == "" {
+		return ""
+	}
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, snippetStartSel, "<mark>")
+	return strings.ReplaceAll(s, snippetStopSel, "</mark>")
+}
+
+// searchTypeClauses — filtre ?type= (famille de fichiers) sur l'alias n.
+var searchTypeClauses = map[string]string{
+	"folder":       `n.is_folder = true`,
+	"image":        `n.is_folder = false AND (n.mime_type LIKE 'image/%' OR LOWER(n.name) ~ '\.(jpe?g|png|gif|webp|heic|heif|bmp|tiff?|svg|avif)$')`,
+	"video":        `n.is_folder = false AND (n.mime_type LIKE 'video/%' OR LOWER(n.name) ~ '\.(mp4|m4v|mov|webm|mkv|avi)$')`,
+	"audio":        `n.is_folder = false AND (n.mime_type LIKE 'audio/%' OR LOWER(n.name) ~ '\.(mp3|wav|flac|ogg|opus|m4a|aac)$')`,
+	"pdf":          `n.is_folder = false AND (n.mime_type = 'application/pdf' OR LOWER(n.name) ~ '\.pdf$')`,
+	"document":     `n.is_folder = false AND LOWER(n.name) ~ '\.(docx?|odt|rtf|pages)$'`,
+	"spreadsheet":  `n.is_folder = false AND LOWER(n.name) ~ '\.(xlsx?|ods|csv|tsv|numbers)$'`,
+	"presentation": `n.is_folder = false AND LOWER(n.name) ~ '\.(pptx?|odp|key)$'`,
+	"text":         `n.is_folder = false AND (n.mime_type LIKE 'text/%' OR LOWER(n.name) ~ '\.(txt|md|markdown|log|rst)$')`,
+	"archive":      `n.is_folder = false AND LOWER(n.name) ~ '\.(zip|tar|tgz|gz|bz2|xz|zst|7z|rar)$'`,
+}
+
+// parseSearchDate accepte RFC3339 ou AAAA-MM-JJ ; endOfDay décale une date seule à la fin du jour.
+func parseSearchDate(raw string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return t, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// sqlArgs numérote les paramètres d'une requête construite dynamiquement.
+type sqlArgs []any
+
+func (a *sqlArgs) add(v any) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// searchFilters lit type / modified_after / modified_before / min_size / max_size et
+// renvoie les clauses SQL correspondantes (préfixées par AND).
+func searchFilters(get func(string) string, args *sqlArgs) (string, error) {
+	var b strings.Builder
+	if kind := strings.ToLower(strings.TrimSpace(get("type"))); kind != "" {
+		clause, ok := searchTypeClauses[kind]
+		if !ok {
+			return "", fmt.Errorf("invalid type %q", kind)
+		}
+		b.WriteString(" AND (" + clause + ")")
+	}
+	for _, f := range []struct {
+		param, op string
+		end       bool
+	}{{"modified_after", ">=", false}, {"modified_before", "<=", true}} {
+		raw := strings.TrimSpace(get(f.param))
+		if raw == "" {
+			continue
+		}
+		t, err := parseSearchDate(raw, f.end)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s", f.param)
+		}
+		b.WriteString(" AND COALESCE(n.updated_at, n.created_at) " + f.op + " " + args.add(t))
+	}
+	for _, f := range []struct{ param, op string }{{"min_size", ">="}, {"max_size", "<="}} {
+		raw := strings.TrimSpace(get(f.param))
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("invalid %s", f.param)
+		}
+		b.WriteString(" AND n.is_folder = false AND n.size " + f.op + " " + args.add(n))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,416 @@
+package main
+
+// thumbnails.go — cache persistant des vignettes (table drive_thumbnails, migration 52).
+//
+// Chaque contenu (content_hash) est décodé une seule fois : orientation EXIF appliquée,
+// redimensionnement Catmull-Rom en cascade lg → md → sm, encodage JPEG et WebP. Le
+// worker génère les vignettes après upload (kickContentWorkers) ; GET /drive/nodes/:id/thumbnail
+// les sert depuis le cache et ne les génère à la volée que si le worker n'est pas encore passé.
+// Les vidéos passent par generateVideoThumbnails (video.go) : image de couverture.
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chai2010/webp"
+	"golang.org/x/image/draw"
+)
+
+const (
+	thumbFormatJPEG = "jpeg"
+	thumbFormatWebP = "webp"
+	thumbBatch      = 10
+)
+
+// thumbVariants — du plus grand au plus petit (chaque variante est dérivée de la précédente).
+var thumbVariants = []struct {
+	name    string
+	maxSide int
+}{
+	{"lg", 1600},
+	{"md", 720},
+	{"sm", 256},
+}
+
+var thumbFormats = []string{thumbFormatJPEG, thumbFormatWebP}
+
+// thumbVariantFor choisit la variante : ?variant=sm|md|lg, sinon la plus petite couvrant ?size=.
+func thumbVariantFor(variant, size string) string {
+	variant = strings.ToLower(strings.TrimSpace(variant))
+	for _, v := range thumbVariants {
+		if v.name == variant {
+			return variant
+		}
+	}
+	px := 360
+	if n, err := strconv.Atoi(size); err == nil && n > 0 {
+		px = n
+	}
+	for i := len(thumbVariants) - 1; i >= 0; i-- {
+		if px <= thumbVariants[i].maxSide {
+			return thumbVariants[i].name
+		}
+	}
+	return thumbVariants[0].name
+}
+
+// thumbFormatFor : ?format=webp|jpeg, sinon WebP si le client l'annonce dans Accept.
+func thumbFormatFor(format, accept string) string {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case thumbFormatWebP:
+		return thumbFormatWebP
+	case thumbFormatJPEG, "jpg":
+		return thumbFormatJPEG
+	}
+	if strings.Contains(strings.ToLower(accept), "image/webp") {
+		return thumbFormatWebP
+	}
+	return thumbFormatJPEG
+}
+
+func thumbContentType(format string) string {
+	if format == thumbFormatWebP {
+		return "image/webp"
+	}
+	return "image/jpeg"
+}
+
+type thumbRendition struct {
+	Variant, Format string
+	Width, Height   int
+	Data            []byte
+}
+
+// scaleToFit réduit src pour que son plus grand côté fasse maxSide (jamais d'agrandissement).
+func scaleToFit(src image.Image, maxSide int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= 0 || h <= 0 || (w <= maxSide && h <= maxSide) {
+		return src
+	}
+	newW, newH := maxSide, maxSide
+	if w >= h {
+		newH = max(1, h*maxSide/w)
+	} else {
+		newW = max(1, w*maxSide/h)
+	}
+	// Très forte réduction : pré-passe bilinéaire à 2× la cible, le noyau Catmull-Rom
+	// sur l'original complet (24 Mpx et plus) serait inutilement coûteux.
+	if w > newW*4 {
+		mid := image.NewRGBA(image.Rect(0, 0, newW*2, newH*2))
+		draw.ApproxBiLinear.Scale(mid, mid.Bounds(), src, b, draw.Src, nil)
+		src, b = mid, mid.Bounds()
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
+
+func encodeThumbnail(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if format == thumbFormatWebP {
+		err = webp.Encode(&buf, img, &webp.Options{Quality: 78})
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 82})
+	}
+	return buf.Bytes(), err
+}
+
+// renderThumbnails décode l'original et produit toutes les variantes dans tous les formats.
+func renderThumbnails(name, contentType string, content []byte) ([]thumbRendition, error) {
+	img, err := decodeThumbnailImage(name, contentType, content)
+	if err != nil {
+		return nil, err
+	}
+	return renderThumbnailSet(img, exifOrientation(name, contentType, content))
+}
+
+// renderThumbnailSet redimensionne img en cascade et encode chaque variante.
+func renderThumbnailSet(img image.Image, orientation int) ([]thumbRendition, error) {
+	out := make([]thumbRendition, 0, len(thumbVariants)*len(thumbFormats))
+	src := img
+	for _, v := range thumbVariants {
+		src = scaleToFit(src, v.maxSide)
+		oriented := applyExifOrientation(src, orientation)
+		ob := oriented.Bounds()
+		for _, f := range thumbFormats {
+			data, err := encodeThumbnail(oriented, f)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, thumbRendition{Variant: v.name, Format: f, Width: ob.Dx(), Height: ob.Dy(), Data: data})
+		}
+	}
+	return out, nil
+}
+
+// loadThumbnail lit une vignette en cache ; failed indique un contenu déjà reconnu non décodable.
+func (h *Handler) loadThumbnail(ctx context.Context, hash, variant, format string) (data []byte, failed bool, err error) {
+	var errMsg sql.NullString
+	err = h.dbex(ctx).QueryRow(`
+		SELECT data, error FROM drive_thumbnails
+		WHERE content_hash = $1 AND ((variant = $2 AND format = $3) OR (variant = 'sm' AND format = 'jpeg' AND error IS NOT NULL))
+		ORDER BY error NULLS FIRST
+		LIMIT 1
+	`, hash, variant, format).Scan(&data, &errMsg)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if errMsg.Valid {
+		return nil, true, nil
+	}
+	return data, false, nil
+}
+
+func (h *Handler) storeThumbnails(ctx context.Context, hash string, list []thumbRendition) error {
+	for _, t := range list {
+		if _, err := h.dbex(ctx).Exec(`
+			INSERT INTO drive_thumbnails (content_hash, variant, format, width, height, data)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (content_hash, variant, format) DO UPDATE SET
+				width = EXCLUDED.width, height = EXCLUDED.height, data = EXCLUDED.data, error = NULL, created_at = CURRENT_TIMESTAMP
+		`, hash, t.Variant, t.Format, t.Width, t.Height, t.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) storeThumbnailFailure(ctx context.Context, hash string, cause error) error {
+	_, err := h.dbex(ctx).Exec(`
+		INSERT INTO drive_thumbnails (content_hash, variant, format, error)
+		VALUES ($1, 'sm', 'jpeg', $2)
+		ON CONFLICT (content_hash, variant, format) DO NOTHING
+	`, hash, cause.Error())
+	return err
+}
+
+// generateThumbnails rend et enregistre toutes les variantes d'un contenu ; en cas d'échec
+// de décodage, l'échec est mémorisé et l'erreur renvoyée.
+func (h *Handler) generateThumbnails(ctx context.Context, hash, name, contentType string, content []byte) ([]thumbRendition, error) {
+	list, err := renderThumbnails(name, contentType, content)
+	if err != nil {
+		if serr := h.storeThumbnailFailure(ctx, hash, err); serr != nil {
+			log.Printf("[drive] thumbnail failure %s: %v", hash, serr)
+		}
+		return nil, err
+	}
+	if err := h.storeThumbnails(ctx, hash, list); err != nil {
+		log.Printf("[drive] thumbnail store %s: %v", hash, err)
+	}
+	return list, nil
+}
+
+// dropThumbnails invalide les vignettes (et l'empreinte perceptuelle) d'un ancien contenu si
+// plus aucun nœud ne le référence.
+func (h *Handler) dropThumbnails(ctx context.Context, hash string) {
+	if hash == "" {
+		return
+	}
+	if _, err := h.dbex(ctx).Exec(`
+		DELETE FROM drive_thumbnails
+		WHERE content_hash = $1 AND NOT EXISTS (SELECT 1 FROM drive_nodes WHERE content_hash = $1)
+	`, hash); err != nil {
+		log.Printf("[drive] thumbnail invalidate %s: %v", hash, err)
+	}
+	if _, err := h.dbex(ctx).Exec(`
+		DELETE FROM drive_photo_hashes
+		WHERE content_hash = $1 AND NOT EXISTS (SELECT 1 FROM drive_nodes WHERE content_hash = $1)
+	`, hash); err != nil {
+		log.Printf("[drive] photo hash invalidate %s: %v", hash, err)
+	}
+}
+
+// thumbKick réveille le worker de vignettes après un upload (envoi non bloquant).
+var thumbKick = make(chan struct{}, 1)
+
+func kickThumbnailer() {
+	select {
+	case thumbKick <- struct{}{}:
+	default:
+	}
+}
+
+// kickContentWorkers réveille les workers d'arrière-plan (index texte, vignettes) après écriture d'un contenu.
+func kickContentWorkers() {
+	kickTextIndexer()
+	kickThumbnailer()
+}
+
+// thumbMaxBytes — au-delà (DRIVE_THUMBNAIL_MAX_BYTES, 64 Mo par défaut), pas de vignette générée.
+func thumbMaxBytes() int64 {
+	if v := os.Getenv("DRIVE_THUMBNAIL_MAX_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 64 << 20
+}
+
+// runWorkerItem exécute fn en convertissant une panique (décodeur sur un fichier malformé)
+// en erreur : un seul fichier ne doit ni arrêter le worker ni être repris à chaque passage.
+func runWorkerItem(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	fn()
+	return nil
+}
+
+func (h *Handler) startThumbnailWorker() {
+	if os.Getenv("DRIVE_THUMBNAIL_WORKER_DISABLED") == "1" {
+		log.Println("[drive] thumbnail worker disabled (DRIVE_THUMBNAIL_WORKER_DISABLED=1)")
+		return
+	}
+	tk := time.NewTicker(time.Minute)
+	defer tk.Stop()
+	// Worker async sans request HTTP : pas de conn pin, les requêtes filtrent explicitement.
+	ctx := context.Background()
+	for {
+		for {
+			n, err := h.generatePendingThumbnails(ctx, thumbBatch)
+			if err != nil {
+				log.Printf("[drive] thumbnail worker: %v", err)
+				break
+			}
+			if n < thumbBatch {
+				break
+			}
+		}
+		for {
+			n, err := h.extractPendingPhotoMeta(ctx, thumbBatch)
+			if err != nil {
+				log.Printf("[drive] photo metadata worker: %v", err)
+				break
+			}
+			if n < thumbBatch {
+				break
+			}
+		}
+		for {
+			n, err := h.computePendingPhotoHashes(ctx, thumbBatch*10)
+			if err != nil {
+				log.Printf("[drive] photo hash worker: %v", err)
+				break
+			}
+			if n < thumbBatch*10 {
+				break
+			}
+		}
+		if _, err := h.dbex(ctx).Exec(`
+			DELETE FROM drive_thumbnails t
+			WHERE t.created_at < CURRENT_TIMESTAMP - INTERVAL '1 hour'
+			  AND NOT EXISTS (SELECT 1 FROM drive_nodes n WHERE n.content_hash = t.content_hash)
+		`); err != nil {
+			log.Printf("[drive] thumbnail purge: %v", err)
+		}
+		if _, err := h.dbex(ctx).Exec(`
+			DELETE FROM drive_photo_hashes p
+			WHERE p.created_at < CURRENT_TIMESTAMP - INTERVAL '1 hour'
+			  AND NOT EXISTS (SELECT 1 FROM drive_nodes n WHERE n.content_hash = p.content_hash)
+		`); err != nil {
+			log.Printf("[drive] photo hash purge: %v", err)
+		}
+		select {
+		case <-tk.C:
+		case <-thumbKick:
+		}
+	}
+}
+
+// generatePendingThumbnails traite au plus limit images sans vignette ; renvoie le nombre traité.
+func (h *Handler) generatePendingThumbnails(ctx context.Context, limit int) (int, error) {
+	rows, err := h.dbex(ctx).Query(`
+		SELECT DISTINCT ON (COALESCE(n.content_hash, n.id::text)) n.id, n.name, COALESCE(n.mime_type, ''), COALESCE(n.content_hash, ''), n.size
+		FROM drive_nodes n
+		WHERE n.deleted_at IS NULL AND n.vault_encrypted = false AND n.size > 0
+		  AND ((`+searchTypeClauses["image"]+` AND n.size <= $2) OR (`+searchTypeClauses["video"]+`))
+		  AND NOT (`+searchTypeClauses["pdf"]+`)
+		  AND (n.content_hash IS NULL OR NOT EXISTS (
+		    SELECT 1 FROM drive_thumbnails t WHERE t.content_hash = n.content_hash AND t.variant = 'sm' AND t.format = 'jpeg'
+		  ))
+		ORDER BY COALESCE(n.content_hash, n.id::text), n.id
+		LIMIT $1
+	`, limit, thumbMaxBytes())
+	if err != nil {
+		return 0, err
+	}
+	type candidate struct {
+		id               int
+		name, mime, hash string
+		size             int64
+	}
+	list := make([]candidate, 0, limit)
+	for rows.Next() {
+		var it candidate
+		if err := rows.Scan(&it.id, &it.name, &it.mime, &it.hash, &it.size); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		list = append(list, it)
+	}
+	rows.Close()
+	for _, it := range list {
+		hash := it.hash
+		if hash == "" {
+			if hash, err = h.ensureContentHash(ctx, it.id); err != nil {
+				log.Printf("[drive] thumbnail node=%d: %v", it.id, err)
+				continue
+			}
+		}
+		perr := runWorkerItem(func() {
+			if isVideoLike(it.name, it.mime) {
+				if _, err := h.generateVideoThumbnails(ctx, it.id, hash, it.name, it.size); err != nil {
+					log.Printf("[drive] video thumbnail node=%d: %v", it.id, err)
+				}
+				return
+			}
+			var content []byte
+			if err := h.dbex(ctx).QueryRow(`SELECT COALESCE(content, ''::bytea) FROM drive_nodes WHERE id = $1`, it.id).Scan(&content); err != nil {
+				log.Printf("[drive] thumbnail node=%d: %v", it.id, err)
+				return
+			}
+			if _, err := h.generateThumbnails(ctx, hash, it.name, it.mime, content); err != nil {
+				log.Printf("[drive] thumbnail node=%d: %v", it.id, err)
+			}
+		})
+		if perr != nil {
+			log.Printf("[drive] thumbnail node=%d: %v", it.id, perr)
+			if err := h.storeThumbnailFailure(ctx, hash, perr); err != nil {
+				log.Printf("[drive] thumbnail failure %s: %v", hash, err)
+			}
+		}
+	}
+	return len(list), nil
+}
+
+// ensureContentHash renseigne content_hash (calculé par Postgres) pour les fichiers antérieurs
+// à la migration 45, sans rapatrier le contenu.
+func (h *Handler) ensureContentHash(ctx context.Context, id int) (string, error) {
+	var hash string
+	err := h.dbex(ctx).QueryRow(`
+		UPDATE drive_nodes SET content_hash = COALESCE(content_hash, encode(sha256(content), 'hex'))
+		WHERE id = $1 AND octet_length(content) > 0
+		RETURNING content_hash
+	`, id).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", errors.New("empty content")
+	}
+	return hash, err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestThumbVariantFor(t *testing.T) {
+	for _, tc := range []struct{ variant, size, want string }{
+		{"md", "", "md"},
+		{"", "", "md"},
+		{"", "200", "sm"},
+		{"", "256", "sm"},
+		{"", "360", "md"},
+		{"", "1024", "lg"},
+		{"", "5000", "lg"},
+		{"xl", "100", "sm"},
+	} {
+		if got := thumbVariantFor(tc.variant, tc.size); got != tc.want {
+			t.Errorf("thumbVariantFor(%q, %q) = %q, want %q", tc.variant, tc.size, got, tc.want)
+		}
+	}
+}
+
+func TestThumbFormatFor(t *testing.T) {
+	if thumbFormatFor("", "image/avif,image/webp,*/*") != thumbFormatWebP {
+		t.Error("Accept image/webp should select webp")
+	}
+	if thumbFormatFor("jpg", "image/webp") != thumbFormatJPEG {
+		t.Error("explicit format must win over Accept")
+	}
+	if thumbFormatFor("", "*/*") != thumbFormatJPEG {
+		t.Error("default format should be jpeg")
+	}
+}
+
+func TestApplyExifOrientation(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	red := color.RGBA{255, 0, 0, 255}
+	src.Set(0, 0, red)
+	rot := applyExifOrientation(src, 6)
+	if b := rot.Bounds(); b.Dx() != 2 || b.Dy() != 4 {
+		t.Fatalf("orientation 6 bounds = %v", b)
+	}
+	if rot.At(1, 0) != red {
+		t.Fatal("orientation 6: top-left pixel should move to top-right")
+	}
+	if applyExifOrientation(src, 1) != image.Image(src) {
+		t.Fatal("orientation 1 must be a no-op")
+	}
+}
+
+func TestRenderThumbnails(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2000, 1000))
+	for y := 0; y < 1000; y++ {
+		for x := 0; x < 2000; x++ {
+			src.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	list, err := renderThumbnails("photo.png", "image/png", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != len(thumbVariants)*len(thumbFormats) {
+		t.Fatalf("got %d renditions", len(list))
+	}
+	for _, r := range list {
+		switch r.Format {
+		case thumbFormatJPEG:
+			if !bytes.HasPrefix(r.Data, []byte{0xFF, 0xD8}) {
+				t.Errorf("%s/%s: not a JPEG", r.Variant, r.Format)
+			}
+		case thumbFormatWebP:
+			if len(r.Data) < 12 || string(r.Data[8:12]) != "WEBP" {
+				t.Errorf("%s/%s: not a WebP", r.Variant, r.Format)
+			}
+		}
+		if r.Variant == "sm" && (r.Width != 256 || r.Height != 128) {
+			t.Errorf("sm = %dx%d, want 256x128", r.Width, r.Height)
+		}
+	}
+	if _, err := renderThumbnails("broken.jpg", "image/jpeg", []byte("nope")); err == nil {
+		t.Fatal("undecodable image should fail")
+	}
+}
+
+func TestRenderThumbnailsRejectsOversizedImage(t *testing.T) {
+	t.Setenv("DRIVE_THUMBNAIL_MAX_PIXELS", "100")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 20, 20))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := renderThumbnails("big.png", "image/png", buf.Bytes()); err == nil {
+		t.Fatal("400 px image accepted with a 100 px limit")
+	}
+}
+
+func TestRunWorkerItemRecoversPanic(t *testing.T) {
+	if err := runWorkerItem(func() { panic("corrupt file") }); err == nil {
+		t.Fatal("panic not converted to error")
+	}
+	if err := runWorkerItem(func() {}); err != nil {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestLockedThumbnailNotCached(t *testing.T) {
+	for _, locked := range []bool{false, true} {
+		db, _ := newFakeDB(func(q string, args []driver.Value) fakeResult {
+			switch {
+			case strings.Contains(q, "photo_locked_at IS NOT NULL, user_id FROM drive_nodes"):
+				return fakeRow("IMG_0001.jpg", int64(0), "image/jpeg", false, "", locked, int64(1))
+			case strings.Contains(q, "FROM drive_locked_pins"):
+				return fakeRow("$argon2id$hash")
+			}
+			return fakeResult{cols: []string{"c"}}
+		})
+		h := &Handler{db: db}
+		r := gin.New()
+		r.GET("/drive/nodes/:id/thumbnail", h.getNodeThumbnail)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/drive/nodes/9/thumbnail", nil)
+		req.Header.Set("X-User-ID", "1")
+		req.Header.Set("X-Locked-Access", issueLockedAccess(shareAccessSecret(), 1, "$argon2id$hash", time.Now()))
+		r.ServeHTTP(w, req)
+		want := "private, max-age=3600"
+		if locked {
+			want = "private, no-store"
+		}
+		if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != want {
+			t.Errorf("locked=%v: got %d Cache-Control %q, want %q", locked, w.Code, w.Header().Get("Cache-Control"), want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+// trash.go — rétention et purge de la corbeille Drive.
+//
+// deleteNode ne fait que poser deleted_at ; la corbeille occupe toujours le quota
+// (loadQuotaUsage). Après DRIVE_TRASH_RETENTION_DAYS (30 jours par défaut, 0 = jamais),
+// le worker purge les éléments ; DELETE /drive/nodes/trash vide la corbeille d'un coup et
+// DELETE /drive/nodes/trash/:id purge un élément. Les trois passent par purgeTrashed :
+// suppression du sous-arbre (cascade parent_id, index texte, partages, albums) puis
+// libération des vignettes et empreintes des contenus qui ne sont plus référencés.
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const trashPurgeBatch = 200
+
+// trashRetention — durée de conservation en corbeille (DRIVE_TRASH_RETENTION_DAYS) ; 0 désactive la purge automatique.
+func trashRetention() time.Duration {
+	days := 30
+	if v := os.Getenv("DRIVE_TRASH_RETENTION_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			days = n
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// trashPurgeAtSQL — date de purge automatique d'une entrée (vide si la rétention est désactivée) ;
+// $1 = durée de rétention en secondes.
+const trashPurgeAtSQL = `CASE WHEN $1::float8 > 0 THEN (deleted_at + make_interval(secs => $1::float8))::text ELSE '' END`
+
+// purgeTrashed supprime définitivement les nœuds en corbeille vérifiant cond (et leurs
+// descendants) ; renvoie le nombre de nœuds supprimés et les octets libérés.
+func (h *Handler) purgeTrashed(ctx context.Context, cond string, args ...any) (nodes, freed int64, err error) {
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	var hashes pq.StringArray
+	err = tx.QueryRow(`
+		WITH RECURSIVE doomed AS (
+			SELECT id, content_hash, size, is_folder FROM drive_nodes WHERE deleted_at IS NOT NULL AND (`+cond+`)
+			UNION
+			SELECT c.id, c.content_hash, c.size, c.is_folder FROM drive_nodes c JOIN doomed d ON c.parent_id = d.id
+		)
+		SELECT COUNT(*), COALESCE(SUM(size) FILTER (WHERE NOT is_folder), 0),
+		       COALESCE(array_agg(DISTINCT content_hash) FILTER (WHERE content_hash IS NOT NULL), '{}')
+		FROM doomed
+	`, args...).Scan(&nodes, &freed, &hashes)
+	if err != nil || nodes == 0 {
+		return 0, 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM drive_nodes WHERE deleted_at IS NOT NULL AND (`+cond+`)`, args...); err != nil {
+		return 0, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	for _, hash := range hashes {
+		h.dropThumbnails(ctx, hash)
+	}
+	return nodes, freed, nil
+}
+
+// emptyTrash — DELETE /drive/nodes/trash : purge toute la corbeille de l'utilisateur.
+func (h *Handler) emptyTrash(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	nodes, freed, err := h.purgeTrashed(c.Request.Context(), `user_id = current_setting('app.current_user_id', true)::INTEGER`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"purged": nodes, "freed_bytes": freed})
+}
+
+// startTrashPurgeWorker purge toutes les heures les éléments en corbeille depuis plus que la rétention.
+func (h *Handler) startTrashPurgeWorker() {
+	retention := trashRetention()
+	if retention <= 0 {
+		log.Println("[drive] trash purge disabled (DRIVE_TRASH_RETENTION_DAYS=0)")
+		return
+	}
+	tk := time.NewTicker(time.Hour)
+	defer tk.Stop()
+	// Worker async sans request HTTP : pas de conn pin, les requêtes filtrent explicitement.
+	ctx := context.Background()
+	for {
+		var total, freed int64
+		for {
+			n, b, err := h.purgeTrashed(ctx, `id IN (
+				SELECT id FROM drive_nodes
+				WHERE deleted_at IS NOT NULL AND deleted_at < CURRENT_TIMESTAMP - make_interval(secs => $1::float8)
+				ORDER BY deleted_at
+				LIMIT $2
+			)`, retention.Seconds(), trashPurgeBatch)
+			if err != nil {
+				log.Printf("[drive] trash purge: %v", err)
+				break
+			}
+			total, freed = total+n, freed+b
+			if n == 0 {
+				break
+			}
+		}
+		if total > 0 {
+			log.Printf("[drive] trash purge: %d nodes removed, %d bytes released", total, freed)
+		}
+		<-tk.C
+	}
+}
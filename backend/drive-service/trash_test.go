@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTrashRetention(t *testing.T) {
+	cases := []struct {
+		env  string
+		want time.Duration
+	}{
+		{"", 30 * 24 * time.Hour},
+		{"7", 7 * 24 * time.Hour},
+		{"0", 0},
+		{"-3", 30 * 24 * time.Hour},
+		{"abc", 30 * 24 * time.Hour},
+	}
+	for _, tc := range cases {
+		t.Setenv("DRIVE_TRASH_RETENTION_DAYS", tc.env)
+		if got := trashRetention(); got != tc.want {
+			t.Errorf("trashRetention(%q) = %v, want %v", tc.env, got, tc.want)
+		}
+	}
+}
+
+func TestEmptyTrashRequiresAuth(t *testing.T) {
+	r := setupRouter(nil)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/drive/nodes/trash", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("DELETE /drive/nodes/trash without X-User-ID: got %d", w.Code)
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+// video.go — vidéos dans Photos : métadonnées (video_meta.go) enregistrées sur drive_nodes,
+// image de couverture pour le cache de vignettes, lecture par plages (Range) sans charger
+// le fichier entier, et URL de lecture signée pour <video> (qui ne peut pas envoyer de JWT).
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// photoVideoCondSQL — fichiers vidéo de la timeline (colonnes drive_nodes sans alias).
+const photoVideoCondSQL = `(LOWER(COALESCE(mime_type, '')) LIKE 'video/%' OR LOWER(name) ~ '\.(mp4|m4v|mov|3gp|webm)$')`
+
+var videoExts = map[string]bool{".mp4": true, ".m4v": true, ".mov": true, ".3gp": true, ".webm": true, ".mkv": true}
+
+func isVideoLike(name, contentType string) bool {
+	if videoExts[strings.ToLower(path.Ext(name))] {
+		return true
+	}
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), "video/")
+}
+
+// nodeContentBlock — taille des tranches lues par nodeContentReader.
+const nodeContentBlock = 1 << 20
+
+// nodeContentReader lit drive_nodes.content par tranches (substring) : lecture par plages
+// et analyse des atomes sans charger une vidéo de plusieurs centaines de Mo en mémoire.
+type nodeContentReader struct {
+	ctx      context.Context
+	h        *Handler
+	id       int
+	size     int64
+	blockOff int64
+	block    []byte
+}
+
+func (r *nodeContentReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) && off < r.size {
+		if r.block == nil || off < r.blockOff || off >= r.blockOff+int64(len(r.block)) {
+			start := off - off%nodeContentBlock
+			var b []byte
+			if err := r.h.dbex(r.ctx).QueryRow(`
+				SELECT substring(content FROM $2 FOR $3) FROM drive_nodes WHERE id = $1
+			`, r.id, start+1, nodeContentBlock).Scan(&b); err != nil {
+				return n, err
+			}
+			if len(b) == 0 {
+				return n, io.ErrUnexpectedEOF
+			}
+			r.blockOff, r.block = start, b
+		}
+		c := copy(p[n:], r.block[off-r.blockOff:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// storeVideoMeta enregistre durée / dimensions / codec sur tous les nœuds de ce contenu ;
+// la date des atomes remplace celle déduite du nom de fichier.
+func (h *Handler) storeVideoMeta(ctx context.Context, hash string, meta videoMeta) error {
+	var takenAt any
+	if !meta.TakenAt.IsZero() {
+		takenAt = meta.TakenAt
+	}
+	_, err := h.dbex(ctx).Exec(`
+		UPDATE drive_nodes SET duration_ms = $2, media_width = $3, media_height = $4,
+			video_codec = NULLIF($5, ''), video_faststart = $6, taken_at = COALESCE($7, taken_at)
+		WHERE content_hash = $1
+	`, hash, meta.DurationMs, meta.Width, meta.Height, meta.Codec, meta.FastStart, takenAt)
+	return err
+}
+
+// generateVideoThumbnails analyse la vidéo, enregistre ses métadonnées et met en cache
+// les vignettes tirées de l'image de couverture.
+func (h *Handler) generateVideoThumbnails(ctx context.Context, id int, hash, name string, size int64) ([]thumbRendition, error) {
+	r := &nodeContentReader{ctx: ctx, h: h, id: id, size: size}
+	meta, err := parseVideoMeta(r, size)
+	if err != nil {
+		// WebM / MKV ou fichier tronqué : pas de métadonnées, couverture générique.
+		log.Printf("[drive] video meta node=%d (%s): %v", id, name, err)
+	} else if err := h.storeVideoMeta(ctx, hash, meta); err != nil {
+		return nil, err
+	}
+	list, err := renderThumbnailSet(videoPoster(ctx, r, size, meta), 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.storeThumbnails(ctx, hash, list); err != nil {
+		log.Printf("[drive] thumbnail store %s: %v", hash, err)
+	}
+	return list, nil
+}
+
+// errFFmpegDisabled — DRIVE_FFMPEG_PATH non défini : la couverture reste en Go pur.
+var errFFmpegDisabled = errors.New("ffmpeg poster extraction disabled")
+
+// videoPoster : pochette embarquée (Go pur), sinon couverture générique aux proportions
+// de la vidéo. L'extraction d'une image par ffmpeg est un ajout explicite (DRIVE_FFMPEG_PATH) :
+// le service ne dépend d'aucun binaire externe par défaut.
+func videoPoster(ctx context.Context, r io.ReaderAt, size int64, meta videoMeta) image.Image {
+	if len(meta.Cover) > 0 {
+		if img, err := decodeImageBounded(meta.Cover); err == nil {
+			return img
+		}
+	}
+	if img, err := ffmpegPoster(ctx, r, size, meta.DurationMs); err == nil {
+		return img
+	} else if !errors.Is(err, errFFmpegDisabled) {
+		log.Printf("[drive] video poster: %v", err)
+	}
+	return placeholderPoster(meta.Width, meta.Height)
+}
+
+// videoPosterMaxBytes — au-delà (DRIVE_VIDEO_POSTER_MAX_BYTES, 512 Mo), pas d'appel à ffmpeg.
+func videoPosterMaxBytes() int64 {
+	if v := os.Getenv("DRIVE_VIDEO_POSTER_MAX_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 512 << 20
+}
+
+func ffmpegPoster(ctx context.Context, r io.ReaderAt, size int64, durationMs int64) (image.Image, error) {
+	bin := strings.TrimSpace(os.Getenv("DRIVE_FFMPEG_PATH"))
+	if bin == "" {
+		return nil, errFFmpegDisabled
+	}
+	bin, err := exec.LookPath(bin)
+	if err != nil {
+		return nil, err
+	}
+	if size > videoPosterMaxBytes() {
+		return nil, fmt.Errorf("video too large for poster extraction (%d bytes)", size)
+	}
+	tmp, err := os.CreateTemp("", "cloudity-video-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, io.NewSectionReader(r, 0, size))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Image à 1 s (ou au milieu des vidéos plus courtes) : la toute première est souvent noire.
+	at := min(1000, durationMs/2)
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, bin, "-v", "error", "-ss", fmt.Sprintf("%.3f", float64(at)/1000),
+		"-i", tmp.Name(), "-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "-").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+	return png.Decode(bytes.NewReader(out))
+}
+
+// placeholderPoster dessine un dégradé sombre avec un triangle « lecture » centré.
+func placeholderPoster(w, h int) image.Image {
+	if w <= 0 || h <= 0 {
+		w, h = 1280, 720
+	}
+	if w > 1280 || h > 1280 {
+		if w >= h {
+			w, h = 1280, max(1, h*1280/w)
+		} else {
+			w, h = max(1, w*1280/h), 1280
+		}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		shade := uint8(55 - 30*y/h)
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{shade, shade, shade + 8, 255})
+		}
+	}
+	side := min(w, h) / 4
+	cx, cy := w/2, h/2
+	for y := -side / 2; y <= side/2; y++ {
+		half := side/2 - abs(y)
+		for x := -side / 3; x <= -side/3+half*2; x++ {
+			img.Set(cx+x, cy+y, color.RGBA{235, 235, 235, 255})
+		}
+	}
+	return img
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// streamTokenTTL — durée de validité d'une URL de lecture signée ; le lecteur en redemande une
+// au-delà (nouvelle ouverture du média).
+const streamTokenTTL = time.Hour
+
+// isStreamableMedia — seuls les contenus servis en video/* ou audio/* ont une URL de lecture :
+// l'URL signée ne doit pas devenir un lien de téléchargement générique (HTML servi inline, etc.).
+func isStreamableMedia(name string, mime sql.NullString) bool {
+	ct := strings.ToLower(nodeContentType(name, mime, nil))
+	return strings.HasPrefix(ct, "video/") || strings.HasPrefix(ct, "audio/")
+}
+
+var errInvalidStreamToken = errors.New("invalid stream token")
+
+// streamTokenMAC lie le jeton au nœud, à l'utilisateur et, pour un nœud du dossier verrouillé,
+// au hash du code courant (pinHash vide sinon) : verrouiller le nœud ou changer le code
+// invalide les URL déjà émises, comme pour lockedAccessMAC.
+func streamTokenMAC(secret []byte, nodeID, userID int, pinHash string, expiry int64) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte("stream|" + strconv.Itoa(nodeID) + "|" + strconv.Itoa(userID) + "|" + pinHash + "|" + strconv.FormatInt(expiry, 10)))
+	return m.Sum(nil)
+}
+
+// issueStreamToken : "<node>.<user>.<expiry>.<mac>" ; l'accès au nœud est revérifié à chaque requête.
+// Un nœud verrouillé n'obtient qu'une URL de la durée d'un jeton d'accès (lockedAccessTTL).
+func issueStreamToken(secret []byte, nodeID, userID int, pinHash string, now time.Time) (string, time.Time) {
+	ttl := streamTokenTTL
+	if pinHash != "" {
+		ttl = lockedAccessTTL
+	}
+	expiry := now.Add(ttl)
+	mac := streamTokenMAC(secret, nodeID, userID, pinHash, expiry.Unix())
+	return fmt.Sprintf("%d.%d.%d.%s", nodeID, userID, expiry.Unix(), base64.RawURLEncoding.EncodeToString(mac)), expiry
+}
+
+// streamToken — jeton décodé et non expiré ; la signature se vérifie (verify) une fois l'état
+// de verrouillage du nœud connu.
+type streamToken struct {
+	nodeID, userID int
+	expiry         int64
+	mac            []byte
+}
+
+func parseStreamToken(token string, now time.Time) (streamToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return streamToken{}, errInvalidStreamToken
+	}
+	nodeID, err1 := strconv.Atoi(parts[0])
+	userID, err2 := strconv.Atoi(parts[1])
+	expiry, err3 := strconv.ParseInt(parts[2], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || nodeID <= 0 || userID <= 0 || now.Unix() > expiry {
+		return streamToken{}, errInvalidStreamToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return streamToken{}, errInvalidStreamToken
+	}
+	return streamToken{nodeID: nodeID, userID: userID, expiry: expiry, mac: mac}, nil
+}
+
+func (t streamToken) verify(secret []byte, pinHash string) bool {
+	return hmac.Equal(t.mac, streamTokenMAC(secret, t.nodeID, t.userID, pinHash, t.expiry))
+}
+
+// getNodeStreamURL — GET /drive/nodes/:id/stream : URL signée pour <video>/<audio> (Range).
+func (h *Handler) getNodeStreamURL(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	uid, _ := strconv.Atoi(c.GetHeader("X-User-ID"))
+	ctx := c.Request.Context()
+	var vaultEncrypted, locked bool
+	var ownerID int
+	var name string
+	var mime sql.NullString
+	err = h.dbex(ctx).QueryRow(`
+		SELECT vault_encrypted, photo_locked_at IS NOT NULL, user_id, name, mime_type FROM drive_nodes
+		WHERE id = $1 AND is_folder = false AND deleted_at IS NULL AND `+nodeAccessSQL("id", accessViewer)+`
+	`, id).Scan(&vaultEncrypted, &locked, &ownerID, &name, &mime)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.requireLockedAccess(c, locked, ownerID) {
+		return
+	}
+	var pinHash string
+	if locked {
+		if pinHash, err = h.lockedPinHash(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if vaultEncrypted {
+		// Le serveur ne détient que le chiffré : lecture uniquement après déchiffrement côté client.
+		c.JSON(http.StatusConflict, gin.H{"error": "vault_encrypted", "code": "VAULT_ENCRYPTED"})
+		return
+	}
+	if !isStreamableMedia(name, mime) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not_streamable", "code": "NOT_STREAMABLE"})
+		return
+	}
+	token, expiry := issueStreamToken(shareAccessSecret(), id, uid, pinHash, time.Now())
+	c.JSON(http.StatusOK, gin.H{"url": "/drive/public/stream/" + token, "expires_at": expiry.UTC().Format(time.RFC3339)})
+}
+
+// getPublicStream — GET|HEAD /drive/public/stream/:token : contenu servi par plages depuis la base.
+func (h *Handler) getPublicStream(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	tok, err := parseStreamToken(c.Param("token"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	id, uid := tok.nodeID, tok.userID
+	release, ok := h.pinUserConn(c, uid)
+	if !ok {
+		return
+	}
+	defer release()
+	ctx := c.Request.Context()
+	var name string
+	var size int64
+	var mime sql.NullString
+	var hash string
+	var locked bool
+	var ownerID int
+	err = h.dbex(ctx).QueryRow(`
+		SELECT name, size, mime_type, COALESCE(content_hash, ''), photo_locked_at IS NOT NULL, user_id FROM drive_nodes
+		WHERE id = $1 AND is_folder = false AND deleted_at IS NULL AND vault_encrypted = false
+		  AND `+nodeAccessSQL("id", accessViewer)+`
+	`, id).Scan(&name, &size, &mime, &hash, &locked, &ownerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Même règle que requireLockedAccess : nœud verrouillé réservé au propriétaire, et URL émise
+	// sous le code courant (un jeton émis avant verrouillage ne porte pas son hash).
+	var pinHash string
+	if locked {
+		if uid != ownerID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		if pinHash, err = h.lockedPinHash(ctx); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+	}
+	if !tok.verify(shareAccessSecret(), pinHash) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if !isStreamableMedia(name, mime) {
+		// Type modifié (renommage) depuis l'émission de l'URL.
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.Header("Content-Type", nodeContentType(name, mime, nil))
+	c.Header("Content-Disposition", `inline; filename="`+dispositionFilename(name)+`"`)
+	if locked {
+		c.Header("Cache-Control", "private, no-store")
+	} else {
+		c.Header("Cache-Control", "private, max-age=600")
+	}
+	if hash != "" {
+		c.Header("ETag", `"`+hash+`"`)
+	}
+	r := &nodeContentReader{ctx: ctx, h: h, id: id, size: size}
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, io.NewSectionReader(r, 0, size))
+}
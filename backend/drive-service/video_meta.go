@@ -0,0 +1,308 @@
+package main
+
+// video_meta.go — lecture des atomes MP4 / QuickTime (ISO BMFF) en Go pur : date de prise,
+// durée, dimensions d'affichage (matrice de rotation), codec, position du moov (faststart)
+// et pochette éventuelle (ilst/covr). Seuls ftyp/moov sont lus : mdat n'est jamais chargé.
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// videoMetaMaxMoov — plafond de l'atome moov chargé en mémoire (tables d'échantillons comprises).
+const videoMetaMaxMoov = 64 << 20
+
+var errNoMoov = errors.New("mp4: moov atom not found")
+
+// mp4Epoch — origine des dates mvhd (1904-01-01 UTC).
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type videoMeta struct {
+	TakenAt       time.Time // zéro si inconnue
+	DurationMs    int64
+	Width, Height int // dimensions d'affichage (rotation appliquée)
+	Rotation      int // 0, 90, 180, 270
+	Codec         string
+	FastStart     bool   // moov avant mdat : lecture possible avant la fin du téléchargement
+	Location      string // ISO 6709 (com.apple.quicktime.location.ISO6709 / ©xyz)
+	Cover         []byte // pochette ilst/covr (rare sur les vidéos de téléphone)
+}
+
+type mp4Box struct {
+	typ          string
+	offset, size int64 // boîte complète
+	header       int64
+}
+
+// readBoxHeader lit l'en-tête à off (size 32 bits, 64 bits si 1, jusqu'à la fin si 0).
+func readBoxHeader(r io.ReaderAt, off, end int64) (mp4Box, error) {
+	var hdr [16]byte
+	if _, err := r.ReadAt(hdr[:8], off); err != nil {
+		return mp4Box{}, err
+	}
+	b := mp4Box{typ: string(hdr[4:8]), offset: off, header: 8}
+	size := int64(binary.BigEndian.Uint32(hdr[:4]))
+	switch size {
+	case 0:
+		size = end - off
+	case 1:
+		if _, err := r.ReadAt(hdr[8:16], off+8); err != nil {
+			return mp4Box{}, err
+		}
+		size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+		b.header = 16
+	}
+	if size < b.header || off+size > end {
+		return mp4Box{}, errors.New("mp4: invalid box size")
+	}
+	b.size = size
+	return b, nil
+}
+
+type mp4Child struct {
+	typ  string
+	data []byte
+}
+
+// childBoxes découpe un payload en mémoire en boîtes filles (ordre conservé).
+func childBoxes(p []byte) []mp4Child {
+	var out []mp4Child
+	for len(p) >= 8 {
+		size := int(binary.BigEndian.Uint32(p[:4]))
+		typ := string(p[4:8])
+		hdr := 8
+		if size == 1 && len(p) >= 16 {
+			size = int(binary.BigEndian.Uint64(p[8:16]))
+			hdr = 16
+		} else if size == 0 {
+			size = len(p)
+		}
+		if size < hdr || size > len(p) {
+			break
+		}
+		out = append(out, mp4Child{typ, p[hdr:size]})
+		p = p[size:]
+	}
+	return out
+}
+
+func findChild(p []byte, path ...string) []byte {
+	for _, name := range path {
+		var next []byte
+		found := false
+		for _, c := range childBoxes(p) {
+			if c.typ == name {
+				next, found = c.data, true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+		p = next
+	}
+	return p
+}
+
+// parseVideoMeta lit les métadonnées d'un fichier MP4 / MOV / M4V / 3GP de taille size.
+func parseVideoMeta(r io.ReaderAt, size int64) (videoMeta, error) {
+	var meta videoMeta
+	var moov []byte
+	mdatAt, moovAt := int64(-1), int64(-1)
+	for off := int64(0); off+8 <= size; {
+		b, err := readBoxHeader(r, off, size)
+		if err != nil {
+			break
+		}
+		switch b.typ {
+		case "moov":
+			if b.size-b.header > videoMetaMaxMoov {
+				return meta, errors.New("mp4: moov atom too large")
+			}
+			moov = make([]byte, b.size-b.header)
+			if _, err := r.ReadAt(moov, off+b.header); err != nil && err != io.EOF {
+				return meta, err
+			}
+			moovAt = off
+		case "mdat":
+			if mdatAt < 0 {
+				mdatAt = off
+			}
+		}
+		off += b.size
+	}
+	if moov == nil {
+		return meta, errNoMoov
+	}
+	meta.FastStart = mdatAt < 0 || moovAt < mdatAt
+
+	if mvhd := findChild(moov, "mvhd"); len(mvhd) >= 20 {
+		var created uint64
+		var timescale uint32
+		var duration uint64
+		if mvhd[0] == 1 && len(mvhd) >= 32 {
+			created = binary.BigEndian.Uint64(mvhd[4:12])
+			timescale = binary.BigEndian.Uint32(mvhd[20:24])
+			duration = binary.BigEndian.Uint64(mvhd[24:32])
+		} else {
+			created = uint64(binary.BigEndian.Uint32(mvhd[4:8]))
+			timescale = binary.BigEndian.Uint32(mvhd[12:16])
+			duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+		}
+		if timescale > 0 {
+			meta.DurationMs = int64(duration * 1000 / uint64(timescale))
+		}
+		// Beaucoup d'appareils écrivent 0 (ou 1904/1970) quand l'horloge n'est pas réglée.
+		if created > 0 {
+			if t := mp4Epoch.Add(time.Duration(created) * time.Second); t.Year() > 1970 {
+				meta.TakenAt = t
+			}
+		}
+	}
+
+	for _, c := range childBoxes(moov) {
+		if c.typ != "trak" {
+			continue
+		}
+		if hdlr := findChild(c.data, "mdia", "hdlr"); len(hdlr) < 12 || string(hdlr[8:12]) != "vide" {
+			continue
+		}
+		if tkhd := findChild(c.data, "tkhd"); len(tkhd) > 0 {
+			meta.Width, meta.Height, meta.Rotation = tkhdDisplaySize(tkhd)
+		}
+		if stsd := findChild(c.data, "mdia", "minf", "stbl", "stsd"); len(stsd) >= 16 {
+			meta.Codec = strings.TrimSpace(string(stsd[12:16]))
+		}
+		break
+	}
+
+	if udta := findChild(moov, "udta"); udta != nil {
+		for _, c := range childBoxes(udta) {
+			switch c.typ {
+			case "\xa9day":
+				if t, ok := parseVideoDate(qtUserDataString(c.data)); ok && meta.TakenAt.IsZero() {
+					meta.TakenAt = t
+				}
+			case "\xa9xyz":
+				meta.Location = qtUserDataString(c.data)
+			case "meta":
+				applyMetaAtom(&meta, c.data)
+			}
+		}
+	}
+	if m := findChild(moov, "meta"); m != nil {
+		applyMetaAtom(&meta, m)
+	}
+	return meta, nil
+}
+
+// tkhdDisplaySize : largeur / hauteur (virgule fixe 16.16) et rotation déduite de la matrice.
+func tkhdDisplaySize(tkhd []byte) (w, h, rotation int) {
+	matrixAt := 40
+	if tkhd[0] == 1 {
+		matrixAt = 52
+	}
+	if len(tkhd) < matrixAt+44 {
+		return 0, 0, 0
+	}
+	m := func(i int) int32 { return int32(binary.BigEndian.Uint32(tkhd[matrixAt+4*i:])) }
+	w = int(binary.BigEndian.Uint32(tkhd[matrixAt+36:]) >> 16)
+	h = int(binary.BigEndian.Uint32(tkhd[matrixAt+40:]) >> 16)
+	const one = 0x10000
+	switch a, b, c, d := m(0), m(1), m(3), m(4); {
+	case a == 0 && b == one && c == -one:
+		rotation = 90
+	case a == -one && d == -one:
+		rotation = 180
+	case a == 0 && b == -one && c == one:
+		rotation = 270
+	}
+	if rotation == 90 || rotation == 270 {
+		w, h = h, w
+	}
+	return w, h, rotation
+}
+
+// qtUserDataString : chaîne QuickTime « internationale » (taille 16 bits, langue 16 bits, texte).
+func qtUserDataString(p []byte) string {
+	if len(p) < 4 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint16(p[:2]))
+	if n > len(p)-4 {
+		n = len(p) - 4
+	}
+	return strings.TrimRight(string(p[4:4+n]), "\x00")
+}
+
+// applyMetaAtom lit un atome meta : clés mdta (QuickTime, iPhone) et éléments iTunes (©day, covr).
+func applyMetaAtom(meta *videoMeta, p []byte) {
+	// MP4 : meta est une « full box » (version + flags) ; QuickTime : boîte simple.
+	if len(p) >= 8 {
+		switch string(p[4:8]) {
+		case "hdlr", "keys", "ilst", "free":
+		default:
+			p = p[4:]
+		}
+	}
+	var keys []string
+	if k := findChild(p, "keys"); len(k) >= 8 {
+		count := int(binary.BigEndian.Uint32(k[4:8]))
+		q := k[8:]
+		for i := 0; i < count && len(q) >= 8; i++ {
+			size := int(binary.BigEndian.Uint32(q[:4]))
+			if size < 8 || size > len(q) {
+				break
+			}
+			keys = append(keys, string(q[8:size]))
+			q = q[size:]
+		}
+	}
+	ilst := findChild(p, "ilst")
+	for _, item := range childBoxes(ilst) {
+		data := findChild(item.data, "data")
+		if len(data) < 8 {
+			continue
+		}
+		value := data[8:]
+		name := item.typ
+		if idx := int(binary.BigEndian.Uint32([]byte(item.typ))); idx >= 1 && idx <= len(keys) {
+			name = keys[idx-1]
+		}
+		switch name {
+		case "com.apple.quicktime.creationdate":
+			if t, ok := parseVideoDate(string(value)); ok {
+				meta.TakenAt = t
+			}
+		case "\xa9day":
+			if t, ok := parseVideoDate(string(value)); ok && meta.TakenAt.IsZero() {
+				meta.TakenAt = t
+			}
+		case "com.apple.quicktime.location.ISO6709":
+			meta.Location = strings.TrimRight(string(value), "\x00")
+		case "covr":
+			meta.Cover = value
+		}
+	}
+}
+
+// parseVideoDate accepte les formats rencontrés dans creationdate / ©day.
+func parseVideoDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(strings.TrimRight(s, "\x00"))
+	for _, layout := range []string{
+		"2006-01-02T15:04:05-0700",
+		time.RFC3339,
+		"2006-01-02T15:04:05Z0700",
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	} {
+		if t, err := time.Parse(layout, s); err == nil && t.Year() > 1970 {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
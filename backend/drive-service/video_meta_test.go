@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func box(typ string, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	out := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(out, uint32(8+len(payload)))
+	copy(out[4:], typ)
+	return append(out, payload...)
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+// testMP4 construit un MP4 minimal : vidéo 1920x1080 tournée à 90°, 12,5 s, moov avant mdat.
+func testMP4(t *testing.T) []byte {
+	t.Helper()
+	created := uint32(time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC).Sub(mp4Epoch) / time.Second)
+	mvhd := box("mvhd", u32(0), u32(created), u32(created), u32(1000), u32(12500), make([]byte, 80))
+	tkhd := bytes.Join([][]byte{
+		u32(0), u32(0), u32(0), u32(1), u32(0), u32(12500), make([]byte, 16),
+		u32(0), u32(0x10000), u32(0), u32(0xFFFF0000), u32(0), u32(0), u32(0), u32(0), u32(0x40000000),
+		u32(1920 << 16), u32(1080 << 16),
+	}, nil)
+	hdlr := box("hdlr", u32(0), u32(0), []byte("vide"), make([]byte, 12))
+	stsd := box("stsd", u32(0), u32(1), box("avc1", make([]byte, 78)))
+	trak := box("trak", box("tkhd", tkhd), box("mdia", hdlr, box("minf", box("stbl", stsd))))
+	key := "com.apple.quicktime.creationdate"
+	keys := box("keys", u32(0), u32(1), u32(uint32(8+len(key))), []byte("mdta"), []byte(key))
+	ilst := box("ilst", box(string(u32(1)), box("data", u32(1), u32(0), []byte("2023-06-01T12:34:56+0200"))))
+	meta := box("meta", box("hdlr", u32(0), u32(0), []byte("mdta"), make([]byte, 12)), keys, ilst)
+	moov := box("moov", mvhd, trak, meta)
+	return bytes.Join([][]byte{box("ftyp", []byte("qt  "), u32(0)), moov, box("mdat", make([]byte, 64))}, nil)
+}
+
+func TestParseVideoMeta(t *testing.T) {
+	data := testMP4(t)
+	meta, err := parseVideoMeta(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.DurationMs != 12500 {
+		t.Errorf("DurationMs = %d", meta.DurationMs)
+	}
+	if meta.Width != 1080 || meta.Height != 1920 || meta.Rotation != 90 {
+		t.Errorf("display = %dx%d rot %d, want 1080x1920 rot 90", meta.Width, meta.Height, meta.Rotation)
+	}
+	if meta.Codec != "avc1" || !meta.FastStart {
+		t.Errorf("codec = %q faststart = %v", meta.Codec, meta.FastStart)
+	}
+	if want := time.Date(2023, 6, 1, 10, 34, 56, 0, time.UTC); !meta.TakenAt.Equal(want) {
+		t.Errorf("TakenAt = %v, want %v (creationdate wins over mvhd)", meta.TakenAt, want)
+	}
+	if _, err := parseVideoMeta(bytes.NewReader([]byte("not a video")), 11); err == nil {
+		t.Error("expected error without moov")
+	}
+}
+
+func TestStreamToken(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Now()
+	token, _ := issueStreamToken(secret, 42, 7, "", now)
+	tok, err := parseStreamToken(token, now)
+	if err != nil || tok.nodeID != 42 || tok.userID != 7 || !tok.verify(secret, "") {
+		t.Fatalf("parseStreamToken = %+v, %v", tok, err)
+	}
+	if _, err := parseStreamToken(token, now.Add(streamTokenTTL+time.Minute)); err == nil {
+		t.Error("expired token accepted")
+	}
+	if tok, err := parseStreamToken("43"+token[2:], now); err == nil && tok.verify(secret, "") {
+		t.Error("tampered token accepted")
+	}
+	// Nœud verrouillé depuis l'émission : le jeton ne porte pas le hash du code.
+	if tok.verify(secret, "pin-hash") {
+		t.Error("token issued before locking accepted for a locked node")
+	}
+}
+
+func TestStreamTokenLockedNode(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Now()
+	token, expiry := issueStreamToken(secret, 42, 7, "pin-hash", now)
+	if expiry.After(now.Add(lockedAccessTTL)) {
+		t.Fatalf("locked stream token expires at %v, beyond lockedAccessTTL", expiry)
+	}
+	tok, err := parseStreamToken(token, now)
+	if err != nil || !tok.verify(secret, "pin-hash") {
+		t.Fatalf("locked token rejected: %v", err)
+	}
+	if tok.verify(secret, "") || tok.verify(secret, "new-pin-hash") {
+		t.Error("locked token accepted after unlock or PIN change")
+	}
+	if _, err := parseStreamToken(token, now.Add(lockedAccessTTL+time.Minute)); err == nil {
+		t.Error("locked token accepted past lockedAccessTTL")
+	}
+}
+
+func TestIsStreamableMedia(t *testing.T) {
+	for _, tc := range []struct {
+		name, mime string
+		want       bool
+	}{
+		{"clip.mp4", "", true},
+		{"song.mp3", "", true},
+		{"voice", "audio/ogg", true},
+		{"IMG_0001.MOV", "application/octet-stream", true},
+		{"page.html", "", false},
+		{"photo.jpg", "image/jpeg", false},
+		{"report.pdf", "application/pdf", false},
+		{"blob", "", false},
+	} {
+		mime := sql.NullString{String: tc.mime, Valid: tc.mime != ""}
+		if got := isStreamableMedia(tc.name, mime); got != tc.want {
+			t.Errorf("isStreamableMedia(%q, %q) = %v, want %v", tc.name, tc.mime, got, tc.want)
+		}
+	}
+}
+
+func TestIsVideoLikeAndPoster(t *testing.T) {
+	if !isVideoLike("IMG_0001.MOV", "") || !isVideoLike("clip", "video/mp4") || isVideoLike("a.jpg", "image/jpeg") {
+		t.Fatal("isVideoLike")
+	}
+	if b := placeholderPoster(1080, 1920).Bounds(); b.Dx() != 720 || b.Dy() != 1280 {
+		t.Fatalf("placeholder bounds = %v", b)
+	}
+}
+
+// Sans DRIVE_FFMPEG_PATH, la couverture reste en Go pur : pochette embarquée sinon générique,
+// même si un binaire ffmpeg est présent dans le PATH.
+func TestVideoPosterFallback(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+	script := "#!/bin/sh\ntouch " + marker + "\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+	t.Setenv("DRIVE_FFMPEG_PATH", "")
+	video := bytes.NewReader([]byte("not a real video"))
+	meta := videoMeta{Width: 1920, Height: 1080}
+	if b := videoPoster(context.Background(), video, video.Size(), meta).Bounds(); b.Dx() != 1280 || b.Dy() != 720 {
+		t.Fatalf("placeholder bounds = %v", b)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("ffmpeg must not run unless DRIVE_FFMPEG_PATH is set")
+	}
+	var cover bytes.Buffer
+	if err := png.Encode(&cover, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	meta.Cover = cover.Bytes()
+	if b := videoPoster(context.Background(), video, video.Size(), meta).Bounds(); b.Dx() != 40 || b.Dy() != 30 {
+		t.Fatalf("embedded cover bounds = %v", b)
+	}
+	// Binaire configuré mais absent : repli sur la couverture générique.
+	t.Setenv("DRIVE_FFMPEG_PATH", filepath.Join(dir, "missing-ffmpeg"))
+	meta.Cover = nil
+	if b := videoPoster(context.Background(), video, video.Size(), meta).Bounds(); b.Dx() != 1280 || b.Dy() != 720 {
+		t.Fatalf("missing ffmpeg bounds = %v", b)
+	}
+}
+
+func TestNodeStreamRequiresAuth(t *testing.T) {
+	r := setupRouter(nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/drive/nodes/1/stream", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /drive/nodes/1/stream without X-User-ID: got %d", w.Code)
+	}
+}
+
+func TestServeNodeContentRange(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/drive/nodes/1/content?inline=1", nil)
+	c.Request.Header.Set("Range", "bytes=2-5")
+	serveNodeContent(c, "clip.mp4", []byte("0123456789"), sql.NullString{}, false)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Fatalf("range: got %d %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "video/mp4" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
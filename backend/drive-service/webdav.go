@@ -0,0 +1,682 @@
+package main
+
+// webdav.go — montage du Drive en WebDAV (/drive/dav/…) pour Nautilus, Finder,
+// Explorateur Windows et rclone.
+//
+// Le protocole (PROPFIND, PROPPATCH, GET, PUT, MKCOL, MOVE, COPY, DELETE, LOCK/UNLOCK)
+// est délégué à golang.org/x/net/webdav ; davFS expose l'arborescence drive_nodes de
+// l'utilisateur avec la sémantique habituelle du Drive :
+//   - DELETE met en corbeille (deleted_at), comme DELETE /drive/nodes/:id ;
+//   - PUT / COPY débitent le quota (reserveQuota) ; un dépassement répond 413/507 ;
+//   - le dossier système Photos ne peut être ni renommé ni supprimé.
+// Seule l'arborescence propre de l'utilisateur est exposée (pas « Partagés avec moi »).
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"golang.org/x/net/webdav"
+)
+
+const davPrefix = "/drive/dav"
+
+// davMaxFileBytes — taille max d'un fichier écrit en WebDAV : le contenu est tamponné en
+// mémoire jusqu'au Close, il faut donc borner le tampon indépendamment du quota.
+func davMaxFileBytes() int64 {
+	if v := os.Getenv("DRIVE_WEBDAV_MAX_FILE_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 512 << 20
+}
+
+// davMethods — verbes WebDAV routés vers serveWebDAV (gin n'a pas de Any pour PROPFIND & co).
+var davMethods = []string{
+	http.MethodOptions, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodPost,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// Verrous WebDAV en mémoire, un espace par utilisateur (les chemins sont relatifs à son Drive).
+var (
+	davLocksMu sync.Mutex
+	davLocks   = map[int]webdav.LockSystem{}
+)
+
+func davLockSystem(uid int) webdav.LockSystem {
+	davLocksMu.Lock()
+	defer davLocksMu.Unlock()
+	ls, ok := davLocks[uid]
+	if !ok {
+		ls = webdav.NewMemLS()
+		davLocks[uid] = ls
+	}
+	return ls
+}
+
+// serveWebDAV — toutes méthodes sur /drive/dav et /drive/dav/*path.
+func (h *Handler) serveWebDAV(c *gin.Context) {
+	login, secret, ok := davCredentials(c.Request)
+	if !ok {
+		davChallenge(c)
+		return
+	}
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	uid, tid, err := h.authenticateAppPassword(c.Request.Context(), login, secret)
+	if errors.Is(err, errInvalidAppPassword) {
+		davChallenge(c)
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	release, ok := h.pinUserConn(c, uid)
+	if !ok {
+		return
+	}
+	defer release()
+	if c.Request.Method == http.MethodPut && c.Request.ContentLength > davMaxFileBytes() {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
+		return
+	}
+	dfs := &davFS{h: h, uid: uid, tid: tid}
+	dav := &webdav.Handler{
+		Prefix:     davPrefix,
+		FileSystem: dfs,
+		LockSystem: davLockSystem(uid),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("[drive] webdav %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	dav.ServeHTTP(&davStatusWriter{ResponseWriter: c.Writer, fs: dfs}, c.Request)
+}
+
+// davChallenge — 401 avec défi Basic (indispensable pour que Finder / Explorateur demandent les identifiants).
+func davChallenge(c *gin.Context) {
+	c.Header("WWW-Authenticate", `Basic realm="Cloudity Drive", charset="UTF-8"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "app password required"})
+}
+
+// davStatusWriter remplace le 405 générique de x/net/webdav par 413/507 quand l'écriture
+// a échoué sur le quota (même code que writeQuotaError).
+type davStatusWriter struct {
+	http.ResponseWriter
+	fs *davFS
+}
+
+func (w *davStatusWriter) WriteHeader(status int) {
+	var qe *quotaExceededError
+	if status >= 400 && errors.As(w.fs.quotaErr, &qe) {
+		status = http.StatusInsufficientStorage
+		if qe.Requested > qe.Max {
+			status = http.StatusRequestEntityTooLarge
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// davFS implémente webdav.FileSystem sur drive_nodes (connexion épinglée sur uid).
+type davFS struct {
+	h        *Handler
+	uid, tid int
+	quotaErr error
+}
+
+// davQuota — marge d'un quota (utilisateur ou tenant) lue à l'ouverture en écriture ; commit
+// revérifie sous verrou, ceci ne sert qu'à refuser tôt un tampon qui ne tiendra pas.
+type davQuota struct {
+	scope     string
+	used, max int64
+}
+
+// writeQuotas lit les quotas non illimités de l'utilisateur et de son tenant.
+func (fsys *davFS) writeQuotas(ctx context.Context) ([]davQuota, error) {
+	q := fsys.h.dbex(ctx)
+	userMax, tenantMax, err := loadQuotaLimits(q, fsys.uid, fsys.tid)
+	if err != nil || (userMax <= 0 && tenantMax <= 0) {
+		return nil, err
+	}
+	userUsed, tenantUsed, err := loadQuotaUsage(q, fsys.uid, fsys.tid)
+	if err != nil {
+		return nil, err
+	}
+	var out []davQuota
+	if userMax > 0 {
+		out = append(out, davQuota{scope: "user", used: userUsed, max: userMax})
+	}
+	if tenantMax > 0 {
+		out = append(out, davQuota{scope: "tenant", used: tenantUsed, max: tenantMax})
+	}
+	return out, nil
+}
+
+// davEntry — nœud résolu ; id 0 = racine virtuelle du Drive.
+type davEntry struct {
+	id       int
+	name     string
+	isFolder bool
+	size     int64
+	modTime  time.Time
+	mime     string
+}
+
+func davRoot() davEntry {
+	return davEntry{name: "/", isFolder: true, modTime: time.Now()}
+}
+
+const davNodeColumns = `id, name, is_folder, size, COALESCE(updated_at, created_at), COALESCE(mime_type, '')`
+
+func scanDavEntry(sc interface{ Scan(...any) error }) (davEntry, error) {
+	var e davEntry
+	err := sc.Scan(&e.id, &e.name, &e.isFolder, &e.size, &e.modTime, &e.mime)
+	return e, err
+}
+
+// child cherche name directement sous parentID (0 = racine). Les photos du dossier
+// verrouillé sont invisibles : ni Stat, ni PUT, ni DELETE/MOVE sans le code (hors WebDAV).
+func (fsys *davFS) child(ctx context.Context, parentID int, name string) (davEntry, error) {
+	var row *sql.Row
+	if parentID == 0 {
+		row = fsys.h.dbex(ctx).QueryRow(`
+			SELECT `+davNodeColumns+` FROM drive_nodes
+			WHERE user_id = current_setting('app.current_user_id', true)::INTEGER AND parent_id IS NULL AND name = $1 AND deleted_at IS NULL AND photo_locked_at IS NULL
+		`, name)
+	} else {
+		row = fsys.h.dbex(ctx).QueryRow(`
+			SELECT `+davNodeColumns+` FROM drive_nodes
+			WHERE user_id = current_setting('app.current_user_id', true)::INTEGER AND parent_id = $1 AND name = $2 AND deleted_at IS NULL AND photo_locked_at IS NULL
+		`, parentID, name)
+	}
+	e, err := scanDavEntry(row)
+	if err == sql.ErrNoRows {
+		return e, os.ErrNotExist
+	}
+	return e, err
+}
+
+// resolve parcourt le chemin (déjà nettoyé par x/net/webdav) depuis la racine.
+func (fsys *davFS) resolve(ctx context.Context, name string) (davEntry, error) {
+	e := davRoot()
+	for _, seg := range strings.Split(strings.Trim(name, "/"), "/") {
+		if seg == "" {
+			continue
+		}
+		if !e.isFolder {
+			return e, os.ErrNotExist
+		}
+		next, err := fsys.child(ctx, e.id, seg)
+		if err != nil {
+			return next, err
+		}
+		e = next
+	}
+	return e, nil
+}
+
+// resolveParent renvoie le dossier parent de name et le nom final.
+func (fsys *davFS) resolveParent(ctx context.Context, name string) (davEntry, string, error) {
+	clean := path.Clean("/" + name)
+	base := path.Base(clean)
+	if clean == "/" || base == "" || base == "." {
+		return davEntry{}, "", os.ErrInvalid
+	}
+	parent, err := fsys.resolve(ctx, path.Dir(clean))
+	if err != nil {
+		return parent, base, err
+	}
+	if !parent.isFolder {
+		return parent, base, os.ErrNotExist
+	}
+	return parent, base, nil
+}
+
+func davParentParam(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id > 0}
+}
+
+func davMapWriteError(err error) error {
+	var perr *pq.Error
+	if errors.As(err, &perr) && perr.Code == "23505" {
+		return os.ErrExist
+	}
+	return err
+}
+
+func (fsys *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	parent, base, err := fsys.resolveParent(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := fsys.child(ctx, parent.id, base); err == nil {
+		return os.ErrExist
+	}
+	_, err = fsys.h.dbex(ctx).Exec(`
+		INSERT INTO drive_nodes (tenant_id, user_id, parent_id, name, is_folder, size) VALUES ($1, $2, $3, $4, true, 0)
+	`, fsys.tid, fsys.uid, davParentParam(parent.id), base)
+	return davMapWriteError(err)
+}
+
+func (fsys *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	e, err := fsys.resolve(ctx, name)
+	if errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE != 0 {
+		parent, base, perr := fsys.resolveParent(ctx, name)
+		if perr != nil {
+			return nil, perr
+		}
+		quotas, qerr := fsys.writeQuotas(ctx)
+		if qerr != nil {
+			return nil, qerr
+		}
+		return &davFile{ctx: ctx, fs: fsys, entry: davEntry{name: base, modTime: time.Now()}, parentID: parent.id,
+			writable: true, isNew: true, dirty: true, loaded: true, quotas: quotas}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, os.ErrExist
+	}
+	if e.isFolder {
+		if writable {
+			return nil, os.ErrPermission
+		}
+		return &davFile{ctx: ctx, fs: fsys, entry: e}, nil
+	}
+	f := &davFile{ctx: ctx, fs: fsys, entry: e, writable: writable}
+	if writable {
+		if f.quotas, err = fsys.writeQuotas(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if writable && flag&os.O_TRUNC != 0 {
+		f.loaded, f.dirty = true, true
+	}
+	return f, nil
+}
+
+// RemoveAll met le nœud en corbeille (mêmes règles que deleteNode).
+func (fsys *davFS) RemoveAll(ctx context.Context, name string) error {
+	e, err := fsys.resolve(ctx, name)
+	if err != nil {
+		return err
+	}
+	if e.id == 0 {
+		return os.ErrPermission
+	}
+	if isRoot, err := fsys.h.isPhotosRootFolder(ctx, e.id); err != nil {
+		return err
+	} else if isRoot {
+		return os.ErrPermission
+	}
+	res, err := fsys.h.dbex(ctx).Exec(`
+		UPDATE drive_nodes SET deleted_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+		  AND photo_locked_at IS NULL
+	`, e.id)
+	if err != nil {
+		return err
+	}
+	if aff, _ := res.RowsAffected(); aff == 0 {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+// Rename déplace et/ou renomme (MOVE). La destination n'existe plus : x/net/webdav l'a
+// déjà supprimée (donc mise en corbeille) si Overwrite: T.
+func (fsys *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	e, err := fsys.resolve(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	if e.id == 0 {
+		return os.ErrPermission
+	}
+	if isRoot, err := fsys.h.isPhotosRootFolder(ctx, e.id); err != nil {
+		return err
+	} else if isRoot {
+		return os.ErrPermission
+	}
+	parent, base, err := fsys.resolveParent(ctx, newName)
+	if err != nil {
+		return err
+	}
+	// Pas de déplacement d'un dossier dans lui-même ou un de ses descendants.
+	for check := parent.id; check > 0; {
+		if check == e.id {
+			return os.ErrInvalid
+		}
+		var pid sql.NullInt64
+		if err := fsys.h.dbex(ctx).QueryRow(`SELECT parent_id FROM drive_nodes WHERE id = $1`, check).Scan(&pid); err != nil {
+			return err
+		}
+		check = int(pid.Int64)
+	}
+	res, err := fsys.h.dbex(ctx).Exec(`
+		UPDATE drive_nodes SET name = $1, parent_id = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+		  AND photo_locked_at IS NULL
+	`, base, davParentParam(parent.id), e.id)
+	if err != nil {
+		return davMapWriteError(err)
+	}
+	if aff, _ := res.RowsAffected(); aff == 0 {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+func (fsys *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	e, err := fsys.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return davFileInfo{e}, nil
+}
+
+func (fsys *davFS) readDir(ctx context.Context, id int) ([]fs.FileInfo, error) {
+	var rows *sql.Rows
+	var err error
+	if id == 0 {
+		rows, err = fsys.h.dbex(ctx).Query(`
+			SELECT ` + davNodeColumns + ` FROM drive_nodes
+			WHERE user_id = current_setting('app.current_user_id', true)::INTEGER AND parent_id IS NULL AND deleted_at IS NULL AND photo_locked_at IS NULL
+			ORDER BY name
+		`)
+	} else {
+		rows, err = fsys.h.dbex(ctx).Query(`
+			SELECT `+davNodeColumns+` FROM drive_nodes
+			WHERE user_id = current_setting('app.current_user_id', true)::INTEGER AND parent_id = $1 AND deleted_at IS NULL AND photo_locked_at IS NULL
+			ORDER BY name
+		`, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]fs.FileInfo, 0)
+	for rows.Next() {
+		e, err := scanDavEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, davFileInfo{e})
+	}
+	return out, rows.Err()
+}
+
+// commit écrit le contenu tampon (PUT / COPY) avec réservation de quota, comme uploadFile.
+func (fsys *davFS) commit(ctx context.Context, f *davFile) error {
+	content := f.buf
+	size := int64(len(content))
+	mimeType := mimeFromFileName(f.entry.name)
+	contentHash := sha256HexContent(content)
+	var oldHash string
+	tx, err := fsys.h.dbex(ctx).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if f.isNew {
+		if _, err := reserveQuota(tx, fsys.uid, fsys.tid, size); err != nil {
+			fsys.quotaErr = err
+			return err
+		}
+		var takenAt sql.NullTime
+		if parsed, ok := photoTakenAtFromExif(f.entry.name, mimeType, content); ok {
+			takenAt = sql.NullTime{Time: parsed, Valid: true}
+		} else if parsed, ok := photoTakenAtFromFileName(f.entry.name); ok {
+			takenAt = sql.NullTime{Time: parsed, Valid: true}
+		}
+		if err := tx.QueryRow(`
+			INSERT INTO drive_nodes (tenant_id, user_id, parent_id, name, is_folder, size, mime_type, content, taken_at, content_hash)
+			VALUES ($1, $2, $3, $4, false, $5, $6, $7, $8, $9) RETURNING id
+		`, fsys.tid, fsys.uid, davParentParam(f.parentID), f.entry.name, size, mimeType, content, takenAt, contentHashParam(contentHash)).Scan(&f.entry.id); err != nil {
+			return davMapWriteError(err)
+		}
+	} else {
+		var oldSize int64
+		if err := tx.QueryRow(`
+			SELECT size, COALESCE(content_hash, '') FROM drive_nodes
+			WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+			  AND photo_locked_at IS NULL
+			FOR UPDATE
+		`, f.entry.id).Scan(&oldSize, &oldHash); err == sql.ErrNoRows {
+			return os.ErrNotExist
+		} else if err != nil {
+			return err
+		}
+		if _, err := reserveQuota(tx, fsys.uid, fsys.tid, size-oldSize); err != nil {
+			fsys.quotaErr = err
+			return err
+		}
+		res, err := tx.Exec(`
+			UPDATE drive_nodes SET content = $1, size = $2, mime_type = $3, vault_encrypted = false, content_hash = $4, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $5 AND photo_locked_at IS NULL
+		`, content, size, mimeType, contentHashParam(contentHash), f.entry.id)
+		if err != nil {
+			return err
+		}
+		if aff, _ := res.RowsAffected(); aff == 0 {
+			return os.ErrNotExist
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if oldHash != contentHash {
+		fsys.h.dropThumbnails(ctx, oldHash)
+	}
+	kickContentWorkers()
+	f.isNew, f.dirty = false, false
+	f.entry.size, f.entry.mime, f.entry.modTime = size, mimeType, time.Now()
+	return nil
+}
+
+// davFile — fichier ou dossier ouvert. Le contenu (bytea) est chargé à la première lecture
+// et les écritures sont tamponnées jusqu'à Close.
+type davFile struct {
+	ctx      context.Context
+	fs       *davFS
+	entry    davEntry
+	parentID int
+
+	writable bool
+	isNew    bool
+	dirty    bool
+	loaded   bool
+	buf      []byte
+	pos      int64
+	quotas   []davQuota
+
+	children []fs.FileInfo
+	dirPos   int
+}
+
+func (f *davFile) load() error {
+	if f.loaded {
+		return nil
+	}
+	if err := f.fs.h.dbex(f.ctx).QueryRow(`
+		SELECT COALESCE(content, ''::bytea) FROM drive_nodes
+		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER AND deleted_at IS NULL
+		  AND photo_locked_at IS NULL
+	`, f.entry.id).Scan(&f.buf); err != nil {
+		if err == sql.ErrNoRows {
+			// Supprimé entre-temps, ou photo du dossier verrouillé (jamais servie sans code).
+			return os.ErrNotExist
+		}
+		return err
+	}
+	f.loaded = true
+	return nil
+}
+
+func (f *davFile) Close() error {
+	if f.writable && f.dirty {
+		return f.fs.commit(f.ctx, f)
+	}
+	return nil
+}
+
+func (f *davFile) Read(p []byte) (int, error) {
+	if f.entry.isFolder {
+		return 0, os.ErrInvalid
+	}
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	if f.pos >= int64(len(f.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.buf[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	if f.entry.isFolder {
+		return 0, os.ErrInvalid
+	}
+	size := f.entry.size
+	if f.loaded {
+		size = int64(len(f.buf))
+	}
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.pos + offset
+	case io.SeekEnd:
+		abs = size + offset
+	default:
+		return 0, os.ErrInvalid
+	}
+	if abs < 0 {
+		return 0, os.ErrInvalid
+	}
+	f.pos = abs
+	return abs, nil
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	if !f.writable {
+		return 0, os.ErrPermission
+	}
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	end := f.pos + int64(len(p))
+	if end > int64(len(f.buf)) {
+		if err := f.checkGrow(end); err != nil {
+			// Abandon de l'écriture : Close ne doit pas committer un contenu tronqué.
+			f.buf, f.dirty, f.writable = nil, false, false
+			f.fs.quotaErr = err
+			return 0, err
+		}
+		f.buf = append(f.buf, make([]byte, end-int64(len(f.buf)))...)
+	}
+	copy(f.buf[f.pos:end], p)
+	f.pos = end
+	f.dirty = true
+	return len(p), nil
+}
+
+// checkGrow refuse d'agrandir le tampon à end octets au-delà de davMaxFileBytes (413) ou
+// de la marge de quota lue à l'ouverture (507, ou 413 si le fichier dépasse à lui seul le quota),
+// avant toute allocation : un Seek loin dans le fichier suivi d'un Write ne réserve rien.
+func (f *davFile) checkGrow(end int64) error {
+	if max := davMaxFileBytes(); end > max {
+		return &quotaExceededError{Scope: "file", Max: max, Requested: end}
+	}
+	delta := end - f.entry.size
+	for _, q := range f.quotas {
+		if err := checkQuota(q.scope, q.used, delta, q.max); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if !f.entry.isFolder {
+		return nil, os.ErrInvalid
+	}
+	if f.children == nil {
+		children, err := f.fs.readDir(f.ctx, f.entry.id)
+		if err != nil {
+			return nil, err
+		}
+		f.children = children
+	}
+	rest := f.children[f.dirPos:]
+	if count <= 0 {
+		f.dirPos = len(f.children)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	f.dirPos += count
+	return rest[:count], nil
+}
+
+func (f *davFile) Stat() (fs.FileInfo, error) {
+	e := f.entry
+	if f.loaded && !e.isFolder {
+		e.size = int64(len(f.buf))
+	}
+	return davFileInfo{e}, nil
+}
+
+// davFileInfo — fs.FileInfo + webdav.ContentTyper (évite de relire le contenu au PROPFIND).
+type davFileInfo struct{ e davEntry }
+
+func (i davFileInfo) Name() string       { return i.e.name }
+func (i davFileInfo) Size() int64        { return i.e.size }
+func (i davFileInfo) ModTime() time.Time { return i.e.modTime }
+func (i davFileInfo) IsDir() bool        { return i.e.isFolder }
+func (i davFileInfo) Sys() any           { return nil }
+
+func (i davFileInfo) Mode() fs.FileMode {
+	if i.e.isFolder {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (i davFileInfo) ContentType(ctx context.Context) (string, error) {
+	if i.e.isFolder {
+		return "httpd/unix-directory", nil
+	}
+	if i.e.mime != "" {
+		return i.e.mime, nil
+	}
+	return mimeFromFileName(i.e.name), nil
+}
+
+var _ webdav.ContentTyper = davFileInfo{}
@@ -0,0 +1,77 @@
+package main
+
+// webdav_auth.go — authentification du montage WebDAV par mot de passe d'application.
+//
+// Les clients WebDAV (Nautilus, Finder, Explorateur Windows, rclone) ne savent pas
+// faire le login JWT + 2FA : la gateway laisse passer /drive/dav/* sans Bearer et
+// c'est ici qu'on vérifie un mot de passe d'application (table app_passwords, créé
+// via POST /auth/app-passwords) reçu en Basic (identifiant = e-mail) ou en Bearer.
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const appPasswordScopeWebDAV = "webdav"
+
+var errInvalidAppPassword = errors.New("invalid app password")
+
+// appPasswordHash — identique à auth-service (app_passwords.go) : SHA-256 hex de la
+// forme normalisée (minuscules, sans tirets ni espaces).
+func appPasswordHash(raw string) string {
+	cleaned := strings.ToLower(strings.TrimSpace(raw))
+	cleaned = strings.ReplaceAll(cleaned, "-", "")
+	cleaned = strings.ReplaceAll(cleaned, " ", "")
+	sum := sha256.Sum256([]byte(cleaned))
+	return hex.EncodeToString(sum[:])
+}
+
+// davCredentials extrait (identifiant, secret) : Basic e-mail:mot-de-passe, ou Bearer <mot-de-passe>
+// (identifiant vide). ok=false si aucun des deux n'est présent.
+func davCredentials(r *http.Request) (login, secret string, ok bool) {
+	if user, pass, basic := r.BasicAuth(); basic {
+		return strings.TrimSpace(user), pass, pass != ""
+	}
+	auth := strings.TrimSpace(r.Header.Get("Authorization"))
+	if strings.HasPrefix(auth, "Bearer ") {
+		secret = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		return "", secret, secret != ""
+	}
+	return "", "", false
+}
+
+// authenticateAppPassword renvoie (user_id, tenant_id) du propriétaire d'un mot de passe
+// d'application actif portant le scope webdav. En Basic, l'identifiant doit être l'e-mail
+// (ou l'id) du propriétaire.
+func (h *Handler) authenticateAppPassword(ctx context.Context, login, secret string) (int, int, error) {
+	var appID, uid, tid int
+	var email string
+	err := h.dbex(ctx).QueryRow(`
+		SELECT ap.id, u.id, u.tenant_id, u.email
+		FROM app_passwords ap
+		INNER JOIN users u ON u.id = ap.user_id
+		WHERE ap.password_hash = $1 AND ap.revoked_at IS NULL
+		  AND $2 = ANY(ap.scopes) AND COALESCE(u.is_active, true)
+	`, appPasswordHash(secret), appPasswordScopeWebDAV).Scan(&appID, &uid, &tid, &email)
+	if err == sql.ErrNoRows {
+		return 0, 0, errInvalidAppPassword
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if login != "" && !strings.EqualFold(login, email) && login != strconv.Itoa(uid) {
+		return 0, 0, errInvalidAppPassword
+	}
+	// last_used_at indicatif : au plus une écriture toutes les 5 minutes (PROPFIND en rafale).
+	_, _ = h.dbex(ctx).Exec(`
+		UPDATE app_passwords SET last_used_at = now()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '5 minutes')
+	`, appID)
+	return uid, tid, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestAppPasswordHashMatchesAuthService(t *testing.T) {
+	// Même normalisation que auth-service (app_passwords.go) : casse et tirets ignorés.
+	a := appPasswordHash("abcd-efgh-jkmn-pqrs-tuvw-xyz2")
+	b := appPasswordHash(" ABCDEFGHJKMNPQRSTUVWXYZ2 ")
+	if a != b || len(a) != 64 {
+		t.Fatalf("appPasswordHash mismatch: %q vs %q", a, b)
+	}
+}
+
+func TestDavCredentials(t *testing.T) {
+	req := httptest.NewRequest("PROPFIND", "/drive/dav/", nil)
+	req.SetBasicAuth("me@example.com", "secret")
+	if login, secret, ok := davCredentials(req); !ok || login != "me@example.com" || secret != "secret" {
+		t.Fatalf("basic: %q %q %v", login, secret, ok)
+	}
+	req = httptest.NewRequest("PROPFIND", "/drive/dav/", nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	if login, secret, ok := davCredentials(req); !ok || login != "" || secret != "tok" {
+		t.Fatalf("bearer: %q %q %v", login, secret, ok)
+	}
+	if _, _, ok := davCredentials(httptest.NewRequest("PROPFIND", "/drive/dav/", nil)); ok {
+		t.Fatal("no credentials must not be ok")
+	}
+}
+
+func TestDavFileBufferedWriteSeek(t *testing.T) {
+	f := &davFile{entry: davEntry{name: "a.txt"}, writable: true, loaded: true}
+	if _, err := f.Write([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("WORLD!"))
+	if got := string(f.buf); got != "hello WORLD!" {
+		t.Fatalf("buf = %q", got)
+	}
+	f.Seek(0, io.SeekStart)
+	out, _ := io.ReadAll(f)
+	if string(out) != "hello WORLD!" {
+		t.Fatalf("read = %q", out)
+	}
+	if fi, _ := f.Stat(); fi.Size() != 12 || fi.IsDir() {
+		t.Fatalf("stat = %d dir=%v", fi.Size(), fi.IsDir())
+	}
+}
+
+func TestDavFileWriteRejectsOversizedBuffer(t *testing.T) {
+	t.Setenv("DRIVE_WEBDAV_MAX_FILE_BYTES", "16")
+	f := &davFile{fs: &davFS{}, entry: davEntry{name: "a.bin"}, writable: true, isNew: true, dirty: true, loaded: true}
+	// Seek très loin puis Write : refusé avant allocation, rien à committer au Close.
+	f.Seek(1<<40, io.SeekStart)
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Fatal("write past max size accepted")
+	}
+	var qe *quotaExceededError
+	if !errors.As(f.fs.quotaErr, &qe) || qe.Requested <= qe.Max {
+		t.Fatalf("quotaErr = %v, want 413-style error", f.fs.quotaErr)
+	}
+	if f.buf != nil || f.dirty {
+		t.Fatal("buffer kept after rejected write")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &davFile{fs: &davFS{}, entry: davEntry{name: "b.bin", size: 4}, writable: true, loaded: true,
+		buf: []byte("abcd"), quotas: []davQuota{{scope: "user", used: 95, max: 100}}}
+	g.Seek(0, io.SeekEnd)
+	if _, err := g.Write([]byte("12345")); err != nil {
+		t.Fatalf("write within quota: %v", err)
+	}
+	if _, err := g.Write([]byte("6")); err == nil {
+		t.Fatal("write past quota accepted")
+	}
+	if !errors.As(g.fs.quotaErr, &qe) || qe.Scope != "user" || qe.Requested > qe.Max {
+		t.Fatalf("quotaErr = %v, want 507-style user error", g.fs.quotaErr)
+	}
+}
+
+func TestDavReaddirPagination(t *testing.T) {
+	f := &davFile{entry: davEntry{isFolder: true}}
+	f.children = append(f.children, davFileInfo{davEntry{name: "a"}}, davFileInfo{davEntry{name: "b"}}, davFileInfo{davEntry{name: "c"}})
+	first, err := f.Readdir(2)
+	if err != nil || len(first) != 2 {
+		t.Fatalf("first page: %d %v", len(first), err)
+	}
+	second, _ := f.Readdir(2)
+	if len(second) != 1 || second[0].Name() != "c" {
+		t.Fatalf("second page: %v", second)
+	}
+	if _, err := f.Readdir(2); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestWebDAVRequiresAppPassword(t *testing.T) {
+	r := setupRouter(nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("PROPFIND", "/drive/dav/", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("PROPFIND without credentials: got %d, WWW-Authenticate=%q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	// X-User-ID seul ne suffit pas : le montage exige un mot de passe d'application.
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("PROPFIND", "/drive/dav/", nil)
+	req.Header.Set("X-User-ID", "1")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("PROPFIND with X-User-ID only: got %d", w.Code)
+	}
+}
+
+// lockedDavDB simule un Drive contenant /secret.jpg (id 5) dans le dossier verrouillé :
+// toute requête qui ne filtre pas photo_locked_at le voit (et le modifierait).
+func lockedDavDB() (*davFS, *fakeDB) {
+	db, f := newFakeDB(func(q string, args []driver.Value) fakeResult {
+		locked := !strings.Contains(q, "photo_locked_at IS NULL")
+		switch {
+		case strings.Contains(q, "FROM storage_quotas"):
+			return fakeRow(int64(-1), int64(-1))
+		case strings.Contains(q, "pg_advisory_xact_lock"):
+			return fakeResult{}
+		case strings.Contains(q, "INSERT INTO drive_nodes"):
+			// Index unique (parent_id, name) : le nœud verrouillé occupe le nom.
+			return fakeResult{err: &pq.Error{Code: "23505"}}
+		case strings.HasPrefix(strings.TrimSpace(q), "UPDATE drive_nodes"):
+			if locked {
+				return fakeResult{affected: 1}
+			}
+			return fakeResult{}
+		case strings.Contains(q, "SELECT "+davNodeColumns) && len(args) > 0 && args[len(args)-1] == "secret.jpg":
+			if locked {
+				return fakeRow(int64(5), "secret.jpg", false, int64(3), time.Now(), "image/jpeg")
+			}
+			return fakeResult{cols: []string{"id", "name", "is_folder", "size", "updated_at", "mime_type"}}
+		}
+		return fakeResult{cols: []string{"c"}}
+	})
+	return &davFS{h: &Handler{db: db}, uid: 1, tid: 1}, f
+}
+
+func TestDavLockedPhotoHidden(t *testing.T) {
+	fsys, _ := lockedDavDB()
+	if _, err := fsys.Stat(context.Background(), "/secret.jpg"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat locked photo: %v, want ErrNotExist", err)
+	}
+}
+
+func TestDavPutDoesNotOverwriteLockedPhoto(t *testing.T) {
+	fsys, db := lockedDavDB()
+	ctx := context.Background()
+	f, err := fsys.OpenFile(ctx, "/secret.jpg", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("PUT over locked photo: %v, want ErrExist", err)
+	}
+	if db.ran("UPDATE drive_nodes SET content") {
+		t.Fatal("PUT must not rewrite the locked photo's content")
+	}
+}
+
+func TestDavDeleteAndMoveLockedPhoto(t *testing.T) {
+	fsys, db := lockedDavDB()
+	ctx := context.Background()
+	if err := fsys.RemoveAll(ctx, "/secret.jpg"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("DELETE locked photo: %v, want ErrNotExist", err)
+	}
+	if err := fsys.Rename(ctx, "/secret.jpg", "/public.jpg"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("MOVE locked photo: %v, want ErrNotExist", err)
+	}
+	if db.ran("UPDATE drive_nodes") {
+		t.Fatal("DELETE/MOVE must not touch the locked photo")
+	}
+}
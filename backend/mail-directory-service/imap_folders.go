@@ -342,6 +342,7 @@ func (h *Handler) syncImapMailboxMessages(ctx context.Context, accountID int, ic
 		imap.FetchEnvelope,
 		imap.FetchUid,
 		imap.FetchInternalDate,
+		imap.FetchRFC822Size,
 		imapDateHeaderSection.FetchItem(),
 	}
 	go func() {
@@ -385,6 +386,7 @@ func (h *Handler) syncImapMailboxMessages(ctx context.Context, accountID int, ic
 						to_addrs = $5,
 						subject = $6,
 						date_at = COALESCE($7, date_at),
+						size_bytes = CASE WHEN $10 > 0 THEN $10 ELSE size_bytes END,
 						in_reply_to = CASE WHEN $8 <> '' THEN $8 ELSE in_reply_to END,
 						thread_key = CASE
 							WHEN thread_key <> '' THEN thread_key
@@ -392,7 +394,7 @@ func (h *Handler) syncImapMailboxMessages(ctx context.Context, accountID int, ic
 							ELSE thread_key
 						END
 					WHERE id = $1
-				`, keepID, dbFolder, msg.Uid, fromAddr, toAddrs, subject, dateAt, irt, tk)
+				`, keepID, dbFolder, msg.Uid, fromAddr, toAddrs, subject, dateAt, irt, tk, int64(msg.Size))
 				if upErr == nil {
 					continue
 				}
@@ -401,10 +403,11 @@ func (h *Handler) syncImapMailboxMessages(ctx context.Context, accountID int, ic
 		}
 		var xmax int64
 		upsertErr := h.dbex(ctx).QueryRow(`
-			INSERT INTO mail_messages (account_id, folder, message_uid, from_addr, to_addrs, subject, date_at, internet_msg_id, in_reply_to, thread_key)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			INSERT INTO mail_messages (account_id, folder, message_uid, from_addr, to_addrs, subject, date_at, internet_msg_id, in_reply_to, thread_key, size_bytes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 			ON CONFLICT (account_id, folder, message_uid) DO UPDATE SET
 				from_addr = EXCLUDED.from_addr,
+				size_bytes = CASE WHEN EXCLUDED.size_bytes > 0 THEN EXCLUDED.size_bytes ELSE mail_messages.size_bytes END,
 				to_addrs = EXCLUDED.to_addrs,
 				subject = EXCLUDED.subject,
 				date_at = COALESCE(EXCLUDED.date_at, mail_messages.date_at),
@@ -416,7 +419,7 @@ func (h *Handler) syncImapMailboxMessages(ctx context.Context, accountID int, ic
 					ELSE mail_messages.thread_key
 				END
 			RETURNING xmax
-		`, accountID, dbFolder, msg.Uid, fromAddr, toAddrs, subject, dateAt, mid, irt, tk).Scan(&xmax)
+		`, accountID, dbFolder, msg.Uid, fromAddr, toAddrs, subject, dateAt, mid, irt, tk, int64(msg.Size)).Scan(&xmax)
 		if upsertErr != nil {
 			log.Printf("[mail] upsert message: %v", upsertErr)
 			continue
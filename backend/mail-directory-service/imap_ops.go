@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"errors"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/emersion/go-imap"
@@ -113,6 +115,61 @@ func imapMailboxContainsUID(ic *client.Client, mailbox string, uid uint32) (bool
 	return found, nil
 }
 
+// imapAppendToFolder dépose raw dans la première boîte candidate du dossier (rôle SPECIAL-USE
+// d'abord) puis retrouve son UID via SEARCH HEADER Message-Id. Avec replace, les autres copies
+// portant le même Message-ID sont supprimées (versions successives d'un brouillon).
+// uid vaut 0 si le serveur ne sait pas chercher sur l'en-tête : la sync le retrouvera.
+func (h *Handler) imapAppendToFolder(ctx context.Context, accountID int, ic *client.Client, dbFolder string, flags []string, date time.Time, raw []byte, messageID string, replace bool) (mailbox string, uid uint32, err error) {
+	var lastErr error
+	for _, mb := range h.imapCandidatesForAccountFolder(ctx, accountID, dbFolder) {
+		if err := ic.Append(mb, flags, date, bytes.NewBuffer(raw)); err != nil {
+			lastErr = err
+			continue
+		}
+		mailbox = mb
+		break
+	}
+	if mailbox == "" {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("aucune boîte pour le dossier %q", dbFolder)
+		}
+		return "", 0, fmt.Errorf("APPEND %s: %w", dbFolder, lastErr)
+	}
+	if _, err := ic.Select(mailbox, false); err != nil {
+		return mailbox, 0, fmt.Errorf("select %q: %w", mailbox, err)
+	}
+	crit := imap.NewSearchCriteria()
+	crit.Header.Add("Message-Id", messageID)
+	uids, err := ic.UidSearch(crit)
+	if err != nil {
+		return mailbox, 0, fmt.Errorf("recherche du message déposé: %w", err)
+	}
+	for _, u := range uids {
+		if u > uid {
+			uid = u
+		}
+	}
+	if !replace {
+		return mailbox, uid, nil
+	}
+	stale := new(imap.SeqSet)
+	for _, u := range uids {
+		if u != uid {
+			stale.AddNum(u)
+		}
+	}
+	if !stale.Empty() {
+		storeItem := imap.FormatFlagsOp(imap.AddFlags, true)
+		if err := ic.UidStore(stale, storeItem, []interface{}{imap.DeletedFlag}, nil); err != nil {
+			return mailbox, uid, fmt.Errorf("suppression de l'ancienne version: %w", err)
+		}
+		if err := ic.Expunge(nil); err != nil {
+			return mailbox, uid, fmt.Errorf("expunge IMAP: %w", err)
+		}
+	}
+	return mailbox, uid, nil
+}
+
 // imapResolveSourceMailbox trouve le nom de boîte IMAP où se trouve réellement le message (UID).
 func (h *Handler) imapResolveSourceMailbox(ctx context.Context, accountID int, ic *client.Client, dbFolder string, uid uint32) (string, error) {
 	candidates := h.imapCandidatesForAccountFolder(ctx, accountID, dbFolder)
@@ -591,6 +648,7 @@ func (h *Handler) imapMoveMessage(ctx context.Context, accountID, msgID int, des
 	if n == 0 {
 		return fmt.Errorf("message introuvable en base après IMAP")
 	}
+	h.trainSpamFromMove(ctx, accountID, msgID, curFolder, destFolder)
 	return nil
 }
 
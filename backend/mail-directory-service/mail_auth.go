@@ -0,0 +1,1086 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Authentification du courrier reçu (MAIL-AUTH-01) : signatures DKIM (RFC 6376, rsa-sha256 et
+// ed25519-sha256, RFC 8463), SPF (RFC 7208) de l'expéditeur d'enveloppe pour l'IP relevée dans
+// le premier Received public, alignement DMARC (RFC 7489) sur le domaine From. Les en-têtes
+// Authentication-Results (RFC 8601) sont lus aussi, mais seuls ceux de l'authserv-id du serveur
+// de réception (hôte « by » de ce Received, qui supprime les faux à son nom, §5) ou listés dans
+// MAIL_AUTH_TRUSTED_AUTHSERV_IDS sont retenus ; le SPF du fournisseur, qui a vu l'IP de
+// connexion, prime sur le nôtre.
+
+// mailAuthResolver — requêtes DNS nécessaires (net.DefaultResolver ; DNS figé en test).
+type mailAuthResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+var mailAuthDNS mailAuthResolver = net.DefaultResolver
+
+const (
+	mailAuthTimeout       = 8 * time.Second
+	dkimMaxSignatures     = 5
+	dkimMinRSABits        = 1024
+	spfMaxDNSLookups      = 10
+	spfMaxVoidLookups     = 2
+	spfMaxMXNames         = 10
+	authResultsMaxHeaders = 5
+)
+
+// mailAuthResult — verdicts stockés sur mail_messages (auth_details) et exposés au détail.
+type mailAuthResult struct {
+	SPF         string              `json:"spf"`
+	SPFDomain   string              `json:"spf_domain,omitempty"`
+	DKIM        string              `json:"dkim"`
+	DKIMDomains []string            `json:"dkim_domains,omitempty"`
+	DMARC       string              `json:"dmarc"`
+	DMARCPolicy string              `json:"dmarc_policy,omitempty"`
+	FromDomain  string              `json:"from_domain,omitempty"`
+	Trust       string              `json:"trust"`
+	Signatures  []dkimSignatureInfo `json:"signatures,omitempty"`
+	Upstream    []authResultsEntry  `json:"upstream,omitempty"`
+	CheckedAt   string              `json:"checked_at,omitempty"`
+}
+
+type dkimSignatureInfo struct {
+	Domain   string `json:"domain"`
+	Selector string `json:"selector"`
+	Result   string `json:"result"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// authResultsEntry — un résultat d'un en-tête Authentication-Results de confiance.
+type authResultsEntry struct {
+	AuthservID string            `json:"authserv_id"`
+	Method     string            `json:"method"`
+	Result     string            `json:"result"`
+	Props      map[string]string `json:"props,omitempty"`
+}
+
+// mailTrustLevel — badge UI : verified (DMARC pass), suspicious (DMARC fail ou aucune preuve
+// valide face à un échec), neutral sinon.
+func mailTrustLevel(r *mailAuthResult) string {
+	switch {
+	case r.DMARC == "pass":
+		return "verified"
+	case r.DMARC == "fail":
+		return "suspicious"
+	case r.DKIM != "pass" && (r.SPF == "fail" || r.SPF == "softfail" || r.DKIM == "fail"):
+		return "suspicious"
+	}
+	return "neutral"
+}
+
+// ---------- En-têtes bruts ----------
+
+type rawHeaderField struct {
+	Name string // nom tel qu'écrit
+	Raw  string // champ complet (lignes de continuation comprises), sans CRLF final
+}
+
+// splitRawMessage sépare en-têtes et corps en normalisant les fins de ligne en CRLF.
+func splitRawMessage(raw []byte) ([]rawHeaderField, []byte) {
+	norm := bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	norm = bytes.ReplaceAll(norm, []byte("\n"), []byte("\r\n"))
+	head, body := norm, []byte(nil)
+	if i := bytes.Index(norm, []byte("\r\n\r\n")); i >= 0 {
+		head, body = norm[:i+2], norm[i+4:]
+	}
+	var fields []rawHeaderField
+	for _, line := range strings.SplitAfter(string(head), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].Raw += "\r\n" + strings.TrimSuffix(line, "\r\n")
+			continue
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			continue
+		}
+		fields = append(fields, rawHeaderField{Name: strings.TrimSpace(line[:colon]), Raw: line})
+	}
+	return fields, body
+}
+
+func (f rawHeaderField) value() string {
+	v := f.Raw[strings.IndexByte(f.Raw, ':')+1:]
+	return strings.TrimSpace(strings.NewReplacer("\r\n", "").Replace(v))
+}
+
+// ---------- DKIM ----------
+
+var (
+	wspRunRe     = regexp.MustCompile(`[ \t]+`)
+	dkimBTagRe   = regexp.MustCompile(`((?:^|;)[ \t\r\n]*b[ \t\r\n]*=)[^;]*`)
+	foldingWSPRe = regexp.MustCompile(`[ \t\r\n]+`)
+)
+
+func dkimCanonHeader(f rawHeaderField, relaxed bool) string {
+	if !relaxed {
+		return f.Raw + "\r\n"
+	}
+	v := f.Raw[strings.IndexByte(f.Raw, ':')+1:]
+	v = strings.ReplaceAll(v, "\r\n", "")
+	v = strings.Trim(wspRunRe.ReplaceAllString(v, " "), " ")
+	return strings.ToLower(strings.TrimSpace(f.Name)) + ":" + v + "\r\n"
+}
+
+func dkimCanonBody(body []byte, relaxed bool) []byte {
+	if relaxed {
+		lines := strings.Split(string(body), "\r\n")
+		for i, l := range lines {
+			lines[i] = strings.TrimRight(wspRunRe.ReplaceAllString(l, " "), " ")
+		}
+		s := strings.TrimRight(strings.Join(lines, "\r\n"), "\r\n")
+		if s == "" {
+			return nil
+		}
+		return []byte(s + "\r\n")
+	}
+	s := string(body)
+	for strings.HasSuffix(s, "\r\n") {
+		s = strings.TrimSuffix(s, "\r\n")
+	}
+	return []byte(s + "\r\n")
+}
+
+// parseDKIMTags lit une tag-list (RFC 6376 §3.2) ; les espaces pliés sont retirés des valeurs.
+func parseDKIMTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.IndexByte(part, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("tag invalide %q", part)
+		}
+		name := strings.TrimSpace(part[:eq])
+		if _, dup := tags[name]; dup {
+			return nil, fmt.Errorf("tag %q en double", name)
+		}
+		tags[name] = strings.TrimSpace(foldingWSPRe.ReplaceAllString(part[eq+1:], " "))
+	}
+	return tags, nil
+}
+
+// dkimSelectHeaders — champs signés (h=), chaque nom pris du bas vers le haut (§5.4.2).
+func dkimSelectHeaders(fields []rawHeaderField, names []string, relaxed bool) string {
+	used := map[string]int{}
+	var b strings.Builder
+	for _, name := range names {
+		key := strings.ToLower(strings.TrimSpace(name))
+		skip := used[key]
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !strings.EqualFold(fields[i].Name, key) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			b.WriteString(dkimCanonHeader(fields[i], relaxed))
+			break
+		}
+		used[key]++
+	}
+	return b.String()
+}
+
+type dkimKey struct {
+	rsa     *rsa.PublicKey
+	ed25519 ed25519.PublicKey
+}
+
+func lookupDKIMKey(ctx context.Context, dns mailAuthResolver, selector, domain string) (*dkimKey, string, error) {
+	txts, err := dns.LookupTXT(ctx, selector+"._domainkey."+domain)
+	if err != nil {
+		if dnsNotFound(err) {
+			return nil, "permerror", fmt.Errorf("clé absente")
+		}
+		return nil, "temperror", err
+	}
+	for _, txt := range txts {
+		tags, err := parseDKIMTags(txt)
+		if err != nil {
+			continue
+		}
+		if v, ok := tags["v"]; ok && v != "DKIM1" {
+			continue
+		}
+		p := strings.ReplaceAll(tags["p"], " ", "")
+		if p == "" {
+			return nil, "fail", fmt.Errorf("clé révoquée")
+		}
+		der, err := base64.StdEncoding.DecodeString(p)
+		if err != nil {
+			return nil, "permerror", fmt.Errorf("clé illisible")
+		}
+		switch strings.ToLower(tags["k"]) {
+		case "", "rsa":
+			pub, err := x509.ParsePKIXPublicKey(der)
+			if err != nil {
+				if k, err2 := x509.ParsePKCS1PublicKey(der); err2 == nil {
+					pub = k
+				} else {
+					return nil, "permerror", fmt.Errorf("clé RSA illisible")
+				}
+			}
+			k, ok := pub.(*rsa.PublicKey)
+			if !ok {
+				return nil, "permerror", fmt.Errorf("clé non RSA")
+			}
+			if k.N.BitLen() < dkimMinRSABits {
+				return nil, "permerror", fmt.Errorf("clé RSA trop courte")
+			}
+			return &dkimKey{rsa: k}, "", nil
+		case "ed25519":
+			if len(der) != ed25519.PublicKeySize {
+				return nil, "permerror", fmt.Errorf("clé ed25519 invalide")
+			}
+			return &dkimKey{ed25519: ed25519.PublicKey(der)}, "", nil
+		default:
+			return nil, "permerror", fmt.Errorf("type de clé inconnu")
+		}
+	}
+	return nil, "permerror", fmt.Errorf("aucun enregistrement DKIM1")
+}
+
+// verifyDKIMSignature vérifie un champ DKIM-Signature ; renvoie pass, fail, permerror ou temperror.
+func verifyDKIMSignature(ctx context.Context, dns mailAuthResolver, fields []rawHeaderField, body []byte, sig rawHeaderField, now time.Time) dkimSignatureInfo {
+	info := dkimSignatureInfo{Result: "permerror"}
+	tags, err := parseDKIMTags(sig.value())
+	if err != nil {
+		info.Reason = err.Error()
+		return info
+	}
+	info.Domain, info.Selector = strings.ToLower(tags["d"]), tags["s"]
+	for _, t := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if tags[t] == "" {
+			info.Reason = "tag " + t + " manquant"
+			return info
+		}
+	}
+	if tags["v"] != "1" {
+		info.Reason = "version non prise en charge"
+		return info
+	}
+	algo := strings.ToLower(tags["a"])
+	if algo != "rsa-sha256" && algo != "ed25519-sha256" {
+		// rsa-sha1 n'est plus acceptable (RFC 8301).
+		info.Reason = "algorithme " + algo + " refusé"
+		return info
+	}
+	signed := strings.Split(tags["h"], ":")
+	hasFrom := false
+	for i := range signed {
+		signed[i] = strings.TrimSpace(signed[i])
+		hasFrom = hasFrom || strings.EqualFold(signed[i], "from")
+	}
+	if !hasFrom {
+		info.Reason = "From non signé"
+		return info
+	}
+	if i := tags["i"]; i != "" {
+		at := strings.LastIndexByte(i, '@')
+		id := strings.ToLower(i[at+1:])
+		if at < 0 || (id != info.Domain && !strings.HasSuffix(id, "."+info.Domain)) {
+			info.Reason = "i= hors du domaine d="
+			return info
+		}
+	}
+	if x := tags["x"]; x != "" {
+		if exp, err := strconv.ParseInt(x, 10, 64); err == nil && now.Unix() > exp {
+			info.Result, info.Reason = "fail", "signature expirée"
+			return info
+		}
+	}
+	headerRelaxed, bodyRelaxed := false, false
+	if c := strings.ToLower(tags["c"]); c != "" {
+		hc, bc, _ := strings.Cut(c, "/")
+		headerRelaxed, bodyRelaxed = hc == "relaxed", bc == "relaxed"
+	}
+	canonBody := dkimCanonBody(body, bodyRelaxed)
+	if l := tags["l"]; l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 || n > len(canonBody) {
+			info.Reason = "l= invalide"
+			return info
+		}
+		canonBody = canonBody[:n]
+	}
+	bh, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(tags["bh"], " ", ""))
+	if err != nil {
+		info.Reason = "bh= illisible"
+		return info
+	}
+	if sum := sha256.Sum256(canonBody); !bytes.Equal(sum[:], bh) {
+		info.Result, info.Reason = "fail", "empreinte du corps différente"
+		return info
+	}
+	sigBytes, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(tags["b"], " ", ""))
+	if err != nil {
+		info.Reason = "b= illisible"
+		return info
+	}
+	key, res, err := lookupDKIMKey(ctx, dns, tags["s"], info.Domain)
+	if err != nil {
+		info.Result, info.Reason = res, err.Error()
+		return info
+	}
+	colon := strings.IndexByte(sig.Raw, ':')
+	stripped := rawHeaderField{Name: sig.Name, Raw: sig.Raw[:colon+1] + dkimBTagRe.ReplaceAllString(sig.Raw[colon+1:], "$1")}
+	data := dkimSelectHeaders(fields, signed, headerRelaxed) + strings.TrimSuffix(dkimCanonHeader(stripped, headerRelaxed), "\r\n")
+	digest := sha256.Sum256([]byte(data))
+	ok := false
+	switch {
+	case algo == "rsa-sha256" && key.rsa != nil:
+		ok = rsa.VerifyPKCS1v15(key.rsa, crypto.SHA256, digest[:], sigBytes) == nil
+	case algo == "ed25519-sha256" && key.ed25519 != nil:
+		ok = ed25519.Verify(key.ed25519, digest[:], sigBytes)
+	default:
+		info.Reason = "clé incompatible avec a="
+		return info
+	}
+	if !ok {
+		info.Result, info.Reason = "fail", "signature invalide"
+		return info
+	}
+	info.Result = "pass"
+	return info
+}
+
+// ---------- SPF ----------
+
+type spfEvaluator struct {
+	ctx     context.Context
+	dns     mailAuthResolver
+	ip      net.IP
+	sender  string // local@domaine
+	helo    string
+	lookups int
+	voids   int
+}
+
+var errSPFPerm = errors.New("spf permerror")
+var errSPFTemp = errors.New("spf temperror")
+
+func dnsNotFound(err error) bool {
+	var de *net.DNSError
+	return errors.As(err, &de) && de.IsNotFound
+}
+
+func (e *spfEvaluator) countLookup() error {
+	e.lookups++
+	if e.lookups > spfMaxDNSLookups {
+		return errSPFPerm
+	}
+	return nil
+}
+
+func (e *spfEvaluator) dnsErr(err error) error {
+	if dnsNotFound(err) {
+		e.voids++
+		if e.voids > spfMaxVoidLookups {
+			return errSPFPerm
+		}
+		return nil
+	}
+	return errSPFTemp
+}
+
+func spfRecord(txts []string) (string, error) {
+	var found []string
+	for _, t := range txts {
+		if l := strings.ToLower(t); l == "v=spf1" || strings.HasPrefix(l, "v=spf1 ") {
+			found = append(found, t)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return found[0], nil
+	}
+	return "", errSPFPerm
+}
+
+// checkHost — fonction check_host() de RFC 7208 §4.
+func (e *spfEvaluator) checkHost(domain string, depth int) string {
+	if depth > spfMaxDNSLookups {
+		return "permerror"
+	}
+	txts, err := e.dns.LookupTXT(e.ctx, domain)
+	if err != nil {
+		if dnsNotFound(err) {
+			return "none"
+		}
+		return "temperror"
+	}
+	rec, err := spfRecord(txts)
+	if err != nil {
+		return "permerror"
+	}
+	if rec == "" {
+		return "none"
+	}
+	var redirect string
+	for _, term := range strings.Fields(rec)[1:] {
+		lower := strings.ToLower(term)
+		if strings.HasPrefix(lower, "redirect=") {
+			redirect = term[len("redirect="):]
+			continue
+		}
+		if strings.HasPrefix(lower, "exp=") {
+			continue
+		}
+		if eq := strings.IndexByte(term, '='); eq > 0 && !strings.ContainsAny(term[:eq], ":/") {
+			continue // modificateur inconnu : ignoré (§6)
+		}
+		qual := "pass"
+		switch term[0] {
+		case '+':
+			term = term[1:]
+		case '-':
+			qual, term = "fail", term[1:]
+		case '~':
+			qual, term = "softfail", term[1:]
+		case '?':
+			qual, term = "neutral", term[1:]
+		}
+		match, err := e.mechanism(term, domain, depth)
+		switch {
+		case errors.Is(err, errSPFTemp):
+			return "temperror"
+		case err != nil:
+			return "permerror"
+		case match:
+			return qual
+		}
+	}
+	if redirect != "" {
+		if err := e.countLookup(); err != nil {
+			return "permerror"
+		}
+		target, err := e.expand(redirect, domain)
+		if err != nil {
+			return "permerror"
+		}
+		if res := e.checkHost(target, depth+1); res != "none" {
+			return res
+		}
+		return "permerror"
+	}
+	return "neutral"
+}
+
+func (e *spfEvaluator) mechanism(term, domain string, depth int) (bool, error) {
+	name, arg, hasArg := strings.Cut(term, ":")
+	if !hasArg {
+		if i := strings.IndexByte(term, '/'); i >= 0 {
+			name, arg = term[:i], term[i:]
+		}
+	}
+	switch strings.ToLower(name) {
+	case "all":
+		return true, nil
+	case "include":
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := e.expand(arg, domain)
+		if err != nil || target == "" {
+			return false, errSPFPerm
+		}
+		switch e.checkHost(target, depth+1) {
+		case "pass":
+			return true, nil
+		case "temperror":
+			return false, errSPFTemp
+		case "permerror", "none":
+			return false, errSPFPerm
+		}
+		return false, nil
+	case "a", "mx":
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		spec, c4, c6, err := splitSPFCIDR(arg)
+		if err != nil {
+			return false, err
+		}
+		target := domain
+		if spec != "" {
+			if target, err = e.expand(spec, domain); err != nil {
+				return false, err
+			}
+		}
+		hosts := []string{target}
+		if strings.EqualFold(name, "mx") {
+			mxs, err := e.dns.LookupMX(e.ctx, target)
+			if err != nil {
+				return false, e.dnsErr(err)
+			}
+			if len(mxs) > spfMaxMXNames {
+				return false, errSPFPerm
+			}
+			hosts = hosts[:0]
+			for _, mx := range mxs {
+				hosts = append(hosts, strings.TrimSuffix(mx.Host, "."))
+			}
+		}
+		for _, host := range hosts {
+			addrs, err := e.dns.LookupIPAddr(e.ctx, host)
+			if err != nil {
+				if err := e.dnsErr(err); err != nil {
+					return false, err
+				}
+				continue
+			}
+			for _, a := range addrs {
+				if spfIPMatch(e.ip, a.IP, c4, c6) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	case "ip4", "ip6":
+		addr, bits, hasBits := strings.Cut(arg, "/")
+		ip := net.ParseIP(addr)
+		if ip == nil || (strings.EqualFold(name, "ip4") != (ip.To4() != nil)) {
+			return false, errSPFPerm
+		}
+		size := 32
+		if ip.To4() == nil {
+			size = 128
+		}
+		n := size
+		if hasBits {
+			var err error
+			if n, err = strconv.Atoi(bits); err != nil || n < 0 || n > size {
+				return false, errSPFPerm
+			}
+		}
+		c4, c6 := n, n
+		return spfIPMatch(e.ip, ip, c4, c6), nil
+	case "exists":
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := e.expand(arg, domain)
+		if err != nil {
+			return false, err
+		}
+		addrs, err := e.dns.LookupIPAddr(e.ctx, target)
+		if err != nil {
+			return false, e.dnsErr(err)
+		}
+		for _, a := range addrs {
+			if a.IP.To4() != nil {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "ptr":
+		// Déconseillé (§5.5) : compté mais jamais considéré comme correspondant.
+		return false, e.countLookup()
+	}
+	return false, errSPFPerm
+}
+
+func splitSPFCIDR(arg string) (spec string, c4, c6 int, err error) {
+	c4, c6 = 32, 128
+	if i := strings.Index(arg, "//"); i >= 0 {
+		if c6, err = strconv.Atoi(arg[i+2:]); err != nil || c6 < 0 || c6 > 128 {
+			return "", 0, 0, errSPFPerm
+		}
+		arg = arg[:i]
+	}
+	if i := strings.LastIndexByte(arg, '/'); i >= 0 {
+		if c4, err = strconv.Atoi(arg[i+1:]); err != nil || c4 < 0 || c4 > 32 {
+			return "", 0, 0, errSPFPerm
+		}
+		arg = arg[:i]
+	}
+	return arg, c4, c6, nil
+}
+
+func spfIPMatch(client, candidate net.IP, c4, c6 int) bool {
+	if v4 := client.To4(); v4 != nil {
+		cand := candidate.To4()
+		return cand != nil && v4.Mask(net.CIDRMask(c4, 32)).Equal(cand.Mask(net.CIDRMask(c4, 32)))
+	}
+	if candidate.To4() != nil {
+		return false
+	}
+	return client.Mask(net.CIDRMask(c6, 128)).Equal(candidate.To16().Mask(net.CIDRMask(c6, 128)))
+}
+
+var spfMacroRe = regexp.MustCompile(`%\{([slodiphcrtvSLODIPHCRTV])([0-9]*)(r?)([.\-+,/_=]*)\}|%%|%_|%-`)
+
+// expand applique les macros SPF (§7) : s l o d i h v p, transformations chiffre / r / délimiteurs.
+func (e *spfEvaluator) expand(spec, domain string) (string, error) {
+	var failed bool
+	local, senderDomain, _ := strings.Cut(e.sender, "@")
+	out := spfMacroRe.ReplaceAllStringFunc(spec, func(m string) string {
+		switch m {
+		case "%%":
+			return "%"
+		case "%_":
+			return " "
+		case "%-":
+			return "%20"
+		}
+		sub := spfMacroRe.FindStringSubmatch(m)
+		var v string
+		switch strings.ToLower(sub[1]) {
+		case "s":
+			v = e.sender
+		case "l":
+			v = local
+		case "o":
+			v = senderDomain
+		case "d":
+			v = domain
+		case "h":
+			v = e.helo
+		case "p":
+			v = "unknown"
+		case "v":
+			if e.ip.To4() != nil {
+				v = "in-addr"
+			} else {
+				v = "ip6"
+			}
+		case "i":
+			if v4 := e.ip.To4(); v4 != nil {
+				v = v4.String()
+			} else {
+				hexd := fmt.Sprintf("%x", []byte(e.ip.To16()))
+				v = strings.Join(strings.Split(hexd, ""), ".")
+			}
+		default:
+			failed = true
+			return ""
+		}
+		delims := sub[4]
+		if delims == "" {
+			delims = "."
+		}
+		parts := strings.FieldsFunc(v, func(r rune) bool { return strings.ContainsRune(delims, r) })
+		if sub[3] == "r" {
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+			}
+		}
+		if sub[2] != "" {
+			n, _ := strconv.Atoi(sub[2])
+			if n == 0 {
+				failed = true
+				return ""
+			}
+			if n < len(parts) {
+				parts = parts[len(parts)-n:]
+			}
+		}
+		return strings.Join(parts, ".")
+	})
+	if failed || strings.Contains(out, "%") {
+		return "", errSPFPerm
+	}
+	return strings.TrimSuffix(out, "."), nil
+}
+
+// checkSPF évalue SPF pour l'expéditeur d'enveloppe (ou postmaster@HELO si nul).
+func checkSPF(ctx context.Context, dns mailAuthResolver, ip net.IP, sender, helo string) (string, string) {
+	if ip == nil {
+		return "none", ""
+	}
+	if !strings.Contains(sender, "@") {
+		if helo == "" {
+			return "none", ""
+		}
+		sender = "postmaster@" + helo
+	}
+	domain := strings.ToLower(sender[strings.LastIndexByte(sender, '@')+1:])
+	e := &spfEvaluator{ctx: ctx, dns: dns, ip: ip, sender: sender, helo: helo}
+	return e.checkHost(domain, 0), domain
+}
+
+var receivedFromRe = regexp.MustCompile(`(?is)^\s*from\s+(\S+)(.*?)(?:\bby\b|;|$)`)
+var receivedByRe = regexp.MustCompile(`(?is)\bby\s+([A-Za-z0-9.\-]+)`)
+var receivedIPRe = regexp.MustCompile(`\[(?:IPv6:)?([0-9A-Fa-f:.]+)\]`)
+
+func publicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	if v4 := ip.To4(); v4 != nil && v4[0] == 100 && v4[1]&0xc0 == 64 {
+		return false // CGNAT 100.64.0.0/10
+	}
+	return true
+}
+
+// connectingPeer — IP publique, HELO et hôte de réception (« by ») du premier Received (du haut)
+// qui porte une IP publique.
+func connectingPeer(fields []rawHeaderField) (ip net.IP, helo, by string) {
+	for _, f := range fields {
+		if !strings.EqualFold(f.Name, "Received") {
+			continue
+		}
+		m := receivedFromRe.FindStringSubmatch(f.value())
+		if m == nil {
+			continue
+		}
+		for _, ipm := range receivedIPRe.FindAllStringSubmatch(m[2], -1) {
+			if ip := net.ParseIP(ipm[1]); publicIP(ip) {
+				if bm := receivedByRe.FindStringSubmatch(f.value()); bm != nil {
+					by = strings.ToLower(strings.TrimSuffix(bm[1], "."))
+				}
+				return ip, strings.Trim(strings.ToLower(m[1]), "[]"), by
+			}
+		}
+	}
+	return nil, "", ""
+}
+
+// ---------- Authentication-Results ----------
+
+func stripRFC5322Comments(s string) string {
+	var b strings.Builder
+	depth := 0
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"' && depth == 0:
+			quoted = !quoted
+			b.WriteRune(r)
+		case r == '(' && !quoted:
+			depth++
+		case r == ')' && !quoted && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// parseAuthenticationResults lit un en-tête Authentication-Results (RFC 8601 §2.2).
+func parseAuthenticationResults(v string) []authResultsEntry {
+	parts := strings.Split(stripRFC5322Comments(v), ";")
+	id := strings.Fields(parts[0])
+	if len(id) == 0 || strings.Contains(id[0], "=") {
+		// sans authserv-id (certains fournisseurs) : illisible pour la confiance
+		return nil
+	}
+	authserv := strings.ToLower(id[0])
+	var out []authResultsEntry
+	for _, p := range parts[1:] {
+		words := strings.Fields(p)
+		if len(words) == 0 || strings.EqualFold(words[0], "none") {
+			continue
+		}
+		method, result, ok := strings.Cut(words[0], "=")
+		if !ok {
+			continue
+		}
+		method, _, _ = strings.Cut(method, "/")
+		e := authResultsEntry{AuthservID: authserv, Method: strings.ToLower(method), Result: strings.ToLower(strings.Trim(result, `"`))}
+		for _, w := range words[1:] {
+			k, val, ok := strings.Cut(w, "=")
+			if !ok {
+				continue
+			}
+			if e.Props == nil {
+				e.Props = map[string]string{}
+			}
+			e.Props[strings.ToLower(k)] = strings.Trim(val, `"`)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func trustedAuthservIDs(receiver string) map[string]bool {
+	out := map[string]bool{}
+	if receiver != "" {
+		out[receiver] = true
+	}
+	for _, id := range strings.Split(os.Getenv("MAIL_AUTH_TRUSTED_AUTHSERV_IDS"), ",") {
+		if id = strings.ToLower(strings.TrimSpace(id)); id != "" {
+			out[id] = true
+		}
+	}
+	return out
+}
+
+// trustedAuthResults — résultats des authserv-id de confiance ; les autres en-têtes peuvent
+// venir de l'expéditeur.
+func trustedAuthResults(fields []rawHeaderField, trusted map[string]bool) []authResultsEntry {
+	var out []authResultsEntry
+	n := 0
+	for _, f := range fields {
+		if !strings.EqualFold(f.Name, "Authentication-Results") || n >= authResultsMaxHeaders {
+			continue
+		}
+		entries := parseAuthenticationResults(f.value())
+		if len(entries) == 0 || !trusted[entries[0].AuthservID] {
+			continue
+		}
+		n++
+		out = append(out, entries...)
+	}
+	return out
+}
+
+func upstreamResult(entries []authResultsEntry, method string) (authResultsEntry, bool) {
+	for _, e := range entries {
+		if e.Method == method {
+			return e, true
+		}
+	}
+	return authResultsEntry{}, false
+}
+
+// ---------- DMARC ----------
+
+func orgDomain(d string) string {
+	if org, err := publicsuffix.EffectiveTLDPlusOne(d); err == nil {
+		return org
+	}
+	return d
+}
+
+func dmarcAligned(id, from string, strict bool) bool {
+	id, from = strings.ToLower(id), strings.ToLower(from)
+	if strict {
+		return id == from
+	}
+	return id != "" && orgDomain(id) == orgDomain(from)
+}
+
+// lookupDMARC — enregistrement du domaine From, sinon du domaine organisationnel (§6.6.3).
+func lookupDMARC(ctx context.Context, dns mailAuthResolver, fromDomain string) (map[string]string, bool, string) {
+	query := func(d string) (map[string]string, string) {
+		txts, err := dns.LookupTXT(ctx, "_dmarc."+d)
+		if err != nil {
+			if dnsNotFound(err) {
+				return nil, "none"
+			}
+			return nil, "temperror"
+		}
+		var found []map[string]string
+		for _, t := range txts {
+			if !strings.HasPrefix(strings.TrimSpace(t), "v=DMARC1") {
+				continue
+			}
+			if tags, err := parseDKIMTags(t); err == nil {
+				found = append(found, tags)
+			}
+		}
+		if len(found) != 1 {
+			return nil, "none"
+		}
+		return found[0], ""
+	}
+	rec, res := query(fromDomain)
+	if rec != nil || res == "temperror" {
+		return rec, false, res
+	}
+	if org := orgDomain(fromDomain); org != fromDomain {
+		rec, res = query(org)
+		return rec, true, res
+	}
+	return nil, false, "none"
+}
+
+// headerFromDomain — domaine de l'unique adresse From ; ok = false si plusieurs From ou illisible.
+func headerFromDomain(fields []rawHeaderField) (string, bool) {
+	var froms []string
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, "From") {
+			froms = append(froms, f.value())
+		}
+	}
+	switch len(froms) {
+	case 0:
+		return "", true
+	case 1:
+	default:
+		return "", false
+	}
+	addr := ""
+	if list, err := mail.ParseAddressList(froms[0]); err == nil {
+		if len(list) != 1 {
+			return "", false
+		}
+		addr = strings.ToLower(list[0].Address)
+	} else {
+		addr = senderAddress(froms[0])
+	}
+	at := strings.LastIndexByte(addr, '@')
+	if at < 0 || at == len(addr)-1 {
+		return "", false
+	}
+	return addr[at+1:], true
+}
+
+// ---------- Vérification complète ----------
+
+// verifyMailAuth calcule SPF, DKIM et DMARC d'un message RFC 822 brut.
+func verifyMailAuth(ctx context.Context, dns mailAuthResolver, raw []byte, now time.Time) *mailAuthResult {
+	ctx, cancel := context.WithTimeout(ctx, mailAuthTimeout)
+	defer cancel()
+	fields, body := splitRawMessage(raw)
+	res := &mailAuthResult{SPF: "none", DKIM: "none", DMARC: "none", CheckedAt: now.UTC().Format(time.RFC3339)}
+	ip, helo, receiver := connectingPeer(fields)
+	res.Upstream = trustedAuthResults(fields, trustedAuthservIDs(receiver))
+
+	// DKIM
+	checked := 0
+	for _, f := range fields {
+		if !strings.EqualFold(f.Name, "DKIM-Signature") || checked >= dkimMaxSignatures {
+			continue
+		}
+		checked++
+		info := verifyDKIMSignature(ctx, dns, fields, body, f, now)
+		res.Signatures = append(res.Signatures, info)
+		if info.Result == "pass" {
+			res.DKIMDomains = append(res.DKIMDomains, info.Domain)
+		}
+	}
+	res.DKIM = aggregateDKIM(res.Signatures)
+	if res.DKIM != "pass" {
+		if up, ok := upstreamResult(res.Upstream, "dkim"); ok && up.Result == "pass" {
+			res.DKIM = "pass"
+			if d := strings.ToLower(up.Props["header.d"]); d != "" {
+				res.DKIMDomains = append(res.DKIMDomains, d)
+			} else if i := up.Props["header.i"]; strings.Contains(i, "@") {
+				res.DKIMDomains = append(res.DKIMDomains, strings.ToLower(i[strings.LastIndexByte(i, '@')+1:]))
+			}
+		}
+	}
+
+	// SPF : résultat du serveur de réception s'il existe, sinon IP du premier Received public.
+	if up, ok := upstreamResult(res.Upstream, "spf"); ok {
+		res.SPF = up.Result
+		mf := up.Props["smtp.mailfrom"]
+		if mf == "" {
+			mf = up.Props["smtp.helo"]
+		}
+		res.SPFDomain = strings.ToLower(mf[strings.LastIndexByte(mf, '@')+1:])
+	} else {
+		var returnPath string
+		for _, f := range fields {
+			if strings.EqualFold(f.Name, "Return-Path") {
+				returnPath = senderAddress(f.value())
+				break
+			}
+		}
+		res.SPF, res.SPFDomain = checkSPF(ctx, dns, ip, returnPath, helo)
+	}
+
+	// DMARC
+	fromDomain, ok := headerFromDomain(fields)
+	switch {
+	case !ok:
+		res.DMARC = "permerror"
+	case fromDomain == "":
+		// sans From, DMARC ne s'applique pas
+	default:
+		res.FromDomain = fromDomain
+		rec, fromOrg, status := lookupDMARC(ctx, dns, fromDomain)
+		switch {
+		case rec != nil:
+			res.DMARCPolicy = strings.ToLower(rec["p"])
+			if sp := strings.ToLower(rec["sp"]); fromOrg && sp != "" {
+				res.DMARCPolicy = sp
+			}
+			res.DMARC = "fail"
+			if res.SPF == "pass" && dmarcAligned(res.SPFDomain, fromDomain, strings.EqualFold(rec["aspf"], "s")) {
+				res.DMARC = "pass"
+			}
+			for _, d := range res.DKIMDomains {
+				if dmarcAligned(d, fromDomain, strings.EqualFold(rec["adkim"], "s")) {
+					res.DMARC = "pass"
+				}
+			}
+		default:
+			res.DMARC = status
+		}
+	}
+	if res.DMARC == "none" || res.DMARC == "temperror" {
+		if up, ok := upstreamResult(res.Upstream, "dmarc"); ok && (up.Result == "pass" || up.Result == "fail") {
+			res.DMARC = up.Result
+		}
+	}
+	res.Trust = mailTrustLevel(res)
+	return res
+}
+
+func aggregateDKIM(sigs []dkimSignatureInfo) string {
+	if len(sigs) == 0 {
+		return "none"
+	}
+	best := "permerror"
+	rank := map[string]int{"pass": 4, "fail": 3, "temperror": 2, "permerror": 1}
+	for _, s := range sigs {
+		if rank[s.Result] > rank[best] {
+			best = s.Result
+		}
+	}
+	return best
+}
+
+// authenticateParsed vérifie le message brut avant enregistrement (pas nos propres envois).
+func authenticateParsed(ctx context.Context, folder string, raw []byte, parsed *mailParsedResult) {
+	if folder == "sent" || folder == "drafts" {
+		return
+	}
+	parsed.Auth = verifyMailAuth(ctx, mailAuthDNS, raw, time.Now())
+}
+
+// saveMailAuth enregistre les verdicts d'un message (tx de storeParsedMail ou h.dbex).
+func saveMailAuth(ex interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, accountID, msgID int, r *mailAuthResult) error {
+	details, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = ex.Exec(`
+		UPDATE mail_messages SET auth_spf = $1, auth_dkim = $2, auth_dmarc = $3, auth_details = $4::jsonb, auth_checked_at = CURRENT_TIMESTAMP
+		WHERE id = $5 AND account_id = $6
+		AND account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
+	`, r.SPF, r.DKIM, r.DMARC, string(details), msgID, accountID)
+	return err
+}
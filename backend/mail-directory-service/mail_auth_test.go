@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAuthDNS — DNS figé pour les tests ; un nom absent répond NXDOMAIN.
+type fakeAuthDNS struct {
+	txt map[string][]string
+	ip  map[string][]string
+	mx  map[string][]string
+}
+
+func (f *fakeAuthDNS) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if v, ok := f.txt[strings.ToLower(strings.TrimSuffix(name, "."))]; ok {
+		return v, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f *fakeAuthDNS) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	v, ok := f.ip[strings.ToLower(strings.TrimSuffix(host, "."))]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	out := make([]net.IPAddr, 0, len(v))
+	for _, s := range v {
+		out = append(out, net.IPAddr{IP: net.ParseIP(s)})
+	}
+	return out, nil
+}
+
+func (f *fakeAuthDNS) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	v, ok := f.mx[strings.ToLower(strings.TrimSuffix(name, "."))]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	out := make([]*net.MX, 0, len(v))
+	for i, h := range v {
+		out = append(out, &net.MX{Host: h + ".", Pref: uint16(10 * (i + 1))})
+	}
+	return out, nil
+}
+
+// dkimSignForTest ajoute les signatures produites par dkimSign en tête du message.
+func dkimSignForTest(t *testing.T, raw, domain, selector string, key crypto.Signer) string {
+	t.Helper()
+	sig, err := dkimSign([]byte(raw), domain, selector, key, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig + raw
+}
+
+const authTestMessage = "Received: from mail.partenaire.fr (mail.partenaire.fr [203.0.113.5])\r\n" +
+	"\tby mx.cloudity.test with ESMTPS; Mon, 19 Oct 2026 09:00:00 +0000\r\n" +
+	"Return-Path: <bounce@partenaire.fr>\r\n" +
+	"From: Alice <alice@partenaire.fr>\r\n" +
+	"To: bob@cloudity.test\r\n" +
+	"Subject: Devis   signé\r\n" +
+	"Date: Mon, 19 Oct 2026 09:00:00 +0000\r\n" +
+	"\r\n" +
+	"Bonjour,  \r\nci-joint le devis.\r\n\r\n\r\n"
+
+func TestDKIMCanonicalization(t *testing.T) {
+	f := rawHeaderField{Name: "Subject", Raw: "SubJect : Devis \t signé\r\n\tet daté  "}
+	if got := dkimCanonHeader(f, true); got != "subject:Devis signé et daté\r\n" {
+		t.Errorf("en-tête relaxed: %q", got)
+	}
+	if got := dkimCanonHeader(f, false); got != f.Raw+"\r\n" {
+		t.Errorf("en-tête simple: %q", got)
+	}
+	body := []byte("a  b \t\r\nc\r\n\r\n\r\n")
+	if got := string(dkimCanonBody(body, true)); got != "a b\r\nc\r\n" {
+		t.Errorf("corps relaxed: %q", got)
+	}
+	if got := string(dkimCanonBody(body, false)); got != "a  b \t\r\nc\r\n" {
+		t.Errorf("corps simple: %q", got)
+	}
+	if got := string(dkimCanonBody(nil, false)); got != "\r\n" {
+		t.Errorf("corps simple vide: %q", got)
+	}
+	if got := dkimCanonBody(nil, true); len(got) != 0 {
+		t.Errorf("corps relaxed vide: %q", got)
+	}
+}
+
+func TestVerifyDKIMSignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	dns := &fakeAuthDNS{txt: map[string][]string{
+		"sel1._domainkey.partenaire.fr": {"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)},
+		"ed._domainkey.partenaire.fr":   {"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPub)},
+		"old._domainkey.partenaire.fr":  {"v=DKIM1; p="},
+	}}
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	verify := func(raw string) dkimSignatureInfo {
+		fields, body := splitRawMessage([]byte(raw))
+		for _, f := range fields {
+			if strings.EqualFold(f.Name, "DKIM-Signature") {
+				return verifyDKIMSignature(context.Background(), dns, fields, body, f, now)
+			}
+		}
+		t.Fatal("aucune signature")
+		return dkimSignatureInfo{}
+	}
+
+	signed := dkimSignForTest(t, authTestMessage, "partenaire.fr", "sel1", rsaKey)
+	if got := verify(signed); got.Result != "pass" || got.Domain != "partenaire.fr" {
+		t.Errorf("rsa: %+v", got)
+	}
+	// relaxed : espaces de fin de ligne et lignes vides finales ignorés
+	if got := verify(strings.Replace(signed, "Bonjour,  \r\n", "Bonjour,\n", 1) + "\r\n"); got.Result != "pass" {
+		t.Errorf("rsa relaxed: %+v", got)
+	}
+	if got := verify(dkimSignForTest(t, authTestMessage, "partenaire.fr", "ed", edKey)); got.Result != "pass" {
+		t.Errorf("ed25519: %+v", got)
+	}
+	if got := verify(strings.Replace(signed, "le devis", "le RIB", 1)); got.Result != "fail" {
+		t.Errorf("corps modifié: %+v", got)
+	}
+	if got := verify(strings.Replace(signed, "Alice <alice@", "Alice <pdg@", 1)); got.Result != "fail" {
+		t.Errorf("From modifié: %+v", got)
+	}
+	if got := verify(strings.Replace(signed, "s=sel1", "s=old", 1)); got.Result != "fail" {
+		t.Errorf("clé révoquée: %+v", got)
+	}
+	if got := verify(strings.Replace(signed, "s=sel1", "s=absent", 1)); got.Result != "permerror" {
+		t.Errorf("clé absente: %+v", got)
+	}
+	if got := verify(strings.Replace(signed, "a=rsa-sha256", "a=rsa-sha1", 1)); got.Result != "permerror" {
+		t.Errorf("sha1: %+v", got)
+	}
+}
+
+func TestCheckSPF(t *testing.T) {
+	dns := &fakeAuthDNS{
+		txt: map[string][]string{
+			"partenaire.fr":                {"v=spf1 ip4:198.51.100.0/24 include:_spf.relais.net mx -all", "google-site-verification=x"},
+			"_spf.relais.net":              {"v=spf1 a:out.relais.net ~all"},
+			"macro.fr":                     {"v=spf1 exists:%{l}.%{d}._spf.macro.fr -all"},
+			"alice.macro.fr._spf.macro.fr": {"v=spf1"},
+			"boucle.fr":                    {"v=spf1 include:boucle.fr -all"},
+			"double.fr":                    {"v=spf1 -all", "v=spf1 +all"},
+		},
+		ip: map[string][]string{
+			"out.relais.net":               {"192.0.2.10"},
+			"mx1.partenaire.fr":            {"203.0.113.5", "2001:db8::25"},
+			"alice.macro.fr._spf.macro.fr": {"127.0.0.2"},
+		},
+		mx: map[string][]string{"partenaire.fr": {"mx1.partenaire.fr"}},
+	}
+	cases := []struct {
+		ip, sender, want string
+	}{
+		{"198.51.100.77", "bounce@partenaire.fr", "pass"},
+		{"203.0.113.5", "bounce@partenaire.fr", "pass"},
+		{"2001:db8::25", "bounce@partenaire.fr", "pass"},
+		{"192.0.2.10", "bounce@partenaire.fr", "pass"},
+		{"192.0.2.99", "bounce@partenaire.fr", "fail"},
+		{"192.0.2.99", "alice@macro.fr", "pass"},
+		{"192.0.2.99", "bob@macro.fr", "fail"},
+		{"192.0.2.99", "x@inconnu.fr", "none"},
+		{"192.0.2.99", "x@boucle.fr", "permerror"},
+		{"192.0.2.99", "x@double.fr", "permerror"},
+	}
+	for _, tc := range cases {
+		got, domain := checkSPF(context.Background(), dns, net.ParseIP(tc.ip), tc.sender, "helo.test")
+		if got != tc.want {
+			t.Errorf("%s %s: got %s, want %s", tc.ip, tc.sender, got, tc.want)
+		}
+		if want := tc.sender[strings.IndexByte(tc.sender, '@')+1:]; domain != want {
+			t.Errorf("%s: domaine %q", tc.sender, domain)
+		}
+	}
+	if got, domain := checkSPF(context.Background(), dns, net.ParseIP("198.51.100.1"), "", "partenaire.fr"); got != "pass" || domain != "partenaire.fr" {
+		t.Errorf("expéditeur nul, HELO: %s %s", got, domain)
+	}
+}
+
+func TestConnectingPeer(t *testing.T) {
+	fields, _ := splitRawMessage([]byte("Received: from localhost (localhost [127.0.0.1]) by mx.cloudity.test; Mon, 19 Oct 2026\r\n" +
+		"Received: from mail.partenaire.fr (mail.partenaire.fr [203.0.113.5])\r\n\tby MX.Cloudity.Test. with ESMTPS id 1; Mon, 19 Oct 2026\r\n" +
+		"Received: from poste (poste [10.0.0.4]) by mail.partenaire.fr; Mon, 19 Oct 2026\r\n\r\n"))
+	ip, helo, by := connectingPeer(fields)
+	if ip.String() != "203.0.113.5" || helo != "mail.partenaire.fr" || by != "mx.cloudity.test" {
+		t.Errorf("got %v %q %q", ip, helo, by)
+	}
+}
+
+func TestParseAuthenticationResults(t *testing.T) {
+	got := parseAuthenticationResults(`mx.google.com; dkim=pass (2048-bit key) header.i=@partenaire.fr header.s=sel1;
+       spf=softfail (google.com: domain of transitioning x@y) smtp.mailfrom=bounce@partenaire.fr;
+       dmarc=pass (p=REJECT sp=NONE dis=NONE) header.from=partenaire.fr`)
+	if len(got) != 3 {
+		t.Fatalf("got %+v", got)
+	}
+	if got[0].AuthservID != "mx.google.com" || got[0].Method != "dkim" || got[0].Result != "pass" || got[0].Props["header.i"] != "@partenaire.fr" {
+		t.Errorf("dkim: %+v", got[0])
+	}
+	if got[1].Result != "softfail" || got[1].Props["smtp.mailfrom"] != "bounce@partenaire.fr" {
+		t.Errorf("spf: %+v", got[1])
+	}
+	if got[2].Props["header.from"] != "partenaire.fr" {
+		t.Errorf("dmarc: %+v", got[2])
+	}
+	if got := parseAuthenticationResults("spf=pass smtp.mailfrom=x@y"); got != nil {
+		t.Errorf("sans authserv-id: %+v", got)
+	}
+}
+
+func TestVerifyMailAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	dns := &fakeAuthDNS{txt: map[string][]string{
+		"sel1._domainkey.partenaire.fr": {"v=DKIM1; p=" + base64.StdEncoding.EncodeToString(der)},
+		"partenaire.fr":                 {"v=spf1 ip4:198.51.100.0/24 -all"},
+		"_dmarc.partenaire.fr":          {"v=DMARC1; p=reject; adkim=r"},
+		"relais.net":                    {"v=spf1 ip4:203.0.113.0/24 -all"},
+	}}
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	t.Setenv("MAIL_AUTH_TRUSTED_AUTHSERV_IDS", "")
+
+	t.Run("dkim aligné", func(t *testing.T) {
+		// SPF en échec (IP hors enregistrement) mais DKIM aligné : DMARC pass.
+		r := verifyMailAuth(context.Background(), dns, []byte(dkimSignForTest(t, authTestMessage, "partenaire.fr", "sel1", key)), now)
+		if r.DKIM != "pass" || r.SPF != "fail" || r.DMARC != "pass" || r.DMARCPolicy != "reject" || r.Trust != "verified" {
+			t.Errorf("got %+v", r)
+		}
+	})
+
+	t.Run("usurpation", func(t *testing.T) {
+		spoof := strings.Replace(authTestMessage, "Return-Path: <bounce@partenaire.fr>", "Return-Path: <x@relais.net>", 1)
+		r := verifyMailAuth(context.Background(), dns, []byte(spoof), now)
+		if r.SPF != "pass" || r.SPFDomain != "relais.net" || r.DKIM != "none" || r.DMARC != "fail" || r.Trust != "suspicious" {
+			t.Errorf("got %+v", r)
+		}
+	})
+
+	t.Run("sous-domaine", func(t *testing.T) {
+		msg := strings.Replace(authTestMessage, "alice@partenaire.fr", "alice@factures.partenaire.fr", 1)
+		msg = strings.Replace(msg, "[203.0.113.5]", "[198.51.100.9]", 1)
+		r := verifyMailAuth(context.Background(), dns, []byte(msg), now)
+		if r.SPF != "pass" || r.DMARC != "pass" || r.FromDomain != "factures.partenaire.fr" {
+			t.Errorf("alignement relâché: %+v", r)
+		}
+	})
+
+	t.Run("authentication-results", func(t *testing.T) {
+		ar := "Authentication-Results: mx.cloudity.test; spf=pass smtp.mailfrom=bounce@partenaire.fr; dkim=pass header.d=partenaire.fr\r\n"
+		forged := "Authentication-Results: mx.faux.test; dmarc=pass header.from=partenaire.fr\r\n"
+		r := verifyMailAuth(context.Background(), &fakeAuthDNS{}, []byte(ar+forged+authTestMessage), now)
+		if r.SPF != "pass" || r.DKIM != "pass" || len(r.Upstream) != 2 {
+			t.Errorf("résultats du serveur de réception ignorés: %+v", r)
+		}
+		if r.DMARC != "none" || r.Trust != "neutral" {
+			t.Errorf("en-tête forgé pris en compte: %+v", r)
+		}
+		t.Setenv("MAIL_AUTH_TRUSTED_AUTHSERV_IDS", "mx.faux.test")
+		if r := verifyMailAuth(context.Background(), &fakeAuthDNS{}, []byte(forged+authTestMessage), now); r.DMARC != "pass" {
+			t.Errorf("authserv-id configuré ignoré: %+v", r)
+		}
+	})
+
+	t.Run("from multiple", func(t *testing.T) {
+		msg := strings.Replace(authTestMessage, "To: ", "From: pdg@partenaire.fr\r\nTo: ", 1)
+		if r := verifyMailAuth(context.Background(), dns, []byte(msg), now); r.DMARC != "permerror" {
+			t.Errorf("got %+v", r)
+		}
+	})
+}
+
+func TestDMARCAligned(t *testing.T) {
+	cases := []struct {
+		id, from string
+		strict   bool
+		want     bool
+	}{
+		{"partenaire.fr", "partenaire.fr", true, true},
+		{"mail.partenaire.fr", "partenaire.fr", false, true},
+		{"mail.partenaire.fr", "partenaire.fr", true, false},
+		{"partenaire.co.uk", "autre.co.uk", false, false},
+		{"", "partenaire.fr", false, false},
+	}
+	for _, tc := range cases {
+		if got := dmarcAligned(tc.id, tc.from, tc.strict); got != tc.want {
+			t.Errorf("%s / %s strict=%v: got %v", tc.id, tc.from, tc.strict, got)
+		}
+	}
+}
@@ -0,0 +1,685 @@
+package main
+
+// mail_compose.go — construction MIME des messages sortants (POST /mail/me/send et /send/schedule).
+//
+// Arborescence produite selon le contenu :
+//
+//	multipart/mixed                 (si pièces jointes)
+//	└─ multipart/related            (si images inline référencées par cid: dans le HTML)
+//	   └─ multipart/alternative     (si texte ET HTML ; sinon la seule partie texte)
+//
+// Destinataires To/Cc/Bcc (Bcc dans l'enveloppe SMTP, jamais dans les en-têtes envoyés ; seule
+// la copie déposée dans Brouillons le conserve), pièces jointes envoyées directement (base64
+// JSON ou multipart/form-data) ou référencées par nœud Drive (lu avec les droits de
+// l'utilisateur épinglé), In-Reply-To / References en réponse.
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxOutgoingRecipients = 100
+	maxOutgoingAttachment = 64
+)
+
+// outgoingMaxBytes — taille cumulée maximale des pièces jointes (MAIL_SEND_MAX_ATTACHMENT_BYTES, 25 Mo).
+func outgoingMaxBytes() int64 {
+	if n, err := strconv.ParseInt(strings.TrimSpace(os.Getenv("MAIL_SEND_MAX_ATTACHMENT_BYTES")), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return 25 << 20
+}
+
+// recipientList accepte une chaîne (« a@x, b@y ») ou un tableau JSON d'adresses.
+type recipientList []string
+
+func (r *recipientList) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*r = recipientList{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return composeError("liste de destinataires invalide")
+	}
+	*r = many
+	return nil
+}
+
+func (r recipientList) String() string {
+	return strings.Join(r, ", ")
+}
+
+type composeAttachment struct {
+	Filename      string `json:"filename"`
+	ContentType   string `json:"content_type"`
+	ContentBase64 string `json:"content_base64"`
+	// ContentID : image inline référencée dans le HTML par cid:<content_id>.
+	ContentID string `json:"content_id"`
+	// DriveNodeID : fichier Drive joint (contenu lu côté serveur).
+	DriveNodeID int `json:"drive_node_id"`
+}
+
+// mailComposeRequest — corps commun de /me/send et /me/send/schedule.
+type mailComposeRequest struct {
+	AccountID int           `json:"account_id"`
+	Password  string        `json:"password"`
+	To        recipientList `json:"to"`
+	Cc        recipientList `json:"cc"`
+	Bcc       recipientList `json:"bcc"`
+	Subject   string        `json:"subject"`
+	// Body : texte brut (compatibilité) ; Text / HTML : corps multipart/alternative.
+	Body     string `json:"body"`
+	Text     string `json:"text"`
+	HTML     string `json:"html"`
+	SmtpHost string `json:"smtp_host"`
+	SmtpPort int    `json:"smtp_port"`
+	// Adresse d’affichage « De » : compte principal ou alias enregistré pour ce compte.
+	FromEmail string `json:"from_email"`
+	// Réponse : message Cloudity d'origine (en-têtes repris en base) ou en-têtes explicites.
+	ReplyToMessageID int                 `json:"reply_to_message_id"`
+	InReplyTo        string              `json:"in_reply_to"`
+	References       string              `json:"references"`
+	Attachments      []composeAttachment `json:"attachments"`
+	DriveNodeIDs     []int               `json:"drive_node_ids"`
+	ScheduledSendAt  string              `json:"scheduled_send_at"`
+	// Brouillon d'origine (mail_drafts.go) : supprimé après l'envoi ; DraftAttachmentIDs
+	// désigne ses pièces jointes déjà enregistrées à conserver.
+	DraftID            int   `json:"draft_id"`
+	DraftAttachmentIDs []int `json:"draft_attachment_ids"`
+}
+
+// bindMailCompose lit le JSON, ou un multipart/form-data : champ « payload » (JSON), fichiers
+// « attachments » (pièces jointes) et « inline » (images, Content-ID = nom du fichier).
+func bindMailCompose(c *gin.Context, req *mailComposeRequest) error {
+	ct, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if ct != "multipart/form-data" {
+		return c.ShouldBindJSON(req)
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return err
+	}
+	if p := form.Value["payload"]; len(p) > 0 {
+		if err := json.Unmarshal([]byte(p[0]), req); err != nil {
+			return err
+		}
+	}
+	for _, field := range []string{"attachments", "inline"} {
+		for _, fh := range form.File[field] {
+			f, err := fh.Open()
+			if err != nil {
+				return err
+			}
+			data, err := io.ReadAll(io.LimitReader(f, outgoingMaxBytes()+1))
+			f.Close()
+			if err != nil {
+				return err
+			}
+			a := composeAttachment{
+				Filename:      fh.Filename,
+				ContentType:   fh.Header.Get("Content-Type"),
+				ContentBase64: base64.StdEncoding.EncodeToString(data),
+			}
+			if field == "inline" {
+				a.ContentID = fh.Filename
+			}
+			req.Attachments = append(req.Attachments, a)
+		}
+	}
+	return nil
+}
+
+// parseRecipients normalise une liste (virgules ou points-virgules, « Nom <a@b> » accepté).
+func parseRecipients(list recipientList) ([]*mail.Address, error) {
+	var out []*mail.Address
+	for _, raw := range list {
+		raw = strings.TrimSpace(strings.ReplaceAll(raw, ";", ","))
+		if raw == "" {
+			continue
+		}
+		addrs, err := netmail.ParseAddressList(raw)
+		if err != nil {
+			return nil, composeErrorf("destinataire invalide : %s", raw)
+		}
+		for _, a := range addrs {
+			out = append(out, &mail.Address{Name: a.Name, Address: strings.ToLower(a.Address)})
+		}
+	}
+	return out, nil
+}
+
+// outgoingAttachment — pièce jointe résolue (ContentID non vide : image inline).
+// DraftAttachmentID : ligne mail_message_attachments du brouillon d'où elle provient.
+type outgoingAttachment struct {
+	Filename          string
+	ContentType       string
+	ContentID         string
+	Data              []byte
+	DraftAttachmentID int
+}
+
+// outgoingMessage — message prêt à sérialiser ; Bcc n'apparaît que dans envelopeRecipients.
+type outgoingMessage struct {
+	From        *mail.Address
+	To, Cc, Bcc []*mail.Address
+	Subject     string
+	Text, HTML  string
+	MessageID   string
+	Date        time.Time
+	InReplyTo   string
+	References  []string
+	Attachments []outgoingAttachment
+	// AutoSubmitted : valeur de l'en-tête Auto-Submitted (RFC 3834), vide pour un envoi manuel.
+	AutoSubmitted string
+	// XLoop : adresses des comptes déjà traversés par un transfert automatique (un en-tête X-Loop chacune).
+	XLoop []string
+}
+
+// envelopeRecipients — RCPT TO : To + Cc + Bcc sans doublon.
+func (m *outgoingMessage) envelopeRecipients() []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, list := range [][]*mail.Address{m.To, m.Cc, m.Bcc} {
+		for _, a := range list {
+			if !seen[a.Address] {
+				seen[a.Address] = true
+				out = append(out, a.Address)
+			}
+		}
+	}
+	return out
+}
+
+var (
+	htmlBreakRe = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6]|blockquote)>`)
+	htmlDropRe  = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>`)
+	htmlTagRe   = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankRunRe  = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToPlainText — alternative texte d'un corps HTML (lecteurs texte, filtres anti-spam).
+func htmlToPlainText(s string) string {
+	s = htmlDropRe.ReplaceAllString(s, "")
+	s = htmlBreakRe.ReplaceAllString(s, "\n")
+	s = htmlTagRe.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t\r ")
+	}
+	return strings.TrimSpace(blankRunRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+type mimeNode struct {
+	header message.Header
+	body   []byte
+	parts  []*mimeNode
+}
+
+func textNode(mediaType, s string) *mimeNode {
+	n := &mimeNode{body: []byte(s)}
+	n.header.SetContentType(mediaType, map[string]string{"charset": "utf-8"})
+	n.header.Set("Content-Transfer-Encoding", "quoted-printable")
+	return n
+}
+
+func multipartNode(subtype string, parts ...*mimeNode) *mimeNode {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	n := &mimeNode{parts: parts}
+	n.header.SetContentType("multipart/"+subtype, map[string]string{})
+	return n
+}
+
+func attachmentNode(a outgoingAttachment) *mimeNode {
+	n := &mimeNode{body: a.Data}
+	ct := a.ContentType
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	n.header.SetContentType(ct, map[string]string{"name": a.Filename})
+	n.header.Set("Content-Transfer-Encoding", "base64")
+	if a.ContentID != "" {
+		n.header.SetContentDisposition("inline", map[string]string{"filename": a.Filename})
+		n.header.Set("Content-Id", "<"+a.ContentID+">")
+	} else {
+		n.header.SetContentDisposition("attachment", map[string]string{"filename": a.Filename})
+	}
+	return n
+}
+
+func writeMIMENode(w *message.Writer, n *mimeNode) error {
+	if n.parts == nil {
+		if _, err := w.Write(n.body); err != nil {
+			return err
+		}
+		return w.Close()
+	}
+	for _, p := range n.parts {
+		pw, err := w.CreatePart(p.header)
+		if err != nil {
+			return err
+		}
+		if err := writeMIMENode(pw, p); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// buildOutgoingMIME sérialise le message (RFC 5322 + MIME), en-têtes encodés RFC 2047.
+// Bcc est omis : c'est la forme transmise aux destinataires.
+func buildOutgoingMIME(m *outgoingMessage) ([]byte, error) {
+	return buildMessageMIME(m, false)
+}
+
+// buildDraftMIME — même sérialisation avec l'en-tête Bcc, pour la copie déposée dans
+// Brouillons : le brouillon repris (ici ou dans un autre client) garde ses copies cachées.
+func buildDraftMIME(m *outgoingMessage) ([]byte, error) {
+	return buildMessageMIME(m, true)
+}
+
+func buildMessageMIME(m *outgoingMessage, withBcc bool) ([]byte, error) {
+	text := m.Text
+	if text == "" && m.HTML != "" {
+		text = htmlToPlainText(m.HTML)
+	}
+	var body *mimeNode
+	switch {
+	case m.HTML != "":
+		body = multipartNode("alternative", textNode("text/plain", text), textNode("text/html", m.HTML))
+	default:
+		body = textNode("text/plain", text)
+	}
+	var inline, attached []*mimeNode
+	for _, a := range m.Attachments {
+		if a.ContentID != "" && m.HTML != "" {
+			inline = append(inline, attachmentNode(a))
+		} else {
+			a.ContentID = ""
+			attached = append(attached, attachmentNode(a))
+		}
+	}
+	if len(inline) > 0 {
+		body = multipartNode("related", append([]*mimeNode{body}, inline...)...)
+	}
+	if len(attached) > 0 {
+		body = multipartNode("mixed", append([]*mimeNode{body}, attached...)...)
+	}
+
+	var h mail.Header
+	h.Header = body.header.Copy()
+	h.SetAddressList("From", []*mail.Address{m.From})
+	h.SetAddressList("To", m.To)
+	h.SetAddressList("Cc", m.Cc)
+	if withBcc {
+		h.SetAddressList("Bcc", m.Bcc)
+	}
+	h.SetSubject(m.Subject)
+	h.SetDate(m.Date)
+	h.Set("Message-Id", m.MessageID)
+	if m.InReplyTo != "" {
+		h.SetMsgIDList("In-Reply-To", []string{m.InReplyTo})
+	}
+	h.SetMsgIDList("References", m.References)
+	if m.AutoSubmitted != "" {
+		h.Set("Auto-Submitted", m.AutoSubmitted)
+	}
+	for _, addr := range m.XLoop {
+		h.Add("X-Loop", addr)
+	}
+
+	var buf strings.Builder
+	w, err := message.CreateWriter(&buf, h.Header)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMIMENode(w, body); err != nil {
+		return nil, err
+	}
+	return []byte(buf.String()), nil
+}
+
+// splitMessageIDs découpe un en-tête References / In-Reply-To en identifiants sans chevrons.
+func splitMessageIDs(s string) []string {
+	var out []string
+	for _, f := range strings.Fields(strings.NewReplacer("<", " ", ">", " ", ",", " ").Replace(s)) {
+		if strings.Contains(f, "@") {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// threadHeaders calcule In-Reply-To / References d'une réponse : References du parent suivi
+// de son Message-ID (RFC 5322 § 3.6.4), en gardant les 20 derniers identifiants.
+func threadHeaders(parentMsgID, parentReferences, parentInReplyTo string) (inReplyTo string, refs []string) {
+	ids := splitMessageIDs(parentMsgID)
+	if len(ids) == 0 {
+		return "", nil
+	}
+	inReplyTo = ids[0]
+	refs = splitMessageIDs(parentReferences)
+	if len(refs) == 0 {
+		refs = splitMessageIDs(parentInReplyTo)
+	}
+	refs = append(refs, inReplyTo)
+	if len(refs) > 20 {
+		refs = append(refs[:1], refs[len(refs)-19:]...)
+	}
+	return inReplyTo, refs
+}
+
+// resolveReplyHeaders — en-têtes de fil : message d'origine en base prioritaire, sinon champs explicites.
+func (h *Handler) resolveReplyHeaders(ctx context.Context, req *mailComposeRequest) (string, []string, error) {
+	if req.ReplyToMessageID > 0 {
+		var msgID, inReplyTo string
+		var refs sql.NullString
+		err := h.dbex(ctx).QueryRow(`
+			SELECT m.internet_msg_id, m.in_reply_to, m.references_header
+			FROM mail_messages m
+			INNER JOIN user_email_accounts u ON u.id = m.account_id
+			WHERE m.id = $1 AND u.user_id = current_setting('app.current_user_id', true)::INTEGER
+		`, req.ReplyToMessageID).Scan(&msgID, &inReplyTo, &refs)
+		if err == sql.ErrNoRows {
+			return "", nil, composeError("message d'origine introuvable")
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		irt, r := threadHeaders(msgID, refs.String, inReplyTo)
+		return irt, r, nil
+	}
+	ids := splitMessageIDs(req.InReplyTo)
+	if len(ids) == 0 {
+		return "", nil, nil
+	}
+	refs := splitMessageIDs(req.References)
+	if len(refs) == 0 || refs[len(refs)-1] != ids[0] {
+		refs = append(refs, ids[0])
+	}
+	return ids[0], refs, nil
+}
+
+// resolveComposeAttachments reprend les pièces jointes conservées du brouillon, décode les pièces
+// jointes directes et lit les nœuds Drive (même contrôle d'accès que drive-service : propriétaire
+// ou partage, hors coffre et verrouillé).
+func (h *Handler) resolveComposeAttachments(ctx context.Context, req *mailComposeRequest) ([]outgoingAttachment, error) {
+	var out []outgoingAttachment
+	var total int64
+	max := outgoingMaxBytes()
+	add := func(a outgoingAttachment) error {
+		if len(out) >= maxOutgoingAttachment {
+			return composeErrorf("trop de pièces jointes (max %d)", maxOutgoingAttachment)
+		}
+		if total += int64(len(a.Data)); total > max {
+			return composeErrorf("pièces jointes trop volumineuses (max %d Mo)", max>>20)
+		}
+		a.Filename = strings.TrimSpace(filepath.Base(strings.ReplaceAll(a.Filename, `\`, "/")))
+		if a.Filename == "" || a.Filename == "." || a.Filename == "/" {
+			a.Filename = "piece-jointe"
+		}
+		if a.ContentType == "" {
+			a.ContentType = mime.TypeByExtension(filepath.Ext(a.Filename))
+		}
+		a.ContentID = strings.Trim(strings.TrimSpace(a.ContentID), "<>")
+		out = append(out, a)
+		return nil
+	}
+	for _, id := range req.DraftAttachmentIDs {
+		if req.DraftID <= 0 {
+			break
+		}
+		a := outgoingAttachment{DraftAttachmentID: id}
+		err := h.dbex(ctx).QueryRow(`
+			SELECT a.filename, a.content_type, a.content_id, COALESCE(a.content, ''::bytea)
+			FROM mail_message_attachments a
+			INNER JOIN mail_messages m ON m.id = a.message_id
+			INNER JOIN user_email_accounts u ON u.id = m.account_id
+			WHERE a.id = $1 AND a.message_id = $2
+			AND u.user_id = current_setting('app.current_user_id', true)::INTEGER
+		`, id, req.DraftID).Scan(&a.Filename, &a.ContentType, &a.ContentID, &a.Data)
+		if err == sql.ErrNoRows {
+			return nil, composeErrorf("pièce jointe %d du brouillon introuvable", id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := add(a); err != nil {
+			return nil, err
+		}
+	}
+	driveIDs := append([]int(nil), req.DriveNodeIDs...)
+	driveCID := make(map[int]string)
+	for _, a := range req.Attachments {
+		if a.DriveNodeID > 0 {
+			driveIDs = append(driveIDs, a.DriveNodeID)
+			driveCID[a.DriveNodeID] = a.ContentID
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(a.ContentBase64)
+		if err != nil {
+			return nil, composeErrorf("pièce jointe %q : contenu base64 invalide", a.Filename)
+		}
+		if err := add(outgoingAttachment{Filename: a.Filename, ContentType: a.ContentType, ContentID: a.ContentID, Data: data}); err != nil {
+			return nil, err
+		}
+	}
+	seen := make(map[int]bool)
+	for _, id := range driveIDs {
+		if id <= 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		var name string
+		var ct sql.NullString
+		var data []byte
+		err := h.dbex(ctx).QueryRow(`
+			SELECT name, mime_type, COALESCE(content, ''::bytea) FROM drive_nodes
+			WHERE id = $1 AND is_folder = false AND deleted_at IS NULL
+			  AND vault_encrypted = false AND photo_locked_at IS NULL
+			  AND (user_id = current_setting('app.current_user_id', true)::INTEGER
+			       OR drive_node_access(id, current_setting('app.current_user_id', true)::INTEGER) >= 1)
+		`, id).Scan(&name, &ct, &data)
+		if err == sql.ErrNoRows {
+			return nil, composeErrorf("fichier Drive %d introuvable ou non joignable", id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := add(outgoingAttachment{Filename: name, ContentType: ct.String, ContentID: driveCID[id], Data: data}); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// composeBodies — (texte, HTML) de la requête ; Body reste le texte brut historique.
+func composeBodies(req *mailComposeRequest) (string, string) {
+	text := req.Text
+	if text == "" {
+		text = req.Body
+	}
+	return text, req.HTML
+}
+
+// composeRecipients valide To/Cc/Bcc (100 au plus ; un brouillon peut n'en avoir aucun).
+func composeRecipients(req *mailComposeRequest) (to, cc, bcc []*mail.Address, err error) {
+	if to, err = parseRecipients(req.To); err != nil {
+		return
+	}
+	if cc, err = parseRecipients(req.Cc); err != nil {
+		return
+	}
+	if bcc, err = parseRecipients(req.Bcc); err != nil {
+		return
+	}
+	if len(to)+len(cc)+len(bcc) > maxOutgoingRecipients {
+		err = composeErrorf("trop de destinataires (max %d)", maxOutgoingRecipients)
+	}
+	return
+}
+
+// composeError — requête d'envoi invalide (400), par opposition aux erreurs base / SMTP.
+type composeError string
+
+func (e composeError) Error() string { return string(e) }
+
+func composeErrorf(format string, args ...any) error {
+	return composeError(fmt.Sprintf(format, args...))
+}
+
+// writeComposeError — 400 pour une requête invalide (destinataires, pièces jointes, fil), 500 sinon.
+func writeComposeError(c *gin.Context, err error) {
+	var ce composeError
+	if errors.As(err, &ce) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ce.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// composeOutgoingMessage valide la requête d'envoi (au moins un destinataire, objet par défaut).
+// From, Message-ID et Date sont posés à l'envoi (stamp), une fois l'expéditeur vérifié.
+func (h *Handler) composeOutgoingMessage(ctx context.Context, req *mailComposeRequest) (*outgoingMessage, error) {
+	m, err := h.resolveComposition(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.envelopeRecipients()) == 0 {
+		return nil, composeError("destinataire invalide")
+	}
+	if m.Subject == "" {
+		m.Subject = "(sans objet)"
+	}
+	return m, nil
+}
+
+// resolveComposition résout destinataires, corps, pièces jointes et fil (brouillon ou envoi).
+func (h *Handler) resolveComposition(ctx context.Context, req *mailComposeRequest) (*outgoingMessage, error) {
+	to, cc, bcc, err := composeRecipients(req)
+	if err != nil {
+		return nil, err
+	}
+	atts, err := h.resolveComposeAttachments(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	inReplyTo, refs, err := h.resolveReplyHeaders(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	text, htmlBody := composeBodies(req)
+	return &outgoingMessage{
+		To: to, Cc: cc, Bcc: bcc,
+		Subject: strings.TrimSpace(req.Subject), Text: text, HTML: htmlBody,
+		InReplyTo: inReplyTo, References: refs,
+		Attachments: atts,
+	}, nil
+}
+
+// resolveFromAddress — adresse « De » : compte principal ou alias actif enregistré pour ce compte.
+func (h *Handler) resolveFromAddress(ctx context.Context, accountID int, accountEmail, fromInput string) (string, error) {
+	from := strings.TrimSpace(fromInput)
+	if from == "" || strings.EqualFold(from, strings.TrimSpace(accountEmail)) {
+		return accountEmail, nil
+	}
+	var canon string
+	err := h.dbex(ctx).QueryRow(`
+		SELECT a.alias_email FROM user_email_aliases a
+		INNER JOIN user_email_accounts u ON u.id = a.account_id
+		WHERE a.account_id = $1 AND LOWER(a.alias_email) = $2
+		AND a.enabled = true
+		AND u.user_id = current_setting('app.current_user_id', true)::INTEGER
+	`, accountID, strings.ToLower(from)).Scan(&canon)
+	if err == sql.ErrNoRows || (err == nil && strings.TrimSpace(canon) == "") {
+		return "", fmt.Errorf("from_email doit être l’adresse du compte ou un alias enregistré pour cette boîte")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(canon), nil
+}
+
+// stamp fixe l'expéditeur vérifié, un Message-ID neuf et la date d'envoi.
+func (m *outgoingMessage) stamp(from string) {
+	m.From = &mail.Address{Address: from}
+	m.MessageID = generateOutboundMessageID(from)
+	m.Date = time.Now()
+}
+
+// addressListString — forme stockée en base (to_addrs, cc_addrs, bcc_addrs).
+func addressListString(list []*mail.Address) string {
+	out := make([]string, 0, len(list))
+	for _, a := range list {
+		out = append(out, a.String())
+	}
+	return strings.Join(out, ", ")
+}
+
+// referencesHeader — valeur References stockée (« <id1> <id2> »), comme à la synchronisation IMAP.
+func referencesHeader(refs []string) string {
+	out := make([]string, 0, len(refs))
+	for _, r := range refs {
+		out = append(out, "<"+r+">")
+	}
+	return strings.Join(out, " ")
+}
+
+// storeComposeAttachments enregistre les pièces jointes d'un envoi programmé ou d'un brouillon
+// (contenu complet), à la suite de celles déjà présentes.
+func storeComposeAttachments(tx *sql.Tx, messageID int, atts []outgoingAttachment) error {
+	for _, a := range atts {
+		if _, err := tx.Exec(`
+			INSERT INTO mail_message_attachments (message_id, part_ordinal, filename, content_type, size_bytes, content, content_id)
+			VALUES ($1, (SELECT COALESCE(MAX(part_ordinal), 0) + 1 FROM mail_message_attachments WHERE message_id = $1), $2, $3, $4, $5, $6)
+		`, messageID, a.Filename, a.ContentType, len(a.Data), a.Data, a.ContentID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadComposeAttachments relit les pièces jointes d'un envoi programmé.
+func (h *Handler) loadComposeAttachments(ctx context.Context, messageID int) ([]outgoingAttachment, error) {
+	rows, err := h.dbex(ctx).Query(`
+		SELECT filename, content_type, content_id, COALESCE(content, ''::bytea)
+		FROM mail_message_attachments WHERE message_id = $1 ORDER BY part_ordinal
+	`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []outgoingAttachment
+	for rows.Next() {
+		var a outgoingAttachment
+		if err := rows.Scan(&a.Filename, &a.ContentType, &a.ContentID, &a.Data); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
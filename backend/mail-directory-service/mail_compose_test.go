@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+func TestRecipientListUnmarshal(t *testing.T) {
+	var req mailComposeRequest
+	if err := json.Unmarshal([]byte(`{"to":"a@x.fr; B <b@y.fr>","cc":["c@z.fr","d@z.fr"]}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	to, err := parseRecipients(req.To)
+	if err != nil || len(to) != 2 || to[0].Address != "a@x.fr" || to[1].Name != "B" || to[1].Address != "b@y.fr" {
+		t.Fatalf("to = %v, %v", to, err)
+	}
+	cc, err := parseRecipients(req.Cc)
+	if err != nil || len(cc) != 2 {
+		t.Fatalf("cc = %v, %v", cc, err)
+	}
+	if _, err := parseRecipients(recipientList{"pas une adresse"}); err == nil {
+		t.Error("invalid recipient accepted")
+	}
+}
+
+func TestComposeRecipientsLimits(t *testing.T) {
+	if to, cc, bcc, err := composeRecipients(&mailComposeRequest{}); err != nil || len(to)+len(cc)+len(bcc) != 0 {
+		t.Errorf("empty recipients (draft): %v", err)
+	}
+	many := make(recipientList, maxOutgoingRecipients+1)
+	for i := range many {
+		many[i] = "u@x.fr"
+	}
+	if _, _, _, err := composeRecipients(&mailComposeRequest{Bcc: many}); err == nil {
+		t.Error("too many recipients accepted")
+	}
+	if _, _, _, err := composeRecipients(&mailComposeRequest{Bcc: recipientList{"a@x.fr"}}); err != nil {
+		t.Errorf("bcc only: %v", err)
+	}
+}
+
+func TestEnvelopeRecipientsDedup(t *testing.T) {
+	m := &outgoingMessage{
+		To:  []*mail.Address{{Address: "a@x.fr"}},
+		Cc:  []*mail.Address{{Address: "b@x.fr"}, {Address: "a@x.fr"}},
+		Bcc: []*mail.Address{{Address: "c@x.fr"}},
+	}
+	got := strings.Join(m.envelopeRecipients(), ",")
+	if got != "a@x.fr,b@x.fr,c@x.fr" {
+		t.Errorf("envelopeRecipients = %q", got)
+	}
+}
+
+func TestHTMLToPlainText(t *testing.T) {
+	in := `<style>p{color:red}</style><p>Bonjour&nbsp;<b>Alice</b></p><p>Ligne 2<br>Ligne 3</p>`
+	want := "Bonjour Alice\nLigne 2\nLigne 3"
+	if got := htmlToPlainText(in); got != want {
+		t.Errorf("htmlToPlainText = %q, want %q", got, want)
+	}
+}
+
+func TestThreadHeaders(t *testing.T) {
+	irt, refs := threadHeaders("<p@x>", "<r1@x> <r2@x>", "r2@x")
+	if irt != "p@x" || strings.Join(refs, " ") != "r1@x r2@x p@x" {
+		t.Errorf("threadHeaders = %q, %v", irt, refs)
+	}
+	irt, refs = threadHeaders("p@x", "", "<q@x>")
+	if irt != "p@x" || strings.Join(refs, " ") != "q@x p@x" {
+		t.Errorf("threadHeaders without References = %q, %v", irt, refs)
+	}
+	if irt, refs = threadHeaders("", "<r1@x>", ""); irt != "" || refs != nil {
+		t.Errorf("threadHeaders without Message-ID = %q, %v", irt, refs)
+	}
+	if got := referencesHeader([]string{"a@x", "b@x"}); got != "<a@x> <b@x>" {
+		t.Errorf("referencesHeader = %q", got)
+	}
+}
+
+func TestBuildOutgoingMIMERoundTrip(t *testing.T) {
+	m := &outgoingMessage{
+		To:         []*mail.Address{{Name: "Zoé", Address: "zoe@x.fr"}},
+		Cc:         []*mail.Address{{Address: "cc@x.fr"}},
+		Bcc:        []*mail.Address{{Address: "secret@x.fr"}},
+		Subject:    "Réunion de lundi",
+		HTML:       `<p>Voir <img src="cid:logo"></p>`,
+		InReplyTo:  "parent@x.fr",
+		References: []string{"root@x.fr", "parent@x.fr"},
+		Attachments: []outgoingAttachment{
+			{Filename: "logo.png", ContentType: "image/png", ContentID: "logo", Data: []byte("\x89PNG")},
+			{Filename: "compte rendu.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")},
+		},
+	}
+	m.stamp("moi@x.fr")
+	m.Date = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	raw, err := buildOutgoingMIME(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(raw)
+	if strings.Contains(s, "secret@x.fr") || strings.Contains(strings.ToLower(s), "\nbcc:") {
+		t.Error("Bcc leaked into headers")
+	}
+	for _, want := range []string{"multipart/mixed", "multipart/related", "multipart/alternative", "Content-Id: <logo>", "In-Reply-To: <parent@x.fr>", "References: <root@x.fr> <parent@x.fr>"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("missing %q in:\n%s", want, s)
+		}
+	}
+	parsed, err := parseRFC822Mail(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Meta.InReplyTo != "parent@x.fr" || parsed.Meta.ThreadKey != "root@x.fr" {
+		t.Errorf("meta = %+v", parsed.Meta)
+	}
+	if !strings.Contains(parsed.Plain, "Voir") || !strings.Contains(parsed.HTML, "cid:logo") {
+		t.Errorf("bodies = %q / %q", parsed.Plain, parsed.HTML)
+	}
+	var names []string
+	for _, a := range parsed.Attachments {
+		names = append(names, a.Filename)
+	}
+	if !strings.Contains(strings.Join(names, "|"), "compte rendu.pdf") {
+		t.Errorf("attachments = %v", names)
+	}
+}
+
+func TestBuildOutgoingMIMEPlainOnly(t *testing.T) {
+	m := &outgoingMessage{To: []*mail.Address{{Address: "a@x.fr"}}, Subject: "Salut", Text: "Corps accentué"}
+	m.stamp("moi@x.fr")
+	raw, err := buildOutgoingMIME(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "multipart/") || !strings.Contains(string(raw), "text/plain") {
+		t.Errorf("plain message:\n%s", raw)
+	}
+	parsed, err := parseRFC822Mail(raw)
+	if err != nil || parsed.Plain != "Corps accentué" {
+		t.Fatalf("parsed = %+v, %v", parsed, err)
+	}
+}
+
+func TestBuildOutgoingMIMELoopHeaders(t *testing.T) {
+	m := &outgoingMessage{To: []*mail.Address{{Address: "a@y.fr"}}, Subject: "Fwd: x", Text: "x",
+		AutoSubmitted: "auto-forwarded", XLoop: []string{"other@y.fr", "moi@x.fr"}}
+	m.stamp("moi@x.fr")
+	raw, err := buildOutgoingMIME(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := parseSieveHeaderBlock(string(raw))
+	if got := strings.Join(headers["x-loop"], ","); !strings.Contains(got, "other@y.fr") || !strings.Contains(got, "moi@x.fr") {
+		t.Errorf("X-Loop = %v", got)
+	}
+	if got := headers["auto-submitted"]; len(got) != 1 || got[0] != "auto-forwarded" {
+		t.Errorf("Auto-Submitted = %v", got)
+	}
+}
+
+func TestSendRoutesRejectInvalidRecipients(t *testing.T) {
+	r := setupRouter(nil)
+	for _, path := range []string{"/mail/me/send", "/mail/me/send/schedule"} {
+		body := `{"account_id":1,"to":"pas une adresse","scheduled_send_at":"2099-01-01T00:00:00Z"}`
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		setAdminMailHeaders(req)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "destinataire invalide") {
+			t.Errorf("POST %s: got %d %s", path, w.Code, w.Body.String())
+		}
+	}
+}
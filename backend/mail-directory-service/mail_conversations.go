@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Vue « conversations » : messages regroupés par thread_key (threadKeyFromHeaders) tous dossiers
+// confondus, Envoyés compris. Un message sans thread_key forme sa propre conversation « #<id> ».
+const conversationKeySQL = `COALESCE(NULLIF(m.thread_key, ''), '#' || m.id::text)`
+
+const (
+	maxConversationParticipants = 10
+	maxConversationBulkKeys     = 200
+)
+
+type MailConversation struct {
+	ThreadKey       string   `json:"thread_key"`
+	AccountID       int      `json:"account_id"`
+	Subject         string   `json:"subject"`
+	Participants    []string `json:"participants"`
+	MessageCount    int      `json:"message_count"`
+	UnreadCount     int      `json:"unread_count"`
+	LatestDate      string   `json:"latest_date"`
+	LatestMessageID int      `json:"latest_message_id"`
+	Folders         []string `json:"folders"`
+	AttachmentCount int      `json:"attachment_count"`
+}
+
+// conversationExcludedFolders : dossiers dont les messages ne comptent pas dans une conversation.
+// Corbeille et spam ne sont visibles que lorsqu'on les consulte ; les envois programmés ne sont
+// pas encore partis.
+func conversationExcludedFolders(folder string) []string {
+	switch strings.ToLower(strings.TrimSpace(folder)) {
+	case "trash", "spam":
+		return []string{"scheduled"}
+	default:
+		return []string{"trash", "spam", "scheduled"}
+	}
+}
+
+// conversationParticipants garde les expéditeurs distincts (par adresse) dans l'ordre
+// d'apparition ; le nom affiché est préféré à l'adresse quand il existe.
+func conversationParticipants(froms []string, max int) []string {
+	seen := map[string]struct{}{}
+	out := []string{}
+	for _, raw := range froms {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		key, label := strings.ToLower(raw), raw
+		if a, err := mail.ParseAddress(raw); err == nil {
+			key = strings.ToLower(a.Address)
+			label = a.Address
+			if strings.TrimSpace(a.Name) != "" {
+				label = strings.TrimSpace(a.Name)
+			}
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, label)
+		if len(out) >= max {
+			break
+		}
+	}
+	return out
+}
+
+// cleanConversationKeys dédoublonne les clés reçues ; nil si vide ou au-delà de la limite.
+func cleanConversationKeys(keys []string) []string {
+	seen := map[string]struct{}{}
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		out = append(out, k)
+	}
+	if len(out) == 0 || len(out) > maxConversationBulkKeys {
+		return nil
+	}
+	return out
+}
+
+func conversationPaging(c *gin.Context) (limit, offset int) {
+	limit = 25
+	if n, _ := strconv.Atoi(c.Query("limit")); n > 0 && n <= 100 {
+		limit = n
+	}
+	if n, _ := strconv.Atoi(c.Query("offset")); n >= 0 {
+		offset = n
+	}
+	return limit, offset
+}
+
+func (h *Handler) listAccountConversations(c *gin.Context) {
+	ctx := c.Request.Context()
+	accountID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || accountID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return
+	}
+	folder := normalizeMailFolderQuery(c.DefaultQuery("folder", "inbox"))
+	if folder == "scheduled" || !h.folderAllowed(ctx, accountID, folder) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dossier inconnu ou non autorisé"})
+		return
+	}
+	limit, offset := conversationPaging(c)
+	list, total, err := h.queryConversations(ctx, "m.account_id = $1", []interface{}{accountID}, folder, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"conversations": list, "total": total})
+}
+
+// listUnifiedConversations : conversations de toutes les boîtes de l'utilisateur
+// (une conversation reste propre à un compte).
+func (h *Handler) listUnifiedConversations(c *gin.Context) {
+	ctx := c.Request.Context()
+	folder := normalizeMailFolderQuery(c.DefaultQuery("folder", "all"))
+	if folder != "all" && !isStandardMailFolder(folder) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dossier inconnu ou non autorisé"})
+		return
+	}
+	limit, offset := conversationPaging(c)
+	scope := `m.account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)`
+	list, total, err := h.queryConversations(ctx, scope, nil, folder, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"conversations": list, "total": total})
+}
+
+// queryConversations sélectionne les conversations ayant au moins un message dans folder
+// (« all » : hors brouillons) puis les résume sur l'ensemble de leurs messages.
+func (h *Handler) queryConversations(ctx context.Context, scopeSQL string, args []interface{}, folder string, limit, offset int) ([]MailConversation, int, error) {
+	p := len(args) + 1
+	args = append(args, pq.Array(conversationExcludedFolders(folder)))
+	excludePh := fmt.Sprintf("$%d", p)
+	p++
+	pick := "LOWER(TRIM(s.folder)) <> 'drafts'"
+	if folder != "all" {
+		pick = fmt.Sprintf("s.folder = $%d", p)
+		args = append(args, folder)
+		p++
+	}
+	with := fmt.Sprintf(`
+		WITH scope AS (
+			SELECT m.id, m.account_id, m.folder, COALESCE(m.from_addr, '') AS from_addr, COALESCE(m.subject, '') AS subject, COALESCE(m.is_read, false) AS is_read,
+				COALESCE(m.attachment_count, 0) AS attachment_count, COALESCE(m.date_at, m.created_at) AS sort_at,
+				%s AS conv_key
+			FROM mail_messages m
+			WHERE %s AND LOWER(TRIM(m.folder)) <> ALL(%s)
+		), picked AS (
+			SELECT DISTINCT s.account_id, s.conv_key FROM scope s WHERE %s
+		)`, conversationKeySQL, scopeSQL, excludePh, pick)
+	var total int
+	if err := h.dbex(ctx).QueryRow(with+` SELECT COUNT(*) FROM picked`, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := h.dbex(ctx).Query(with+fmt.Sprintf(`
+		SELECT s.account_id, s.conv_key, COUNT(*), COUNT(*) FILTER (WHERE NOT s.is_read), MAX(s.sort_at)::text,
+			(array_agg(s.subject ORDER BY s.sort_at ASC, s.id ASC))[1],
+			(array_agg(s.id ORDER BY s.sort_at DESC, s.id DESC))[1],
+			array_agg(s.from_addr ORDER BY s.sort_at ASC, s.id ASC),
+			array_agg(DISTINCT s.folder),
+			SUM(s.attachment_count)
+		FROM scope s
+		INNER JOIN picked p ON p.account_id = s.account_id AND p.conv_key = s.conv_key
+		GROUP BY s.account_id, s.conv_key
+		ORDER BY MAX(s.sort_at) DESC, s.conv_key
+		LIMIT $%d OFFSET $%d
+	`, p, p+1), append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	list := []MailConversation{}
+	for rows.Next() {
+		var cv MailConversation
+		var latest sql.NullString
+		var froms, folders []string
+		if err := rows.Scan(&cv.AccountID, &cv.ThreadKey, &cv.MessageCount, &cv.UnreadCount, &latest,
+			&cv.Subject, &cv.LatestMessageID, pq.Array(&froms), pq.Array(&folders), &cv.AttachmentCount); err != nil {
+			return nil, 0, err
+		}
+		if latest.Valid {
+			cv.LatestDate = normalizeTimestampString(latest.String)
+		}
+		cv.Participants = conversationParticipants(froms, maxConversationParticipants)
+		cv.Folders = folders
+		list = append(list, cv)
+	}
+	return list, total, rows.Err()
+}
+
+// listConversationMessages renvoie les messages d'une conversation, du plus ancien au plus récent.
+func (h *Handler) listConversationMessages(c *gin.Context) {
+	ctx := c.Request.Context()
+	accountID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || accountID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return
+	}
+	key := strings.TrimSpace(c.Query("thread_key"))
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "thread_key requis"})
+		return
+	}
+	rows, err := h.dbex(ctx).Query(`
+		SELECT m.id, m.account_id, m.folder, m.from_addr, m.to_addrs, m.subject, m.date_at::text, m.scheduled_send_at::text, m.created_at::text, COALESCE(m.is_read, false), COALESCE(m.is_starred, false),
+			COALESCE(m.thread_key, ''), COALESCE(m.attachment_count, 0),
+			COALESCE((SELECT string_agg(mt.tag_id::text, ',' ORDER BY mt.tag_id) FROM mail_message_tags mt WHERE mt.message_id = m.id), '')
+		FROM mail_messages m
+		WHERE m.account_id = $1 AND `+conversationKeySQL+` = $2
+			AND LOWER(TRIM(m.folder)) <> ALL($3)
+			AND m.account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
+		ORDER BY COALESCE(m.date_at, m.created_at) ASC, m.id ASC
+	`, accountID, key, pq.Array(conversationExcludedFolders(c.Query("folder"))))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	msgList := []MailMessage{}
+	for rows.Next() {
+		var m MailMessage
+		var dateAt, scheduledAt sql.NullString
+		var createdRaw, tagCSV string
+		if err := rows.Scan(&m.ID, &m.AccountID, &m.Folder, &m.FromAddr, &m.ToAddrs, &m.Subject, &dateAt, &scheduledAt, &createdRaw, &m.IsRead, &m.IsStarred, &m.ThreadKey, &m.AttachmentCount, &tagCSV); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		applyListedMailTimestamps(&m, dateAt, scheduledAt, createdRaw)
+		m.TagIDs = parseMessageTagCSV(tagCSV)
+		m.SpamScore = spamHeuristicScore(m.Subject, m.FromAddr)
+		msgList = append(msgList, m)
+	}
+	h.applySpamScores(ctx, msgList)
+	if len(msgList) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "conversation introuvable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"messages": msgList})
+}
+
+type conversationMessageRef struct {
+	ID     int
+	Folder string
+}
+
+// conversationMessages liste les messages (hors envois programmés) des conversations demandées.
+func (h *Handler) conversationMessages(ctx context.Context, accountID int, keys []string) ([]conversationMessageRef, error) {
+	rows, err := h.dbex(ctx).Query(`
+		SELECT m.id, m.folder
+		FROM mail_messages m
+		WHERE m.account_id = $1 AND `+conversationKeySQL+` = ANY($2)
+			AND LOWER(TRIM(m.folder)) <> 'scheduled'
+			AND m.account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
+		ORDER BY m.id
+	`, accountID, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var refs []conversationMessageRef
+	for rows.Next() {
+		var r conversationMessageRef
+		if err := rows.Scan(&r.ID, &r.Folder); err != nil {
+			return nil, err
+		}
+		refs = append(refs, r)
+	}
+	return refs, rows.Err()
+}
+
+// conversationMoveSkips : en déplaçant une conversation, les messages envoyés et brouillons
+// restent à leur place, sauf vers la corbeille (suppression de toute la conversation).
+func conversationMoveSkips(msgFolder, dest string) bool {
+	src := strings.ToLower(strings.TrimSpace(msgFolder))
+	if src == strings.ToLower(dest) {
+		return true
+	}
+	if strings.EqualFold(dest, "trash") {
+		return false
+	}
+	return src == "sent" || src == "drafts"
+}
+
+func (h *Handler) markConversationsRead(c *gin.Context) {
+	ctx := c.Request.Context()
+	accountID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || accountID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return
+	}
+	var body struct {
+		ThreadKeys []string `json:"thread_keys"`
+		Read       *bool    `json:"read"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Read == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must contain thread_keys[] and read"})
+		return
+	}
+	keys := cleanConversationKeys(body.ThreadKeys)
+	if keys == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("thread_keys : entre 1 et %d conversations", maxConversationBulkKeys)})
+		return
+	}
+	res, err := h.dbex(ctx).Exec(`
+		UPDATE mail_messages m SET is_read = $3
+		WHERE m.account_id = $1 AND `+conversationKeySQL+` = ANY($2)
+		AND m.account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
+	`, accountID, pq.Array(keys), *body.Read)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	n, _ := res.RowsAffected()
+	c.JSON(http.StatusOK, gin.H{"ok": true, "updated": n, "read": *body.Read})
+}
+
+func (h *Handler) moveConversationsToFolder(c *gin.Context) {
+	ctx := c.Request.Context()
+	accountID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || accountID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return
+	}
+	var body struct {
+		ThreadKeys []string `json:"thread_keys"`
+		Folder     *string  `json:"folder"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Folder == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must contain thread_keys[] and folder"})
+		return
+	}
+	keys := cleanConversationKeys(body.ThreadKeys)
+	if keys == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("thread_keys : entre 1 et %d conversations", maxConversationBulkKeys)})
+		return
+	}
+	folder := strings.TrimSpace(*body.Folder)
+	if isStandardMailFolder(folder) {
+		folder = strings.ToLower(folder)
+	}
+	if folder == "" || !h.folderAllowed(ctx, accountID, folder) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dossier inconnu ou non autorisé pour cette boîte"})
+		return
+	}
+	refs, err := h.conversationMessages(ctx, accountID, keys)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	updated, skipped := 0, 0
+	for _, r := range refs {
+		if conversationMoveSkips(r.Folder, folder) {
+			skipped++
+			continue
+		}
+		if moveErr := h.imapMoveMessage(ctx, accountID, r.ID, folder); moveErr != nil {
+			log.Printf("[mail] conversation move account=%d msg=%d -> %q: %v", accountID, r.ID, folder, moveErr)
+			continue
+		}
+		updated++
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "updated": updated, "skipped": skipped, "requested": len(refs), "folder": folder})
+}
+
+func (h *Handler) tagConversations(c *gin.Context) {
+	ctx := c.Request.Context()
+	accountID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || accountID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return
+	}
+	var body struct {
+		ThreadKeys   []string `json:"thread_keys"`
+		AddTagIDs    []int    `json:"add_tag_ids"`
+		RemoveTagIDs []int    `json:"remove_tag_ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || len(body.AddTagIDs)+len(body.RemoveTagIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must contain thread_keys[] and add_tag_ids[] or remove_tag_ids[]"})
+		return
+	}
+	keys := cleanConversationKeys(body.ThreadKeys)
+	if keys == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("thread_keys : entre 1 et %d conversations", maxConversationBulkKeys)})
+		return
+	}
+	refs, err := h.conversationMessages(ctx, accountID, keys)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ids := make([]int64, 0, len(refs))
+	for _, r := range refs {
+		ids = append(ids, int64(r.ID))
+	}
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	if len(body.RemoveTagIDs) > 0 {
+		if _, err := tx.Exec(`
+			DELETE FROM mail_message_tags WHERE message_id = ANY($1) AND tag_id = ANY($2)
+		`, pq.Array(ids), pq.Array(body.RemoveTagIDs)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if len(body.AddTagIDs) > 0 {
+		// Étiquettes d'un autre compte ignorées (même règle que putMessageTagsHTTP).
+		if _, err := tx.Exec(`
+			INSERT INTO mail_message_tags (message_id, tag_id)
+			SELECT mid, t.id FROM unnest($1::int[]) AS mid
+			CROSS JOIN mail_tags t
+			WHERE t.id = ANY($2) AND t.account_id = $3
+			ON CONFLICT DO NOTHING
+		`, pq.Array(ids), pq.Array(body.AddTagIDs), accountID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "messages": len(ids)})
+}
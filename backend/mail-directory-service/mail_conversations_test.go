@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConversationParticipants(t *testing.T) {
+	froms := []string{"Alice <alice@x.fr>", "moi@x.fr", "ALICE@x.fr", "", "Bob <bob@y.fr>"}
+	got := strings.Join(conversationParticipants(froms, 10), "|")
+	if got != "Alice|moi@x.fr|Bob" {
+		t.Errorf("participants = %q", got)
+	}
+	if n := len(conversationParticipants(froms, 2)); n != 2 {
+		t.Errorf("max not applied: %d", n)
+	}
+}
+
+func TestCleanConversationKeys(t *testing.T) {
+	if got := cleanConversationKeys([]string{" a@x ", "a@x", "", "#12"}); strings.Join(got, ",") != "a@x,#12" {
+		t.Errorf("cleanConversationKeys = %v", got)
+	}
+	if cleanConversationKeys(nil) != nil {
+		t.Error("empty keys accepted")
+	}
+	many := make([]string, maxConversationBulkKeys+1)
+	for i := range many {
+		many[i] = strings.Repeat("k", i+1)
+	}
+	if cleanConversationKeys(many) != nil {
+		t.Error("too many keys accepted")
+	}
+}
+
+func TestConversationMoveSkips(t *testing.T) {
+	cases := []struct {
+		src, dest string
+		want      bool
+	}{
+		{"inbox", "archive", false},
+		{"sent", "archive", true},
+		{"drafts", "archive", true},
+		{"sent", "trash", false},
+		{"archive", "archive", true},
+		{"INBOX", "inbox", true},
+	}
+	for _, tc := range cases {
+		if got := conversationMoveSkips(tc.src, tc.dest); got != tc.want {
+			t.Errorf("conversationMoveSkips(%q, %q) = %v, want %v", tc.src, tc.dest, got, tc.want)
+		}
+	}
+}
+
+func TestConversationRoutesRequireAuth(t *testing.T) {
+	r := setupRouter(nil)
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/mail/me/conversations/unified"},
+		{http.MethodGet, "/mail/me/accounts/1/conversations"},
+		{http.MethodGet, "/mail/me/accounts/1/conversations/messages?thread_key=a"},
+		{http.MethodPatch, "/mail/me/accounts/1/conversations/read"},
+		{http.MethodPatch, "/mail/me/accounts/1/conversations/folder"},
+		{http.MethodPatch, "/mail/me/accounts/1/conversations/tags"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without X-User-ID: got %d", tc.method, tc.path, w.Code)
+		}
+	}
+}
+
+func TestConversationBulkRejectsEmptyKeys(t *testing.T) {
+	r := setupRouter(nil)
+	for _, tc := range []struct{ path, body string }{
+		{"/mail/me/accounts/1/conversations/read", `{"thread_keys":[],"read":true}`},
+		{"/mail/me/accounts/1/conversations/tags", `{"thread_keys":[" "],"add_tag_ids":[3]}`},
+		{"/mail/me/accounts/1/conversations/tags", `{"thread_keys":["a@x"]}`},
+	} {
+		req := httptest.NewRequest(http.MethodPatch, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		setAdminMailHeaders(req)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("PATCH %s %s: got %d %s", tc.path, tc.body, w.Code, w.Body.String())
+		}
+	}
+}
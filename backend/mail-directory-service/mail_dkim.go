@@ -0,0 +1,678 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Signature DKIM des domaines hébergés (MAIL-ALIAS-06) : une paire RSA-2048 et une paire Ed25519
+// par domaine (double signature, RFC 8463 §4 — les vérificateurs sans Ed25519 gardent RSA).
+// Signés : envois des comptes dont le From est un domaine hébergé (sendOutgoingMail) et messages
+// relayés par l'alias-router (/mail/internal/dkim/sign). GET /mail/domains/:id/dns liste les
+// enregistrements à publier ; /dns/check les compare aux réponses du résolveur.
+
+const (
+	dkimRSAKeyBits       = 2048
+	dkimMaxSignBytes     = 64 << 20
+	mailDNSTTL           = 3600
+	mtaSTSMaxAge         = 604800
+	dkimSignatureLineLen = 76
+)
+
+// dkimSignedHeaders — champs signés s'ils sont présents ; From est sur-signé (une entrée de plus
+// que d'occurrences) pour qu'un From ajouté en aval casse la signature (RFC 6376 §8.15).
+var dkimSignedHeaders = []string{
+	"from", "reply-to", "subject", "date", "to", "cc", "message-id", "in-reply-to", "references",
+	"mime-version", "content-type", "content-transfer-encoding", "list-unsubscribe", "list-unsubscribe-post",
+}
+
+// dkimSign calcule le champ DKIM-Signature (relaxed/relaxed, CRLF final compris) à placer en tête.
+func dkimSign(raw []byte, domain, selector string, key crypto.Signer, now time.Time) (string, error) {
+	algo, opts := "rsa-sha256", crypto.SignerOpts(crypto.SHA256)
+	switch key.(type) {
+	case *rsa.PrivateKey:
+	case ed25519.PrivateKey:
+		algo, opts = "ed25519-sha256", crypto.Hash(0)
+	default:
+		return "", fmt.Errorf("type de clé DKIM non pris en charge")
+	}
+	fields, body := splitRawMessage(raw)
+	var names []string
+	for _, name := range dkimSignedHeaders {
+		for _, f := range fields {
+			if strings.EqualFold(f.Name, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	names = append(names, "from")
+	bh := sha256.Sum256(dkimCanonBody(body, true))
+	sig := rawHeaderField{Name: "DKIM-Signature", Raw: fmt.Sprintf(
+		"DKIM-Signature: v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d;\r\n\th=%s;\r\n\tbh=%s;\r\n\tb=",
+		algo, domain, selector, now.Unix(), strings.Join(names, ":"), base64.StdEncoding.EncodeToString(bh[:]))}
+	digest := sha256.Sum256([]byte(dkimSelectHeaders(fields, names, true) + strings.TrimSuffix(dkimCanonHeader(sig, true), "\r\n")))
+	b, err := key.Sign(rand.Reader, digest[:], opts)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.StdEncoding.EncodeToString(b)
+	var out strings.Builder
+	out.WriteString(sig.Raw)
+	for len(enc) > dkimSignatureLineLen {
+		out.WriteString(enc[:dkimSignatureLineLen] + "\r\n\t  ")
+		enc = enc[dkimSignatureLineLen:]
+	}
+	out.WriteString(enc + "\r\n")
+	return out.String(), nil
+}
+
+// ---------- Clés ----------
+
+// dkimKeyPair — clé générée : PrivatePEM (PKCS#8) à chiffrer, PublicKey = valeur p= du TXT.
+type dkimKeyPair struct {
+	Algorithm  string
+	Selector   string
+	PrivatePEM string
+	PublicKey  string
+}
+
+// dkimSelectorFor — <base>-rsa-AAAAMMJJ : une rotation un autre jour publie un nouveau sélecteur,
+// l'ancien TXT reste valable pour les messages en transit.
+func dkimSelectorFor(base, algorithm string, now time.Time) string {
+	base = strings.ToLower(strings.TrimSpace(base))
+	if base == "" {
+		base = "cloudity"
+	}
+	return base + "-" + algorithm + "-" + now.UTC().Format("20060102")
+}
+
+func generateDKIMKeys(base string, now time.Time) ([]dkimKeyPair, error) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, dkimRSAKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	rsaPub, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]dkimKeyPair, 0, 2)
+	for _, k := range []struct {
+		algorithm string
+		private   any
+		public    []byte
+	}{
+		{"rsa", rsaKey, rsaPub},
+		// RFC 8463 §4.2 : p= porte la clé Ed25519 brute (32 octets), pas une structure PKIX.
+		{"ed25519", edKey, edPub},
+	} {
+		der, err := x509.MarshalPKCS8PrivateKey(k.private)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, dkimKeyPair{
+			Algorithm:  k.algorithm,
+			Selector:   dkimSelectorFor(base, k.algorithm, now),
+			PrivatePEM: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+			PublicKey:  base64.StdEncoding.EncodeToString(k.public),
+		})
+	}
+	return out, nil
+}
+
+func parseDKIMPrivateKey(pemText string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(pemText))
+	if block == nil {
+		return nil, fmt.Errorf("clé DKIM illisible")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("clé DKIM non signante")
+	}
+	return signer, nil
+}
+
+// dkimPublicKey — clé publiée d'un domaine (sans la partie privée).
+type dkimPublicKey struct {
+	Selector  string `json:"selector"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+	CreatedAt string `json:"created_at"`
+}
+
+func (k dkimPublicKey) txtValue() string {
+	return "v=DKIM1; k=" + k.Algorithm + "; p=" + k.PublicKey
+}
+
+func loadDKIMPublicKeys(ex dbExec, domainID int) ([]dkimPublicKey, error) {
+	rows, err := ex.Query(`
+		SELECT selector, algorithm, public_key, created_at::text
+		FROM mail_domain_dkim_keys WHERE domain_id = $1 ORDER BY algorithm DESC
+	`, domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []dkimPublicKey
+	for rows.Next() {
+		var k dkimPublicKey
+		if err := rows.Scan(&k.Selector, &k.Algorithm, &k.PublicKey, &k.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+type dkimSigner struct {
+	domain   string
+	selector string
+	key      crypto.Signer
+}
+
+// loadDKIMSigners — clés du domaine (actif) ; ex = h.dbex (tenant courant) ou h.db (MTA interne).
+func loadDKIMSigners(ex dbExec, domain string) ([]dkimSigner, error) {
+	rows, err := ex.Query(`
+		SELECT k.selector, k.private_key_encrypted
+		FROM mail_domain_dkim_keys k
+		JOIN mail_domains d ON d.id = k.domain_id
+		WHERE LOWER(d.domain) = $1 AND COALESCE(d.is_active, true)
+		ORDER BY k.algorithm DESC
+	`, strings.ToLower(strings.TrimSpace(domain)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []dkimSigner
+	for rows.Next() {
+		var selector, enc string
+		if err := rows.Scan(&selector, &enc); err != nil {
+			return nil, err
+		}
+		plain, err := decryptPassword(enc)
+		if err != nil || plain == "" {
+			return nil, fmt.Errorf("clé DKIM %s indéchiffrable", selector)
+		}
+		key, err := parseDKIMPrivateKey(plain)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, dkimSigner{domain: strings.ToLower(domain), selector: selector, key: key})
+	}
+	return out, rows.Err()
+}
+
+// dkimSignatureHeaders signe avec les clés de domain ; "" si le domaine n'en a pas.
+func dkimSignatureHeaders(ex dbExec, raw []byte, domain string, now time.Time) (string, error) {
+	signers, err := loadDKIMSigners(ex, domain)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	for _, s := range signers {
+		h, err := dkimSign(raw, s.domain, s.selector, s.key, now)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(h)
+	}
+	return out.String(), nil
+}
+
+// platformMTAHost : host (relais SMTP de l'envoi) est le MTA Cloudity du domaine — mta_hostname,
+// mx_target ou mail.<domaine> par défaut — et non un relais saisi par l'utilisateur.
+func platformMTAHost(host string, d Domain) bool {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	if host == "" {
+		return false
+	}
+	for _, h := range []string{d.MTAHostname, d.MXTarget, mailDomainMXHost(d)} {
+		if h = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(h)), "."); h != "" && h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// dkimSendingDomain renvoie le domaine hébergé avec lequel signer un envoi du compte accountEmail
+// sous l'adresse from, "" si la signature n'est pas due : from doit être la boîte hébergée du
+// compte (mail_mailboxes) ou un alias de domaine (mail_aliases) livré à ce compte. Les
+// conditions sont explicites : le tenant n'est pas épinglé et le rôle de service peut
+// contourner la RLS.
+func dkimSendingDomain(ex dbExec, accountEmail, from, smtpHost string) (string, error) {
+	from = strings.ToLower(strings.TrimSpace(from))
+	account := strings.ToLower(strings.TrimSpace(accountEmail))
+	at := strings.LastIndex(from, "@")
+	if at <= 0 || at == len(from)-1 {
+		return "", nil
+	}
+	local, domain := from[:at], from[at+1:]
+	var d Domain
+	err := ex.QueryRow(`
+		SELECT d.domain, COALESCE(d.mta_hostname, ''), COALESCE(d.mx_target, '')
+		FROM mail_domains d
+		WHERE LOWER(d.domain) = $1 AND COALESCE(d.is_active, true)
+		  AND (
+		    ($3 = $4 AND EXISTS (
+		      SELECT 1 FROM mail_mailboxes m
+		      WHERE m.domain_id = d.id AND LOWER(m.local_part) = $2 AND COALESCE(m.is_active, true)
+		    ))
+		    OR EXISTS (
+		      SELECT 1 FROM mail_aliases a
+		      WHERE a.domain_id = d.id AND LOWER(a.source_local) = $2
+		        AND LOWER(TRIM(a.destination)) = $4
+		        AND (a.expires_at IS NULL OR a.expires_at > now())
+		    )
+		  )
+		LIMIT 1
+	`, domain, local, from, account).Scan(&d.Domain, &d.MTAHostname, &d.MXTarget)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !platformMTAHost(smtpHost, d) {
+		return "", nil
+	}
+	return strings.ToLower(d.Domain), nil
+}
+
+// signOutgoingDKIM ajoute les signatures du domaine From quand dkimSendingDomain l'autorise
+// (boîte ou alias du compte, relayé par notre MTA) ; un échec n'empêche pas l'envoi.
+func signOutgoingDKIM(ex dbExec, raw []byte, accountEmail, from, smtpHost string) []byte {
+	domain, err := dkimSendingDomain(ex, accountEmail, from, smtpHost)
+	if err != nil {
+		log.Printf("[mail] signature DKIM: %v (envoi non signé)", err)
+		return raw
+	}
+	if domain == "" {
+		return raw
+	}
+	headers, err := dkimSignatureHeaders(ex, raw, domain, time.Now())
+	if err != nil {
+		log.Printf("[mail] signature DKIM: %v (envoi non signé)", err)
+		return raw
+	}
+	if headers == "" {
+		return raw
+	}
+	return append([]byte(headers), raw...)
+}
+
+// ---------- Enregistrements DNS ----------
+
+// mailDNSRecord — enregistrement à publier ; Status / Found remplis par /dns/check.
+type mailDNSRecord struct {
+	Purpose  string   `json:"purpose"`
+	Type     string   `json:"type"`
+	Name     string   `json:"name"`
+	Value    string   `json:"value"`
+	Priority int      `json:"priority,omitempty"`
+	Zone     string   `json:"zone"`
+	Status   string   `json:"status,omitempty"`
+	Found    []string `json:"found,omitempty"`
+}
+
+func mailDomainMXHost(d Domain) string {
+	for _, h := range []string{d.MXTarget, d.MTAHostname} {
+		if h = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(h)), "."); h != "" {
+			return h
+		}
+	}
+	return "mail." + d.Domain
+}
+
+// mtaSTSPolicy — contenu de https://mta-sts.<domaine>/.well-known/mta-sts.txt (RFC 8461 §3.2) ;
+// mode testing tant que les rapports TLS-RPT n'ont pas été lus.
+func mtaSTSPolicy(d Domain) string {
+	return fmt.Sprintf("version: STSv1\nmode: testing\nmx: %s\nmax_age: %d\n", mailDomainMXHost(d), mtaSTSMaxAge)
+}
+
+// zoneTXT découpe une valeur TXT en chaînes de 255 octets au plus (RFC 1035 §3.3.14).
+func zoneTXT(value string) string {
+	var parts []string
+	for len(value) > 255 {
+		parts = append(parts, strconv.Quote(value[:255]))
+		value = value[255:]
+	}
+	return strings.Join(append(parts, strconv.Quote(value)), " ")
+}
+
+func mailDNSRecords(d Domain, keys []dkimPublicKey) []mailDNSRecord {
+	mx := mailDomainMXHost(d)
+	spf := strings.TrimSpace(d.SPFPolicy)
+	if !strings.HasPrefix(strings.ToLower(spf), "v=spf1") {
+		spf = "v=spf1 mx a:" + mx + " -all"
+	}
+	policy := mtaSTSPolicy(d)
+	policySum := sha256.Sum256([]byte(policy))
+	txt := func(purpose, name, value string) mailDNSRecord {
+		return mailDNSRecord{Purpose: purpose, Type: "TXT", Name: name, Value: value,
+			Zone: fmt.Sprintf("%s. %d IN TXT %s", name, mailDNSTTL, zoneTXT(value))}
+	}
+	recs := []mailDNSRecord{
+		{Purpose: "mx", Type: "MX", Name: d.Domain, Value: mx, Priority: 10,
+			Zone: fmt.Sprintf("%s. %d IN MX 10 %s.", d.Domain, mailDNSTTL, mx)},
+		txt("spf", d.Domain, spf),
+	}
+	for _, k := range keys {
+		recs = append(recs, txt("dkim", k.Selector+"._domainkey."+d.Domain, k.txtValue()))
+	}
+	recs = append(recs,
+		txt("dmarc", "_dmarc."+d.Domain, "v=DMARC1; p="+normalizeDMARCPolicy(d.DMARCPolicy)+"; adkim=r; aspf=r"),
+		txt("mta-sts", "_mta-sts."+d.Domain, "v=STSv1; id="+hex.EncodeToString(policySum[:8])),
+		mailDNSRecord{Purpose: "mta-sts", Type: "CNAME", Name: "mta-sts." + d.Domain, Value: mx,
+			Zone: fmt.Sprintf("mta-sts.%s. %d IN CNAME %s.", d.Domain, mailDNSTTL, mx)},
+		txt("tls-rpt", "_smtp._tls."+d.Domain, "v=TLSRPTv1; rua=mailto:postmaster@"+d.Domain),
+	)
+	return recs
+}
+
+// txtFamily — préfixe qui identifie, parmi les TXT d'un nom, ceux qui concernent l'enregistrement
+// ("" pour DKIM : le nom <sélecteur>._domainkey lui est propre et v= y est facultatif).
+func txtFamily(purpose string) string {
+	switch purpose {
+	case "spf":
+		return "v=spf1"
+	case "dmarc":
+		return "v=dmarc1"
+	case "mta-sts":
+		return "v=stsv1"
+	case "tls-rpt":
+		return "v=tlsrptv1"
+	}
+	return ""
+}
+
+// sameTagList compare deux tag-lists (DKIM, DMARC, MTA-STS, TLS-RPT) sans tenir compte de
+// l'ordre, des espaces ni de la casse des valeurs hors p= (clé base64).
+func sameTagList(a, b string) bool {
+	ta, errA := parseDKIMTags(a)
+	tb, errB := parseDKIMTags(b)
+	if errA != nil || errB != nil || len(ta) != len(tb) {
+		return false
+	}
+	for k, va := range ta {
+		vb, ok := tb[k]
+		if k == "p" {
+			va, vb = strings.ReplaceAll(va, " ", ""), strings.ReplaceAll(vb, " ", "")
+			if va != vb {
+				return false
+			}
+			continue
+		}
+		if !ok || !strings.EqualFold(va, vb) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkMailDNSRecords compare chaque enregistrement attendu aux réponses du résolveur :
+// ok, missing, mismatch (publié mais différent) ou error (échec DNS temporaire).
+func checkMailDNSRecords(ctx context.Context, dns mailAuthResolver, recs []mailDNSRecord) {
+	for i := range recs {
+		r := &recs[i]
+		switch r.Type {
+		case "MX":
+			mxs, err := dns.LookupMX(ctx, r.Name)
+			if err != nil {
+				r.Status = dnsCheckErr(err)
+				continue
+			}
+			r.Status = "mismatch"
+			for _, mx := range mxs {
+				host := strings.TrimSuffix(strings.ToLower(mx.Host), ".")
+				r.Found = append(r.Found, host)
+				if host == r.Value {
+					r.Status = "ok"
+				}
+			}
+		case "CNAME":
+			// CNAME ou A / AAAA directs : il suffit que le nom mène aux adresses du MX.
+			got, err := dns.LookupIPAddr(ctx, r.Name)
+			if err != nil {
+				r.Status = dnsCheckErr(err)
+				continue
+			}
+			want, err := dns.LookupIPAddr(ctx, r.Value)
+			if err != nil {
+				r.Status = dnsCheckErr(err)
+				continue
+			}
+			wantIPs := map[string]bool{}
+			for _, a := range want {
+				wantIPs[a.IP.String()] = true
+			}
+			r.Status = "mismatch"
+			for _, a := range got {
+				r.Found = append(r.Found, a.IP.String())
+				if wantIPs[a.IP.String()] {
+					r.Status = "ok"
+				}
+			}
+		case "TXT":
+			txts, err := dns.LookupTXT(ctx, r.Name)
+			if err != nil {
+				r.Status = dnsCheckErr(err)
+				continue
+			}
+			family := txtFamily(r.Purpose)
+			for _, t := range txts {
+				if l := strings.ToLower(strings.TrimSpace(t)); family == "" || l == family || strings.HasPrefix(l, family+" ") || strings.HasPrefix(l, family+";") {
+					r.Found = append(r.Found, t)
+				}
+			}
+			switch {
+			case len(r.Found) == 0:
+				r.Status = "missing"
+			case len(r.Found) > 1:
+				// deux SPF / DMARC publiés = permerror chez les destinataires
+				r.Status = "mismatch"
+			case r.Purpose == "spf":
+				r.Status = "mismatch"
+				if strings.EqualFold(strings.Join(strings.Fields(r.Found[0]), " "), strings.Join(strings.Fields(r.Value), " ")) {
+					r.Status = "ok"
+				}
+			default:
+				r.Status = "mismatch"
+				if sameTagList(r.Found[0], r.Value) {
+					r.Status = "ok"
+				}
+			}
+		}
+	}
+}
+
+func dnsCheckErr(err error) string {
+	if dnsNotFound(err) {
+		return "missing"
+	}
+	return "error"
+}
+
+// ---------- Handlers ----------
+
+func (h *Handler) loadMailDomain(ex dbExec, domainID int) (Domain, error) {
+	var d Domain
+	err := ex.QueryRow(`
+		SELECT id, tenant_id, domain, is_active,
+			COALESCE(role, 'standard'),
+			COALESCE(mta_enabled, false),
+			COALESCE(mta_provider, 'maddy'),
+			COALESCE(mta_hostname, ''),
+			COALESCE(mx_target, ''),
+			COALESCE(spf_policy, ''),
+			COALESCE(dkim_selector, ''),
+			COALESCE(dmarc_policy, '')
+		FROM mail_domains WHERE id = $1
+	`, domainID).Scan(&d.ID, &d.TenantID, &d.Domain, &d.IsActive,
+		&d.Role, &d.MTAEnabled, &d.MTAProvider, &d.MTAHostname, &d.MXTarget,
+		&d.SPFPolicy, &d.DKIMSelector, &d.DMARCPolicy)
+	d.Domain = strings.ToLower(d.Domain)
+	return d, err
+}
+
+// mailDomainDNS — réponse commune de /dns et /dns/check.
+func (h *Handler) mailDomainDNS(c *gin.Context, check bool) {
+	ctx := c.Request.Context()
+	domainID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || domainID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid domain id"})
+		return
+	}
+	d, err := h.loadMailDomain(h.dbex(ctx), domainID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "domain not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	keys, err := loadDKIMPublicKeys(h.dbex(ctx), domainID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recs := mailDNSRecords(d, keys)
+	out := gin.H{
+		"domain":         d.Domain,
+		"records":        recs,
+		"dkim_keys":      keys,
+		"mta_sts_policy": mtaSTSPolicy(d),
+	}
+	if check {
+		lookupCtx, cancel := context.WithTimeout(ctx, mailAuthTimeout)
+		defer cancel()
+		checkMailDNSRecords(lookupCtx, mailAuthDNS, recs)
+		ok := len(keys) > 0
+		for _, r := range recs {
+			ok = ok && r.Status == "ok"
+		}
+		out["ok"] = ok
+		out["checked_at"] = time.Now().UTC().Format(time.RFC3339)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// GET /mail/domains/:id/dns — MX, SPF, DKIM, DMARC, MTA-STS et TLS-RPT à publier.
+func (h *Handler) getMailDomainDNS(c *gin.Context) { h.mailDomainDNS(c, false) }
+
+// GET /mail/domains/:id/dns/check — mêmes enregistrements comparés au DNS public.
+func (h *Handler) checkMailDomainDNS(c *gin.Context) { h.mailDomainDNS(c, true) }
+
+// POST /mail/domains/:id/dkim — génère (ou renouvelle) les paires RSA-2048 et Ed25519.
+func (h *Handler) generateMailDomainDKIM(c *gin.Context) {
+	ctx := c.Request.Context()
+	domainID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || domainID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid domain id"})
+		return
+	}
+	d, err := h.loadMailDomain(h.dbex(ctx), domainID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "domain not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	pairs, err := generateDKIMKeys(d.DKIMSelector, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	for _, p := range pairs {
+		enc, err := encryptPassword(p.PrivatePEM)
+		if err != nil || enc == "" {
+			log.Printf("[mail] chiffrement clé DKIM: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "impossible de stocker la clé DKIM (clé de chiffrement manquante ou invalide)"})
+			return
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO mail_domain_dkim_keys (domain_id, selector, algorithm, private_key_encrypted, public_key)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (domain_id, algorithm) DO UPDATE SET
+				selector = EXCLUDED.selector,
+				private_key_encrypted = EXCLUDED.private_key_encrypted,
+				public_key = EXCLUDED.public_key,
+				created_at = CURRENT_TIMESTAMP
+		`, domainID, p.Selector, p.Algorithm, enc, p.PublicKey); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	keys := make([]dkimPublicKey, 0, len(pairs))
+	for _, p := range pairs {
+		keys = append(keys, dkimPublicKey{Selector: p.Selector, Algorithm: p.Algorithm, PublicKey: p.PublicKey})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Algorithm > keys[j].Algorithm })
+	c.JSON(http.StatusCreated, gin.H{"ok": true, "dkim_keys": keys, "records": mailDNSRecords(d, keys)})
+}
+
+// POST /mail/internal/dkim/sign — signatures pour un message relayé par l'alias-router (hors JWT).
+// Toujours d= domaine de l'alias qui relaie, jamais celui du From : pour un message entrant,
+// le From est choisi par l'expéditeur et nos clés ne doivent pas l'authentifier.
+func (h *Handler) internalDKIMSign(c *gin.Context) {
+	if !mtaInternalTokenOK(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing MTA internal token"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, dkimMaxSignBytes)
+	var body struct {
+		Domain  string `json:"domain"`
+		Message []byte `json:"message"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || len(body.Message) == 0 || strings.TrimSpace(body.Domain) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	domain := strings.ToLower(strings.TrimSpace(body.Domain))
+	if at := strings.LastIndexByte(domain, '@'); at >= 0 {
+		domain = domain[at+1:]
+	}
+	headers, err := dkimSignatureHeaders(h.db, body.Message, domain, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"signed": headers != "", "headers": headers})
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDKIMSignRoundTrip(t *testing.T) {
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	pairs, err := generateDKIMKeys("Cloudity", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 2 || pairs[0].Selector != "cloudity-rsa-20261019" || pairs[1].Selector != "cloudity-ed25519-20261019" {
+		t.Fatalf("clés: %+v", pairs)
+	}
+	dns := &fakeAuthDNS{txt: map[string][]string{}}
+	for _, p := range pairs {
+		pub := dkimPublicKey{Selector: p.Selector, Algorithm: p.Algorithm, PublicKey: p.PublicKey}
+		dns.txt[p.Selector+"._domainkey.partenaire.fr"] = []string{pub.txtValue()}
+	}
+	raw := "Message-ID: <1@partenaire.fr>\r\n" + authTestMessage
+	var headers string
+	for _, p := range pairs {
+		key, err := parseDKIMPrivateKey(p.PrivatePEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := dkimSign([]byte(raw), "partenaire.fr", p.Selector, key, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(h, "h=from:subject:date:to:message-id:from;") {
+			t.Errorf("%s: en-têtes signés: %s", p.Algorithm, h)
+		}
+		headers += h
+	}
+	check := func(msg string) []string {
+		fields, body := splitRawMessage([]byte(msg))
+		var out []string
+		for _, f := range fields {
+			if strings.EqualFold(f.Name, "DKIM-Signature") {
+				out = append(out, verifyDKIMSignature(context.Background(), dns, fields, body, f, now).Result)
+			}
+		}
+		return out
+	}
+	if got := check(headers + raw); strings.Join(got, ",") != "pass,pass" {
+		t.Errorf("double signature: %v", got)
+	}
+	// From sur-signé : un From ajouté au-dessus après signature invalide le message.
+	if got := check(headers + "From: pdg@partenaire.fr\r\n" + raw); strings.Join(got, ",") != "fail,fail" {
+		t.Errorf("From ajouté: %v", got)
+	}
+	if r := verifyMailAuth(context.Background(), dns, []byte(headers+raw), now); r.DKIM != "pass" || len(r.DKIMDomains) != 2 {
+		t.Errorf("verifyMailAuth: %+v", r)
+	}
+}
+
+func TestMailDNSRecords(t *testing.T) {
+	d := Domain{Domain: "partenaire.fr", DMARCPolicy: "quarantine"}
+	keys := []dkimPublicKey{
+		{Selector: "cloudity-rsa-20261019", Algorithm: "rsa", PublicKey: strings.Repeat("A", 392)},
+		{Selector: "cloudity-ed25519-20261019", Algorithm: "ed25519", PublicKey: "11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="},
+	}
+	recs := mailDNSRecords(d, keys)
+	byName := map[string]mailDNSRecord{}
+	for _, r := range recs {
+		byName[r.Type+" "+r.Name] = r
+	}
+	want := map[string]string{
+		"MX partenaire.fr":                                       "mail.partenaire.fr",
+		"TXT partenaire.fr":                                      "v=spf1 mx a:mail.partenaire.fr -all",
+		"TXT _dmarc.partenaire.fr":                               "v=DMARC1; p=quarantine; adkim=r; aspf=r",
+		"CNAME mta-sts.partenaire.fr":                            "mail.partenaire.fr",
+		"TXT _smtp._tls.partenaire.fr":                           "v=TLSRPTv1; rua=mailto:postmaster@partenaire.fr",
+		"TXT cloudity-ed25519-20261019._domainkey.partenaire.fr": "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=",
+	}
+	for k, v := range want {
+		if byName[k].Value != v {
+			t.Errorf("%s: got %q, want %q", k, byName[k].Value, v)
+		}
+	}
+	if z := byName["MX partenaire.fr"].Zone; z != "partenaire.fr. 3600 IN MX 10 mail.partenaire.fr." {
+		t.Errorf("zone MX: %s", z)
+	}
+	if z := byName["TXT cloudity-rsa-20261019._domainkey.partenaire.fr"].Zone; strings.Count(z, `"`) != 4 {
+		t.Errorf("TXT DKIM RSA non découpé en chaînes de 255: %s", z)
+	}
+	if sts := byName["TXT _mta-sts.partenaire.fr"].Value; !strings.HasPrefix(sts, "v=STSv1; id=") || len(sts) != len("v=STSv1; id=")+16 {
+		t.Errorf("MTA-STS: %q", sts)
+	}
+	custom := mailDNSRecords(Domain{Domain: "partenaire.fr", MXTarget: "MX.Relais.net.", SPFPolicy: "v=spf1 include:relais.net -all"}, nil)
+	if custom[0].Value != "mx.relais.net" || custom[1].Value != "v=spf1 include:relais.net -all" {
+		t.Errorf("valeurs du domaine ignorées: %+v", custom[:2])
+	}
+	if !strings.Contains(mtaSTSPolicy(Domain{Domain: "partenaire.fr"}), "mx: mail.partenaire.fr\n") {
+		t.Errorf("politique MTA-STS: %q", mtaSTSPolicy(Domain{Domain: "partenaire.fr"}))
+	}
+}
+
+func TestCheckMailDNSRecords(t *testing.T) {
+	d := Domain{Domain: "partenaire.fr"}
+	keys := []dkimPublicKey{{Selector: "s1", Algorithm: "ed25519", PublicKey: "AAAA"}}
+	recs := mailDNSRecords(d, keys)
+	dns := &fakeAuthDNS{
+		txt: map[string][]string{
+			"partenaire.fr":               {"v=spf1  MX a:mail.partenaire.fr -all", "google-site-verification=x"},
+			"s1._domainkey.partenaire.fr": {"k=ed25519; v=DKIM1; p=AA AA"},
+			"_dmarc.partenaire.fr":        {"v=DMARC1; p=reject"},
+			"_mta-sts.partenaire.fr":      {"v=STSv1; id=ancien"},
+		},
+		ip: map[string][]string{
+			"mail.partenaire.fr":    {"203.0.113.5"},
+			"mta-sts.partenaire.fr": {"203.0.113.5"},
+		},
+		mx: map[string][]string{"partenaire.fr": {"mail.partenaire.fr"}},
+	}
+	checkMailDNSRecords(context.Background(), dns, recs)
+	want := map[string]string{"mx": "ok", "spf": "ok", "dkim": "ok", "dmarc": "mismatch", "tls-rpt": "missing"}
+	for _, r := range recs {
+		if w, ok := want[r.Purpose]; ok && r.Status != w {
+			t.Errorf("%s %s: got %s (%v), want %s", r.Purpose, r.Name, r.Status, r.Found, w)
+		}
+		if r.Purpose == "mta-sts" {
+			w := map[string]string{"TXT": "mismatch", "CNAME": "ok"}[r.Type]
+			if r.Status != w {
+				t.Errorf("mta-sts %s: got %s, want %s", r.Type, r.Status, w)
+			}
+		}
+	}
+	dns.txt["partenaire.fr"] = append(dns.txt["partenaire.fr"], "v=spf1 -all")
+	recs = mailDNSRecords(d, keys)
+	checkMailDNSRecords(context.Background(), dns, recs)
+	if recs[1].Purpose != "spf" || recs[1].Status != "mismatch" || len(recs[1].Found) != 2 {
+		t.Errorf("deux SPF publiés: %+v", recs[1])
+	}
+}
+
+func TestMailDomainDKIMRoutes(t *testing.T) {
+	r := setupRouter(nil)
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/mail/domains/1/dns"},
+		{http.MethodGet, "/mail/domains/1/dns/check"},
+		{http.MethodPost, "/mail/domains/1/dkim"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("X-Tenant-ID", "1")
+		req.Header.Set("X-User-ID", "1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s sans rôle admin: got %d, want 403", tc.method, tc.path, w.Code)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/mail/domains/abc/dns", nil)
+	setAdminMailHeaders(req)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("id invalide: got %d", w.Code)
+	}
+}
+
+func TestInternalDKIMSign(t *testing.T) {
+	t.Setenv("MTA_INTERNAL_TOKEN", "test-mta-secret-token-32chars")
+	r := setupRouter(nil)
+	for _, tc := range []struct {
+		token, body string
+		want        int
+	}{
+		{"", `{"domain":"alias.cloudity.test","message":"RnJvbTogYUBiDQoNCng="}`, http.StatusUnauthorized},
+		{"test-mta-secret-token-32chars", `{"domain":"","message":"RnJvbTogYUBiDQoNCng="}`, http.StatusBadRequest},
+		{"test-mta-secret-token-32chars", `{"domain":"alias.cloudity.test"}`, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/mail/internal/dkim/sign", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		if tc.token != "" {
+			req.Header.Set("X-MTA-Internal-Token", tc.token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.body, w.Code, tc.want)
+		}
+	}
+}
+
+func TestPlatformMTAHost(t *testing.T) {
+	d := Domain{Domain: "hosted.example", MTAHostname: "MX1.Cloudity.example."}
+	for _, tc := range []struct {
+		host string
+		want bool
+	}{
+		{"mx1.cloudity.example", true},
+		{"mail.hosted.example", false}, // mta_hostname configuré : pas de repli sur mail.<domaine>
+		{"smtp.gmail.com", false},
+		{"relay.attacker.example", false},
+		{"", false},
+	} {
+		if got := platformMTAHost(tc.host, d); got != tc.want {
+			t.Errorf("platformMTAHost(%q) = %v, want %v", tc.host, got, tc.want)
+		}
+	}
+	if !platformMTAHost("mail.hosted.example", Domain{Domain: "hosted.example"}) {
+		t.Error("default mail.<domain> MTA not recognised")
+	}
+}
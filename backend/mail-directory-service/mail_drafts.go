@@ -0,0 +1,417 @@
+package main
+
+// mail_drafts.go — brouillons côté serveur (enregistrement automatique du formulaire de rédaction).
+//
+// Un brouillon est une ligne mail_messages du dossier « drafts » qui porte la composition
+// complète : To/Cc/Bcc, texte, HTML, fil (In-Reply-To / References) et pièces jointes
+// (mail_message_attachments, contenu complet). À chaque enregistrement, le message MIME est
+// déposé par IMAP APPEND (drapeaux \Draft \Seen) dans le dossier Brouillons du compte, résolu
+// comme les autres dossiers spéciaux (imapCandidatesForAccountFolder : SPECIAL-USE \Drafts puis
+// noms usuels), et la version précédente est supprimée : Thunderbird et le mobile voient le
+// même brouillon. Le Message-ID reste stable d'une version à l'autre ; la synchronisation IMAP
+// réconcilie la ligne par internet_msg_id. Si IMAP est injoignable, le brouillon reste en base
+// (message_uid négatif) et sera déposé au prochain enregistrement.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message/mail"
+	"github.com/gin-gonic/gin"
+)
+
+type mailDraftAttachment struct {
+	ID          int    `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	SizeBytes   int    `json:"size_bytes"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+type mailDraft struct {
+	ID          int                   `json:"id"`
+	AccountID   int                   `json:"account_id"`
+	FromEmail   string                `json:"from_email"`
+	To          string                `json:"to"`
+	Cc          string                `json:"cc"`
+	Bcc         string                `json:"bcc"`
+	Subject     string                `json:"subject"`
+	Text        string                `json:"text"`
+	HTML        string                `json:"html"`
+	InReplyTo   string                `json:"in_reply_to"`
+	References  string                `json:"references"`
+	UpdatedAt   string                `json:"updated_at"`
+	IMAPSynced  bool                  `json:"imap_synced"`
+	IMAPError   string                `json:"imap_error,omitempty"`
+	Attachments []mailDraftAttachment `json:"attachments"`
+}
+
+// draftParams lit :id (compte) et :draftId.
+func draftParams(c *gin.Context) (accountID, draftID int, ok bool) {
+	accountID, _ = strconv.Atoi(c.Param("id"))
+	draftID, _ = strconv.Atoi(c.Param("draftId"))
+	if accountID <= 0 || draftID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id invalide"})
+		return 0, 0, false
+	}
+	return accountID, draftID, true
+}
+
+func (h *Handler) getMailDraft(c *gin.Context) {
+	accountID, draftID, ok := draftParams(c)
+	if !ok {
+		return
+	}
+	d, err := h.loadMailDraft(c.Request.Context(), accountID, draftID)
+	if errors.Is(err, errMailMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "brouillon introuvable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, d)
+}
+
+func (h *Handler) createMailDraft(c *gin.Context) {
+	accountID, _ := strconv.Atoi(c.Param("id"))
+	if accountID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return
+	}
+	var req mailComposeRequest
+	if err := bindMailCompose(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "composition invalide"})
+		return
+	}
+	req.AccountID = accountID
+	req.DraftID = 0
+	h.saveMailDraft(c, &req, 0)
+}
+
+func (h *Handler) updateMailDraft(c *gin.Context) {
+	accountID, draftID, ok := draftParams(c)
+	if !ok {
+		return
+	}
+	var req mailComposeRequest
+	if err := bindMailCompose(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "composition invalide"})
+		return
+	}
+	req.AccountID = accountID
+	req.DraftID = draftID
+	h.saveMailDraft(c, &req, draftID)
+}
+
+func (h *Handler) deleteMailDraft(c *gin.Context) {
+	accountID, draftID, ok := draftParams(c)
+	if !ok {
+		return
+	}
+	err := h.deleteDraft(c.Request.Context(), accountID, draftID)
+	if errors.Is(err, errMailMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "brouillon introuvable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// saveMailDraft crée (draftID = 0) ou remplace un brouillon, puis le dépose dans Brouillons (IMAP).
+func (h *Handler) saveMailDraft(c *gin.Context, req *mailComposeRequest, draftID int) {
+	ctx := c.Request.Context()
+	var accountEmail string
+	err := h.dbex(ctx).QueryRow(`
+		SELECT email FROM user_email_accounts
+		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER
+	`, req.AccountID).Scan(&accountEmail)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "compte non trouvé"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	m, err := h.resolveComposition(ctx, req)
+	if err != nil {
+		writeComposeError(c, err)
+		return
+	}
+	from, err := h.resolveFromAddress(ctx, req.AccountID, accountEmail, req.FromEmail)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	refs := referencesHeader(m.References)
+	threadKey := threadKeyFromHeaders("", m.InReplyTo, refs)
+	var msgID string
+	status := http.StatusOK
+	if draftID == 0 {
+		status = http.StatusCreated
+		msgID = normalizeMessageID(generateOutboundMessageID(from))
+		err = tx.QueryRow(`
+			INSERT INTO mail_messages (
+				account_id, folder, message_uid, from_addr, to_addrs, cc_addrs, bcc_addrs, subject,
+				body_plain, body_html, date_at, is_read, internet_msg_id, in_reply_to, references_header,
+				thread_key, attachment_count
+			)
+			VALUES ($1, 'drafts', $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), NOW(), true, $10, $11,
+				NULLIF($12, ''), $13, $14)
+			RETURNING id
+		`, req.AccountID, -time.Now().UnixNano(), from, addressListString(m.To), addressListString(m.Cc),
+			addressListString(m.Bcc), m.Subject, m.Text, m.HTML, msgID, m.InReplyTo, refs,
+			coalesceString(threadKey, msgID), len(m.Attachments)).Scan(&draftID)
+	} else {
+		err = tx.QueryRow(`
+			UPDATE mail_messages
+			SET from_addr = $3, to_addrs = $4, cc_addrs = $5, bcc_addrs = $6, subject = $7,
+				body_plain = NULLIF($8, ''), body_html = NULLIF($9, ''), date_at = NOW(),
+				in_reply_to = $10, references_header = NULLIF($11, ''),
+				thread_key = CASE WHEN $12 <> '' THEN $12 ELSE thread_key END,
+				attachment_count = $13
+			WHERE id = $1 AND account_id = $2 AND LOWER(TRIM(folder)) = 'drafts'
+			AND account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
+			RETURNING internet_msg_id
+		`, draftID, req.AccountID, from, addressListString(m.To), addressListString(m.Cc),
+			addressListString(m.Bcc), m.Subject, m.Text, m.HTML, m.InReplyTo, refs, threadKey,
+			len(m.Attachments)).Scan(&msgID)
+		if err == nil {
+			err = pruneDraftAttachments(tx, draftID, req.DraftAttachmentIDs)
+		}
+	}
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "brouillon introuvable"})
+		return
+	}
+	if err == nil {
+		err = storeComposeAttachments(tx, draftID, newDraftAttachments(m.Attachments))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Brouillon importé d'un autre client sans Message-ID : on lui en attribue un pour le suivre.
+	if msgID == "" {
+		msgID = normalizeMessageID(generateOutboundMessageID(from))
+		_, _ = h.dbex(ctx).Exec(`UPDATE mail_messages SET internet_msg_id = $3 WHERE id = $1 AND account_id = $2`, draftID, req.AccountID, msgID)
+	}
+	m.From = &mail.Address{Address: from}
+	m.MessageID = "<" + msgID + ">"
+	m.Date = time.Now()
+	imapErr := h.appendDraftToIMAP(ctx, req.AccountID, draftID, m)
+	if imapErr != nil {
+		log.Printf("[mail] draft %d IMAP APPEND: %v", draftID, imapErr)
+	}
+	d, err := h.loadMailDraft(ctx, req.AccountID, draftID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if imapErr != nil {
+		d.IMAPError = imapErr.Error()
+	}
+	c.JSON(status, d)
+}
+
+// pruneDraftAttachments supprime les pièces jointes du brouillon absentes de keep
+// (les pièces conservées gardent leur id : le client peut continuer à les référencer).
+func pruneDraftAttachments(tx *sql.Tx, draftID int, keep []int) error {
+	rows, err := tx.Query(`SELECT id FROM mail_message_attachments WHERE message_id = $1`, draftID)
+	if err != nil {
+		return err
+	}
+	kept := make(map[int]bool, len(keep))
+	for _, id := range keep {
+		kept[id] = true
+	}
+	var drop []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		if !kept[id] {
+			drop = append(drop, id)
+		}
+	}
+	rows.Close()
+	for _, id := range drop {
+		if _, err := tx.Exec(`DELETE FROM mail_message_attachments WHERE id = $1 AND message_id = $2`, id, draftID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newDraftAttachments — pièces jointes à enregistrer (hors celles déjà stockées dans le brouillon).
+func newDraftAttachments(atts []outgoingAttachment) []outgoingAttachment {
+	var out []outgoingAttachment
+	for _, a := range atts {
+		if a.DraftAttachmentID == 0 {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func coalesceString(s, fallback string) string {
+	if s != "" {
+		return s
+	}
+	return fallback
+}
+
+// appendDraftToIMAP dépose la version courante dans Brouillons puis retire les versions
+// précédentes (même Message-ID) ; l'UID obtenu devient message_uid de la ligne.
+func (h *Handler) appendDraftToIMAP(ctx context.Context, accountID, draftID int, m *outgoingMessage) error {
+	raw, err := buildDraftMIME(m)
+	if err != nil {
+		return err
+	}
+	_, ic, err := h.imapDialAndLogin(ctx, accountID, "")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = ic.Logout() }()
+	_, newUID, err := h.imapAppendToFolder(ctx, accountID, ic, "drafts", []string{imap.DraftFlag, imap.SeenFlag}, m.Date, raw, m.MessageID, true)
+	if err != nil {
+		return err
+	}
+	if newUID == 0 {
+		// Serveur sans SEARCH HEADER : le brouillon est déposé, la sync retrouvera son UID.
+		return nil
+	}
+	if _, err := h.dbex(ctx).Exec(`
+		UPDATE mail_messages SET message_uid = $3
+		WHERE id = $1 AND account_id = $2
+		AND account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
+	`, draftID, accountID, int64(newUID)); err != nil {
+		return fmt.Errorf("UID du brouillon: %w", err)
+	}
+	return nil
+}
+
+// deleteDraft supprime le brouillon du dossier Brouillons IMAP (s'il y a été déposé) puis en base.
+func (h *Handler) deleteDraft(ctx context.Context, accountID, draftID int) error {
+	var messageUID int64
+	err := h.dbex(ctx).QueryRow(`
+		SELECT message_uid FROM mail_messages
+		WHERE id = $1 AND account_id = $2 AND LOWER(TRIM(folder)) = 'drafts'
+		AND account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
+	`, draftID, accountID).Scan(&messageUID)
+	if err == sql.ErrNoRows {
+		return errMailMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if messageUID > 0 && messageUID <= maxIMAPUID {
+		_, ic, err := h.imapDialAndLogin(ctx, accountID, "")
+		if err != nil {
+			return err
+		}
+		defer func() { _ = ic.Logout() }()
+		mb, err := h.imapResolveSourceMailbox(ctx, accountID, ic, "drafts", uint32(messageUID))
+		if err == nil {
+			if _, err = ic.Select(mb, false); err == nil {
+				seqset := new(imap.SeqSet)
+				seqset.AddNum(uint32(messageUID))
+				storeItem := imap.FormatFlagsOp(imap.AddFlags, true)
+				if err = ic.UidStore(seqset, storeItem, []interface{}{imap.DeletedFlag}, nil); err == nil {
+					err = ic.Expunge(nil)
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("suppression IMAP du brouillon: %w", err)
+		}
+	}
+	res, err := h.dbex(ctx).Exec(`
+		DELETE FROM mail_messages
+		WHERE id = $1 AND account_id = $2 AND LOWER(TRIM(folder)) = 'drafts'
+		AND account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
+	`, draftID, accountID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errMailMessageNotFound
+	}
+	return nil
+}
+
+// loadMailDraft relit un brouillon (composition + métadonnées des pièces jointes).
+func (h *Handler) loadMailDraft(ctx context.Context, accountID, draftID int) (*mailDraft, error) {
+	d := &mailDraft{ID: draftID, AccountID: accountID, Attachments: []mailDraftAttachment{}}
+	var messageUID int64
+	var updatedAt sql.NullTime
+	err := h.dbex(ctx).QueryRow(`
+		SELECT from_addr, to_addrs, cc_addrs, bcc_addrs, subject, COALESCE(body_plain, ''), COALESCE(body_html, ''),
+			in_reply_to, COALESCE(references_header, ''), date_at, message_uid
+		FROM mail_messages
+		WHERE id = $1 AND account_id = $2 AND LOWER(TRIM(folder)) = 'drafts'
+		AND account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
+	`, draftID, accountID).Scan(&d.FromEmail, &d.To, &d.Cc, &d.Bcc, &d.Subject, &d.Text, &d.HTML,
+		&d.InReplyTo, &d.References, &updatedAt, &messageUID)
+	if err == sql.ErrNoRows {
+		return nil, errMailMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.IMAPSynced = messageUID > 0
+	if updatedAt.Valid {
+		d.UpdatedAt = updatedAt.Time.UTC().Format(time.RFC3339)
+	}
+	rows, err := h.dbex(ctx).Query(`
+		SELECT id, filename, content_type, size_bytes, content_id
+		FROM mail_message_attachments WHERE message_id = $1 ORDER BY part_ordinal
+	`, draftID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a mailDraftAttachment
+		if err := rows.Scan(&a.ID, &a.Filename, &a.ContentType, &a.SizeBytes, &a.ContentID); err != nil {
+			return nil, err
+		}
+		d.Attachments = append(d.Attachments, a)
+	}
+	return d, rows.Err()
+}
+
+// discardSentDraft retire le brouillon d'origine une fois le message envoyé ou programmé
+// (échec journalisé : l'envoi a déjà eu lieu).
+func (h *Handler) discardSentDraft(ctx context.Context, accountID, draftID int) {
+	if draftID <= 0 {
+		return
+	}
+	if err := h.deleteDraft(ctx, accountID, draftID); err != nil && !errors.Is(err, errMailMessageNotFound) {
+		log.Printf("[mail] discard draft %d after send: %v", draftID, err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+)
+
+func TestMailDraftRoutesRequireAuth(t *testing.T) {
+	r := setupRouter(nil)
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodPost, "/mail/me/accounts/1/drafts"},
+		{http.MethodGet, "/mail/me/accounts/1/drafts/2"},
+		{http.MethodPut, "/mail/me/accounts/1/drafts/2"},
+		{http.MethodDelete, "/mail/me/accounts/1/drafts/2"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without X-Tenant-ID: got %d", tc.method, tc.path, w.Code)
+		}
+	}
+}
+
+func TestMailDraftRoutesRejectInvalidIDs(t *testing.T) {
+	r := setupRouter(nil)
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodPost, "/mail/me/accounts/abc/drafts"},
+		{http.MethodGet, "/mail/me/accounts/1/drafts/0"},
+		{http.MethodPut, "/mail/me/accounts/1/drafts/x"},
+		{http.MethodDelete, "/mail/me/accounts/-1/drafts/2"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		setAdminMailHeaders(req)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: got %d %s", tc.method, tc.path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestNewDraftAttachments(t *testing.T) {
+	got := newDraftAttachments([]outgoingAttachment{
+		{Filename: "kept.pdf", DraftAttachmentID: 4},
+		{Filename: "direct.txt"},
+		{Filename: "drive.png"},
+	})
+	if len(got) != 2 || got[0].Filename != "direct.txt" || got[1].Filename != "drive.png" {
+		t.Errorf("newDraftAttachments = %+v", got)
+	}
+}
+
+// Le brouillon déposé dans Brouillons garde Bcc ; l'envoi le retire.
+func TestDraftMIMEKeepsBcc(t *testing.T) {
+	m := &outgoingMessage{
+		To:      []*mail.Address{{Address: "a@x.fr"}},
+		Bcc:     []*mail.Address{{Name: "Chef", Address: "secret@x.fr"}, {Address: "archive@x.fr"}},
+		Subject: "Brouillon",
+		Text:    "À finir",
+	}
+	m.stamp("moi@x.fr")
+	raw, err := buildDraftMIME(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcc, err := mr.Header.AddressList("Bcc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range bcc {
+		got = append(got, a.Address)
+	}
+	if strings.Join(got, ",") != "secret@x.fr,archive@x.fr" {
+		t.Fatalf("draft Bcc = %v", got)
+	}
+	parsed, err := parseRFC822Mail(raw)
+	if err != nil || parsed.Plain != "À finir" {
+		t.Fatalf("parsed draft = %+v, %v", parsed, err)
+	}
+	sent, err := buildOutgoingMIME(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(sent), "secret@x.fr") {
+		t.Error("Bcc must be stripped from the sent message")
+	}
+}
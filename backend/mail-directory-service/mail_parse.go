@@ -70,6 +70,8 @@ type mailParsedResult struct {
 	RawHeaders  string
 	Meta        mailParsedMeta
 	Attachments []mailAttachmentParsed
+	// Auth : verdicts SPF / DKIM / DMARC (authenticateParsed), nil si non vérifié.
+	Auth *mailAuthResult
 }
 
 // extractRawMIMEHeaders renvoie le bloc d’en-têtes (avant la première ligne vide), tronqué pour la base.
@@ -0,0 +1,1461 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/gin-gonic/gin"
+)
+
+// Moteur de règles Mail (MAIL-RULES-02) : conditions imbriquées ET / OU / NON et actions
+// ordonnées. Les règles historiques (colonnes from_pattern … action_folder) sont compilées vers
+// la même représentation : une feuille ET, leurs actions, puis « stop » (premier match gagnant).
+
+const (
+	maxRuleConditionDepth = 8
+	maxRuleConditionNodes = 64
+	maxRuleActions        = 16
+	maxRuleValueLen       = 1000
+	// Messages téléchargés (BODY.PEEK[]) par passe quand une règle lit en-têtes ou corps.
+	ruleContentFetchLimit = 50
+	ruleContentMaxBytes   = 25 << 20
+	// Transferts, réponses automatiques et webhooks exécutés par passe (le reste attend la suivante).
+	ruleSideEffectLimit = 20
+	// Les effets externes ne visent que le courrier récent arrivé après la dernière modification.
+	ruleSideEffectMaxAge  = 7 * 24 * time.Hour
+	ruleAutoReplyInterval = 4 * 24 * time.Hour
+	ruleDryRunDefaultScan = 500
+	ruleDryRunMaxScan     = 5000
+	ruleDryRunMaxResults  = 100
+	ruleWebhookTimeout    = 10 * time.Second
+)
+
+// ruleCondition — nœud de l'arbre de conditions : Op (and / or / not) ou feuille Field + Match.
+type ruleCondition struct {
+	Op         string          `json:"op,omitempty"`
+	Conditions []ruleCondition `json:"conditions,omitempty"`
+	Field      string          `json:"field,omitempty"`
+	Header     string          `json:"header,omitempty"`
+	Match      string          `json:"match,omitempty"`
+	Value      string          `json:"value,omitempty"`
+	Number     *int64          `json:"number,omitempty"`
+	Bool       *bool           `json:"bool,omitempty"`
+	TagID      int             `json:"tag_id,omitempty"`
+
+	re *regexp.Regexp
+	at time.Time
+}
+
+// ruleAction — action exécutée dans l'ordre quand la règle correspond.
+type ruleAction struct {
+	Type    string `json:"type"`
+	Folder  string `json:"folder,omitempty"`
+	TagID   int    `json:"tag_id,omitempty"`
+	To      string `json:"to,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+}
+
+var ruleTextMatches = map[string]bool{"contains": true, "equals": true, "starts_with": true, "ends_with": true, "regex": true, "exists": true}
+
+var ruleHeaderNameRe = regexp.MustCompile(`^[a-z0-9!#$%&'*+.^_|~-]+$`)
+
+// compile normalise et valide l'arbre (profondeur, nombre de nœuds, regex, dates).
+func (c *ruleCondition) compile(depth int, nodes *int) error {
+	*nodes++
+	if depth > maxRuleConditionDepth {
+		return fmt.Errorf("conditions : profondeur maximale %d", maxRuleConditionDepth)
+	}
+	if *nodes > maxRuleConditionNodes {
+		return fmt.Errorf("conditions : %d nœuds maximum", maxRuleConditionNodes)
+	}
+	c.Op = strings.ToLower(strings.TrimSpace(c.Op))
+	switch c.Op {
+	case "and", "or":
+		if len(c.Conditions) == 0 {
+			return fmt.Errorf("conditions : %q sans sous-condition", c.Op)
+		}
+	case "not":
+		if len(c.Conditions) != 1 {
+			return fmt.Errorf("conditions : \"not\" attend exactement une sous-condition")
+		}
+	case "":
+		return c.compileLeaf()
+	default:
+		return fmt.Errorf("conditions : opérateur inconnu %q", c.Op)
+	}
+	for i := range c.Conditions {
+		if err := c.Conditions[i].compile(depth+1, nodes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ruleCondition) compileLeaf() error {
+	c.Field = strings.ToLower(strings.TrimSpace(c.Field))
+	c.Match = strings.ToLower(strings.TrimSpace(c.Match))
+	if len(c.Value) > maxRuleValueLen {
+		return fmt.Errorf("condition %s : valeur trop longue", c.Field)
+	}
+	switch c.Field {
+	case "from", "to", "cc", "subject", "folder", "body", "header", "from_domain":
+		if c.Match == "" {
+			c.Match = "contains"
+			if c.Field == "from_domain" {
+				c.Match = "equals"
+			}
+		}
+		if !ruleTextMatches[c.Match] {
+			return fmt.Errorf("condition %s : comparaison %q invalide", c.Field, c.Match)
+		}
+		if c.Field == "header" {
+			c.Header = strings.ToLower(strings.TrimSpace(c.Header))
+			if !ruleHeaderNameRe.MatchString(c.Header) {
+				return fmt.Errorf("condition header : nom d'en-tête invalide")
+			}
+		}
+		if c.Field == "from_domain" && c.Match == "equals" {
+			c.Value = normalizeFromDomainPattern(c.Value)
+		}
+		if c.Match != "exists" && strings.TrimSpace(c.Value) == "" {
+			return fmt.Errorf("condition %s : valeur requise", c.Field)
+		}
+		if c.Match == "regex" {
+			re, err := regexp.Compile("(?i)" + c.Value)
+			if err != nil {
+				return fmt.Errorf("condition %s : expression régulière invalide", c.Field)
+			}
+			c.re = re
+		}
+	case "size":
+		if c.Match != "gt" && c.Match != "lt" {
+			return fmt.Errorf("condition size : comparaison gt ou lt attendue")
+		}
+		if c.Number == nil || *c.Number < 0 {
+			return fmt.Errorf("condition size : nombre d'octets requis")
+		}
+	case "date":
+		switch c.Match {
+		case "before", "after":
+			t, ok := parseRuleDate(c.Value)
+			if !ok {
+				return fmt.Errorf("condition date : date invalide (AAAA-MM-JJ ou RFC 3339)")
+			}
+			c.at = t
+		case "older_than_days", "newer_than_days":
+			if c.Number == nil || *c.Number <= 0 {
+				return fmt.Errorf("condition date : nombre de jours requis")
+			}
+		default:
+			return fmt.Errorf("condition date : comparaison %q invalide", c.Match)
+		}
+	case "has_attachments":
+		if c.Bool == nil {
+			return fmt.Errorf("condition has_attachments : booléen requis")
+		}
+	case "has_tag":
+		if c.TagID <= 0 {
+			return fmt.Errorf("condition has_tag : tag_id requis")
+		}
+	default:
+		return fmt.Errorf("condition : champ inconnu %q", c.Field)
+	}
+	return nil
+}
+
+func parseRuleDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true
+	}
+	return parseFlexibleTimestamp(s)
+}
+
+func (c *ruleCondition) tagIDs(out []int) []int {
+	if c.Field == "has_tag" {
+		out = append(out, c.TagID)
+	}
+	for i := range c.Conditions {
+		out = c.Conditions[i].tagIDs(out)
+	}
+	return out
+}
+
+// ruleMessage — message évalué ; en-têtes et corps sont chargés à la demande (load).
+type ruleMessage struct {
+	messageForRules
+	ID        int
+	UID       int64
+	Folder    string
+	CcAddrs   string
+	SizeBytes int64
+	Date      time.Time
+	CreatedAt time.Time
+	IsRead    bool
+	IsStarred bool
+
+	headers map[string][]string
+	body    string
+	loaded  bool
+	missing bool
+	load    func(m *ruleMessage)
+}
+
+// setContent renseigne en-têtes et corps ; sans en-têtes bruts, From / To / Cc / Subject
+// connus en base restent interrogeables.
+func (m *ruleMessage) setContent(rawHeaders, plain, html string) {
+	m.loaded = true
+	if strings.TrimSpace(rawHeaders) != "" {
+		m.headers = parseSieveHeaderBlock(rawHeaders)
+	} else {
+		m.headers = map[string][]string{}
+		for k, v := range map[string]string{"from": m.FromAddr, "to": m.ToAddrs, "cc": m.CcAddrs, "subject": m.Subject} {
+			if v != "" {
+				m.headers[k] = []string{v}
+			}
+		}
+	}
+	m.body = plain
+	if strings.TrimSpace(m.body) == "" && html != "" {
+		m.body = htmlToPlainText(html)
+	}
+	m.missing = strings.TrimSpace(rawHeaders) == "" && plain == "" && html == ""
+}
+
+func (m *ruleMessage) content() (map[string][]string, string, bool) {
+	if !m.loaded {
+		m.loaded = true
+		if m.load != nil {
+			m.load(m)
+		}
+	}
+	return m.headers, m.body, !m.missing
+}
+
+func (c *ruleCondition) matches(m *ruleMessage, now time.Time) bool {
+	switch c.Op {
+	case "and":
+		for i := range c.Conditions {
+			if !c.Conditions[i].matches(m, now) {
+				return false
+			}
+		}
+		return true
+	case "or":
+		for i := range c.Conditions {
+			if c.Conditions[i].matches(m, now) {
+				return true
+			}
+		}
+		return false
+	case "not":
+		return !c.Conditions[0].matches(m, now)
+	}
+	switch c.Field {
+	case "from":
+		return c.matchText(m.FromAddr)
+	case "to":
+		return c.matchText(m.ToAddrs)
+	case "cc":
+		return c.matchText(m.CcAddrs)
+	case "subject":
+		return c.matchText(m.Subject)
+	case "folder":
+		return c.matchText(m.Folder)
+	case "from_domain":
+		if c.Match == "equals" {
+			return ruleFromDomainMatches(m.FromAddr, c.Value)
+		}
+		return c.matchText(strings.TrimRight(spamExtractEmailDomain(strings.ToLower(m.FromAddr)), " \t>"))
+	case "header":
+		headers, _, _ := m.content()
+		values := headers[c.Header]
+		if c.Match == "exists" {
+			return len(values) > 0
+		}
+		for _, v := range values {
+			if c.matchText(v) {
+				return true
+			}
+		}
+		return false
+	case "body":
+		_, body, ok := m.content()
+		return ok && c.matchText(body)
+	case "size":
+		if c.Match == "gt" {
+			return m.SizeBytes > *c.Number
+		}
+		return m.SizeBytes > 0 && m.SizeBytes < *c.Number
+	case "date":
+		if m.Date.IsZero() {
+			return false
+		}
+		switch c.Match {
+		case "before":
+			return m.Date.Before(c.at)
+		case "after":
+			return m.Date.After(c.at)
+		case "older_than_days":
+			return now.Sub(m.Date) > time.Duration(*c.Number)*24*time.Hour
+		default:
+			return now.Sub(m.Date) < time.Duration(*c.Number)*24*time.Hour
+		}
+	case "has_attachments":
+		return (m.AttachmentCount > 0) == *c.Bool
+	case "has_tag":
+		_, ok := m.TagIDs[c.TagID]
+		return ok
+	}
+	return false
+}
+
+func (c *ruleCondition) matchText(s string) bool {
+	switch c.Match {
+	case "exists":
+		return strings.TrimSpace(s) != ""
+	case "regex":
+		return c.re != nil && c.re.MatchString(s)
+	}
+	s, v := strings.ToLower(s), strings.ToLower(c.Value)
+	switch c.Match {
+	case "equals":
+		return strings.TrimSpace(s) == strings.TrimSpace(v)
+	case "starts_with":
+		return strings.HasPrefix(strings.TrimSpace(s), v)
+	case "ends_with":
+		return strings.HasSuffix(strings.TrimSpace(s), v)
+	}
+	return strings.Contains(s, v)
+}
+
+// ruleActionHasSideEffect — actions sortant de la boîte, exécutées une seule fois par message.
+func ruleActionHasSideEffect(t string) bool {
+	return t == "forward" || t == "auto_reply" || t == "webhook"
+}
+
+// compileRuleActions normalise et valide la forme des actions (dossiers et étiquettes sont
+// vérifiés contre le compte par checkRuleReferences).
+func compileRuleActions(actions []ruleAction) error {
+	if len(actions) == 0 {
+		return fmt.Errorf("au moins une action")
+	}
+	if len(actions) > maxRuleActions {
+		return fmt.Errorf("%d actions maximum", maxRuleActions)
+	}
+	seen := map[string]bool{}
+	for i := range actions {
+		a := &actions[i]
+		a.Type = strings.ToLower(strings.TrimSpace(a.Type))
+		if ruleActionHasSideEffect(a.Type) {
+			if seen[a.Type] {
+				return fmt.Errorf("action %s : une seule par règle", a.Type)
+			}
+			seen[a.Type] = true
+		}
+		switch a.Type {
+		case "move":
+			a.Folder = strings.TrimSpace(a.Folder)
+			if a.Folder == "" {
+				return fmt.Errorf("action move : dossier requis")
+			}
+			if isStandardMailFolder(a.Folder) {
+				a.Folder = strings.ToLower(a.Folder)
+			}
+		case "add_tag":
+			if a.TagID <= 0 {
+				return fmt.Errorf("action add_tag : tag_id requis")
+			}
+		case "mark_read", "mark_unread", "star", "delete", "stop":
+		case "forward":
+			list, err := mail.ParseAddressList(strings.TrimSpace(a.To))
+			if err != nil || len(list) == 0 {
+				return fmt.Errorf("action forward : destinataire invalide")
+			}
+			if len(list) > 5 {
+				return fmt.Errorf("action forward : 5 destinataires maximum")
+			}
+		case "auto_reply":
+			if strings.TrimSpace(a.Body) == "" {
+				return fmt.Errorf("action auto_reply : texte de réponse requis")
+			}
+			if len(a.Body) > 20000 || len(a.Subject) > 500 {
+				return fmt.Errorf("action auto_reply : réponse trop longue")
+			}
+		case "webhook":
+			u, err := url.Parse(strings.TrimSpace(a.URL))
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+				return fmt.Errorf("action webhook : URL http(s) requise")
+			}
+			a.URL = u.String()
+		default:
+			return fmt.Errorf("action inconnue %q", a.Type)
+		}
+	}
+	return nil
+}
+
+// compiledMailRule — règle prête à évaluer (nouveau format ou colonnes historiques).
+type compiledMailRule struct {
+	ID         int
+	Name       string
+	UpdatedAt  time.Time
+	Conditions ruleCondition
+	Actions    []ruleAction
+}
+
+// legacyRuleDefinition traduit les colonnes historiques en conditions / actions.
+func legacyRuleDefinition(r *MailFilterRule) (ruleCondition, []ruleAction) {
+	root := ruleCondition{Op: "and"}
+	leaf := func(field, value string) {
+		if strings.TrimSpace(value) != "" {
+			root.Conditions = append(root.Conditions, ruleCondition{Field: field, Match: "contains", Value: value})
+		}
+	}
+	leaf("from", r.FromPattern)
+	if dom := normalizeFromDomainPattern(r.FromDomainPattern); dom != "" {
+		root.Conditions = append(root.Conditions, ruleCondition{Field: "from_domain", Match: "equals", Value: dom})
+	}
+	leaf("to", r.RecipientPattern)
+	leaf("subject", r.SubjectPattern)
+	if r.HasAttachments != nil {
+		v := *r.HasAttachments
+		root.Conditions = append(root.Conditions, ruleCondition{Field: "has_attachments", Bool: &v})
+	}
+	if r.HasTagID != nil && *r.HasTagID > 0 {
+		root.Conditions = append(root.Conditions, ruleCondition{Field: "has_tag", TagID: *r.HasTagID})
+	}
+	folder := strings.TrimSpace(r.ActionFolder)
+	if folder == "" {
+		folder = "inbox"
+	}
+	actions := []ruleAction{{Type: "move", Folder: folder}}
+	if r.MarkRead != nil {
+		if *r.MarkRead {
+			actions = append(actions, ruleAction{Type: "mark_read"})
+		} else {
+			actions = append(actions, ruleAction{Type: "mark_unread"})
+		}
+	}
+	if r.AddTagID != nil && *r.AddTagID > 0 {
+		actions = append(actions, ruleAction{Type: "add_tag", TagID: *r.AddTagID})
+	}
+	actions = append(actions, ruleAction{Type: "stop"})
+	return root, actions
+}
+
+// decodeRuleDefinition extrait conditions / actions de criteria_json et actions_json.
+func decodeRuleDefinition(criteriaJSON, actionsJSON string) (*ruleCondition, []ruleAction) {
+	var crit struct {
+		Conditions *ruleCondition `json:"conditions"`
+	}
+	var acts struct {
+		Actions []ruleAction `json:"actions"`
+	}
+	_ = json.Unmarshal([]byte(criteriaJSON), &crit)
+	_ = json.Unmarshal([]byte(actionsJSON), &acts)
+	return crit.Conditions, acts.Actions
+}
+
+// compileMailRule construit la règle évaluable ; les parties absentes du nouveau format
+// retombent sur les colonnes historiques.
+func compileMailRule(r *MailFilterRule) (*compiledMailRule, error) {
+	legacyCond, legacyActions := legacyRuleDefinition(r)
+	out := &compiledMailRule{ID: r.ID, Name: r.Name, Conditions: legacyCond, Actions: legacyActions}
+	if t, ok := parseFlexibleTimestamp(r.UpdatedAt); ok {
+		out.UpdatedAt = t
+	}
+	// l'arbre historique est construit déjà normalisé (ET vide = tout message, comme avant)
+	if r.Conditions != nil {
+		out.Conditions = *r.Conditions
+		nodes := 0
+		if err := out.Conditions.compile(0, &nodes); err != nil {
+			return nil, err
+		}
+	}
+	if r.Actions != nil {
+		out.Actions = append([]ruleAction(nil), r.Actions...)
+	}
+	if err := compileRuleActions(out.Actions); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ruleEffect — action à effet externe retenue pour un message.
+type ruleEffect struct {
+	Rule   *compiledMailRule
+	Action ruleAction
+}
+
+// rulePlan — état final d'un message après évaluation de toutes les règles.
+type rulePlan struct {
+	Folder  string
+	Read    bool
+	Starred bool
+	AddTags []int
+	Effects []ruleEffect
+	Matched []*compiledMailRule
+	// Actions décrit chaque action retenue (« move:archive », « forward:a@x.fr »…) pour le dry-run.
+	Actions []string
+}
+
+// planRuleActions évalue les règles dans l'ordre ; chaque règle voit l'état laissé par les
+// précédentes (dossier, étiquettes) et « stop » interrompt l'évaluation.
+func planRuleActions(rules []*compiledMailRule, m *ruleMessage, now time.Time) rulePlan {
+	p := rulePlan{Folder: m.Folder, Read: m.IsRead, Starred: m.IsStarred}
+	tags := map[int]struct{}{}
+	for id := range m.TagIDs {
+		tags[id] = struct{}{}
+	}
+	view := *m
+	view.TagIDs = tags
+	for _, r := range rules {
+		view.Folder = p.Folder
+		view.IsRead = p.Read
+		matched := r.Conditions.matches(&view, now)
+		// le chargement paresseux du contenu reste acquis pour les règles suivantes et l'appelant
+		m.headers, m.body, m.loaded, m.missing = view.headers, view.body, view.loaded, view.missing
+		if !matched {
+			continue
+		}
+		p.Matched = append(p.Matched, r)
+		stop := false
+		for _, a := range r.Actions {
+			desc := a.Type
+			switch a.Type {
+			case "move":
+				p.Folder = a.Folder
+				desc += ":" + a.Folder
+			case "delete":
+				p.Folder = "trash"
+			case "mark_read":
+				p.Read = true
+			case "mark_unread":
+				p.Read = false
+			case "star":
+				p.Starred = true
+			case "add_tag":
+				if _, ok := tags[a.TagID]; !ok {
+					tags[a.TagID] = struct{}{}
+					p.AddTags = append(p.AddTags, a.TagID)
+				}
+				desc += fmt.Sprintf(":%d", a.TagID)
+			case "forward":
+				desc += ":" + strings.TrimSpace(a.To)
+				p.Effects = append(p.Effects, ruleEffect{Rule: r, Action: a})
+			case "webhook":
+				if u, err := url.Parse(a.URL); err == nil {
+					desc += ":" + u.Host
+				}
+				p.Effects = append(p.Effects, ruleEffect{Rule: r, Action: a})
+			case "auto_reply":
+				p.Effects = append(p.Effects, ruleEffect{Rule: r, Action: a})
+			case "stop":
+				stop = true
+			}
+			if a.Type != "stop" {
+				p.Actions = append(p.Actions, desc)
+			}
+			if stop {
+				break
+			}
+		}
+		if stop {
+			break
+		}
+	}
+	return p
+}
+
+// loadMailFilterRules lit les règles du compte (RLS : compte de l'utilisateur courant).
+func (h *Handler) loadMailFilterRules(ctx context.Context, accountID int, enabledOnly bool) ([]MailFilterRule, error) {
+	q := `
+		SELECT id, account_id, name, from_pattern, from_domain_pattern, recipient_pattern, has_tag_id, add_tag_id, subject_pattern, has_attachments, action_folder, mark_read, enabled, rule_order, criteria_json::text, actions_json::text, created_at::text, updated_at::text
+		FROM mail_filter_rules
+		WHERE account_id = $1
+		  AND account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)`
+	if enabledOnly {
+		q += ` AND enabled = TRUE
+		ORDER BY rule_order ASC, id ASC`
+	} else {
+		q += `
+		ORDER BY rule_order ASC, id DESC`
+	}
+	rows, err := h.dbex(ctx).Query(q, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []MailFilterRule
+	for rows.Next() {
+		var r MailFilterRule
+		var hasAtt, markRead sql.NullBool
+		var hasTag, addTag sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.AccountID, &r.Name, &r.FromPattern, &r.FromDomainPattern, &r.RecipientPattern, &hasTag, &addTag, &r.SubjectPattern, &hasAtt, &r.ActionFolder, &markRead, &r.Enabled, &r.RuleOrder, &r.CriteriaJSON, &r.ActionsJSON, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			continue
+		}
+		if hasAtt.Valid {
+			v := hasAtt.Bool
+			r.HasAttachments = &v
+		}
+		if markRead.Valid {
+			v := markRead.Bool
+			r.MarkRead = &v
+		}
+		if hasTag.Valid {
+			v := int(hasTag.Int64)
+			r.HasTagID = &v
+		}
+		if addTag.Valid {
+			v := int(addTag.Int64)
+			r.AddTagID = &v
+		}
+		r.Conditions, r.Actions = decodeRuleDefinition(r.CriteriaJSON, r.ActionsJSON)
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// checkRuleReferences vérifie dossiers et étiquettes référencés par la règle pour ce compte.
+func (h *Handler) checkRuleReferences(ctx context.Context, accountID int, cond *ruleCondition, actions []ruleAction) error {
+	var tagIDs []int
+	if cond != nil {
+		tagIDs = cond.tagIDs(nil)
+	}
+	for _, a := range actions {
+		switch a.Type {
+		case "add_tag":
+			tagIDs = append(tagIDs, a.TagID)
+		case "move":
+			if !h.folderAllowed(ctx, accountID, a.Folder) {
+				return fmt.Errorf("dossier d'action invalide")
+			}
+		case "forward":
+			if ruleForwardTargetsOwn(a.To, h.accountOwnAddresses(ctx, accountID)) {
+				return fmt.Errorf("action forward : impossible de transférer vers une adresse du compte")
+			}
+		}
+	}
+	for _, id := range tagIDs {
+		var okTag int
+		if err := h.dbex(ctx).QueryRow(`SELECT id FROM mail_tags WHERE id=$1 AND account_id=$2`, id, accountID).Scan(&okTag); err != nil {
+			return fmt.Errorf("étiquette %d invalide", id)
+		}
+	}
+	return nil
+}
+
+// loadRuleMessages lit les métadonnées des messages du compte, les plus récents d'abord
+// (limit <= 0 : tous). Le contenu est chargé à la demande par ruleMessage.load.
+func (h *Handler) loadRuleMessages(ctx context.Context, accountID, limit int) ([]*ruleMessage, error) {
+	q := `
+		SELECT id, COALESCE(message_uid, 0), COALESCE(from_addr, ''), COALESCE(to_addrs, ''), COALESCE(cc_addrs, ''), COALESCE(subject, ''),
+			COALESCE(attachment_count, 0), COALESCE(folder, ''), COALESCE(is_read, FALSE), COALESCE(is_starred, FALSE),
+			COALESCE(size_bytes, 0), date_at::text, COALESCE(created_at::text, '')
+		FROM mail_messages
+		WHERE account_id = $1 AND COALESCE(scheduled_status, '') = ''
+		ORDER BY id DESC`
+	args := []interface{}{accountID}
+	if limit > 0 {
+		q += ` LIMIT $2`
+		args = append(args, limit)
+	}
+	rows, err := h.dbex(ctx).Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []*ruleMessage
+	byID := map[int]*ruleMessage{}
+	for rows.Next() {
+		m := &ruleMessage{}
+		var dateAt sql.NullString
+		var createdAt string
+		if err := rows.Scan(&m.ID, &m.UID, &m.FromAddr, &m.ToAddrs, &m.CcAddrs, &m.Subject, &m.AttachmentCount, &m.Folder, &m.IsRead, &m.IsStarred, &m.SizeBytes, &dateAt, &createdAt); err != nil {
+			continue
+		}
+		if dateAt.Valid {
+			m.Date, _ = parseFlexibleTimestamp(dateAt.String)
+		}
+		m.CreatedAt, _ = parseFlexibleTimestamp(createdAt)
+		if m.Date.IsZero() {
+			m.Date = m.CreatedAt
+		}
+		msgs = append(msgs, m)
+		byID[m.ID] = m
+	}
+	rows.Close()
+	tagRows, err := h.dbex(ctx).Query(`
+		SELECT mt.message_id, mt.tag_id
+		FROM mail_message_tags mt
+		INNER JOIN mail_messages m ON m.id = mt.message_id
+		WHERE m.account_id = $1
+	`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer tagRows.Close()
+	for tagRows.Next() {
+		var mid, tid int
+		if err := tagRows.Scan(&mid, &tid); err != nil {
+			continue
+		}
+		if m := byID[mid]; m != nil {
+			if m.TagIDs == nil {
+				m.TagIDs = map[int]struct{}{}
+			}
+			m.TagIDs[tid] = struct{}{}
+		}
+	}
+	return msgs, nil
+}
+
+// loadStoredRuleContent lit en-têtes et corps enregistrés (sans IMAP).
+func (h *Handler) loadStoredRuleContent(ctx context.Context, accountID int, m *ruleMessage) {
+	var raw, plain, html sql.NullString
+	if err := h.dbex(ctx).QueryRow(`
+		SELECT raw_headers, body_plain, body_html FROM mail_messages WHERE id = $1 AND account_id = $2
+	`, m.ID, accountID).Scan(&raw, &plain, &html); err != nil {
+		m.setContent("", "", "")
+		return
+	}
+	m.setContent(raw.String, plain.String, html.String)
+}
+
+// ruleRun — état partagé d'une passe du moteur (connexion IMAP, budgets).
+type ruleRun struct {
+	h            *Handler
+	ctx          context.Context
+	accountID    int
+	ic           *client.Client
+	imapDown     bool
+	fetchBudget  int
+	effectBudget int
+	ownAddrs     map[string]bool
+}
+
+func (run *ruleRun) imapClient() *client.Client {
+	if run.ic == nil && !run.imapDown {
+		_, ic, err := run.h.imapDialAndLogin(run.ctx, run.accountID, "")
+		if err != nil {
+			run.imapDown = true
+			log.Printf("[mail-rules] IMAP indisponible account=%d: %v", run.accountID, err)
+			return nil
+		}
+		run.ic = ic
+	}
+	return run.ic
+}
+
+func (run *ruleRun) close() {
+	if run.ic != nil {
+		_ = run.ic.Logout()
+	}
+}
+
+// loadContent : base d'abord, puis téléchargement IMAP (dans la limite du budget de la passe).
+func (run *ruleRun) loadContent(m *ruleMessage) {
+	run.h.loadStoredRuleContent(run.ctx, run.accountID, m)
+	if !m.missing || m.UID <= 0 || m.UID > maxIMAPUID || run.fetchBudget <= 0 || m.SizeBytes > ruleContentMaxBytes {
+		return
+	}
+	run.fetchBudget--
+	ic := run.imapClient()
+	if ic == nil {
+		return
+	}
+	raw, err := imapFetchRawByUID(run.ctx, run.h, run.accountID, ic, m.Folder, uint32(m.UID), "BODY.PEEK[]")
+	if err != nil {
+		log.Printf("[mail-rules] contenu IMAP account=%d msg=%d: %v", run.accountID, m.ID, err)
+		return
+	}
+	parsed, err := parseRFC822Mail(raw)
+	if err != nil {
+		return
+	}
+	if len(parsed.RawHeaders) == 0 {
+		parsed.RawHeaders = extractRawMIMEHeaders(raw)
+	}
+	authenticateParsed(run.ctx, m.Folder, raw, parsed)
+	if err := run.h.storeParsedMail(run.ctx, run.accountID, m.ID, parsed); err != nil {
+		log.Printf("[mail-rules] enregistrement contenu msg=%d: %v", m.ID, err)
+	}
+	m.setContent(parsed.RawHeaders, parsed.Plain, parsed.HTML)
+}
+
+// imapFetchRawByUID télécharge une section (BODY.PEEK[] ou BODY.PEEK[HEADER]) d'un message via
+// une connexion déjà ouverte.
+func imapFetchRawByUID(ctx context.Context, h *Handler, accountID int, ic *client.Client, dbFolder string, uid uint32, section string) ([]byte, error) {
+	mailbox, err := h.imapResolveSourceMailbox(ctx, accountID, ic, dbFolder, uid)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ic.Select(mailbox, true); err != nil {
+		return nil, err
+	}
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- ic.UidFetch(seqset, []imap.FetchItem{imap.FetchItem(section)}, messages)
+	}()
+	var raw []byte
+	for msg := range messages {
+		for _, lit := range msg.Body {
+			if lit != nil && raw == nil {
+				raw, _ = io.ReadAll(lit)
+			}
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("message UID %d vide ou introuvable", uid)
+	}
+	return raw, nil
+}
+
+// accountOwnAddresses — adresse du compte et alias enregistrés (minuscules).
+func (h *Handler) accountOwnAddresses(ctx context.Context, accountID int) map[string]bool {
+	out := map[string]bool{}
+	rows, err := h.dbex(ctx).Query(`
+		SELECT email FROM user_email_accounts WHERE id = $1
+		UNION ALL
+		SELECT alias_email FROM user_email_aliases WHERE account_id = $1
+	`, accountID)
+	if err != nil {
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var addr string
+		if rows.Scan(&addr) == nil && strings.TrimSpace(addr) != "" {
+			out[strings.ToLower(strings.TrimSpace(addr))] = true
+		}
+	}
+	return out
+}
+
+func (h *Handler) applyMailRulesForAccount(ctx context.Context, accountID int) (int, error) {
+	stored, err := h.loadMailFilterRules(ctx, accountID, true)
+	if err != nil {
+		return 0, err
+	}
+	var rules []*compiledMailRule
+	for i := range stored {
+		r, err := compileMailRule(&stored[i])
+		if err != nil {
+			log.Printf("[mail-rules] règle %d ignorée: %v", stored[i].ID, err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	if len(rules) == 0 {
+		return 0, nil
+	}
+	msgs, err := h.loadRuleMessages(ctx, accountID, 0)
+	if err != nil {
+		return 0, err
+	}
+	run := &ruleRun{h: h, ctx: ctx, accountID: accountID, fetchBudget: ruleContentFetchLimit, effectBudget: ruleSideEffectLimit}
+	defer run.close()
+	now := time.Now()
+	affected := 0
+	for _, m := range msgs {
+		m.load = run.loadContent
+		p := planRuleActions(rules, m, now)
+		if len(p.Matched) == 0 {
+			continue
+		}
+		changed := h.applyRulePlanState(run, m, p)
+		for _, e := range p.Effects {
+			if h.runRuleEffect(run, m, e, now) {
+				changed = true
+			}
+		}
+		if changed {
+			affected++
+		}
+	}
+	return affected, nil
+}
+
+// applyRulePlanState écrit dossier, lu, étoile et étiquettes, puis répercute dossier et \Seen sur IMAP.
+func (h *Handler) applyRulePlanState(run *ruleRun, m *ruleMessage, p rulePlan) bool {
+	ctx, accountID := run.ctx, run.accountID
+	folderChanged := !strings.EqualFold(strings.TrimSpace(m.Folder), strings.TrimSpace(p.Folder))
+	if folderChanged && !h.folderAllowed(ctx, accountID, p.Folder) {
+		log.Printf("[mail-rules] dossier %q refusé account=%d msg=%d", p.Folder, accountID, m.ID)
+		p.Folder, folderChanged = m.Folder, false
+	}
+	changed := false
+	if folderChanged || p.Read != m.IsRead || p.Starred != m.IsStarred {
+		res, err := h.dbex(ctx).Exec(`
+			UPDATE mail_messages
+			SET folder = $1, is_read = $2, is_starred = $3
+			WHERE id = $4 AND account_id = $5
+		`, p.Folder, p.Read, p.Starred, m.ID, accountID)
+		if err == nil {
+			if n, _ := res.RowsAffected(); n > 0 {
+				changed = true
+				if m.UID > 0 && (folderChanged || p.Read != m.IsRead) {
+					if ic := run.imapClient(); ic != nil {
+						if err := h.reconcileMessageStateOnIMAP(ctx, accountID, ic, uint32(m.UID), m.Folder, p.Folder, m.IsRead, p.Read); err != nil {
+							log.Printf("[mail-rules] Réconciliation IMAP échouée account=%d msg=%d uid=%d: %v", accountID, m.ID, m.UID, err)
+						}
+					}
+				}
+			}
+		}
+	}
+	for _, tagID := range p.AddTags {
+		res, err := h.dbex(ctx).Exec(`
+			INSERT INTO mail_message_tags (message_id, tag_id)
+			SELECT $1, t.id FROM mail_tags t
+			WHERE t.id = $2 AND t.account_id = $3
+			ON CONFLICT (message_id, tag_id) DO NOTHING
+		`, m.ID, tagID, accountID)
+		if err == nil {
+			if n, _ := res.RowsAffected(); n > 0 {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// ruleEffectEligible : jamais pour nos propres envois, ni pour le courrier antérieur à la
+// dernière modification de la règle (une règle neuve ne rejoue pas l'historique).
+func ruleEffectEligible(m *ruleMessage, r *compiledMailRule, now time.Time) bool {
+	switch strings.ToLower(m.Folder) {
+	case "sent", "drafts":
+		return false
+	}
+	if m.CreatedAt.IsZero() || r.UpdatedAt.IsZero() || !m.CreatedAt.After(r.UpdatedAt) {
+		return false
+	}
+	return now.Sub(m.Date) < ruleSideEffectMaxAge
+}
+
+// runRuleEffect réserve l'exécution (mail_rule_executions) puis transfère, répond ou appelle
+// le webhook ; l'erreur éventuelle est conservée sur la ligne réservée.
+func (h *Handler) runRuleEffect(run *ruleRun, m *ruleMessage, e ruleEffect, now time.Time) bool {
+	if run.effectBudget <= 0 || !ruleEffectEligible(m, e.Rule, now) {
+		return false
+	}
+	ctx := run.ctx
+	target := ""
+	switch e.Action.Type {
+	case "forward":
+		// Règle antérieure à la validation des cibles, ou transfert revenu : pas de boucle.
+		headers, _, _ := m.content()
+		if ruleForwardTargetsOwn(e.Action.To, run.ownAddrsFor()) || ruleForwardLooped(headers, run.ownAddrsFor()) {
+			return false
+		}
+		target = strings.TrimSpace(e.Action.To)
+	case "webhook":
+		target = e.Action.URL
+	case "auto_reply":
+		headers, _, _ := m.content()
+		if reason := autoReplyBlockedReason(headers, m.FromAddr, run.ownAddrsFor()); reason != "" {
+			return false
+		}
+		if m.Folder == "spam" || m.Folder == "trash" {
+			return false
+		}
+		target = senderAddress(m.FromAddr)
+		var recent int
+		_ = h.dbex(ctx).QueryRow(`
+			SELECT COUNT(*) FROM mail_rule_executions
+			WHERE rule_id = $1 AND action = 'auto_reply' AND target = $2 AND error IS NULL
+			  AND executed_at > $3
+		`, e.Rule.ID, target, now.Add(-ruleAutoReplyInterval)).Scan(&recent)
+		if recent > 0 {
+			return false
+		}
+	}
+	if len(target) > 512 {
+		target = target[:512]
+	}
+	var execID int
+	err := h.dbex(ctx).QueryRow(`
+		INSERT INTO mail_rule_executions (rule_id, message_id, action, target)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (rule_id, message_id, action) DO NOTHING
+		RETURNING id
+	`, e.Rule.ID, m.ID, e.Action.Type, target).Scan(&execID)
+	if err != nil {
+		return false
+	}
+	run.effectBudget--
+	switch e.Action.Type {
+	case "forward":
+		err = h.ruleForward(ctx, run.accountID, m, e.Action)
+	case "auto_reply":
+		err = h.ruleAutoReply(ctx, run.accountID, m, e.Action)
+	case "webhook":
+		err = sendRuleWebhook(ctx, run.accountID, e.Rule, m, e.Action)
+	}
+	if err != nil {
+		log.Printf("[mail-rules] %s règle=%d msg=%d: %v", e.Action.Type, e.Rule.ID, m.ID, err)
+		_, _ = h.dbex(ctx).Exec(`UPDATE mail_rule_executions SET error = $1 WHERE id = $2`, err.Error(), execID)
+	}
+	return true
+}
+
+func (run *ruleRun) ownAddrsFor() map[string]bool {
+	if run.ownAddrs == nil {
+		run.ownAddrs = run.h.accountOwnAddresses(run.ctx, run.accountID)
+	}
+	return run.ownAddrs
+}
+
+func senderAddress(from string) string {
+	if a, err := mail.ParseAddress(strings.TrimSpace(from)); err == nil {
+		return strings.ToLower(a.Address)
+	}
+	return strings.ToLower(strings.Trim(strings.TrimSpace(from), "<>"))
+}
+
+// autoReplyBlockedReason applique RFC 3834 §2 : pas de réponse aux messages automatiques, aux
+// listes ou envois en nombre, aux expéditeurs système ni à nos propres adresses.
+func autoReplyBlockedReason(headers map[string][]string, from string, own map[string]bool) string {
+	first := func(name string) string {
+		if v := headers[name]; len(v) > 0 {
+			return strings.ToLower(strings.TrimSpace(v[0]))
+		}
+		return ""
+	}
+	if v := first("auto-submitted"); v != "" && v != "no" {
+		return "auto-submitted"
+	}
+	switch first("precedence") {
+	case "bulk", "list", "junk":
+		return "precedence"
+	}
+	for _, h := range []string{"list-id", "list-unsubscribe", "list-post", "x-auto-response-suppress", "x-autoreply", "x-autorespond"} {
+		if len(headers[h]) > 0 {
+			return h
+		}
+	}
+	addr := senderAddress(from)
+	if addr == "" || !strings.Contains(addr, "@") {
+		return "sender"
+	}
+	if own[addr] {
+		return "own-address"
+	}
+	local := addr[:strings.LastIndex(addr, "@")]
+	if strings.HasSuffix(local, "-request") {
+		return "system-sender"
+	}
+	for _, p := range []string{"mailer-daemon", "postmaster", "noreply", "no-reply", "no_reply", "donotreply", "do-not-reply", "bounce", "listserv", "majordomo", "owner-"} {
+		if strings.HasPrefix(local, p) {
+			return "system-sender"
+		}
+	}
+	return ""
+}
+
+// prefixSubject ajoute « Re: » / « Fwd: » sans le doubler.
+func prefixSubject(prefix, subject string) string {
+	s := strings.TrimSpace(subject)
+	if strings.HasPrefix(strings.ToLower(s), strings.ToLower(prefix)) {
+		return s
+	}
+	return prefix + " " + s
+}
+
+func (h *Handler) ruleAutoReply(ctx context.Context, accountID int, m *ruleMessage, a ruleAction) error {
+	to, err := mail.ParseAddress(senderAddress(m.FromAddr))
+	if err != nil {
+		return err
+	}
+	var msgID, refs, irt sql.NullString
+	_ = h.dbex(ctx).QueryRow(`
+		SELECT internet_msg_id, references_header, in_reply_to FROM mail_messages WHERE id = $1 AND account_id = $2
+	`, m.ID, accountID).Scan(&msgID, &refs, &irt)
+	subject := strings.TrimSpace(a.Subject)
+	if subject == "" {
+		subject = prefixSubject("Re:", m.Subject)
+	}
+	out := &outgoingMessage{To: []*mail.Address{to}, Subject: subject, Text: a.Body, AutoSubmitted: "auto-replied"}
+	if msgID.String != "" {
+		out.InReplyTo, out.References = threadHeaders(msgID.String, refs.String, irt.String)
+	}
+	raw, err := h.sendOutgoingMail(ctx, accountID, "", "", 0, "", out)
+	if err != nil {
+		return err
+	}
+	h.recordSentMessage(ctx, accountID, 0, out, raw)
+	return nil
+}
+
+// maxRuleForwardHops — au-delà de ce nombre d'en-têtes X-Loop, le message n'est plus transféré.
+const maxRuleForwardHops = 10
+
+// ruleForwardTargetsOwn : un destinataire du transfert est une adresse du compte (boucle immédiate).
+func ruleForwardTargetsOwn(to string, own map[string]bool) bool {
+	list, err := mail.ParseAddressList(strings.TrimSpace(to))
+	if err != nil {
+		return false
+	}
+	for _, a := range list {
+		if own[strings.ToLower(a.Address)] {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleForwardLooped : le message porte un X-Loop d'une adresse du compte (il a déjà été
+// transféré par lui) ou a traversé trop de transferts automatiques.
+func ruleForwardLooped(headers map[string][]string, own map[string]bool) bool {
+	loops := headers["x-loop"]
+	if len(loops) >= maxRuleForwardHops {
+		return true
+	}
+	for _, v := range loops {
+		if own[senderAddress(v)] {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleForwardLoopHeaders — X-Loop du transfert : ceux du message reçu, puis l'adresse du compte.
+func ruleForwardLoopHeaders(headers map[string][]string, account string) []string {
+	var out []string
+	for _, v := range headers["x-loop"] {
+		if addr := senderAddress(v); strings.Contains(addr, "@") {
+			out = append(out, addr)
+		}
+	}
+	return append(out, strings.ToLower(strings.TrimSpace(account)))
+}
+
+func (h *Handler) ruleForward(ctx context.Context, accountID int, m *ruleMessage, a ruleAction) error {
+	to, err := mail.ParseAddressList(strings.TrimSpace(a.To))
+	if err != nil {
+		return err
+	}
+	var account string
+	if err := h.dbex(ctx).QueryRow(`SELECT email FROM user_email_accounts WHERE id = $1`, accountID).Scan(&account); err != nil {
+		return err
+	}
+	headers, body, _ := m.content()
+	var summary strings.Builder
+	summary.WriteString("---------- Message transféré ----------\n")
+	fmt.Fprintf(&summary, "De : %s\n", m.FromAddr)
+	if !m.Date.IsZero() {
+		fmt.Fprintf(&summary, "Date : %s\n", m.Date.UTC().Format(time.RFC1123Z))
+	}
+	fmt.Fprintf(&summary, "Objet : %s\nÀ : %s\n", m.Subject, m.ToAddrs)
+	if m.CcAddrs != "" {
+		fmt.Fprintf(&summary, "Cc : %s\n", m.CcAddrs)
+	}
+	summary.WriteString("\n")
+	summary.WriteString(body)
+	out := &outgoingMessage{To: to, Subject: prefixSubject("Fwd:", m.Subject), Text: summary.String(),
+		AutoSubmitted: "auto-forwarded", XLoop: ruleForwardLoopHeaders(headers, account)}
+	rows, err := h.dbex(ctx).Query(`
+		SELECT COALESCE(filename, ''), COALESCE(content_type, ''), content
+		FROM mail_message_attachments WHERE message_id = $1 AND content IS NOT NULL
+		ORDER BY part_ordinal
+	`, m.ID)
+	if err == nil {
+		var total int64
+		for rows.Next() {
+			var att outgoingAttachment
+			if rows.Scan(&att.Filename, &att.ContentType, &att.Data) != nil {
+				continue
+			}
+			total += int64(len(att.Data))
+			if total > outgoingMaxBytes() {
+				break
+			}
+			out.Attachments = append(out.Attachments, att)
+		}
+		rows.Close()
+	}
+	raw, err := h.sendOutgoingMail(ctx, accountID, "", "", 0, "", out)
+	if err != nil {
+		return err
+	}
+	h.recordSentMessage(ctx, accountID, 0, out, raw)
+	return nil
+}
+
+// ruleWebhookPayload — corps JSON envoyé par l'action webhook.
+type ruleWebhookPayload struct {
+	Event     string             `json:"event"`
+	AccountID int                `json:"account_id"`
+	Rule      ruleWebhookRule    `json:"rule"`
+	Message   ruleWebhookMessage `json:"message"`
+}
+
+type ruleWebhookRule struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type ruleWebhookMessage struct {
+	ID              int    `json:"id"`
+	Folder          string `json:"folder"`
+	From            string `json:"from"`
+	To              string `json:"to"`
+	Cc              string `json:"cc,omitempty"`
+	Subject         string `json:"subject"`
+	DateAt          string `json:"date_at,omitempty"`
+	SizeBytes       int64  `json:"size_bytes"`
+	AttachmentCount int    `json:"attachment_count"`
+}
+
+// ruleWebhookClient refuse les adresses internes (SSRF) sauf MAIL_RULE_WEBHOOK_ALLOW_PRIVATE=1 ;
+// le contrôle porte sur l'IP effectivement contactée, redirections comprises.
+var ruleWebhookClient = &http.Client{
+	Timeout: ruleWebhookTimeout,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || (webhookAddressBlocked(ip) && os.Getenv("MAIL_RULE_WEBHOOK_ALLOW_PRIVATE") != "1") {
+					return fmt.Errorf("webhook : adresse %s refusée", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return errors.New("webhook : trop de redirections")
+		}
+		return nil
+	},
+}
+
+var webhookCGNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func webhookAddressBlocked(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() || webhookCGNAT.Contains(ip)
+}
+
+// ruleWebhookSignature — « sha256=<hex> » : HMAC-SHA256 du corps avec le secret de l'action.
+func ruleWebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func sendRuleWebhook(ctx context.Context, accountID int, r *compiledMailRule, m *ruleMessage, a ruleAction) error {
+	payload := ruleWebhookPayload{
+		Event:     "mail.rule.matched",
+		AccountID: accountID,
+		Rule:      ruleWebhookRule{ID: r.ID, Name: r.Name},
+		Message: ruleWebhookMessage{
+			ID: m.ID, Folder: m.Folder, From: m.FromAddr, To: m.ToAddrs, Cc: m.CcAddrs, Subject: m.Subject,
+			SizeBytes: m.SizeBytes, AttachmentCount: m.AttachmentCount,
+		},
+	}
+	if !m.Date.IsZero() {
+		payload.Message.DateAt = m.Date.UTC().Format(time.RFC3339)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Cloudity-Mail-Rules/1")
+	req.Header.Set("X-Cloudity-Event", payload.Event)
+	if a.Secret != "" {
+		req.Header.Set("X-Cloudity-Signature", ruleWebhookSignature(a.Secret, body))
+	}
+	resp, err := ruleWebhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook : HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// mailRuleDefinitionInput — champs de définition communs à la création et au dry-run.
+type mailRuleDefinitionInput struct {
+	Name              string         `json:"name"`
+	FromPattern       string         `json:"from_pattern"`
+	FromDomainPattern string         `json:"from_domain_pattern"`
+	RecipientPattern  string         `json:"recipient_pattern"`
+	HasTagID          *int           `json:"has_tag_id"`
+	AddTagID          *int           `json:"add_tag_id"`
+	SubjectPattern    string         `json:"subject_pattern"`
+	HasAttachments    *bool          `json:"has_attachments"`
+	ActionFolder      string         `json:"action_folder"`
+	MarkRead          *bool          `json:"mark_read"`
+	Conditions        *ruleCondition `json:"conditions"`
+	Actions           []ruleAction   `json:"actions"`
+}
+
+func (in *mailRuleDefinitionInput) hasLegacyCondition() bool {
+	return strings.TrimSpace(in.FromPattern) != "" || normalizeFromDomainPattern(in.FromDomainPattern) != "" ||
+		strings.TrimSpace(in.RecipientPattern) != "" || strings.TrimSpace(in.SubjectPattern) != "" ||
+		in.HasAttachments != nil || in.HasTagID != nil
+}
+
+// dryRunMailFilterRule évalue une définition de règle sur les messages enregistrés, sans rien
+// modifier ni télécharger : le résultat montre ce que la règle ferait avant son enregistrement.
+func (h *Handler) dryRunMailFilterRule(c *gin.Context) {
+	accountID, ok := parsePositiveParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return
+	}
+	ctx := c.Request.Context()
+	var body struct {
+		mailRuleDefinitionInput
+		Limit int `json:"limit"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body JSON invalide"})
+		return
+	}
+	if body.Conditions == nil && !body.hasLegacyCondition() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "au moins une condition"})
+		return
+	}
+	def := MailFilterRule{
+		Name: body.Name, FromPattern: strings.TrimSpace(body.FromPattern), FromDomainPattern: body.FromDomainPattern,
+		RecipientPattern: strings.TrimSpace(body.RecipientPattern), SubjectPattern: strings.TrimSpace(body.SubjectPattern),
+		HasAttachments: body.HasAttachments, HasTagID: body.HasTagID, AddTagID: body.AddTagID,
+		ActionFolder: body.ActionFolder, MarkRead: body.MarkRead, Conditions: body.Conditions, Actions: body.Actions,
+	}
+	rule, err := compileMailRule(&def)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.checkRuleReferences(ctx, accountID, &rule.Conditions, rule.Actions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit := body.Limit
+	if limit <= 0 {
+		limit = ruleDryRunDefaultScan
+	}
+	if limit > ruleDryRunMaxScan {
+		limit = ruleDryRunMaxScan
+	}
+	msgs, err := h.loadRuleMessages(ctx, accountID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	type dryRunMatch struct {
+		ID      int      `json:"id"`
+		Folder  string   `json:"folder"`
+		From    string   `json:"from"`
+		Subject string   `json:"subject"`
+		DateAt  string   `json:"date_at,omitempty"`
+		Actions []string `json:"actions"`
+	}
+	matches := []dryRunMatch{}
+	matched, unavailable := 0, 0
+	now := time.Now()
+	rules := []*compiledMailRule{rule}
+	for _, m := range msgs {
+		m.load = func(m *ruleMessage) { h.loadStoredRuleContent(ctx, accountID, m) }
+		p := planRuleActions(rules, m, now)
+		if m.loaded && m.missing {
+			unavailable++
+		}
+		if len(p.Matched) == 0 {
+			continue
+		}
+		matched++
+		if len(matches) < ruleDryRunMaxResults {
+			dm := dryRunMatch{ID: m.ID, Folder: m.Folder, From: m.FromAddr, Subject: m.Subject, Actions: p.Actions}
+			if !m.Date.IsZero() {
+				dm.DateAt = m.Date.UTC().Format(time.RFC3339)
+			}
+			matches = append(matches, dm)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"scanned":             len(msgs),
+		"matched":             matched,
+		"content_unavailable": unavailable,
+		"messages":            matches,
+	})
+}
+
+func (h *Handler) markMessageStarred(c *gin.Context) {
+	accountID, ok := parsePositiveParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return
+	}
+	msgID, ok := parsePositiveParam(c, "msgId")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+		return
+	}
+	var body struct {
+		Starred *bool `json:"starred"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Starred == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must contain \"starred\": true|false"})
+		return
+	}
+	res, err := h.dbex(c.Request.Context()).Exec(`
+		UPDATE mail_messages SET is_starred = $1
+		WHERE id = $2 AND account_id = $3
+		AND account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
+	`, *body.Starred, msgID, accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "starred": *body.Starred})
+}
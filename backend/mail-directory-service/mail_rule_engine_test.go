@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func compileTestConditions(t *testing.T, src string) ruleCondition {
+	t.Helper()
+	var c ruleCondition
+	if err := json.Unmarshal([]byte(src), &c); err != nil {
+		t.Fatal(err)
+	}
+	nodes := 0
+	if err := c.compile(0, &nodes); err != nil {
+		t.Fatalf("compile %s: %v", src, err)
+	}
+	return c
+}
+
+func TestRuleConditionMatches(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	m := &ruleMessage{
+		messageForRules: messageForRules{FromAddr: "Shop <deals@eu.news.example>", ToAddrs: "me@x.fr", Subject: "Promo : -50 %", AttachmentCount: 1, TagIDs: map[int]struct{}{3: {}}},
+		Folder:          "inbox",
+		CcAddrs:         "boss@x.fr",
+		SizeBytes:       2 << 20,
+		Date:            now.Add(-48 * time.Hour),
+	}
+	m.setContent("List-Id: <news.example>\r\nX-Mailer: Blast 2\r\n", "Cliquez ici pour vous désinscrire", "")
+	cases := []struct {
+		cond string
+		want bool
+	}{
+		{`{"field":"from_domain","value":"@news.example"}`, true},
+		{`{"field":"from_domain","value":"other.example"}`, false},
+		{`{"field":"subject","match":"starts_with","value":"PROMO"}`, true},
+		{`{"field":"subject","match":"regex","value":"-\\d+ %"}`, true},
+		{`{"field":"cc","match":"equals","value":"BOSS@x.fr"}`, true},
+		{`{"field":"header","header":"List-Id","match":"exists"}`, true},
+		{`{"field":"header","header":"x-mailer","match":"ends_with","value":"blast 2"}`, true},
+		{`{"field":"body","value":"désinscrire"}`, true},
+		{`{"field":"size","match":"gt","number":1048576}`, true},
+		{`{"field":"size","match":"lt","number":1048576}`, false},
+		{`{"field":"date","match":"older_than_days","number":1}`, true},
+		{`{"field":"date","match":"before","value":"2026-10-01"}`, false},
+		{`{"field":"has_attachments","bool":true}`, true},
+		{`{"field":"has_tag","tag_id":4}`, false},
+		{`{"op":"and","conditions":[{"field":"folder","match":"equals","value":"inbox"},{"op":"not","conditions":[{"field":"has_tag","tag_id":3}]}]}`, false},
+		{`{"op":"or","conditions":[{"field":"to","value":"nobody"},{"op":"not","conditions":[{"field":"has_tag","tag_id":4}]}]}`, true},
+	}
+	for _, tc := range cases {
+		c := compileTestConditions(t, tc.cond)
+		if got := c.matches(m, now); got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.cond, got, tc.want)
+		}
+	}
+}
+
+func TestRuleConditionCompileRejects(t *testing.T) {
+	deep := `{"field":"subject","value":"x"}`
+	for i := 0; i <= maxRuleConditionDepth; i++ {
+		deep = `{"op":"not","conditions":[` + deep + `]}`
+	}
+	cases := []string{
+		`{"op":"xor","conditions":[{"field":"subject","value":"x"}]}`,
+		`{"op":"and"}`,
+		`{"op":"not","conditions":[{"field":"subject","value":"a"},{"field":"subject","value":"b"}]}`,
+		`{"field":"subject"}`,
+		`{"field":"subject","match":"regex","value":"("}`,
+		`{"field":"header","header":"bad name","match":"exists"}`,
+		`{"field":"size","match":"gt"}`,
+		`{"field":"date","match":"after","value":"demain"}`,
+		`{"field":"has_attachments"}`,
+		`{"field":"priority","value":"high"}`,
+		deep,
+	}
+	for _, src := range cases {
+		var c ruleCondition
+		if err := json.Unmarshal([]byte(src), &c); err != nil {
+			t.Fatal(err)
+		}
+		nodes := 0
+		if err := c.compile(0, &nodes); err == nil {
+			t.Errorf("accepted: %s", src)
+		}
+	}
+}
+
+func TestCompileRuleActions(t *testing.T) {
+	ok := []ruleAction{{Type: "Move", Folder: "Archive"}, {Type: "star"}, {Type: "forward", To: "a@x.fr, b@y.fr"}, {Type: "webhook", URL: "https://hooks.example/r"}, {Type: "stop"}}
+	if err := compileRuleActions(ok); err != nil {
+		t.Fatal(err)
+	}
+	if ok[0].Type != "move" || ok[0].Folder != "archive" {
+		t.Errorf("normalisation = %+v", ok[0])
+	}
+	bad := [][]ruleAction{
+		nil,
+		{{Type: "move"}},
+		{{Type: "forward", To: "pas une adresse"}},
+		{{Type: "auto_reply"}},
+		{{Type: "webhook", URL: "ftp://x"}},
+		{{Type: "webhook", URL: "https://a.example"}, {Type: "webhook", URL: "https://b.example"}},
+		{{Type: "explode"}},
+	}
+	for _, actions := range bad {
+		if err := compileRuleActions(actions); err == nil {
+			t.Errorf("accepted: %+v", actions)
+		}
+	}
+}
+
+func TestPlanRuleActions(t *testing.T) {
+	yes := true
+	legacy, err := compileMailRule(&MailFilterRule{ID: 1, FromPattern: "alerts@", ActionFolder: "archive", MarkRead: &yes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cond := compileTestConditions(t, `{"field":"folder","match":"equals","value":"archive"}`)
+	tagging, err := compileMailRule(&MailFilterRule{ID: 2, Conditions: &cond, Actions: []ruleAction{{Type: "add_tag", TagID: 9}, {Type: "star"}, {Type: "webhook", URL: "https://hooks.example/x"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &ruleMessage{messageForRules: messageForRules{FromAddr: "alerts@ops.example"}, Folder: "inbox"}
+	// règle historique : stop implicite, la seconde règle n'est pas évaluée
+	p := planRuleActions([]*compiledMailRule{legacy, tagging}, m, time.Now())
+	if p.Folder != "archive" || !p.Read || len(p.Matched) != 1 || !reflect.DeepEqual(p.Actions, []string{"move:archive", "mark_read"}) {
+		t.Errorf("legacy plan = %+v", p)
+	}
+	// ordre inversé : la règle enrichie voit le dossier d'origine, puis la règle historique déplace
+	p = planRuleActions([]*compiledMailRule{tagging, legacy}, m, time.Now())
+	if len(p.Matched) != 1 || p.Folder != "archive" || p.Starred || len(p.Effects) != 0 {
+		t.Errorf("reordered plan = %+v", p)
+	}
+	m.Folder = "archive"
+	p = planRuleActions([]*compiledMailRule{tagging}, m, time.Now())
+	if !p.Starred || !reflect.DeepEqual(p.AddTags, []int{9}) || len(p.Effects) != 1 || p.Effects[0].Action.Type != "webhook" {
+		t.Errorf("tagging plan = %+v", p)
+	}
+	if m.TagIDs != nil {
+		t.Errorf("plan must not mutate message tags: %v", m.TagIDs)
+	}
+}
+
+func TestRuleMessageLoadsContentLazily(t *testing.T) {
+	loads := 0
+	m := &ruleMessage{messageForRules: messageForRules{Subject: "Facture"}}
+	m.load = func(m *ruleMessage) { loads++; m.setContent("", "", "<p>Total : 42 €</p>") }
+	cheap := compileTestConditions(t, `{"op":"and","conditions":[{"field":"subject","value":"devis"},{"field":"body","value":"42"}]}`)
+	body := compileTestConditions(t, `{"field":"body","value":"42 €"}`)
+	if planRuleActions([]*compiledMailRule{{Conditions: cheap, Actions: []ruleAction{{Type: "star"}}}}, m, time.Now()).Starred || loads != 0 {
+		t.Fatalf("content loaded for a short-circuited rule (loads=%d)", loads)
+	}
+	rules := []*compiledMailRule{{Conditions: body, Actions: []ruleAction{{Type: "star"}}}, {Conditions: body, Actions: []ruleAction{{Type: "mark_read"}}}}
+	if p := planRuleActions(rules, m, time.Now()); !p.Starred || !p.Read || loads != 1 || !m.loaded {
+		t.Errorf("plan = %+v loads=%d", p, loads)
+	}
+}
+
+func TestAutoReplyBlockedReason(t *testing.T) {
+	own := map[string]bool{"me@x.fr": true}
+	cases := []struct {
+		headers map[string][]string
+		from    string
+		want    string
+	}{
+		{nil, "Alice <alice@y.fr>", ""},
+		{map[string][]string{"auto-submitted": {"no"}}, "alice@y.fr", ""},
+		{map[string][]string{"auto-submitted": {"auto-replied"}}, "alice@y.fr", "auto-submitted"},
+		{map[string][]string{"precedence": {"Bulk"}}, "alice@y.fr", "precedence"},
+		{map[string][]string{"list-id": {"<l.y.fr>"}}, "alice@y.fr", "list-id"},
+		{nil, "ME@x.fr", "own-address"},
+		{nil, "MAILER-DAEMON@y.fr", "system-sender"},
+		{nil, "no-reply@y.fr", "system-sender"},
+		{nil, "users-request@lists.y.fr", "system-sender"},
+		{nil, "", "sender"},
+	}
+	for _, tc := range cases {
+		if got := autoReplyBlockedReason(tc.headers, tc.from, own); got != tc.want {
+			t.Errorf("autoReplyBlockedReason(%v, %q) = %q, want %q", tc.headers, tc.from, got, tc.want)
+		}
+	}
+}
+
+func TestRuleForwardLoopProtection(t *testing.T) {
+	own := map[string]bool{"me@x.fr": true, "alias@x.fr": true}
+	if !ruleForwardTargetsOwn("Bob <bob@y.fr>, ALIAS@x.fr", own) || ruleForwardTargetsOwn("bob@y.fr", own) {
+		t.Error("ruleForwardTargetsOwn")
+	}
+	if ruleForwardLooped(map[string][]string{"x-loop": {"other@y.fr"}}, own) {
+		t.Error("foreign X-Loop treated as a loop")
+	}
+	if !ruleForwardLooped(map[string][]string{"x-loop": {"other@y.fr", "<Me@x.fr>"}}, own) {
+		t.Error("own X-Loop not detected")
+	}
+	hops := make([]string, maxRuleForwardHops)
+	for i := range hops {
+		hops[i] = fmt.Sprintf("hop%d@y.fr", i)
+	}
+	if !ruleForwardLooped(map[string][]string{"x-loop": hops}, own) {
+		t.Error("hop limit not enforced")
+	}
+	got := ruleForwardLoopHeaders(map[string][]string{"x-loop": {"Other@y.fr", "garbage"}}, "Me@x.fr")
+	if strings.Join(got, ",") != "other@y.fr,me@x.fr" {
+		t.Errorf("ruleForwardLoopHeaders = %v", got)
+	}
+}
+
+func TestRuleEffectEligible(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	r := &compiledMailRule{UpdatedAt: now.Add(-time.Hour)}
+	fresh := &ruleMessage{Folder: "inbox", CreatedAt: now.Add(-time.Minute), Date: now.Add(-time.Minute)}
+	if !ruleEffectEligible(fresh, r, now) {
+		t.Error("fresh message not eligible")
+	}
+	for name, m := range map[string]*ruleMessage{
+		"before rule change": {Folder: "inbox", CreatedAt: now.Add(-2 * time.Hour), Date: now.Add(-2 * time.Hour)},
+		"old mail synced":    {Folder: "inbox", CreatedAt: now.Add(-time.Minute), Date: now.Add(-30 * 24 * time.Hour)},
+		"own sent mail":      {Folder: "sent", CreatedAt: now.Add(-time.Minute), Date: now.Add(-time.Minute)},
+	} {
+		if ruleEffectEligible(m, r, now) {
+			t.Errorf("%s: eligible", name)
+		}
+	}
+}
+
+func TestWebhookAddressBlocked(t *testing.T) {
+	for _, s := range []string{"127.0.0.1", "10.1.2.3", "192.168.0.10", "169.254.169.254", "100.64.1.1", "::1", "fd00::1", "0.0.0.0"} {
+		if !webhookAddressBlocked(net.ParseIP(s)) {
+			t.Errorf("%s not blocked", s)
+		}
+	}
+	for _, s := range []string{"93.184.216.34", "2606:4700::1111"} {
+		if webhookAddressBlocked(net.ParseIP(s)) {
+			t.Errorf("%s blocked", s)
+		}
+	}
+}
+
+func TestSendRuleWebhookRefusesLoopback(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Cloudity-Signature") != ruleWebhookSignature("s3cret", body) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+	rule := &compiledMailRule{ID: 4, Name: "Alertes"}
+	m := &ruleMessage{ID: 12, Folder: "inbox", messageForRules: messageForRules{Subject: "Alerte"}}
+	a := ruleAction{Type: "webhook", URL: srv.URL, Secret: "s3cret"}
+	if err := sendRuleWebhook(t.Context(), 1, rule, m, a); err == nil || hits != 0 {
+		t.Fatalf("loopback webhook: err=%v hits=%d", err, hits)
+	}
+	t.Setenv("MAIL_RULE_WEBHOOK_ALLOW_PRIVATE", "1")
+	if err := sendRuleWebhook(t.Context(), 1, rule, m, a); err != nil || hits != 1 {
+		t.Fatalf("allowed webhook: err=%v hits=%d", err, hits)
+	}
+}
+
+func TestMailRuleEngineRoutes(t *testing.T) {
+	r := setupRouter(nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/mail/me/accounts/1/rules/dry-run", strings.NewReader(`{}`)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("dry-run without X-User-ID: got %d", w.Code)
+	}
+	cases := []struct{ method, path, body string }{
+		{http.MethodPost, "/mail/me/accounts/1/rules/dry-run", `{}`},
+		{http.MethodPost, "/mail/me/accounts/1/rules/dry-run", `{"conditions":{"field":"subject","match":"regex","value":"("}}`},
+		{http.MethodPost, "/mail/me/accounts/1/rules", `{"conditions":{"op":"and"}}`},
+		{http.MethodPost, "/mail/me/accounts/1/rules", `{"subject_pattern":"x","actions":[{"type":"forward","to":"nope"}]}`},
+		{http.MethodPatch, "/mail/me/accounts/1/rules/2", `{"actions":[]}`},
+		{http.MethodPatch, "/mail/me/accounts/1/rules/2", `{"conditions":{"field":"size"}}`},
+		{http.MethodPatch, "/mail/me/accounts/1/messages/2/star", `{}`},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		setAdminMailHeaders(req)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s %s: got %d %s", tc.method, tc.path, tc.body, w.Code, w.Body.String())
+		}
+	}
+}
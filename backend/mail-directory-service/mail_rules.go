@@ -5,10 +5,10 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
@@ -34,6 +34,10 @@ type MailFilterRule struct {
 	ActionsJSON       string `json:"actions_json,omitempty"`
 	CreatedAt         string `json:"created_at"`
 	UpdatedAt         string `json:"updated_at"`
+	// Conditions / Actions : format enrichi (mail_rule_engine.go) ; absents, les colonnes
+	// historiques ci-dessus définissent la règle.
+	Conditions *ruleCondition `json:"conditions,omitempty"`
+	Actions    []ruleAction   `json:"actions,omitempty"`
 }
 
 func (h *Handler) listMailFilterRules(c *gin.Context) {
@@ -42,47 +46,11 @@ func (h *Handler) listMailFilterRules(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
 		return
 	}
-	ctx := c.Request.Context()
-	rows, err := h.dbex(ctx).Query(`
-		SELECT id, account_id, name, from_pattern, from_domain_pattern, recipient_pattern, has_tag_id, add_tag_id, subject_pattern, has_attachments, action_folder, mark_read, enabled, rule_order, criteria_json::text, actions_json::text, created_at::text, updated_at::text
-		FROM mail_filter_rules
-		WHERE account_id = $1
-		  AND account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
-		ORDER BY rule_order ASC, id DESC
-	`, accountID)
+	out, err := h.loadMailFilterRules(c.Request.Context(), accountID, false)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
-	var out []MailFilterRule
-	for rows.Next() {
-		var r MailFilterRule
-		var hasAtt sql.NullBool
-		var markRead sql.NullBool
-		var hasTag sql.NullInt64
-		var addTag sql.NullInt64
-		if err := rows.Scan(&r.ID, &r.AccountID, &r.Name, &r.FromPattern, &r.FromDomainPattern, &r.RecipientPattern, &hasTag, &addTag, &r.SubjectPattern, &hasAtt, &r.ActionFolder, &markRead, &r.Enabled, &r.RuleOrder, &r.CriteriaJSON, &r.ActionsJSON, &r.CreatedAt, &r.UpdatedAt); err != nil {
-			continue
-		}
-		if hasAtt.Valid {
-			v := hasAtt.Bool
-			r.HasAttachments = &v
-		}
-		if markRead.Valid {
-			v := markRead.Bool
-			r.MarkRead = &v
-		}
-		if hasTag.Valid {
-			v := int(hasTag.Int64)
-			r.HasTagID = &v
-		}
-		if addTag.Valid {
-			v := int(addTag.Int64)
-			r.AddTagID = &v
-		}
-		out = append(out, r)
-	}
 	c.JSON(http.StatusOK, out)
 }
 
@@ -106,6 +74,9 @@ func (h *Handler) createMailFilterRule(c *gin.Context) {
 		MarkRead          *bool  `json:"mark_read"`
 		Enabled           *bool  `json:"enabled"`
 		RuleOrder         *int   `json:"rule_order"`
+		// Conditions / Actions : format enrichi, prioritaire sur les champs historiques.
+		Conditions *ruleCondition `json:"conditions"`
+		Actions    []ruleAction   `json:"actions"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "body JSON invalide"})
@@ -134,10 +105,22 @@ func (h *Handler) createMailFilterRule(c *gin.Context) {
 	subPat := strings.TrimSpace(body.SubjectPattern)
 	domPat := normalizeFromDomainPattern(body.FromDomainPattern)
 	rcpPat := strings.TrimSpace(strings.ToLower(body.RecipientPattern))
-	if fromPat == "" && subPat == "" && domPat == "" && rcpPat == "" && body.HasAttachments == nil && body.HasTagID == nil {
+	if body.Conditions == nil && fromPat == "" && subPat == "" && domPat == "" && rcpPat == "" && body.HasAttachments == nil && body.HasTagID == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "au moins une condition : expéditeur, domaine, destinataire, sujet, étiquette ou pièces jointes"})
 		return
 	}
+	var advanced *compiledMailRule
+	if body.Conditions != nil || body.Actions != nil {
+		rule, err := compileMailRule(&MailFilterRule{ActionFolder: actionFolder, Conditions: body.Conditions, Actions: body.Actions})
+		if err == nil {
+			err = h.checkRuleReferences(ctx, accountID, &rule.Conditions, rule.Actions)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		advanced = rule
+	}
 	if body.HasTagID != nil && *body.HasTagID > 0 {
 		var okTag int
 		if err := h.dbex(ctx).QueryRow(`SELECT id FROM mail_tags WHERE id=$1 AND account_id=$2`, *body.HasTagID, accountID).Scan(&okTag); err != nil {
@@ -172,6 +155,12 @@ func (h *Handler) createMailFilterRule(c *gin.Context) {
 		"mark_read":     body.MarkRead,
 		"add_tag_id":    body.AddTagID,
 	}
+	if body.Conditions != nil {
+		criteriaMap["conditions"] = advanced.Conditions
+	}
+	if body.Actions != nil {
+		actionsMap["actions"] = advanced.Actions
+	}
 	criteriaJSON, _ := json.Marshal(criteriaMap)
 	actionsJSON, _ := json.Marshal(actionsMap)
 	var id int
@@ -220,6 +209,9 @@ func (h *Handler) patchMailFilterRule(c *gin.Context) {
 		MarkRead          *bool   `json:"mark_read"`
 		Enabled           *bool   `json:"enabled"`
 		RuleOrder         *int    `json:"rule_order"`
+		// null retire le format enrichi (retour aux champs historiques).
+		Conditions json.RawMessage `json:"conditions"`
+		Actions    json.RawMessage `json:"actions"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "body JSON invalide"})
@@ -333,6 +325,55 @@ func (h *Handler) patchMailFilterRule(c *gin.Context) {
 		args = append(args, ord)
 		idx++
 	}
+	if len(body.Conditions) > 0 {
+		if string(body.Conditions) == "null" {
+			set = append(set, "criteria_json = COALESCE(criteria_json, '{}'::jsonb) - 'conditions'")
+		} else {
+			var cond ruleCondition
+			if err := json.Unmarshal(body.Conditions, &cond); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "conditions invalides"})
+				return
+			}
+			rule, err := compileMailRule(&MailFilterRule{Conditions: &cond})
+			if err == nil {
+				err = h.checkRuleReferences(ctx, accountID, &rule.Conditions, nil)
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			raw, _ := json.Marshal(rule.Conditions)
+			set = append(set, "criteria_json = jsonb_set(COALESCE(criteria_json, '{}'::jsonb), '{conditions}', $"+strconv.Itoa(idx)+"::jsonb)")
+			args = append(args, string(raw))
+			idx++
+		}
+	}
+	if len(body.Actions) > 0 {
+		if string(body.Actions) == "null" {
+			set = append(set, "actions_json = COALESCE(actions_json, '{}'::jsonb) - 'actions'")
+		} else {
+			var actions []ruleAction
+			if err := json.Unmarshal(body.Actions, &actions); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "actions invalides"})
+				return
+			}
+			if actions == nil {
+				actions = []ruleAction{}
+			}
+			rule, err := compileMailRule(&MailFilterRule{Actions: actions})
+			if err == nil {
+				err = h.checkRuleReferences(ctx, accountID, nil, rule.Actions)
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			raw, _ := json.Marshal(rule.Actions)
+			set = append(set, "actions_json = jsonb_set(COALESCE(actions_json, '{}'::jsonb), '{actions}', $"+strconv.Itoa(idx)+"::jsonb)")
+			args = append(args, string(raw))
+			idx++
+		}
+	}
 	if len(set) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "aucun champ à modifier"})
 		return
@@ -433,8 +474,8 @@ func ruleFromDomainMatches(fromHeader, patternNorm string) bool {
 
 // ruleMatchCriteria — conditions pures d'une règle de tri Mail, testables sans DB.
 //
-// Utilisé par `applyMailRulesForAccount` au moment de décider si une règle s'applique à un
-// message donné, et exposé pour les tests (`mail_rules_test.go`). Toutes les chaînes patterns
+// `ruleMatches` les évalue via la compilation historique du moteur (`legacyRuleDefinition`),
+// ce qui garde testée l'équivalence avec les anciennes règles (`mail_rules_test.go`). Toutes les chaînes patterns
 // sont attendues telles qu'enregistrées (le helper applique lui-même la mise en minuscule
 // nécessaire). Les pointeurs représentent des conditions optionnelles : `nil` = ignoré.
 type ruleMatchCriteria struct {
@@ -455,202 +496,15 @@ type messageForRules struct {
 }
 
 func ruleMatches(rule ruleMatchCriteria, msg messageForRules) bool {
-	fromLower := strings.ToLower(msg.FromAddr)
-	toLower := strings.ToLower(msg.ToAddrs)
-	subjLower := strings.ToLower(msg.Subject)
-	if rule.FromPattern != "" && !strings.Contains(fromLower, strings.ToLower(rule.FromPattern)) {
-		return false
-	}
-	if !ruleFromDomainMatches(msg.FromAddr, rule.FromDomainNorm) {
-		return false
-	}
-	if rule.RecipientPattern != "" && !strings.Contains(toLower, strings.ToLower(rule.RecipientPattern)) {
-		return false
-	}
-	if rule.SubjectPattern != "" && !strings.Contains(subjLower, strings.ToLower(rule.SubjectPattern)) {
-		return false
-	}
-	if rule.HasAttachments != nil {
-		if (msg.AttachmentCount > 0) != *rule.HasAttachments {
-			return false
-		}
-	}
-	if rule.HasTagID != nil {
-		if msg.TagIDs == nil {
-			return false
-		}
-		if _, ok := msg.TagIDs[*rule.HasTagID]; !ok {
-			return false
-		}
-	}
-	return true
-}
-
-func (h *Handler) applyMailRulesForAccount(ctx context.Context, accountID int) (int, error) {
-	rows, err := h.dbex(ctx).Query(`
-		SELECT id, from_pattern, from_domain_pattern, recipient_pattern, has_tag_id, add_tag_id, subject_pattern, has_attachments, action_folder, mark_read
-		FROM mail_filter_rules
-		WHERE account_id = $1 AND enabled = TRUE
-		ORDER BY rule_order ASC, id ASC
-	`, accountID)
-	if err != nil {
-		return 0, err
-	}
-	defer rows.Close()
-	type dbRule struct {
-		id               int
-		fromPattern      string
-		fromDomainNorm   string
-		recipientPattern string
-		hasTagID         sql.NullInt64
-		addTagID         sql.NullInt64
-		subjectPattern   string
-		hasAttachments   sql.NullBool
-		actionFolder     string
-		markRead         sql.NullBool
-	}
-	var rules []dbRule
-	for rows.Next() {
-		var r dbRule
-		var domRaw string
-		if err := rows.Scan(&r.id, &r.fromPattern, &domRaw, &r.recipientPattern, &r.hasTagID, &r.addTagID, &r.subjectPattern, &r.hasAttachments, &r.actionFolder, &r.markRead); err != nil {
-			continue
-		}
-		r.fromDomainNorm = normalizeFromDomainPattern(domRaw)
-		rules = append(rules, r)
-	}
-	if len(rules) == 0 {
-		return 0, nil
-	}
-	msgRows, err := h.dbex(ctx).Query(`
-		SELECT id, COALESCE(from_addr, ''), COALESCE(to_addrs, ''), COALESCE(subject, ''), COALESCE(attachment_count, 0), COALESCE(folder, ''), COALESCE(is_read, FALSE), COALESCE(message_uid, 0)
-		FROM mail_messages
-		WHERE account_id = $1
-	`, accountID)
-	if err != nil {
-		return 0, err
-	}
-	defer msgRows.Close()
-	tagRows, err := h.dbex(ctx).Query(`
-		SELECT mt.message_id, mt.tag_id
-		FROM mail_message_tags mt
-		INNER JOIN mail_messages m ON m.id = mt.message_id
-		WHERE m.account_id = $1
-	`, accountID)
-	if err != nil {
-		return 0, err
-	}
-	msgTagSet := map[int]map[int]struct{}{}
-	for tagRows.Next() {
-		var mid, tid int
-		if err := tagRows.Scan(&mid, &tid); err != nil {
-			continue
-		}
-		if _, ok := msgTagSet[mid]; !ok {
-			msgTagSet[mid] = map[int]struct{}{}
-		}
-		msgTagSet[mid][tid] = struct{}{}
-	}
-	tagRows.Close()
-
-	affected := 0
-	var imapClientConn *client.Client
-	imapAvailable := true
-	defer func() {
-		if imapClientConn != nil {
-			_ = imapClientConn.Logout()
-		}
-	}()
-	for msgRows.Next() {
-		var msgID int
-		var fromAddr, toAddrs, subject, folder string
-		var attachmentCount int
-		var isRead bool
-		var messageUID int64
-		if err := msgRows.Scan(&msgID, &fromAddr, &toAddrs, &subject, &attachmentCount, &folder, &isRead, &messageUID); err != nil {
-			continue
-		}
-		for _, rule := range rules {
-			crit := ruleMatchCriteria{
-				FromPattern:      rule.fromPattern,
-				FromDomainNorm:   rule.fromDomainNorm,
-				RecipientPattern: rule.recipientPattern,
-				SubjectPattern:   rule.subjectPattern,
-			}
-			if rule.hasAttachments.Valid {
-				v := rule.hasAttachments.Bool
-				crit.HasAttachments = &v
-			}
-			if rule.hasTagID.Valid {
-				v := int(rule.hasTagID.Int64)
-				crit.HasTagID = &v
-			}
-			msgInput := messageForRules{
-				FromAddr:        fromAddr,
-				ToAddrs:         toAddrs,
-				Subject:         subject,
-				AttachmentCount: attachmentCount,
-				TagIDs:          msgTagSet[msgID],
-			}
-			if !ruleMatches(crit, msgInput) {
-				continue
-			}
-			newFolder := strings.TrimSpace(rule.actionFolder)
-			if isStandardMailFolder(newFolder) {
-				newFolder = strings.ToLower(newFolder)
-			}
-			if !h.folderAllowed(ctx, accountID, newFolder) {
-				continue
-			}
-			newRead := isRead
-			if rule.markRead.Valid {
-				newRead = rule.markRead.Bool
-			}
-			res, err := h.dbex(ctx).Exec(`
-				UPDATE mail_messages
-				SET folder = $1, is_read = $2
-				WHERE id = $3 AND account_id = $4
-			`, newFolder, newRead, msgID, accountID)
-			if err == nil {
-				n, _ := res.RowsAffected()
-				if n > 0 {
-					affected++
-					folderChanged := !strings.EqualFold(strings.TrimSpace(folder), strings.TrimSpace(newFolder))
-					readChanged := isRead != newRead
-					if messageUID > 0 && imapAvailable && (folderChanged || readChanged) {
-						if imapClientConn == nil {
-							_, ic, imapErr := h.imapDialAndLogin(ctx, accountID, "")
-							if imapErr != nil {
-								imapAvailable = false
-								log.Printf("[mail-rules] IMAP indisponible pour réconciliation account=%d: %v", accountID, imapErr)
-							} else {
-								imapClientConn = ic
-							}
-						}
-						if imapClientConn != nil {
-							if imapErr := h.reconcileMessageStateOnIMAP(ctx, accountID, imapClientConn, uint32(messageUID), folder, newFolder, isRead, newRead); imapErr != nil {
-								log.Printf("[mail-rules] Réconciliation IMAP échouée account=%d msg=%d uid=%d: %v", accountID, msgID, messageUID, imapErr)
-							}
-						}
-					}
-					if rule.addTagID.Valid && int(rule.addTagID.Int64) > 0 {
-						_, _ = h.dbex(ctx).Exec(`
-							INSERT INTO mail_message_tags (message_id, tag_id)
-							SELECT $1, t.id FROM mail_tags t
-							WHERE t.id = $2 AND t.account_id = $3
-							ON CONFLICT (message_id, tag_id) DO NOTHING
-						`, msgID, int(rule.addTagID.Int64), accountID)
-						if _, ok := msgTagSet[msgID]; !ok {
-							msgTagSet[msgID] = map[int]struct{}{}
-						}
-						msgTagSet[msgID][int(rule.addTagID.Int64)] = struct{}{}
-					}
-				}
-			}
-			break
-		}
-	}
-	return affected, nil
+	cond, _ := legacyRuleDefinition(&MailFilterRule{
+		FromPattern:       rule.FromPattern,
+		FromDomainPattern: rule.FromDomainNorm,
+		RecipientPattern:  rule.RecipientPattern,
+		SubjectPattern:    rule.SubjectPattern,
+		HasAttachments:    rule.HasAttachments,
+		HasTagID:          rule.HasTagID,
+	})
+	return cond.matches(&ruleMessage{messageForRules: msg}, time.Now())
 }
 
 func (h *Handler) reconcileMessageStateOnIMAP(
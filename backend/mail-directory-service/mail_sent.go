@@ -0,0 +1,141 @@
+package main
+
+// mail_sent.go — copie des messages envoyés (envoi immédiat, envoi programmé, réponses
+// automatiques et transferts de règles).
+//
+// Une fois le message accepté par SMTP, recordSentMessage crée la ligne « sent » de
+// mail_messages (fil calculé par threadKeyFromHeaders, pièces jointes incluses) ; pour un envoi
+// programmé, la ligne existe déjà et passe simplement à « sent ». Les octets RFC 822 réellement
+// transmis sont ensuite déposés par IMAP APPEND (\Seen) dans le dossier Envoyés du compte
+// (SPECIAL-USE \Sent puis noms usuels), et l'UID obtenu remplace le message_uid provisoire.
+// Le réglage save_sent_copy du compte pilote ce dépôt : NULL = automatique, désactivé pour les
+// fournisseurs qui rangent eux-mêmes le message soumis (Gmail) — la synchronisation rattache
+// alors leur copie par Message-ID. Le message étant parti, aucune erreur n'est remontée au client.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// providerStoresSentCopy : fournisseurs qui rangent eux-mêmes le message soumis en SMTP dans
+// « Envoyés » (Gmail / Google Workspace). Y déposer une copie créerait un doublon.
+func providerStoresSentCopy(email, imapHost, oauthProvider string) bool {
+	if strings.EqualFold(strings.TrimSpace(oauthProvider), "google") {
+		return true
+	}
+	host := strings.ToLower(strings.TrimSpace(imapHost))
+	if host == "imap.gmail.com" || host == "imap.googlemail.com" {
+		return true
+	}
+	lower := strings.ToLower(email)
+	return strings.Contains(lower, "@gmail.") || strings.Contains(lower, "@googlemail.")
+}
+
+// sentCopyEnabled : réglage save_sent_copy du compte (NULL = automatique selon le fournisseur).
+func (h *Handler) sentCopyEnabled(ctx context.Context, accountID int) (bool, error) {
+	var email string
+	var imapHost, oauthProvider sql.NullString
+	var setting sql.NullBool
+	err := h.dbex(ctx).QueryRow(`
+		SELECT email, imap_host, oauth_provider, save_sent_copy
+		FROM user_email_accounts
+		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER
+	`, accountID).Scan(&email, &imapHost, &oauthProvider, &setting)
+	if err != nil {
+		return false, err
+	}
+	if setting.Valid {
+		return setting.Bool, nil
+	}
+	return !providerStoresSentCopy(email, imapHost.String, oauthProvider.String), nil
+}
+
+// recordSentMessage enregistre un message parti en SMTP : ligne « sent » dans mail_messages
+// (sauf si localID désigne déjà la ligne, cas de l'envoi programmé) puis, si le compte le
+// demande, APPEND des octets RFC 822 envoyés dans le dossier Envoyés IMAP.
+// Le message est déjà parti : les erreurs sont journalisées, jamais remontées au client.
+func (h *Handler) recordSentMessage(ctx context.Context, accountID, localID int, m *outgoingMessage, raw []byte) int {
+	msgID := normalizeMessageID(m.MessageID)
+	if localID == 0 {
+		id, err := h.insertSentMessage(ctx, accountID, m)
+		if err != nil {
+			log.Printf("[mail] sent copy account=%d message-id=%q: %v", accountID, msgID, err)
+			return 0
+		}
+		localID = id
+	}
+	enabled, err := h.sentCopyEnabled(ctx, accountID)
+	if err != nil {
+		log.Printf("[mail] sent copy setting account=%d: %v", accountID, err)
+		return localID
+	}
+	if !enabled {
+		// Le fournisseur range le message : la sync rattachera la ligne par Message-ID.
+		return localID
+	}
+	if err := h.appendSentToIMAP(ctx, accountID, localID, m, raw); err != nil {
+		log.Printf("[mail] sent copy IMAP APPEND account=%d id=%d: %v", accountID, localID, err)
+	}
+	return localID
+}
+
+func (h *Handler) insertSentMessage(ctx context.Context, accountID int, m *outgoingMessage) (int, error) {
+	msgID := normalizeMessageID(m.MessageID)
+	refs := referencesHeader(m.References)
+	threadKey := threadKeyFromHeaders(msgID, m.InReplyTo, refs)
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var id int
+	if err := tx.QueryRow(`
+		INSERT INTO mail_messages (
+			account_id, folder, message_uid, from_addr, to_addrs, cc_addrs, bcc_addrs, subject,
+			body_plain, body_html, date_at, is_read, internet_msg_id, in_reply_to, references_header,
+			thread_key, attachment_count
+		)
+		VALUES ($1, 'sent', $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), $10, true, $11, $12,
+			NULLIF($13, ''), $14, $15)
+		RETURNING id
+	`, accountID, -time.Now().UnixNano(), m.From.Address, addressListString(m.To), addressListString(m.Cc),
+		addressListString(m.Bcc), m.Subject, m.Text, m.HTML, m.Date.UTC(), msgID, m.InReplyTo, refs,
+		coalesceString(threadKey, msgID), len(m.Attachments)).Scan(&id); err != nil {
+		return 0, err
+	}
+	if err := storeComposeAttachments(tx, id, m.Attachments); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// appendSentToIMAP dépose les octets envoyés dans Envoyés (\Seen) ; l'UID obtenu devient
+// message_uid de la ligne locale.
+func (h *Handler) appendSentToIMAP(ctx context.Context, accountID, localID int, m *outgoingMessage, raw []byte) error {
+	_, ic, err := h.imapDialAndLogin(ctx, accountID, "")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = ic.Logout() }()
+	_, uid, err := h.imapAppendToFolder(ctx, accountID, ic, "sent", []string{imap.SeenFlag}, m.Date, raw, m.MessageID, false)
+	if err != nil {
+		return err
+	}
+	if uid == 0 {
+		return nil
+	}
+	if _, err := h.dbex(ctx).Exec(`
+		UPDATE mail_messages SET message_uid = $3
+		WHERE id = $1 AND account_id = $2
+		AND account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
+	`, localID, accountID, int64(uid)); err != nil {
+		return fmt.Errorf("UID du message envoyé: %w", err)
+	}
+	return nil
+}
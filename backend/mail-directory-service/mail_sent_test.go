@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestProviderStoresSentCopy(t *testing.T) {
+	cases := []struct {
+		email, imapHost, oauth string
+		want                   bool
+	}{
+		{"alice@gmail.com", "", "", true},
+		{"bob@entreprise.fr", "", "google", true},
+		{"bob@entreprise.fr", "imap.gmail.com", "", true},
+		{"carol@ovh.fr", "ssl0.ovh.net", "", false},
+		{"dave@infomaniak.ch", "", "", false},
+	}
+	for _, tc := range cases {
+		if got := providerStoresSentCopy(tc.email, tc.imapHost, tc.oauth); got != tc.want {
+			t.Errorf("providerStoresSentCopy(%q, %q, %q) = %v, want %v", tc.email, tc.imapHost, tc.oauth, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,854 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// Sieve (RFC 5228) : sous-ensemble suffisant pour les règles de tri Cloudity et les scripts
+// déposés par ManageSieve (managesieve.go). Extensions prises en charge : fileinto,
+// imap4flags (RFC 5232), reject (RFC 5429), envelope, copy (RFC 3894).
+var sieveExtensions = []string{"fileinto", "imap4flags", "reject", "envelope", "copy"}
+
+const maxSieveScriptBytes = 64 << 10
+
+// sieveArg : argument positionnel — étiquette (:contains), nombre ou liste de chaînes.
+type sieveArg struct {
+	Tag    string
+	Num    int64
+	IsNum  bool
+	Strs   []string
+	IsList bool
+}
+
+type sieveTest struct {
+	Name  string
+	Args  []sieveArg
+	Tests []*sieveTest
+}
+
+type sieveCommand struct {
+	Name     string
+	Args     []sieveArg
+	Test     *sieveTest
+	Block    []*sieveCommand
+	Comments []string
+	Line     int
+}
+
+type sieveScript struct {
+	Require  []string
+	Commands []*sieveCommand
+}
+
+// --- Analyse lexicale ---
+
+type sieveTokKind int
+
+const (
+	sieveTokEOF sieveTokKind = iota
+	sieveTokIdent
+	sieveTokTag
+	sieveTokNum
+	sieveTokString
+	sieveTokPunct
+	sieveTokComment
+)
+
+type sieveToken struct {
+	Kind sieveTokKind
+	Text string
+	Num  int64
+	Line int
+}
+
+func sieveLex(src string) ([]sieveToken, error) {
+	var toks []sieveToken
+	line := 1
+	i := 0
+	for i < len(src) {
+		ch := src[i]
+		switch {
+		case ch == '\n':
+			line++
+			i++
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			i++
+		case ch == '#':
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			toks = append(toks, sieveToken{Kind: sieveTokComment, Text: strings.TrimSpace(src[i+1 : i+end]), Line: line})
+			i += end
+		case ch == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("ligne %d : commentaire /* non terminé", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case ch == '"':
+			var b strings.Builder
+			start := line
+			j := i + 1
+			for ; j < len(src) && src[j] != '"'; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				if src[j] == '\n' {
+					line++
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("ligne %d : chaîne non terminée", start)
+			}
+			toks = append(toks, sieveToken{Kind: sieveTokString, Text: b.String(), Line: start})
+			i = j + 1
+		case strings.HasPrefix(src[i:], "text:"):
+			// Chaîne multi-ligne : jusqu'à une ligne contenant un point seul (« .. » → « . »).
+			nl := strings.IndexByte(src[i:], '\n')
+			if nl < 0 {
+				return nil, fmt.Errorf("ligne %d : text: sans contenu", line)
+			}
+			start := line
+			i += nl + 1
+			line++
+			var b strings.Builder
+			for {
+				if i >= len(src) {
+					return nil, fmt.Errorf("ligne %d : text: non terminé", start)
+				}
+				end := strings.IndexByte(src[i:], '\n')
+				if end < 0 {
+					end = len(src) - i
+				}
+				l := strings.TrimRight(src[i:i+end], "\r")
+				i += end + 1
+				line++
+				if l == "." {
+					break
+				}
+				b.WriteString(strings.TrimPrefix(l, "."))
+				b.WriteString("\r\n")
+			}
+			toks = append(toks, sieveToken{Kind: sieveTokString, Text: b.String(), Line: start})
+		case ch == ':':
+			j := i + 1
+			for j < len(src) && isSieveIdentChar(src[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("ligne %d : étiquette vide", line)
+			}
+			toks = append(toks, sieveToken{Kind: sieveTokTag, Text: strings.ToLower(src[i+1 : j]), Line: line})
+			i = j
+		case ch >= '0' && ch <= '9':
+			j := i
+			for j < len(src) && src[j] >= '0' && src[j] <= '9' {
+				j++
+			}
+			n, err := strconv.ParseInt(src[i:j], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("ligne %d : nombre invalide", line)
+			}
+			if j < len(src) {
+				switch unicode.ToUpper(rune(src[j])) {
+				case 'K':
+					n <<= 10
+					j++
+				case 'M':
+					n <<= 20
+					j++
+				case 'G':
+					n <<= 30
+					j++
+				}
+			}
+			toks = append(toks, sieveToken{Kind: sieveTokNum, Num: n, Line: line})
+			i = j
+		case isSieveIdentChar(ch):
+			j := i
+			for j < len(src) && isSieveIdentChar(src[j]) {
+				j++
+			}
+			toks = append(toks, sieveToken{Kind: sieveTokIdent, Text: strings.ToLower(src[i:j]), Line: line})
+			i = j
+		case strings.ContainsRune("[](){},;", rune(ch)):
+			toks = append(toks, sieveToken{Kind: sieveTokPunct, Text: string(ch), Line: line})
+			i++
+		default:
+			return nil, fmt.Errorf("ligne %d : caractère inattendu %q", line, ch)
+		}
+	}
+	toks = append(toks, sieveToken{Kind: sieveTokEOF, Line: line})
+	return toks, nil
+}
+
+func isSieveIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// --- Analyse syntaxique ---
+
+type sieveParser struct {
+	toks     []sieveToken
+	pos      int
+	comments []string
+}
+
+func (p *sieveParser) peek() sieveToken {
+	for p.toks[p.pos].Kind == sieveTokComment {
+		p.comments = append(p.comments, p.toks[p.pos].Text)
+		p.pos++
+	}
+	return p.toks[p.pos]
+}
+
+func (p *sieveParser) next() sieveToken {
+	t := p.peek()
+	if t.Kind != sieveTokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *sieveParser) isPunct(s string) bool {
+	t := p.peek()
+	return t.Kind == sieveTokPunct && t.Text == s
+}
+
+func (p *sieveParser) expect(s string) error {
+	t := p.next()
+	if t.Kind != sieveTokPunct || t.Text != s {
+		return fmt.Errorf("ligne %d : %q attendu", t.Line, s)
+	}
+	return nil
+}
+
+// parseSieve analyse un script et vérifie les commandes, tests et extensions déclarées.
+func parseSieve(src string) (*sieveScript, error) {
+	if len(src) > maxSieveScriptBytes {
+		return nil, fmt.Errorf("script trop volumineux (max %d octets)", maxSieveScriptBytes)
+	}
+	toks, err := sieveLex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &sieveParser{toks: toks}
+	cmds, err := p.parseCommands(false)
+	if err != nil {
+		return nil, err
+	}
+	s := &sieveScript{Commands: cmds}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *sieveParser) parseCommands(inBlock bool) ([]*sieveCommand, error) {
+	var cmds []*sieveCommand
+	for {
+		p.comments = nil
+		t := p.peek()
+		if t.Kind == sieveTokEOF {
+			if inBlock {
+				return nil, fmt.Errorf("ligne %d : « } » manquant", t.Line)
+			}
+			return cmds, nil
+		}
+		if inBlock && p.isPunct("}") {
+			return cmds, nil
+		}
+		cmd, err := p.parseCommand()
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+}
+
+func (p *sieveParser) parseCommand() (*sieveCommand, error) {
+	comments := p.comments
+	t := p.next()
+	if t.Kind != sieveTokIdent {
+		return nil, fmt.Errorf("ligne %d : commande attendue", t.Line)
+	}
+	cmd := &sieveCommand{Name: t.Text, Comments: comments, Line: t.Line}
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Args = args
+	if p.peek().Kind == sieveTokIdent {
+		if cmd.Test, err = p.parseTest(); err != nil {
+			return nil, err
+		}
+	}
+	if p.isPunct("{") {
+		p.next()
+		if cmd.Block, err = p.parseCommands(true); err != nil {
+			return nil, err
+		}
+		return cmd, p.expect("}")
+	}
+	return cmd, p.expect(";")
+}
+
+func (p *sieveParser) parseArgs() ([]sieveArg, error) {
+	var args []sieveArg
+	for {
+		t := p.peek()
+		switch {
+		case t.Kind == sieveTokTag:
+			p.next()
+			args = append(args, sieveArg{Tag: t.Text})
+		case t.Kind == sieveTokNum:
+			p.next()
+			args = append(args, sieveArg{Num: t.Num, IsNum: true})
+		case t.Kind == sieveTokString:
+			p.next()
+			args = append(args, sieveArg{Strs: []string{t.Text}})
+		case p.isPunct("["):
+			p.next()
+			var list []string
+			for {
+				s := p.next()
+				if s.Kind != sieveTokString {
+					return nil, fmt.Errorf("ligne %d : chaîne attendue dans la liste", s.Line)
+				}
+				list = append(list, s.Text)
+				if p.isPunct(",") {
+					p.next()
+					continue
+				}
+				if err := p.expect("]"); err != nil {
+					return nil, err
+				}
+				break
+			}
+			args = append(args, sieveArg{Strs: list, IsList: true})
+		default:
+			return args, nil
+		}
+	}
+}
+
+func (p *sieveParser) parseTest() (*sieveTest, error) {
+	t := p.next()
+	if t.Kind != sieveTokIdent {
+		return nil, fmt.Errorf("ligne %d : test attendu", t.Line)
+	}
+	test := &sieveTest{Name: t.Text}
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+	test.Args = args
+	switch {
+	case p.isPunct("("):
+		p.next()
+		for {
+			sub, err := p.parseTest()
+			if err != nil {
+				return nil, err
+			}
+			test.Tests = append(test.Tests, sub)
+			if p.isPunct(",") {
+				p.next()
+				continue
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	case p.peek().Kind == sieveTokIdent:
+		sub, err := p.parseTest()
+		if err != nil {
+			return nil, err
+		}
+		test.Tests = []*sieveTest{sub}
+	}
+	return test, nil
+}
+
+// --- Validation ---
+
+// sieveCommandExtension : extension à déclarer (require) pour chaque commande ou test.
+var sieveCommandExtension = map[string]string{
+	"fileinto": "fileinto", "reject": "reject", "ereject": "reject",
+	"addflag": "imap4flags", "setflag": "imap4flags", "removeflag": "imap4flags", "hasflag": "imap4flags",
+	"envelope": "envelope",
+}
+
+var sieveKnownCommands = map[string]bool{
+	"require": true, "if": true, "elsif": true, "else": true, "stop": true, "keep": true, "discard": true,
+	"redirect": true, "fileinto": true, "reject": true, "ereject": true, "addflag": true, "setflag": true, "removeflag": true,
+}
+
+var sieveKnownTests = map[string]bool{
+	"address": true, "header": true, "envelope": true, "exists": true, "size": true, "allof": true,
+	"anyof": true, "not": true, "true": true, "false": true, "hasflag": true,
+}
+
+func (s *sieveScript) validate() error {
+	declared := map[string]bool{}
+	supported := map[string]bool{}
+	for _, e := range sieveExtensions {
+		supported[e] = true
+	}
+	for i, cmd := range s.Commands {
+		if cmd.Name != "require" {
+			continue
+		}
+		for _, a := range s.Commands[:i] {
+			if a.Name != "require" {
+				return fmt.Errorf("ligne %d : require doit précéder les autres commandes", cmd.Line)
+			}
+		}
+		if len(cmd.Args) != 1 || cmd.Args[0].Strs == nil {
+			return fmt.Errorf("ligne %d : require attend une liste d'extensions", cmd.Line)
+		}
+		for _, ext := range cmd.Args[0].Strs {
+			ext = strings.ToLower(ext)
+			if !supported[ext] {
+				return fmt.Errorf("ligne %d : extension %q non prise en charge", cmd.Line, ext)
+			}
+			declared[ext] = true
+			s.Require = append(s.Require, ext)
+		}
+	}
+	return validateSieveBlock(s.Commands, declared, false)
+}
+
+func validateSieveBlock(cmds []*sieveCommand, declared map[string]bool, nested bool) error {
+	for i, cmd := range cmds {
+		if !sieveKnownCommands[cmd.Name] {
+			return fmt.Errorf("ligne %d : commande inconnue %q", cmd.Line, cmd.Name)
+		}
+		if ext := sieveCommandExtension[cmd.Name]; ext != "" && !declared[ext] {
+			return fmt.Errorf("ligne %d : %s nécessite require \"%s\"", cmd.Line, cmd.Name, ext)
+		}
+		switch cmd.Name {
+		case "require":
+			if nested {
+				return fmt.Errorf("ligne %d : require interdit dans un bloc", cmd.Line)
+			}
+		case "if", "elsif":
+			if cmd.Test == nil {
+				return fmt.Errorf("ligne %d : %s sans test", cmd.Line, cmd.Name)
+			}
+			if cmd.Name == "elsif" && (i == 0 || (cmds[i-1].Name != "if" && cmds[i-1].Name != "elsif")) {
+				return fmt.Errorf("ligne %d : elsif sans if", cmd.Line)
+			}
+			if err := validateSieveTest(cmd.Test, declared, cmd.Line); err != nil {
+				return err
+			}
+		case "else":
+			if i == 0 || (cmds[i-1].Name != "if" && cmds[i-1].Name != "elsif") {
+				return fmt.Errorf("ligne %d : else sans if", cmd.Line)
+			}
+		case "fileinto", "redirect", "reject", "ereject":
+			if len(sieveStringArgs(cmd.Args)) != 1 {
+				return fmt.Errorf("ligne %d : %s attend une chaîne", cmd.Line, cmd.Name)
+			}
+			if cmd.Name == "redirect" {
+				if _, err := mail.ParseAddress(sieveStringArgs(cmd.Args)[0][0]); err != nil {
+					return fmt.Errorf("ligne %d : adresse de redirection invalide", cmd.Line)
+				}
+			}
+			if sieveHasTag(cmd.Args, "copy") && !declared["copy"] {
+				return fmt.Errorf("ligne %d : :copy nécessite require \"copy\"", cmd.Line)
+			}
+		case "addflag", "setflag", "removeflag":
+			if len(sieveStringArgs(cmd.Args)) == 0 {
+				return fmt.Errorf("ligne %d : %s attend une liste de drapeaux", cmd.Line, cmd.Name)
+			}
+		}
+		isBlock := cmd.Name == "if" || cmd.Name == "elsif" || cmd.Name == "else"
+		if isBlock && cmd.Block == nil {
+			return fmt.Errorf("ligne %d : %s attend un bloc { }", cmd.Line, cmd.Name)
+		}
+		if !isBlock && cmd.Block != nil {
+			return fmt.Errorf("ligne %d : %s n'accepte pas de bloc", cmd.Line, cmd.Name)
+		}
+		if err := validateSieveBlock(cmd.Block, declared, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateSieveTest(t *sieveTest, declared map[string]bool, line int) error {
+	if !sieveKnownTests[t.Name] {
+		return fmt.Errorf("ligne %d : test inconnu %q", line, t.Name)
+	}
+	if ext := sieveCommandExtension[t.Name]; ext != "" && !declared[ext] {
+		return fmt.Errorf("ligne %d : %s nécessite require \"%s\"", line, t.Name, ext)
+	}
+	switch t.Name {
+	case "allof", "anyof":
+		if len(t.Tests) == 0 {
+			return fmt.Errorf("ligne %d : %s attend une liste de tests", line, t.Name)
+		}
+	case "not":
+		if len(t.Tests) != 1 {
+			return fmt.Errorf("ligne %d : not attend un test", line)
+		}
+	case "header", "address", "envelope":
+		if len(sieveStringArgs(t.Args)) != 2 {
+			return fmt.Errorf("ligne %d : %s attend une liste d'en-têtes et une liste de valeurs", line, t.Name)
+		}
+	case "exists", "hasflag":
+		if len(sieveStringArgs(t.Args)) == 0 {
+			return fmt.Errorf("ligne %d : %s attend une liste", line, t.Name)
+		}
+	case "size":
+		if (!sieveHasTag(t.Args, "over") && !sieveHasTag(t.Args, "under")) || sieveNumArg(t.Args) < 0 {
+			return fmt.Errorf("ligne %d : size attend :over ou :under et un nombre", line)
+		}
+	}
+	for _, sub := range t.Tests {
+		if err := validateSieveTest(sub, declared, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sieveStringArgs : arguments chaîne positionnels (la valeur de :comparator est exclue).
+func sieveStringArgs(args []sieveArg) [][]string {
+	var out [][]string
+	for i, a := range args {
+		if a.Strs != nil && (i == 0 || args[i-1].Tag != "comparator") {
+			out = append(out, a.Strs)
+		}
+	}
+	return out
+}
+
+func sieveHasTag(args []sieveArg, tag string) bool {
+	for _, a := range args {
+		if a.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func sieveNumArg(args []sieveArg) int64 {
+	for _, a := range args {
+		if a.IsNum {
+			return a.Num
+		}
+	}
+	return -1
+}
+
+// --- Évaluation ---
+
+// sieveMessage : ce que l'interpréteur voit d'un message (en-têtes en minuscules).
+type sieveMessage struct {
+	Headers      map[string][]string
+	Size         int64
+	EnvelopeFrom string
+	EnvelopeTo   string
+	InitialFlags []string
+}
+
+// sieveOutcome : actions retenues après exécution (keep implicite si aucune action de dépôt).
+type sieveOutcome struct {
+	Keep     bool
+	FileInto []string
+	Redirect []string
+	Discard  bool
+	Reject   string
+	Flags    []string
+}
+
+type sieveRun struct {
+	msg      *sieveMessage
+	out      sieveOutcome
+	flags    []string
+	explicit bool
+	cancel   bool
+}
+
+// evalSieve exécute le script sur le message (RFC 5228 §2.10.2 : keep implicite).
+func evalSieve(s *sieveScript, msg *sieveMessage) sieveOutcome {
+	r := &sieveRun{msg: msg, flags: append([]string(nil), msg.InitialFlags...)}
+	r.exec(s.Commands)
+	if !r.cancel && !r.explicit {
+		r.out.Keep = true
+	}
+	r.out.Flags = r.flags
+	return r.out
+}
+
+// exec retourne false quand « stop » interrompt le script.
+func (r *sieveRun) exec(cmds []*sieveCommand) bool {
+	matched := false
+	for _, cmd := range cmds {
+		switch cmd.Name {
+		case "if":
+			matched = r.test(cmd.Test)
+			if matched && !r.exec(cmd.Block) {
+				return false
+			}
+		case "elsif":
+			if !matched {
+				matched = r.test(cmd.Test)
+				if matched && !r.exec(cmd.Block) {
+					return false
+				}
+			}
+		case "else":
+			if !matched && !r.exec(cmd.Block) {
+				return false
+			}
+		case "stop":
+			return false
+		case "keep":
+			r.out.Keep = true
+			r.explicit = true
+		case "discard":
+			r.out.Discard = true
+			r.cancel = true
+		case "fileinto":
+			r.out.FileInto = appendUnique(r.out.FileInto, sieveStringArgs(cmd.Args)[0][0])
+			if !sieveHasTag(cmd.Args, "copy") {
+				r.explicit = true
+			}
+		case "redirect":
+			r.out.Redirect = appendUnique(r.out.Redirect, sieveStringArgs(cmd.Args)[0][0])
+			if !sieveHasTag(cmd.Args, "copy") {
+				r.explicit = true
+			}
+		case "reject", "ereject":
+			r.out.Reject = sieveStringArgs(cmd.Args)[0][0]
+			r.cancel = true
+		case "addflag":
+			for _, f := range sieveFlagList(cmd.Args) {
+				r.flags = appendUnique(r.flags, f)
+			}
+		case "setflag":
+			r.flags = sieveFlagList(cmd.Args)
+		case "removeflag":
+			drop := map[string]bool{}
+			for _, f := range sieveFlagList(cmd.Args) {
+				drop[strings.ToLower(f)] = true
+			}
+			kept := r.flags[:0]
+			for _, f := range r.flags {
+				if !drop[strings.ToLower(f)] {
+					kept = append(kept, f)
+				}
+			}
+			r.flags = kept
+		}
+	}
+	return true
+}
+
+func appendUnique(list []string, v string) []string {
+	for _, x := range list {
+		if strings.EqualFold(x, v) {
+			return list
+		}
+	}
+	return append(list, v)
+}
+
+// sieveFlagList : drapeaux séparés par des espaces, dans une ou plusieurs chaînes.
+func sieveFlagList(args []sieveArg) []string {
+	var out []string
+	for _, list := range sieveStringArgs(args) {
+		for _, s := range list {
+			for _, f := range strings.Fields(s) {
+				out = appendUnique(out, f)
+			}
+		}
+	}
+	return out
+}
+
+func (r *sieveRun) test(t *sieveTest) bool {
+	switch t.Name {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "not":
+		return !r.test(t.Tests[0])
+	case "allof":
+		for _, sub := range t.Tests {
+			if !r.test(sub) {
+				return false
+			}
+		}
+		return true
+	case "anyof":
+		for _, sub := range t.Tests {
+			if r.test(sub) {
+				return true
+			}
+		}
+		return false
+	case "exists":
+		for _, h := range sieveStringArgs(t.Args)[0] {
+			if len(r.msg.Headers[strings.ToLower(h)]) == 0 {
+				return false
+			}
+		}
+		return true
+	case "size":
+		n := sieveNumArg(t.Args)
+		if sieveHasTag(t.Args, "over") {
+			return r.msg.Size > n
+		}
+		return r.msg.Size < n
+	case "hasflag":
+		keys := sieveStringArgs(t.Args)[0]
+		for _, f := range r.flags {
+			if sieveMatchAny(t.Args, f, keys) {
+				return true
+			}
+		}
+		return false
+	case "header", "address", "envelope":
+		lists := sieveStringArgs(t.Args)
+		names, keys := lists[0], lists[1]
+		for _, name := range names {
+			for _, v := range r.values(t, strings.ToLower(name)) {
+				if sieveMatchAny(t.Args, v, keys) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// values : valeurs comparées pour header / address / envelope (partie d'adresse selon l'étiquette).
+func (r *sieveRun) values(t *sieveTest, name string) []string {
+	var raw []string
+	if t.Name == "envelope" {
+		switch name {
+		case "from":
+			raw = []string{r.msg.EnvelopeFrom}
+		case "to":
+			raw = []string{r.msg.EnvelopeTo}
+		}
+	} else {
+		raw = r.msg.Headers[name]
+	}
+	if t.Name == "header" {
+		return raw
+	}
+	var out []string
+	for _, v := range raw {
+		addrs, err := mail.ParseAddressList(v)
+		if err != nil || len(addrs) == 0 {
+			addrs = []*mail.Address{{Address: strings.Trim(strings.TrimSpace(v), "<>")}}
+		}
+		for _, a := range addrs {
+			out = append(out, sieveAddressPart(t.Args, a.Address))
+		}
+	}
+	return out
+}
+
+func sieveAddressPart(args []sieveArg, addr string) string {
+	at := strings.LastIndex(addr, "@")
+	switch {
+	case sieveHasTag(args, "localpart"):
+		if at < 0 {
+			return addr
+		}
+		return addr[:at]
+	case sieveHasTag(args, "domain"):
+		if at < 0 {
+			return ""
+		}
+		return addr[at+1:]
+	}
+	return addr
+}
+
+func sieveComparatorOctet(args []sieveArg) bool {
+	for i, a := range args {
+		if a.Tag == "comparator" && i+1 < len(args) && len(args[i+1].Strs) == 1 {
+			return args[i+1].Strs[0] == "i;octet"
+		}
+	}
+	return false
+}
+
+func sieveMatchAny(args []sieveArg, value string, keys []string) bool {
+	octet := sieveComparatorOctet(args)
+	if !octet {
+		value = strings.ToLower(value)
+	}
+	for _, k := range keys {
+		if !octet {
+			k = strings.ToLower(k)
+		}
+		switch {
+		case sieveHasTag(args, "contains"):
+			if strings.Contains(value, k) {
+				return true
+			}
+		case sieveHasTag(args, "matches"):
+			if sieveGlobMatch(k, value) {
+				return true
+			}
+		default:
+			if value == k {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sieveGlobMatch : joker Sieve « * » (toute suite) et « ? » (un caractère), « \\ » échappe.
+func sieveGlobMatch(pattern, value string) bool {
+	p, v := []rune(pattern), []rune(value)
+	star, mark := -1, 0
+	i, j := 0, 0
+	for j < len(v) {
+		switch {
+		case i < len(p) && p[i] == '*':
+			star, mark = i, j
+			i++
+		case i < len(p) && p[i] == '\\' && i+1 < len(p) && p[i+1] == v[j]:
+			i += 2
+			j++
+		case i < len(p) && p[i] != '\\' && (p[i] == '?' || p[i] == v[j]):
+			i++
+			j++
+		case star >= 0:
+			mark++
+			i, j = star+1, mark
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
+
+// sieveQuote échappe une chaîne pour un script généré.
+func sieveQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Import / export des règles de tri (MailFilterRule) au format Sieve. Chaque règle devient un
+// bloc « if … { …; stop; } » précédé de « # rule: <nom> » : Cloudity n'applique que la première
+// règle correspondante, ce que reproduit le stop. Les étiquettes passent par imap4flags
+// (mot-clé = nom de l'étiquette, espaces remplacés par « _ »).
+
+// sieveRuleSpec : règle issue d'un script, avant résolution des étiquettes et du dossier.
+type sieveRuleSpec struct {
+	Name              string
+	FromPattern       string
+	FromDomainPattern string
+	RecipientPattern  string
+	SubjectPattern    string
+	HasTagName        string
+	AddTagName        string
+	ActionFolder      string
+	MarkRead          *bool
+}
+
+func sieveTagKeyword(name string) string {
+	return strings.Join(strings.Fields(name), "_")
+}
+
+// rulesToSieve produit le script des règles actives ; tagNames résout has_tag_id / add_tag_id.
+func rulesToSieve(rules []MailFilterRule, tagNames map[int]string) (string, []string) {
+	var warnings []string
+	var body strings.Builder
+	needFileinto, needFlags := false, false
+	for _, r := range rules {
+		if !r.Enabled {
+			continue
+		}
+		if r.Conditions != nil || r.Actions != nil {
+			warnings = append(warnings, fmt.Sprintf("règle %q : conditions ou actions enrichies sans équivalent Sieve, règle omise", r.Name))
+			continue
+		}
+		var tests []string
+		if p := strings.TrimSpace(r.FromPattern); p != "" {
+			tests = append(tests, "header :contains \"from\" "+sieveQuote(p))
+		}
+		if d := normalizeFromDomainPattern(r.FromDomainPattern); d != "" {
+			tests = append(tests, "address :domain :matches \"from\" ["+sieveQuote(d)+", "+sieveQuote("*."+d)+"]")
+		}
+		if p := strings.TrimSpace(r.RecipientPattern); p != "" {
+			tests = append(tests, "header :contains \"to\" "+sieveQuote(p))
+		}
+		if p := strings.TrimSpace(r.SubjectPattern); p != "" {
+			tests = append(tests, "header :contains \"subject\" "+sieveQuote(p))
+		}
+		if r.HasTagID != nil {
+			name, ok := tagNames[*r.HasTagID]
+			if !ok {
+				warnings = append(warnings, fmt.Sprintf("règle %q : étiquette critère introuvable, condition ignorée", r.Name))
+			} else {
+				tests = append(tests, "hasflag :is "+sieveQuote(sieveTagKeyword(name)))
+				needFlags = true
+			}
+		}
+		if r.HasAttachments != nil {
+			warnings = append(warnings, fmt.Sprintf("règle %q : condition « pièces jointes » sans équivalent Sieve, ignorée", r.Name))
+		}
+		if len(tests) == 0 {
+			warnings = append(warnings, fmt.Sprintf("règle %q : aucune condition exportable, règle omise", r.Name))
+			continue
+		}
+		cond := tests[0]
+		if len(tests) > 1 {
+			cond = "allof(" + strings.Join(tests, ",\n      ") + ")"
+		}
+		fmt.Fprintf(&body, "\n# rule: %s\nif %s {\n", strings.ReplaceAll(r.Name, "\n", " "), cond)
+		if r.MarkRead != nil {
+			needFlags = true
+			if *r.MarkRead {
+				body.WriteString("    addflag \"\\\\Seen\";\n")
+			} else {
+				body.WriteString("    removeflag \"\\\\Seen\";\n")
+			}
+		}
+		if r.AddTagID != nil {
+			if name, ok := tagNames[*r.AddTagID]; ok {
+				fmt.Fprintf(&body, "    addflag %s;\n", sieveQuote(sieveTagKeyword(name)))
+				needFlags = true
+			} else {
+				warnings = append(warnings, fmt.Sprintf("règle %q : étiquette action introuvable, ignorée", r.Name))
+			}
+		}
+		folder := strings.TrimSpace(r.ActionFolder)
+		if folder == "" || strings.EqualFold(folder, "inbox") {
+			body.WriteString("    keep;\n")
+		} else {
+			fmt.Fprintf(&body, "    fileinto %s;\n", sieveQuote(folder))
+			needFileinto = true
+		}
+		body.WriteString("    stop;\n}\n")
+	}
+	var req []string
+	if needFileinto {
+		req = append(req, `"fileinto"`)
+	}
+	if needFlags {
+		req = append(req, `"imap4flags"`)
+	}
+	out := "# Règles de tri Cloudity (export Sieve, RFC 5228)\n"
+	if len(req) > 0 {
+		out += "require [" + strings.Join(req, ", ") + "];\n"
+	}
+	return out + body.String(), warnings
+}
+
+// sieveToRuleSpecs convertit un script en règles Cloudity. Les constructions sans équivalent
+// (else, discard, redirect, reject, tests autres que :contains / :is simples…) sont ignorées
+// et signalées dans les avertissements.
+func sieveToRuleSpecs(s *sieveScript) ([]sieveRuleSpec, []string) {
+	var specs []sieveRuleSpec
+	var warnings []string
+	missingStop := false
+	for _, cmd := range s.Commands {
+		switch cmd.Name {
+		case "require":
+			continue
+		case "if", "elsif":
+		case "else":
+			warnings = append(warnings, fmt.Sprintf("ligne %d : else sans condition, ignoré", cmd.Line))
+			continue
+		default:
+			warnings = append(warnings, fmt.Sprintf("ligne %d : %s hors d'un if, ignoré", cmd.Line, cmd.Name))
+			continue
+		}
+		name := sieveRuleName(cmd)
+		action, hasStop, err := sieveRuleActions(cmd.Block)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("ligne %d (%s) : %v, règle ignorée", cmd.Line, name, err))
+			continue
+		}
+		if !hasStop {
+			missingStop = true
+		}
+		alternatives := []*sieveTest{cmd.Test}
+		if cmd.Test.Name == "anyof" {
+			alternatives = cmd.Test.Tests
+		}
+		for i, t := range alternatives {
+			spec := action
+			spec.Name = name
+			if len(alternatives) > 1 {
+				spec.Name = fmt.Sprintf("%s (%d)", name, i+1)
+			}
+			if err := sieveApplyCondition(&spec, t); err != nil {
+				warnings = append(warnings, fmt.Sprintf("ligne %d (%s) : %v, règle ignorée", cmd.Line, spec.Name, err))
+				continue
+			}
+			if spec.FromPattern == "" && spec.FromDomainPattern == "" && spec.RecipientPattern == "" &&
+				spec.SubjectPattern == "" && spec.HasTagName == "" {
+				warnings = append(warnings, fmt.Sprintf("ligne %d (%s) : aucune condition, règle ignorée", cmd.Line, spec.Name))
+				continue
+			}
+			specs = append(specs, spec)
+		}
+	}
+	if missingStop {
+		warnings = append(warnings, "bloc sans stop : Cloudity n'applique que la première règle correspondante")
+	}
+	return specs, warnings
+}
+
+func sieveRuleName(cmd *sieveCommand) string {
+	for _, c := range cmd.Comments {
+		if n, ok := strings.CutPrefix(c, "rule:"); ok && strings.TrimSpace(n) != "" {
+			return strings.TrimSpace(n)
+		}
+	}
+	return fmt.Sprintf("Sieve ligne %d", cmd.Line)
+}
+
+func sieveRuleActions(block []*sieveCommand) (sieveRuleSpec, bool, error) {
+	var spec sieveRuleSpec
+	stop := false
+	for _, a := range block {
+		switch a.Name {
+		case "keep":
+			spec.ActionFolder = "inbox"
+		case "fileinto":
+			if sieveHasTag(a.Args, "copy") {
+				return spec, false, fmt.Errorf("fileinto :copy non pris en charge")
+			}
+			if spec.ActionFolder != "" && spec.ActionFolder != "inbox" {
+				return spec, false, fmt.Errorf("plusieurs fileinto")
+			}
+			folder := strings.TrimSpace(sieveStringArgs(a.Args)[0][0])
+			if isStandardMailFolder(folder) {
+				folder = strings.ToLower(folder)
+			}
+			spec.ActionFolder = folder
+		case "addflag", "removeflag":
+			for _, f := range sieveFlagList(a.Args) {
+				if strings.EqualFold(f, `\Seen`) {
+					v := a.Name == "addflag"
+					spec.MarkRead = &v
+					continue
+				}
+				if a.Name == "removeflag" || strings.HasPrefix(f, `\`) || spec.AddTagName != "" {
+					return spec, false, fmt.Errorf("%s %q non pris en charge", a.Name, f)
+				}
+				spec.AddTagName = f
+			}
+		case "stop":
+			stop = true
+		default:
+			return spec, false, fmt.Errorf("action %s non prise en charge", a.Name)
+		}
+	}
+	if spec.ActionFolder == "" {
+		spec.ActionFolder = "inbox"
+	}
+	return spec, stop, nil
+}
+
+// sieveApplyCondition reporte un test (ou un allof de tests) sur les champs de la règle.
+func sieveApplyCondition(spec *sieveRuleSpec, t *sieveTest) error {
+	if t.Name == "allof" {
+		for _, sub := range t.Tests {
+			if err := sieveApplyCondition(spec, sub); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	set := func(field *string, v string) error {
+		if *field != "" || strings.TrimSpace(v) == "" {
+			return fmt.Errorf("condition %s non convertible", t.Name)
+		}
+		*field = strings.TrimSpace(v)
+		return nil
+	}
+	if sieveComparatorOctet(t.Args) {
+		return fmt.Errorf("comparateur i;octet non pris en charge")
+	}
+	switch t.Name {
+	case "true":
+		return nil
+	case "hasflag":
+		keys := sieveStringArgs(t.Args)[0]
+		if len(keys) != 1 || sieveHasTag(t.Args, "contains") || sieveHasTag(t.Args, "matches") {
+			return fmt.Errorf("hasflag non convertible")
+		}
+		return set(&spec.HasTagName, keys[0])
+	case "header", "address":
+		lists := sieveStringArgs(t.Args)
+		if len(lists[0]) != 1 {
+			return fmt.Errorf("%s sur plusieurs en-têtes non convertible", t.Name)
+		}
+		field := strings.ToLower(lists[0][0])
+		keys := lists[1]
+		if t.Name == "address" && sieveHasTag(t.Args, "domain") && field == "from" {
+			if d, ok := sieveDomainKeys(keys, sieveHasTag(t.Args, "matches")); ok {
+				return set(&spec.FromDomainPattern, d)
+			}
+			return fmt.Errorf("address :domain non convertible")
+		}
+		if len(keys) != 1 || sieveHasTag(t.Args, "matches") || sieveHasTag(t.Args, "localpart") || sieveHasTag(t.Args, "domain") {
+			return fmt.Errorf("%s %q non convertible (seul :contains / :is à une valeur)", t.Name, field)
+		}
+		switch field {
+		case "from":
+			return set(&spec.FromPattern, keys[0])
+		case "to":
+			return set(&spec.RecipientPattern, strings.ToLower(keys[0]))
+		case "subject":
+			if t.Name == "header" {
+				return set(&spec.SubjectPattern, keys[0])
+			}
+		}
+		return fmt.Errorf("en-tête %q non pris en charge", field)
+	}
+	return fmt.Errorf("test %s non pris en charge", t.Name)
+}
+
+// sieveDomainKeys reconnaît « d » seul (:is) ou la paire [d, *.d] produite par l'export.
+func sieveDomainKeys(keys []string, matches bool) (string, bool) {
+	var dom string
+	for _, k := range keys {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if matches && strings.HasPrefix(k, "*.") {
+			k = k[2:]
+		}
+		if strings.ContainsAny(k, "*?") || k == "" || (dom != "" && dom != k) {
+			return "", false
+		}
+		dom = k
+	}
+	return dom, dom != ""
+}
+
+func (h *Handler) mailTagNames(ctx context.Context, accountID int) (map[int]string, error) {
+	rows, err := h.dbex(ctx).Query(`
+		SELECT t.id, t.name FROM mail_tags t
+		JOIN user_email_accounts a ON a.id = t.account_id
+		WHERE t.account_id = $1 AND a.user_id = current_setting('app.current_user_id', true)::INTEGER
+	`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int]string{}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		out[id] = name
+	}
+	return out, rows.Err()
+}
+
+func (h *Handler) exportMailRulesSieve(c *gin.Context) {
+	accountID, ok := parsePositiveParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return
+	}
+	ctx := c.Request.Context()
+	rules, err := h.loadMailFilterRules(ctx, accountID, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tags, err := h.mailTagNames(ctx, accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	script, warnings := rulesToSieve(rules, tags)
+	if strings.Contains(c.GetHeader("Accept"), "application/json") {
+		c.JSON(http.StatusOK, gin.H{"script": script, "warnings": warnings})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="cloudity-rules-%d.sieve"`, accountID))
+	c.Data(http.StatusOK, "application/sieve; charset=utf-8", []byte(script))
+}
+
+// importMailRulesSieve : POST {script, replace}. replace=true supprime d'abord les règles
+// existantes (hors règles d'alias « Alias · … », gérées par les alias).
+func (h *Handler) importMailRulesSieve(c *gin.Context) {
+	accountID, ok := parsePositiveParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return
+	}
+	var body struct {
+		Script  string `json:"script"`
+		Replace bool   `json:"replace"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.Script) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "script Sieve requis"})
+		return
+	}
+	script, err := parseSieve(body.Script)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "script Sieve invalide : " + err.Error()})
+		return
+	}
+	specs, warnings := sieveToRuleSpecs(script)
+	ctx := c.Request.Context()
+	var owned int
+	if err := h.dbex(ctx).QueryRow(`
+		SELECT 1 FROM user_email_accounts
+		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER
+	`, accountID).Scan(&owned); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+	var valid []sieveRuleSpec
+	for _, s := range specs {
+		if !h.folderAllowed(ctx, accountID, s.ActionFolder) {
+			warnings = append(warnings, fmt.Sprintf("%s : dossier %q inconnu, règle ignorée", s.Name, s.ActionFolder))
+			continue
+		}
+		valid = append(valid, s)
+	}
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	if body.Replace {
+		if _, err := tx.Exec(`DELETE FROM mail_filter_rules WHERE account_id = $1 AND name NOT LIKE 'Alias · %'`, accountID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var order int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(rule_order), 0) FROM mail_filter_rules WHERE account_id = $1 AND rule_order < 900`, accountID).Scan(&order); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tagIDs := map[string]int{}
+	tagID := func(name string) (*int, error) {
+		if name == "" {
+			return nil, nil
+		}
+		key := strings.ToLower(name)
+		if id, ok := tagIDs[key]; ok {
+			return &id, nil
+		}
+		var id int
+		err := tx.QueryRow(`
+			SELECT id FROM mail_tags
+			WHERE account_id = $1 AND (LOWER(name) = $2 OR LOWER(REPLACE(name, ' ', '_')) = $2)
+			ORDER BY id LIMIT 1
+		`, accountID, key).Scan(&id)
+		if err == sql.ErrNoRows {
+			err = tx.QueryRow(`INSERT INTO mail_tags (account_id, name, color) VALUES ($1, $2, 'slate') RETURNING id`,
+				accountID, strings.ReplaceAll(name, "_", " ")).Scan(&id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		tagIDs[key] = id
+		return &id, nil
+	}
+	imported := 0
+	for _, s := range valid {
+		hasTag, err := tagID(s.HasTagName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		addTag, err := tagID(s.AddTagName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		order += 10
+		criteriaJSON, _ := json.Marshal(map[string]interface{}{
+			"from_pattern":        s.FromPattern,
+			"from_domain_pattern": s.FromDomainPattern,
+			"recipient_pattern":   s.RecipientPattern,
+			"subject_pattern":     s.SubjectPattern,
+			"has_attachments":     nil,
+			"has_tag_id":          hasTag,
+		})
+		actionsJSON, _ := json.Marshal(map[string]interface{}{
+			"action_folder": s.ActionFolder,
+			"mark_read":     s.MarkRead,
+			"add_tag_id":    addTag,
+		})
+		if _, err := tx.Exec(`
+			INSERT INTO mail_filter_rules(account_id, name, from_pattern, from_domain_pattern, recipient_pattern, has_tag_id, add_tag_id, subject_pattern, action_folder, mark_read, enabled, rule_order, criteria_json, actions_json)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, true, $11, $12::jsonb, $13::jsonb)
+		`, accountID, s.Name, s.FromPattern, s.FromDomainPattern, s.RecipientPattern, hasTag, addTag, s.SubjectPattern,
+			s.ActionFolder, s.MarkRead, order, string(criteriaJSON), string(actionsJSON)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		imported++
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if warnings == nil {
+		warnings = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "imported": imported, "warnings": warnings})
+}
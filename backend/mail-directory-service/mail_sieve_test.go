@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSieveRejectsInvalidScripts(t *testing.T) {
+	cases := map[string]string{
+		"missing require":    `fileinto "Archive";`,
+		"unknown extension":  `require "vacation";`,
+		"unknown command":    `frobnicate;`,
+		"unterminated block": `if true { keep;`,
+		"else without if":    `else { keep; }`,
+		"bad redirect":       `redirect "not an address";`,
+		"size without tag":   `if size 10K { discard; }`,
+		"late require":       "keep;\nrequire \"fileinto\";",
+	}
+	for name, src := range cases {
+		if _, err := parseSieve(src); err == nil {
+			t.Errorf("%s: script accepted: %s", name, src)
+		}
+	}
+}
+
+func TestEvalSieve(t *testing.T) {
+	src := `require ["fileinto", "imap4flags", "reject"];
+# newsletters
+if allof(address :domain :is "from" "news.example", header :contains "subject" "promo") {
+    addflag "\\Seen";
+    fileinto "Newsletters";
+    stop;
+} elsif header :matches "subject" "*[SPAM]*" {
+    discard;
+} elsif size :over 1M {
+    reject text:
+Message trop volumineux.
+.
+;
+}
+if exists "x-priority" { addflag "urgent"; }
+`
+	s, err := parseSieve(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := func(from, subject string, size int64) *sieveMessage {
+		return &sieveMessage{Headers: map[string][]string{"from": {from}, "subject": {subject}}, Size: size}
+	}
+	got := evalSieve(s, msg("Shop <deals@news.example>", "Big PROMO today", 10))
+	if got.Keep || !reflect.DeepEqual(got.FileInto, []string{"Newsletters"}) || !reflect.DeepEqual(got.Flags, []string{`\Seen`}) {
+		t.Errorf("newsletter outcome = %+v", got)
+	}
+	if got := evalSieve(s, msg("a@b.fr", "hello [spam] there", 10)); !got.Discard || got.Keep {
+		t.Errorf("spam outcome = %+v", got)
+	}
+	if got := evalSieve(s, msg("a@b.fr", "big", 2<<20)); got.Reject != "Message trop volumineux.\r\n" || got.Keep {
+		t.Errorf("reject outcome = %+v", got)
+	}
+	m := msg("a@b.fr", "hi", 10)
+	m.Headers["x-priority"] = []string{"1"}
+	if got := evalSieve(s, m); !got.Keep || !reflect.DeepEqual(got.Flags, []string{"urgent"}) {
+		t.Errorf("implicit keep outcome = %+v", got)
+	}
+}
+
+func TestSieveGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern, value string
+		want           bool
+	}{
+		{"*", "", true},
+		{"a*c", "abbbc", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"*/x/*", "dir/x/file", true},
+	}
+	for _, tc := range cases {
+		if got := sieveGlobMatch(tc.pattern, tc.value); got != tc.want {
+			t.Errorf("sieveGlobMatch(%q, %q) = %v", tc.pattern, tc.value, got)
+		}
+	}
+}
+
+func TestRulesSieveRoundTrip(t *testing.T) {
+	yes, no := true, false
+	tag := 7
+	rules := []MailFilterRule{
+		{Name: "Factures", FromDomainPattern: "@Billing.Example", SubjectPattern: "facture", ActionFolder: "archive", MarkRead: &yes, AddTagID: &tag, Enabled: true},
+		{Name: "Équipe", RecipientPattern: "team@x.fr", ActionFolder: "inbox", MarkRead: &no, Enabled: true},
+		{Name: "Désactivée", FromPattern: "x", ActionFolder: "trash", Enabled: false},
+		{Name: "PJ seules", HasAttachments: &yes, ActionFolder: "archive", Enabled: true},
+	}
+	script, warnings := rulesToSieve(rules, map[int]string{7: "À payer"})
+	if len(warnings) != 2 {
+		t.Errorf("warnings = %v", warnings)
+	}
+	parsed, err := parseSieve(script)
+	if err != nil {
+		t.Fatalf("exported script does not parse: %v\n%s", err, script)
+	}
+	specs, warnings := sieveToRuleSpecs(parsed)
+	if len(warnings) != 0 {
+		t.Errorf("import warnings = %v", warnings)
+	}
+	want := []sieveRuleSpec{
+		{Name: "Factures", FromDomainPattern: "billing.example", SubjectPattern: "facture", AddTagName: "À_payer", ActionFolder: "archive", MarkRead: &yes},
+		{Name: "Équipe", RecipientPattern: "team@x.fr", ActionFolder: "inbox", MarkRead: &no},
+	}
+	if !reflect.DeepEqual(specs, want) {
+		t.Errorf("round trip = %+v\nscript:\n%s", specs, script)
+	}
+	out := evalSieve(parsed, &sieveMessage{Headers: map[string][]string{
+		"from": {"Compta <noreply@eu.billing.example>"}, "subject": {"Votre facture"},
+	}})
+	if !reflect.DeepEqual(out.FileInto, []string{"archive"}) {
+		t.Errorf("exported script evaluation = %+v", out)
+	}
+}
+
+func TestSieveToRuleSpecsWarnings(t *testing.T) {
+	s, err := parseSieve(`require ["fileinto"];
+if anyof(header :contains "from" "a@x.fr", header :contains "from" "b@x.fr") { fileinto "Amis"; stop; }
+if header :contains "subject" "x" { discard; }
+if header :contains "cc" "y" { keep; }
+if header :contains "subject" "z" { fileinto "Z"; }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	specs, warnings := sieveToRuleSpecs(s)
+	if len(specs) != 3 || specs[0].Name != "Sieve ligne 2 (1)" || specs[1].FromPattern != "b@x.fr" || specs[2].ActionFolder != "Z" {
+		t.Errorf("specs = %+v", specs)
+	}
+	if len(warnings) != 3 || !strings.Contains(warnings[2], "stop") {
+		t.Errorf("warnings = %v", warnings)
+	}
+}
+
+func TestParseSieveHeaderBlock(t *testing.T) {
+	h := parseSieveHeaderBlock("From: a@x.fr\r\nSubject: =?UTF-8?Q?Caf=C3=A9?=\r\n  suite\r\nReceived: 1\r\nReceived: 2\r\n\r\nBody: no\r\n")
+	if h["subject"][0] != "Café suite" || len(h["received"]) != 2 || h["body"] != nil {
+		t.Errorf("headers = %v", h)
+	}
+}
+
+func TestSieveRulesRoutes(t *testing.T) {
+	r := setupRouter(nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/mail/me/accounts/1/rules/sieve", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("export without X-User-ID: got %d", w.Code)
+	}
+	for _, body := range []string{`{}`, `{"script":"if true {"}`} {
+		req := httptest.NewRequest(http.MethodPost, "/mail/me/accounts/1/rules/sieve", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		setAdminMailHeaders(req)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("import %s: got %d %s", body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestInternalSieveEvaluateRequiresToken(t *testing.T) {
+	t.Setenv("MTA_INTERNAL_TOKEN", "test-mta-secret-token-32chars")
+	r := setupRouter(nil)
+	req := httptest.NewRequest(http.MethodPost, "/mail/internal/sieve/evaluate", strings.NewReader(`{"recipient":"a@x.fr"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("got %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Classifieur bayésien du spam (MAIL-SPAM-02). Jetons : mots de l'objet (préfixe « s: »),
+// adresse et domaine de l'expéditeur (« f: », « d: »), mots du corps (sans préfixe). Modèle
+// personnel (déplacements vers / hors Spam) + modèle de base du tenant (admin), sommés au
+// score ; combinaison de Robinson sur les jetons les plus marqués. Tant que le modèle n'a
+// pas assez d'exemples des deux classes, seul spamHeuristicScore s'applique.
+
+const (
+	spamMaxTokens         = 500
+	spamMaxTokenRunes     = 30
+	spamMinTokenRunes     = 3
+	spamBodyScanBytes     = 20000
+	spamMinClassMessages  = 5
+	spamInterestingTokens = 15
+	spamRobinsonStrength  = 1.0
+	spamBaseTrainMax      = 500
+)
+
+// spamTokens — jetons distincts d'un message, dans l'ordre d'apparition (plafonnés).
+func spamTokens(subject, fromAddr, body string) []string {
+	seen := map[string]bool{}
+	var out []string
+	add := func(tok string) {
+		if len(out) >= spamMaxTokens || seen[tok] || len(tok) > 64 {
+			return
+		}
+		seen[tok] = true
+		out = append(out, tok)
+	}
+	if addr := senderAddress(fromAddr); strings.Contains(addr, "@") {
+		add("f:" + addr)
+		add("d:" + addr[strings.LastIndex(addr, "@")+1:])
+	}
+	for _, w := range spamWords(subject) {
+		add("s:" + w)
+	}
+	if len(body) > spamBodyScanBytes {
+		body = body[:spamBodyScanBytes]
+	}
+	for _, w := range spamWords(body) {
+		add(w)
+	}
+	return out
+}
+
+func spamWords(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := fields[:0]
+	for _, f := range fields {
+		n := utf8.RuneCountInString(f)
+		if n < spamMinTokenRunes || n > spamMaxTokenRunes {
+			continue
+		}
+		if strings.IndexFunc(f, unicode.IsLetter) < 0 {
+			continue
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// spamModel — comptes agrégés (personnel + base tenant) pour les jetons d'un message.
+type spamModel struct {
+	SpamMessages int
+	HamMessages  int
+	Tokens       map[string][2]int // jeton → {spam, légitime}
+}
+
+func (m *spamModel) ready() bool {
+	return m != nil && m.SpamMessages >= spamMinClassMessages && m.HamMessages >= spamMinClassMessages
+}
+
+// probability — probabilité de spam (0–1) ; 0.5 sans jeton significatif.
+func (m *spamModel) probability(tokens []string) float64 {
+	var fs []float64
+	for _, tok := range tokens {
+		cnt, ok := m.Tokens[tok]
+		if !ok || cnt[0]+cnt[1] == 0 {
+			continue
+		}
+		ps := math.Min(1, float64(cnt[0])/float64(m.SpamMessages))
+		ph := math.Min(1, float64(cnt[1])/float64(m.HamMessages))
+		if ps+ph == 0 {
+			continue
+		}
+		n := float64(cnt[0] + cnt[1])
+		f := (spamRobinsonStrength*0.5 + n*ps/(ps+ph)) / (spamRobinsonStrength + n)
+		fs = append(fs, math.Max(0.01, math.Min(0.99, f)))
+	}
+	sort.Slice(fs, func(i, j int) bool { return math.Abs(fs[i]-0.5) > math.Abs(fs[j]-0.5) })
+	if len(fs) > spamInterestingTokens {
+		fs = fs[:spamInterestingTokens]
+	}
+	logit := 0.0
+	for _, f := range fs {
+		logit += math.Log(f) - math.Log(1-f)
+	}
+	return 1 / (1 + math.Exp(-logit))
+}
+
+// combineSpamScores — score 0–100 : modèle bayésien pondéré 2/3, heuristique 1/3.
+func combineSpamScores(heuristic int, model *spamModel, tokens []string) int {
+	if !model.ready() {
+		return heuristic
+	}
+	bayes := int(math.Round(model.probability(tokens) * 100))
+	score := (2*bayes + heuristic + 1) / 3
+	if score > 100 {
+		return 100
+	}
+	return score
+}
+
+// spamScope — portée d'un modèle : personnel (app.current_user_id) ou base du tenant.
+type spamScope struct {
+	tokens, stats, key, current string
+}
+
+var (
+	spamUserScope   = spamScope{"mail_spam_user_tokens", "mail_spam_user_stats", "user_id", "current_setting('app.current_user_id', true)::INTEGER"}
+	spamTenantScope = spamScope{"mail_spam_tenant_tokens", "mail_spam_tenant_stats", "tenant_id", "current_setting('app.current_tenant', true)::INTEGER"}
+)
+
+// loadSpamModel charge les totaux puis, si le modèle est exploitable, les comptes des jetons.
+func (h *Handler) loadSpamModel(ctx context.Context, tokens []string) (*spamModel, error) {
+	m := &spamModel{Tokens: map[string][2]int{}}
+	err := h.dbex(ctx).QueryRow(fmt.Sprintf(`
+		SELECT COALESCE(SUM(spam_messages), 0), COALESCE(SUM(ham_messages), 0) FROM (
+			SELECT spam_messages, ham_messages FROM %s WHERE %s = %s
+			UNION ALL
+			SELECT spam_messages, ham_messages FROM %s WHERE %s = %s
+		) s
+	`, spamUserScope.stats, spamUserScope.key, spamUserScope.current,
+		spamTenantScope.stats, spamTenantScope.key, spamTenantScope.current)).Scan(&m.SpamMessages, &m.HamMessages)
+	if err != nil {
+		return nil, err
+	}
+	if !m.ready() || len(tokens) == 0 {
+		return m, nil
+	}
+	rows, err := h.dbex(ctx).Query(fmt.Sprintf(`
+		SELECT token, SUM(spam_count), SUM(ham_count) FROM (
+			SELECT token, spam_count, ham_count FROM %s WHERE %s = %s AND token = ANY($1)
+			UNION ALL
+			SELECT token, spam_count, ham_count FROM %s WHERE %s = %s AND token = ANY($1)
+		) t
+		GROUP BY token
+	`, spamUserScope.tokens, spamUserScope.key, spamUserScope.current,
+		spamTenantScope.tokens, spamTenantScope.key, spamTenantScope.current), pq.Array(tokens))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tok string
+		var s, hm int
+		if err := rows.Scan(&tok, &s, &hm); err != nil {
+			return nil, err
+		}
+		m.Tokens[tok] = [2]int{s, hm}
+	}
+	return m, rows.Err()
+}
+
+// applySpamScores complète le score heuristique des listes (objet + expéditeur, une requête par page).
+func (h *Handler) applySpamScores(ctx context.Context, msgs []MailMessage) {
+	if len(msgs) == 0 {
+		return
+	}
+	perMsg := make([][]string, len(msgs))
+	var all []string
+	seen := map[string]bool{}
+	for i := range msgs {
+		perMsg[i] = spamTokens(msgs[i].Subject, msgs[i].FromAddr, "")
+		for _, tok := range perMsg[i] {
+			if !seen[tok] {
+				seen[tok] = true
+				all = append(all, tok)
+			}
+		}
+	}
+	model, err := h.loadSpamModel(ctx, all)
+	if err != nil {
+		log.Printf("[mail-spam] modèle: %v", err)
+		return
+	}
+	for i := range msgs {
+		msgs[i].SpamScore = combineSpamScores(msgs[i].SpamScore, model, perMsg[i])
+	}
+}
+
+// messageSpamScore — score d'un message ouvert (corps compris).
+func (h *Handler) messageSpamScore(ctx context.Context, subject, fromAddr, bodyPlain, bodyHTML string) int {
+	heuristic := spamHeuristicScore(subject, fromAddr)
+	body := bodyPlain
+	if strings.TrimSpace(body) == "" && bodyHTML != "" {
+		body = htmlToPlainText(bodyHTML)
+	}
+	tokens := spamTokens(subject, fromAddr, body)
+	model, err := h.loadSpamModel(ctx, tokens)
+	if err != nil {
+		log.Printf("[mail-spam] modèle: %v", err)
+		return heuristic
+	}
+	return combineSpamScores(heuristic, model, tokens)
+}
+
+// addSpamCounts ajoute (deltas positifs) ou retire (négatifs) les jetons de messages d'un modèle.
+func (h *Handler) addSpamCounts(ctx context.Context, scope spamScope, counts map[string][2]int, spamMsgs, hamMsgs int) error {
+	toks := make([]string, 0, len(counts))
+	spamD := make([]int64, 0, len(counts))
+	hamD := make([]int64, 0, len(counts))
+	for tok, c := range counts {
+		toks = append(toks, tok)
+		spamD = append(spamD, int64(c[0]))
+		hamD = append(hamD, int64(c[1]))
+	}
+	unlearn := spamMsgs < 0 || hamMsgs < 0
+	switch {
+	case len(toks) == 0:
+	case unlearn:
+		// Désapprentissage : seuls les jetons déjà comptés existent ; les lignes vidées sont supprimées.
+		if _, err := h.dbex(ctx).Exec(fmt.Sprintf(`
+			UPDATE %[1]s SET
+				spam_count = GREATEST(0, %[1]s.spam_count + t.s),
+				ham_count = GREATEST(0, %[1]s.ham_count + t.h)
+			FROM unnest($1::text[], $2::int[], $3::int[]) AS t(tok, s, h)
+			WHERE %[1]s.%[2]s = %[3]s AND %[1]s.token = t.tok
+		`, scope.tokens, scope.key, scope.current), pq.Array(toks), pq.Array(spamD), pq.Array(hamD)); err != nil {
+			return err
+		}
+		_, _ = h.dbex(ctx).Exec(fmt.Sprintf(`
+			DELETE FROM %s WHERE %s = %s AND token = ANY($1) AND spam_count = 0 AND ham_count = 0
+		`, scope.tokens, scope.key, scope.current), pq.Array(toks))
+	default:
+		if _, err := h.dbex(ctx).Exec(fmt.Sprintf(`
+			INSERT INTO %[1]s (%[2]s, token, spam_count, ham_count)
+			SELECT %[3]s, t.tok, t.s, t.h
+			FROM unnest($1::text[], $2::int[], $3::int[]) AS t(tok, s, h)
+			ON CONFLICT (%[2]s, token) DO UPDATE SET
+				spam_count = %[1]s.spam_count + EXCLUDED.spam_count,
+				ham_count = %[1]s.ham_count + EXCLUDED.ham_count
+		`, scope.tokens, scope.key, scope.current), pq.Array(toks), pq.Array(spamD), pq.Array(hamD)); err != nil {
+			return err
+		}
+	}
+	_, err := h.dbex(ctx).Exec(fmt.Sprintf(`
+		INSERT INTO %[1]s (%[2]s, spam_messages, ham_messages)
+		VALUES (%[3]s, GREATEST($1::int, 0), GREATEST($2::int, 0))
+		ON CONFLICT (%[2]s) DO UPDATE SET
+			spam_messages = GREATEST(0, %[1]s.spam_messages + $1::int),
+			ham_messages = GREATEST(0, %[1]s.ham_messages + $2::int)
+	`, scope.stats, scope.key, scope.current), spamMsgs, hamMsgs)
+	return err
+}
+
+func spamTokenCounts(tokens []string, isSpam bool, sign int) map[string][2]int {
+	counts := make(map[string][2]int, len(tokens))
+	for _, tok := range tokens {
+		if isSpam {
+			counts[tok] = [2]int{sign, 0}
+		} else {
+			counts[tok] = [2]int{0, sign}
+		}
+	}
+	return counts
+}
+
+func spamMessageDeltas(isSpam bool, sign int) (int, int) {
+	if isSpam {
+		return sign, 0
+	}
+	return 0, sign
+}
+
+// spamTrainingLabel — apprentissage déduit d'un déplacement : vers Spam = spam ; hors de Spam
+// (sauf corbeille, qui ne dit rien de la légitimité) = légitime.
+func spamTrainingLabel(fromFolder, toFolder string) (isSpam, ok bool) {
+	from := strings.ToLower(strings.TrimSpace(fromFolder))
+	to := strings.ToLower(strings.TrimSpace(toFolder))
+	switch {
+	case to == "spam" && from != "spam":
+		return true, true
+	case from == "spam" && to != "spam" && to != "trash":
+		return false, true
+	}
+	return false, false
+}
+
+// trainSpamMessage apprend un message dans le modèle personnel ; un message déjà appris avec
+// l'autre libellé est d'abord désappris (correction de l'utilisateur).
+func (h *Handler) trainSpamMessage(ctx context.Context, accountID, msgID int, isSpam bool) error {
+	var subject, fromAddr, bodyPlain, bodyHTML string
+	err := h.dbex(ctx).QueryRow(`
+		SELECT COALESCE(subject, ''), COALESCE(from_addr, ''), COALESCE(body_plain, ''), COALESCE(body_html, '')
+		FROM mail_messages
+		WHERE id = $1 AND account_id = $2
+		AND account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
+	`, msgID, accountID).Scan(&subject, &fromAddr, &bodyPlain, &bodyHTML)
+	if err != nil {
+		return err
+	}
+	var prevSpam bool
+	var prevTokens []string
+	err = h.dbex(ctx).QueryRow(`SELECT is_spam, tokens FROM mail_spam_training WHERE message_id = $1`, msgID).
+		Scan(&prevSpam, pq.Array(&prevTokens))
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case prevSpam == isSpam:
+		return nil
+	default:
+		s, hm := spamMessageDeltas(prevSpam, -1)
+		if err := h.addSpamCounts(ctx, spamUserScope, spamTokenCounts(prevTokens, prevSpam, -1), s, hm); err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(bodyPlain) == "" && bodyHTML != "" {
+		bodyPlain = htmlToPlainText(bodyHTML)
+	}
+	tokens := spamTokens(subject, fromAddr, bodyPlain)
+	s, hm := spamMessageDeltas(isSpam, 1)
+	if err := h.addSpamCounts(ctx, spamUserScope, spamTokenCounts(tokens, isSpam, 1), s, hm); err != nil {
+		return err
+	}
+	_, err = h.dbex(ctx).Exec(`
+		INSERT INTO mail_spam_training (message_id, user_id, is_spam, tokens)
+		VALUES ($1, current_setting('app.current_user_id', true)::INTEGER, $2, $3)
+		ON CONFLICT (message_id) DO UPDATE SET is_spam = EXCLUDED.is_spam, tokens = EXCLUDED.tokens, trained_at = CURRENT_TIMESTAMP
+	`, msgID, isSpam, pq.Array(tokens))
+	return err
+}
+
+// trainSpamFromMove — appelé après un déplacement réussi ; un échec n'annule jamais le déplacement.
+func (h *Handler) trainSpamFromMove(ctx context.Context, accountID, msgID int, fromFolder, toFolder string) {
+	isSpam, ok := spamTrainingLabel(fromFolder, toFolder)
+	if !ok {
+		return
+	}
+	if err := h.trainSpamMessage(ctx, accountID, msgID, isSpam); err != nil {
+		log.Printf("[mail-spam] apprentissage account=%d msg=%d: %v", accountID, msgID, err)
+	}
+}
+
+// GET /mail/admin/spam/model — état du modèle de base du tenant.
+func (h *Handler) getSpamBaseModel(c *gin.Context) {
+	var spamMsgs, hamMsgs, tokens int
+	var updatedAt sql.NullString
+	err := h.dbex(c.Request.Context()).QueryRow(`
+		SELECT COALESCE(s.spam_messages, 0), COALESCE(s.ham_messages, 0), s.updated_at::text,
+			(SELECT COUNT(*) FROM mail_spam_tenant_tokens WHERE tenant_id = current_setting('app.current_tenant', true)::INTEGER)
+		FROM (SELECT 1) one
+		LEFT JOIN mail_spam_tenant_stats s ON s.tenant_id = current_setting('app.current_tenant', true)::INTEGER
+	`).Scan(&spamMsgs, &hamMsgs, &updatedAt, &tokens)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	out := gin.H{"spam_messages": spamMsgs, "ham_messages": hamMsgs, "tokens": tokens}
+	if updatedAt.Valid {
+		out["updated_at"] = normalizeTimestampString(updatedAt.String)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// POST /mail/admin/spam/model/train — ajoute un lot d'exemples étiquetés au modèle de base.
+func (h *Handler) trainSpamBaseModel(c *gin.Context) {
+	var body struct {
+		IsSpam   *bool `json:"is_spam"`
+		Messages []struct {
+			Subject string `json:"subject"`
+			From    string `json:"from"`
+			Body    string `json:"body"`
+		} `json:"messages"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.IsSpam == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must contain is_spam and messages[]"})
+		return
+	}
+	if len(body.Messages) == 0 || len(body.Messages) > spamBaseTrainMax {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("messages: 1 à %d exemples", spamBaseTrainMax)})
+		return
+	}
+	counts := map[string][2]int{}
+	trained := 0
+	for _, m := range body.Messages {
+		tokens := spamTokens(m.Subject, m.From, m.Body)
+		if len(tokens) == 0 {
+			continue
+		}
+		trained++
+		for tok, d := range spamTokenCounts(tokens, *body.IsSpam, 1) {
+			cur := counts[tok]
+			counts[tok] = [2]int{cur[0] + d[0], cur[1] + d[1]}
+		}
+	}
+	if trained == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "aucun jeton exploitable dans les exemples"})
+		return
+	}
+	s, hm := spamMessageDeltas(*body.IsSpam, trained)
+	if err := h.addSpamCounts(c.Request.Context(), spamTenantScope, counts, s, hm); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "trained": trained})
+}
+
+// DELETE /mail/admin/spam/model — réinitialise le modèle de base (les modèles personnels restent).
+func (h *Handler) resetSpamBaseModel(c *gin.Context) {
+	ctx := c.Request.Context()
+	for _, q := range []string{
+		`DELETE FROM mail_spam_tenant_tokens WHERE tenant_id = current_setting('app.current_tenant', true)::INTEGER`,
+		`DELETE FROM mail_spam_tenant_stats WHERE tenant_id = current_setting('app.current_tenant', true)::INTEGER`,
+	} {
+		if _, err := h.dbex(ctx).Exec(q); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
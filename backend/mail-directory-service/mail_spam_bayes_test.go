@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSpamTokens(t *testing.T) {
+	got := spamTokens("Votre colis 12345 en attente", "Poste <Info@Colis-Express.xyz>", "Cliquez ici : https://colis-express.xyz/suivi — colis")
+	want := []string{
+		"f:info@colis-express.xyz", "d:colis-express.xyz",
+		"s:votre", "s:colis", "s:attente",
+		"cliquez", "ici", "https", "colis", "express", "xyz", "suivi",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	long := strings.Repeat("mot", 11)
+	if toks := spamTokens("", "", long+" ok 2026 é"); len(toks) != 0 {
+		t.Errorf("jetons trop longs / courts / numériques gardés: %v", toks)
+	}
+}
+
+func TestSpamModelProbability(t *testing.T) {
+	m := &spamModel{SpamMessages: 10, HamMessages: 10, Tokens: map[string][2]int{
+		"s:gagnez":   {9, 0},
+		"d:promo.tk": {8, 0},
+		"s:réunion":  {0, 9},
+		"sécurité":   {2, 6},
+	}}
+	if p := m.probability([]string{"s:gagnez", "d:promo.tk"}); p < 0.95 {
+		t.Errorf("spam attendu, p=%f", p)
+	}
+	if p := m.probability([]string{"s:réunion", "sécurité"}); p > 0.05 {
+		t.Errorf("légitime attendu, p=%f", p)
+	}
+	if p := m.probability([]string{"inconnu"}); p != 0.5 {
+		t.Errorf("sans jeton connu: p=%f", p)
+	}
+}
+
+func TestCombineSpamScores(t *testing.T) {
+	cold := &spamModel{SpamMessages: 2, HamMessages: 50}
+	if got := combineSpamScores(24, cold, []string{"x"}); got != 24 {
+		t.Errorf("modèle non prêt: got %d, want heuristique 24", got)
+	}
+	if got := combineSpamScores(24, nil, nil); got != 24 {
+		t.Errorf("modèle absent: got %d", got)
+	}
+	ham := &spamModel{SpamMessages: 20, HamMessages: 20, Tokens: map[string][2]int{"s:sécurité": {0, 15}}}
+	if got := combineSpamScores(12, ham, []string{"s:sécurité"}); got >= 12 {
+		t.Errorf("le modèle doit faire baisser un faux positif heuristique: got %d", got)
+	}
+	spam := &spamModel{SpamMessages: 20, HamMessages: 20, Tokens: map[string][2]int{"f:x@promo.tk": {18, 0}}}
+	if got := combineSpamScores(0, spam, []string{"f:x@promo.tk"}); got < 60 {
+		t.Errorf("spam appris ignoré: got %d", got)
+	}
+}
+
+func TestSpamTrainingLabel(t *testing.T) {
+	cases := []struct {
+		from, to   string
+		isSpam, ok bool
+	}{
+		{"inbox", "spam", true, true},
+		{"Travail/Clients", "spam", true, true},
+		{"spam", "inbox", false, true},
+		{"spam", "archive", false, true},
+		{"spam", "trash", false, false},
+		{"inbox", "archive", false, false},
+		{"spam", "spam", false, false},
+	}
+	for _, tc := range cases {
+		isSpam, ok := spamTrainingLabel(tc.from, tc.to)
+		if isSpam != tc.isSpam || ok != tc.ok {
+			t.Errorf("%s -> %s: got (%v, %v)", tc.from, tc.to, isSpam, ok)
+		}
+	}
+}
+
+func TestSpamHeuristicNoLongerFlagsSecurite(t *testing.T) {
+	if got := spamHeuristicScore("Point sécurité du chantier", "chef@entreprise.fr"); got != 0 {
+		t.Errorf("got %d", got)
+	}
+}
+
+func TestSpamBaseModelRoutes(t *testing.T) {
+	r := setupRouter(nil)
+	req := httptest.NewRequest(http.MethodGet, "/mail/admin/spam/model", nil)
+	req.Header.Set("X-Tenant-ID", "1")
+	req.Header.Set("X-User-ID", "1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("sans rôle admin: got %d, want 403", w.Code)
+	}
+	for _, body := range []string{
+		`{"messages":[{"subject":"x"}]}`,
+		`{"is_spam":true,"messages":[]}`,
+		`{"is_spam":true,"messages":[{"subject":"ok"}]}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/mail/admin/spam/model/train", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		setAdminMailHeaders(req)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("train %s: got %d %s", body, w.Code, w.Body.String())
+		}
+	}
+}
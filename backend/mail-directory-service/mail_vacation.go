@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+	"github.com/gin-gonic/gin"
+)
+
+// Répondeur d'absence par boîte (MAIL-VACATION-01). Deux déclencheurs partagent la même
+// décision RFC 3834 et le même registre par expéditeur (mail_vacation_replies) :
+//   - après chaque sync IMAP, les nouveaux messages de la réception (curseur last_message_id) ;
+//   - à la livraison d'un alias par alias-router (POST /mail/internal/vacation/notify).
+// Une réponse livrée puis synchronisée n'est donc envoyée qu'une fois par intervalle.
+
+const (
+	vacationDefaultIntervalDays = 7
+	vacationMaxIntervalDays     = 30
+	vacationSyncBatch           = 200
+	vacationHeaderFetchLimit    = 30
+	vacationMaxSubjectLen       = 500
+	vacationMaxBodyLen          = 20000
+)
+
+type mailVacationSettings struct {
+	Enabled           bool   `json:"enabled"`
+	StartAt           string `json:"start_at,omitempty"`
+	EndAt             string `json:"end_at,omitempty"`
+	Subject           string `json:"subject"`
+	Body              string `json:"body"`
+	ReplyIntervalDays int    `json:"reply_interval_days"`
+	UpdatedAt         string `json:"updated_at,omitempty"`
+
+	start, end    time.Time
+	lastMessageID int
+}
+
+// activeAt : répondeur activé et t dans [start_at, end_at[ (bornes absentes = ouvertes).
+func (s *mailVacationSettings) activeAt(t time.Time) bool {
+	if !s.Enabled {
+		return false
+	}
+	if !s.start.IsZero() && t.Before(s.start) {
+		return false
+	}
+	return s.end.IsZero() || t.Before(s.end)
+}
+
+// vacationCandidate — message reçu pouvant déclencher une réponse.
+type vacationCandidate struct {
+	Headers map[string][]string
+	// Envelope : livraison MTA, ReturnPath est alors l'expéditeur d'enveloppe (vide = <>).
+	Envelope   bool
+	ReturnPath string
+	Date       time.Time
+}
+
+// vacationReplyTarget applique RFC 3834 : renvoie le destinataire de la réponse, l'adresse
+// locale à laquelle le message était adressé (expéditeur de la réponse), ou la raison du refus.
+func vacationReplyTarget(s *mailVacationSettings, c *vacationCandidate, own map[string]bool, now time.Time) (to, ownAddr, reason string) {
+	if !s.activeAt(now) {
+		return "", "", "inactive"
+	}
+	if !c.Date.IsZero() && !s.activeAt(c.Date) {
+		return "", "", "outside-period"
+	}
+	first := func(name string) string {
+		if v := c.Headers[name]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+	if reason := autoReplyBlockedReason(c.Headers, first("from"), own); reason != "" {
+		return "", "", reason
+	}
+	// §3.1.1 : la réponse part vers l'expéditeur d'enveloppe (Return-Path), jamais vers <>.
+	target := first("from")
+	if c.Envelope {
+		target = c.ReturnPath
+	} else if rp, ok := c.Headers["return-path"]; ok && len(rp) > 0 {
+		target = rp[0]
+	}
+	if t := strings.TrimSpace(target); t == "" || t == "<>" {
+		return "", "", "null-sender"
+	}
+	if reason := autoReplyBlockedReason(nil, target, own); reason != "" {
+		return "", "", reason
+	}
+	// §3.1.1 : ne répondre que si l'une de nos adresses figure explicitement en To / Cc.
+	for _, name := range []string{"to", "cc"} {
+		for _, v := range c.Headers[name] {
+			list, err := mail.ParseAddressList(v)
+			if err != nil {
+				continue
+			}
+			for _, a := range list {
+				if addr := strings.ToLower(a.Address); own[addr] {
+					return senderAddress(target), addr, ""
+				}
+			}
+		}
+	}
+	return "", "", "not-addressed"
+}
+
+// vacationSubject — objet configuré, sinon « Auto: » + objet d'origine (RFC 3834 §3.1.5).
+func vacationSubject(s *mailVacationSettings, headers map[string][]string) string {
+	if subj := strings.TrimSpace(s.Subject); subj != "" {
+		return subj
+	}
+	orig := ""
+	if v := headers["subject"]; len(v) > 0 {
+		orig = v[0]
+	}
+	return prefixSubject("Auto:", orig)
+}
+
+func (h *Handler) loadVacationSettings(ctx context.Context, accountID int) (*mailVacationSettings, error) {
+	s := &mailVacationSettings{}
+	var start, end sql.NullString
+	err := h.dbex(ctx).QueryRow(`
+		SELECT enabled, start_at::text, end_at::text, subject, body, reply_interval_days, last_message_id, COALESCE(updated_at::text, '')
+		FROM mail_vacation_settings
+		WHERE account_id = $1
+		  AND account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
+	`, accountID).Scan(&s.Enabled, &start, &end, &s.Subject, &s.Body, &s.ReplyIntervalDays, &s.lastMessageID, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if start.Valid {
+		s.start, _ = parseFlexibleTimestamp(start.String)
+		s.StartAt = normalizeTimestampString(start.String)
+	}
+	if end.Valid {
+		s.end, _ = parseFlexibleTimestamp(end.String)
+		s.EndAt = normalizeTimestampString(end.String)
+	}
+	s.UpdatedAt = normalizeTimestampString(s.UpdatedAt)
+	return s, nil
+}
+
+// sendVacationReply réserve l'expéditeur pour l'intervalle puis envoie par le SMTP du compte ;
+// la réservation est levée si l'envoi échoue.
+func (h *Handler) sendVacationReply(ctx context.Context, accountID int, s *mailVacationSettings, to, from string, headers map[string][]string) (bool, error) {
+	var claimed string
+	err := h.dbex(ctx).QueryRow(`
+		INSERT INTO mail_vacation_replies (account_id, sender, replied_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT (account_id, sender) DO UPDATE SET replied_at = CURRENT_TIMESTAMP
+		WHERE mail_vacation_replies.replied_at < CURRENT_TIMESTAMP - make_interval(days => $3)
+		RETURNING sender
+	`, accountID, to, s.ReplyIntervalDays).Scan(&claimed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return false, err
+	}
+	first := func(name string) string {
+		if v := headers[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	out := &outgoingMessage{To: []*mail.Address{rcpt}, Subject: vacationSubject(s, headers), Text: s.Body, AutoSubmitted: "auto-replied"}
+	if mid := normalizeMessageID(first("message-id")); mid != "" {
+		out.InReplyTo, out.References = threadHeaders(mid, first("references"), normalizeMessageID(first("in-reply-to")))
+	}
+	raw, err := h.sendOutgoingMail(ctx, accountID, "", "", 0, from, out)
+	if err != nil {
+		_, _ = h.dbex(ctx).Exec(`DELETE FROM mail_vacation_replies WHERE account_id = $1 AND sender = $2`, accountID, to)
+		return false, err
+	}
+	h.recordSentMessage(ctx, accountID, 0, out, raw)
+	return true, nil
+}
+
+// runVacationResponder examine les messages arrivés en réception depuis le dernier passage.
+func (h *Handler) runVacationResponder(ctx context.Context, accountID int) (int, error) {
+	s, err := h.loadVacationSettings(ctx, accountID)
+	if err != nil || s == nil {
+		return 0, err
+	}
+	type pending struct {
+		id         int
+		uid        int64
+		folder     string
+		rawHeaders string
+		date       time.Time
+	}
+	rows, err := h.dbex(ctx).Query(`
+		SELECT id, COALESCE(message_uid, 0), COALESCE(folder, ''), COALESCE(raw_headers, ''), COALESCE(date_at, created_at)::text
+		FROM mail_messages
+		WHERE account_id = $1 AND id > $2
+		ORDER BY id ASC
+		LIMIT $3
+	`, accountID, s.lastMessageID, vacationSyncBatch)
+	if err != nil {
+		return 0, err
+	}
+	var msgs []pending
+	maxID := s.lastMessageID
+	for rows.Next() {
+		var p pending
+		var date sql.NullString
+		if err := rows.Scan(&p.id, &p.uid, &p.folder, &p.rawHeaders, &date); err != nil {
+			continue
+		}
+		p.date, _ = parseFlexibleTimestamp(date.String)
+		if p.id > maxID {
+			maxID = p.id
+		}
+		msgs = append(msgs, p)
+	}
+	rows.Close()
+	if maxID == s.lastMessageID {
+		return 0, nil
+	}
+	// Curseur avancé avant tout envoi : deux passes concurrentes ne traitent pas les mêmes messages.
+	res, err := h.dbex(ctx).Exec(`
+		UPDATE mail_vacation_settings SET last_message_id = $3
+		WHERE account_id = $1 AND last_message_id = $2
+	`, accountID, s.lastMessageID, maxID)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, nil
+	}
+	now := time.Now()
+	if !s.activeAt(now) {
+		return 0, nil
+	}
+	own := h.accountOwnAddresses(ctx, accountID)
+	var ic *client.Client
+	imapDown := false
+	fetchBudget := vacationHeaderFetchLimit
+	defer func() {
+		if ic != nil {
+			_ = ic.Logout()
+		}
+	}()
+	sent := 0
+	for _, p := range msgs {
+		if p.folder != "inbox" {
+			continue
+		}
+		raw := p.rawHeaders
+		if strings.TrimSpace(raw) == "" && p.uid > 0 && p.uid <= maxIMAPUID && fetchBudget > 0 && !imapDown {
+			fetchBudget--
+			if ic == nil {
+				if _, ic, err = h.imapDialAndLogin(ctx, accountID, ""); err != nil {
+					imapDown, ic = true, nil
+					log.Printf("[mail-vacation] IMAP indisponible account=%d: %v", accountID, err)
+				}
+			}
+			if ic != nil {
+				if b, err := imapFetchRawByUID(ctx, h, accountID, ic, p.folder, uint32(p.uid), "BODY.PEEK[HEADER]"); err == nil {
+					raw = extractRawMIMEHeaders(b)
+					_, _ = h.dbex(ctx).Exec(`
+						UPDATE mail_messages SET raw_headers = $1
+						WHERE id = $2 AND account_id = $3 AND COALESCE(raw_headers, '') = ''
+					`, raw, p.id, accountID)
+				}
+			}
+		}
+		if strings.TrimSpace(raw) == "" {
+			// sans en-têtes, impossible d'écarter listes et messages automatiques : pas de réponse
+			continue
+		}
+		cand := &vacationCandidate{Headers: parseSieveHeaderBlock(raw), Date: p.date}
+		to, from, reason := vacationReplyTarget(s, cand, own, now)
+		if reason != "" {
+			continue
+		}
+		ok, err := h.sendVacationReply(ctx, accountID, s, to, from, cand.Headers)
+		if err != nil {
+			log.Printf("[mail-vacation] réponse account=%d msg=%d: %v", accountID, p.id, err)
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+func (h *Handler) getMailVacation(c *gin.Context) {
+	accountID, ok := parsePositiveParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return
+	}
+	s, err := h.loadVacationSettings(c.Request.Context(), accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if s == nil {
+		s = &mailVacationSettings{ReplyIntervalDays: vacationDefaultIntervalDays}
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// parseVacationInput valide le corps de PUT /vacation (dates RFC 3339 ou AAAA-MM-JJ).
+func parseVacationInput(in *mailVacationSettings) error {
+	in.Subject = strings.TrimSpace(in.Subject)
+	in.Body = strings.TrimSpace(in.Body)
+	if len(in.Subject) > vacationMaxSubjectLen || len(in.Body) > vacationMaxBodyLen {
+		return fmt.Errorf("objet ou texte trop long")
+	}
+	if in.Enabled && in.Body == "" {
+		return fmt.Errorf("texte de réponse requis")
+	}
+	if in.ReplyIntervalDays == 0 {
+		in.ReplyIntervalDays = vacationDefaultIntervalDays
+	}
+	if in.ReplyIntervalDays < 1 || in.ReplyIntervalDays > vacationMaxIntervalDays {
+		return fmt.Errorf("reply_interval_days entre 1 et %d", vacationMaxIntervalDays)
+	}
+	for _, f := range []struct {
+		raw string
+		out *time.Time
+	}{{in.StartAt, &in.start}, {in.EndAt, &in.end}} {
+		if strings.TrimSpace(f.raw) == "" {
+			continue
+		}
+		t, ok := parseRuleDate(f.raw)
+		if !ok {
+			return fmt.Errorf("date invalide %q (AAAA-MM-JJ ou RFC 3339)", f.raw)
+		}
+		*f.out = t
+	}
+	if !in.start.IsZero() && !in.end.IsZero() && !in.end.After(in.start) {
+		return fmt.Errorf("end_at doit suivre start_at")
+	}
+	return nil
+}
+
+func (h *Handler) putMailVacation(c *gin.Context) {
+	accountID, ok := parsePositiveParam(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return
+	}
+	var body mailVacationSettings
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body JSON invalide"})
+		return
+	}
+	if err := parseVacationInput(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	nullTime := func(t time.Time) interface{} {
+		if t.IsZero() {
+			return nil
+		}
+		return t
+	}
+	// Curseur repositionné sur le dernier message connu : seul le courrier à venir reçoit une réponse.
+	res, err := h.dbex(c.Request.Context()).Exec(`
+		INSERT INTO mail_vacation_settings (account_id, enabled, start_at, end_at, subject, body, reply_interval_days, last_message_id)
+		SELECT $1, $2, $3, $4, $5, $6, $7, COALESCE((SELECT MAX(id) FROM mail_messages WHERE account_id = $1), 0)
+		WHERE EXISTS (
+			SELECT 1 FROM user_email_accounts
+			WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER
+		)
+		ON CONFLICT (account_id) DO UPDATE SET
+			enabled = EXCLUDED.enabled, start_at = EXCLUDED.start_at, end_at = EXCLUDED.end_at,
+			subject = EXCLUDED.subject, body = EXCLUDED.body,
+			reply_interval_days = EXCLUDED.reply_interval_days, last_message_id = EXCLUDED.last_message_id
+	`, accountID, body.Enabled, nullTime(body.start), nullTime(body.end), body.Subject, body.Body, body.ReplyIntervalDays)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// POST /mail/internal/vacation/notify — livraison d'un alias par alias-router (hors JWT).
+func (h *Handler) internalVacationNotify(c *gin.Context) {
+	if !mtaInternalTokenOK(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing MTA internal token"})
+		return
+	}
+	var body struct {
+		AccountID    int    `json:"account_id"`
+		Recipient    string `json:"recipient"`
+		EnvelopeFrom string `json:"envelope_from"`
+		Headers      string `json:"headers"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.AccountID <= 0 || !strings.Contains(body.Recipient, "@") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	ctx := c.Request.Context()
+	var userID int
+	if err := h.db.QueryRowContext(ctx, `SELECT user_id FROM user_email_accounts WHERE id = $1`, body.AccountID).Scan(&userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+	// Conn dédiée portant le contexte utilisateur (RLS), comme le worker d'envoi programmé.
+	conn, err := h.db.Conn(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer conn.Close()
+	ctx = withPinnedConn(ctx, &pinnedConn{conn: conn, ctx: ctx})
+	if _, err := h.dbex(ctx).Exec("SELECT set_config('app.current_user_id', $1, false)", strconv.Itoa(userID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s, err := h.loadVacationSettings(ctx, body.AccountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if s == nil {
+		c.JSON(http.StatusOK, gin.H{"ok": true, "replied": false, "reason": "inactive"})
+		return
+	}
+	own := h.accountOwnAddresses(ctx, body.AccountID)
+	if !own[strings.ToLower(strings.TrimSpace(body.Recipient))] {
+		c.JSON(http.StatusOK, gin.H{"ok": true, "replied": false, "reason": "unknown-recipient"})
+		return
+	}
+	cand := &vacationCandidate{Headers: parseSieveHeaderBlock(body.Headers), Envelope: true, ReturnPath: body.EnvelopeFrom}
+	if v := cand.Headers["date"]; len(v) > 0 {
+		cand.Date, _ = mail.ParseDate(v[0])
+	}
+	to, from, reason := vacationReplyTarget(s, cand, own, time.Now())
+	if reason != "" {
+		c.JSON(http.StatusOK, gin.H{"ok": true, "replied": false, "reason": reason})
+		return
+	}
+	replied, err := h.sendVacationReply(ctx, body.AccountID, s, to, from, cand.Headers)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if !replied {
+		reason = "interval"
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "replied": replied, "reason": reason})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMailVacationActiveAt(t *testing.T) {
+	start := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 7, 15, 0, 0, 0, 0, time.UTC)
+	s := &mailVacationSettings{Enabled: true, start: start, end: end}
+	if !s.activeAt(start) || !s.activeAt(start.Add(48*time.Hour)) {
+		t.Error("attendu actif dans la période")
+	}
+	if s.activeAt(start.Add(-time.Second)) || s.activeAt(end) {
+		t.Error("attendu inactif hors période (fin exclue)")
+	}
+	s.Enabled = false
+	if s.activeAt(start.Add(time.Hour)) {
+		t.Error("désactivé : jamais actif")
+	}
+	open := &mailVacationSettings{Enabled: true}
+	if !open.activeAt(time.Now()) {
+		t.Error("sans bornes : toujours actif")
+	}
+}
+
+func TestVacationReplyTarget(t *testing.T) {
+	now := time.Date(2026, 7, 5, 12, 0, 0, 0, time.UTC)
+	s := &mailVacationSettings{Enabled: true, start: now.Add(-24 * time.Hour), end: now.Add(24 * time.Hour)}
+	own := map[string]bool{"moi@cloudity.fr": true, "alias@cloudity.fr": true}
+	base := func() map[string][]string {
+		return map[string][]string{
+			"from": {"Alice <alice@example.com>"},
+			"to":   {"Alias <Alias@cloudity.fr>"},
+		}
+	}
+	cases := []struct {
+		name   string
+		edit   func(c *vacationCandidate)
+		to     string
+		reason string
+	}{
+		{"ok", func(c *vacationCandidate) {}, "alice@example.com", ""},
+		{"cc", func(c *vacationCandidate) {
+			c.Headers["to"] = []string{"autre@example.com"}
+			c.Headers["cc"] = []string{"moi@cloudity.fr"}
+		}, "alice@example.com", ""},
+		{"return-path", func(c *vacationCandidate) { c.Headers["return-path"] = []string{"<bounce-alice@example.com>"} }, "", "system-sender"},
+		{"return-path tiers", func(c *vacationCandidate) { c.Headers["return-path"] = []string{"<alice+srs@example.com>"} }, "alice+srs@example.com", ""},
+		{"enveloppe", func(c *vacationCandidate) { c.Envelope, c.ReturnPath = true, "env@example.com" }, "env@example.com", ""},
+		{"enveloppe nulle", func(c *vacationCandidate) { c.Envelope = true }, "", "null-sender"},
+		{"return-path nul", func(c *vacationCandidate) { c.Headers["return-path"] = []string{"<>"} }, "", "null-sender"},
+		{"auto-submitted", func(c *vacationCandidate) { c.Headers["auto-submitted"] = []string{"auto-replied"} }, "", "auto-submitted"},
+		{"liste", func(c *vacationCandidate) { c.Headers["list-id"] = []string{"<dev.example.com>"} }, "", "list-id"},
+		{"bulk", func(c *vacationCandidate) { c.Headers["precedence"] = []string{"bulk"} }, "", "precedence"},
+		{"propre alias", func(c *vacationCandidate) { c.Headers["from"] = []string{"alias@cloudity.fr"} }, "", "own-address"},
+		{"non adressé", func(c *vacationCandidate) { c.Headers["to"] = []string{"liste@example.com"} }, "", "not-addressed"},
+		{"hors période", func(c *vacationCandidate) { c.Date = now.Add(-72 * time.Hour) }, "", "outside-period"},
+	}
+	for _, tc := range cases {
+		c := &vacationCandidate{Headers: base()}
+		tc.edit(c)
+		to, from, reason := vacationReplyTarget(s, c, own, now)
+		if to != tc.to || reason != tc.reason {
+			t.Errorf("%s: got (%q, %q), want (%q, %q)", tc.name, to, reason, tc.to, tc.reason)
+		}
+		if reason == "" && !own[from] {
+			t.Errorf("%s: adresse d'envoi %q hors comptes", tc.name, from)
+		}
+	}
+	if _, _, reason := vacationReplyTarget(s, &vacationCandidate{Headers: base()}, own, now.Add(48*time.Hour)); reason != "inactive" {
+		t.Errorf("après la période: got %q", reason)
+	}
+}
+
+func TestVacationSubject(t *testing.T) {
+	h := map[string][]string{"subject": {"Réunion"}}
+	if got := vacationSubject(&mailVacationSettings{}, h); got != "Auto: Réunion" {
+		t.Errorf("got %q", got)
+	}
+	if got := vacationSubject(&mailVacationSettings{Subject: " Absent "}, h); got != "Absent" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestParseVacationInput(t *testing.T) {
+	in := &mailVacationSettings{Enabled: true, Body: "Absent", StartAt: "2026-07-01", EndAt: "2026-07-15T00:00:00Z"}
+	if err := parseVacationInput(in); err != nil {
+		t.Fatal(err)
+	}
+	if in.ReplyIntervalDays != vacationDefaultIntervalDays || in.start.IsZero() || in.end.IsZero() {
+		t.Errorf("got %+v", in)
+	}
+	bad := []*mailVacationSettings{
+		{Enabled: true},
+		{Body: "x", ReplyIntervalDays: 31},
+		{Body: "x", StartAt: "demain"},
+		{Body: "x", StartAt: "2026-07-15", EndAt: "2026-07-01"},
+		{Body: "x", Subject: strings.Repeat("a", vacationMaxSubjectLen+1)},
+	}
+	for i, b := range bad {
+		if err := parseVacationInput(b); err == nil {
+			t.Errorf("cas %d: erreur attendue", i)
+		}
+	}
+}
+
+func TestMailVacationRoutesValidation(t *testing.T) {
+	r := setupRouter(nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/mail/me/accounts/1/vacation", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET sans X-User-ID: got %d", w.Code)
+	}
+	for _, body := range []string{`{"enabled":true}`, `{"body":"x","reply_interval_days":0.5}`, `{"body":"x","end_at":"hier"}`} {
+		req := httptest.NewRequest(http.MethodPut, "/mail/me/accounts/1/vacation", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		setAdminMailHeaders(req)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: got %d %s", body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestInternalVacationNotifyValidation(t *testing.T) {
+	t.Setenv("MTA_INTERNAL_TOKEN", "test-mta-secret-token-32chars")
+	r := setupRouter(nil)
+	req := httptest.NewRequest(http.MethodPost, "/mail/internal/vacation/notify", strings.NewReader(`{"account_id":1,"recipient":"a@x.fr"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("sans jeton: got %d, want 401", w.Code)
+	}
+	for _, body := range []string{`{"recipient":"a@x.fr"}`, `{"account_id":1,"recipient":"ax.fr"}`, `nope`} {
+		req := httptest.NewRequest(http.MethodPost, "/mail/internal/vacation/notify", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-MTA-Internal-Token", "test-mta-secret-token-32chars")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", body, w.Code)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -92,7 +93,7 @@ func spamHeuristicScore(subject, fromAddr string) int {
 		"urgent:", "invoice attached", "wire transfer", "verify your account",
 		"account suspended", "free gift", "100% free", "no obligation",
 		// FR + phishing courant
-		"gagnez", "gagner", "remboursement", "remboursez", "sécurité", "securite",
+		"gagnez", "gagner", "remboursement", "remboursez", "alerte de sécurité", "alerte de securite",
 		"validez votre", "confirmez votre compte", "mise à jour obligatoire", "mise a jour obligatoire",
 		"compte bloqué", "compte bloque", "paiement refusé", "paiement refuse",
 		"facture impayée", "facture impayee", "colis en attente", "livraison échouée", "livraison echouee",
@@ -322,6 +323,9 @@ func main() {
 	// Callback OAuth Google : pas d'auth (redirection navigateur depuis Google)
 	r.GET("/mail/me/oauth/google/callback", h.oauthGoogleCallback)
 	r.POST("/mail/internal/alias-resolve", h.internalAliasResolve)
+	r.POST("/mail/internal/sieve/evaluate", h.internalSieveEvaluate)
+	r.POST("/mail/internal/vacation/notify", h.internalVacationNotify)
+	r.POST("/mail/internal/dkim/sign", h.internalDKIMSign)
 	r.Use(h.requireTenantAndUser)
 	r.Use(h.requireAdminRoleForMailDirectory)
 
@@ -346,6 +350,11 @@ func main() {
 		mail.PATCH("/me/accounts/:id/rules/:ruleId", h.patchMailFilterRule)
 		mail.DELETE("/me/accounts/:id/rules/:ruleId", h.deleteMailFilterRule)
 		mail.POST("/me/accounts/:id/rules/apply", h.applyMailFilterRulesNow)
+		mail.POST("/me/accounts/:id/rules/dry-run", h.dryRunMailFilterRule)
+		mail.GET("/me/accounts/:id/rules/sieve", h.exportMailRulesSieve)
+		mail.POST("/me/accounts/:id/rules/sieve", h.importMailRulesSieve)
+		mail.GET("/me/accounts/:id/vacation", h.getMailVacation)
+		mail.PUT("/me/accounts/:id/vacation", h.putMailVacation)
 		mail.GET("/me/accounts/:id/folders/summary", h.accountFolderSummary)
 		mail.GET("/me/accounts/:id/imap-folders", h.listImapFoldersHTTP)
 		mail.POST("/me/accounts/:id/imap-folders/rename", h.renameImapFolderHTTP)
@@ -355,10 +364,17 @@ func main() {
 		mail.POST("/me/accounts/:id/tags", h.createMailTagHTTP)
 		mail.PUT("/me/accounts/:id/messages/:msgId/tags", h.putMessageTagsHTTP)
 		mail.GET("/me/messages/unified", h.listUnifiedUserMessages)
+		mail.GET("/me/conversations/unified", h.listUnifiedConversations)
+		mail.GET("/me/accounts/:id/conversations", h.listAccountConversations)
+		mail.GET("/me/accounts/:id/conversations/messages", h.listConversationMessages)
+		mail.PATCH("/me/accounts/:id/conversations/read", h.markConversationsRead)
+		mail.PATCH("/me/accounts/:id/conversations/folder", h.moveConversationsToFolder)
+		mail.PATCH("/me/accounts/:id/conversations/tags", h.tagConversations)
 		mail.GET("/me/accounts/:id/messages", h.listAccountMessages)
 		mail.GET("/me/accounts/:id/messages/:msgId/attachments/:attId", h.downloadMailAttachmentHTTP)
 		mail.GET("/me/accounts/:id/messages/:msgId", h.getAccountMessage)
 		mail.PATCH("/me/accounts/:id/messages/:msgId/read", h.markMessageRead)
+		mail.PATCH("/me/accounts/:id/messages/:msgId/star", h.markMessageStarred)
 		mail.PATCH("/me/accounts/:id/messages/:msgId/folder", h.moveMessageToFolder)
 		mail.PATCH("/me/accounts/:id/messages/read", h.markMessagesReadBulk)
 		mail.PATCH("/me/accounts/:id/messages/folder", h.moveMessagesToFolderBulk)
@@ -368,10 +384,17 @@ func main() {
 		mail.POST("/me/accounts/:id/messages/:msgId/schedule/cancel", h.cancelScheduledMessage)
 		mail.POST("/me/accounts/:id/messages/:msgId/schedule/send-now", h.sendScheduledMessageNow)
 		mail.POST("/me/send", h.sendMessageSMTP)
+		mail.POST("/me/accounts/:id/drafts", h.createMailDraft)
+		mail.GET("/me/accounts/:id/drafts/:draftId", h.getMailDraft)
+		mail.PUT("/me/accounts/:id/drafts/:draftId", h.updateMailDraft)
+		mail.DELETE("/me/accounts/:id/drafts/:draftId", h.deleteMailDraft)
 		mail.GET("/domains", h.listDomains)
 		mail.POST("/domains", h.createDomain)
 		mail.PATCH("/domains/:id", h.patchDomain)
 		mail.DELETE("/domains/:id", h.deleteDomain)
+		mail.GET("/domains/:id/dns", h.getMailDomainDNS)
+		mail.GET("/domains/:id/dns/check", h.checkMailDomainDNS)
+		mail.POST("/domains/:id/dkim", h.generateMailDomainDKIM)
 		mail.GET("/domains/:id/mailboxes", h.listMailboxes)
 		mail.POST("/domains/:id/mailboxes", h.createMailbox)
 		mail.PATCH("/domains/:id/mailboxes/:mailboxId", h.patchMailbox)
@@ -380,6 +403,9 @@ func main() {
 		mail.POST("/domains/:id/aliases", h.createAlias)
 		mail.PATCH("/domains/:id/aliases/:aliasId", h.patchAlias)
 		mail.DELETE("/domains/:id/aliases/:aliasId", h.deleteAlias)
+		mail.GET("/admin/spam/model", h.getSpamBaseModel)
+		mail.POST("/admin/spam/model/train", h.trainSpamBaseModel)
+		mail.DELETE("/admin/spam/model", h.resetSpamBaseModel)
 	}
 
 	port := os.Getenv("PORT")
@@ -388,6 +414,7 @@ func main() {
 	}
 	log.Println("Mail directory service listening on", port)
 	go h.startScheduledSenderWorker()
+	go h.startManageSieveServer()
 	r.Run(":" + port)
 }
 
@@ -528,7 +555,8 @@ func decryptPassword(encrypted string) (string, error) {
 func isAdminOnlyMailDirectoryPath(path string) bool {
 	return strings.HasPrefix(path, "/mail/domains") ||
 		strings.HasPrefix(path, "/mail/mailboxes") ||
-		strings.HasPrefix(path, "/mail/aliases")
+		strings.HasPrefix(path, "/mail/aliases") ||
+		strings.HasPrefix(path, "/mail/admin/")
 }
 
 // requireAdminRoleForMailDirectory revérifie côté service Go que l'appelant a
@@ -1215,6 +1243,9 @@ type UserEmailAccount struct {
 	ImapAuthReady bool    `json:"imap_auth_ready"`
 	LastSyncAt    *string `json:"last_sync_at,omitempty"`
 	LastSyncError *string `json:"last_sync_error,omitempty"`
+	// Copie des envois dans « Envoyés » IMAP : null = automatique (SentCopyEnabled = valeur retenue).
+	SaveSentCopy    *bool  `json:"save_sent_copy"`
+	SentCopyEnabled bool   `json:"sent_copy_enabled"`
 	CreatedAt     string  `json:"created_at"`
 	UpdatedAt     string `json:"updated_at"`
 }
@@ -1232,6 +1263,7 @@ func (h *Handler) listUserAccounts(c *gin.Context) {
 			),
 			last_sync_at::text,
 			last_sync_error,
+			oauth_provider, save_sent_copy,
 			created_at::text, COALESCE(updated_at::text, '')
 		FROM user_email_accounts
 		WHERE user_id = $1
@@ -1252,12 +1284,15 @@ func (h *Handler) listUserAccounts(c *gin.Context) {
 		var smtpPort sql.NullInt32
 		var lastSyncAt sql.NullString
 		var lastSyncErr sql.NullString
+		var oauthProvider sql.NullString
+		var saveSentCopy sql.NullBool
 		var uat string
 		if err := rows.Scan(
 			&a.ID, &a.UserID, &a.TenantID, &a.Email, &label,
 			&imapHost, &imapPort, &smtpHost, &smtpPort,
 			&a.ImapAuthReady,
 			&lastSyncAt, &lastSyncErr,
+			&oauthProvider, &saveSentCopy,
 			&a.CreatedAt, &uat,
 		); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -1274,6 +1309,13 @@ func (h *Handler) listUserAccounts(c *gin.Context) {
 			p := int(imapPort.Int32)
 			a.ImapPort = &p
 		}
+		if saveSentCopy.Valid {
+			v := saveSentCopy.Bool
+			a.SaveSentCopy = &v
+			a.SentCopyEnabled = v
+		} else {
+			a.SentCopyEnabled = !providerStoresSentCopy(a.Email, imapHost.String, oauthProvider.String)
+		}
 		if smtpHost.Valid {
 			s := smtpHost.String
 			a.SmtpHost = &s
@@ -1565,12 +1607,14 @@ func (h *Handler) patchUserAccount(c *gin.Context) {
 		ImapPort *int    `json:"imap_port"`
 		SmtpHost *string `json:"smtp_host"`
 		SmtpPort *int    `json:"smtp_port"`
+		// true / false, ou null pour revenir au choix automatique (cf. mail_sent.go).
+		SaveSentCopy json.RawMessage `json:"save_sent_copy"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "JSON invalide"})
 		return
 	}
-	if body.Label == nil && body.Password == nil && body.ImapHost == nil && body.ImapPort == nil && body.SmtpHost == nil && body.SmtpPort == nil {
+	if body.Label == nil && body.Password == nil && body.ImapHost == nil && body.ImapPort == nil && body.SmtpHost == nil && body.SmtpPort == nil && body.SaveSentCopy == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "aucun champ à mettre à jour"})
 		return
 	}
@@ -1646,6 +1690,20 @@ func (h *Handler) patchUserAccount(c *gin.Context) {
 			i++
 		}
 	}
+	if body.SaveSentCopy != nil {
+		var v *bool
+		if err := json.Unmarshal(body.SaveSentCopy, &v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "save_sent_copy doit valoir true, false ou null"})
+			return
+		}
+		if v == nil {
+			sets = append(sets, "save_sent_copy = NULL")
+		} else {
+			sets = append(sets, fmt.Sprintf("save_sent_copy = $%d", i))
+			args = append(args, *v)
+			i++
+		}
+	}
 	if len(sets) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "aucune modification applicable"})
 		return
@@ -2000,6 +2058,7 @@ type MailMessage struct {
 	ScheduledSendAt string `json:"scheduled_send_at,omitempty"`
 	CreatedAt       string `json:"created_at"`
 	IsRead          bool   `json:"is_read"`
+	IsStarred       bool   `json:"is_starred"`
 	SpamScore       int    `json:"spam_score"`
 	ThreadKey       string `json:"thread_key,omitempty"`
 	AttachmentCount int    `json:"attachment_count"`
@@ -2020,6 +2079,8 @@ type MailMessageDetail struct {
 	BodyHTML    string               `json:"body_html,omitempty"`
 	RawHeaders  string               `json:"raw_headers,omitempty"`
 	Attachments []MailAttachmentInfo `json:"attachments,omitempty"`
+	// Authentication : SPF / DKIM / DMARC et niveau de confiance (badge), si vérifié.
+	Authentication *mailAuthResult `json:"authentication,omitempty"`
 }
 
 func parseMessageTagCSV(s string) []int {
@@ -2120,7 +2181,7 @@ func (h *Handler) listAccountMessages(c *gin.Context) {
 	argsSel := append(args, limit, offset)
 	orderBy := mailListOrderByClause(c.Query("order"), ftsOrderPrefix, "m.id")
 	selectSQL := fmt.Sprintf(`
-			SELECT m.id, m.account_id, m.folder, m.from_addr, m.to_addrs, m.subject, m.date_at::text, m.scheduled_send_at::text, m.created_at::text, COALESCE(m.is_read, false),
+			SELECT m.id, m.account_id, m.folder, m.from_addr, m.to_addrs, m.subject, m.date_at::text, m.scheduled_send_at::text, m.created_at::text, COALESCE(m.is_read, false), COALESCE(m.is_starred, false),
 				COALESCE(m.thread_key, ''), COALESCE(m.attachment_count, 0),
 				COALESCE((SELECT string_agg(mt.tag_id::text, ',' ORDER BY mt.tag_id) FROM mail_message_tags mt WHERE mt.message_id = m.id), '')
 			FROM mail_messages m%s
@@ -2140,7 +2201,7 @@ func (h *Handler) listAccountMessages(c *gin.Context) {
 		var dateAt, scheduledAt sql.NullString
 		var createdRaw string
 		var tagCSV string
-		if err := rows.Scan(&m.ID, &m.AccountID, &m.Folder, &m.FromAddr, &m.ToAddrs, &m.Subject, &dateAt, &scheduledAt, &createdRaw, &m.IsRead, &m.ThreadKey, &m.AttachmentCount, &tagCSV); err != nil {
+		if err := rows.Scan(&m.ID, &m.AccountID, &m.Folder, &m.FromAddr, &m.ToAddrs, &m.Subject, &dateAt, &scheduledAt, &createdRaw, &m.IsRead, &m.IsStarred, &m.ThreadKey, &m.AttachmentCount, &tagCSV); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -2149,6 +2210,7 @@ func (h *Handler) listAccountMessages(c *gin.Context) {
 		m.SpamScore = spamHeuristicScore(m.Subject, m.FromAddr)
 		msgList = append(msgList, m)
 	}
+	h.applySpamScores(ctx, msgList)
 	if msgList == nil {
 		msgList = []MailMessage{}
 	}
@@ -2213,7 +2275,7 @@ func (h *Handler) listUnifiedUserMessages(c *gin.Context) {
 	argsSel := append(args, limit, offset)
 	orderBy := mailListOrderByClause(c.Query("order"), ftsOrderPrefix, "m.account_id, m.id")
 	selectSQL := fmt.Sprintf(`
-			SELECT m.id, m.account_id, m.folder, m.from_addr, m.to_addrs, m.subject, m.date_at::text, m.scheduled_send_at::text, m.created_at::text, COALESCE(m.is_read, false),
+			SELECT m.id, m.account_id, m.folder, m.from_addr, m.to_addrs, m.subject, m.date_at::text, m.scheduled_send_at::text, m.created_at::text, COALESCE(m.is_read, false), COALESCE(m.is_starred, false),
 				COALESCE(m.thread_key, ''), COALESCE(m.attachment_count, 0),
 				COALESCE((SELECT string_agg(mt.tag_id::text, ',' ORDER BY mt.tag_id) FROM mail_message_tags mt WHERE mt.message_id = m.id), '')
 			FROM mail_messages m
@@ -2233,7 +2295,7 @@ func (h *Handler) listUnifiedUserMessages(c *gin.Context) {
 		var dateAt, scheduledAt sql.NullString
 		var createdRaw string
 		var tagCSV string
-		if err := rows.Scan(&m.ID, &m.AccountID, &m.Folder, &m.FromAddr, &m.ToAddrs, &m.Subject, &dateAt, &scheduledAt, &createdRaw, &m.IsRead, &m.ThreadKey, &m.AttachmentCount, &tagCSV); err != nil {
+		if err := rows.Scan(&m.ID, &m.AccountID, &m.Folder, &m.FromAddr, &m.ToAddrs, &m.Subject, &dateAt, &scheduledAt, &createdRaw, &m.IsRead, &m.IsStarred, &m.ThreadKey, &m.AttachmentCount, &tagCSV); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -2242,6 +2304,7 @@ func (h *Handler) listUnifiedUserMessages(c *gin.Context) {
 		m.SpamScore = spamHeuristicScore(m.Subject, m.FromAddr)
 		msgList = append(msgList, m)
 	}
+	h.applySpamScores(ctx, msgList)
 	if msgList == nil {
 		msgList = []MailMessage{}
 	}
@@ -2265,17 +2328,17 @@ func (h *Handler) getAccountMessage(c *gin.Context) {
 	var m MailMessageDetail
 	var dateAt, scheduledAt sql.NullString
 	var createdRaw string
-	var bodyPlain, bodyHTML, rawHeadersDB sql.NullString
+	var bodyPlain, bodyHTML, rawHeadersDB, authDetails sql.NullString
 	var isRead bool
 	var messageUID int64
 	err = h.dbex(ctx).QueryRow(`
-		SELECT id, account_id, folder, message_uid, from_addr, to_addrs, subject, date_at::text, scheduled_send_at::text, created_at::text, COALESCE(is_read, false), body_plain, body_html,
+		SELECT id, account_id, folder, message_uid, from_addr, to_addrs, subject, date_at::text, scheduled_send_at::text, created_at::text, COALESCE(is_read, false), COALESCE(is_starred, false), body_plain, body_html,
 			raw_headers,
-			COALESCE(thread_key, ''), COALESCE(attachment_count, 0)
+			COALESCE(thread_key, ''), COALESCE(attachment_count, 0), auth_details::text
 		FROM mail_messages
 		WHERE id = $1 AND account_id = $2
 		AND account_id IN (SELECT id FROM user_email_accounts WHERE user_id = current_setting('app.current_user_id', true)::INTEGER)
-	`, msgID, accountID).Scan(&m.ID, &m.AccountID, &m.Folder, &messageUID, &m.FromAddr, &m.ToAddrs, &m.Subject, &dateAt, &scheduledAt, &createdRaw, &isRead, &bodyPlain, &bodyHTML, &rawHeadersDB, &m.ThreadKey, &m.AttachmentCount)
+	`, msgID, accountID).Scan(&m.ID, &m.AccountID, &m.Folder, &messageUID, &m.FromAddr, &m.ToAddrs, &m.Subject, &dateAt, &scheduledAt, &createdRaw, &isRead, &m.IsStarred, &bodyPlain, &bodyHTML, &rawHeadersDB, &m.ThreadKey, &m.AttachmentCount, &authDetails)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
 		return
@@ -2295,6 +2358,12 @@ func (h *Handler) getAccountMessage(c *gin.Context) {
 	if rawHeadersDB.Valid {
 		m.RawHeaders = rawHeadersDB.String
 	}
+	if authDetails.Valid {
+		var auth mailAuthResult
+		if json.Unmarshal([]byte(authDetails.String), &auth) == nil {
+			m.Authentication = &auth
+		}
+	}
 	// Anciens messages : corps en base mais pas encore d’en-têtes bruts — compléter une fois depuis l’IMAP.
 	if strings.TrimSpace(m.RawHeaders) == "" && messageUID > 0 && (bodyPlain.Valid || bodyHTML.Valid) {
 		rawBackfill, fetchErr := h.fetchRawRFC822FromIMAP(c, accountID, messageUID, m.Folder)
@@ -2331,6 +2400,8 @@ func (h *Handler) getAccountMessage(c *gin.Context) {
 			if perr != nil {
 				log.Printf("[mail] parse MIME id=%d: %v", msgID, perr)
 			} else {
+				authenticateParsed(ctx, m.Folder, raw, parsed)
+				m.Authentication = parsed.Auth
 				if saveErr := h.persistParsedMail(c, accountID, msgID, parsed); saveErr != nil {
 					log.Printf("[mail] persistance parse id=%d: %v", msgID, saveErr)
 				}
@@ -2350,8 +2421,17 @@ func (h *Handler) getAccountMessage(c *gin.Context) {
 			}
 		}
 	}
+	// Messages enregistrés avant la vérification : une fois, depuis le RFC822 IMAP.
+	if m.Authentication == nil && messageUID > 0 && m.Folder != "sent" && m.Folder != "drafts" {
+		if raw, fetchErr := h.fetchRawRFC822FromIMAP(c, accountID, messageUID, m.Folder); fetchErr == nil && len(raw) > 0 {
+			m.Authentication = verifyMailAuth(ctx, mailAuthDNS, raw, time.Now())
+			if saveErr := saveMailAuth(h.dbex(ctx), accountID, msgID, m.Authentication); saveErr != nil {
+				log.Printf("[mail] authentification id=%d: %v", msgID, saveErr)
+			}
+		}
+	}
 	m.Attachments = h.loadMessageAttachmentInfo(ctx, msgID)
-	m.SpamScore = spamHeuristicScore(m.Subject, m.FromAddr)
+	m.SpamScore = h.messageSpamScore(ctx, m.Subject, m.FromAddr, m.BodyPlain, m.BodyHTML)
 	c.JSON(http.StatusOK, m)
 }
 
@@ -2374,6 +2454,12 @@ func (h *Handler) persistParsedMail(c *gin.Context, accountID, msgID int, parsed
 	if _, err := h.dbex(ctx).Exec("SELECT set_config('app.current_user_id', $1, false)", userID); err != nil {
 		return err
 	}
+	return h.storeParsedMail(ctx, accountID, msgID, parsed)
+}
+
+// storeParsedMail enregistre corps, en-têtes et pièces jointes d'un message analysé.
+// Le contexte utilisateur (app.current_user_id) doit déjà être posé sur la conn de ctx.
+func (h *Handler) storeParsedMail(ctx context.Context, accountID, msgID int, parsed *mailParsedResult) error {
 	tx, err := h.dbex(ctx).Begin()
 	if err != nil {
 		return err
@@ -2407,6 +2493,11 @@ func (h *Handler) persistParsedMail(c *gin.Context, accountID, msgID int, parsed
 	if _, err := tx.Exec(`DELETE FROM mail_message_attachments WHERE message_id = $1`, msgID); err != nil {
 		return err
 	}
+	if parsed.Auth != nil {
+		if err := saveMailAuth(tx, accountID, msgID, parsed.Auth); err != nil {
+			return err
+		}
+	}
 	for _, a := range parsed.Attachments {
 		if _, err := tx.Exec(`
 			INSERT INTO mail_message_attachments (message_id, part_ordinal, filename, content_type, size_bytes, content)
@@ -3112,6 +3203,7 @@ func (h *Handler) syncAccountIMAP(c *gin.Context) {
 		totalSynced += n
 	}
 	_, _ = h.applyMailRulesForAccount(ctx, accountID)
+	_, _ = h.runVacationResponder(ctx, accountID)
 	passwordStored := false
 	imapHostStored := false
 	if !useOAuth && password != "" {
@@ -3193,32 +3285,33 @@ func smtpHostPort(email string) (host string, port int) {
 
 func (h *Handler) sendMessageSMTP(c *gin.Context) {
 	ctx := c.Request.Context()
-	var body struct {
-		AccountID int    `json:"account_id" binding:"required"`
-		Password  string `json:"password"`
-		To        string `json:"to" binding:"required"`
-		Subject   string `json:"subject"`
-		Body      string `json:"body"`
-		SmtpHost  string `json:"smtp_host"`
-		SmtpPort  int    `json:"smtp_port"`
-		// Adresse d’affichage « De » : compte principal ou alias enregistré pour ce compte.
-		FromEmail string `json:"from_email"`
-	}
-	if err := c.ShouldBindJSON(&body); err != nil {
+	// JSON ou multipart/form-data (champ « payload » + fichiers), voir mail_compose.go.
+	var body mailComposeRequest
+	if err := bindMailCompose(c, &body); err != nil || body.AccountID <= 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "account_id et to requis"})
 		return
 	}
-	if err := h.sendMessageSMTPWithPayload(ctx, body.AccountID, body.Password, body.To, body.Subject, body.Body, body.SmtpHost, body.SmtpPort, body.FromEmail); err != nil {
+	msg, err := h.composeOutgoingMessage(ctx, &body)
+	if err != nil {
+		writeComposeError(c, err)
+		return
+	}
+	raw, err := h.sendOutgoingMail(ctx, body.AccountID, body.Password, body.SmtpHost, body.SmtpPort, body.FromEmail, msg)
+	if err != nil {
 		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "message envoyé"})
+	sentID := h.recordSentMessage(ctx, body.AccountID, 0, msg, raw)
+	h.discardSentDraft(ctx, body.AccountID, body.DraftID)
+	c.JSON(http.StatusOK, gin.H{"message": "message envoyé", "message_id": normalizeMessageID(msg.MessageID), "id": sentID})
 }
 
-func (h *Handler) sendMessageSMTPWithPayload(ctx context.Context, accountID int, passwordInput, toInput, subjectInput, bodyInput, smtpHostInput string, smtpPortInput int, fromEmailInput string) error {
-	to := strings.TrimSpace(strings.ToLower(toInput))
-	if to == "" || !strings.Contains(to, "@") {
-		return fmt.Errorf("destinataire invalide")
+// sendOutgoingMail authentifie le compte (OAuth ou mot de passe), vérifie l'adresse « De »
+// (compte ou alias), sérialise le message puis l'envoie à tous les destinataires (To, Cc, Bcc).
+// Retourne les octets RFC 822 envoyés (copie dans Envoyés, cf. recordSentMessage).
+func (h *Handler) sendOutgoingMail(ctx context.Context, accountID int, passwordInput, smtpHostInput string, smtpPortInput int, fromEmailInput string, m *outgoingMessage) ([]byte, error) {
+	if len(m.envelopeRecipients()) == 0 {
+		return nil, fmt.Errorf("destinataire invalide")
 	}
 	var email string
 	var passwordEnc, oauthRefreshEnc sql.NullString
@@ -3230,10 +3323,10 @@ func (h *Handler) sendMessageSMTPWithPayload(ctx context.Context, accountID int,
 		WHERE id = $1 AND user_id = current_setting('app.current_user_id', true)::INTEGER
 	`, accountID).Scan(&email, &passwordEnc, &oauthRefreshEnc, &dbSmtpHost, &dbSmtpPort)
 	if err == sql.ErrNoRows {
-		return fmt.Errorf("compte non trouvé")
+		return nil, fmt.Errorf("compte non trouvé")
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 	host := strings.TrimSpace(smtpHostInput)
 	port := smtpPortInput
@@ -3252,11 +3345,11 @@ func (h *Handler) sendMessageSMTPWithPayload(ctx context.Context, accountID int,
 	if useOAuth {
 		refreshTok, decErr := decryptPassword(oauthRefreshEnc.String)
 		if decErr != nil || refreshTok == "" {
-			return fmt.Errorf("compte OAuth : reconnectez avec Google pour envoyer")
+			return nil, fmt.Errorf("compte OAuth : reconnectez avec Google pour envoyer")
 		}
 		accessToken, tokErr := getGoogleAccessToken(refreshTok)
 		if tokErr != nil {
-			return fmt.Errorf("OAuth expiré. Reconnectez la boîte avec Google")
+			return nil, fmt.Errorf("OAuth expiré. Reconnectez la boîte avec Google")
 		}
 		auth = &smtpXOAUTH2Auth{email: email, accessToken: accessToken}
 	} else {
@@ -3265,68 +3358,33 @@ func (h *Handler) sendMessageSMTPWithPayload(ctx context.Context, accountID int,
 			password, _ = decryptPassword(passwordEnc.String)
 		}
 		if password == "" {
-			return fmt.Errorf("mot de passe requis pour l'envoi (saisissez-le dans le formulaire ou reconnectez la boîte en le renseignant)")
+			return nil, fmt.Errorf("mot de passe requis pour l'envoi (saisissez-le dans le formulaire ou reconnectez la boîte en le renseignant)")
 		}
 		auth = smtp.PlainAuth("", email, password, host)
 	}
-	displayFrom := strings.TrimSpace(fromEmailInput)
-	if displayFrom == "" {
-		displayFrom = email
-	} else {
-		dfLower := strings.ToLower(displayFrom)
-		if dfLower != strings.ToLower(strings.TrimSpace(email)) {
-			var canon string
-			aerr := h.dbex(ctx).QueryRow(`
-				SELECT a.alias_email FROM user_email_aliases a
-				INNER JOIN user_email_accounts u ON u.id = a.account_id
-				WHERE a.account_id = $1 AND LOWER(a.alias_email) = $2
-				AND a.enabled = true
-				AND u.user_id = current_setting('app.current_user_id', true)::INTEGER
-			`, accountID, dfLower).Scan(&canon)
-			if aerr == sql.ErrNoRows || strings.TrimSpace(canon) == "" {
-				return fmt.Errorf("from_email doit être l’adresse du compte ou un alias enregistré pour cette boîte")
-			}
-			if aerr != nil {
-				return aerr
-			}
-			displayFrom = strings.TrimSpace(canon)
-		} else {
-			displayFrom = email
-		}
-	}
-	subject := subjectInput
-	if subject == "" {
-		subject = "(sans objet)"
-	}
-	messageID := generateOutboundMessageID(displayFrom)
-	msg := []byte("From: " + displayFrom + "\r\n" +
-		"To: " + to + "\r\n" +
-		"Message-ID: " + messageID + "\r\n" +
-		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		"MIME-Version: 1.0\r\n" +
-		"Content-Type: text/plain; charset=UTF-8\r\n" +
-		"Content-Transfer-Encoding: 8bit\r\n" +
-		"\r\n" + bodyInput)
+	displayFrom, err := h.resolveFromAddress(ctx, accountID, email, fromEmailInput)
+	if err != nil {
+		return nil, err
+	}
+	m.stamp(displayFrom)
+	msg, err := buildOutgoingMIME(m)
+	if err != nil {
+		return nil, err
+	}
+	// Boîte ou alias hébergé relayé par notre MTA : signé ici (les relais tiers signent pour leurs domaines).
+	msg = signOutgoingDKIM(h.dbex(ctx), msg, email, displayFrom, host)
 	// Enveloppe SMTP : compte authentifié (évite les rejets si l’alias n’est pas autorisé comme MAIL FROM).
-	if err := smtp.SendMail(addr, auth, email, []string{to}, msg); err != nil {
+	if err := smtp.SendMail(addr, auth, email, m.envelopeRecipients(), msg); err != nil {
 		log.Printf("[mail] SMTP send: %v", err)
-		return fmt.Errorf("envoi SMTP échoué: %w", err)
+		return nil, fmt.Errorf("envoi SMTP échoué: %w", err)
 	}
-	return nil
+	return msg, nil
 }
 
 func (h *Handler) scheduleMessageSMTP(c *gin.Context) {
 	ctx := c.Request.Context()
-	var body struct {
-		AccountID       int    `json:"account_id" binding:"required"`
-		To              string `json:"to" binding:"required"`
-		Subject         string `json:"subject"`
-		Body            string `json:"body"`
-		FromEmail       string `json:"from_email"`
-		ScheduledSendAt string `json:"scheduled_send_at" binding:"required"`
-	}
-	if err := c.ShouldBindJSON(&body); err != nil {
+	var body mailComposeRequest
+	if err := bindMailCompose(c, &body); err != nil || body.AccountID <= 0 || strings.TrimSpace(body.ScheduledSendAt) == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "account_id, to et scheduled_send_at requis"})
 		return
 	}
@@ -3339,28 +3397,45 @@ func (h *Handler) scheduleMessageSMTP(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "la date programmée doit être au moins dans 1 minute"})
 		return
 	}
-	to := strings.TrimSpace(strings.ToLower(body.To))
-	if to == "" || !strings.Contains(to, "@") {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "destinataire invalide"})
+	// Pièces jointes Drive résolues dès maintenant : le message part tel qu'il a été composé.
+	msg, err := h.composeOutgoingMessage(ctx, &body)
+	if err != nil {
+		writeComposeError(c, err)
 		return
 	}
-	subject := strings.TrimSpace(body.Subject)
-	if subject == "" {
-		subject = "(sans objet)"
+	tx, err := h.dbex(ctx).Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+	defer tx.Rollback()
 	var msgID int
 	messageUID := -time.Now().UnixNano()
-	if err := h.dbex(ctx).QueryRow(`
+	refs := referencesHeader(msg.References)
+	if err := tx.QueryRow(`
 		INSERT INTO mail_messages (
-			account_id, folder, message_uid, from_addr, to_addrs, subject, body_plain, date_at,
-			is_read, scheduled_send_at, scheduled_status
+			account_id, folder, message_uid, from_addr, to_addrs, cc_addrs, bcc_addrs, subject,
+			body_plain, body_html, date_at, is_read, scheduled_send_at, scheduled_status,
+			in_reply_to, references_header, thread_key, attachment_count
 		)
-		VALUES ($1, 'scheduled', $2, $3, $4, $5, NULLIF($6, ''), NOW(), true, $7, 'scheduled')
+		VALUES ($1, 'scheduled', $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), NOW(), true, $10, 'scheduled',
+			$11, NULLIF($12, ''), $13, $14)
 		RETURNING id
-	`, body.AccountID, messageUID, strings.TrimSpace(body.FromEmail), to, subject, body.Body, sendAt.UTC()).Scan(&msgID); err != nil {
+	`, body.AccountID, messageUID, strings.TrimSpace(body.FromEmail), addressListString(msg.To), addressListString(msg.Cc),
+		addressListString(msg.Bcc), msg.Subject, msg.Text, msg.HTML, sendAt.UTC(),
+		msg.InReplyTo, refs, threadKeyFromHeaders("", msg.InReplyTo, refs), len(msg.Attachments)).Scan(&msgID); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "impossible de programmer cet envoi"})
 		return
 	}
+	if err := storeComposeAttachments(tx, msgID, msg.Attachments); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.discardSentDraft(ctx, body.AccountID, body.DraftID)
 	c.JSON(http.StatusCreated, gin.H{"ok": true, "id": msgID, "scheduled_send_at": sendAt.UTC().Format(time.RFC3339)})
 }
 
@@ -3452,23 +3527,49 @@ func (h *Handler) processDueScheduledSends(ctx context.Context, limit int) error
 }
 
 func (h *Handler) sendOneScheduledMessage(ctx context.Context, messageID, accountID int) error {
-	var toAddrs, subject, bodyPlain, fromAddr string
+	var toAddrs, ccAddrs, bccAddrs, subject, bodyPlain, bodyHTML, fromAddr, inReplyTo, references string
 	var userID int
 	err := h.dbex(ctx).QueryRow(`
-		SELECT m.to_addrs, m.subject, COALESCE(m.body_plain, ''), COALESCE(m.from_addr, ''), u.user_id
+		SELECT m.to_addrs, m.cc_addrs, m.bcc_addrs, m.subject, COALESCE(m.body_plain, ''), COALESCE(m.body_html, ''),
+			COALESCE(m.from_addr, ''), m.in_reply_to, COALESCE(m.references_header, ''), u.user_id
 		FROM mail_messages m
 		INNER JOIN user_email_accounts u ON u.id = m.account_id
 		WHERE m.id=$1 AND m.account_id=$2
 			AND LOWER(TRIM(m.folder))='scheduled'
 			AND COALESCE(m.scheduled_status, '')='scheduled'
-	`, messageID, accountID).Scan(&toAddrs, &subject, &bodyPlain, &fromAddr, &userID)
+	`, messageID, accountID).Scan(&toAddrs, &ccAddrs, &bccAddrs, &subject, &bodyPlain, &bodyHTML, &fromAddr, &inReplyTo, &references, &userID)
 	if err != nil {
 		return err
 	}
+	// Depuis le worker (pas de conn épinglée) : une conn dédiée porte le contexte utilisateur,
+	// nécessaire pour relire les pièces jointes sous RLS.
+	if _, pinned := ctx.Value(pinKey{}).(*pinnedConn); !pinned {
+		conn, err := h.db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		ctx = withPinnedConn(ctx, &pinnedConn{conn: conn, ctx: ctx})
+	}
 	if _, err := h.dbex(ctx).Exec("SELECT set_config('app.current_user_id', $1, false)", strconv.Itoa(userID)); err != nil {
 		return err
 	}
-	if err := h.sendMessageSMTPWithPayload(ctx, accountID, "", toAddrs, subject, bodyPlain, "", 0, fromAddr); err != nil {
+	atts, err := h.loadComposeAttachments(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	to, cc, bcc, err := composeRecipients(&mailComposeRequest{To: recipientList{toAddrs}, Cc: recipientList{ccAddrs}, Bcc: recipientList{bccAddrs}})
+	if err != nil {
+		return err
+	}
+	msg := &outgoingMessage{
+		To: to, Cc: cc, Bcc: bcc,
+		Subject: subject, Text: bodyPlain, HTML: bodyHTML,
+		InReplyTo: normalizeMessageID(inReplyTo), References: splitMessageIDs(references),
+		Attachments: atts,
+	}
+	raw, err := h.sendOutgoingMail(ctx, accountID, "", "", 0, fromAddr, msg)
+	if err != nil {
 		return err
 	}
 	_, _ = h.dbex(ctx).Exec(`
@@ -3477,8 +3578,10 @@ func (h *Handler) sendOneScheduledMessage(ctx context.Context, messageID, accoun
 			scheduled_status='sent',
 			scheduled_send_at=NULL,
 			date_at=NOW(),
+			internet_msg_id=$3,
 			is_read=true
 		WHERE id=$1 AND account_id=$2
-	`, messageID, accountID)
+	`, messageID, accountID, normalizeMessageID(msg.MessageID))
+	h.recordSentMessage(ctx, accountID, messageID, msg, raw)
 	return nil
 }
@@ -356,6 +356,9 @@ func setupRouter(db *sql.DB) *gin.Engine {
 	r.GET("/health", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "healthy", "service": "mail-directory"}) })
 	h := &Handler{db: db}
 	r.POST("/mail/internal/alias-resolve", h.internalAliasResolve)
+	r.POST("/mail/internal/sieve/evaluate", h.internalSieveEvaluate)
+	r.POST("/mail/internal/vacation/notify", h.internalVacationNotify)
+	r.POST("/mail/internal/dkim/sign", h.internalDKIMSign)
 	r.Use(h.requireTenantAndUser)
 	r.Use(h.requireAdminRoleForMailDirectory)
 	mail := r.Group("/mail")
@@ -367,13 +370,36 @@ func setupRouter(db *sql.DB) *gin.Engine {
 		mail.PATCH("/me/accounts/:id", h.patchUserAccount)
 		mail.DELETE("/me/accounts/:id", h.deleteUserAccount)
 		mail.GET("/me/messages/unified", h.listUnifiedUserMessages)
+		mail.GET("/me/accounts/:id/rules/sieve", h.exportMailRulesSieve)
+		mail.POST("/me/accounts/:id/rules/sieve", h.importMailRulesSieve)
+		mail.POST("/me/accounts/:id/rules/dry-run", h.dryRunMailFilterRule)
+		mail.GET("/me/accounts/:id/vacation", h.getMailVacation)
+		mail.PUT("/me/accounts/:id/vacation", h.putMailVacation)
+		mail.POST("/me/accounts/:id/rules", h.createMailFilterRule)
+		mail.PATCH("/me/accounts/:id/rules/:ruleId", h.patchMailFilterRule)
+		mail.PATCH("/me/accounts/:id/messages/:msgId/star", h.markMessageStarred)
+		mail.GET("/me/conversations/unified", h.listUnifiedConversations)
+		mail.GET("/me/accounts/:id/conversations", h.listAccountConversations)
+		mail.GET("/me/accounts/:id/conversations/messages", h.listConversationMessages)
+		mail.PATCH("/me/accounts/:id/conversations/read", h.markConversationsRead)
+		mail.PATCH("/me/accounts/:id/conversations/folder", h.moveConversationsToFolder)
+		mail.PATCH("/me/accounts/:id/conversations/tags", h.tagConversations)
 		mail.GET("/me/accounts/:id/messages", h.listAccountMessages)
 		mail.PATCH("/me/accounts/:id/messages/read", h.markMessagesReadBulk)
 		mail.PATCH("/me/accounts/:id/messages/folder", h.moveMessagesToFolderBulk)
+		mail.POST("/me/send/schedule", h.scheduleMessageSMTP)
+		mail.POST("/me/send", h.sendMessageSMTP)
+		mail.POST("/me/accounts/:id/drafts", h.createMailDraft)
+		mail.GET("/me/accounts/:id/drafts/:draftId", h.getMailDraft)
+		mail.PUT("/me/accounts/:id/drafts/:draftId", h.updateMailDraft)
+		mail.DELETE("/me/accounts/:id/drafts/:draftId", h.deleteMailDraft)
 		mail.GET("/domains", h.listDomains)
 		mail.POST("/domains", h.createDomain)
 		mail.PATCH("/domains/:id", h.patchDomain)
 		mail.DELETE("/domains/:id", h.deleteDomain)
+		mail.GET("/domains/:id/dns", h.getMailDomainDNS)
+		mail.GET("/domains/:id/dns/check", h.checkMailDomainDNS)
+		mail.POST("/domains/:id/dkim", h.generateMailDomainDKIM)
 		mail.GET("/domains/:id/mailboxes", h.listMailboxes)
 		mail.POST("/domains/:id/mailboxes", h.createMailbox)
 		mail.PATCH("/domains/:id/mailboxes/:mailboxId", h.patchMailbox)
@@ -382,6 +408,9 @@ func setupRouter(db *sql.DB) *gin.Engine {
 		mail.POST("/domains/:id/aliases", h.createAlias)
 		mail.PATCH("/domains/:id/aliases/:aliasId", h.patchAlias)
 		mail.DELETE("/domains/:id/aliases/:aliasId", h.deleteAlias)
+		mail.GET("/admin/spam/model", h.getSpamBaseModel)
+		mail.POST("/admin/spam/model/train", h.trainSpamBaseModel)
+		mail.DELETE("/admin/spam/model", h.resetSpamBaseModel)
 	}
 	return r
 }
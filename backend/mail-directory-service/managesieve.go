@@ -0,0 +1,794 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ManageSieve (RFC 5804) pour les boîtes de nos domaines hébergés (mail_mailboxes) : les clients
+// (Thunderbird, Roundcube, sieve-connect…) y déposent leurs scripts, évalués à la livraison par
+// le routeur MTA via POST /mail/internal/sieve/evaluate. Serveur démarré si MANAGESIEVE_ADDR est
+// défini (ex. « :4190 »). AUTHENTICATE n'est accepté qu'après STARTTLS (MANAGESIEVE_TLS_CERT /
+// MANAGESIEVE_TLS_KEY) : sans certificat, aucun mot de passe ne transite et toute connexion échoue.
+
+const (
+	maxSieveScriptsPerMailbox = 20
+	manageSieveIdleTimeout    = 30 * time.Minute
+	maxManageSieveLine        = 8 << 10
+	// Octets de littéraux {n} acceptés par commande (un script et son nom).
+	maxManageSieveLiterals = maxSieveScriptBytes + maxManageSieveLine
+	// Connexions simultanées, au total et par adresse IP cliente.
+	maxManageSieveConns      = 200
+	maxManageSieveConnsPerIP = 10
+	// Après maxSieveLoginFailures échecs consécutifs depuis une même IP, la boîte refuse
+	// AUTHENTICATE à cette IP pendant sieveLoginBlockSeconds (compteur en base par boîte et IP,
+	// partagé entre connexions et instances) : un tiers ne peut pas bloquer la boîte pour tous.
+	maxSieveLoginFailures  = 10
+	sieveLoginBlockSeconds = 15 * 60
+)
+
+var (
+	errSieveScriptNotFound = errors.New("script introuvable")
+	errSieveScriptActive   = errors.New("script actif")
+	errSieveScriptExists   = errors.New("un script porte déjà ce nom")
+	errSieveQuota          = errors.New("nombre maximal de scripts atteint")
+	errSieveLoginBlocked   = errors.New("trop d'échecs d'authentification, réessayez plus tard")
+)
+
+type sieveStoredScript struct {
+	Name   string
+	Active bool
+}
+
+// sieveScriptStore : persistance des scripts d'une boîte (PostgreSQL en production, mémoire en test).
+type sieveScriptStore interface {
+	Authenticate(ctx context.Context, login, password, clientIP string) (mailboxID int, ok bool, err error)
+	List(ctx context.Context, mailboxID int) ([]sieveStoredScript, error)
+	Get(ctx context.Context, mailboxID int, name string) (string, error)
+	Put(ctx context.Context, mailboxID int, name, content string) error
+	SetActive(ctx context.Context, mailboxID int, name string) error
+	Delete(ctx context.Context, mailboxID int, name string) error
+	Rename(ctx context.Context, mailboxID int, oldName, newName string) error
+}
+
+type pgSieveStore struct {
+	db *sql.DB
+}
+
+func (s *pgSieveStore) Authenticate(ctx context.Context, login, password, clientIP string) (int, bool, error) {
+	var id int
+	var hash string
+	var blocked bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT m.id, m.password_hash, COALESCE((
+		  SELECT f.blocked_until > now() FROM mail_sieve_login_failures f
+		  WHERE f.mailbox_id = m.id AND f.client_ip = $2
+		), false)
+		FROM mail_mailboxes m
+		JOIN mail_domains d ON d.id = m.domain_id
+		WHERE LOWER(m.local_part) || '@' || LOWER(d.domain) = $1
+		  AND COALESCE(m.is_active, true) AND COALESCE(d.is_active, true)
+		LIMIT 1
+	`, strings.ToLower(strings.TrimSpace(login)), clientIP).Scan(&id, &hash, &blocked)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if blocked {
+		return 0, false, errSieveLoginBlocked
+	}
+	if subtle.ConstantTimeCompare([]byte(hashMailboxPassword(password)), []byte(hash)) != 1 {
+		// Les compteurs d'autres IP inactifs depuis un jour sont purgés au passage.
+		if _, err := s.db.ExecContext(ctx, `
+			DELETE FROM mail_sieve_login_failures
+			WHERE mailbox_id = $1 AND client_ip <> $2 AND updated_at < now() - interval '1 day'
+		`, id, clientIP); err != nil {
+			return 0, false, err
+		}
+		if _, err := s.db.ExecContext(ctx, `
+			INSERT INTO mail_sieve_login_failures AS f (mailbox_id, client_ip, failed_attempts)
+			VALUES ($1, $2, 1)
+			ON CONFLICT (mailbox_id, client_ip) DO UPDATE
+			SET failed_attempts = CASE WHEN f.failed_attempts + 1 >= $3 THEN 0 ELSE f.failed_attempts + 1 END,
+			    blocked_until = CASE WHEN f.failed_attempts + 1 >= $3 THEN now() + make_interval(secs => $4) ELSE f.blocked_until END,
+			    updated_at = now()
+		`, id, clientIP, maxSieveLoginFailures, sieveLoginBlockSeconds); err != nil {
+			return 0, false, err
+		}
+		return 0, false, nil
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		DELETE FROM mail_sieve_login_failures WHERE mailbox_id = $1 AND client_ip = $2
+	`, id, clientIP); err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+func (s *pgSieveStore) List(ctx context.Context, mailboxID int) ([]sieveStoredScript, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT name, is_active FROM mail_sieve_scripts WHERE mailbox_id = $1 ORDER BY name
+	`, mailboxID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []sieveStoredScript
+	for rows.Next() {
+		var sc sieveStoredScript
+		if err := rows.Scan(&sc.Name, &sc.Active); err != nil {
+			return nil, err
+		}
+		out = append(out, sc)
+	}
+	return out, rows.Err()
+}
+
+func (s *pgSieveStore) Get(ctx context.Context, mailboxID int, name string) (string, error) {
+	var content string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT content FROM mail_sieve_scripts WHERE mailbox_id = $1 AND name = $2
+	`, mailboxID, name).Scan(&content)
+	if err == sql.ErrNoRows {
+		return "", errSieveScriptNotFound
+	}
+	return content, err
+}
+
+func (s *pgSieveStore) Put(ctx context.Context, mailboxID int, name, content string) error {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO mail_sieve_scripts (mailbox_id, name, content)
+		SELECT $1, $2, $3
+		WHERE EXISTS (SELECT 1 FROM mail_sieve_scripts WHERE mailbox_id = $1 AND name = $2)
+		   OR (SELECT COUNT(*) FROM mail_sieve_scripts WHERE mailbox_id = $1) < $4
+		ON CONFLICT (mailbox_id, name) DO UPDATE SET content = EXCLUDED.content
+	`, mailboxID, name, content, maxSieveScriptsPerMailbox)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errSieveQuota
+	}
+	return nil
+}
+
+func (s *pgSieveStore) SetActive(ctx context.Context, mailboxID int, name string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `UPDATE mail_sieve_scripts SET is_active = false WHERE mailbox_id = $1 AND is_active`, mailboxID); err != nil {
+		return err
+	}
+	if name != "" {
+		res, err := tx.ExecContext(ctx, `UPDATE mail_sieve_scripts SET is_active = true WHERE mailbox_id = $1 AND name = $2`, mailboxID, name)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errSieveScriptNotFound
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *pgSieveStore) Delete(ctx context.Context, mailboxID int, name string) error {
+	var active bool
+	err := s.db.QueryRowContext(ctx, `
+		DELETE FROM mail_sieve_scripts WHERE mailbox_id = $1 AND name = $2 AND NOT is_active
+		RETURNING false
+	`, mailboxID, name).Scan(&active)
+	if err != sql.ErrNoRows {
+		return err
+	}
+	if err := s.db.QueryRowContext(ctx, `SELECT is_active FROM mail_sieve_scripts WHERE mailbox_id = $1 AND name = $2`, mailboxID, name).Scan(&active); err == nil {
+		return errSieveScriptActive
+	}
+	return errSieveScriptNotFound
+}
+
+func (s *pgSieveStore) Rename(ctx context.Context, mailboxID int, oldName, newName string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE mail_sieve_scripts SET name = $3 WHERE mailbox_id = $1 AND name = $2
+	`, mailboxID, oldName, newName)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return errSieveScriptExists
+		}
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errSieveScriptNotFound
+	}
+	return nil
+}
+
+// activeScriptForAddress : script actif de la boîte hébergée local@domaine (found=false sinon).
+func (s *pgSieveStore) activeScriptForAddress(ctx context.Context, address string) (string, bool, error) {
+	var content string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT sc.content
+		FROM mail_sieve_scripts sc
+		JOIN mail_mailboxes m ON m.id = sc.mailbox_id
+		JOIN mail_domains d ON d.id = m.domain_id
+		WHERE sc.is_active
+		  AND LOWER(m.local_part) || '@' || LOWER(d.domain) = $1
+		  AND COALESCE(m.is_active, true) AND COALESCE(d.is_active, true)
+		LIMIT 1
+	`, strings.ToLower(strings.TrimSpace(address))).Scan(&content)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return content, err == nil, err
+}
+
+// startManageSieveServer écoute MANAGESIEVE_ADDR (no-op si vide).
+func (h *Handler) startManageSieveServer() {
+	addr := strings.TrimSpace(os.Getenv("MANAGESIEVE_ADDR"))
+	if addr == "" {
+		return
+	}
+	var tlsConf *tls.Config
+	if cert, key := os.Getenv("MANAGESIEVE_TLS_CERT"), os.Getenv("MANAGESIEVE_TLS_KEY"); cert != "" && key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			log.Printf("[managesieve] certificat TLS: %v (STARTTLS désactivé)", err)
+		} else {
+			tlsConf = &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}
+		}
+	}
+	if tlsConf == nil {
+		log.Printf("[managesieve] pas de certificat TLS : AUTHENTICATE sera refusé (mot de passe en clair)")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("[managesieve] écoute %s: %v", addr, err)
+		return
+	}
+	log.Printf("[managesieve] écoute sur %s", addr)
+	store := &pgSieveStore{db: h.db}
+	limiter := newManageSieveConnLimiter(maxManageSieveConns, maxManageSieveConnsPerIP)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("[managesieve] accept: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		release, ok := limiter.acquire(conn.RemoteAddr())
+		if !ok {
+			_ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			_, _ = io.WriteString(conn, "BYE (TRYLATER) "+manageSieveQuote("trop de connexions")+"\r\n")
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer release()
+			serveManageSieve(conn, store, tlsConf)
+		}()
+	}
+}
+
+// manageSieveConnLimiter borne les connexions simultanées, au total et par adresse IP.
+type manageSieveConnLimiter struct {
+	mu          sync.Mutex
+	max, maxPer int
+	total       int
+	perIP       map[string]int
+}
+
+func newManageSieveConnLimiter(max, maxPerIP int) *manageSieveConnLimiter {
+	return &manageSieveConnLimiter{max: max, maxPer: maxPerIP, perIP: map[string]int{}}
+}
+
+// manageSieveClientIP — adresse IP du pair, sans le port.
+func manageSieveClientIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return ip
+}
+
+func (l *manageSieveConnLimiter) acquire(addr net.Addr) (release func(), ok bool) {
+	ip := manageSieveClientIP(addr)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.total >= l.max || l.perIP[ip] >= l.maxPer {
+		return nil, false
+	}
+	l.total++
+	l.perIP[ip]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.total--
+			if l.perIP[ip]--; l.perIP[ip] <= 0 {
+				delete(l.perIP, ip)
+			}
+		})
+	}, true
+}
+
+type manageSieveSession struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	w         *bufio.Writer
+	store     sieveScriptStore
+	tlsConf   *tls.Config
+	tlsActive bool
+	clientIP  string
+	mailboxID int
+}
+
+func serveManageSieve(conn net.Conn, store sieveScriptStore, tlsConf *tls.Config) {
+	defer conn.Close()
+	s := &manageSieveSession{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), store: store, tlsConf: tlsConf,
+		clientIP: manageSieveClientIP(conn.RemoteAddr())}
+	s.writeCapabilities()
+	s.ok("", "ManageSieve Cloudity prêt")
+	for {
+		_ = conn.SetDeadline(time.Now().Add(manageSieveIdleTimeout))
+		args, err := s.readLine()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.reply("BYE", "", err.Error())
+			}
+			return
+		}
+		if len(args) == 0 {
+			s.no("", "commande vide")
+			continue
+		}
+		if !s.dispatch(strings.ToUpper(args[0]), args[1:]) {
+			return
+		}
+	}
+}
+
+func (s *manageSieveSession) writeCapabilities() {
+	fmt.Fprintf(s.w, "\"IMPLEMENTATION\" \"Cloudity ManageSieve\"\r\n")
+	// RFC 5804 §2.1 : pas de mécanisme PLAIN annoncé tant que le canal n'est pas chiffré.
+	if s.tlsActive {
+		fmt.Fprintf(s.w, "\"SASL\" \"PLAIN\"\r\n")
+	}
+	fmt.Fprintf(s.w, "\"SIEVE\" %s\r\n", manageSieveQuote(strings.Join(sieveExtensions, " ")))
+	if s.tlsConf != nil && !s.tlsActive {
+		fmt.Fprintf(s.w, "\"STARTTLS\"\r\n")
+	}
+	fmt.Fprintf(s.w, "\"MAXREDIRECTS\" \"4\"\r\n\"VERSION\" \"1.0\"\r\n")
+}
+
+func (s *manageSieveSession) reply(status, code, text string) {
+	s.w.WriteString(status)
+	if code != "" {
+		s.w.WriteString(" (" + code + ")")
+	}
+	if text != "" {
+		s.w.WriteString(" " + manageSieveQuote(text))
+	}
+	s.w.WriteString("\r\n")
+	_ = s.w.Flush()
+}
+
+func (s *manageSieveSession) ok(code, text string) { s.reply("OK", code, text) }
+func (s *manageSieveSession) no(code, text string) { s.reply("NO", code, text) }
+
+// dispatch traite une commande ; false ferme la connexion.
+func (s *manageSieveSession) dispatch(cmd string, args []string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	switch cmd {
+	case "CAPABILITY":
+		s.writeCapabilities()
+		s.ok("", "")
+		return true
+	case "NOOP":
+		s.ok("", "NOOP")
+		return true
+	case "LOGOUT":
+		s.ok("", "au revoir")
+		return false
+	case "STARTTLS":
+		if s.tlsConf == nil || s.tlsActive {
+			s.no("", "STARTTLS indisponible")
+			return true
+		}
+		s.ok("", "négociation TLS")
+		tlsConn := tls.Server(s.conn, s.tlsConf)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return false
+		}
+		s.conn, s.tlsActive = tlsConn, true
+		s.r, s.w = bufio.NewReader(tlsConn), bufio.NewWriter(tlsConn)
+		s.writeCapabilities()
+		s.ok("", "TLS actif")
+		return true
+	case "AUTHENTICATE":
+		return s.authenticate(ctx, args)
+	}
+	if s.mailboxID == 0 {
+		s.no("", "authentification requise")
+		return true
+	}
+	switch cmd {
+	case "LISTSCRIPTS":
+		list, err := s.store.List(ctx, s.mailboxID)
+		if err != nil {
+			s.no("TRYLATER", "erreur interne")
+			return true
+		}
+		for _, sc := range list {
+			s.w.WriteString(manageSieveQuote(sc.Name))
+			if sc.Active {
+				s.w.WriteString(" ACTIVE")
+			}
+			s.w.WriteString("\r\n")
+		}
+		s.ok("", "")
+	case "GETSCRIPT":
+		if len(args) != 1 {
+			s.no("", "GETSCRIPT <nom>")
+			return true
+		}
+		content, err := s.store.Get(ctx, s.mailboxID, args[0])
+		if err != nil {
+			s.storeError(err)
+			return true
+		}
+		fmt.Fprintf(s.w, "{%d}\r\n%s\r\n", len(content), content)
+		s.ok("", "")
+	case "CHECKSCRIPT":
+		if len(args) != 1 {
+			s.no("", "CHECKSCRIPT <script>")
+			return true
+		}
+		if _, err := parseSieve(args[0]); err != nil {
+			s.no("", err.Error())
+			return true
+		}
+		s.ok("", "")
+	case "PUTSCRIPT":
+		if len(args) != 2 || !validSieveScriptName(args[0]) {
+			s.no("", "PUTSCRIPT <nom> <script>")
+			return true
+		}
+		if _, err := parseSieve(args[1]); err != nil {
+			s.no("", err.Error())
+			return true
+		}
+		if err := s.store.Put(ctx, s.mailboxID, args[0], args[1]); err != nil {
+			s.storeError(err)
+			return true
+		}
+		s.ok("", "")
+	case "HAVESPACE":
+		if len(args) != 2 || !validSieveScriptName(args[0]) {
+			s.no("", "HAVESPACE <nom> <taille>")
+			return true
+		}
+		if n, err := strconv.Atoi(args[1]); err != nil || n > maxSieveScriptBytes {
+			s.no("QUOTA/MAXSIZE", fmt.Sprintf("taille maximale %d octets", maxSieveScriptBytes))
+			return true
+		}
+		s.ok("", "")
+	case "SETACTIVE":
+		if len(args) != 1 {
+			s.no("", "SETACTIVE <nom>")
+			return true
+		}
+		if err := s.store.SetActive(ctx, s.mailboxID, args[0]); err != nil {
+			s.storeError(err)
+			return true
+		}
+		s.ok("", "")
+	case "DELETESCRIPT":
+		if len(args) != 1 {
+			s.no("", "DELETESCRIPT <nom>")
+			return true
+		}
+		if err := s.store.Delete(ctx, s.mailboxID, args[0]); err != nil {
+			s.storeError(err)
+			return true
+		}
+		s.ok("", "")
+	case "RENAMESCRIPT":
+		if len(args) != 2 || !validSieveScriptName(args[1]) {
+			s.no("", "RENAMESCRIPT <ancien> <nouveau>")
+			return true
+		}
+		if err := s.store.Rename(ctx, s.mailboxID, args[0], args[1]); err != nil {
+			s.storeError(err)
+			return true
+		}
+		s.ok("", "")
+	default:
+		s.no("", "commande inconnue")
+	}
+	return true
+}
+
+func (s *manageSieveSession) storeError(err error) {
+	switch {
+	case errors.Is(err, errSieveScriptNotFound):
+		s.no("NONEXISTENT", err.Error())
+	case errors.Is(err, errSieveScriptActive):
+		s.no("ACTIVE", err.Error())
+	case errors.Is(err, errSieveScriptExists):
+		s.no("ALREADYEXISTS", err.Error())
+	case errors.Is(err, errSieveQuota):
+		s.no("QUOTA/MAXSCRIPTS", err.Error())
+	default:
+		log.Printf("[managesieve] mailbox=%d: %v", s.mailboxID, err)
+		s.no("TRYLATER", "erreur interne")
+	}
+}
+
+// authenticate : SASL PLAIN (réponse initiale ou défi vide), uniquement après STARTTLS.
+func (s *manageSieveSession) authenticate(ctx context.Context, args []string) bool {
+	if s.mailboxID != 0 {
+		s.no("", "déjà authentifié")
+		return true
+	}
+	if !s.tlsActive {
+		msg := "STARTTLS requis"
+		if s.tlsConf == nil {
+			msg = "TLS non configuré sur ce serveur"
+		}
+		s.no("ENCRYPT-NEEDED", msg)
+		return true
+	}
+	if len(args) == 0 || !strings.EqualFold(args[0], "PLAIN") {
+		s.no("", "mécanisme SASL non pris en charge")
+		return true
+	}
+	var resp string
+	if len(args) > 1 {
+		resp = args[1]
+	} else {
+		s.w.WriteString("\"\"\r\n")
+		_ = s.w.Flush()
+		line, err := s.readLine()
+		if err != nil || len(line) != 1 {
+			s.no("", "réponse SASL invalide")
+			return err == nil
+		}
+		resp = line[0]
+	}
+	if resp == "*" {
+		s.no("", "authentification annulée")
+		return true
+	}
+	raw, err := base64.StdEncoding.DecodeString(resp)
+	if err != nil {
+		s.no("", "réponse SASL invalide")
+		return true
+	}
+	parts := strings.Split(string(raw), "\x00")
+	if len(parts) != 3 || (parts[0] != "" && !strings.EqualFold(parts[0], parts[1])) {
+		s.no("", "réponse SASL invalide")
+		return true
+	}
+	id, ok, err := s.store.Authenticate(ctx, parts[1], parts[2], s.clientIP)
+	if errors.Is(err, errSieveLoginBlocked) {
+		s.no("TRYLATER", err.Error())
+		return true
+	}
+	if err != nil {
+		log.Printf("[managesieve] authentification: %v", err)
+		s.no("TRYLATER", "erreur interne")
+		return true
+	}
+	if !ok {
+		time.Sleep(time.Second)
+		s.no("", "identifiants invalides")
+		return true
+	}
+	s.mailboxID = id
+	s.ok("", "authentifié")
+	return true
+}
+
+func validSieveScriptName(name string) bool {
+	if name == "" || len(name) > 255 {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// readLine lit une commande : atomes, chaînes entre guillemets et littéraux {n} / {n+}.
+// total borne le texte de la commande (maxManageSieveLine), literals le cumul des littéraux
+// (maxManageSieveLiterals) : une commande ne peut pas enchaîner les littéraux sans limite.
+func (s *manageSieveSession) readLine() ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inAtom := false
+	total, literals := 0, 0
+	flush := func() {
+		if inAtom {
+			args = append(args, cur.String())
+			cur.Reset()
+			inAtom = false
+		}
+	}
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		total++
+		if total > maxManageSieveLine {
+			return nil, errors.New("ligne trop longue")
+		}
+		switch {
+		case b == '\n':
+			flush()
+			return args, nil
+		case b == '\r' || b == ' ':
+			flush()
+		case b == '"' && !inAtom:
+			var str strings.Builder
+			for {
+				c, err := s.r.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				total++
+				if total > maxManageSieveLine || c == '\n' {
+					return nil, errors.New("chaîne invalide")
+				}
+				if c == '\\' {
+					if c, err = s.r.ReadByte(); err != nil {
+						return nil, err
+					}
+				} else if c == '"' {
+					break
+				}
+				str.WriteByte(c)
+			}
+			args = append(args, str.String())
+		case b == '{' && !inAtom:
+			spec, err := s.r.ReadString('}')
+			if err != nil || len(spec) > 16 {
+				return nil, errors.New("littéral invalide")
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(spec, "}"), "+"))
+			if err != nil || n < 0 || n > maxSieveScriptBytes || literals+n > maxManageSieveLiterals {
+				return nil, errors.New("littéral trop volumineux")
+			}
+			literals += n
+			if crlf, err := s.r.ReadString('\n'); err != nil || strings.TrimRight(crlf, "\r\n") != "" {
+				return nil, errors.New("littéral invalide")
+			}
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(s.r, buf); err != nil {
+				return nil, err
+			}
+			args = append(args, string(buf))
+		default:
+			inAtom = true
+			cur.WriteByte(b)
+		}
+	}
+}
+
+func manageSieveQuote(s string) string {
+	if strings.ContainsAny(s, "\r\n") {
+		return fmt.Sprintf("{%d}\r\n%s", len(s), s)
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// POST /mail/internal/sieve/evaluate — script actif du destinataire appliqué au message entrant
+// (routeur MTA, hors JWT). Corps : recipient, envelope_from, headers (bloc d'en-têtes brut), size.
+func (h *Handler) internalSieveEvaluate(c *gin.Context) {
+	if !mtaInternalTokenOK(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing MTA internal token"})
+		return
+	}
+	var body struct {
+		Recipient    string `json:"recipient"`
+		EnvelopeFrom string `json:"envelope_from"`
+		Headers      string `json:"headers"`
+		Size         int64  `json:"size"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || !strings.Contains(body.Recipient, "@") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	store := &pgSieveStore{db: h.db}
+	content, found, err := store.activeScriptForAddress(c.Request.Context(), body.Recipient)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusOK, gin.H{"script": false, "keep": true})
+		return
+	}
+	script, err := parseSieve(content)
+	if err != nil {
+		// Script accepté par PUTSCRIPT mais devenu invalide : keep implicite (RFC 5228 §2.10.6).
+		log.Printf("[sieve] script actif invalide pour %s: %v", body.Recipient, err)
+		c.JSON(http.StatusOK, gin.H{"script": true, "keep": true, "error": err.Error()})
+		return
+	}
+	out := evalSieve(script, &sieveMessage{
+		Headers:      parseSieveHeaderBlock(body.Headers),
+		Size:         body.Size,
+		EnvelopeFrom: body.EnvelopeFrom,
+		EnvelopeTo:   body.Recipient,
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"script":   true,
+		"keep":     out.Keep,
+		"fileinto": out.FileInto,
+		"redirect": out.Redirect,
+		"discard":  out.Discard,
+		"reject":   out.Reject,
+		"flags":    out.Flags,
+	})
+}
+
+// parseSieveHeaderBlock : en-têtes RFC 5322 (lignes repliées jointes), clés en minuscules.
+func parseSieveHeaderBlock(raw string) map[string][]string {
+	out := map[string][]string{}
+	var name string
+	for _, line := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
+		if line == "" {
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && name != "" {
+			vals := out[name]
+			vals[len(vals)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(k))
+		out[name] = append(out[name], strings.TrimSpace(v))
+	}
+	dec := new(mime.WordDecoder)
+	for k, vals := range out {
+		for i, v := range vals {
+			if d, err := dec.DecodeHeader(v); err == nil {
+				vals[i] = d
+			}
+		}
+		out[k] = vals
+	}
+	return out
+}
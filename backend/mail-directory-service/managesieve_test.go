@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memSieveStore : store en mémoire pour exercer le protocole sans PostgreSQL.
+type memSieveStore struct {
+	scripts  map[string]string
+	active   string
+	clientIP string
+}
+
+func (m *memSieveStore) Authenticate(_ context.Context, login, password, clientIP string) (int, bool, error) {
+	m.clientIP = clientIP
+	return 1, login == "alice@hosted.example" && password == "secret", nil
+}
+
+func (m *memSieveStore) List(context.Context, int) ([]sieveStoredScript, error) {
+	var out []sieveStoredScript
+	for name := range m.scripts {
+		out = append(out, sieveStoredScript{Name: name, Active: name == m.active})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (m *memSieveStore) Get(_ context.Context, _ int, name string) (string, error) {
+	s, ok := m.scripts[name]
+	if !ok {
+		return "", errSieveScriptNotFound
+	}
+	return s, nil
+}
+
+func (m *memSieveStore) Put(_ context.Context, _ int, name, content string) error {
+	m.scripts[name] = content
+	return nil
+}
+
+func (m *memSieveStore) SetActive(_ context.Context, _ int, name string) error {
+	if _, ok := m.scripts[name]; !ok && name != "" {
+		return errSieveScriptNotFound
+	}
+	m.active = name
+	return nil
+}
+
+func (m *memSieveStore) Delete(_ context.Context, _ int, name string) error {
+	if name == m.active {
+		return errSieveScriptActive
+	}
+	if _, ok := m.scripts[name]; !ok {
+		return errSieveScriptNotFound
+	}
+	delete(m.scripts, name)
+	return nil
+}
+
+func (m *memSieveStore) Rename(_ context.Context, _ int, oldName, newName string) error {
+	s, ok := m.scripts[oldName]
+	if !ok {
+		return errSieveScriptNotFound
+	}
+	delete(m.scripts, oldName)
+	m.scripts[newName] = s
+	return nil
+}
+
+type sieveTestClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// until lit jusqu'à la ligne OK / NO / BYE et renvoie toute la réponse.
+func (c *sieveTestClient) until() string {
+	c.t.Helper()
+	var b strings.Builder
+	for {
+		_ = c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("read: %v (so far %q)", err, b.String())
+		}
+		b.WriteString(line)
+		for _, st := range []string{"OK", "NO", "BYE"} {
+			if line == st+"\r\n" || strings.HasPrefix(line, st+" ") {
+				return b.String()
+			}
+		}
+	}
+}
+
+func (c *sieveTestClient) cmd(line string) string {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.until()
+}
+
+// starttls négocie TLS et renvoie les capacités annoncées sur le canal chiffré.
+func (c *sieveTestClient) starttls() string {
+	c.t.Helper()
+	if got := c.cmd("STARTTLS"); !strings.HasPrefix(got, "OK") {
+		c.t.Fatalf("STARTTLS = %q", got)
+	}
+	tlsConn := tls.Client(c.conn, &tls.Config{InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		c.t.Fatal(err)
+	}
+	c.conn, c.r = tlsConn, bufio.NewReader(tlsConn)
+	return c.until()
+}
+
+// sieveTestTLS — certificat autosigné éphémère pour exercer STARTTLS.
+func sieveTestTLS(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sieve.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}, MinVersion: tls.VersionTLS12}
+}
+
+func TestManageSieveSession(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	store := &memSieveStore{scripts: map[string]string{}}
+	go serveManageSieve(server, store, sieveTestTLS(t))
+	c := &sieveTestClient{t: t, conn: client, r: bufio.NewReader(client)}
+
+	if greet := c.until(); strings.Contains(greet, "SASL") || !strings.Contains(greet, "STARTTLS") || !strings.Contains(greet, "fileinto") {
+		t.Fatalf("greeting = %q", greet)
+	}
+	good := base64.StdEncoding.EncodeToString([]byte("\x00alice@hosted.example\x00secret"))
+	if got := c.cmd(`AUTHENTICATE "PLAIN" "` + good + `"`); !strings.HasPrefix(got, "NO (ENCRYPT-NEEDED)") {
+		t.Fatalf("auth before STARTTLS = %q", got)
+	}
+	if caps := c.starttls(); !strings.Contains(caps, `"SASL" "PLAIN"`) || strings.Contains(caps, "STARTTLS") {
+		t.Fatalf("capabilities after STARTTLS = %q", caps)
+	}
+	if got := c.cmd("LISTSCRIPTS"); !strings.HasPrefix(got, "NO") {
+		t.Errorf("LISTSCRIPTS before auth = %q", got)
+	}
+	bad := base64.StdEncoding.EncodeToString([]byte("\x00alice@hosted.example\x00nope"))
+	if got := c.cmd(`AUTHENTICATE "PLAIN" "` + bad + `"`); !strings.HasPrefix(got, "NO") {
+		t.Errorf("bad password = %q", got)
+	}
+	if got := c.cmd(`AUTHENTICATE "PLAIN" "` + good + `"`); !strings.HasPrefix(got, "OK") {
+		t.Fatalf("auth = %q", got)
+	}
+	if store.clientIP != "pipe" {
+		t.Errorf("client IP passed to the store = %q", store.clientIP)
+	}
+
+	script := "require \"fileinto\";\r\nif header :contains \"subject\" \"x\" { fileinto \"X\"; }\r\n"
+	if got := c.cmd(fmt.Sprintf("PUTSCRIPT \"main\" {%d+}\r\n%s", len(script), script)); !strings.HasPrefix(got, "OK") {
+		t.Fatalf("PUTSCRIPT = %q", got)
+	}
+	if got := c.cmd("PUTSCRIPT \"broken\" {9+}\r\nfileinto;"); !strings.HasPrefix(got, "NO") {
+		t.Errorf("invalid PUTSCRIPT = %q", got)
+	}
+	if got := c.cmd(`SETACTIVE "main"`); !strings.HasPrefix(got, "OK") {
+		t.Errorf("SETACTIVE = %q", got)
+	}
+	if got := c.cmd("LISTSCRIPTS"); got != "\"main\" ACTIVE\r\nOK\r\n" {
+		t.Errorf("LISTSCRIPTS = %q", got)
+	}
+	if got := c.cmd(`GETSCRIPT "main"`); got != fmt.Sprintf("{%d}\r\n%s\r\nOK\r\n", len(script), script) {
+		t.Errorf("GETSCRIPT = %q", got)
+	}
+	if got := c.cmd(`DELETESCRIPT "main"`); !strings.HasPrefix(got, "NO (ACTIVE)") {
+		t.Errorf("DELETESCRIPT active = %q", got)
+	}
+	if got := c.cmd(`GETSCRIPT "absent"`); !strings.HasPrefix(got, "NO (NONEXISTENT)") {
+		t.Errorf("GETSCRIPT absent = %q", got)
+	}
+	if got := c.cmd(`HAVESPACE "x" 999999999`); !strings.HasPrefix(got, "NO (QUOTA/MAXSIZE)") {
+		t.Errorf("HAVESPACE = %q", got)
+	}
+	if got := c.cmd("LOGOUT"); !strings.HasPrefix(got, "OK") {
+		t.Errorf("LOGOUT = %q", got)
+	}
+}
+
+func TestManageSieveRequiresTLSWhenOffered(t *testing.T) {
+	s := &manageSieveSession{tlsConf: &tls.Config{}}
+	var b strings.Builder
+	s.w = bufio.NewWriter(&b)
+	s.writeCapabilities()
+	_ = s.w.Flush()
+	if strings.Contains(b.String(), "SASL") || !strings.Contains(b.String(), "STARTTLS") {
+		t.Errorf("capabilities before TLS = %q", b.String())
+	}
+}
+
+// Sans certificat, PLAIN n'est jamais annoncé ni accepté : le mot de passe ne passe pas en clair.
+func TestManageSieveRefusesPlaintextAuth(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	store := &memSieveStore{scripts: map[string]string{}}
+	go serveManageSieve(server, store, nil)
+	c := &sieveTestClient{t: t, conn: client, r: bufio.NewReader(client)}
+	if greet := c.until(); strings.Contains(greet, "SASL") || strings.Contains(greet, "STARTTLS") {
+		t.Fatalf("greeting without TLS = %q", greet)
+	}
+	good := base64.StdEncoding.EncodeToString([]byte("\x00alice@hosted.example\x00secret"))
+	if got := c.cmd(`AUTHENTICATE "PLAIN" "` + good + `"`); !strings.HasPrefix(got, "NO (ENCRYPT-NEEDED)") {
+		t.Fatalf("plaintext auth = %q", got)
+	}
+	if store.clientIP != "" {
+		t.Fatal("credentials must not reach the store over plaintext")
+	}
+}
+
+func TestManageSieveClientIP(t *testing.T) {
+	if got := manageSieveClientIP(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 4190}); got != "2001:db8::1" {
+		t.Errorf("IPv6 = %q", got)
+	}
+	if got := manageSieveClientIP(&net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 51000}); got != "192.0.2.7" {
+		t.Errorf("IPv4 = %q", got)
+	}
+}
+
+func TestManageSieveConnLimiter(t *testing.T) {
+	l := newManageSieveConnLimiter(3, 2)
+	a := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000}
+	b := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 4000}
+	r1, ok1 := l.acquire(a)
+	_, ok2 := l.acquire(&net.TCPAddr{IP: a.IP, Port: 4001})
+	if !ok1 || !ok2 {
+		t.Fatal("first connections refused")
+	}
+	if _, ok := l.acquire(a); ok {
+		t.Error("per-IP limit not enforced")
+	}
+	if _, ok := l.acquire(b); !ok {
+		t.Error("other IP refused below the global limit")
+	}
+	if _, ok := l.acquire(&net.TCPAddr{IP: net.ParseIP("192.0.2.3"), Port: 4000}); ok {
+		t.Error("global limit not enforced")
+	}
+	r1()
+	r1()
+	if _, ok := l.acquire(a); !ok {
+		t.Error("slot not released")
+	}
+}
+
+func TestManageSieveLiteralBudget(t *testing.T) {
+	big := strings.Repeat("x", maxSieveScriptBytes)
+	// Deux littéraux maximaux dans une même commande dépassent le budget par commande.
+	in := fmt.Sprintf("PUTSCRIPT {%d+}\r\n%s {%d+}\r\n%s\r\n", len(big), big, len(big), big)
+	s := &manageSieveSession{r: bufio.NewReader(strings.NewReader(in))}
+	if _, err := s.readLine(); err == nil {
+		t.Fatal("command with oversized literals accepted")
+	}
+	in = fmt.Sprintf("PUTSCRIPT \"main\" {%d+}\r\n%s\r\n", len(big), big)
+	s = &manageSieveSession{r: bufio.NewReader(strings.NewReader(in))}
+	if args, err := s.readLine(); err != nil || len(args) != 3 || len(args[2]) != len(big) {
+		t.Fatalf("readLine = %d args, %v", len(args), err)
+	}
+}
+
+// blockedSieveStore refuse toute authentification comme une boîte verrouillée.
+type blockedSieveStore struct{ memSieveStore }
+
+func (blockedSieveStore) Authenticate(context.Context, string, string, string) (int, bool, error) {
+	return 0, false, errSieveLoginBlocked
+}
+
+func TestManageSieveLoginBlocked(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go serveManageSieve(server, &blockedSieveStore{}, sieveTestTLS(t))
+	c := &sieveTestClient{t: t, conn: client, r: bufio.NewReader(client)}
+	c.until()
+	c.starttls()
+	good := base64.StdEncoding.EncodeToString([]byte("\x00alice@hosted.example\x00secret"))
+	if got := c.cmd(`AUTHENTICATE "PLAIN" "` + good + `"`); !strings.HasPrefix(got, "NO (TRYLATER)") {
+		t.Errorf("blocked login = %q", got)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+// cursor.go — pagination par curseur (keyset) de la timeline et résumé par mois.
+//
+// La timeline est triée par (timelineKeySQL, id) décroissants (index de la migration 57).
+// Le curseur est opaque pour le client : base64url d'un JSON {t, i} désignant la dernière
+// ligne servie ; la page suivante reprend strictement après, quelles que soient les photos
+// ajoutées entre-temps. Chaque mois du résumé porte un curseur qui démarre sur ce mois.
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// timelineKeySQL — clé de tri de la timeline (prise de vue, sinon import ; jamais NULL).
+const timelineKeySQL = `COALESCE(taken_at, created_at, TIMESTAMPTZ 'epoch')`
+
+type timelineCursor struct {
+	At time.Time `json:"t"`
+	ID int       `json:"i"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func encodeCursor(v any) string {
+	raw, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(raw, v) != nil {
+		return errInvalidCursor
+	}
+	return nil
+}
+
+func parseTimelineCursor(s string) (timelineCursor, error) {
+	var cur timelineCursor
+	if err := decodeCursor(s, &cur); err != nil || cur.At.IsZero() || cur.ID < 0 {
+		return timelineCursor{}, errInvalidCursor
+	}
+	return cur, nil
+}
+
+// timelineBucket — nombre de médias d'un mois (UTC) ; Cursor ouvre la timeline sur ce mois.
+type timelineBucket struct {
+	Year   int    `json:"year"`
+	Month  int    `json:"month"`
+	Count  int    `json:"count"`
+	Cursor string `json:"cursor"`
+}
+
+// monthStartCursor — curseur placé juste avant le 1er instant du mois suivant : la page
+// commence par le média le plus récent du mois (year, month).
+func monthStartCursor(year, month int) string {
+	next := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+	return encodeCursor(timelineCursor{At: next, ID: 0})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimelineCursorRoundTrip(t *testing.T) {
+	at := time.Date(2023, 8, 14, 9, 30, 12, 345678000, time.UTC)
+	cur, err := parseTimelineCursor(encodeCursor(timelineCursor{At: at, ID: 42}))
+	if err != nil || !cur.At.Equal(at) || cur.ID != 42 {
+		t.Fatalf("got %+v %v", cur, err)
+	}
+	for _, raw := range []string{"not-base64!", "e30", encodeCursor(map[string]int{"i": 3})} {
+		if _, err := parseTimelineCursor(raw); err == nil {
+			t.Errorf("cursor %q must be rejected", raw)
+		}
+	}
+}
+
+func TestMonthStartCursor(t *testing.T) {
+	cur, err := parseTimelineCursor(monthStartCursor(2019, 12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC); !cur.At.Equal(want) || cur.ID != 0 {
+		t.Fatalf("December 2019 bucket cursor = %+v, want strictly before %v", cur, want)
+	}
+}
+
+func TestTimelineRejectsInvalidCursor(t *testing.T) {
+	r := setupRouter(nil)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/photos/timeline?cursor=bogus", nil)
+	req.Header.Set("X-User-ID", "1")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid cursor: got %d", w.Code)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+// duplicates.go — quasi-doublons et rafales (empreintes perceptuelles, migration 56).
+//
+// drive-service calcule dHash / pHash de chaque contenu image (photo_hash.go). Ici :
+//   - quasi-doublon : distance de Hamming pHash ≤ threshold et dHash ≤ threshold + 4
+//     (copies réencodées, redimensionnées, exportées) ;
+//   - rafale : prises de vue consécutives du même appareil à moins de burst_gap secondes,
+//     visuellement proches (pHash ≤ burstMaxDistance).
+// POST /photos/duplicates/resolve garde une photo d'un groupe et met les autres à la corbeille.
+
+import (
+	"database/sql"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	dupDefaultThreshold = 6
+	// Au-delà de 7, le pré-filtre par octets identiques (8 bandes de 8 bits) n'est plus exhaustif.
+	dupMaxThreshold  = 7
+	dupDefaultBurst  = 2 * time.Second
+	burstMaxDistance = 20
+)
+
+// dupPhoto — vue minimale d'une photo pour le regroupement.
+type dupPhoto struct {
+	DHash, PHash uint64
+	TakenAt      time.Time // zéro si inconnue (pas de rafale possible)
+	Camera       string
+	Pixels       int64
+	Size         int64
+	CreatedAt    time.Time
+	ID           int
+}
+
+type dupGroup struct {
+	Kind    string // "duplicate" | "burst"
+	Members []int  // indices dans la liste d'entrée, meilleure photo en premier
+}
+
+func hamming(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+type unionFind []int
+
+func newUnionFind(n int) unionFind {
+	uf := make(unionFind, n)
+	for i := range uf {
+		uf[i] = i
+	}
+	return uf
+}
+
+func (uf unionFind) find(i int) int {
+	for uf[i] != i {
+		uf[i] = uf[uf[i]]
+		i = uf[i]
+	}
+	return i
+}
+
+func (uf unionFind) union(a, b int) {
+	if ra, rb := uf.find(a), uf.find(b); ra != rb {
+		uf[rb] = ra
+	}
+}
+
+// betterPhoto : meilleure définition, puis fichier le plus lourd (moins compressé), puis le plus ancien.
+func betterPhoto(a, b dupPhoto) bool {
+	if a.Pixels != b.Pixels {
+		return a.Pixels > b.Pixels
+	}
+	if a.Size != b.Size {
+		return a.Size > b.Size
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+// groupDuplicates regroupe quasi-doublons et rafales ; seuls les groupes d'au moins deux
+// photos sont renvoyés. Un groupe contenant un lien de rafale est de type "burst".
+func groupDuplicates(items []dupPhoto, threshold int, burstGap time.Duration) []dupGroup {
+	threshold = min(max(threshold, 0), dupMaxThreshold)
+	uf := newUnionFind(len(items))
+	// Deux empreintes à distance ≤ 7 ont au moins un octet (sur 8) identique : seules les
+	// photos partageant une bande sont comparées.
+	for band := 0; band < 8; band++ {
+		buckets := make(map[byte][]int)
+		for i, it := range items {
+			k := byte(it.PHash >> (8 * band))
+			buckets[k] = append(buckets[k], i)
+		}
+		for _, idx := range buckets {
+			for x := 0; x < len(idx); x++ {
+				for y := x + 1; y < len(idx); y++ {
+					a, b := items[idx[x]], items[idx[y]]
+					if uf.find(idx[x]) == uf.find(idx[y]) {
+						continue
+					}
+					if hamming(a.PHash, b.PHash) <= threshold && hamming(a.DHash, b.DHash) <= threshold+4 {
+						uf.union(idx[x], idx[y])
+					}
+				}
+			}
+		}
+	}
+	var burstEdges [][2]int
+	if burstGap > 0 {
+		dated := make([]int, 0, len(items))
+		for i, it := range items {
+			if !it.TakenAt.IsZero() {
+				dated = append(dated, i)
+			}
+		}
+		sort.Slice(dated, func(x, y int) bool { return items[dated[x]].TakenAt.Before(items[dated[y]].TakenAt) })
+		for k := 1; k < len(dated); k++ {
+			a, b := items[dated[k-1]], items[dated[k]]
+			if b.TakenAt.Sub(a.TakenAt) <= burstGap && a.Camera == b.Camera && hamming(a.PHash, b.PHash) <= burstMaxDistance {
+				uf.union(dated[k-1], dated[k])
+				burstEdges = append(burstEdges, [2]int{dated[k-1], dated[k]})
+			}
+		}
+	}
+	byRoot := make(map[int][]int)
+	for i := range items {
+		r := uf.find(i)
+		byRoot[r] = append(byRoot[r], i)
+	}
+	burstRoots := make(map[int]bool)
+	for _, e := range burstEdges {
+		burstRoots[uf.find(e[0])] = true
+	}
+	groups := make([]dupGroup, 0)
+	for root, members := range byRoot {
+		if len(members) < 2 {
+			continue
+		}
+		sort.Slice(members, func(x, y int) bool { return betterPhoto(items[members[x]], items[members[y]]) })
+		kind := "duplicate"
+		if burstRoots[root] {
+			kind = "burst"
+		}
+		groups = append(groups, dupGroup{Kind: kind, Members: members})
+	}
+	return groups
+}
+
+type duplicateItem struct {
+	DriveNodeRef
+	// Distance pHash à la photo conseillée (0 pour elle-même).
+	Distance int `json:"distance"`
+}
+
+type duplicateGroup struct {
+	Kind   string          `json:"kind"`
+	KeepID int             `json:"keep_id"`
+	Items  []duplicateItem `json:"items"`
+}
+
+type duplicatesPage struct {
+	Groups          []duplicateGroup `json:"groups"`
+	Threshold       int              `json:"threshold"`
+	BurstGapSeconds float64          `json:"burst_gap_seconds"`
+	Limit           int              `json:"limit"`
+	Offset          int              `json:"offset"`
+	HasMore         bool             `json:"has_more"`
+}
+
+// listDuplicates — GET /photos/duplicates[?threshold=6&burst_gap=2&kind=duplicate|burst&limit=&offset=]
+// Groupes les plus récents d'abord ; la première photo de chaque groupe est celle à garder.
+func (h *Handler) listDuplicates(c *gin.Context) {
+	threshold, err := strconv.Atoi(c.DefaultQuery("threshold", strconv.Itoa(dupDefaultThreshold)))
+	if err != nil || threshold < 0 || threshold > dupMaxThreshold {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be between 0 and " + strconv.Itoa(dupMaxThreshold)})
+		return
+	}
+	burstGap := dupDefaultBurst
+	if v := c.Query("burst_gap"); v != "" {
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil || secs < 0 || secs > 60 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "burst_gap must be between 0 and 60 seconds"})
+			return
+		}
+		burstGap = time.Duration(secs * float64(time.Second))
+	}
+	kind := c.Query("kind")
+	if kind != "" && kind != "duplicate" && kind != "burst" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be duplicate or burst"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 {
+		limit = 50
+	}
+	limit = min(limit, 200)
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	page := duplicatesPage{Groups: []duplicateGroup{}, Threshold: threshold, BurstGapSeconds: burstGap.Seconds(), Limit: limit, Offset: offset}
+	if h.db == nil {
+		c.JSON(http.StatusOK, page)
+		return
+	}
+	rows, err := h.dbex(c.Request.Context()).Query(`
+		SELECT` + nodeRefColumnsSQL + `, ph.dhash, ph.phash, taken_at, created_at
+		FROM (SELECT * ` + timelineFromSQL + ` AND NOT ` + videoCondSQL + `) drive_nodes
+		CROSS JOIN LATERAL (
+			SELECT dhash, phash FROM drive_photo_hashes h
+			WHERE h.content_hash = drive_nodes.content_hash AND h.phash IS NOT NULL AND h.dhash IS NOT NULL
+		) ph
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	var refs []DriveNodeRef
+	var items []dupPhoto
+	for rows.Next() {
+		var d, p int64
+		var takenAt sql.NullTime
+		var createdAt time.Time
+		n, err := scanNodeRef(rows, &d, &p, &takenAt, &createdAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		refs = append(refs, n)
+		items = append(items, dupPhoto{
+			DHash: uint64(d), PHash: uint64(p), TakenAt: takenAt.Time, Camera: n.CameraMake + "/" + n.CameraModel,
+			Pixels: int64(n.Width) * int64(n.Height), Size: n.Size, CreatedAt: createdAt, ID: n.ID,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	groups := groupDuplicates(items, threshold, burstGap)
+	// Plus récent d'abord (date de prise de vue, sinon d'ajout, de la photo conseillée).
+	when := func(i int) time.Time {
+		if !items[i].TakenAt.IsZero() {
+			return items[i].TakenAt
+		}
+		return items[i].CreatedAt
+	}
+	sort.Slice(groups, func(x, y int) bool {
+		a, b := when(groups[x].Members[0]), when(groups[y].Members[0])
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return items[groups[x].Members[0]].ID > items[groups[y].Members[0]].ID
+	})
+	for _, g := range groups {
+		if kind != "" && g.Kind != kind {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if len(page.Groups) == limit {
+			page.HasMore = true
+			break
+		}
+		keep := items[g.Members[0]]
+		out := duplicateGroup{Kind: g.Kind, KeepID: keep.ID, Items: make([]duplicateItem, 0, len(g.Members))}
+		for _, m := range g.Members {
+			out.Items = append(out.Items, duplicateItem{DriveNodeRef: refs[m], Distance: hamming(keep.PHash, items[m].PHash)})
+		}
+		page.Groups = append(page.Groups, out)
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// resolveDuplicates — POST /photos/duplicates/resolve {"ids": [...], "keep_id": 12}
+// Garde keep_id (par défaut la meilleure photo : définition, poids, ancienneté) et met les
+// autres photos du groupe à la corbeille, restaurables depuis Drive.
+func (h *Handler) resolveDuplicates(c *gin.Context) {
+	var body struct {
+		IDs    []int `json:"ids"`
+		KeepID int   `json:"keep_id"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || len(body.IDs) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids must list at least two photos"})
+		return
+	}
+	if len(body.IDs) > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max 500 ids per request"})
+		return
+	}
+	ids := make([]int64, 0, len(body.IDs))
+	keepListed := body.KeepID == 0
+	for _, id := range body.IDs {
+		if id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+		keepListed = keepListed || id == body.KeepID
+		ids = append(ids, int64(id))
+	}
+	if !keepListed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "keep_id must be one of ids"})
+		return
+	}
+	if h.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "database not configured"})
+		return
+	}
+	tx, err := h.dbex(c.Request.Context()).Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	var keepID int
+	err = tx.QueryRow(`
+		SELECT id FROM drive_nodes
+		WHERE id = ANY($1) AND ($2 = 0 OR id = $2)
+		  AND user_id = current_setting('app.current_user_id', true)::INTEGER
+		  AND deleted_at IS NULL AND photo_locked_at IS NULL AND is_folder = false
+		ORDER BY COALESCE(media_width, 0)::BIGINT * COALESCE(media_height, 0) DESC, size DESC, created_at, id
+		LIMIT 1
+	`, pq.Array(ids), body.KeepID).Scan(&keepID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "photo to keep not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res, err := tx.Exec(`
+		UPDATE drive_nodes SET deleted_at = CURRENT_TIMESTAMP
+		WHERE id = ANY($1) AND id <> $2
+		  AND user_id = current_setting('app.current_user_id', true)::INTEGER
+		  AND deleted_at IS NULL AND photo_locked_at IS NULL AND is_folder = false
+	`, pq.Array(ids), keepID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	trashed, _ := res.RowsAffected()
+	c.JSON(http.StatusOK, gin.H{"kept_id": keepID, "trashed": trashed})
+}
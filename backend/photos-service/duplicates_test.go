@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupDuplicates(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	const base = 0x9F3A_55C1_0E7B_D248
+	items := []dupPhoto{
+		// 0, 1 : même image, la copie 1 est réduite (moins de pixels) et a 2 bits d'écart.
+		{ID: 10, PHash: base, DHash: 0xFF00, Pixels: 12_000_000, Size: 4 << 20, CreatedAt: t0},
+		{ID: 11, PHash: base ^ 0b101, DHash: 0xFF01, Pixels: 2_000_000, Size: 900 << 10, CreatedAt: t0.Add(time.Hour)},
+		// 2, 3 : rafale (1 s d'écart, même appareil, images différentes mais proches).
+		{ID: 20, PHash: 0x0123_4567_89AB_CDEF, DHash: 1, TakenAt: t0, Camera: "Apple/iPhone 15", Pixels: 12_000_000, Size: 3 << 20},
+		{ID: 21, PHash: 0x0123_4567_89AB_CDEF ^ 0xFFF0, DHash: 2, TakenAt: t0.Add(time.Second), Camera: "Apple/iPhone 15", Pixels: 12_000_000, Size: 4 << 20},
+		// 4 : même instant mais autre appareil et image sans rapport.
+		{ID: 30, PHash: ^uint64(0x0123_4567_89AB_CDEF), DHash: 3, TakenAt: t0.Add(time.Second), Camera: "Canon/EOS R6"},
+	}
+	groups := groupDuplicates(items, dupDefaultThreshold, dupDefaultBurst)
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups: %+v", len(groups), groups)
+	}
+	byKind := map[string]dupGroup{}
+	for _, g := range groups {
+		byKind[g.Kind] = g
+	}
+	if g := byKind["duplicate"]; len(g.Members) != 2 || items[g.Members[0]].ID != 10 {
+		t.Errorf("duplicate group = %+v (best must be the full-resolution original)", g)
+	}
+	if g := byKind["burst"]; len(g.Members) != 2 || items[g.Members[0]].ID != 21 {
+		t.Errorf("burst group = %+v (best must be the heavier file at equal resolution)", g)
+	}
+	if got := groupDuplicates(items, dupDefaultThreshold, 0); len(got) != 1 || got[0].Kind != "duplicate" {
+		t.Errorf("burst_gap=0 must disable bursts, got %+v", got)
+	}
+	if got := groupDuplicates(items, 1, 0); len(got) != 0 {
+		t.Errorf("threshold 1 must not match a 2-bit distance, got %+v", got)
+	}
+}
+
+func TestDuplicatesRequireAuth(t *testing.T) {
+	r := setupRouter(nil)
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/photos/duplicates"},
+		{http.MethodPost, "/photos/duplicates/resolve"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without X-User-ID: got %d", tc.method, tc.path, w.Code)
+		}
+	}
+}
+
+func TestResolveDuplicatesValidation(t *testing.T) {
+	r := setupRouter(nil)
+	for _, tc := range []struct {
+		body string
+		want int
+	}{
+		{`{"ids":[1]}`, http.StatusBadRequest},
+		{`{"ids":[1,2],"keep_id":3}`, http.StatusBadRequest},
+		{`{"ids":[1,-2]}`, http.StatusBadRequest},
+		{`{"ids":[1,2],"keep_id":2}`, http.StatusServiceUnavailable},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/photos/duplicates/resolve", strings.NewReader(tc.body))
+		req.Header.Set("X-User-ID", "1")
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("resolve %s: got %d, want %d", tc.body, w.Code, tc.want)
+		}
+	}
+}
+
+func TestListDuplicatesRejectsHighThreshold(t *testing.T) {
+	r := setupRouter(nil)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/photos/duplicates?threshold=12", nil)
+	req.Header.Set("X-User-ID", "1")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("threshold above %d: got %d", dupMaxThreshold, w.Code)
+	}
+}
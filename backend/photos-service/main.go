@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -14,7 +16,7 @@ import (
 
 const defaultPort = "8057"
 
-// DriveNodeRef — même forme JSON que drive-service pour les fichiers image (timeline).
+// DriveNodeRef — même forme JSON que drive-service pour les fichiers image et vidéo (timeline).
 type DriveNodeRef struct {
 	ID        int     `json:"id"`
 	TenantID  int     `json:"tenant_id"`
@@ -27,13 +29,64 @@ type DriveNodeRef struct {
 	TakenAt   string  `json:"taken_at,omitempty"`
 	CreatedAt string  `json:"created_at"`
 	UpdatedAt string  `json:"updated_at"`
+	// "image" | "video" ; métadonnées vidéo renseignées par drive-service (atomes MP4 / QuickTime).
+	MediaKind  string `json:"media_kind,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Streamable bool   `json:"streamable,omitempty"`
+	// Position EXIF et lieu (géocodage inverse hors ligne par drive-service), appareil.
+	Latitude     *float64 `json:"latitude,omitempty"`
+	Longitude    *float64 `json:"longitude,omitempty"`
+	PlaceName    string   `json:"place_name,omitempty"`
+	PlaceCountry string   `json:"place_country,omitempty"`
+	CameraMake   string   `json:"camera_make,omitempty"`
+	CameraModel  string   `json:"camera_model,omitempty"`
+}
+
+// videoCondSQL — fichiers vidéo de la timeline (même règle que drive-service).
+const videoCondSQL = `(LOWER(COALESCE(mime_type, '')) LIKE 'video/%' OR LOWER(name) ~ '\.(mp4|m4v|mov|3gp|webm)$')`
+
+// nodeRefColumnsSQL — colonnes lues par scanNodeRef (timeline, doublons).
+const nodeRefColumnsSQL = `
+		       id, tenant_id, user_id, parent_id, name, is_folder, size, mime_type,
+		       COALESCE(taken_at::text, ''), COALESCE(taken_at, created_at)::text, COALESCE(updated_at::text, ''),
+		       CASE WHEN ` + videoCondSQL + ` THEN 'video' ELSE 'image' END,
+		       COALESCE(duration_ms, 0), COALESCE(media_width, 0), COALESCE(media_height, 0), COALESCE(video_faststart, false),
+		       gps_lat, gps_lon, COALESCE(place_name, ''), COALESCE(place_country, ''),
+		       COALESCE(camera_make, ''), COALESCE(camera_model, '')`
+
+// scanNodeRef lit une ligne nodeRefColumnsSQL, suivie des colonnes extra éventuelles.
+func scanNodeRef(rows *sql.Rows, extra ...any) (DriveNodeRef, error) {
+	var n DriveNodeRef
+	var pid sql.NullInt64
+	var mime sql.NullString
+	var lat, lon sql.NullFloat64
+	dest := append([]any{&n.ID, &n.TenantID, &n.UserID, &pid, &n.Name, &n.IsFolder, &n.Size, &mime, &n.TakenAt, &n.CreatedAt, &n.UpdatedAt,
+		&n.MediaKind, &n.DurationMs, &n.Width, &n.Height, &n.Streamable,
+		&lat, &lon, &n.PlaceName, &n.PlaceCountry, &n.CameraMake, &n.CameraModel}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return n, err
+	}
+	if lat.Valid && lon.Valid {
+		n.Latitude, n.Longitude = &lat.Float64, &lon.Float64
+	}
+	if pid.Valid {
+		p := int(pid.Int64)
+		n.ParentID = &p
+	}
+	if mime.Valid {
+		n.MimeType = &mime.String
+	}
+	return n, nil
 }
 
 type timelinePage struct {
-	Items   []DriveNodeRef `json:"items"`
-	Limit   int            `json:"limit"`
-	Offset  int            `json:"offset"`
-	HasMore bool           `json:"has_more"`
+	Items      []DriveNodeRef   `json:"items"`
+	Limit      int              `json:"limit"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	HasMore    bool             `json:"has_more"`
+	Buckets    []timelineBucket `json:"buckets,omitempty"`
 }
 
 func setupRouter(db *sql.DB) *gin.Engine {
@@ -48,6 +101,10 @@ func setupRouter(db *sql.DB) *gin.Engine {
 	})
 	r.Use(h.requireUserID)
 	r.GET("/photos/timeline", h.listTimeline)
+	r.GET("/photos/places", h.listPlaces)
+	r.GET("/photos/cameras", h.listCameras)
+	r.GET("/photos/duplicates", h.listDuplicates)
+	r.POST("/photos/duplicates/resolve", h.resolveDuplicates)
 	return r
 }
 
@@ -112,73 +169,104 @@ func (h *Handler) requireUserID(c *gin.Context) {
 	c.Next()
 }
 
+// listTimeline — GET /photos/timeline?limit=48[&cursor=][&buckets=1] (+ filtres photoFilters)
+// Pagination par curseur : next_cursor à renvoyer tel quel (avec les mêmes filtres) pour la page
+// suivante. La première page (sans curseur) ou ?buckets=1 inclut le résumé par mois.
 func (h *Handler) listTimeline(c *gin.Context) {
-	limitStr := c.DefaultQuery("limit", "48")
-	offsetStr := c.DefaultQuery("offset", "0")
-	limit, err := strconv.Atoi(limitStr)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "48"))
 	if err != nil || limit < 1 {
 		limit = 48
 	}
 	if limit > 200 {
 		limit = 200
 	}
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil || offset < 0 {
-		offset = 0
+	var cursor *timelineCursor
+	if raw := c.Query("cursor"); raw != "" {
+		cur, err := parseTimelineCursor(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		cursor = &cur
 	}
+	withBuckets := cursor == nil || c.Query("buckets") == "1"
 	if h.db == nil {
-		c.JSON(http.StatusOK, timelinePage{Items: []DriveNodeRef{}, Limit: limit, Offset: offset, HasMore: false})
+		page := timelinePage{Items: []DriveNodeRef{}, Limit: limit}
+		if withBuckets {
+			page.Buckets = []timelineBucket{}
+		}
+		c.JSON(http.StatusOK, page)
+		return
+	}
+	args := sqlArgs{}
+	filters, err := photoFilters(c.Query, &args)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	ctx := c.Request.Context()
-	fetch := limit + 1
-	rows, err := h.dbex(ctx).Query(`
-		SELECT id, tenant_id, user_id, parent_id, name, is_folder, size, mime_type,
-		       COALESCE(taken_at::text, ''), COALESCE(taken_at, created_at)::text, COALESCE(updated_at::text, '')
-		FROM drive_nodes
-		WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
-		  AND deleted_at IS NULL
-		  AND photo_archived_at IS NULL
-		  AND photo_locked_at IS NULL
-		  AND is_folder = false
-		  AND LOWER(name) !~ '\.pdf$'
-		  AND LOWER(COALESCE(mime_type, '')) NOT LIKE 'application/pdf%'
-		  AND (
-			(LOWER(COALESCE(mime_type, '')) LIKE 'image/%' AND LOWER(COALESCE(mime_type, '')) NOT LIKE 'image/pdf%')
-			OR LOWER(name) ~ '\.(jpg|jpeg|png|gif|webp|bmp|heic|heif|avif|tiff|tif)$'
-		  )
-		ORDER BY COALESCE(taken_at, created_at) DESC NULLS LAST, id DESC
-		LIMIT $1 OFFSET $2
-	`, fetch, offset)
+	page := timelinePage{Items: make([]DriveNodeRef, 0), Limit: limit}
+	if withBuckets {
+		if page.Buckets, err = h.timelineBuckets(ctx, filters, args); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	keyset := ""
+	if cursor != nil {
+		keyset = ` AND (` + timelineKeySQL + `, id) < (` + args.add(cursor.At) + `, ` + args.add(cursor.ID) + `)`
+	}
+	// Demander une ligne de plus pour savoir s'il reste des pages (évite COUNT(*)).
+	fetch := args.add(limit + 1)
+	rows, err := h.dbex(ctx).Query(`SELECT `+nodeRefColumnsSQL+`, `+timelineKeySQL+timelineFromSQL+filters+keyset+`
+		ORDER BY `+timelineKeySQL+` DESC, id DESC
+		LIMIT `+fetch, args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	defer rows.Close()
-	list := make([]DriveNodeRef, 0)
+	var last timelineCursor
 	for rows.Next() {
-		var n DriveNodeRef
-		var pid sql.NullInt64
-		var mime sql.NullString
-		var takenAt, uat string
-		if err := rows.Scan(&n.ID, &n.TenantID, &n.UserID, &pid, &n.Name, &n.IsFolder, &n.Size, &mime, &takenAt, &n.CreatedAt, &uat); err != nil {
+		var key time.Time
+		n, err := scanNodeRef(rows, &key)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		if pid.Valid {
-			p := int(pid.Int64)
-			n.ParentID = &p
+		if len(page.Items) == limit {
+			page.HasMore = true
+			break
 		}
-		if mime.Valid {
-			n.MimeType = &mime.String
-		}
-		n.TakenAt = takenAt
-		n.UpdatedAt = uat
-		list = append(list, n)
+		page.Items = append(page.Items, n)
+		last = timelineCursor{At: key, ID: n.ID}
+	}
+	if page.HasMore {
+		page.NextCursor = encodeCursor(last)
 	}
-	hasMore := len(list) > limit
-	if hasMore {
-		list = list[:limit]
+	c.JSON(http.StatusOK, page)
+}
+
+// timelineBuckets compte les médias par mois (UTC), du plus récent au plus ancien.
+func (h *Handler) timelineBuckets(ctx context.Context, filters string, args sqlArgs) ([]timelineBucket, error) {
+	rows, err := h.dbex(ctx).Query(`
+		SELECT EXTRACT(YEAR FROM k)::int, EXTRACT(MONTH FROM k)::int, COUNT(*)
+		FROM (SELECT `+timelineKeySQL+` AT TIME ZONE 'UTC' AS k `+timelineFromSQL+filters+`) t
+		GROUP BY 1, 2
+		ORDER BY 1 DESC, 2 DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]timelineBucket, 0)
+	for rows.Next() {
+		var b timelineBucket
+		if err := rows.Scan(&b.Year, &b.Month, &b.Count); err != nil {
+			return nil, err
+		}
+		b.Cursor = monthStartCursor(b.Year, b.Month)
+		list = append(list, b)
 	}
-	c.JSON(http.StatusOK, timelinePage{Items: list, Limit: limit, Offset: offset, HasMore: hasMore})
+	return list, rows.Err()
 }
@@ -0,0 +1,259 @@
+package main
+
+// places.go — lieux (carte) et filtres EXIF de la timeline.
+//
+// Position, lieu et appareil sont extraits par drive-service (photo_meta.go, migration 55) :
+// ce service ne fait que regrouper. GET /photos/places regroupe par lieu connu (défaut) ou par
+// maille de carte (?group=grid&zoom=), GET /photos/cameras liste les appareils pour les filtres.
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// timelineFromSQL — médias visibles dans la timeline de l'utilisateur courant.
+const timelineFromSQL = `
+		FROM drive_nodes
+		WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
+		  AND deleted_at IS NULL
+		  AND photo_archived_at IS NULL
+		  AND photo_locked_at IS NULL
+		  AND is_folder = false
+		  AND LOWER(name) !~ '\.pdf$'
+		  AND LOWER(COALESCE(mime_type, '')) NOT LIKE 'application/pdf%'
+		  AND (
+			(LOWER(COALESCE(mime_type, '')) LIKE 'image/%' AND LOWER(COALESCE(mime_type, '')) NOT LIKE 'image/pdf%')
+			OR LOWER(name) ~ '\.(jpg|jpeg|png|gif|webp|bmp|heic|heif|avif|tiff|tif)$'
+			OR ` + videoCondSQL + `
+		  )`
+
+// sqlArgs numérote les paramètres d'une requête construite dynamiquement.
+type sqlArgs []any
+
+func (a *sqlArgs) add(v any) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// parseFilterDate accepte RFC3339 ou AAAA-MM-JJ ; endOfDay décale une date seule à la fin du jour.
+func parseFilterDate(raw string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return t, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// parseBBox lit ?bbox=sud,ouest,nord,est (degrés) ; ouest > est traverse l'antiméridien.
+func parseBBox(raw string) (south, west, north, east float64, err error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) != 4 {
+		return 0, 0, 0, 0, fmt.Errorf("invalid bbox")
+	}
+	v := make([]float64, 4)
+	for i, p := range parts {
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64); err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("invalid bbox")
+		}
+	}
+	south, west, north, east = v[0], v[1], v[2], v[3]
+	if south > north || math.Abs(south) > 90 || math.Abs(north) > 90 || math.Abs(west) > 180 || math.Abs(east) > 180 {
+		return 0, 0, 0, 0, fmt.Errorf("invalid bbox")
+	}
+	return south, west, north, east, nil
+}
+
+// photoFilters lit camera / make / from / to / place / country / has_location / bbox et
+// renvoie les clauses SQL correspondantes (préfixées par AND).
+func photoFilters(get func(string) string, args *sqlArgs) (string, error) {
+	var b strings.Builder
+	if v := strings.TrimSpace(get("camera")); v != "" {
+		b.WriteString(" AND LOWER(camera_model) = LOWER(" + args.add(v) + ")")
+	}
+	if v := strings.TrimSpace(get("make")); v != "" {
+		b.WriteString(" AND LOWER(camera_make) = LOWER(" + args.add(v) + ")")
+	}
+	for _, f := range []struct {
+		param, op string
+		end       bool
+	}{{"from", ">=", false}, {"to", "<=", true}} {
+		raw := strings.TrimSpace(get(f.param))
+		if raw == "" {
+			continue
+		}
+		t, err := parseFilterDate(raw, f.end)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s", f.param)
+		}
+		b.WriteString(" AND COALESCE(taken_at, created_at) " + f.op + " " + args.add(t))
+	}
+	if v := strings.TrimSpace(get("place")); v != "" {
+		b.WriteString(" AND place_name = " + args.add(v))
+	}
+	if v := strings.TrimSpace(get("country")); v != "" {
+		b.WriteString(" AND place_country = " + args.add(strings.ToUpper(v)))
+	}
+	switch get("has_location") {
+	case "1", "true":
+		b.WriteString(" AND gps_lat IS NOT NULL")
+	case "0", "false":
+		b.WriteString(" AND gps_lat IS NULL")
+	}
+	if raw := strings.TrimSpace(get("bbox")); raw != "" {
+		s, w, n, e, err := parseBBox(raw)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(" AND gps_lat BETWEEN " + args.add(s) + " AND " + args.add(n))
+		if w <= e {
+			b.WriteString(" AND gps_lon BETWEEN " + args.add(w) + " AND " + args.add(e))
+		} else {
+			b.WriteString(" AND (gps_lon >= " + args.add(w) + " OR gps_lon <= " + args.add(e) + ")")
+		}
+	}
+	return b.String(), nil
+}
+
+// gridCellDegrees — taille de maille pour un niveau de zoom de carte (0 = monde entier).
+func gridCellDegrees(zoom int) float64 {
+	if zoom < 0 {
+		zoom = 0
+	}
+	if zoom > 18 {
+		zoom = 18
+	}
+	return 360 / math.Pow(2, float64(zoom))
+}
+
+type placeCluster struct {
+	Key          string  `json:"key"`
+	Name         string  `json:"name,omitempty"`
+	Admin        string  `json:"admin,omitempty"`
+	Country      string  `json:"country,omitempty"`
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	Count        int     `json:"count"`
+	CoverNodeID  int     `json:"cover_node_id"`
+	FirstTakenAt string  `json:"first_taken_at"`
+	LastTakenAt  string  `json:"last_taken_at"`
+}
+
+// listPlaces — GET /photos/places[?group=place|grid&zoom=3&bbox=s,w,n,e&from=&to=&camera=]
+// Regroupe les médias géolocalisés : par lieu (nom + région + pays, centroïde des positions)
+// ou par maille de carte (lieu le plus fréquent de la maille comme libellé).
+func (h *Handler) listPlaces(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusOK, []placeCluster{})
+		return
+	}
+	args := sqlArgs{}
+	filters, err := photoFilters(c.Query, &args)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var keySQL, nameSQL, adminSQL, countrySQL, groupSQL string
+	switch c.DefaultQuery("group", "place") {
+	case "place":
+		// Positions sans lieu connu (en mer, zone isolée) : regroupées au degré près.
+		keySQL = `COALESCE(place_country, '') || '/' || COALESCE(place_admin, '') || '/' ||
+			COALESCE(place_name, ROUND(gps_lat::numeric)::text || ',' || ROUND(gps_lon::numeric)::text)`
+		nameSQL, adminSQL, countrySQL = `MAX(place_name)`, `MAX(place_admin)`, `MAX(place_country)`
+		groupSQL = `place_country, place_admin, place_name,
+			CASE WHEN place_name IS NULL THEN ROUND(gps_lat::numeric) END,
+			CASE WHEN place_name IS NULL THEN ROUND(gps_lon::numeric) END`
+	case "grid":
+		zoom, err := strconv.Atoi(c.DefaultQuery("zoom", "3"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid zoom"})
+			return
+		}
+		cell := args.add(gridCellDegrees(zoom))
+		keySQL = `FLOOR(gps_lat / ` + cell + `)::text || ':' || FLOOR(gps_lon / ` + cell + `)::text`
+		nameSQL = `mode() WITHIN GROUP (ORDER BY place_name)`
+		adminSQL = `mode() WITHIN GROUP (ORDER BY place_admin)`
+		countrySQL = `mode() WITHIN GROUP (ORDER BY place_country)`
+		groupSQL = `FLOOR(gps_lat / ` + cell + `), FLOOR(gps_lon / ` + cell + `)`
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group must be place or grid"})
+		return
+	}
+	rows, err := h.dbex(c.Request.Context()).Query(`
+		SELECT MIN(`+keySQL+`), COALESCE(`+nameSQL+`, ''), COALESCE(`+adminSQL+`, ''), COALESCE(`+countrySQL+`, ''),
+		       AVG(gps_lat), AVG(gps_lon), COUNT(*),
+		       (array_agg(id ORDER BY COALESCE(taken_at, created_at) DESC, id DESC))[1],
+		       MIN(COALESCE(taken_at, created_at))::text, MAX(COALESCE(taken_at, created_at))::text
+		`+timelineFromSQL+`
+		  AND gps_lat IS NOT NULL AND gps_lon IS NOT NULL
+		`+filters+`
+		GROUP BY `+groupSQL+`
+		ORDER BY COUNT(*) DESC, MAX(COALESCE(taken_at, created_at)) DESC
+		LIMIT 2000
+	`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	list := make([]placeCluster, 0)
+	for rows.Next() {
+		var p placeCluster
+		if err := rows.Scan(&p.Key, &p.Name, &p.Admin, &p.Country, &p.Latitude, &p.Longitude, &p.Count, &p.CoverNodeID, &p.FirstTakenAt, &p.LastTakenAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list = append(list, p)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+type cameraCount struct {
+	Make  string `json:"make"`
+	Model string `json:"model"`
+	Count int    `json:"count"`
+}
+
+// listCameras — GET /photos/cameras : appareils présents dans la timeline (filtre ?camera=).
+func (h *Handler) listCameras(c *gin.Context) {
+	if h.db == nil {
+		c.JSON(http.StatusOK, []cameraCount{})
+		return
+	}
+	rows, err := h.dbex(c.Request.Context()).Query(`
+		SELECT COALESCE(MAX(camera_make), ''), camera_model, COUNT(*)
+		` + timelineFromSQL + `
+		  AND camera_model IS NOT NULL
+		GROUP BY camera_model
+		ORDER BY COUNT(*) DESC, camera_model
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	list := make([]cameraCount, 0)
+	for rows.Next() {
+		var cc cameraCount
+		var model sql.NullString
+		if err := rows.Scan(&cc.Make, &model, &cc.Count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		cc.Model = model.String
+		list = append(list, cc)
+	}
+	c.JSON(http.StatusOK, list)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseBBox(t *testing.T) {
+	s, w, n, e, err := parseBBox("43.1, 1.5, 49.2, 7.8")
+	if err != nil || s != 43.1 || w != 1.5 || n != 49.2 || e != 7.8 {
+		t.Fatalf("got %v %v %v %v %v", s, w, n, e, err)
+	}
+	for _, raw := range []string{"", "1,2,3", "a,b,c,d", "50,0,40,10", "-95,0,10,10", "0,-190,10,10"} {
+		if _, _, _, _, err := parseBBox(raw); err == nil {
+			t.Errorf("parseBBox(%q) must fail", raw)
+		}
+	}
+}
+
+func TestPhotoFilters(t *testing.T) {
+	q := map[string]string{
+		"camera":       "Pixel 8",
+		"from":         "2024-01-01",
+		"to":           "2024-01-31",
+		"country":      "fr",
+		"has_location": "1",
+		"bbox":         "-20,170,-10,-170",
+	}
+	args := sqlArgs{}
+	sql, err := photoFilters(func(k string) string { return q[k] }, &args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"LOWER(camera_model) = LOWER($1)", ">= $2", "<= $3", "place_country = $4", "gps_lat IS NOT NULL", "(gps_lon >= $7 OR gps_lon <= $8)"} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("filters %q missing %q", sql, want)
+		}
+	}
+	if len(args) != 8 || args[3] != "FR" {
+		t.Fatalf("args = %v", args)
+	}
+	if _, err := photoFilters(func(k string) string {
+		if k == "from" {
+			return "hier"
+		}
+		return ""
+	}, &sqlArgs{}); err == nil {
+		t.Fatal("invalid date must be rejected")
+	}
+}
+
+func TestGridCellDegrees(t *testing.T) {
+	if gridCellDegrees(0) != 360 || gridCellDegrees(-3) != 360 {
+		t.Fatal("zoom 0 must cover the world")
+	}
+	if gridCellDegrees(3) != 45 || gridCellDegrees(40) != gridCellDegrees(18) {
+		t.Fatal("unexpected cell size")
+	}
+}
+
+func TestPlacesAndCamerasRequireAuth(t *testing.T) {
+	r := setupRouter(nil)
+	for _, path := range []string{"/photos/places", "/photos/cameras"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without X-User-ID: got %d", path, w.Code)
+		}
+	}
+}
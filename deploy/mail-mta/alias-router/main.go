@@ -178,6 +178,13 @@ func handleSMTP(conn net.Conn, cfg config) error {
 				return err
 			}
 			if err := relayToResolvedRecipients(cfg, mailFrom, msg, resolutions); err != nil {
+				var rejectErr *sieveRejectError
+				if errors.As(err, &rejectErr) {
+					if err := writeLine("550 5.7.1 %s", sanitizeHeaderValue(strings.TrimSpace(rejectErr.Reason))); err != nil {
+						return err
+					}
+					continue
+				}
 				log.Printf("relay failed: %v", err)
 				if err := writeLine("451 4.3.0 relay failed"); err != nil {
 					return err
@@ -283,6 +290,8 @@ func resolveAlias(ctx context.Context, cfg config, aliasEmail string) (aliasReso
 }
 
 func relayToResolvedRecipients(cfg config, mailFrom string, msg []byte, resolutions map[string]aliasResolution) error {
+	rejected := 0
+	var rejectReason string
 	for alias, resolved := range resolutions {
 		envelopeFrom := cfg.RelayFrom
 		if envelopeFrom == "" {
@@ -292,20 +301,220 @@ func relayToResolvedRecipients(cfg config, mailFrom string, msg []byte, resoluti
 			envelopeFrom = alias
 		}
 
-		withHeaders := prependAliasHeaders(alias, msg)
-		addr := net.JoinHostPort(cfg.RelayHost, cfg.RelayPort)
-		var auth smtp.Auth
-		if cfg.RelayUsername != "" || cfg.RelayPassword != "" {
-			auth = smtp.PlainAuth("", cfg.RelayUsername, cfg.RelayPassword, cfg.RelayHost)
+		// Sieve à la livraison : en cas d'erreur, keep implicite (le message n'est jamais perdu).
+		outcome, err := evaluateSieve(context.Background(), cfg, resolved.DeliverTo, mailFrom, msg)
+		if err != nil {
+			log.Printf("sieve %s: %v (keep)", resolved.DeliverTo, err)
+			outcome = sieveOutcome{Keep: true}
+		}
+		if outcome.Reject != "" {
+			rejected++
+			rejectReason = outcome.Reject
+			log.Printf("sieve reject alias %s -> %s", alias, resolved.DeliverTo)
+			continue
+		}
+		if outcome.Discard {
+			log.Printf("sieve discard alias %s -> %s", alias, resolved.DeliverTo)
+			continue
+		}
+
+		withHeaders := signDKIM(context.Background(), cfg, alias, prependAliasHeaders(alias, msg))
+		for _, target := range outcome.Redirect {
+			if err := relaySend(cfg, envelopeFrom, target, withHeaders); err != nil {
+				return fmt.Errorf("%s -> redirect %s: %w", alias, target, err)
+			}
+			log.Printf("sieve redirect alias %s -> %s", alias, target)
+		}
+		if !outcome.Keep && len(outcome.FileInto) == 0 {
+			continue
 		}
-		if err := smtp.SendMail(addr, auth, envelopeFrom, []string{resolved.DeliverTo}, withHeaders); err != nil {
+		if err := relaySend(cfg, envelopeFrom, resolved.DeliverTo, prependSieveHeaders(outcome, withHeaders)); err != nil {
 			return fmt.Errorf("%s -> %s: %w", alias, resolved.DeliverTo, err)
 		}
 		log.Printf("delivered alias %s -> %s (account_id=%d)", alias, resolved.DeliverTo, resolved.AccountID)
+		if resolved.AccountID > 0 && !fileIntoJunk(outcome) {
+			go notifyVacation(context.Background(), cfg, resolved.AccountID, alias, mailFrom, msg)
+		}
+	}
+	if rejected > 0 && rejected == len(resolutions) {
+		return &sieveRejectError{Reason: rejectReason}
 	}
 	return nil
 }
 
+func relaySend(cfg config, envelopeFrom, rcpt string, data []byte) error {
+	addr := net.JoinHostPort(cfg.RelayHost, cfg.RelayPort)
+	var auth smtp.Auth
+	if cfg.RelayUsername != "" || cfg.RelayPassword != "" {
+		auth = smtp.PlainAuth("", cfg.RelayUsername, cfg.RelayPassword, cfg.RelayHost)
+	}
+	return smtp.SendMail(addr, auth, envelopeFrom, []string{rcpt}, data)
+}
+
+// sieveOutcome : réponse de /mail/internal/sieve/evaluate (script actif du destinataire).
+type sieveOutcome struct {
+	Script   bool     `json:"script"`
+	Keep     bool     `json:"keep"`
+	FileInto []string `json:"fileinto"`
+	Redirect []string `json:"redirect"`
+	Discard  bool     `json:"discard"`
+	Reject   string   `json:"reject"`
+	Flags    []string `json:"flags"`
+}
+
+// sieveRejectError : tous les destinataires ont rejeté le message (réponse SMTP 550).
+type sieveRejectError struct {
+	Reason string
+}
+
+func (e *sieveRejectError) Error() string { return "sieve reject: " + e.Reason }
+
+func evaluateSieve(ctx context.Context, cfg config, recipient, envelopeFrom string, msg []byte) (sieveOutcome, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]any{
+		"recipient":     recipient,
+		"envelope_from": envelopeFrom,
+		"headers":       string(headerBlock(msg)),
+		"size":          len(msg),
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.MailDirectoryURL+"/mail/internal/sieve/evaluate", bytes.NewReader(body))
+	if err != nil {
+		return sieveOutcome{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-MTA-Internal-Token", cfg.InternalToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return sieveOutcome{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return sieveOutcome{}, fmt.Errorf("sieve/evaluate status %d", resp.StatusCode)
+	}
+	var out sieveOutcome
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return sieveOutcome{}, err
+	}
+	return out, nil
+}
+
+// headerBlock : en-têtes du message (jusqu'à la première ligne vide incluse).
+func headerBlock(msg []byte) []byte {
+	if idx := bytes.Index(msg, []byte("\r\n\r\n")); idx >= 0 {
+		return msg[:idx+2]
+	}
+	if idx := bytes.Index(msg, []byte("\n\n")); idx >= 0 {
+		return msg[:idx+1]
+	}
+	return msg
+}
+
+// fileIntoJunk : pas de réponse d'absence au courrier classé indésirable ou corbeille par Sieve.
+func fileIntoJunk(outcome sieveOutcome) bool {
+	for _, f := range outcome.FileInto {
+		switch strings.ToLower(strings.TrimSpace(f)) {
+		case "spam", "junk", "trash", "inbox.spam", "inbox.junk", "inbox.trash":
+			return true
+		}
+	}
+	return false
+}
+
+// notifyVacation signale une livraison au répondeur d'absence (best effort : la décision RFC 3834
+// et l'envoi se font côté mail-directory-service ; un échec n'affecte jamais la livraison).
+func notifyVacation(ctx context.Context, cfg config, accountID int, recipient, envelopeFrom string, msg []byte) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]any{
+		"account_id":    accountID,
+		"recipient":     recipient,
+		"envelope_from": envelopeFrom,
+		"headers":       string(headerBlock(msg)),
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.MailDirectoryURL+"/mail/internal/vacation/notify", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-MTA-Internal-Token", cfg.InternalToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("vacation notify %s: %v", recipient, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("vacation notify %s: status %d", recipient, resp.StatusCode)
+		return
+	}
+	var out struct {
+		Replied bool `json:"replied"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&out) == nil && out.Replied {
+		log.Printf("vacation reply sent for %s (account_id=%d)", recipient, accountID)
+	}
+}
+
+// signDKIM fait signer le message relayé avec les clés du domaine de l'alias (d= domaine qui
+// relaie). Best effort : sans clé ou en cas d'erreur, le message part non signé.
+func signDKIM(ctx context.Context, cfg config, alias string, msg []byte) []byte {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	domain := alias[strings.LastIndexByte(alias, '@')+1:]
+	body, _ := json.Marshal(map[string]any{"domain": domain, "message": msg})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.MailDirectoryURL+"/mail/internal/dkim/sign", bytes.NewReader(body))
+	if err != nil {
+		return msg
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-MTA-Internal-Token", cfg.InternalToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("dkim sign %s: %v", domain, err)
+		return msg
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("dkim sign %s: status %d", domain, resp.StatusCode)
+		return msg
+	}
+	var out struct {
+		Signed  bool   `json:"signed"`
+		Headers string `json:"headers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil || !out.Signed {
+		return msg
+	}
+	return append([]byte(out.Headers), msg...)
+}
+
+// prependSieveHeaders transmet fileinto / imap4flags au magasin final (règle de tri côté IMAP
+// ou sync Cloudity), qui seul connaît les dossiers de la boîte.
+func prependSieveHeaders(outcome sieveOutcome, msg []byte) []byte {
+	var prefix strings.Builder
+	if len(outcome.FileInto) > 0 {
+		fmt.Fprintf(&prefix, "X-Cloudity-Sieve-Folder: %s\r\n", sanitizeHeaderValue(outcome.FileInto[0]))
+	}
+	if len(outcome.Flags) > 0 {
+		fmt.Fprintf(&prefix, "X-Cloudity-Sieve-Flags: %s\r\n", sanitizeHeaderValue(strings.Join(outcome.Flags, " ")))
+	}
+	if prefix.Len() == 0 {
+		return msg
+	}
+	return append([]byte(prefix.String()), msg...)
+}
+
+func sanitizeHeaderValue(v string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(v)
+}
+
 func prependAliasHeaders(alias string, msg []byte) []byte {
 	prefix := fmt.Sprintf("Delivered-To: %s\r\nX-Original-To: %s\r\nX-Envelope-To: %s\r\n", alias, alias, alias)
 	return append([]byte(prefix), msg...)
@@ -2,6 +2,10 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 )
@@ -40,3 +44,98 @@ func TestPrependAliasHeaders(t *testing.T) {
 		}
 	}
 }
+
+func TestPrependSieveHeaders(t *testing.T) {
+	msg := []byte("Subject: hi\r\n\r\nbody")
+	if got := prependSieveHeaders(sieveOutcome{Keep: true}, msg); string(got) != string(msg) {
+		t.Fatalf("keep without folder changed message: %q", got)
+	}
+	got := string(prependSieveHeaders(sieveOutcome{FileInto: []string{"News\r\nBcc: x"}, Flags: []string{`\Seen`, "urgent"}}, msg))
+	want := "X-Cloudity-Sieve-Folder: News  Bcc: x\r\nX-Cloudity-Sieve-Flags: \\Seen urgent\r\nSubject: hi"
+	if !strings.HasPrefix(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestEvaluateSieveSendsHeadersOnly(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mail/internal/sieve/evaluate" || r.Header.Get("X-MTA-Internal-Token") != "tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		_, _ = w.Write([]byte(`{"script":true,"keep":false,"discard":true}`))
+	}))
+	defer srv.Close()
+	cfg := config{MailDirectoryURL: srv.URL, InternalToken: "tok"}
+	out, err := evaluateSieve(context.Background(), cfg, "box@hosted.example", "s@x.fr", []byte("Subject: hi\r\n\r\nsecret body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.Discard || out.Keep {
+		t.Fatalf("outcome = %+v", out)
+	}
+	if body["headers"] != "Subject: hi\r\n" || body["recipient"] != "box@hosted.example" {
+		t.Fatalf("request body = %v", body)
+	}
+}
+
+func TestNotifyVacationPostsDelivery(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mail/internal/vacation/notify" || r.Header.Get("X-MTA-Internal-Token") != "tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		_, _ = w.Write([]byte(`{"ok":true,"replied":true}`))
+	}))
+	defer srv.Close()
+	cfg := config{MailDirectoryURL: srv.URL, InternalToken: "tok"}
+	notifyVacation(context.Background(), cfg, 7, "alias@cloudity.fr", "s@x.fr", []byte("Subject: hi\n\nbody"))
+	if body["account_id"] != float64(7) || body["envelope_from"] != "s@x.fr" || body["headers"] != "Subject: hi\n" {
+		t.Fatalf("request body = %v", body)
+	}
+	if !fileIntoJunk(sieveOutcome{FileInto: []string{"Junk"}}) || fileIntoJunk(sieveOutcome{FileInto: []string{"Travail"}}) {
+		t.Fatal("fileIntoJunk")
+	}
+}
+
+func TestSignDKIMPrependsSignatures(t *testing.T) {
+	var body struct {
+		Domain  string `json:"domain"`
+		Message []byte `json:"message"`
+	}
+	signed := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mail/internal/dkim/sign" || r.Header.Get("X-MTA-Internal-Token") != "tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if !signed {
+			_, _ = w.Write([]byte(`{"signed":false,"headers":""}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"signed":true,"headers":"DKIM-Signature: v=1; d=alias.cloudity.fr\r\n"}`))
+	}))
+	defer srv.Close()
+	cfg := config{MailDirectoryURL: srv.URL, InternalToken: "tok"}
+	msg := []byte("Subject: hi\r\n\r\nbody")
+	got := signDKIM(context.Background(), cfg, "promo@alias.cloudity.fr", msg)
+	if string(got) != "DKIM-Signature: v=1; d=alias.cloudity.fr\r\n"+string(msg) {
+		t.Fatalf("got %q", got)
+	}
+	if body.Domain != "alias.cloudity.fr" || string(body.Message) != string(msg) {
+		t.Fatalf("request body = %+v", body)
+	}
+	signed = false
+	if got := signDKIM(context.Background(), cfg, "promo@alias.cloudity.fr", msg); string(got) != string(msg) {
+		t.Fatalf("sans clé: got %q", got)
+	}
+	cfg.InternalToken = "bad"
+	if got := signDKIM(context.Background(), cfg, "promo@alias.cloudity.fr", msg); string(got) != string(msg) {
+		t.Fatalf("erreur: got %q", got)
+	}
+}
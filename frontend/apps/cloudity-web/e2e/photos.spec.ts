@@ -54,7 +54,7 @@ test.describe('Photos archive et corbeille (E2E)', () => {
       await route.fulfill({
         status: 200,
         contentType: 'application/json',
-        body: JSON.stringify({ items: timeline, limit: 48, offset: 0, has_more: false }),
+        body: JSON.stringify({ items: timeline, limit: 48, has_more: false }),
       })
     })
     await page.route('**/drive/photos/archive', async (route) => {
@@ -106,7 +106,7 @@ test.describe('Photos archive et corbeille (E2E)', () => {
       await route.fulfill({
         status: 200,
         contentType: 'application/json',
-        body: JSON.stringify({ items: timeline, limit: 48, offset: 0, has_more: false }),
+        body: JSON.stringify({ items: timeline, limit: 48, has_more: false }),
       })
     })
     await page.route('**/drive/nodes/trash', async (route) => {
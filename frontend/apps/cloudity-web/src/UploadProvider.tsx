@@ -199,13 +199,13 @@ export function UploadProvider({ children }: { children: React.ReactNode }) {
   }, [])
 
   const registerDownload = useCallback(
-    (label: string, task: () => Promise<{ blob: Blob; filename: string }>) => {
+    (label: string, task: (onProgress: (percent: number) => void) => Promise<{ blob: Blob; filename: string }>) => {
       const id = genId()
       setItems((prev) => [
         ...prev,
         { id, name: label, status: 'uploading', kind: 'download', parentId: null },
       ])
-      task()
+      task((p) => updateItem(id, { progress: p }))
         .then(({ blob, filename }) => {
           const url = URL.createObjectURL(blob)
           const a = document.createElement('a')
@@ -31,6 +31,20 @@ import {
   fetchMailAccounts,
   syncMailAccount,
   sendMailMessage,
+  fetchMailConversations,
+  moveMailConversationsToFolder,
+  dryRunMailFilterRule,
+  exportMailRulesSieve,
+  importMailRulesSieve,
+  setMailMessageStarred,
+  fetchMailVacation,
+  saveMailVacation,
+  fetchMailSpamBaseModel,
+  trainMailSpamBaseModel,
+  resetMailSpamBaseModel,
+  fetchMailDomainDNS,
+  checkMailDomainDNS,
+  generateMailDomainDKIM,
 } from './api'
 
 describe('api', () => {
@@ -823,6 +837,198 @@ describe('api', () => {
           }),
         })
       )
+    }
+
+    it('sends cc, bcc, html, drive attachments and reply reference', async () => {
+      const mockFetch = vi.mocked(fetch)
+      mockFetch.mockResolvedValue({
+        ok: true,
+        json: () => Promise.resolve({ message: 'message envoyé', message_id: 'abc@x.fr' }),
+      } as Response)
+      const res = await sendMailMessage('tk', {
+        account_id: 1,
+        to: ['a@example.com', 'b@example.com'],
+        cc: 'c@example.com',
+        bcc: 'd@example.com',
+        subject: 'Re: Test',
+        html: '<p>Hello</p>',
+        reply_to_message_id: 42,
+        drive_node_ids: [7],
+      })
+      expect(res.message_id).toBe('abc@x.fr')
+      const init = mockFetch.mock.calls[0][1] as RequestInit
+      expect(JSON.parse(init.body as string)).toEqual({
+        account_id: 1,
+        to: ['a@example.com', 'b@example.com'],
+        cc: 'c@example.com',
+        bcc: 'd@example.com',
+        subject: 'Re: Test',
+        html: '<p>Hello</p>',
+        reply_to_message_id: 42,
+        drive_node_ids: [7],
+      })
+    })
+  })
+
+  describe('mail conversations', () => {
+    it('lists conversations for a folder', async () => {
+      const mockFetch = vi.mocked(fetch)
+      mockFetch.mockResolvedValue({
+        ok: true,
+        json: () =>
+          Promise.resolve({
+            conversations: [{ thread_key: 'root@x.fr', account_id: 3, subject: 'Projet', message_count: 4, unread_count: 1 }],
+            total: 1,
+          }),
+      } as Response)
+      const res = await fetchMailConversations('tk', 3, { folder: 'inbox', limit: 50 })
+      expect(res.total).toBe(1)
+      expect(res.conversations[0].thread_key).toBe('root@x.fr')
+      expect(mockFetch.mock.calls[0][0]).toContain('/mail/me/accounts/3/conversations?folder=inbox&limit=50')
+    })
+
+    it('moves whole conversations with deduplicated keys', async () => {
+      const mockFetch = vi.mocked(fetch)
+      mockFetch.mockResolvedValue({
+        ok: true,
+        json: () => Promise.resolve({ ok: true, updated: 3, skipped: 1, requested: 4, folder: 'archive' }),
+      } as Response)
+      await moveMailConversationsToFolder('tk', 3, ['root@x.fr', 'root@x.fr', '#12'], 'archive')
+      const init = mockFetch.mock.calls[0][1] as RequestInit
+      expect(init.method).toBe('PATCH')
+      expect(JSON.parse(init.body as string)).toEqual({ thread_keys: ['root@x.fr', '#12'], folder: 'archive' })
+    })
+  })
+
+  describe('mail rules Sieve', () => {
+    it('exports rules as JSON-wrapped Sieve script', async () => {
+      const mockFetch = vi.mocked(fetch)
+      mockFetch.mockResolvedValue({
+        ok: true,
+        json: () => Promise.resolve({ script: 'require ["fileinto"];', warnings: null }),
+      } as Response)
+      const res = await exportMailRulesSieve('tk', 3)
+      expect(res.script).toContain('fileinto')
+      expect(mockFetch.mock.calls[0][0]).toContain('/mail/me/accounts/3/rules/sieve')
+      const init = mockFetch.mock.calls[0][1] as RequestInit
+      expect((init.headers as Record<string, string>).Accept).toBe('application/json')
+    })
+
+    it('imports a Sieve script', async () => {
+      const mockFetch = vi.mocked(fetch)
+      mockFetch.mockResolvedValue({
+        ok: true,
+        json: () => Promise.resolve({ ok: true, imported: 2, warnings: [] }),
+      } as Response)
+      const res = await importMailRulesSieve('tk', 3, 'if true { keep; }', true)
+      expect(res.imported).toBe(2)
+      const init = mockFetch.mock.calls[0][1] as RequestInit
+      expect(init.method).toBe('POST')
+      expect(JSON.parse(init.body as string)).toEqual({ script: 'if true { keep; }', replace: true })
+    })
+  })
+
+  describe('mail rules engine', () => {
+    it('dry-runs a rule with nested conditions', async () => {
+      const mockFetch = vi.mocked(fetch)
+      mockFetch.mockResolvedValue({
+        ok: true,
+        json: () =>
+          Promise.resolve({ scanned: 10, matched: 1, content_unavailable: 0, messages: [{ id: 5, folder: 'inbox', from: 'a@x.fr', subject: 'S', actions: ['star'] }] }),
+      } as Response)
+      const conditions = { op: 'or' as const, conditions: [{ field: 'subject' as const, value: 'facture' }, { field: 'size' as const, match: 'gt' as const, number: 1000 }] }
+      const res = await dryRunMailFilterRule('tk', 3, { conditions, actions: [{ type: 'star' }], limit: 50 })
+      expect(res.matched).toBe(1)
+      expect(mockFetch.mock.calls[0][0]).toContain('/mail/me/accounts/3/rules/dry-run')
+      const init = mockFetch.mock.calls[0][1] as RequestInit
+      expect(JSON.parse(init.body as string)).toEqual({ conditions, actions: [{ type: 'star' }], limit: 50 })
+    })
+
+    it('stars a message', async () => {
+      const mockFetch = vi.mocked(fetch)
+      mockFetch.mockResolvedValue({ ok: true, json: () => Promise.resolve({ ok: true, starred: true }) } as Response)
+      await setMailMessageStarred('tk', 3, 9, true)
+      expect(mockFetch.mock.calls[0][0]).toContain('/mail/me/accounts/3/messages/9/star')
+      const init = mockFetch.mock.calls[0][1] as RequestInit
+      expect(init.method).toBe('PATCH')
+      expect(JSON.parse(init.body as string)).toEqual({ starred: true })
+    })
+  })
+
+  describe('mail vacation', () => {
+    it('fetches the vacation settings', async () => {
+      const mockFetch = vi.mocked(fetch)
+      mockFetch.mockResolvedValue({
+        ok: true,
+        json: () => Promise.resolve({ enabled: false, subject: '', body: '', reply_interval_days: 7 }),
+      } as Response)
+      const res = await fetchMailVacation('tk', 3)
+      expect(res.reply_interval_days).toBe(7)
+      expect(mockFetch.mock.calls[0][0]).toContain('/mail/me/accounts/3/vacation')
+    })
+
+    it('saves the vacation settings', async () => {
+      const mockFetch = vi.mocked(fetch)
+      mockFetch.mockResolvedValue({ ok: true, json: () => Promise.resolve({ ok: true }) } as Response)
+      const settings = { enabled: true, start_at: '2026-07-01', end_at: '2026-07-15', subject: '', body: 'Absent', reply_interval_days: 3 }
+      await saveMailVacation('tk', 3, settings)
+      const init = mockFetch.mock.calls[0][1] as RequestInit
+      expect(init.method).toBe('PUT')
+      expect(JSON.parse(init.body as string)).toEqual(settings)
+    })
+  })
+
+  describe('mail spam base model', () => {
+    it('fetches the tenant base model', async () => {
+      const mockFetch = vi.mocked(fetch)
+      mockFetch.mockResolvedValue({
+        ok: true,
+        json: () => Promise.resolve({ spam_messages: 4, ham_messages: 9, tokens: 120 }),
+      } as Response)
+      const res = await fetchMailSpamBaseModel('tk')
+      expect(res.tokens).toBe(120)
+      expect(mockFetch.mock.calls[0][0]).toContain('/mail/admin/spam/model')
+    })
+
+    it('trains and resets the base model', async () => {
+      const mockFetch = vi.mocked(fetch)
+      mockFetch.mockResolvedValue({ ok: true, json: () => Promise.resolve({ ok: true, trained: 1 }) } as Response)
+      await trainMailSpamBaseModel('tk', true, [{ subject: 'Gagnez', from: 'promo@x.tk' }])
+      expect(mockFetch.mock.calls[0][0]).toContain('/mail/admin/spam/model/train')
+      const init = mockFetch.mock.calls[0][1] as RequestInit
+      expect(JSON.parse(init.body as string)).toEqual({ is_spam: true, messages: [{ subject: 'Gagnez', from: 'promo@x.tk' }] })
+      await resetMailSpamBaseModel('tk')
+      expect((mockFetch.mock.calls[1][1] as RequestInit).method).toBe('DELETE')
+    })
+  })
+
+  describe('mail domain DNS / DKIM', () => {
+    it('fetches and checks the records to publish', async () => {
+      const mockFetch = vi.mocked(fetch)
+      mockFetch.mockResolvedValue({
+        ok: true,
+        json: () =>
+          Promise.resolve({
+            domain: 'example.com',
+            records: [{ purpose: 'mx', type: 'MX', name: 'example.com', value: 'mail.example.com', zone: '', status: 'ok' }],
+            dkim_keys: [],
+            mta_sts_policy: 'version: STSv1\n',
+            ok: false,
+          }),
+      } as Response)
+      const res = await fetchMailDomainDNS('tk', 3)
+      expect(res.records[0].value).toBe('mail.example.com')
+      expect(mockFetch.mock.calls[0][0]).toContain('/mail/domains/3/dns')
+      await checkMailDomainDNS('tk', 3)
+      expect(mockFetch.mock.calls[1][0]).toContain('/mail/domains/3/dns/check')
+    })
+
+    it('generates DKIM keys with POST', async () => {
+      const mockFetch = vi.mocked(fetch)
+      mockFetch.mockResolvedValue({ ok: true, json: () => Promise.resolve({ ok: true, dkim_keys: [], records: [] }) } as Response)
+      await generateMailDomainDKIM('tk', 3)
+      expect(mockFetch.mock.calls[0][0]).toContain('/mail/domains/3/dkim')
+      expect((mockFetch.mock.calls[0][1] as RequestInit).method).toBe('POST')
     })
   })
 })
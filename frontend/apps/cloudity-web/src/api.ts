@@ -496,6 +496,91 @@ export async function deleteDomain(token: string, domainId: number): Promise<voi
   if (!res.ok) throw new Error(`Delete domain: ${res.status}`)
 }
 
+/** Enregistrement DNS à publier pour un domaine mail (status rempli par la vérification). */
+export type MailDomainDNSRecord = {
+  purpose: 'mx' | 'spf' | 'dkim' | 'dmarc' | 'mta-sts' | 'tls-rpt' | string
+  type: 'MX' | 'TXT' | 'CNAME' | string
+  name: string
+  value: string
+  priority?: number
+  /** Ligne de zone BIND prête à coller. */
+  zone: string
+  status?: 'ok' | 'missing' | 'mismatch' | 'error'
+  found?: string[]
+}
+
+export type MailDomainDKIMKey = {
+  selector: string
+  algorithm: 'rsa' | 'ed25519' | string
+  public_key: string
+  created_at?: string
+}
+
+export type MailDomainDNSResponse = {
+  domain: string
+  records: MailDomainDNSRecord[]
+  dkim_keys: MailDomainDKIMKey[] | null
+  /** Contenu à servir sur https://mta-sts.<domaine>/.well-known/mta-sts.txt */
+  mta_sts_policy: string
+  /** Présents seulement après vérification (tous les enregistrements publiés et clés DKIM générées). */
+  ok?: boolean
+  checked_at?: string
+}
+
+export async function fetchMailDomainDNS(token: string, domainId: number): Promise<MailDomainDNSResponse> {
+  return apiJson<MailDomainDNSResponse>(token, `/mail/domains/${domainId}/dns`, undefined, 'Domain DNS')
+}
+
+/** Compare les enregistrements attendus aux réponses DNS publiques. */
+export async function checkMailDomainDNS(token: string, domainId: number): Promise<MailDomainDNSResponse> {
+  return apiJson<MailDomainDNSResponse>(token, `/mail/domains/${domainId}/dns/check`, undefined, 'Domain DNS check')
+}
+
+/** Génère (ou renouvelle) les clés DKIM RSA-2048 et Ed25519 du domaine. */
+export async function generateMailDomainDKIM(
+  token: string,
+  domainId: number
+): Promise<{ ok: boolean; dkim_keys: MailDomainDKIMKey[]; records: MailDomainDNSRecord[] }> {
+  return apiJsonOk<{ ok: boolean; dkim_keys: MailDomainDKIMKey[]; records: MailDomainDNSRecord[] }>(
+    token,
+    `/mail/domains/${domainId}/dkim`,
+    { method: 'POST' },
+    'Generate DKIM keys'
+  )
+}
+
+/** Modèle anti-spam de base du tenant (admin), ajouté aux modèles appris par chaque utilisateur. */
+export type MailSpamBaseModel = {
+  spam_messages: number
+  ham_messages: number
+  tokens: number
+  updated_at?: string
+}
+
+export type MailSpamTrainingExample = { subject?: string; from?: string; body?: string }
+
+export async function fetchMailSpamBaseModel(token: string): Promise<MailSpamBaseModel> {
+  return apiJson<MailSpamBaseModel>(token, '/mail/admin/spam/model', undefined, 'Spam base model')
+}
+
+/** Ajoute jusqu’à 500 exemples étiquetés (spam ou légitimes) au modèle de base. */
+export async function trainMailSpamBaseModel(
+  token: string,
+  isSpam: boolean,
+  messages: MailSpamTrainingExample[]
+): Promise<{ ok: boolean; trained: number }> {
+  return apiJsonOk<{ ok: boolean; trained: number }>(
+    token,
+    '/mail/admin/spam/model/train',
+    { method: 'POST', body: JSON.stringify({ is_spam: isSpam, messages }) },
+    'Train spam base model'
+  )
+}
+
+export async function resetMailSpamBaseModel(token: string): Promise<{ ok: boolean }> {
+  return apiJsonOk<{ ok: boolean }>(token, '/mail/admin/spam/model', { method: 'DELETE' }, 'Reset spam base model')
+}
+
 export type MailboxResponse = {
   id: number
   domain_id: number
@@ -650,6 +735,10 @@ export type MailAccountResponse = {
   last_sync_at?: string | null
   /** Dernier message d'erreur sync (mot de passe refusé, OAuth révoqué, etc.). */
   last_sync_error?: string | null
+  /** Copie des envois dans « Envoyés » IMAP : null = automatique (désactivée pour Gmail). */
+  save_sent_copy?: boolean | null
+  /** Valeur effectivement appliquée (réglage ou choix automatique). */
+  sent_copy_enabled?: boolean
   created_at: string
   updated_at: string
 }
@@ -716,7 +805,8 @@ export type MailMessageResponse = {
   scheduled_send_at?: string
   created_at: string
   is_read?: boolean
-  /** 0–100 : heuristique anti-spam (backend). */
+  is_starred?: boolean
+  /** 0–100 : heuristique anti-spam combinée au classifieur bayésien (modèle personnel + base du tenant). */
   spam_score?: number
   /** Clé de regroupement conversation (Message-ID racine / References). */
   thread_key?: string
@@ -740,6 +830,22 @@ export type MailMessageDetailResponse = MailMessageResponse & {
   /** Bloc d’en-têtes MIME (RFC822) tel que stocké côté serveur ; optionnel selon version / synchro. */
   raw_headers?: string
   attachments?: MailAttachmentDTO[]
+  /** Verdicts SPF / DKIM / DMARC (absents tant que le message n’a pas été vérifié). */
+  authentication?: MailAuthResult
+}
+
+/** Authentification de l’expéditeur calculée à la réception (badge de confiance). */
+export type MailAuthResult = {
+  spf: string
+  spf_domain?: string
+  dkim: string
+  dkim_domains?: string[]
+  dmarc: string
+  dmarc_policy?: string
+  from_domain?: string
+  /** verified = DMARC pass, suspicious = échec / usurpation probable, neutral sinon. */
+  trust: 'verified' | 'suspicious' | 'neutral'
+  checked_at?: string
 }
 
 export type MailMessagesPageResponse = {
@@ -766,10 +872,46 @@ export type MailFilterRuleResponse = {
   rule_order?: number
   criteria_json?: string
   actions_json?: string
+  /** Format enrichi ; absent, les champs historiques ci-dessus définissent la règle. */
+  conditions?: MailRuleCondition
+  actions?: MailRuleAction[]
   created_at: string
   updated_at: string
 }
 
+/** Nœud ET / OU / NON ou feuille (champ + comparaison) du moteur de règles. */
+export type MailRuleCondition =
+  | { op: 'and' | 'or' | 'not'; conditions: MailRuleCondition[] }
+  | {
+      field: 'from' | 'to' | 'cc' | 'subject' | 'folder' | 'body' | 'header' | 'from_domain'
+      match?: 'contains' | 'equals' | 'starts_with' | 'ends_with' | 'regex' | 'exists'
+      /** Nom d'en-tête pour `field: 'header'`. */
+      header?: string
+      value?: string
+    }
+  | { field: 'size'; match: 'gt' | 'lt'; number: number }
+  | { field: 'date'; match: 'before' | 'after'; value: string }
+  | { field: 'date'; match: 'older_than_days' | 'newer_than_days'; number: number }
+  | { field: 'has_attachments'; bool: boolean }
+  | { field: 'has_tag'; tag_id: number }
+
+/** Actions exécutées dans l'ordre ; transfert, réponse et webhook ne partent qu'une fois par message. */
+export type MailRuleAction =
+  | { type: 'move'; folder: string }
+  | { type: 'add_tag'; tag_id: number }
+  | { type: 'mark_read' | 'mark_unread' | 'star' | 'delete' | 'stop' }
+  | { type: 'forward'; to: string }
+  | { type: 'auto_reply'; body: string; subject?: string }
+  | { type: 'webhook'; url: string; secret?: string }
+
+export type MailRuleDryRunResponse = {
+  scanned: number
+  matched: number
+  /** Messages dont en-têtes et corps ne sont pas encore en base (non évalués sur ces critères). */
+  content_unavailable: number
+  messages: { id: number; folder: string; from: string; subject: string; date_at?: string; actions: string[] }[]
+}
+
 export async function fetchMailMessages(
   token: string,
   accountId: number,
@@ -857,6 +999,8 @@ export type MailAccountUpdatePayload = {
   imap_port?: number
   smtp_host?: string
   smtp_port?: number
+  /** null = automatique selon le fournisseur. */
+  save_sent_copy?: boolean | null
 }
 
 /** Met à jour libellé, mot de passe, serveurs IMAP/SMTP (sauvegardés en base pour sync et envoi). */
@@ -939,6 +1083,8 @@ export async function createMailFilterRule(
     mark_read?: boolean
     enabled?: boolean
     rule_order?: number
+    conditions?: MailRuleCondition
+    actions?: MailRuleAction[]
   }
 ): Promise<{ ok: boolean; id: number }> {
   return apiJsonOk<{ ok: boolean; id: number }>(
@@ -967,6 +1113,9 @@ export async function patchMailFilterRule(
     mark_read?: boolean
     enabled?: boolean
     rule_order?: number
+    /** `null` revient aux champs historiques. */
+    conditions?: MailRuleCondition | null
+    actions?: MailRuleAction[] | null
   }
 ): Promise<{ ok: boolean }> {
   return apiJsonOk(
@@ -995,6 +1144,48 @@ export async function applyMailFilterRules(token: string, accountId: number): Pr
   )
 }
 
+/** Évalue une règle sur les messages enregistrés sans rien modifier (aperçu avant enregistrement). */
+export async function dryRunMailFilterRule(
+  token: string,
+  accountId: number,
+  rule: Partial<Parameters<typeof createMailFilterRule>[2]> & { limit?: number }
+): Promise<MailRuleDryRunResponse> {
+  return apiJson<MailRuleDryRunResponse>(
+    token,
+    `/mail/me/accounts/${accountId}/rules/dry-run`,
+    { method: 'POST', body: JSON.stringify(rule) },
+    'Dry-run mail rule'
+  )
+}
+
+/** Export Sieve (RFC 5228) des règles actives ; `warnings` liste les conditions sans équivalent. */
+export async function exportMailRulesSieve(
+  token: string,
+  accountId: number
+): Promise<{ script: string; warnings: string[] | null }> {
+  return apiJson<{ script: string; warnings: string[] | null }>(
+    token,
+    `/mail/me/accounts/${accountId}/rules/sieve`,
+    { json: false, headers: { Accept: 'application/json' } },
+    'Export mail rules (Sieve)'
+  )
+}
+
+/** Import d'un script Sieve en règles de tri ; `replace` supprime d'abord les règles hors alias. */
+export async function importMailRulesSieve(
+  token: string,
+  accountId: number,
+  script: string,
+  replace = false
+): Promise<{ ok: boolean; imported: number; warnings: string[] }> {
+  return apiJsonOk<{ ok: boolean; imported: number; warnings: string[] }>(
+    token,
+    `/mail/me/accounts/${accountId}/rules/sieve`,
+    { method: 'POST', body: JSON.stringify({ script, replace }) },
+    'Import mail rules (Sieve)'
+  )
+}
+
 export async function createMailAlias(
   token: string,
   accountId: number,
@@ -1087,6 +1278,57 @@ export async function markMailMessageRead(
   )
 }
 
+export async function setMailMessageStarred(
+  token: string,
+  accountId: number,
+  messageId: number,
+  starred: boolean
+): Promise<{ ok: boolean; starred: boolean }> {
+  return apiJsonOk<{ ok: boolean; starred: boolean }>(
+    token,
+    `/mail/me/accounts/${accountId}/messages/${messageId}/star`,
+    { method: 'PATCH', body: JSON.stringify({ starred }) },
+    'Star message'
+  )
+}
+
+/** Répondeur d’absence d’une boîte (RFC 3834 : pas de réponse aux listes, envois en nombre, messages automatiques ni à nos alias). */
+export type MailVacationSettings = {
+  enabled: boolean
+  /** RFC 3339 ou `AAAA-MM-JJ` ; absent = période ouverte. */
+  start_at?: string
+  end_at?: string
+  /** Vide = « Auto: » + objet du message reçu. */
+  subject: string
+  body: string
+  /** Une réponse au plus par expéditeur sur cet intervalle (1 à 30 jours). */
+  reply_interval_days: number
+  updated_at?: string
+}
+
+export async function fetchMailVacation(token: string, accountId: number): Promise<MailVacationSettings> {
+  return apiJson<MailVacationSettings>(
+    token,
+    `/mail/me/accounts/${accountId}/vacation`,
+    { json: false, headers: { Accept: 'application/json' } },
+    'Fetch mail vacation'
+  )
+}
+
+/** Enregistre le répondeur ; seuls les messages reçus après l’enregistrement reçoivent une réponse. */
+export async function saveMailVacation(
+  token: string,
+  accountId: number,
+  settings: Omit<MailVacationSettings, 'updated_at'>
+): Promise<{ ok: boolean }> {
+  return apiJsonOk<{ ok: boolean }>(
+    token,
+    `/mail/me/accounts/${accountId}/vacation`,
+    { method: 'PUT', body: JSON.stringify(settings) },
+    'Save mail vacation'
+  )
+}
+
 export type MailStandardFolderId = 'inbox' | 'sent' | 'drafts' | 'archive' | 'spam' | 'trash'
 /** Dossier standard, vue agrégée `all`, vue multi-boîtes `unified`, ou chemin IMAP synchronisé (même valeur qu’en base). `string & {}` : pattern TS pour ne pas absorber les littéraux dans le union. */
 // eslint-disable-next-line @typescript-eslint/ban-types -- voir jsdoc ci-dessus
@@ -1136,6 +1378,126 @@ export async function moveMailMessagesToFolderBulk(
   )
 }
 
+/** Conversation : messages regroupés par thread_key, tous dossiers confondus (Envoyés compris). */
+export type MailConversationResponse = {
+  /** thread_key, ou « #<id> » pour un message isolé. */
+  thread_key: string
+  account_id: number
+  subject: string
+  participants: string[]
+  message_count: number
+  unread_count: number
+  latest_date: string
+  latest_message_id: number
+  folders: string[]
+  attachment_count: number
+}
+
+export type MailConversationsPageResponse = { conversations: MailConversationResponse[]; total: number }
+
+function conversationsQuery(options?: { folder?: string; limit?: number; offset?: number }): string {
+  const params = new URLSearchParams()
+  if (options?.folder) params.set('folder', options.folder)
+  if (options?.limit != null) params.set('limit', String(options.limit))
+  if (options?.offset != null) params.set('offset', String(options.offset))
+  const q = params.toString()
+  return q ? `?${q}` : ''
+}
+
+/** Conversations ayant au moins un message dans le dossier (`all` : hors brouillons). */
+export async function fetchMailConversations(
+  token: string,
+  accountId: number,
+  options?: { folder?: string; limit?: number; offset?: number }
+): Promise<MailConversationsPageResponse> {
+  const data = await apiJson<MailConversationsPageResponse>(
+    token,
+    `/mail/me/accounts/${accountId}/conversations${conversationsQuery(options)}`,
+    { json: false },
+    'Mail conversations'
+  )
+  const conversations = Array.isArray(data.conversations) ? data.conversations : []
+  return { conversations, total: typeof data.total === 'number' ? data.total : conversations.length }
+}
+
+export async function fetchUnifiedMailConversations(
+  token: string,
+  options?: { folder?: string; limit?: number; offset?: number }
+): Promise<MailConversationsPageResponse> {
+  const data = await apiJson<MailConversationsPageResponse>(
+    token,
+    `/mail/me/conversations/unified${conversationsQuery(options)}`,
+    { json: false },
+    'Mail conversations unifiées'
+  )
+  const conversations = Array.isArray(data.conversations) ? data.conversations : []
+  return { conversations, total: typeof data.total === 'number' ? data.total : conversations.length }
+}
+
+/** Messages d'une conversation, du plus ancien au plus récent. */
+export async function fetchMailConversationMessages(
+  token: string,
+  accountId: number,
+  threadKey: string,
+  folder?: string
+): Promise<MailMessageResponse[]> {
+  const params = new URLSearchParams({ thread_key: threadKey })
+  if (folder) params.set('folder', folder)
+  const data = await apiJson<{ messages: MailMessageResponse[] }>(
+    token,
+    `/mail/me/accounts/${accountId}/conversations/messages?${params.toString()}`,
+    { json: false },
+    'Mail conversation'
+  )
+  return Array.isArray(data.messages) ? data.messages : []
+}
+
+export async function markMailConversationsRead(
+  token: string,
+  accountId: number,
+  threadKeys: string[],
+  read: boolean
+): Promise<{ ok: boolean; updated: number; read: boolean }> {
+  return apiJsonOk<{ ok: boolean; updated: number; read: boolean }>(
+    token,
+    `/mail/me/accounts/${accountId}/conversations/read`,
+    { method: 'PATCH', body: JSON.stringify({ thread_keys: [...new Set(threadKeys)], read }) },
+    'Conversations mark read'
+  )
+}
+
+/** Déplace des conversations entières (Envoyés / brouillons restent en place, sauf vers la corbeille). */
+export async function moveMailConversationsToFolder(
+  token: string,
+  accountId: number,
+  threadKeys: string[],
+  folder: string
+): Promise<{ ok: boolean; updated: number; skipped: number; requested: number; folder: string }> {
+  return apiJsonOk<{ ok: boolean; updated: number; skipped: number; requested: number; folder: string }>(
+    token,
+    `/mail/me/accounts/${accountId}/conversations/folder`,
+    { method: 'PATCH', body: JSON.stringify({ thread_keys: [...new Set(threadKeys)], folder }) },
+    'Conversations move'
+  )
+}
+
+export async function tagMailConversations(
+  token: string,
+  accountId: number,
+  threadKeys: string[],
+  tags: { add?: number[]; remove?: number[] }
+): Promise<{ ok: boolean; messages: number }> {
+  return apiJsonOk<{ ok: boolean; messages: number }>(
+    token,
+    `/mail/me/accounts/${accountId}/conversations/tags`,
+    {
+      method: 'PATCH',
+      body: JSON.stringify({ thread_keys: [...new Set(threadKeys)], add_tag_ids: tags.add ?? [], remove_tag_ids: tags.remove ?? [] }),
+    },
+    'Conversations tags'
+  )
+}
+
 export async function deleteMailMessagePermanently(
   token: string,
   accountId: number,
@@ -1418,20 +1780,41 @@ export async function putMailMessageTags(
   )
 }
 
+/** Corps commun de POST /mail/me/send et /mail/me/send/schedule (destinataires : « a@x, b@y » ou tableau). */
+export type MailComposePayload = {
+  account_id: number
+  to: string | string[]
+  cc?: string | string[]
+  /** Destinataires cachés : enveloppe SMTP uniquement, jamais dans les en-têtes. */
+  bcc?: string | string[]
+  subject: string
+  /** Texte brut (historique) ; `text` + `html` produisent un multipart/alternative. */
+  body?: string
+  text?: string
+  html?: string
+  /** Adresse « De » : boîte principale ou alias enregistré pour ce compte. */
+  from_email?: string
+  /** Réponse : message d’origine, dont le serveur reprend In-Reply-To / References. */
+  reply_to_message_id?: number
+  /** Fichiers Drive joints (contenu lu côté serveur avec les droits de l’utilisateur). */
+  drive_node_ids?: number[]
+  /** Pièces jointes directes ; `content_id` = image inline référencée par cid: dans le HTML. */
+  attachments?: { filename: string; content_type?: string; content_base64: string; content_id?: string }[]
+  /** Brouillon d’origine : supprimé une fois le message envoyé ou programmé. */
+  draft_id?: number
+  draft_attachment_ids?: number[]
+  in_reply_to?: string
+  references?: string
+}
+
 export async function sendMailMessage(
   token: string,
-  payload: {
-    account_id: number
+  payload: MailComposePayload & {
     password?: string
-    to: string
-    subject: string
-    body: string
     smtp_host?: string
     smtp_port?: number
-    /** Adresse « De » : boîte principale ou alias enregistré pour ce compte. */
-    from_email?: string
   }
-): Promise<{ message: string }> {
+): Promise<{ message: string; message_id?: string }> {
   const res = await apiFetch(token, '/mail/me/send', { method: 'POST', body: JSON.stringify(payload) })
   if (!res.ok) {
     const t = await res.text()
@@ -1442,19 +1825,12 @@ export async function sendMailMessage(
       throw new Error(t || `Send: ${res.status}`)
     }
   }
-  return res.json() as Promise<{ message: string }>
+  return res.json() as Promise<{ message: string; message_id?: string }>
 }
 
 export async function scheduleMailMessage(
   token: string,
-  payload: {
-    account_id: number
-    to: string
-    subject: string
-    body: string
-    from_email?: string
-    scheduled_send_at: string
-  }
+  payload: MailComposePayload & { scheduled_send_at: string }
 ): Promise<{ ok: boolean; id: number; scheduled_send_at: string }> {
   const res = await apiFetch(token, '/mail/me/send/schedule', { method: 'POST', body: JSON.stringify(payload) })
   if (!res.ok) {
@@ -1469,6 +1845,63 @@ export async function scheduleMailMessage(
   return res.json() as Promise<{ ok: boolean; id: number; scheduled_send_at: string }>
 }
 
+/** Brouillon serveur (dossier Brouillons, déposé aussi dans le dossier IMAP Drafts du compte). */
+export type MailDraftResponse = {
+  id: number
+  account_id: number
+  from_email: string
+  to: string
+  cc: string
+  bcc: string
+  subject: string
+  text: string
+  html: string
+  in_reply_to: string
+  references: string
+  updated_at: string
+  /** false : brouillon seulement en base (IMAP injoignable), redéposé au prochain enregistrement. */
+  imap_synced: boolean
+  imap_error?: string
+  attachments: { id: number; filename: string; content_type: string; size_bytes: number; content_id?: string }[]
+}
+
+export type MailDraftPayload = Omit<MailComposePayload, 'account_id' | 'to'> & {
+  to?: string | string[]
+  in_reply_to?: string
+  references?: string
+  /** Pièces jointes déjà enregistrées dans le brouillon à conserver. */
+  draft_attachment_ids?: number[]
+}
+
+export async function fetchMailDraft(token: string, accountId: number, draftId: number): Promise<MailDraftResponse> {
+  return apiJson<MailDraftResponse>(token, `/mail/me/accounts/${accountId}/drafts/${draftId}`, { method: 'GET' }, 'Mail draft')
+}
+
+/** Crée (draftId absent) ou remplace un brouillon. */
+export async function saveMailDraft(
+  token: string,
+  accountId: number,
+  draftId: number | null,
+  payload: MailDraftPayload
+): Promise<MailDraftResponse> {
+  const path = draftId ? `/mail/me/accounts/${accountId}/drafts/${draftId}` : `/mail/me/accounts/${accountId}/drafts`
+  return apiJson<MailDraftResponse>(
+    token,
+    path,
+    { method: draftId ? 'PUT' : 'POST', body: JSON.stringify(payload) },
+    'Save mail draft'
+  )
+}
+
+export async function deleteMailDraft(token: string, accountId: number, draftId: number): Promise<{ ok: boolean }> {
+  return apiJsonOk<{ ok: boolean }>(
+    token,
+    `/mail/me/accounts/${accountId}/drafts/${draftId}`,
+    { method: 'DELETE' },
+    'Delete mail draft'
+  )
+}
+
 export type LoginBody = { email: string; password: string; tenant_id?: number }
 export type LoginResponse = {
   access_token: string
@@ -1666,6 +2099,8 @@ export type DriveNode = {
   child_files?: number
   /** Date de suppression (corbeille). */
   deleted_at?: string | null
+  /** Date de purge automatique (corbeille, DRIVE_TRASH_RETENTION_DAYS) ; absente si la rétention est désactivée. */
+  purge_at?: string | null
   /** Archivage Photos (hors timeline). */
   photo_archived_at?: string | null
   /** Verrouillage Photos (hors timeline et archive). */
@@ -1676,6 +2111,21 @@ export type DriveNode = {
   is_vault_folder?: boolean
   /** Nom du dossier parent (recherche GET /drive/nodes/search). */
   parent_folder_name?: string
+  /** Timeline Photos : type de média et métadonnées vidéo (atomes MP4 / QuickTime). */
+  media_kind?: 'image' | 'video'
+  duration_ms?: number
+  width?: number
+  height?: number
+  video_codec?: string
+  /** moov avant mdat : lecture possible avant la fin du téléchargement. */
+  streamable?: boolean
+  /** Position GPS (EXIF / ISO 6709) et lieu issu du géocodage hors ligne. */
+  latitude?: number
+  longitude?: number
+  place_name?: string
+  place_country?: string
+  camera_make?: string
+  camera_model?: string
 }
 
 export async function fetchDriveNodes(
@@ -1686,6 +2136,25 @@ export async function fetchDriveNodes(
   return apiJson<DriveNode[]>(token, path, { json: false }, 'Drive')
 }
 
+export type DriveNodesPage = {
+  items: DriveNode[]
+  limit: number
+  next_cursor?: string
+  has_more: boolean
+}
+
+/** Listing paginé par curseur (dossiers d'abord, puis nom) pour les très gros dossiers. */
+export async function fetchDriveNodesPage(
+  token: string,
+  parentId: number | null,
+  opts?: { limit?: number; cursor?: string }
+): Promise<DriveNodesPage> {
+  const params = new URLSearchParams({ limit: String(opts?.limit ?? 200) })
+  if (parentId != null) params.set('parent_id', String(parentId))
+  if (opts?.cursor) params.set('cursor', opts.cursor)
+  return apiJson<DriveNodesPage>(token, `/drive/nodes?${params}`, { json: false }, 'Drive')
+}
+
 export type DriveStorageServiceUsage = {
   label: string
   bytes: number
@@ -1739,24 +2208,155 @@ export async function fetchDriveRecentFiles(
 }
 
 /** Réponse paginée : toutes les images du Drive (tous dossiers), tri récent d’abord. */
+/** Mois de la timeline (UTC) : cursor ouvre la timeline directement sur ce mois. */
+export type PhotosTimelineBucket = {
+  year: number
+  month: number
+  count: number
+  cursor: string
+}
+
 export type DrivePhotosTimelinePage = {
   items: DriveNode[]
   limit: number
-  offset: number
+  /** Curseur opaque de la page suivante (absent sur la dernière page). */
+  next_cursor?: string
   has_more: boolean
+  /** Résumé par mois, renvoyé avec la première page (sans curseur). */
+  buckets?: PhotosTimelineBucket[]
+}
+
+/** Filtres EXIF communs à la timeline et aux lieux (dates AAAA-MM-JJ ou RFC 3339). */
+export type PhotosFilters = {
+  camera?: string
+  make?: string
+  from?: string
+  to?: string
+  place?: string
+  country?: string
+  has_location?: boolean
+  /** Sud, ouest, nord, est (degrés) ; ouest > est traverse l'antiméridien. */
+  bbox?: [number, number, number, number]
+}
+
+function photosFiltersQuery(params: URLSearchParams, f?: PhotosFilters) {
+  if (!f) return
+  for (const k of ['camera', 'make', 'from', 'to', 'place', 'country'] as const) {
+    const v = f[k]
+    if (v) params.set(k, v)
+  }
+  if (f.has_location !== undefined) params.set('has_location', f.has_location ? '1' : '0')
+  if (f.bbox) params.set('bbox', f.bbox.join(','))
 }
 
 export async function fetchDrivePhotosTimeline(
   token: string,
-  opts?: { limit?: number; offset?: number }
+  opts?: { limit?: number; cursor?: string; buckets?: boolean } & PhotosFilters
 ): Promise<DrivePhotosTimelinePage> {
-  const limit = opts?.limit ?? 48
-  const offset = opts?.offset ?? 0
-  return apiJson<DrivePhotosTimelinePage>(
+  const params = new URLSearchParams({ limit: String(opts?.limit ?? 48) })
+  if (opts?.cursor) params.set('cursor', opts.cursor)
+  if (opts?.buckets) params.set('buckets', '1')
+  photosFiltersQuery(params, opts)
+  return apiJson<DrivePhotosTimelinePage>(token, `/photos/timeline?${params}`, { json: false }, 'Photos timeline')
+}
+
+export type PhotosPlace = {
+  key: string
+  name?: string
+  admin?: string
+  country?: string
+  latitude: number
+  longitude: number
+  count: number
+  cover_node_id: number
+  first_taken_at: string
+  last_taken_at: string
+}
+
+/** Lieux Photos : par lieu connu (défaut) ou par maille de carte (group=grid, zoom 0–18). */
+export function fetchPhotosPlaces(
+  token: string,
+  opts?: { group?: 'place' | 'grid'; zoom?: number } & PhotosFilters
+): Promise<PhotosPlace[]> {
+  const params = new URLSearchParams()
+  if (opts?.group) params.set('group', opts.group)
+  if (opts?.zoom !== undefined) params.set('zoom', String(opts.zoom))
+  photosFiltersQuery(params, opts)
+  const qs = params.toString()
+  return apiJson<PhotosPlace[]>(token, `/photos/places${qs ? `?${qs}` : ''}`, { json: false }, 'Lieux photos')
+}
+
+export type PhotosCamera = { make: string; model: string; count: number }
+
+export function fetchPhotosCameras(token: string): Promise<PhotosCamera[]> {
+  return apiJson<PhotosCamera[]>(token, '/photos/cameras', { json: false }, 'Appareils photo')
+}
+
+/** Métadonnées EXIF / GPS d'un fichier (analyzed = false tant que le worker n'est pas passé). */
+export type DriveNodeMetadata = {
+  id: number
+  name: string
+  analyzed: boolean
+  taken_at?: string
+  camera_make?: string
+  camera_model?: string
+  lens_model?: string
+  width?: number
+  height?: number
+  orientation?: number
+  iso?: number
+  exposure_time?: string
+  f_number?: number
+  focal_length?: number
+  duration_ms?: number
+  location?: { latitude: number; longitude: number; altitude?: number }
+  place?: { name: string; admin: string; country: string }
+}
+
+export function fetchDriveNodeMetadata(token: string, nodeId: number): Promise<DriveNodeMetadata> {
+  return apiJson<DriveNodeMetadata>(token, `/drive/nodes/${nodeId}/metadata`, { json: false }, 'Métadonnées')
+}
+
+/** Groupe de quasi-doublons ou de rafale ; items[0] (= keep_id) est la photo conseillée. */
+export type PhotosDuplicateGroup = {
+  kind: 'duplicate' | 'burst'
+  keep_id: number
+  items: (DriveNode & { distance: number })[]
+}
+
+export type PhotosDuplicatesPage = {
+  groups: PhotosDuplicateGroup[]
+  threshold: number
+  burst_gap_seconds: number
+  limit: number
+  offset: number
+  has_more: boolean
+}
+
+/** Quasi-doublons (empreintes perceptuelles, threshold 0–7 bits) et rafales (burst_gap en s, 0 = désactivé). */
+export function fetchPhotosDuplicates(
+  token: string,
+  opts?: { kind?: 'duplicate' | 'burst'; threshold?: number; burst_gap?: number; limit?: number; offset?: number }
+): Promise<PhotosDuplicatesPage> {
+  const params = new URLSearchParams()
+  for (const [k, v] of Object.entries(opts ?? {})) {
+    if (v !== undefined) params.set(k, String(v))
+  }
+  const qs = params.toString()
+  return apiJson<PhotosDuplicatesPage>(token, `/photos/duplicates${qs ? `?${qs}` : ''}`, { json: false }, 'Doublons photos')
+}
+
+/** Garde keep_id (sinon la meilleure photo du groupe) et met les autres à la corbeille. */
+export function resolvePhotosDuplicates(
+  token: string,
+  ids: number[],
+  keepId?: number
+): Promise<{ kept_id: number; trashed: number }> {
+  return apiJson<{ kept_id: number; trashed: number }>(
     token,
-    `/photos/timeline?limit=${encodeURIComponent(String(limit))}&offset=${encodeURIComponent(String(offset))}`,
-    { json: false },
-    'Photos timeline'
+    '/photos/duplicates/resolve',
+    { method: 'POST', body: JSON.stringify({ ids, keep_id: keepId ?? 0 }) },
+    'Nettoyage doublons'
   )
 }
 
@@ -1764,19 +2364,81 @@ export async function fetchDrivePhotosArchive(token: string): Promise<DriveNode[
   return apiJson<DriveNode[]>(token, '/drive/photos/archive', { json: false }, 'Photos archive')
 }
 
+/**
+ * Jeton d'accès court au dossier verrouillé (POST /drive/photos/locked/unlock), gardé en mémoire
+ * seulement : envoyé en `X-Locked-Access` sur le contenu, les vignettes et la liste verrouillée.
+ */
+let driveLockedAccess: string | null = null
+
+export function setDriveLockedAccess(access: string | null): void {
+  driveLockedAccess = access
+}
+
+function lockedAccessHeaders(): Record<string, string> {
+  return driveLockedAccess ? { 'X-Locked-Access': driveLockedAccess } : {}
+}
+
+export type DriveLockedStatus = { pin_set: boolean; unlocked: boolean }
+
+export async function fetchDriveLockedStatus(token: string): Promise<DriveLockedStatus> {
+  return apiJson<DriveLockedStatus>(
+    token,
+    '/drive/photos/locked/status',
+    { json: false, headers: lockedAccessHeaders() },
+    'Coffre Photos'
+  )
+}
+
+/** PUT /drive/photos/locked/pin — définit le code serveur (currentPin requis pour le changer). */
+export async function setDriveLockedPin(token: string, pin: string, currentPin?: string): Promise<void> {
+  const res = await apiFetch(token, '/drive/photos/locked/pin', {
+    method: 'PUT',
+    body: JSON.stringify({ pin, current_pin: currentPin ?? '' }),
+  })
+  if (!res.ok) {
+    let msg = `Code coffre: ${res.status}`
+    try {
+      const j = (await res.json()) as { error?: string }
+      if (j?.error) msg = j.error
+    } catch {
+      /* ignore */
+    }
+    throw new Error(msg)
+  }
+}
+
+/** POST /drive/photos/locked/unlock — échange le code contre un jeton d'accès (mémorisé pour les appels suivants). */
+export async function unlockDriveLocked(token: string, pin: string): Promise<{ access: string; expires_in: number }> {
+  const out = await apiJson<{ access: string; expires_in: number }>(
+    token,
+    '/drive/photos/locked/unlock',
+    { method: 'POST', body: JSON.stringify({ pin }) },
+    'Déverrouillage coffre'
+  )
+  setDriveLockedAccess(out.access)
+  return out
+}
+
 export async function fetchDrivePhotosLocked(token: string): Promise<DriveNode[]> {
-  return apiJson<DriveNode[]>(token, '/drive/photos/locked', { json: false }, 'Photos locked')
+  return apiJson<DriveNode[]>(
+    token,
+    '/drive/photos/locked',
+    { json: false, headers: lockedAccessHeaders() },
+    'Photos locked'
+  )
 }
 
 async function mutateDrivePhotosIds(
   token: string,
   path: string,
   ids: number[],
-  label: string
+  label: string,
+  headers?: Record<string, string>
 ): Promise<{ updated: number }> {
   const res = await apiFetch(token, path, {
     method: 'POST',
     body: JSON.stringify({ ids }),
+    headers,
   })
   if (!res.ok) {
     let msg = `${label}: ${res.status}`
@@ -1804,7 +2466,140 @@ export function lockDrivePhotos(token: string, ids: number[]): Promise<{ updated
 }
 
 export function unlockDrivePhotos(token: string, ids: number[]): Promise<{ updated: number }> {
-  return mutateDrivePhotosIds(token, '/drive/photos/unlock', ids, 'Déverrouillage photos')
+  return mutateDrivePhotosIds(token, '/drive/photos/unlock', ids, 'Déverrouillage photos', lockedAccessHeaders())
+}
+
+export type PhotoAlbumRole = 'owner' | 'contributor' | 'viewer'
+export type PhotoAlbumSortMode = 'taken_desc' | 'taken_asc' | 'manual'
+
+/** Album Photos : référence des nœuds Drive (pas de copie). */
+export type PhotoAlbum = {
+  id: number
+  owner_id: number
+  owner_email?: string
+  title: string
+  description: string
+  cover_node_id: number | null
+  sort_mode: PhotoAlbumSortMode
+  role: PhotoAlbumRole
+  item_count: number
+  created_at: string
+  updated_at: string
+  items?: DriveNode[]
+}
+
+export type PhotoAlbumMember = { user_id: number; email: string; role: 'viewer' | 'contributor'; added_at: string }
+
+function expectNoContent(res: Response, label: string): void {
+  if (!res.ok) throw new Error(`${label}: ${res.status}`)
+}
+
+export function fetchPhotoAlbums(token: string): Promise<PhotoAlbum[]> {
+  return apiJson<PhotoAlbum[]>(token, '/drive/photos/albums', { json: false }, 'Albums')
+}
+
+export function fetchPhotoAlbum(token: string, albumId: number): Promise<PhotoAlbum> {
+  return apiJson<PhotoAlbum>(token, `/drive/photos/albums/${albumId}`, { json: false }, 'Album')
+}
+
+export function createPhotoAlbum(
+  token: string,
+  title: string,
+  nodeIds: number[] = [],
+  description = ''
+): Promise<PhotoAlbum> {
+  return apiJson<PhotoAlbum>(
+    token,
+    '/drive/photos/albums',
+    { method: 'POST', body: JSON.stringify({ title, description, node_ids: nodeIds }) },
+    'Création album'
+  )
+}
+
+export function updatePhotoAlbum(
+  token: string,
+  albumId: number,
+  patch: { title?: string; description?: string; cover_node_id?: number; sort_mode?: PhotoAlbumSortMode }
+): Promise<PhotoAlbum> {
+  return apiJson<PhotoAlbum>(
+    token,
+    `/drive/photos/albums/${albumId}`,
+    { method: 'PATCH', body: JSON.stringify(patch) },
+    'Modification album'
+  )
+}
+
+export async function deletePhotoAlbum(token: string, albumId: number): Promise<void> {
+  expectNoContent(await apiFetch(token, `/drive/photos/albums/${albumId}`, { method: 'DELETE' }), 'Suppression album')
+}
+
+export function addPhotoAlbumItems(
+  token: string,
+  albumId: number,
+  nodeIds: number[]
+): Promise<{ added: number; ignored: number }> {
+  return apiJson<{ added: number; ignored: number }>(
+    token,
+    `/drive/photos/albums/${albumId}/items`,
+    { method: 'POST', body: JSON.stringify({ node_ids: nodeIds }) },
+    'Ajout album'
+  )
+}
+
+export async function removePhotoAlbumItem(token: string, albumId: number, nodeId: number): Promise<void> {
+  expectNoContent(
+    await apiFetch(token, `/drive/photos/albums/${albumId}/items/${nodeId}`, { method: 'DELETE' }),
+    'Retrait album'
+  )
+}
+
+/** Ordre manuel : les nœuds listés passent en tête, l’album bascule en tri « manual ». */
+export async function reorderPhotoAlbum(token: string, albumId: number, nodeIds: number[]): Promise<void> {
+  expectNoContent(
+    await apiFetch(token, `/drive/photos/albums/${albumId}/order`, {
+      method: 'PUT',
+      body: JSON.stringify({ node_ids: nodeIds }),
+    }),
+    'Ordre album'
+  )
+}
+
+export function fetchPhotoAlbumMembers(token: string, albumId: number): Promise<PhotoAlbumMember[]> {
+  return apiJson<PhotoAlbumMember[]>(token, `/drive/photos/albums/${albumId}/members`, { json: false }, 'Membres album')
+}
+
+export function addPhotoAlbumMember(
+  token: string,
+  albumId: number,
+  member: { user_id?: number; email?: string; role: 'viewer' | 'contributor' }
+): Promise<{ album_id: number; user_id: number; role: string }> {
+  return apiJson(
+    token,
+    `/drive/photos/albums/${albumId}/members`,
+    { method: 'POST', body: JSON.stringify(member) },
+    'Partage album'
+  )
+}
+
+export async function removePhotoAlbumMember(token: string, albumId: number, userId: number): Promise<void> {
+  expectNoContent(
+    await apiFetch(token, `/drive/photos/albums/${albumId}/members/${userId}`, { method: 'DELETE' }),
+    'Retrait membre album'
+  )
+}
+
+/** Lien public en lecture seule (mot de passe / expiration / plafond optionnels). */
+export function createPhotoAlbumShareLink(
+  token: string,
+  albumId: number,
+  opts: { password?: string; expires_at?: string; max_downloads?: number } = {}
+): Promise<{ id: number; token: string; path: string; has_password: boolean; expires_at?: string }> {
+  return apiJson(
+    token,
+    `/drive/photos/albums/${albumId}/shares`,
+    { method: 'POST', body: JSON.stringify(opts) },
+    'Lien album'
+  )
 }
 
 export async function createDriveFolder(
@@ -1959,13 +2754,20 @@ export async function purgeDriveNode(token: string, id: number): Promise<void> {
   if (!res.ok) throw new Error(`Purge: ${res.status}`)
 }
 
+/** DELETE /drive/nodes/trash — vide toute la corbeille (nœuds supprimés, octets libérés). */
+export async function emptyDriveTrash(token: string): Promise<{ purged: number; freed_bytes: number }> {
+  const res = await apiFetch(token, '/drive/nodes/trash', { method: 'DELETE' })
+  if (!res.ok) throw new Error(`Vider la corbeille: ${res.status}`)
+  return res.json()
+}
+
 export async function downloadDriveFile(
   token: string,
   nodeId: number,
   options?: { inline?: boolean }
 ): Promise<Blob> {
   const q = options?.inline ? '?inline=1' : ''
-  const res = await apiFetch(token, `/drive/nodes/${nodeId}/content${q}`, { json: false })
+  const res = await apiFetch(token, `/drive/nodes/${nodeId}/content${q}`, { json: false, headers: lockedAccessHeaders() })
   if (!res.ok) throw new Error(`Download: ${res.status}`)
   const blob = await res.blob()
   const hdr = res.headers.get('Content-Type')?.split(';')[0]?.trim()
@@ -1984,8 +2786,9 @@ export async function downloadDriveThumbnail(
   nodeId: number,
   size = 360
 ): Promise<Blob> {
-  const res = await apiFetch(token, `/drive/nodes/${nodeId}/thumbnail?size=${encodeURIComponent(String(size))}`, {
+  const res = await apiFetch(token, `/drive/nodes/${nodeId}/thumbnail?size=${encodeURIComponent(String(size))}&format=webp`, {
     json: false,
+    headers: lockedAccessHeaders(),
   })
   if (!res.ok) throw new Error(`Thumbnail: ${res.status}`)
   const blob = await res.blob()
@@ -1996,6 +2799,17 @@ export async function downloadDriveThumbnail(
   return blob
 }
 
+/** URL de lecture signée (Range) pour <video> / <audio>, qui ne peuvent pas envoyer le JWT. */
+export async function fetchDriveStreamUrl(token: string, nodeId: number): Promise<string> {
+  const res = await apiJson<{ url: string; expires_at: string }>(
+    token,
+    `/drive/nodes/${nodeId}/stream`,
+    { json: false, headers: lockedAccessHeaders() },
+    'Drive stream'
+  )
+  return apiUrl(res.url)
+}
+
 /** Télécharge un dossier entier en ZIP (pas de .zip dans l’UI, juste « Télécharger »). */
 export async function downloadDriveFolderAsZip(
   token: string,
@@ -2006,9 +2820,80 @@ export async function downloadDriveFolderAsZip(
   return res.blob()
 }
 
+export type DriveExportJob = {
+  id: string
+  name: string
+  status: 'pending' | 'running' | 'ready' | 'failed'
+  notify: boolean
+  total_files: number
+  done_files: number
+  total_bytes: number
+  done_bytes: number
+  size_bytes: number
+  progress: number
+  error?: string
+  created_at: string
+  finished_at?: string
+  expires_at: string
+}
+
+/** Crée un export ZIP asynchrone (dossiers récursifs, fichiers directs) ; le serveur l'écrit en arrière-plan. */
+export async function createDriveExport(
+  token: string,
+  nodeIds: number[],
+  opts?: { notify?: boolean }
+): Promise<DriveExportJob> {
+  const res = await apiFetch(token, '/drive/exports', {
+    method: 'POST',
+    body: JSON.stringify({ node_ids: nodeIds, notify: Boolean(opts?.notify) }),
+  })
+  if (res.status === 429) throw new Error('Trop d’exports en cours, réessayez dans un instant')
+  if (!res.ok) throw new Error(`Export: ${res.status}`)
+  return res.json()
+}
+
+export async function fetchDriveExport(token: string, exportId: string): Promise<DriveExportJob> {
+  return apiJson<DriveExportJob>(token, `/drive/exports/${encodeURIComponent(exportId)}`, { json: false }, 'Export')
+}
+
+export async function downloadDriveExport(token: string, exportId: string): Promise<Blob> {
+  const res = await apiFetch(token, `/drive/exports/${encodeURIComponent(exportId)}/download`, { json: false })
+  if (!res.ok) throw new Error(`Export download: ${res.status}`)
+  return res.blob()
+}
+
+export async function deleteDriveExport(token: string, exportId: string): Promise<void> {
+  const res = await apiFetch(token, `/drive/exports/${encodeURIComponent(exportId)}`, { method: 'DELETE' })
+  if (!res.ok && res.status !== 404) throw new Error(`Export delete: ${res.status}`)
+}
+
+/**
+ * Exporte des nœuds en ZIP via un job serveur (pas de délai de requête pour les gros dossiers) :
+ * création, suivi de la progression puis téléchargement ; le job est supprimé une fois récupéré.
+ */
+export async function exportDriveNodesAsZip(
+  token: string,
+  nodeIds: number[],
+  onProgress?: (percent: number) => void,
+  opts?: { notify?: boolean; pollMs?: number }
+): Promise<{ blob: Blob; filename: string }> {
+  let job = await createDriveExport(token, nodeIds, { notify: opts?.notify })
+  const pollMs = opts?.pollMs ?? 1000
+  while (job.status === 'pending' || job.status === 'running') {
+    onProgress?.(job.progress)
+    await new Promise((resolve) => setTimeout(resolve, pollMs))
+    job = await fetchDriveExport(token, job.id)
+  }
+  if (job.status === 'failed') throw new Error(job.error || 'Export échoué')
+  onProgress?.(100)
+  const blob = await downloadDriveExport(token, job.id)
+  void deleteDriveExport(token, job.id).catch(() => {})
+  return { blob, filename: job.name }
+}
+
 export type DriveZipEntry = { path: string; name: string; size: number; is_dir: boolean }
 
-/** Liste les entrées d'un fichier ZIP (sans extraire). */
+/** Liste les entrées d'une archive ZIP, tar, tar.gz ou tar.zst (sans extraire). */
 export async function fetchDriveZipEntries(
   token: string,
   nodeId: number
@@ -2022,6 +2907,51 @@ export async function fetchDriveZipEntries(
   return Array.isArray(data.entries) ? (data.entries as DriveZipEntry[]) : []
 }
 
+/** Contenu d'une seule entrée d'archive (aperçu sans extraire). */
+export async function fetchDriveArchiveEntry(
+  token: string,
+  nodeId: number,
+  path: string
+): Promise<Blob> {
+  const res = await apiFetch(
+    token,
+    `/drive/nodes/${nodeId}/archive/entry?path=${encodeURIComponent(path)}`,
+    { json: false }
+  )
+  if (!res.ok) throw new Error(`Archive entry: ${res.status}`)
+  return res.blob()
+}
+
+export type DriveArchiveExtractResult = {
+  folder_id: number
+  name: string
+  format: string
+  files: number
+  folders: number
+  bytes: number
+  skipped: number
+}
+
+/** Extrait l'archive (ZIP, tar, tar.gz, tar.zst) dans un nouveau dossier, à côté de l'archive par défaut. */
+export async function extractDriveArchive(
+  token: string,
+  nodeId: number,
+  parentId?: number | null
+): Promise<DriveArchiveExtractResult> {
+  const res = await apiFetch(token, `/drive/nodes/${nodeId}/archive/extract`, {
+    method: 'POST',
+    body: JSON.stringify(parentId != null ? { parent_id: parentId } : {}),
+  })
+  if (!res.ok) {
+    const j = (await res.json().catch(() => ({}))) as { code?: string; message?: string }
+    if (j.code === 'QUOTA_EXCEEDED') throw new Error(j.message || 'Espace de stockage insuffisant')
+    if (j.code === 'ARCHIVE_LIMITS') throw new Error('Archive trop volumineuse pour être extraite')
+    if (j.code === 'UNSUPPORTED_ARCHIVE') throw new Error("Format d'archive non pris en charge")
+    throw new Error(`Extraction: ${res.status}`)
+  }
+  return res.json()
+}
+
 /** Crée une archive ZIP à partir des nœuds sélectionnés (fichiers + dossiers). */
 export async function downloadDriveArchive(
   token: string,
@@ -31,9 +31,12 @@ vi.mock('../../../api', () => ({
   deleteDriveNode: vi.fn(),
   restoreDriveNode: vi.fn(),
   purgeDriveNode: vi.fn(),
+  emptyDriveTrash: vi.fn(),
   downloadDriveFile: vi.fn(),
   downloadDriveFolderAsZip: vi.fn(),
   downloadDriveArchive: vi.fn(),
+  extractDriveArchive: vi.fn(),
+  exportDriveNodesAsZip: vi.fn(),
   getDriveNodeContentAsText: vi.fn().mockResolvedValue(''),
   uploadDriveFile: vi.fn(),
   uploadDriveFileWithProgress: vi.fn().mockResolvedValue({ id: 1, name: 'f', size: 0 }),
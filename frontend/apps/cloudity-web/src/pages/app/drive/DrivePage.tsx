@@ -69,10 +69,11 @@ import {
   deleteDriveNode,
   restoreDriveNode,
   purgeDriveNode,
+  emptyDriveTrash,
   downloadDriveFile,
-  downloadDriveFolderAsZip,
   fetchDriveZipEntries,
-  downloadDriveArchive,
+  extractDriveArchive,
+  exportDriveNodesAsZip,
   getDriveNodeContentAsText,
   moveDriveNode,
   type DriveNode,
@@ -424,9 +425,26 @@ function isPdfNode(node: DriveNode): boolean {
   return mime === 'application/pdf'
 }
 
+/** Export ZIP long terminé onglet masqué : notification système si déjà autorisée (jamais de demande ici). */
+function notifyDesktopExportReady<T extends { filename: string }>(result: T): T {
+  if (
+    typeof document !== 'undefined' &&
+    document.visibilityState === 'hidden' &&
+    typeof globalThis.Notification !== 'undefined' &&
+    globalThis.Notification.permission === 'granted'
+  ) {
+    new globalThis.Notification('Export Drive prêt', { body: result.filename })
+  }
+  return result
+}
+
+const ARCHIVE_PREVIEW_SUFFIXES = ['.zip', '.tar', '.tgz', '.tar.gz', '.tzst', '.tar.zst']
+/** Archives listables / extractibles côté serveur (7z, rar : non pris en charge). */
 function isZipNode(node: DriveNode): boolean {
-  const ext = getExtension(node.name)
-  return ext === '.zip' || (node.mime_type || '').toLowerCase() === 'application/zip'
+  const lower = node.name.toLowerCase()
+  if (ARCHIVE_PREVIEW_SUFFIXES.some((s) => lower.endsWith(s))) return true
+  const mime = (node.mime_type || '').toLowerCase()
+  return mime === 'application/zip' || mime === 'application/x-tar' || mime === 'application/zstd'
 }
 
 const VIDEO_PREVIEW_EXTENSIONS = ['.mp4', '.webm', '.ogv', '.mov']
@@ -505,10 +523,13 @@ function FilePreviewContent({
   node,
   accessToken,
   previewEditorState,
+  onExtract,
 }: {
   node: DriveNode
   accessToken: string | null
   previewEditorState?: EditorFromState
+  /** Archive : « Extraire ici » (absent si l'utilisateur ne peut pas écrire à côté). */
+  onExtract?: () => void
 }) {
   const [status, setStatus] = useState<'idle' | 'loading' | 'ok' | 'error'>('idle')
   const [blobUrl, setBlobUrl] = useState<string | null>(null)
@@ -712,14 +733,25 @@ function FilePreviewContent({
               </li>
             ))}
           </ul>
-          <p className="text-xs text-slate-500 dark:text-slate-400 mt-2">Aucune extraction. Utilisez Télécharger pour récupérer l'archive.</p>
+          {onExtract ? (
+            <button
+              type="button"
+              onClick={onExtract}
+              className="mt-3 inline-flex items-center gap-2 rounded-lg bg-brand-600 dark:bg-brand-500 px-3 py-2 text-sm font-medium text-white hover:bg-brand-700 dark:hover:bg-brand-600"
+            >
+              <Folder className="h-4 w-4 shrink-0" aria-hidden />
+              Extraire ici
+            </button>
+          ) : (
+            <p className="text-xs text-slate-500 dark:text-slate-400 mt-2">Aucune extraction. Utilisez Télécharger pour récupérer l'archive.</p>
+          )}
         </div>
       )
     }
     if (zipStatus === 'ok' && zipEntries && zipEntries.length === 0) {
       return (
         <div className="mt-4 rounded-lg border border-slate-200 dark:border-slate-600 bg-slate-50 dark:bg-slate-800/50 p-6 text-center">
-          <p className="text-sm text-slate-600 dark:text-slate-300">Archive ZIP vide.</p>
+          <p className="text-sm text-slate-600 dark:text-slate-300">Archive vide.</p>
         </div>
       )
     }
@@ -1382,7 +1414,7 @@ const DriveNodeRow = React.memo(function DriveNodeRow({
       <td className="py-2 px-2 align-middle text-sm text-slate-500 dark:text-slate-400 whitespace-nowrap w-32" title={formatFullDate(node.updated_at)}>
         {formatRelativeDateWithTime(node.updated_at)}
       </td>
-      <td className="py-2 px-2 align-middle text-sm text-slate-500 dark:text-slate-400 whitespace-nowrap w-32" title={isTrashView && node.deleted_at ? formatFullDate(node.deleted_at) : formatFullDate(node.updated_at)}>
+      <td className="py-2 px-2 align-middle text-sm text-slate-500 dark:text-slate-400 whitespace-nowrap w-32" title={isTrashView && node.deleted_at ? `${formatFullDate(node.deleted_at)}${node.purge_at ? ` — suppression définitive le ${formatFullDate(node.purge_at)}` : ''}` : formatFullDate(node.updated_at)}>
         {isTrashView && node.deleted_at ? formatRelativeDate(node.deleted_at) : formatRelativeDateWithTime(node.updated_at)}
       </td>
       <td className="py-2 pr-3 pl-1 align-middle w-28">
@@ -1507,6 +1539,7 @@ const DriveToolbar = React.memo(function DriveToolbar({
   onDisplayModeChange,
   onOpenSettings,
   localVaultActive,
+  onEmptyTrash,
 }: {
   viewMode: 'drive' | 'trash' | 'recent'
   onViewModeChange: (v: 'drive' | 'trash' | 'recent') => void
@@ -1528,6 +1561,7 @@ const DriveToolbar = React.memo(function DriveToolbar({
   onDisplayModeChange?: (v: 'grid' | 'list') => void
   onOpenSettings?: () => void
   localVaultActive?: boolean
+  onEmptyTrash?: () => void
 }) {
   const showRootDropZone = viewMode === 'drive' && breadcrumb.length > 1 && onDragOverBreadcrumbRoot && onDropOnBreadcrumbRoot
   return (
@@ -1598,7 +1632,16 @@ const DriveToolbar = React.memo(function DriveToolbar({
         )}
         {viewMode === 'trash' && (
           <p className="mt-1 text-sm text-slate-500 dark:text-slate-400">
-            Fichiers et dossiers supprimés — restaurez ou supprimez définitivement.
+            Fichiers et dossiers supprimés — restaurez ou supprimez définitivement. Les éléments sont purgés automatiquement après la période de rétention (date au survol).
+            {onEmptyTrash && (
+              <button
+                type="button"
+                onClick={onEmptyTrash}
+                className="ml-2 font-medium text-red-600 dark:text-red-400 hover:underline"
+              >
+                Vider la corbeille
+              </button>
+            )}
           </p>
         )}
         {viewMode === 'recent' && (
@@ -1842,6 +1885,7 @@ export default function DrivePage() {
   type DeleteModalTarget = { type: 'single'; node: DriveNode } | { type: 'bulk'; ids: number[] } | null
   const [deleteModalTarget, setDeleteModalTarget] = useState<DeleteModalTarget>(null)
   const [purgeModalTarget, setPurgeModalTarget] = useState<DriveNode | null>(null)
+  const [showEmptyTrashModal, setShowEmptyTrashModal] = useState(false)
   const [previewNode, setPreviewNode] = useState<DriveNode | null>(null)
   /** Horodatage d’ouverture de la modale d’aperçu (ignore fermeture « fond » trop rapide après double-clic). */
   const previewOpenedAtRef = React.useRef(0)
@@ -2229,12 +2273,9 @@ export default function DrivePage() {
     (node: DriveNode) => {
       if (!accessToken) return
       const label = node.is_folder ? `${node.name.replace(/\.zip$/i, '')}.zip` : node.name
-      registerDownload(label, () =>
+      registerDownload(label, (onProgress) =>
         node.is_folder
-          ? downloadDriveFolderAsZip(accessToken, node.id).then((blob) => ({
-              blob,
-              filename: `${node.name.replace(/\.zip$/i, '')}.zip`,
-            }))
+          ? exportDriveNodesAsZip(accessToken, [node.id], onProgress, { notify: true }).then(notifyDesktopExportReady)
           : downloadDriveFile(accessToken, node.id).then((blob) => ({ blob, filename: node.name }))
       )
     },
@@ -2244,8 +2285,8 @@ export default function DrivePage() {
   const handleDownloadSelectionAsZip = useCallback(() => {
     if (!accessToken || selectedIds.size === 0) return
     const ids = Array.from(selectedIds)
-    registerDownload('archive.zip', () =>
-      downloadDriveArchive(accessToken, ids).then((blob) => ({ blob, filename: 'archive.zip' }))
+    registerDownload('archive.zip', (onProgress) =>
+      exportDriveNodesAsZip(accessToken, ids, onProgress, { notify: true }).then(notifyDesktopExportReady)
     )
   }, [accessToken, selectedIds, registerDownload])
 
@@ -2378,6 +2419,23 @@ export default function DrivePage() {
     [accessToken, queryClient]
   )
 
+  const handleExtractArchive = useCallback(
+    (node: DriveNode) => {
+      if (!accessToken) return
+      const toastId = toast.loading(`Extraction de « ${node.name} »…`)
+      extractDriveArchive(accessToken, node.id)
+        .then((r) => {
+          const skipped = r.skipped > 0 ? ` (${r.skipped} entrée(s) ignorée(s))` : ''
+          toast.success(`Extrait dans « ${r.name} » : ${r.files} fichier(s)${skipped}`, { id: toastId })
+          setPreviewNode(null)
+          queryClient.invalidateQueries({ queryKey: ['drive', 'nodes'] })
+          queryClient.invalidateQueries({ queryKey: ['drive', 'recent'] })
+        })
+        .catch((e) => toast.error(e instanceof Error ? e.message : 'Erreur', { id: toastId }))
+    },
+    [accessToken, queryClient]
+  )
+
   const handlePurgeClick = useCallback((node: DriveNode) => setPurgeModalTarget(node), [])
   const confirmPurge = useCallback(() => {
     if (!accessToken || !purgeModalTarget) return
@@ -2391,6 +2449,18 @@ export default function DrivePage() {
         toast.error(e instanceof Error ? e.message : 'Erreur')
       })
   }, [accessToken, purgeModalTarget, queryClient])
+  const confirmEmptyTrash = useCallback(() => {
+    if (!accessToken) return
+    emptyDriveTrash(accessToken)
+      .then((r) => {
+        toast.success(r.purged > 0 ? `Corbeille vidée (${formatFileSize(r.freed_bytes)} libérés)` : 'Corbeille déjà vide')
+        setShowEmptyTrashModal(false)
+        queryClient.invalidateQueries({ queryKey: ['drive', 'trash'] })
+      })
+      .catch((e) => {
+        toast.error(e instanceof Error ? e.message : 'Erreur')
+      })
+  }, [accessToken, queryClient])
   /** Ouvrir le formulaire « Nouveau dossier » au prochain tick pour ne pas bloquer le clic (Chromium). */
   const openNewFolderForm = useCallback(() => {
     setTimeout(() => {
@@ -2643,6 +2713,35 @@ export default function DrivePage() {
           </div>
         </div>
       )}
+      {/* Modal de confirmation : vider la corbeille */}
+      {showEmptyTrashModal && (
+        <div className="fixed inset-0 z-50 flex items-center justify-center p-4 bg-black/50" role="dialog" aria-modal="true" aria-labelledby="empty-trash-modal-title">
+          <div className="bg-white dark:bg-slate-800 rounded-xl shadow-xl max-w-md w-full p-6 border border-slate-200 dark:border-slate-600">
+            <h2 id="empty-trash-modal-title" className="text-lg font-semibold text-slate-900 dark:text-slate-100">
+              Vider la corbeille ?
+            </h2>
+            <p className="mt-2 text-sm text-slate-600 dark:text-slate-300">
+              Tous les éléments de la corbeille seront supprimés définitivement. Cette action est irréversible.
+            </p>
+            <div className="mt-6 flex justify-end gap-3">
+              <button
+                type="button"
+                onClick={() => setShowEmptyTrashModal(false)}
+                className="px-4 py-2 text-sm font-medium text-slate-700 dark:text-slate-300 bg-slate-100 dark:bg-slate-700 rounded-lg hover:bg-slate-200 dark:hover:bg-slate-600"
+              >
+                Annuler
+              </button>
+              <button
+                type="button"
+                onClick={confirmEmptyTrash}
+                className="px-4 py-2 text-sm font-medium text-white bg-red-600 dark:bg-red-500 rounded-lg hover:bg-red-700 dark:hover:bg-red-600"
+              >
+                Vider la corbeille
+              </button>
+            </div>
+          </div>
+        </div>
+      )}
       {/* Popup d’aperçu fichier (clic sur une ligne fichier) */}
       {previewNode != null && !previewNode.is_folder && (
         <div className="fixed inset-0 z-50 flex items-center justify-center p-4 bg-black/50" role="dialog" aria-modal="true" aria-labelledby="preview-modal-title" onClick={closeDrivePreviewBackdrop}>
@@ -2671,6 +2770,7 @@ export default function DrivePage() {
                       ? { from: 'drive', parentId: null, breadcrumb: [{ id: null, name: 'Drive' }] }
                       : undefined
                 }
+                onExtract={viewMode === 'drive' || viewMode === 'recent' ? () => handleExtractArchive(previewNode) : undefined}
               />
             </div>
             <div className="p-6 pt-0 flex flex-wrap gap-2 shrink-0">
@@ -2771,6 +2871,7 @@ export default function DrivePage() {
           setShowDriveSettings(true)
         }}
         localVaultActive={driveVaultRequired && driveVaultUnlocked}
+        onEmptyTrash={(trashData?.length ?? 0) > 0 ? () => setShowEmptyTrashModal(true) : undefined}
       />
 
       {driveVaultRequired && driveVaultUnlocked ? (
@@ -25,6 +25,8 @@ vi.mock('../../../api', () => ({
   deleteMailMessagePermanently: vi.fn().mockResolvedValue({ ok: true }),
   syncMailAccount: vi.fn(),
   sendMailMessage: vi.fn(),
+  saveMailDraft: vi.fn(),
+  fetchMailDraft: vi.fn(),
   getMailGoogleOAuthRedirectUrl: vi.fn(),
   getMailGoogleOAuthStatus: vi.fn().mockResolvedValue({ enabled: true }),
   fetchContacts: vi.fn().mockResolvedValue([]),
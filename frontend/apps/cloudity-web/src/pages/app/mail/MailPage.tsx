@@ -26,6 +26,7 @@ import {
   Square,
   MailOpen,
   ShieldAlert,
+  ShieldCheck,
   KeyRound,
   MessagesSquare,
   Download,
@@ -97,6 +98,8 @@ import {
   syncMailAccount,
   sendMailMessage,
   scheduleMailMessage,
+  fetchMailDraft,
+  saveMailDraft,
   getMailGoogleOAuthRedirectUrl,
   getMailGoogleOAuthStatus,
   fetchContacts,
@@ -133,6 +136,7 @@ import {
   type MailImapFolderRow,
   type MailFilterRuleResponse,
   type MailAccountAliasResponse,
+  type MailComposePayload,
   type VaultResponse,
   type PassItemResponse,
 } from '../../../api'
@@ -638,10 +642,22 @@ export type ComposeSlot = {
   /** Compte d’envoi (réponse depuis une autre boîte, ex. vue unifiée). */
   sendAccountId?: number
   to: string
+  /** Copie / copie cachée : « a@x, b@y ». */
+  cc?: string
+  bcc?: string
   subject: string
   body: string
   minimized: boolean
   attachments: AttachmentFromDrive[]
+  /** Réponse : message d’origine (le serveur pose In-Reply-To / References). */
+  replyToMessageId?: number
+  /** Brouillon serveur (enregistré automatiquement, déposé dans Brouillons IMAP). */
+  draftId?: number
+  /** Pièces jointes déjà stockées dans un brouillon rouvert. */
+  draftAttachments?: { id: number; name: string; size: number }[]
+  /** Fil repris d’un brouillon rouvert (en-têtes déjà calculés côté serveur). */
+  inReplyTo?: string
+  references?: string
   /** Position horizontale depuis la droite (desktop), pour glisser la fenêtre en bas. */
   xOffsetPx: number
   /** Date/heure d'envoi programmé (datetime-local) — appliquée avant confirmation d'envoi. */
@@ -655,6 +671,25 @@ function mailMessageDisplayFingerprint(m: { account_id: number; folder?: string;
   return `${m.account_id}|${(m.folder ?? '').toLowerCase()}|${from}|${subject}|${d}`
 }
 
+/** Corps d’envoi d’une fenêtre de rédaction : HTML (texte alternatif généré côté serveur), Cc/Bcc, fichiers Drive. */
+function composeSlotPayload(slot: ComposeSlot, accountId: number, html: string): MailComposePayload {
+  return {
+    account_id: accountId,
+    to: slot.to.trim(),
+    cc: slot.cc?.trim() || undefined,
+    bcc: slot.bcc?.trim() || undefined,
+    subject: slot.subject,
+    html,
+    from_email: slot.fromAddress.trim() || undefined,
+    reply_to_message_id: slot.replyToMessageId,
+    in_reply_to: slot.inReplyTo || undefined,
+    references: slot.references || undefined,
+    drive_node_ids: slot.attachments.length > 0 ? slot.attachments.map((a) => a.nodeId) : undefined,
+    draft_id: slot.draftId,
+    draft_attachment_ids: slot.draftAttachments?.length ? slot.draftAttachments.map((a) => a.id) : undefined,
+  }
+}
+
 function escapeHtml(s: string): string {
   return s
     .replaceAll('&', '&amp;')
@@ -1224,6 +1259,7 @@ export default function MailPage() {
   const [editAccImapPort, setEditAccImapPort] = useState('')
   const [editAccSmtpHost, setEditAccSmtpHost] = useState('')
   const [editAccSmtpPort, setEditAccSmtpPort] = useState('')
+  const [editAccSentCopy, setEditAccSentCopy] = useState<'auto' | 'on' | 'off'>('auto')
   const [savingAccount, setSavingAccount] = useState(false)
   const [recipientAliasFilter, setRecipientAliasFilter] = useState<string | null>(null)
   /** Adresse « De » préférée après clic sur un alias dans la barre latérale. */
@@ -2185,7 +2221,21 @@ export default function MailPage() {
   const activeSlot = composeSlots.find((s) => s.id === activeComposeId) ?? composeSlots[composeSlots.length - 1] ?? null
 
   const openNewCompose = useCallback(
-    (initial?: { to?: string; subject?: string; body?: string; fromAddress?: string; accountId?: number; title?: string }) => {
+    (initial?: {
+      to?: string
+      cc?: string
+      subject?: string
+      body?: string
+      fromAddress?: string
+      accountId?: number
+      title?: string
+      replyToMessageId?: number
+      bcc?: string
+      draftId?: number
+      draftAttachments?: { id: number; name: string; size: number }[]
+      inReplyTo?: string
+      references?: string
+    }) => {
       const composeAccountId = initial?.accountId ?? effectiveAccountId
       const primary = accounts.find((a) => a.id === composeAccountId)?.email ?? ''
       const allowedFrom = new Set<string>()
@@ -2216,10 +2266,17 @@ export default function MailPage() {
           fromAddress,
           sendAccountId: initial?.accountId,
           to: initial?.to ?? draft?.to ?? '',
+          cc: initial?.cc,
+          bcc: initial?.bcc,
           subject: initial?.subject ?? draft?.subject ?? '',
           body: initial?.body ?? draft?.body ?? '',
           minimized: false,
           attachments: [],
+          replyToMessageId: initial?.replyToMessageId,
+          draftId: initial?.draftId,
+          draftAttachments: initial?.draftAttachments,
+          inReplyTo: initial?.inReplyTo,
+          references: initial?.references,
           xOffsetPx: 24 + (prev.length % 5) * 28,
         }
         setActiveComposeId(id)
@@ -2259,7 +2316,7 @@ export default function MailPage() {
   )
 
   const updateSlot = useCallback(
-    (id: string, patch: Partial<Pick<ComposeSlot, 'fromAddress' | 'to' | 'subject' | 'body' | 'minimized'>>) => {
+    (id: string, patch: Partial<Pick<ComposeSlot, 'fromAddress' | 'to' | 'cc' | 'bcc' | 'subject' | 'body' | 'minimized' | 'scheduledSendAtLocal' | 'draftAttachments'>>) => {
       setComposeSlots((prev) => prev.map((s) => (s.id === id ? { ...s, ...patch } : s)))
     },
     []
@@ -2312,6 +2369,42 @@ export default function MailPage() {
     activeSlot?.fromAddress,
   ])
 
+  // Brouillon serveur (dossier Brouillons IMAP) : enregistré 5 s après la dernière modification.
+  const draftSaveInFlightRef = useRef<Set<string>>(new Set())
+  useEffect(() => {
+    const slot = activeSlot
+    const draftAcc = slot?.sendAccountId ?? effectiveAccountId
+    if (!slot || draftAcc == null || !accessToken) return
+    if (!slot.to.trim() && !slot.subject.trim() && !slot.body.trim() && slot.attachments.length === 0) return
+    const t = setTimeout(() => {
+      if (draftSaveInFlightRef.current.has(slot.id)) return
+      draftSaveInFlightRef.current.add(slot.id)
+      saveMailDraft(accessToken, draftAcc, slot.draftId ?? null, composeSlotPayload(slot, draftAcc, slot.body))
+        .then((saved) => {
+          setComposeSlots((prev) => prev.map((s) => (s.id === slot.id ? { ...s, draftId: saved.id } : s)))
+          void queryClient.invalidateQueries({ queryKey: ['mail', 'folder-summary'] })
+        })
+        .catch(() => {
+          /* brouillon local (localStorage) conservé ; nouvel essai à la prochaine modification */
+        })
+        .finally(() => draftSaveInFlightRef.current.delete(slot.id))
+    }, 5000)
+    return () => clearTimeout(t)
+  }, [
+    activeSlot?.id,
+    activeSlot?.sendAccountId,
+    effectiveAccountId,
+    accessToken,
+    activeSlot?.to,
+    activeSlot?.cc,
+    activeSlot?.bcc,
+    activeSlot?.subject,
+    activeSlot?.body,
+    activeSlot?.fromAddress,
+    activeSlot?.attachments.length,
+    activeSlot?.draftAttachments?.length,
+  ])
+
   useEffect(() => {
     const onPointerMove = (e: PointerEvent) => {
       const drag = composeDragRef.current
@@ -3435,6 +3528,7 @@ export default function MailPage() {
       setEditAccImapPort(acc?.imap_port != null ? String(acc.imap_port) : '')
       setEditAccSmtpHost(acc?.smtp_host ?? '')
       setEditAccSmtpPort(acc?.smtp_port != null ? String(acc.smtp_port) : '')
+      setEditAccSentCopy(acc?.save_sent_copy == null ? 'auto' : acc.save_sent_copy ? 'on' : 'off')
       setShowEditAccountModal(true)
     },
     [accounts]
@@ -3518,6 +3612,10 @@ export default function MailPage() {
           patch.smtp_host = ''
         }
       }
+      const prevSentCopy = acc?.save_sent_copy == null ? 'auto' : acc.save_sent_copy ? 'on' : 'off'
+      if (editAccSentCopy !== prevSentCopy) {
+        patch.save_sent_copy = editAccSentCopy === 'auto' ? null : editAccSentCopy === 'on'
+      }
       if (Object.keys(patch).length === 0) {
         toast.error('Modifiez au moins le libellé, le mot de passe ou les serveurs IMAP/SMTP.')
         setSavingAccount(false)
@@ -3542,6 +3640,7 @@ export default function MailPage() {
     editAccImapPort,
     editAccSmtpHost,
     editAccSmtpPort,
+    editAccSentCopy,
     accounts,
     queryClient,
     closeEditAccountModal,
@@ -3782,6 +3881,7 @@ export default function MailPage() {
       subject: subj.startsWith('Re:') ? subj : `Re: ${subj}`,
       body: quoted,
       accountId: selectedMessageDetail.account_id,
+      replyToMessageId: selectedMessageDetail.id,
     })
   }, [selectedMessageDetail, openNewCompose])
 
@@ -3807,6 +3907,7 @@ export default function MailPage() {
       subject: subj.startsWith('Re:') ? subj : `Re: ${subj}`,
       body: quoted,
       accountId: replyAcc,
+      replyToMessageId: selectedMessageDetail.id,
     })
   }, [selectedMessageDetail, accounts, openNewCompose])
 
@@ -3826,6 +3927,29 @@ export default function MailPage() {
     })
   }, [selectedMessageDetail, openNewCompose])
 
+  const handleEditDraft = useCallback(async () => {
+    if (!selectedMessageDetail || !accessToken) return
+    try {
+      const d = await fetchMailDraft(accessToken, selectedMessageDetail.account_id, selectedMessageDetail.id)
+      openNewCompose({
+        to: d.to,
+        cc: d.cc,
+        bcc: d.bcc,
+        subject: d.subject,
+        body: d.html || plainTextToHtml(d.text),
+        fromAddress: d.from_email,
+        accountId: d.account_id,
+        title: 'Brouillon',
+        draftId: d.id,
+        draftAttachments: d.attachments.map((a) => ({ id: a.id, name: a.filename, size: a.size_bytes })),
+        inReplyTo: d.in_reply_to,
+        references: d.references,
+      })
+    } catch (e) {
+      toast.error(e instanceof Error ? e.message : 'Impossible d’ouvrir le brouillon')
+    }
+  }, [selectedMessageDetail, accessToken, openNewCompose])
+
   const handleSendMessage = useCallback(
     async (slotId?: string) => {
       const slot = slotId ? composeSlots.find((s) => s.id === slotId) : activeSlot
@@ -3847,19 +3971,7 @@ export default function MailPage() {
         if (sig && !body.trim().endsWith(sig.trim())) {
           body = body.trimEnd() + (body.trim() ? '<br><br>' : '') + plainTextToHtml(sig)
         }
-        if (slot.attachments.length > 0) {
-          body += '<br><br>--- Pièces jointes (Drive) ---<br>'
-          slot.attachments.forEach((a) => {
-            body += `• ${escapeHtml(a.name)} (${(a.size / 1024).toFixed(1)} Ko) — lien de téléchargement à configurer côté serveur<br>`
-          })
-        }
-        await sendMailMessage(accessToken, {
-          account_id: sendAcc,
-          to: slot.to.trim(),
-          subject: slot.subject,
-          body,
-          from_email: slot.fromAddress.trim() || undefined,
-        })
+        await sendMailMessage(accessToken, composeSlotPayload(slot, sendAcc, body))
         toast.success('Message envoyé')
         void runMailSyncBatch([sendAcc], { force: true })
         void queryClient.invalidateQueries({ queryKey: ['mail', 'folder-summary'] })
@@ -3909,14 +4021,7 @@ export default function MailPage() {
         if (sig && !body.trim().endsWith(sig.trim())) {
           body = body.trimEnd() + (body.trim() ? '<br><br>' : '') + plainTextToHtml(sig)
         }
-        await scheduleMailMessage(accessToken, {
-          account_id: sendAcc,
-          to: slot.to.trim(),
-          subject: slot.subject,
-          body,
-          from_email: slot.fromAddress.trim() || undefined,
-          scheduled_send_at: utcIso,
-        })
+        await scheduleMailMessage(accessToken, { ...composeSlotPayload(slot, sendAcc, body), scheduled_send_at: utcIso })
         toast.success('Envoi programmé')
         void queryClient.invalidateQueries({ queryKey: ['mail', 'messages'] })
         void queryClient.invalidateQueries({ queryKey: ['mail', 'folder-summary'] })
@@ -5444,6 +5549,23 @@ export default function MailPage() {
                                         <span className="text-xs font-normal text-slate-500 dark:text-slate-400">{sl.secondary}</span>
                                       ) : null}
                                     </span>
+                                    {selectedMessageDetail.authentication?.trust === 'verified' ? (
+                                      <span
+                                        title={`Expéditeur authentifié (DMARC ${selectedMessageDetail.authentication.from_domain || ''})`}
+                                        className="ml-2 inline-flex items-center gap-1 align-top rounded px-1.5 py-0.5 text-[11px] font-medium bg-emerald-50 text-emerald-700 dark:bg-emerald-900/30 dark:text-emerald-300"
+                                      >
+                                        <ShieldCheck className="h-3.5 w-3.5" aria-hidden />
+                                        Vérifié
+                                      </span>
+                                    ) : selectedMessageDetail.authentication?.trust === 'suspicious' ? (
+                                      <span
+                                        title={`SPF ${selectedMessageDetail.authentication.spf}, DKIM ${selectedMessageDetail.authentication.dkim}, DMARC ${selectedMessageDetail.authentication.dmarc} — l’expéditeur affiché peut être usurpé.`}
+                                        className="ml-2 inline-flex items-center gap-1 align-top rounded px-1.5 py-0.5 text-[11px] font-medium bg-red-50 text-red-700 dark:bg-red-900/30 dark:text-red-300"
+                                      >
+                                        <ShieldAlert className="h-3.5 w-3.5" aria-hidden />
+                                        Non authentifié
+                                      </span>
+                                    ) : null}
                                   </p>
                                 )
                               })()}
@@ -5557,6 +5679,12 @@ export default function MailPage() {
                           </div>
                         ) : null}
                         <div className="flex flex-wrap items-center gap-2 px-4 pb-3 md:px-5">
+                          {activeFolder === 'drafts' ? (
+                            <button type="button" onClick={() => void handleEditDraft()} className="inline-flex items-center gap-2 rounded-lg px-3 py-2 text-sm font-medium bg-brand-600 dark:bg-brand-500 text-white hover:bg-brand-700 dark:hover:bg-brand-600 shadow-sm" title="Modifier le brouillon">
+                              <PenLine className="h-4 w-4" />
+                              Modifier le brouillon
+                            </button>
+                          ) : null}
                           <button type="button" onClick={handleReply} className="inline-flex items-center gap-2 rounded-lg px-3 py-2 text-sm font-medium bg-brand-600 dark:bg-brand-500 text-white hover:bg-brand-700 dark:hover:bg-brand-600 shadow-sm" title="Répondre">
                             <Reply className="h-4 w-4" />
                             Répondre
@@ -6644,10 +6772,22 @@ export default function MailPage() {
                   </div>
                 ) : null}
                 <div>
-                  <label htmlFor={`mail-to-${slot.id}`} className="block text-sm font-medium text-slate-700 dark:text-slate-300 mb-1">Destinataire</label>
+                  <div className="flex items-center justify-between mb-1">
+                    <label htmlFor={`mail-to-${slot.id}`} className="block text-sm font-medium text-slate-700 dark:text-slate-300">Destinataire</label>
+                    {slot.cc === undefined && slot.bcc === undefined ? (
+                      <button
+                        type="button"
+                        onClick={() => updateSlot(slot.id, { cc: '', bcc: '' })}
+                        className="text-xs font-medium text-brand-600 hover:text-brand-700 dark:text-brand-400"
+                      >
+                        Cc / Cci
+                      </button>
+                    ) : null}
+                  </div>
                   <input
                     id={`mail-to-${slot.id}`}
                     type="email"
+                    multiple
                     value={slot.to}
                     onChange={(e) => updateSlot(slot.id, { to: e.target.value })}
                     list="mail-recent-recipients"
@@ -6663,6 +6803,36 @@ export default function MailPage() {
                   ))}
                 </datalist>
               </div>
+                {slot.cc !== undefined || slot.bcc !== undefined ? (
+                  <>
+                    <div>
+                      <label htmlFor={`mail-cc-${slot.id}`} className="block text-sm font-medium text-slate-700 dark:text-slate-300 mb-1">Cc</label>
+                      <input
+                        id={`mail-cc-${slot.id}`}
+                        type="email"
+                        multiple
+                        value={slot.cc ?? ''}
+                        onChange={(e) => updateSlot(slot.id, { cc: e.target.value })}
+                        list="mail-recent-recipients"
+                        placeholder="copie@exemple.fr"
+                        className="w-full rounded-lg border border-slate-300 dark:border-slate-500 bg-white dark:bg-slate-700 px-3 py-2 text-slate-900 dark:text-slate-100 placeholder-slate-400 focus:ring-2 focus:ring-brand-500 focus:border-transparent"
+                      />
+                    </div>
+                    <div>
+                      <label htmlFor={`mail-bcc-${slot.id}`} className="block text-sm font-medium text-slate-700 dark:text-slate-300 mb-1">Cci</label>
+                      <input
+                        id={`mail-bcc-${slot.id}`}
+                        type="email"
+                        multiple
+                        value={slot.bcc ?? ''}
+                        onChange={(e) => updateSlot(slot.id, { bcc: e.target.value })}
+                        list="mail-recent-recipients"
+                        placeholder="copie cachée"
+                        className="w-full rounded-lg border border-slate-300 dark:border-slate-500 bg-white dark:bg-slate-700 px-3 py-2 text-slate-900 dark:text-slate-100 placeholder-slate-400 focus:ring-2 focus:ring-brand-500 focus:border-transparent"
+                      />
+                    </div>
+                  </>
+                ) : null}
                 <div>
                   <label htmlFor={`mail-subject-${slot.id}`} className="block text-sm font-medium text-slate-700 dark:text-slate-300 mb-1">Objet</label>
                   <input
@@ -6701,6 +6871,25 @@ export default function MailPage() {
                       ))}
                     </div>
                   )}
+                  {slot.draftAttachments && slot.draftAttachments.length > 0 && (
+                    <div className="flex flex-wrap gap-2 mb-2">
+                      {slot.draftAttachments.map((a) => (
+                        <span
+                          key={`draft-${a.id}`}
+                          className="inline-flex items-center gap-1 rounded-full bg-slate-100 dark:bg-slate-700 px-2 py-1 text-xs text-slate-700 dark:text-slate-300"
+                        >
+                          {a.name}
+                          <button
+                            type="button"
+                            onClick={() => updateSlot(slot.id, { draftAttachments: slot.draftAttachments?.filter((x) => x.id !== a.id) })}
+                            className="rounded-full hover:bg-slate-200 dark:hover:bg-slate-600 p-0.5"
+                          >
+                            <X className="h-3 w-3" />
+                          </button>
+                        </span>
+                      ))}
+                    </div>
+                  )}
                   <div className="mb-2 flex flex-wrap items-center gap-1 rounded-lg border border-slate-200 dark:border-slate-600 bg-slate-50 dark:bg-slate-900/30 p-1">
                     <button type="button" onClick={() => document.execCommand('bold')} className="rounded px-2 py-1 text-xs text-slate-700 dark:text-slate-200 hover:bg-slate-200 dark:hover:bg-slate-700" title="Gras"><Bold className="h-3.5 w-3.5" /></button>
                     <button type="button" onClick={() => document.execCommand('italic')} className="rounded px-2 py-1 text-xs text-slate-700 dark:text-slate-200 hover:bg-slate-200 dark:hover:bg-slate-700" title="Italique"><Italic className="h-3.5 w-3.5" /></button>
@@ -7181,6 +7370,22 @@ export default function MailPage() {
                   </div>
                 </div>
               </div>
+
+              <div>
+                <label htmlFor="mail-edit-sent-copy" className="block text-xs font-medium text-slate-500 dark:text-slate-400 mb-2">
+                  Copie des messages envoyés dans « Envoyés » (IMAP)
+                </label>
+                <select
+                  id="mail-edit-sent-copy"
+                  value={editAccSentCopy}
+                  onChange={(e) => setEditAccSentCopy(e.target.value as 'auto' | 'on' | 'off')}
+                  className="w-full rounded-lg border border-slate-300 dark:border-slate-500 bg-white dark:bg-slate-700 px-3 py-2 text-slate-900 dark:text-slate-100 focus:ring-2 focus:ring-brand-500"
+                >
+                  <option value="auto">Automatique (sauf Gmail, qui range déjà les envois)</option>
+                  <option value="on">Toujours déposer une copie</option>
+                  <option value="off">Ne pas déposer de copie (le fournisseur le fait déjà)</option>
+                </select>
+              </div>
                 </>
               ) : null}
             </div>
@@ -17,9 +17,14 @@ import { deriveAndStoreAppVaultKey, exportAppVaultKeyB64u, importAppVaultKeyB64u
 type PhotosLockedGateProps = {
   scope: string
   onUnlocked: (vaultKeyB64u?: string) => void
+  /**
+   * Réauthentification serveur (drive-service) : définit le code au premier usage puis l'échange
+   * contre un jeton d'accès. `null` = déverrouillage biométrique (jeton encore valide exigé).
+   */
+  serverUnlock?: (pin: string | null) => Promise<void>
 }
 
-export function PhotosLockedGate({ scope, onUnlocked }: PhotosLockedGateProps) {
+export function PhotosLockedGate({ scope, onUnlocked, serverUnlock }: PhotosLockedGateProps) {
   const [pinConfigured, setPinConfigured] = useState(() => hasPhotosLockedPin(scope))
   const [pin, setPin] = useState('')
   const [confirmPin, setConfirmPin] = useState('')
@@ -44,12 +49,31 @@ export function PhotosLockedGate({ scope, onUnlocked }: PhotosLockedGateProps) {
     [onUnlocked, resetInputs]
   )
 
+  /** Faux (et message affiché) si le serveur refuse le code ou le jeton. */
+  const unlockServer = useCallback(
+    async (pinValue: string | null) => {
+      if (!serverUnlock) return true
+      try {
+        await serverUnlock(pinValue)
+        return true
+      } catch (e) {
+        setError(e instanceof Error ? e.message : 'Déverrouillage serveur impossible.')
+        return false
+      }
+    },
+    [serverUnlock]
+  )
+
   const finishUnlockWithPin = useCallback(
     async (pinValue: string) => {
+      if (!(await unlockServer(pinValue))) {
+        resetInputs()
+        return
+      }
       await deriveAndStoreAppVaultKey('photos', scope, pinValue)
       finishUnlock(exportAppVaultKeyB64u('photos', scope) ?? undefined)
     },
-    [finishUnlock, scope]
+    [finishUnlock, resetInputs, scope, unlockServer]
   )
 
   const handleSetup = async (e: React.FormEvent) => {
@@ -110,6 +134,7 @@ export function PhotosLockedGate({ scope, onUnlocked }: PhotosLockedGateProps) {
       }
       const cached = readPhotosLockedVaultKeyB64u(scope)
       if (cached) {
+        if (!(await unlockServer(null))) return
         importAppVaultKeyB64u('photos', scope, cached)
         finishUnlock(cached)
         return
@@ -258,7 +283,7 @@ export function PhotosLockedGate({ scope, onUnlocked }: PhotosLockedGateProps) {
 
       <p className="mt-6 flex items-start gap-2 text-left text-xs text-slate-500 dark:text-slate-500">
         <Shield className="mt-0.5 h-3.5 w-3.5 shrink-0" aria-hidden />
-        Protection locale sur cet appareil. Le chiffrement serveur dédié du coffre reste prévu pour une version ultérieure.
+        Le code est aussi exigé par le serveur : sans lui, aucune photo verrouillée n’est servie, même à une session ouverte. Les photos sont chiffrées sur cet appareil avant l’envoi.
       </p>
     </div>
   )
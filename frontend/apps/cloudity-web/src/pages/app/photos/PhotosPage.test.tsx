@@ -28,6 +28,10 @@ vi.mock('../../../api', () => ({
   fetchDrivePhotosTimeline: vi.fn(),
   fetchDrivePhotosArchive: vi.fn(),
   fetchDrivePhotosLocked: vi.fn(),
+  fetchDriveLockedStatus: vi.fn().mockResolvedValue({ pin_set: true, unlocked: false }),
+  setDriveLockedAccess: vi.fn(),
+  setDriveLockedPin: vi.fn().mockResolvedValue(undefined),
+  unlockDriveLocked: vi.fn().mockResolvedValue({ access: 'tok', expires_in: 600 }),
   fetchDriveNodes: vi.fn(),
   fetchDriveTrash: vi.fn(),
   downloadDriveFile: vi.fn().mockResolvedValue(new Blob(['pixels'], { type: 'image/jpeg' })),
@@ -66,7 +70,6 @@ describe('PhotosPage', () => {
     vi.mocked(api.fetchDrivePhotosTimeline).mockResolvedValue({
       items: [],
       limit: 48,
-      offset: 0,
       has_more: false,
     })
     vi.mocked(api.fetchDriveNodes).mockResolvedValue([])
@@ -152,7 +155,6 @@ describe('PhotosPage', () => {
         },
       ],
       limit: 48,
-      offset: 0,
       has_more: false,
     })
     render(wrap(<PhotosPage />))
@@ -188,7 +190,6 @@ describe('PhotosPage', () => {
         },
       ],
       limit: 48,
-      offset: 0,
       has_more: false,
     })
     render(wrap(<PhotosPage />))
@@ -221,7 +222,6 @@ describe('PhotosPage', () => {
         },
       ],
       limit: 48,
-      offset: 0,
       has_more: false,
     })
     render(wrap(<PhotosPage />))
@@ -260,7 +260,6 @@ describe('PhotosPage', () => {
         },
       ],
       limit: 48,
-      offset: 0,
       has_more: false,
     })
     render(wrap(<PhotosPage />))
@@ -299,7 +298,6 @@ describe('PhotosPage', () => {
         },
       ],
       limit: 48,
-      offset: 0,
       has_more: false,
     })
     render(wrap(<PhotosPage />))
@@ -336,7 +334,6 @@ describe('PhotosPage', () => {
         },
       ],
       limit: 48,
-      offset: 0,
       has_more: false,
     })
     render(wrap(<PhotosPage />))
@@ -386,7 +383,6 @@ describe('PhotosPage', () => {
         },
       ],
       limit: 48,
-      offset: 0,
       has_more: false,
     })
     render(wrap(<PhotosPage />))
@@ -440,6 +436,9 @@ describe('PhotosPage', () => {
       await expect(verifyPhotosLockedPin('1:user@test.com', '1234')).resolves.toBe(false)
       await expect(verifyPhotosLockedPin('1:user@test.com', '5678')).resolves.toBe(true)
     })
+    await waitFor(() => {
+      expect(api.setDriveLockedPin).toHaveBeenCalledWith('token', '5678', '1234')
+    })
   })
 
   it('onglet Verrouillé : déverrouille avec le code puis charge les photos', async () => {
@@ -479,6 +478,7 @@ describe('PhotosPage', () => {
     fireEvent.change(screen.getByLabelText('Code'), { target: { value: '4321' } })
     fireEvent.click(screen.getByRole('button', { name: 'Déverrouiller avec le code' }))
     await waitFor(() => {
+      expect(api.unlockDriveLocked).toHaveBeenCalledWith('token', '4321')
       expect(api.fetchDrivePhotosLocked).toHaveBeenCalledWith('token')
     })
     await screen.findByRole('button', { name: /Ouvrir secret\.jpg/ })
@@ -525,7 +525,6 @@ describe('PhotosPage', () => {
         },
       ],
       limit: 48,
-      offset: 0,
       has_more: false,
     })
     render(wrap(<PhotosPage />))
@@ -565,7 +564,6 @@ describe('PhotosPage', () => {
         },
       ],
       limit: 48,
-      offset: 0,
       has_more: false,
     })
     render(wrap(<PhotosPage />))
@@ -604,7 +602,6 @@ describe('PhotosPage', () => {
         },
       ],
       limit: 48,
-      offset: 0,
       has_more: false,
     })
     render(wrap(<PhotosPage />))
@@ -654,7 +651,6 @@ describe('PhotosPage', () => {
         },
       ],
       limit: 48,
-      offset: 0,
       has_more: false,
     })
     render(wrap(<PhotosPage />))
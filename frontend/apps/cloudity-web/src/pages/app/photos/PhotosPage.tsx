@@ -16,6 +16,7 @@ import {
   RotateCcw,
   Plus,
   Settings,
+  Play,
 } from 'lucide-react'
 import { Link, useSearchParams } from 'react-router-dom'
 import { useAuth } from '../../../authContext'
@@ -29,8 +30,13 @@ import {
   downloadDriveFile,
   downloadDriveThumbnail,
   fetchDriveNodes,
+  fetchDriveStreamUrl,
   fetchDrivePhotosArchive,
   fetchDrivePhotosLocked,
+  fetchDriveLockedStatus,
+  setDriveLockedAccess,
+  setDriveLockedPin,
+  unlockDriveLocked,
   fetchDrivePhotosTimeline,
   fetchDriveTrash,
   lockDrivePhotos,
@@ -182,6 +188,20 @@ function isExternalFileDrag(dataTransfer: DataTransfer | null): boolean {
   return !types.some((t) => t === 'text/html' || t === 'text/uri-list' || t === 'text/plain')
 }
 
+function isVideoNode(node: DriveNode): boolean {
+  if (node.media_kind) return node.media_kind === 'video'
+  return (node.mime_type ?? '').startsWith('video/') || /\.(mp4|m4v|mov|3gp|webm)$/i.test(node.name)
+}
+
+/** Durée vidéo au format m:ss (ou h:mm:ss). */
+function formatVideoDuration(ms: number): string {
+  const total = Math.max(0, Math.round(ms / 1000))
+  const h = Math.floor(total / 3600)
+  const m = Math.floor((total % 3600) / 60)
+  const s = String(total % 60).padStart(2, '0')
+  return h > 0 ? `${h}:${String(m).padStart(2, '0')}:${s}` : `${m}:${s}`
+}
+
 function typedImageBlob(blob: Blob, fileName: string): Blob {
   if (blob.type && blob.type !== 'application/octet-stream') return blob
   const lower = fileName.toLowerCase()
@@ -325,6 +345,12 @@ function PhotoThumb({
             <Loader2 className="h-6 w-6 animate-spin text-slate-400" aria-hidden />
           </span>
         )}
+        {isVideoNode(node) ? (
+          <span className="pointer-events-none absolute bottom-1 right-1 flex items-center gap-0.5 rounded bg-black/55 px-1 py-0.5 text-[11px] font-medium text-white">
+            <Play className="h-3 w-3 fill-current" aria-hidden />
+            {node.duration_ms ? formatVideoDuration(node.duration_ms) : null}
+          </span>
+        ) : null}
         {rangePreview && !selected ? (
           <span
             className="pointer-events-none absolute inset-0 bg-blue-500/25 ring-2 ring-inset ring-blue-400/80 dark:ring-blue-300/70"
@@ -357,6 +383,7 @@ function Lightbox({
   const [loading, setLoading] = useState(true)
   const [zoom, setZoom] = useState(1)
   const viewportRef = useRef<HTMLDivElement>(null)
+  const playAsVideo = isVideoNode(node) && !node.vault_encrypted
 
   useEffect(() => {
     setZoom(1)
@@ -367,6 +394,22 @@ function Lightbox({
     let u: string | null = null
     setLoading(true)
     setUrl(null)
+    if (playAsVideo) {
+      // Vidéo : URL signée servie par plages (lecture immédiate, pas de téléchargement complet).
+      fetchDriveStreamUrl(token, node.id)
+        .then((streamUrl) => {
+          if (!cancelled) setUrl(streamUrl)
+        })
+        .catch(() => {
+          if (!cancelled) toast.error('Impossible de lire la vidéo')
+        })
+        .finally(() => {
+          if (!cancelled) setLoading(false)
+        })
+      return () => {
+        cancelled = true
+      }
+    }
     schedulePhotoDownload(() => downloadDriveFile(token, node.id, { inline: true }))
       .then((blob) => {
         if (cancelled) return
@@ -384,7 +427,7 @@ function Lightbox({
       cancelled = true
       if (u) URL.revokeObjectURL(u)
     }
-  }, [token, node.id, node.name])
+  }, [token, node.id, node.name, playAsVideo])
 
   useEffect(() => {
     const onKey = (e: KeyboardEvent) => {
@@ -438,7 +481,19 @@ function Lightbox({
             <Loader2 className="h-10 w-10 animate-spin text-white/70" />
           </div>
         )}
-        {url && !loading && (
+        {url && !loading && playAsVideo && (
+          <video
+            src={url}
+            controls
+            autoPlay
+            playsInline
+            preload="metadata"
+            aria-label={node.name}
+            onClick={(e) => e.stopPropagation()}
+            className="h-full w-full max-h-[100dvh] max-w-[100vw] object-contain"
+          />
+        )}
+        {url && !loading && !playAsVideo && (
           <img
             src={url}
             alt={node.name}
@@ -525,9 +580,9 @@ export default function PhotosPage() {
   const photosQuery = useInfiniteQuery({
     queryKey: ['drive', 'photos', 'timeline'],
     queryFn: ({ pageParam }) =>
-      fetchDrivePhotosTimeline(accessToken!, { limit: PAGE_SIZE, offset: pageParam as number }),
-    initialPageParam: 0,
-    getNextPageParam: (lastPage) => (lastPage.has_more ? lastPage.offset + lastPage.limit : undefined),
+      fetchDrivePhotosTimeline(accessToken!, { limit: PAGE_SIZE, cursor: pageParam }),
+    initialPageParam: undefined as string | undefined,
+    getNextPageParam: (lastPage) => (lastPage.has_more ? lastPage.next_cursor : undefined),
     enabled: !!accessToken && tab === 'timeline',
     staleTime: 30_000,
     refetchInterval: 60_000,
@@ -779,6 +834,7 @@ export default function PhotosPage() {
   useEffect(() => {
     if (tab === 'locked') return
     if (lockedVaultScope) revokePhotosLockedVaultSession(lockedVaultScope)
+    setDriveLockedAccess(null)
     setLockedVaultUnlocked(false)
   }, [tab, lockedVaultScope])
 
@@ -787,6 +843,7 @@ export default function PhotosPage() {
     const onVisibility = () => {
       if (document.visibilityState === 'hidden') {
         revokePhotosLockedVaultSession(lockedVaultScope)
+        setDriveLockedAccess(null)
         setLockedVaultUnlocked(false)
       }
     }
@@ -801,10 +858,26 @@ export default function PhotosPage() {
     setLockedVaultUnlocked(true)
   }, [lockedVaultScope])
 
+  /** Code serveur : défini au premier déverrouillage, puis échangé contre un jeton d'accès court. */
+  const handleLockedServerUnlock = useCallback(
+    async (pin: string | null) => {
+      if (!accessToken) throw new Error('Non connecté')
+      const status = await fetchDriveLockedStatus(accessToken)
+      if (pin == null) {
+        if (!status.unlocked) throw new Error('Entrez votre code : le serveur exige une nouvelle vérification.')
+        return
+      }
+      if (!status.pin_set) await setDriveLockedPin(accessToken, pin)
+      await unlockDriveLocked(accessToken, pin)
+    },
+    [accessToken]
+  )
+
   const lockLockedVault = useCallback(() => {
     if (!lockedVaultScope) return
     clearAppVaultKey('photos', lockedVaultScope)
     revokePhotosLockedVaultSession(lockedVaultScope)
+    setDriveLockedAccess(null)
     setLockedVaultUnlocked(false)
     setLightboxIndex(null)
     void queryClient.removeQueries({ queryKey: ['drive', 'photos', 'locked'] })
@@ -844,6 +917,14 @@ export default function PhotosPage() {
         setPinChangeError(result.error)
         return
       }
+      // Le nouveau code révoque les jetons serveur déjà délivrés.
+      try {
+        await setDriveLockedPin(accessToken, pinChangeNext, pinChangeCurrent)
+        await unlockDriveLocked(accessToken, pinChangeNext)
+      } catch (e) {
+        setPinChangeError(e instanceof Error ? e.message : 'Code serveur non mis à jour.')
+        return
+      }
       setPinChangeCurrent('')
       setPinChangeNext('')
       setPinChangeConfirm('')
@@ -1771,7 +1852,11 @@ export default function PhotosPage() {
       )}
 
       {tab === 'locked' && accessToken && lockedVaultScope && !lockedVaultUnlocked && (
-        <PhotosLockedGate scope={lockedVaultScope} onUnlocked={handleLockedVaultUnlocked} />
+        <PhotosLockedGate
+          scope={lockedVaultScope}
+          onUnlocked={handleLockedVaultUnlocked}
+          serverUnlock={handleLockedServerUnlock}
+        />
       )}
 
       {tab === 'locked' && accessToken && lockedVaultUnlocked && (
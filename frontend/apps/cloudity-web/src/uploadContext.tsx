@@ -30,8 +30,11 @@ export type UploadContextValue = {
   replaceUpload: (id: string) => void
   /** Annuler un conflit (supprimer l’entrée sans remplacer). */
   cancelConflict: (id: string) => void
-  /** Téléchargement Drive : suit le même panneau que les téléversements. */
-  registerDownload: (label: string, task: () => Promise<{ blob: Blob; filename: string }>) => void
+  /** Téléchargement Drive : suit le même panneau que les téléversements (progression optionnelle, en %). */
+  registerDownload: (
+    label: string,
+    task: (onProgress: (percent: number) => void) => Promise<{ blob: Blob; filename: string }>
+  ) => void
   driveParentId: number | null
   setDriveParentId: (id: number | null) => void
   setDriveVaultUploadState: (state: DriveVaultUploadState | null) => void
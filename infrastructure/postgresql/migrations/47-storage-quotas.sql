@@ -0,0 +1,34 @@
+-- Quotas de stockage Drive/Photos par utilisateur et par tenant.
+--   - user_id NULL  ⇒ quota global du tenant (somme de tous ses utilisateurs) ;
+--   - user_id renseigné ⇒ quota individuel.
+-- Absence de ligne ⇒ quota par défaut du service (DRIVE_DEFAULT_USER_QUOTA_BYTES /
+-- DRIVE_DEFAULT_TENANT_QUOTA_BYTES, 0 = illimité). Appliqué par drive-service
+-- (uploadFile, putNodeContent, createNode) sous verrou transactionnel par tenant.
+CREATE TABLE IF NOT EXISTS storage_quotas (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    max_bytes BIGINT NOT NULL CHECK (max_bytes >= 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_storage_quotas_tenant
+  ON storage_quotas(tenant_id) WHERE user_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_storage_quotas_user
+  ON storage_quotas(user_id) WHERE user_id IS NOT NULL;
+
+-- Calcul d'usage par tenant (somme des fichiers, corbeille incluse).
+CREATE INDEX IF NOT EXISTS idx_drive_nodes_tenant_files
+  ON drive_nodes(tenant_id) WHERE is_folder = false;
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_storage_quotas_updated_at') THEN
+    CREATE TRIGGER update_storage_quotas_updated_at BEFORE UPDATE ON storage_quotas
+      FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+  END IF;
+END $$;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON storage_quotas TO cloudity_app;
+GRANT USAGE, SELECT ON SEQUENCE storage_quotas_id_seq TO cloudity_app;
@@ -0,0 +1,28 @@
+-- Liens de partage publics Drive (fichier ou dossier), cf. docs/securite/URL-CAPABILITIES.md § 3.
+--   - token brut jamais stocké : seul son SHA-256 (`token_hash`) est en base ;
+--   - mot de passe optionnel haché Argon2id (même norme que auth-service) ;
+--   - expiration, plafond de téléchargements et révocation explicite ;
+--   - mode 'read' (lecture / téléchargement) ou 'upload' (dépôt dans un dossier).
+CREATE TABLE IF NOT EXISTS drive_share_links (
+    id SERIAL PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,
+    node_id INTEGER NOT NULL REFERENCES drive_nodes(id) ON DELETE CASCADE,
+    tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    password_hash TEXT DEFAULT NULL,
+    mode VARCHAR(16) NOT NULL DEFAULT 'read' CHECK (mode IN ('read', 'upload')),
+    expires_at TIMESTAMPTZ DEFAULT NULL,
+    max_downloads INTEGER DEFAULT NULL CHECK (max_downloads IS NULL OR max_downloads > 0),
+    download_count INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMPTZ DEFAULT NULL,
+    last_used_at TIMESTAMPTZ DEFAULT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_drive_share_links_user
+  ON drive_share_links(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_drive_share_links_node
+  ON drive_share_links(node_id) WHERE revoked_at IS NULL;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON drive_share_links TO cloudity_app;
+GRANT USAGE, SELECT ON SEQUENCE drive_share_links_id_seq TO cloudity_app;
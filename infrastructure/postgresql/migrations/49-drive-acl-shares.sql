@@ -0,0 +1,121 @@
+-- Partage interne Drive entre utilisateurs d'un même tenant (ACL par nœud, héritée par les descendants).
+--
+-- Rôles (niveau croissant) : viewer (1) < commenter (2) < editor (3) < propriétaire (4).
+--   - viewer    : lister, prévisualiser, télécharger ;
+--   - commenter : viewer + annotations (réservé, mêmes droits que viewer côté API aujourd'hui) ;
+--   - editor    : commenter + créer / renommer / déplacer / remplacer / mettre à la corbeille.
+-- Les nœuds créés par un éditeur dans un dossier partagé appartiennent au propriétaire
+-- du dossier (quota, unicité des noms et corbeille restent ceux du propriétaire).
+
+-- Groupes d'utilisateurs par tenant (gérés via l'API admin).
+CREATE TABLE IF NOT EXISTS user_groups (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (tenant_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS user_group_members (
+    group_id INTEGER NOT NULL REFERENCES user_groups(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    added_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_group_members_user ON user_group_members(user_id);
+
+CREATE TABLE IF NOT EXISTS drive_node_shares (
+    id SERIAL PRIMARY KEY,
+    node_id INTEGER NOT NULL REFERENCES drive_nodes(id) ON DELETE CASCADE,
+    tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    owner_user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    grantee_user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    grantee_group_id INTEGER REFERENCES user_groups(id) ON DELETE CASCADE,
+    role VARCHAR(16) NOT NULL CHECK (role IN ('viewer', 'commenter', 'editor')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT drive_node_shares_one_grantee CHECK ((grantee_user_id IS NULL) <> (grantee_group_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_drive_node_shares_user
+  ON drive_node_shares(node_id, grantee_user_id) WHERE grantee_user_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_drive_node_shares_group
+  ON drive_node_shares(node_id, grantee_group_id) WHERE grantee_group_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_drive_node_shares_grantee_user ON drive_node_shares(grantee_user_id);
+CREATE INDEX IF NOT EXISTS idx_drive_node_shares_grantee_group ON drive_node_shares(grantee_group_id);
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_drive_node_shares_updated_at') THEN
+    CREATE TRIGGER update_drive_node_shares_updated_at BEFORE UPDATE ON drive_node_shares
+      FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+  END IF;
+END $$;
+
+-- Niveau d'accès effectif de p_user_id sur p_node_id (0 = aucun) : propriétaire, ou
+-- meilleur partage sur le nœud / un ancêtre non supprimé (utilisateur direct ou groupe).
+-- SECURITY DEFINER : appelée depuis les policies RLS de drive_nodes sans récursion de policy.
+CREATE OR REPLACE FUNCTION drive_node_access(p_node_id INTEGER, p_user_id INTEGER)
+RETURNS INTEGER
+LANGUAGE sql STABLE SECURITY DEFINER
+SET search_path = public
+AS $$
+  WITH RECURSIVE chain AS (
+    SELECT id, parent_id, user_id, 0 AS depth FROM drive_nodes WHERE id = p_node_id
+    UNION ALL
+    SELECT p.id, p.parent_id, p.user_id, chain.depth + 1
+    FROM drive_nodes p
+    INNER JOIN chain ON p.id = chain.parent_id
+    WHERE p.deleted_at IS NULL AND chain.depth < 256
+  )
+  SELECT GREATEST(
+    COALESCE((SELECT 4 FROM chain WHERE depth = 0 AND user_id = p_user_id), 0),
+    COALESCE((
+      SELECT MAX(CASE s.role WHEN 'editor' THEN 3 WHEN 'commenter' THEN 2 ELSE 1 END)
+      FROM drive_node_shares s
+      WHERE s.node_id IN (SELECT id FROM chain)
+        AND (
+          s.grantee_user_id = p_user_id
+          OR s.grantee_group_id IN (SELECT group_id FROM user_group_members WHERE user_id = p_user_id)
+        )
+    ), 0)
+  );
+$$;
+
+GRANT EXECUTE ON FUNCTION drive_node_access(INTEGER, INTEGER) TO cloudity_app;
+
+-- RLS : le propriétaire garde tous les droits ; les partages ouvrent lecture / écriture.
+DROP POLICY IF EXISTS drive_nodes_shared_read ON drive_nodes;
+CREATE POLICY drive_nodes_shared_read ON drive_nodes
+    FOR SELECT USING (drive_node_access(id, current_setting('app.current_user_id', true)::INTEGER) >= 1);
+
+DROP POLICY IF EXISTS drive_nodes_shared_update ON drive_nodes;
+CREATE POLICY drive_nodes_shared_update ON drive_nodes
+    FOR UPDATE USING (drive_node_access(id, current_setting('app.current_user_id', true)::INTEGER) >= 3);
+
+DROP POLICY IF EXISTS drive_nodes_shared_insert ON drive_nodes;
+CREATE POLICY drive_nodes_shared_insert ON drive_nodes
+    FOR INSERT WITH CHECK (
+      parent_id IS NOT NULL
+      AND drive_node_access(parent_id, current_setting('app.current_user_id', true)::INTEGER) >= 3
+    );
+
+ALTER TABLE drive_node_shares ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS drive_node_shares_owner ON drive_node_shares;
+CREATE POLICY drive_node_shares_owner ON drive_node_shares
+    FOR ALL USING (owner_user_id = current_setting('app.current_user_id', true)::INTEGER);
+
+DROP POLICY IF EXISTS drive_node_shares_grantee_read ON drive_node_shares;
+CREATE POLICY drive_node_shares_grantee_read ON drive_node_shares
+    FOR SELECT USING (
+      grantee_user_id = current_setting('app.current_user_id', true)::INTEGER
+      OR grantee_group_id IN (
+        SELECT group_id FROM user_group_members
+        WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
+      )
+    );
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON user_groups, user_group_members, drive_node_shares TO cloudity_app;
+GRANT USAGE, SELECT ON SEQUENCE user_groups_id_seq, drive_node_shares_id_seq TO cloudity_app;
@@ -0,0 +1,34 @@
+-- Migration 50 — Mots de passe d'application (`app_passwords`).
+--
+-- Utilisés par les clients qui ne savent pas faire le login JWT + 2FA :
+-- montage WebDAV du Drive (Nautilus, Finder, Explorateur Windows, rclone).
+--
+-- Le mot de passe (120 bits, format `xxxx-xxxx-xxxx-xxxx-xxxx-xxxx`) est généré
+-- par auth-service et montré UNE FOIS. Seul son SHA-256 (forme normalisée : minuscules,
+-- sans tirets) est stocké : l'entropie rend un hash lent inutile et permet la
+-- vérification à chaque requête WebDAV sans coût Argon2.
+--
+-- `scopes` limite l'usage (aujourd'hui uniquement 'webdav').
+
+CREATE TABLE IF NOT EXISTS app_passwords (
+    id           SERIAL PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tenant_id    INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    name         VARCHAR(100) NOT NULL,
+    password_hash TEXT NOT NULL UNIQUE,
+    scopes       TEXT[] NOT NULL DEFAULT ARRAY['webdav']::TEXT[],
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS app_passwords_user_idx
+    ON app_passwords (user_id, created_at DESC);
+
+COMMENT ON TABLE app_passwords IS
+    'Mots de passe d''application (WebDAV…). SHA-256 uniquement, révocables (revoked_at).';
+COMMENT ON COLUMN app_passwords.password_hash IS
+    'SHA-256 hex du mot de passe normalisé (minuscules, sans tirets). Le clair n''est montré qu''à la création.';
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON app_passwords TO cloudity_app;
+GRANT USAGE, SELECT ON SEQUENCE app_passwords_id_seq TO cloudity_app;
@@ -0,0 +1,30 @@
+-- Indexation plein texte du contenu des fichiers Drive (PDF, OOXML, ODF, Markdown, texte).
+--
+-- Alimentée par le worker d'indexation de drive-service (text_index.go) : une ligne par
+-- fichier, recalculée quand content_hash change. Les fichiers vault_encrypted ne sont
+-- jamais indexés (le serveur ne voit que du chiffré).
+--   status : 'indexed' | 'empty' | 'too_large' | 'unsupported' | 'error'
+CREATE TABLE IF NOT EXISTS drive_node_text (
+    node_id INTEGER PRIMARY KEY REFERENCES drive_nodes(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    content_hash TEXT DEFAULT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'indexed',
+    body TEXT NOT NULL DEFAULT '',
+    tsv TSVECTOR NOT NULL DEFAULT ''::tsvector,
+    error TEXT DEFAULT NULL,
+    indexed_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_drive_node_text_tsv ON drive_node_text USING GIN (tsv);
+CREATE INDEX IF NOT EXISTS idx_drive_node_text_user ON drive_node_text(user_id);
+
+-- File d'attente du worker : fichiers non chiffrés, non supprimés.
+CREATE INDEX IF NOT EXISTS idx_drive_nodes_text_candidates
+  ON drive_nodes(id) WHERE is_folder = false AND deleted_at IS NULL AND vault_encrypted = false;
+
+ALTER TABLE drive_node_text ENABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS drive_node_text_user_isolation ON drive_node_text;
+CREATE POLICY drive_node_text_user_isolation ON drive_node_text
+    FOR ALL USING (user_id = current_setting('app.current_user_id', true)::INTEGER);
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON drive_node_text TO cloudity_app;
@@ -0,0 +1,25 @@
+-- Cache persistant des vignettes / aperçus Drive & Photos, adressé par contenu.
+--
+-- Une ligne par (content_hash, variante, format) : sm (grille), md, lg (aperçu plein
+-- écran), en jpeg et webp. Générées une seule fois par le worker de drive-service
+-- (thumbnails.go) après upload, ou à la première demande ; deux fichiers identiques
+-- partagent leurs vignettes. L'accès est contrôlé via le nœud (GET /drive/nodes/:id/thumbnail),
+-- jamais directement par hash. Une ligne avec error non NULL (data vide) marque une image
+-- non décodable pour ne pas la retraiter à chaque passage.
+CREATE TABLE IF NOT EXISTS drive_thumbnails (
+    content_hash TEXT NOT NULL,
+    variant VARCHAR(8) NOT NULL,
+    format VARCHAR(8) NOT NULL,
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    data BYTEA NOT NULL DEFAULT ''::bytea,
+    error TEXT DEFAULT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (content_hash, variant, format)
+);
+
+-- Invalidation (putNodeContent) et purge des vignettes orphelines : recherche par hash seul.
+CREATE INDEX IF NOT EXISTS idx_drive_nodes_content_hash
+  ON drive_nodes(content_hash) WHERE content_hash IS NOT NULL;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON drive_thumbnails TO cloudity_app;
@@ -0,0 +1,9 @@
+-- Vidéos dans Photos : métadonnées lues dans les atomes MP4 / QuickTime par drive-service
+-- (video_meta.go) lors de la génération de la couverture. taken_at reçoit la date de prise
+-- des atomes (creationdate / ©day / mvhd).
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS duration_ms BIGINT DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS media_width INTEGER DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS media_height INTEGER DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS video_codec VARCHAR(16) DEFAULT NULL;
+-- moov avant mdat : lecture possible dès les premiers octets (« faststart »).
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS video_faststart BOOLEAN DEFAULT NULL;
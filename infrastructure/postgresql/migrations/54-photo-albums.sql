@@ -0,0 +1,111 @@
+-- Albums Photos : référencent des nœuds Drive sans les copier (drive-service, photo_albums.go).
+--   - photo_albums        : titre, description, couverture (élément de l'album), tri ;
+--   - photo_album_items   : nœuds de l'album, position pour le tri manuel, auteur de l'ajout ;
+--   - photo_album_members : partage de l'album avec des utilisateurs du tenant
+--                           (viewer : consultation ; contributor : + ajout de ses propres photos) ;
+--   - drive_share_links.album_id : lien public en lecture sur un album.
+-- Un élément reste la propriété de celui qui l'a ajouté (quota, corbeille) ; la suppression
+-- du nœud le retire de l'album.
+CREATE TABLE IF NOT EXISTS photo_albums (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    title VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    cover_node_id INTEGER REFERENCES drive_nodes(id) ON DELETE SET NULL,
+    sort_mode VARCHAR(16) NOT NULL DEFAULT 'taken_desc' CHECK (sort_mode IN ('taken_desc', 'taken_asc', 'manual')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_photo_albums_user ON photo_albums(user_id, updated_at DESC);
+
+CREATE TABLE IF NOT EXISTS photo_album_items (
+    id SERIAL PRIMARY KEY,
+    album_id INTEGER NOT NULL REFERENCES photo_albums(id) ON DELETE CASCADE,
+    node_id INTEGER NOT NULL REFERENCES drive_nodes(id) ON DELETE CASCADE,
+    added_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL DEFAULT 0,
+    added_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (album_id, node_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_photo_album_items_node ON photo_album_items(node_id);
+CREATE INDEX IF NOT EXISTS idx_photo_album_items_order ON photo_album_items(album_id, position, id);
+
+CREATE TABLE IF NOT EXISTS photo_album_members (
+    album_id INTEGER NOT NULL REFERENCES photo_albums(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(16) NOT NULL DEFAULT 'viewer' CHECK (role IN ('viewer', 'contributor')),
+    added_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (album_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_photo_album_members_user ON photo_album_members(user_id);
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_photo_albums_updated_at') THEN
+    CREATE TRIGGER update_photo_albums_updated_at BEFORE UPDATE ON photo_albums
+      FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+  END IF;
+END $$;
+
+-- Liens publics : cible = un nœud OU un album (lecture seule).
+ALTER TABLE drive_share_links ADD COLUMN IF NOT EXISTS album_id INTEGER REFERENCES photo_albums(id) ON DELETE CASCADE;
+ALTER TABLE drive_share_links ALTER COLUMN node_id DROP NOT NULL;
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'drive_share_links_one_target') THEN
+    ALTER TABLE drive_share_links ADD CONSTRAINT drive_share_links_one_target
+      CHECK ((node_id IS NULL) <> (album_id IS NULL) AND (album_id IS NULL OR mode = 'read'));
+  END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_drive_share_links_album
+  ON drive_share_links(album_id) WHERE album_id IS NOT NULL AND revoked_at IS NULL;
+
+-- drive_node_access (migration 49) : un élément d'album est visible (viewer) par le
+-- propriétaire et les membres de l'album, sans droit sur le reste de l'arborescence.
+CREATE OR REPLACE FUNCTION drive_node_access(p_node_id INTEGER, p_user_id INTEGER)
+RETURNS INTEGER
+LANGUAGE sql STABLE SECURITY DEFINER
+SET search_path = public
+AS $$
+  WITH RECURSIVE chain AS (
+    SELECT id, parent_id, user_id, 0 AS depth FROM drive_nodes WHERE id = p_node_id
+    UNION ALL
+    SELECT p.id, p.parent_id, p.user_id, chain.depth + 1
+    FROM drive_nodes p
+    INNER JOIN chain ON p.id = chain.parent_id
+    WHERE p.deleted_at IS NULL AND chain.depth < 256
+  )
+  SELECT GREATEST(
+    COALESCE((SELECT 4 FROM chain WHERE depth = 0 AND user_id = p_user_id), 0),
+    COALESCE((
+      SELECT MAX(CASE s.role WHEN 'editor' THEN 3 WHEN 'commenter' THEN 2 ELSE 1 END)
+      FROM drive_node_shares s
+      WHERE s.node_id IN (SELECT id FROM chain)
+        AND (
+          s.grantee_user_id = p_user_id
+          OR s.grantee_group_id IN (SELECT group_id FROM user_group_members WHERE user_id = p_user_id)
+        )
+    ), 0),
+    COALESCE((
+      SELECT 1 FROM photo_album_items i
+      INNER JOIN photo_albums a ON a.id = i.album_id
+      WHERE i.node_id = p_node_id
+        AND (
+          a.user_id = p_user_id
+          OR EXISTS (SELECT 1 FROM photo_album_members m WHERE m.album_id = a.id AND m.user_id = p_user_id)
+        )
+      LIMIT 1
+    ), 0)
+  );
+$$;
+
+GRANT EXECUTE ON FUNCTION drive_node_access(INTEGER, INTEGER) TO cloudity_app;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON photo_albums, photo_album_items, photo_album_members TO cloudity_app;
+GRANT USAGE, SELECT ON SEQUENCE photo_albums_id_seq, photo_album_items_id_seq TO cloudity_app;
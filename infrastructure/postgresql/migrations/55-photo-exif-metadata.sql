@@ -0,0 +1,26 @@
+-- Métadonnées EXIF complètes des photos (drive-service, photo_meta.go) : position GPS,
+-- appareil, objectif, orientation, exposition, et lieu déduit par géocodage inverse hors ligne
+-- (jeu de villes embarqué ou export GeoNames, sans API externe). Les dimensions réutilisent
+-- media_width / media_height (migration 53). photo_meta_hash = content_hash analysé : un
+-- nouveau contenu relance l'extraction.
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS gps_lat DOUBLE PRECISION DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS gps_lon DOUBLE PRECISION DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS gps_alt DOUBLE PRECISION DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS camera_make VARCHAR(64) DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS camera_model VARCHAR(128) DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS lens_model VARCHAR(128) DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS exif_orientation SMALLINT DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS iso INTEGER DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS exposure_time VARCHAR(16) DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS f_number REAL DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS focal_length REAL DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS place_name VARCHAR(200) DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS place_admin VARCHAR(200) DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS place_country VARCHAR(2) DEFAULT NULL;
+ALTER TABLE drive_nodes ADD COLUMN IF NOT EXISTS photo_meta_hash CHAR(64) DEFAULT NULL;
+
+-- Carte / lieux (photos-service GET /photos/places) et filtre par appareil.
+CREATE INDEX IF NOT EXISTS idx_drive_nodes_user_geo
+  ON drive_nodes(user_id, place_country, place_name) WHERE gps_lat IS NOT NULL AND deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_drive_nodes_user_camera
+  ON drive_nodes(user_id, camera_model) WHERE camera_model IS NOT NULL AND deleted_at IS NULL;
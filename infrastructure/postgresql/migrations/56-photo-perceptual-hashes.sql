@@ -0,0 +1,14 @@
+-- Empreintes perceptuelles des photos (drive-service, photo_hash.go), adressées par contenu
+-- comme drive_thumbnails : dHash (gradient 9×8) et pHash (DCT 32×32), 64 bits chacun, calculés
+-- sur la vignette sm déjà redressée. Deux copies réencodées ou redimensionnées d'une même
+-- image ont des empreintes à faible distance de Hamming (photos-service GET /photos/duplicates).
+-- Une ligne avec error non NULL marque une vignette absente ou illisible (pas de reprise).
+CREATE TABLE IF NOT EXISTS drive_photo_hashes (
+    content_hash TEXT PRIMARY KEY,
+    dhash BIGINT DEFAULT NULL,
+    phash BIGINT DEFAULT NULL,
+    error TEXT DEFAULT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON drive_photo_hashes TO cloudity_app;
@@ -0,0 +1,13 @@
+-- Pagination par curseur (keyset) de la timeline Photos et des listings Drive.
+--
+-- Timeline : clé (date de prise de vue, sinon d'import ; id) décroissante, même expression que
+-- photos-service / drive-service (timelineKeySQL). Les pages ne glissent plus quand des photos
+-- arrivent pendant le défilement et une page profonde coûte autant que la première.
+CREATE INDEX IF NOT EXISTS idx_drive_nodes_photos_timeline_keyset
+  ON drive_nodes(user_id, (COALESCE(taken_at, created_at, TIMESTAMPTZ 'epoch')) DESC, id DESC)
+  WHERE deleted_at IS NULL AND is_folder = false AND photo_archived_at IS NULL AND photo_locked_at IS NULL;
+
+-- Listing d'un dossier : dossiers d'abord, puis nom, id (GET /drive/nodes?parent_id=&limit=).
+CREATE INDEX IF NOT EXISTS idx_drive_nodes_children_keyset
+  ON drive_nodes(parent_id, is_folder DESC, name, id)
+  WHERE deleted_at IS NULL;
@@ -0,0 +1,24 @@
+-- Migration 58 — Code du dossier verrouillé Photos (`drive_locked_pins`).
+--
+-- photo_locked_at ne fait que masquer une photo de la timeline : le contenu restait servi
+-- à toute session valide. Le dossier verrouillé exige désormais une réauthentification :
+-- le code (Argon2id, mêmes paramètres que les mots de passe de liens de partage) est
+-- échangé par POST /drive/photos/locked/unlock contre un jeton d'accès HMAC court
+-- (drive-service, photos_locked.go), lié au hash courant : changer le code révoque les
+-- jetons déjà délivrés.
+--
+-- failed_attempts / blocked_until : après 5 échecs consécutifs, nouvelle tentative
+-- refusée pendant 5 minutes (le code est court, l'essai exhaustif doit rester lent).
+
+CREATE TABLE IF NOT EXISTS drive_locked_pins (
+    user_id         INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    pin_hash        TEXT NOT NULL,
+    failed_attempts INTEGER NOT NULL DEFAULT 0,
+    blocked_until   TIMESTAMPTZ,
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMENT ON TABLE drive_locked_pins IS
+    'Code du dossier verrouillé Photos (Argon2id) ; requis pour lire le contenu des nœuds photo_locked_at.';
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON drive_locked_pins TO cloudity_app;
@@ -0,0 +1,41 @@
+-- Migration 59 — Exports ZIP asynchrones Drive (`drive_export_jobs`).
+--
+-- GET /drive/nodes/:id/zip et POST /drive/nodes/archive construisent le ZIP en mémoire dans
+-- la requête : un gros dossier dépasse le délai de la gateway. POST /drive/exports crée un
+-- job ; un worker drive-service (exports.go) écrit l'archive dans DRIVE_EXPORT_DIR en
+-- mettant à jour la progression, puis GET /drive/exports/:id/download la sert (Range).
+-- Le fichier et la ligne disparaissent à expires_at (DRIVE_EXPORT_TTL_HOURS, 24 h).
+--
+-- id : jeton aléatoire (pas de séquence énumérable). updated_at sert de battement de cœur :
+-- un job « running » sans progression depuis 10 minutes (instance arrêtée) est repris.
+
+CREATE TABLE IF NOT EXISTS drive_export_jobs (
+    id           TEXT PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tenant_id    INTEGER NOT NULL,
+    node_ids     INTEGER[] NOT NULL,
+    name         TEXT NOT NULL,
+    status       TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'ready', 'failed')),
+    notify       BOOLEAN NOT NULL DEFAULT false,
+    total_files  INTEGER NOT NULL DEFAULT 0,
+    done_files   INTEGER NOT NULL DEFAULT 0,
+    total_bytes  BIGINT NOT NULL DEFAULT 0,
+    done_bytes   BIGINT NOT NULL DEFAULT 0,
+    size_bytes   BIGINT NOT NULL DEFAULT 0,
+    file_path    TEXT,
+    error        TEXT,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    started_at   TIMESTAMPTZ,
+    finished_at  TIMESTAMPTZ,
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_drive_export_jobs_user ON drive_export_jobs(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_drive_export_jobs_queue ON drive_export_jobs(created_at) WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS idx_drive_export_jobs_expires ON drive_export_jobs(expires_at);
+
+COMMENT ON TABLE drive_export_jobs IS
+    'Exports ZIP asynchrones Drive : file d''attente, progression et fichier temporaire jusqu''à expires_at.';
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON drive_export_jobs TO cloudity_app;
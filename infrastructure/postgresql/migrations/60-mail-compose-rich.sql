@@ -0,0 +1,13 @@
+-- Migration 60 — Envoi riche (mail-directory-service, mail_compose.go).
+--
+-- POST /mail/me/send et /mail/me/send/schedule acceptent désormais plusieurs destinataires
+-- To / Cc / Bcc, un corps HTML, des images inline et des pièces jointes (directes ou nœuds
+-- Drive). Un envoi programmé doit conserver tout cela jusqu'à son départ :
+--   * cc_addrs / bcc_addrs : listes « a@x, b@y » (bcc_addrs n'est jamais émis en en-tête) ;
+--   * mail_message_attachments.content_id : Content-ID d'une image inline (cid: dans le HTML),
+--     vide pour une pièce jointe classique.
+
+ALTER TABLE mail_messages ADD COLUMN IF NOT EXISTS cc_addrs TEXT NOT NULL DEFAULT '';
+ALTER TABLE mail_messages ADD COLUMN IF NOT EXISTS bcc_addrs TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE mail_message_attachments ADD COLUMN IF NOT EXISTS content_id VARCHAR(255) NOT NULL DEFAULT '';
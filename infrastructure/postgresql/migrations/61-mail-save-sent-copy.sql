@@ -0,0 +1,9 @@
+-- Migration 61 — Copie des messages envoyés dans « Envoyés » IMAP (mail-directory-service, mail_sent.go).
+--
+-- Après un envoi SMTP, le message exact (octets RFC 822) est déposé par APPEND dans le dossier
+-- Envoyés (SPECIAL-USE \Sent) pour apparaître dans les autres clients (OVH, Infomaniak…).
+-- Gmail range déjà lui-même le message soumis : la copie y créerait un doublon.
+--   * save_sent_copy NULL  : automatique (copie sauf Gmail / Google OAuth) ;
+--   * true / false         : forcé par l'utilisateur (PATCH /mail/me/accounts/:id).
+
+ALTER TABLE user_email_accounts ADD COLUMN IF NOT EXISTS save_sent_copy BOOLEAN DEFAULT NULL;
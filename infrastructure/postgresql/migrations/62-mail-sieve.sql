@@ -0,0 +1,43 @@
+-- Migration 62 — Scripts Sieve des boîtes hébergées (mail-directory-service, managesieve.go).
+--
+-- Les boîtes de nos domaines (mail_mailboxes) déposent leurs scripts via ManageSieve (RFC 5804,
+-- MANAGESIEVE_ADDR). Le script actif est évalué à la livraison par le routeur MTA
+-- (POST /mail/internal/sieve/evaluate) : fileinto, discard, redirect, reject, imap4flags.
+-- Un seul script actif par boîte (index unique partiel).
+
+CREATE TABLE IF NOT EXISTS mail_sieve_scripts (
+    id SERIAL PRIMARY KEY,
+    mailbox_id INTEGER NOT NULL REFERENCES mail_mailboxes(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL,
+    is_active BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(mailbox_id, name)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mail_sieve_scripts_active
+    ON mail_sieve_scripts(mailbox_id) WHERE is_active;
+
+ALTER TABLE mail_sieve_scripts ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS mail_sieve_scripts_via_mailbox ON mail_sieve_scripts;
+CREATE POLICY mail_sieve_scripts_via_mailbox ON mail_sieve_scripts
+    FOR ALL USING (
+        mailbox_id IN (
+            SELECT m.id FROM mail_mailboxes m
+            JOIN mail_domains d ON d.id = m.domain_id
+            WHERE d.tenant_id = current_setting('app.current_tenant', true)::INTEGER
+        )
+    );
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_mail_sieve_scripts_updated_at') THEN
+    CREATE TRIGGER update_mail_sieve_scripts_updated_at BEFORE UPDATE ON mail_sieve_scripts
+      FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+  END IF;
+END $$;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON mail_sieve_scripts TO cloudity_app;
+GRANT USAGE, SELECT ON SEQUENCE mail_sieve_scripts_id_seq TO cloudity_app;
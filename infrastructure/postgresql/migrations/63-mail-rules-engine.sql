@@ -0,0 +1,42 @@
+-- Migration 63 — Moteur de règles Mail enrichi (mail-directory-service, mail_rule_engine.go).
+--
+-- * criteria_json.conditions : arbre ET / OU / NON (en-têtes, corps, taille, date…) ;
+--   actions_json.actions : liste ordonnée (déplacer, lu, étiquette, étoile, supprimer, transférer,
+--   réponse automatique, webhook, stop). Sans ces clés, les colonnes historiques s'appliquent.
+-- * size_bytes : RFC822.SIZE relevé à la synchronisation (conditions de taille).
+-- * is_starred : action « étoile » et PATCH /mail/me/accounts/:id/messages/:msgId/star.
+-- * mail_rule_executions : une seule exécution des actions à effet externe (transfert, réponse
+--   automatique, webhook) par règle et par message, la réévaluation ayant lieu à chaque sync.
+
+ALTER TABLE mail_messages ADD COLUMN IF NOT EXISTS size_bytes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE mail_messages ADD COLUMN IF NOT EXISTS is_starred BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS mail_rule_executions (
+    id SERIAL PRIMARY KEY,
+    rule_id INTEGER NOT NULL REFERENCES mail_filter_rules(id) ON DELETE CASCADE,
+    message_id INTEGER NOT NULL REFERENCES mail_messages(id) ON DELETE CASCADE,
+    action VARCHAR(32) NOT NULL,
+    target VARCHAR(512) NOT NULL DEFAULT '',
+    error TEXT,
+    executed_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(rule_id, message_id, action)
+);
+
+CREATE INDEX IF NOT EXISTS idx_mail_rule_executions_target
+    ON mail_rule_executions(rule_id, action, target, executed_at DESC);
+
+ALTER TABLE mail_rule_executions ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS mail_rule_executions_via_message ON mail_rule_executions;
+CREATE POLICY mail_rule_executions_via_message ON mail_rule_executions
+    FOR ALL USING (
+        message_id IN (
+            SELECT id FROM mail_messages WHERE account_id IN (
+                SELECT id FROM user_email_accounts
+                WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
+            )
+        )
+    );
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON mail_rule_executions TO cloudity_app;
+GRANT USAGE, SELECT ON SEQUENCE mail_rule_executions_id_seq TO cloudity_app;
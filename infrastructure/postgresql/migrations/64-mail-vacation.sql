@@ -0,0 +1,60 @@
+-- Migration 64 — Répondeur d'absence par boîte mail (mail-directory-service, mail_vacation.go).
+--
+-- * mail_vacation_settings : période (start_at / end_at, bornes optionnelles), objet, texte et
+--   intervalle minimal entre deux réponses au même expéditeur. last_message_id : curseur des
+--   messages déjà examinés après synchronisation (repositionné à chaque enregistrement, le
+--   courrier antérieur ne reçoit jamais de réponse).
+-- * mail_vacation_replies : dernière réponse envoyée par expéditeur (RFC 3834 §2 : pas plus
+--   d'une réponse par intervalle), partagée entre la sync IMAP et la livraison alias-router.
+
+CREATE TABLE IF NOT EXISTS mail_vacation_settings (
+    account_id INTEGER PRIMARY KEY REFERENCES user_email_accounts(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL DEFAULT false,
+    start_at TIMESTAMPTZ,
+    end_at TIMESTAMPTZ,
+    subject VARCHAR(500) NOT NULL DEFAULT '',
+    body TEXT NOT NULL DEFAULT '',
+    reply_interval_days INTEGER NOT NULL DEFAULT 7 CHECK (reply_interval_days BETWEEN 1 AND 30),
+    last_message_id INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS mail_vacation_replies (
+    account_id INTEGER NOT NULL REFERENCES user_email_accounts(id) ON DELETE CASCADE,
+    sender VARCHAR(320) NOT NULL,
+    replied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (account_id, sender)
+);
+
+ALTER TABLE mail_vacation_settings ENABLE ROW LEVEL SECURITY;
+ALTER TABLE mail_vacation_replies ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS mail_vacation_settings_via_account ON mail_vacation_settings;
+CREATE POLICY mail_vacation_settings_via_account ON mail_vacation_settings
+    FOR ALL USING (
+        account_id IN (
+            SELECT id FROM user_email_accounts
+            WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
+        )
+    );
+
+DROP POLICY IF EXISTS mail_vacation_replies_via_account ON mail_vacation_replies;
+CREATE POLICY mail_vacation_replies_via_account ON mail_vacation_replies
+    FOR ALL USING (
+        account_id IN (
+            SELECT id FROM user_email_accounts
+            WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
+        )
+    );
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_mail_vacation_settings_updated_at') THEN
+    CREATE TRIGGER update_mail_vacation_settings_updated_at BEFORE UPDATE ON mail_vacation_settings
+      FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+  END IF;
+END $$;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON mail_vacation_settings TO cloudity_app;
+GRANT SELECT, INSERT, UPDATE, DELETE ON mail_vacation_replies TO cloudity_app;
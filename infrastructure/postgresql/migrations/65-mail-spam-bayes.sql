@@ -0,0 +1,90 @@
+-- Migration 65 — Classifieur bayésien du spam (mail-directory-service, mail_spam_bayes.go).
+--
+-- * mail_spam_user_tokens / mail_spam_user_stats : modèle personnel, entraîné quand l'utilisateur
+--   déplace un message vers Spam (spam) ou hors de Spam (légitime).
+-- * mail_spam_tenant_tokens / mail_spam_tenant_stats : modèle de base du tenant, alimenté par
+--   l'admin (/mail/admin/spam/model) ; ajouté au modèle personnel au moment du score.
+-- * mail_spam_training : dernier libellé appris par message et ses jetons, pour désapprendre
+--   quand l'utilisateur corrige (Spam → réception) et ne jamais compter deux fois un message.
+
+CREATE TABLE IF NOT EXISTS mail_spam_user_tokens (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token VARCHAR(64) NOT NULL,
+    spam_count INTEGER NOT NULL DEFAULT 0,
+    ham_count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, token)
+);
+
+CREATE TABLE IF NOT EXISTS mail_spam_user_stats (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    spam_messages INTEGER NOT NULL DEFAULT 0,
+    ham_messages INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS mail_spam_tenant_tokens (
+    tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    token VARCHAR(64) NOT NULL,
+    spam_count INTEGER NOT NULL DEFAULT 0,
+    ham_count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (tenant_id, token)
+);
+
+CREATE TABLE IF NOT EXISTS mail_spam_tenant_stats (
+    tenant_id INTEGER PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+    spam_messages INTEGER NOT NULL DEFAULT 0,
+    ham_messages INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS mail_spam_training (
+    message_id INTEGER PRIMARY KEY REFERENCES mail_messages(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    is_spam BOOLEAN NOT NULL,
+    tokens TEXT[] NOT NULL DEFAULT '{}',
+    trained_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE mail_spam_user_tokens ENABLE ROW LEVEL SECURITY;
+ALTER TABLE mail_spam_user_stats ENABLE ROW LEVEL SECURITY;
+ALTER TABLE mail_spam_tenant_tokens ENABLE ROW LEVEL SECURITY;
+ALTER TABLE mail_spam_tenant_stats ENABLE ROW LEVEL SECURITY;
+ALTER TABLE mail_spam_training ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS mail_spam_user_tokens_owner ON mail_spam_user_tokens;
+CREATE POLICY mail_spam_user_tokens_owner ON mail_spam_user_tokens
+    FOR ALL USING (user_id = current_setting('app.current_user_id', true)::INTEGER);
+
+DROP POLICY IF EXISTS mail_spam_user_stats_owner ON mail_spam_user_stats;
+CREATE POLICY mail_spam_user_stats_owner ON mail_spam_user_stats
+    FOR ALL USING (user_id = current_setting('app.current_user_id', true)::INTEGER);
+
+DROP POLICY IF EXISTS mail_spam_training_owner ON mail_spam_training;
+CREATE POLICY mail_spam_training_owner ON mail_spam_training
+    FOR ALL USING (user_id = current_setting('app.current_user_id', true)::INTEGER);
+
+DROP POLICY IF EXISTS mail_spam_tenant_tokens_isolation ON mail_spam_tenant_tokens;
+CREATE POLICY mail_spam_tenant_tokens_isolation ON mail_spam_tenant_tokens
+    FOR ALL USING (tenant_id = current_setting('app.current_tenant', true)::INTEGER);
+
+DROP POLICY IF EXISTS mail_spam_tenant_stats_isolation ON mail_spam_tenant_stats;
+CREATE POLICY mail_spam_tenant_stats_isolation ON mail_spam_tenant_stats
+    FOR ALL USING (tenant_id = current_setting('app.current_tenant', true)::INTEGER);
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_mail_spam_user_stats_updated_at') THEN
+    CREATE TRIGGER update_mail_spam_user_stats_updated_at BEFORE UPDATE ON mail_spam_user_stats
+      FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+  END IF;
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_mail_spam_tenant_stats_updated_at') THEN
+    CREATE TRIGGER update_mail_spam_tenant_stats_updated_at BEFORE UPDATE ON mail_spam_tenant_stats
+      FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+  END IF;
+END $$;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON mail_spam_user_tokens TO cloudity_app;
+GRANT SELECT, INSERT, UPDATE, DELETE ON mail_spam_user_stats TO cloudity_app;
+GRANT SELECT, INSERT, UPDATE, DELETE ON mail_spam_tenant_tokens TO cloudity_app;
+GRANT SELECT, INSERT, UPDATE, DELETE ON mail_spam_tenant_stats TO cloudity_app;
+GRANT SELECT, INSERT, UPDATE, DELETE ON mail_spam_training TO cloudity_app;
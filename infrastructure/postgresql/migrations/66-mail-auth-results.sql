@@ -0,0 +1,11 @@
+-- Migration 66 — Authentification du courrier reçu (mail-directory-service, mail_auth.go).
+--
+-- Verdicts SPF / DKIM / DMARC calculés à l'analyse du message brut (ouverture, règles de tri) ;
+-- auth_details garde le détail exposé au client (domaines, signatures, Authentication-Results
+-- de confiance, niveau de confiance du badge). auth_checked_at NULL = pas encore vérifié.
+
+ALTER TABLE mail_messages ADD COLUMN IF NOT EXISTS auth_spf VARCHAR(16);
+ALTER TABLE mail_messages ADD COLUMN IF NOT EXISTS auth_dkim VARCHAR(16);
+ALTER TABLE mail_messages ADD COLUMN IF NOT EXISTS auth_dmarc VARCHAR(16);
+ALTER TABLE mail_messages ADD COLUMN IF NOT EXISTS auth_details JSONB;
+ALTER TABLE mail_messages ADD COLUMN IF NOT EXISTS auth_checked_at TIMESTAMPTZ;
@@ -0,0 +1,38 @@
+-- Migration 67 — Clés DKIM par domaine hébergé (mail-directory-service, mail_dkim.go).
+--
+-- Une paire RSA-2048 et une paire Ed25519 par domaine (double signature, RFC 8463 §4) ;
+-- le sélecteur publié est <dkim_selector>-rsa-AAAAMMJJ / <dkim_selector>-ed25519-AAAAMMJJ.
+-- La clé privée est chiffrée (AES-GCM, MAIL_PASSWORD_ENCRYPTION_KEY) ; public_key = valeur p=
+-- du TXT DNS. Une rotation remplace la ligne de l'algorithme avec un sélecteur daté du jour :
+-- l'ancien TXT peut rester publié le temps que les messages en transit soient vérifiés.
+
+CREATE TABLE IF NOT EXISTS mail_domain_dkim_keys (
+    id SERIAL PRIMARY KEY,
+    domain_id INTEGER NOT NULL REFERENCES mail_domains(id) ON DELETE CASCADE,
+    selector VARCHAR(128) NOT NULL,
+    algorithm VARCHAR(16) NOT NULL CHECK (algorithm IN ('rsa', 'ed25519')),
+    private_key_encrypted TEXT NOT NULL,
+    public_key TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (domain_id, algorithm)
+);
+
+ALTER TABLE mail_domain_dkim_keys ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS mail_domain_dkim_keys_via_domain ON mail_domain_dkim_keys;
+CREATE POLICY mail_domain_dkim_keys_via_domain ON mail_domain_dkim_keys
+    FOR ALL USING (
+        domain_id IN (SELECT id FROM mail_domains WHERE tenant_id = current_setting('app.current_tenant', true)::INTEGER)
+    );
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_mail_domain_dkim_keys_updated_at') THEN
+    CREATE TRIGGER update_mail_domain_dkim_keys_updated_at BEFORE UPDATE ON mail_domain_dkim_keys
+      FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+  END IF;
+END $$;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON mail_domain_dkim_keys TO cloudity_app;
+GRANT USAGE, SELECT ON SEQUENCE mail_domain_dkim_keys_id_seq TO cloudity_app;
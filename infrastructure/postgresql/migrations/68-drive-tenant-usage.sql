@@ -0,0 +1,23 @@
+-- Migration 68 — Consommation Drive/Photos du tenant pour les quotas (drive-service, storage_quota.go).
+--
+-- Sous un rôle soumis à RLS, SUM(drive_nodes.size) ne voit que les fichiers de l'appelant :
+-- le quota tenant n'était jamais atteint. Le total est calculé ici en SECURITY DEFINER, et
+-- uniquement pour le tenant de l'utilisateur courant (app.current_user_id) ; 0 sinon.
+CREATE OR REPLACE FUNCTION drive_tenant_usage_bytes(p_tenant_id INTEGER)
+RETURNS BIGINT
+LANGUAGE sql STABLE SECURITY DEFINER
+SET search_path = public
+AS $$
+  SELECT COALESCE(SUM(n.size), 0)::BIGINT
+  FROM drive_nodes n
+  WHERE n.tenant_id = p_tenant_id
+    AND n.is_folder = false
+    AND EXISTS (
+      SELECT 1 FROM users u
+      WHERE u.id = current_setting('app.current_user_id', true)::INTEGER
+        AND u.tenant_id = p_tenant_id
+    );
+$$;
+
+REVOKE ALL ON FUNCTION drive_tenant_usage_bytes(INTEGER) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION drive_tenant_usage_bytes(INTEGER) TO cloudity_app;
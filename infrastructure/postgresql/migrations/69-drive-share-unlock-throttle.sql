@@ -0,0 +1,6 @@
+-- Migration 69 — Limite des essais de mot de passe des liens publics (drive-service, share_links.go).
+--
+-- POST /drive/public/shares/:token/unlock est anonyme : après 10 échecs
+-- consécutifs, le lien refuse tout essai (429) jusqu'à unlock_blocked_until, sans calcul Argon2id.
+ALTER TABLE drive_share_links ADD COLUMN IF NOT EXISTS unlock_failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE drive_share_links ADD COLUMN IF NOT EXISTS unlock_blocked_until TIMESTAMPTZ DEFAULT NULL;
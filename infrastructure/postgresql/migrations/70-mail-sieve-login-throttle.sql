@@ -0,0 +1,7 @@
+-- Migration 70 — Limite des essais de mot de passe ManageSieve (mail-directory-service, managesieve.go).
+--
+-- AUTHENTICATE est ouvert sur MANAGESIEVE_ADDR : après 10 échecs consécutifs sur une boîte,
+-- toute tentative est refusée jusqu'à sieve_blocked_until, quel que soit le nombre de connexions
+-- parallèles utilisées.
+ALTER TABLE mail_mailboxes ADD COLUMN IF NOT EXISTS sieve_failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE mail_mailboxes ADD COLUMN IF NOT EXISTS sieve_blocked_until TIMESTAMPTZ DEFAULT NULL;
@@ -0,0 +1,83 @@
+-- Migration 71 — Policies de partage interne Drive : coût et colonnes protégées (drive-service, drive_acl.go).
+--
+-- 49-drive-acl-shares.sql évaluait drive_node_access (parcours récursif des ancêtres) pour
+-- chaque ligne lue, y compris les nœuds de l'appelant : l'index user_id ne servait plus.
+-- Les policies partagées ne s'appliquent désormais qu'aux nœuds d'autrui, et seulement si
+-- l'appelant a reçu au moins un partage (sous-requête non corrélée, évaluée une fois).
+--
+-- Un éditeur pouvait aussi réécrire user_id, tenant_id ou photo_locked_at d'un nœud partagé,
+-- ou le sortir de l'arborescence du propriétaire. WITH CHECK ne voit pas l'ancienne ligne :
+-- un trigger BEFORE UPDATE fige ces colonnes pour tout autre utilisateur que le propriétaire.
+
+DROP POLICY IF EXISTS drive_nodes_shared_read ON drive_nodes;
+CREATE POLICY drive_nodes_shared_read ON drive_nodes
+    FOR SELECT USING (
+      user_id <> current_setting('app.current_user_id', true)::INTEGER
+      AND EXISTS (
+        SELECT 1 FROM drive_node_shares
+        WHERE grantee_user_id = current_setting('app.current_user_id', true)::INTEGER
+           OR grantee_group_id IN (
+             SELECT group_id FROM user_group_members
+             WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
+           )
+      )
+      AND drive_node_access(id, current_setting('app.current_user_id', true)::INTEGER) >= 1
+    );
+
+DROP POLICY IF EXISTS drive_nodes_shared_update ON drive_nodes;
+CREATE POLICY drive_nodes_shared_update ON drive_nodes
+    FOR UPDATE USING (
+      user_id <> current_setting('app.current_user_id', true)::INTEGER
+      AND EXISTS (
+        SELECT 1 FROM drive_node_shares
+        WHERE grantee_user_id = current_setting('app.current_user_id', true)::INTEGER
+           OR grantee_group_id IN (
+             SELECT group_id FROM user_group_members
+             WHERE user_id = current_setting('app.current_user_id', true)::INTEGER
+           )
+      )
+      AND drive_node_access(id, current_setting('app.current_user_id', true)::INTEGER) >= 3
+    )
+    WITH CHECK (
+      drive_node_access(id, current_setting('app.current_user_id', true)::INTEGER) >= 3
+    );
+
+-- Propriétaire ou traitement système (aucun utilisateur épinglé) : pas de restriction.
+-- Éditeur d'un partage : propriétaire, tenant et verrouillage figés ; déplacement seulement
+-- vers un dossier du même propriétaire sur lequel il est lui-même éditeur.
+CREATE OR REPLACE FUNCTION drive_nodes_guard_shared_update()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+SET search_path = public
+AS $$
+DECLARE
+  v_uid INTEGER := NULLIF(current_setting('app.current_user_id', true), '')::INTEGER;
+BEGIN
+  IF v_uid IS NULL OR v_uid = OLD.user_id THEN
+    RETURN NEW;
+  END IF;
+  IF NEW.user_id IS DISTINCT FROM OLD.user_id
+     OR NEW.tenant_id IS DISTINCT FROM OLD.tenant_id
+     OR NEW.photo_locked_at IS DISTINCT FROM OLD.photo_locked_at THEN
+    RAISE EXCEPTION 'drive_nodes: owner, tenant and locked state are reserved to the owner'
+      USING ERRCODE = '42501';
+  END IF;
+  IF NEW.parent_id IS DISTINCT FROM OLD.parent_id AND (
+       NEW.parent_id IS NULL
+       OR NOT EXISTS (SELECT 1 FROM drive_nodes p WHERE p.id = NEW.parent_id AND p.user_id = OLD.user_id)
+       OR drive_node_access(NEW.parent_id, v_uid) < 3
+     ) THEN
+    RAISE EXCEPTION 'drive_nodes: shared node cannot leave its owner''s tree'
+      USING ERRCODE = '42501';
+  END IF;
+  RETURN NEW;
+END;
+$$;
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'drive_nodes_shared_update_guard') THEN
+    CREATE TRIGGER drive_nodes_shared_update_guard BEFORE UPDATE ON drive_nodes
+      FOR EACH ROW EXECUTE FUNCTION drive_nodes_guard_shared_update();
+  END IF;
+END $$;
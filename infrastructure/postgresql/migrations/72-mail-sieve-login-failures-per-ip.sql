@@ -0,0 +1,32 @@
+-- Migration 72 — Blocage ManageSieve par boîte ET adresse IP (mail-directory-service, managesieve.go).
+--
+-- 70-mail-sieve-login-throttle.sql comptait les échecs par boîte seule : n'importe qui pouvait
+-- bloquer une boîte pour son titulaire avec 10 mauvais mots de passe. Le compteur est désormais
+-- tenu par (boîte, IP cliente) ; les lignes d'autres IP inactives depuis un jour sont purgées
+-- lors des échecs suivants.
+
+CREATE TABLE IF NOT EXISTS mail_sieve_login_failures (
+    mailbox_id INTEGER NOT NULL REFERENCES mail_mailboxes(id) ON DELETE CASCADE,
+    client_ip VARCHAR(64) NOT NULL,
+    failed_attempts INTEGER NOT NULL DEFAULT 0,
+    blocked_until TIMESTAMPTZ DEFAULT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (mailbox_id, client_ip)
+);
+
+ALTER TABLE mail_sieve_login_failures ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS mail_sieve_login_failures_via_mailbox ON mail_sieve_login_failures;
+CREATE POLICY mail_sieve_login_failures_via_mailbox ON mail_sieve_login_failures
+    FOR ALL USING (
+        mailbox_id IN (
+            SELECT m.id FROM mail_mailboxes m
+            JOIN mail_domains d ON d.id = m.domain_id
+            WHERE d.tenant_id = current_setting('app.current_tenant', true)::INTEGER
+        )
+    );
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON mail_sieve_login_failures TO cloudity_app;
+
+ALTER TABLE mail_mailboxes DROP COLUMN IF EXISTS sieve_failed_attempts;
+ALTER TABLE mail_mailboxes DROP COLUMN IF EXISTS sieve_blocked_until;
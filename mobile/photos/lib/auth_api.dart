@@ -116,9 +116,15 @@ class AuthApi {
   Future<Map<String, dynamic>> fetchTimelinePage({
     required String accessToken,
     required int limit,
-    required int offset,
+    String? cursor,
   }) async {
-    final uri = Uri.parse('$_base/photos/timeline?limit=$limit&offset=$offset');
+    // Pagination par curseur : next_cursor de la page précédente, absent pour la première.
+    final uri = Uri.parse('$_base/photos/timeline').replace(
+      queryParameters: {
+        'limit': '$limit',
+        if (cursor != null && cursor.isNotEmpty) 'cursor': cursor,
+      },
+    );
     final res = await http.get(
       uri,
       headers: authHeaders(accessToken, json: false),
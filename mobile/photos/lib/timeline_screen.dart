@@ -93,7 +93,7 @@ class _TimelineScreenState extends State<TimelineScreen>
     with WidgetsBindingObserver {
   final List<Map<String, dynamic>> _items = [];
   Timer? _refreshTimer;
-  int _offset = 0;
+  String? _cursor;
   bool _hasMore = true;
   bool _loading = false;
   bool _loadingMore = false;
@@ -248,7 +248,7 @@ class _TimelineScreenState extends State<TimelineScreen>
       if (!silent || _items.isEmpty) {
         _loading = true;
       }
-      _offset = 0;
+      _cursor = null;
       _hasMore = true;
     });
     await _fetchPage(
@@ -267,7 +267,7 @@ class _TimelineScreenState extends State<TimelineScreen>
       final data = await widget.session.api.fetchTimelinePage(
         accessToken: widget.session.accessToken,
         limit: _pageSize,
-        offset: reset ? 0 : _offset,
+        cursor: reset ? null : _cursor,
       );
       final itemsVal = data['items'];
       final raw = itemsVal is List
@@ -286,11 +286,10 @@ class _TimelineScreenState extends State<TimelineScreen>
               ..clear()
               ..addAll(photos);
           }
-          _offset = raw.length;
         } else {
           _items.addAll(photos);
-          _offset += raw.length;
         }
+        _cursor = data['next_cursor'] as String?;
         _hasMore = more;
         _error = null;
       });
@@ -301,7 +300,7 @@ class _TimelineScreenState extends State<TimelineScreen>
           final data = await widget.session.api.fetchTimelinePage(
             accessToken: widget.session.accessToken,
             limit: _pageSize,
-            offset: reset ? 0 : _offset,
+            cursor: reset ? null : _cursor,
           );
           final itemsVal = data['items'];
           final raw = itemsVal is List
@@ -319,11 +318,10 @@ class _TimelineScreenState extends State<TimelineScreen>
                   ..clear()
                   ..addAll(photos);
               }
-              _offset = raw.length;
             } else {
               _items.addAll(photos);
-              _offset += raw.length;
             }
+            _cursor = data['next_cursor'] as String?;
             _hasMore = data['has_more'] == true;
           });
           return;